@@ -0,0 +1,598 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"oss.nandlabs.io/golly/codec/validator"
+	"oss.nandlabs.io/golly/config"
+)
+
+const (
+	// ConfigFormatYAML is the YAML format of the config commands
+	ConfigFormatYAML = "yaml"
+	// ConfigFormatJSON is the JSON format of the config commands
+	ConfigFormatJSON = "json"
+	// UsageTag is the struct tag of the description of a configuration field, written as a comment of the template
+	UsageTag = "usage"
+	// SecretTag is the struct tag marking the configuration fields holding secrets. i.e. `secret:"true"`
+	SecretTag = "secret"
+	// RedactedValue replaces the values of the secrets shown
+	RedactedValue = "[REDACTED]"
+	// ConfigOriginDefault is the origin of the values of the schema
+	ConfigOriginDefault = "default"
+
+	defaultConfigFileFlag   = "file"
+	defaultConfigOutputFlag = "output"
+)
+
+// ErrUnsupportedConfigFormat is returned when the output format is neither yaml nor json
+var ErrUnsupportedConfigFormat = errors.New("unsupported configuration format")
+
+// ErrConfigFileMissing is returned when the configuration file flag is not set
+var ErrConfigFileMissing = errors.New("configuration file is missing")
+
+// configReference matches a string value that is a reference to an environment variable, such as ${DB_PASSWORD}
+var configReference = regexp.MustCompile(`^\$\{(\w+)}$`)
+
+// ConfigCmdOptions configures the config commands
+type ConfigCmdOptions struct {
+	// FileFlag is the name of the flag of the configuration file. Defaults to "file".
+	FileFlag string
+	// OutputFlag is the name of the flag of the format, yaml or json. Defaults to "output", the format of the file
+	// extension or yaml applies if the flag is not set.
+	OutputFlag string
+	// EnvPrefix enables the environment overrides of the loaded configuration. With the prefix APP, the variable
+	// APP_SERVER_PORT overrides the key server.port.
+	EnvPrefix string
+	// Writer of the output of the commands. The writer of the App, or os.Stdout, is used if nil.
+	Writer io.Writer
+}
+
+// ConfigViolation is a value of a configuration that does not fit the schema
+type ConfigViolation struct {
+	// Path is the dot separated path of the key
+	Path string `json:"path"`
+	// Message describes the violation
+	Message string `json:"message"`
+}
+
+// ConfigValidationError is returned by the validate command when the configuration has violations
+type ConfigValidationError struct {
+	// File is the configuration file
+	File string
+	// Violations of the configuration
+	Violations []ConfigViolation
+}
+
+// Error returns the number of violations of the file
+func (e *ConfigValidationError) Error() string {
+	return fmt.Sprintf("%s: %d violations", e.File, len(e.Violations))
+}
+
+// configCommands are the config commands of a schema
+type configCommands struct {
+	schema  reflect.Value
+	options ConfigCmdOptions
+}
+
+// NewConfigCommands creates the "config" command of the schema, a struct or a pointer to a struct whose values are the
+// defaults of the configuration. The keys of the configuration are the yaml names of the fields, the UsageTag
+// describes a field and the fields tagged with SecretTag are never written. Its subcommands are
+//   - init writing a commented template of the configuration to the file, or to the writer if the file flag is not
+//     set. The secrets are written as references to environment variables, e.g. ${APP_DB_PASSWORD}.
+//   - validate loading the file with its includes and environment overrides, and listing every key that is unknown,
+//     cannot be decoded or violates the constraints tag of its field. A *ConfigValidationError is returned if any.
+//   - show writing the effective configuration with the origin of every value and the secrets redacted.
+//
+// The flags are read from the context, so they must be added to the flags of the App too.
+func NewConfigCommands(schema any, opts ConfigCmdOptions) *Command {
+	if opts.FileFlag == "" {
+		opts.FileFlag = defaultConfigFileFlag
+	}
+	if opts.OutputFlag == "" {
+		opts.OutputFlag = defaultConfigOutputFlag
+	}
+	c := &configCommands{schema: reflect.Indirect(reflect.ValueOf(schema)), options: opts}
+	return &Command{
+		Name:  "config",
+		Usage: "manage the configuration file",
+		Flags: []*Flag{
+			{Name: opts.FileFlag, Aliases: []string{opts.FileFlag}, Default: "", Usage: "configuration file"},
+			{Name: opts.OutputFlag, Aliases: []string{opts.OutputFlag}, Default: "", Usage: "format, yaml or json"},
+		},
+		Commands: []*Command{
+			{Name: "init", Usage: "write a configuration template", Action: c.init},
+			{Name: "validate", Usage: "validate the configuration file", Action: c.validate},
+			{Name: "show", Usage: "show the effective configuration", Action: c.show},
+		},
+	}
+}
+
+// init writes the template of the configuration
+func (c *configCommands) init(conTxt *Context) (err error) {
+	file := flagString(conTxt, c.options.FileFlag)
+	var format string
+	if format, err = c.format(conTxt, file); err != nil {
+		return
+	}
+	var node *yaml.Node
+	node, err = c.node(c.schema, "", func(path string, field reflect.StructField, fv reflect.Value,
+		value *yaml.Node) *yaml.Node {
+		if field.Tag.Get(SecretTag) == "true" {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "${" + c.envName(path) + "}"}
+		}
+		return value
+	})
+	if err != nil {
+		return
+	}
+	if file == "" {
+		return writeConfig(c.writer(conTxt), node, format)
+	}
+	var f *os.File
+	if f, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644); err != nil {
+		return
+	}
+	err = writeConfig(f, node, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// validate lists the violations of the configuration file
+func (c *configCommands) validate(conTxt *Context) (err error) {
+	file := flagString(conTxt, c.options.FileFlag)
+	var format string
+	if format, err = c.format(conTxt, ""); err != nil {
+		return
+	}
+	var loaded *loadedConfig
+	if loaded, err = c.load(file); err != nil {
+		return
+	}
+	w := c.writer(conTxt)
+	if format == ConfigFormatJSON {
+		err = writeJSON(w, map[string]any{
+			"file":       file,
+			"valid":      len(loaded.violations) == 0,
+			"violations": append([]ConfigViolation{}, loaded.violations...),
+		})
+	} else if len(loaded.violations) == 0 {
+		_, err = fmt.Fprintf(w, "%s: valid\n", file)
+	} else {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s: %d violations\n", file, len(loaded.violations))
+		for _, v := range loaded.violations {
+			fmt.Fprintf(&sb, "  %s: %s\n", v.Path, v.Message)
+		}
+		_, err = io.WriteString(w, sb.String())
+	}
+	if err == nil && len(loaded.violations) > 0 {
+		err = &ConfigValidationError{File: file, Violations: loaded.violations}
+	}
+	return
+}
+
+// show writes the effective configuration with the origins of the values
+func (c *configCommands) show(conTxt *Context) (err error) {
+	file := flagString(conTxt, c.options.FileFlag)
+	var format string
+	if format, err = c.format(conTxt, ""); err != nil {
+		return
+	}
+	var loaded *loadedConfig
+	if loaded, err = c.load(file); err != nil {
+		return
+	}
+	var node *yaml.Node
+	node, err = c.node(loaded.value, "", func(path string, field reflect.StructField, fv reflect.Value,
+		value *yaml.Node) *yaml.Node {
+		if field.Tag.Get(SecretTag) == "true" && !fv.IsZero() {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: RedactedValue}
+		}
+		origin := loaded.origin(path)
+		if format == ConfigFormatJSON {
+			return &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "value"}, value,
+				{Kind: yaml.ScalarNode, Value: "origin"}, {Kind: yaml.ScalarNode, Tag: "!!str", Value: origin},
+			}}
+		}
+		value.LineComment = origin
+		return value
+	})
+	if err == nil {
+		err = writeConfig(c.writer(conTxt), node, format)
+	}
+	return
+}
+
+// loadedConfig is a configuration file decoded into a copy of the schema
+type loadedConfig struct {
+	value      reflect.Value
+	doc        *config.Document
+	dir        string
+	env        map[string]string
+	violations []ConfigViolation
+}
+
+// origin returns the environment variable or the file relative to the configuration file that supplied the value
+// of the key, ConfigOriginDefault if none did
+func (l *loadedConfig) origin(path string) string {
+	if name, ok := l.env[path]; ok {
+		return "env:" + name
+	}
+	origin := l.doc.Origin(path)
+	if origin == "" {
+		return ConfigOriginDefault
+	}
+	if rel, err := filepath.Rel(l.dir, origin); err == nil {
+		origin = rel
+	}
+	return origin
+}
+
+// load resolves the includes of the file, applies the environment overrides and the references, and decodes the
+// values into a copy of the schema whose violations are collected
+func (c *configCommands) load(file string) (loaded *loadedConfig, err error) {
+	if file == "" {
+		err = ErrConfigFileMissing
+		return
+	}
+	var doc *config.Document
+	if doc, err = config.ResolveIncludes(file); err != nil {
+		return
+	}
+	var abs string
+	if abs, err = filepath.Abs(file); err != nil {
+		return
+	}
+	loaded = &loadedConfig{doc: doc, dir: filepath.Dir(abs), env: make(map[string]string)}
+	values := doc.Values()
+	if c.options.EnvPrefix != "" {
+		c.overrides(c.schema.Type(), values, "", loaded.env)
+	}
+	resolveReferences(values)
+	loaded.value = reflect.New(c.schema.Type()).Elem()
+	loaded.value.Set(c.schema)
+	loaded.violations = decodeConfig(values, loaded.value, "")
+	loaded.violations = append(loaded.violations, validateConfig(loaded.value, "")...)
+	return
+}
+
+// overrides sets the values of the keys whose environment variable is set
+func (c *configCommands) overrides(t reflect.Type, values map[string]any, prefix string, env map[string]string) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, ok := configKey(sf)
+		if !ok {
+			continue
+		}
+		path := prefix + key
+		if st, isStruct := configStructType(sf.Type); isStruct {
+			child, _ := values[key].(map[string]any)
+			if child == nil {
+				child = make(map[string]any)
+			}
+			c.overrides(st, child, path+".", env)
+			if len(child) > 0 {
+				values[key] = child
+			}
+			continue
+		}
+		name := c.envName(path)
+		if s, set := os.LookupEnv(name); set {
+			var v any = s
+			// the value is typed as it would be in the file
+			_ = yaml.Unmarshal([]byte(s), &v)
+			values[key] = v
+			env[path] = name
+		}
+	}
+}
+
+// envName returns the environment variable of the key
+func (c *configCommands) envName(path string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+	if c.options.EnvPrefix != "" {
+		name = c.options.EnvPrefix + "_" + name
+	}
+	return name
+}
+
+// node returns the mapping node of the struct value, the leaf function may replace the node of every value
+func (c *configCommands) node(v reflect.Value, prefix string,
+	leaf func(path string, field reflect.StructField, fv reflect.Value, value *yaml.Node) *yaml.Node) (
+	node *yaml.Node, err error) {
+	node = &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+	for i := 0; i < t.NumField() && err == nil; i++ {
+		sf := t.Field(i)
+		key, ok := configKey(sf)
+		if !ok {
+			continue
+		}
+		path := prefix + key
+		fv := v.Field(i)
+		var value *yaml.Node
+		if _, isStruct := configStructType(sf.Type); isStruct {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv = reflect.New(sf.Type.Elem())
+				}
+				fv = fv.Elem()
+			}
+			value, err = c.node(fv, path+".", leaf)
+		} else {
+			value = &yaml.Node{}
+			if d, isDuration := fv.Interface().(time.Duration); isDuration {
+				err = value.Encode(d.String())
+			} else {
+				err = value.Encode(fv.Interface())
+			}
+			if err == nil {
+				value = leaf(path, sf, fv, value)
+			}
+		}
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: key, HeadComment: sf.Tag.Get(UsageTag)}
+		node.Content = append(node.Content, keyNode, value)
+	}
+	return
+}
+
+// format returns the format of the output flag, of the extension of the file if the flag is not set
+func (c *configCommands) format(conTxt *Context, file string) (format string, err error) {
+	format = strings.ToLower(flagString(conTxt, c.options.OutputFlag))
+	if format == "" {
+		format = ConfigFormatYAML
+		if strings.EqualFold(filepath.Ext(file), ".json") {
+			format = ConfigFormatJSON
+		}
+	}
+	if format != ConfigFormatYAML && format != ConfigFormatJSON {
+		err = fmt.Errorf("%w: %s", ErrUnsupportedConfigFormat, format)
+	}
+	return
+}
+
+// writer returns the writer of the output
+func (c *configCommands) writer(conTxt *Context) io.Writer {
+	if c.options.Writer != nil {
+		return c.options.Writer
+	}
+	if conTxt.App != nil && conTxt.App.Writer != nil {
+		return conTxt.App.writer()
+	}
+	return os.Stdout
+}
+
+// decodeConfig decodes the values into the struct value, the keys that are not fields of the struct and the values
+// that cannot be decoded are returned as violations
+func decodeConfig(values map[string]any, v reflect.Value, prefix string) (violations []ConfigViolation) {
+	t := v.Type()
+	known := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, ok := configKey(sf)
+		if !ok {
+			continue
+		}
+		known[key] = true
+		value, present := values[key]
+		if !present {
+			continue
+		}
+		path := prefix + key
+		fv := v.Field(i)
+		if _, isStruct := configStructType(sf.Type); isStruct {
+			child, isMap := value.(map[string]any)
+			if !isMap {
+				violations = append(violations, ConfigViolation{Path: path, Message: "expected a mapping"})
+				continue
+			}
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv.Set(reflect.New(sf.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			violations = append(violations, decodeConfig(child, fv, path+".")...)
+			continue
+		}
+		data, err := yaml.Marshal(value)
+		if err == nil {
+			decoded := reflect.New(sf.Type)
+			if err = yaml.Unmarshal(data, decoded.Interface()); err == nil {
+				fv.Set(decoded.Elem())
+			}
+		}
+		if err != nil {
+			violations = append(violations, ConfigViolation{Path: path, Message: decodeMessage(err)})
+		}
+	}
+	for _, key := range sortedKeys(values) {
+		if !known[key] {
+			violations = append(violations, ConfigViolation{Path: prefix + key, Message: "unknown key"})
+		}
+	}
+	return
+}
+
+// validateConfig validates the constraints of the fields of the struct value and of its nested structs
+func validateConfig(v reflect.Value, prefix string) (violations []ConfigViolation) {
+	errs := validator.NewStructValidator().ValidateAll(v.Interface())
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key, ok := configKey(sf)
+		if !ok {
+			continue
+		}
+		if err := errs[sf.Name]; err != nil {
+			violations = append(violations, ConfigViolation{Path: prefix + key, Message: err.Error()})
+		}
+		if _, isStruct := configStructType(sf.Type); isStruct {
+			fv := reflect.Indirect(v.Field(i))
+			if fv.IsValid() {
+				violations = append(violations, validateConfig(fv, prefix+key+".")...)
+			}
+		}
+	}
+	return
+}
+
+// resolveReferences replaces the string values referencing an environment variable with its value
+func resolveReferences(values map[string]any) {
+	for k, v := range values {
+		switch value := v.(type) {
+		case map[string]any:
+			resolveReferences(value)
+		case string:
+			if m := configReference.FindStringSubmatch(value); m != nil {
+				values[k] = os.Getenv(m[1])
+			}
+		}
+	}
+}
+
+// configKey returns the key of the field, the name of its yaml tag or its lower cased name. False is returned for
+// the fields that are not part of the configuration.
+func configKey(sf reflect.StructField) (key string, ok bool) {
+	if !sf.IsExported() {
+		return
+	}
+	key, _, _ = strings.Cut(sf.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return "", false
+	}
+	if key == "" {
+		key = strings.ToLower(sf.Name)
+	}
+	return key, true
+}
+
+// configStructType returns the struct type of a field holding a struct or a pointer to one, except time.Time
+func configStructType(t reflect.Type) (st reflect.Type, ok bool) {
+	st = t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	ok = st.Kind() == reflect.Struct && st != reflect.TypeOf(time.Time{})
+	return
+}
+
+// decodeMessage removes the line of the decoded value from the message of the error
+func decodeMessage(err error) string {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		_, msg, found := strings.Cut(typeErr.Errors[0], ": ")
+		if found {
+			return msg
+		}
+		return typeErr.Errors[0]
+	}
+	return err.Error()
+}
+
+// writeConfig writes the mapping node in the format
+func writeConfig(w io.Writer, node *yaml.Node, format string) (err error) {
+	if format == ConfigFormatJSON {
+		return writeJSON(w, jsonNode(node))
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err = enc.Encode(node); err == nil {
+		err = enc.Close()
+	}
+	return
+}
+
+func writeJSON(w io.Writer, v any) (err error) {
+	var data []byte
+	if data, err = json.MarshalIndent(v, "", "  "); err == nil {
+		_, err = w.Write(append(data, '\n'))
+	}
+	return
+}
+
+// jsonNode returns the value of the node, the mappings keep the order of their keys
+func jsonNode(node *yaml.Node) any {
+	switch node.Kind {
+	case yaml.MappingNode:
+		m := make(orderedMap, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			m = append(m, orderedEntry{key: node.Content[i].Value, value: jsonNode(node.Content[i+1])})
+		}
+		return m
+	case yaml.SequenceNode:
+		s := make([]any, 0, len(node.Content))
+		for _, child := range node.Content {
+			s = append(s, jsonNode(child))
+		}
+		return s
+	default:
+		var v any
+		_ = node.Decode(&v)
+		return v
+	}
+}
+
+type orderedEntry struct {
+	key   string
+	value any
+}
+
+// orderedMap is a JSON object whose keys are written in order
+type orderedMap []orderedEntry
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, e := range m {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		key, err := json.Marshal(e.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		sb.Write(key)
+		sb.WriteString(":")
+		sb.Write(value)
+	}
+	sb.WriteString("}")
+	return []byte(sb.String()), nil
+}
+
+// flagString returns the value of the flag, empty if it is not set
+func flagString(conTxt *Context, name string) string {
+	value := conTxt.GetFlag(name)
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+func sortedKeys(values map[string]any) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testServerConfig struct {
+	Host    string        `yaml:"host" usage:"address the server listens on" constraints:"notnull=true"`
+	Port    int           `yaml:"port" usage:"port the server listens on" constraints:"min=1;max=65535"`
+	Timeout time.Duration `yaml:"timeout" usage:"timeout of the requests"`
+}
+
+type testDBConfig struct {
+	Url      string `yaml:"url" constraints:"pattern=^postgres://"`
+	Password string `yaml:"password" usage:"password of the database user" secret:"true"`
+}
+
+type testConfig struct {
+	Name   string           `yaml:"name" usage:"name of the service" constraints:"notnull=true"`
+	Mode   string           `yaml:"mode" usage:"dev or prod" constraints:"enum=dev,prod"`
+	Server testServerConfig `yaml:"server" usage:"http server"`
+	DB     *testDBConfig    `yaml:"db"`
+	Tags   []string         `yaml:"tags"`
+	Debug  bool
+	cache  string
+}
+
+func testConfigSchema() *testConfig {
+	return &testConfig{
+		Name:   "orders",
+		Mode:   "dev",
+		Server: testServerConfig{Host: "0.0.0.0", Port: 8080, Timeout: 30 * time.Second},
+		DB:     &testDBConfig{Url: "postgres://localhost/orders"},
+		Tags:   []string{"api"},
+	}
+}
+
+// runConfigCommand runs the config subcommand with the flags and returns its output
+func runConfigCommand(t *testing.T, opts ConfigCmdOptions, name string, flags map[string]string) (string, error) {
+	var out bytes.Buffer
+	opts.Writer = &out
+	cmd := NewConfigCommands(testConfigSchema(), opts)
+	for _, f := range cmd.Flags {
+		mappedFlags[f.Name] = flags[f.Name]
+		defer delete(mappedFlags, f.Name)
+	}
+	for _, sub := range cmd.Commands {
+		if sub.Name == name {
+			err := sub.Action(NewContext(&App{Name: "orders"}, nil))
+			return out.String(), err
+		}
+	}
+	t.Fatalf("no %s command", name)
+	return "", nil
+}
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigCommands_Init_Golden(t *testing.T) {
+	for _, format := range []string{ConfigFormatYAML, ConfigFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			got, err := runConfigCommand(t, ConfigCmdOptions{EnvPrefix: "ORDERS"}, "init",
+				map[string]string{"output": format})
+			if err != nil {
+				t.Fatalf("init error = %v", err)
+			}
+			golden := filepath.Join("testdata", "config_init."+format+".golden")
+			if *update {
+				if err = os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("init =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigCommands_RoundTrip(t *testing.T) {
+	for _, name := range []string{"orders.yaml", "orders.json"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), name)
+			if _, err := runConfigCommand(t, ConfigCmdOptions{}, "init", map[string]string{"file": file}); err != nil {
+				t.Fatalf("init error = %v", err)
+			}
+			out, err := runConfigCommand(t, ConfigCmdOptions{}, "validate", map[string]string{"file": file})
+			if err != nil || out != file+": valid\n" {
+				t.Errorf("validate = %q, %v for the template", out, err)
+			}
+			// the template is never overwritten
+			if _, err = runConfigCommand(t, ConfigCmdOptions{}, "init", map[string]string{"file": file}); err == nil {
+				t.Error("init overwrote the file")
+			}
+		})
+	}
+}
+
+func TestConfigCommands_Validate(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "server.yaml", "server:\n  port: 70000\n  timeout: soon\n")
+	file := writeConfigFile(t, dir, "orders.yaml", `name: ""
+mode: test
+$include: server.yaml
+db:
+  url: mysql://localhost
+  user: admin
+color: blue
+`)
+	out, err := runConfigCommand(t, ConfigCmdOptions{}, "validate", map[string]string{"file": file})
+	var vErr *ConfigValidationError
+	if !errors.As(err, &vErr) {
+		t.Fatalf("validate error = %v, want a ConfigValidationError", err)
+	}
+	want := []ConfigViolation{
+		{Path: "server.timeout", Message: "cannot unmarshal !!str `soon` into time.Duration"},
+		{Path: "db.user", Message: "unknown key"},
+		{Path: "color", Message: "unknown key"},
+		{Path: "name", Message: "notnull validation failed for field Name"},
+		{Path: "mode", Message: "enum validation failed for field Mode"},
+		{Path: "server.port", Message: "max value validation failed for field Port"},
+		{Path: "db.url", Message: "pattern validation failed for field Url"},
+	}
+	if !reflect.DeepEqual(vErr.Violations, want) {
+		t.Errorf("violations = %+v\nwant %+v", vErr.Violations, want)
+	}
+	if !strings.HasPrefix(out, file+": 7 violations\n  server.timeout: ") {
+		t.Errorf("validate =\n%s", out)
+	}
+
+	out, _ = runConfigCommand(t, ConfigCmdOptions{}, "validate", map[string]string{"file": file, "output": "json"})
+	if !strings.Contains(out, `"valid": false`) || !strings.Contains(out, `"path": "db.user"`) {
+		t.Errorf("validate =\n%s", out)
+	}
+	if _, err = runConfigCommand(t, ConfigCmdOptions{}, "validate", nil); !errors.Is(err, ErrConfigFileMissing) {
+		t.Errorf("validate error = %v without a file", err)
+	}
+	_, err = runConfigCommand(t, ConfigCmdOptions{}, "validate", map[string]string{"file": file, "output": "toml"})
+	if !errors.Is(err, ErrUnsupportedConfigFormat) {
+		t.Errorf("validate error = %v with the toml format", err)
+	}
+}
+
+func TestConfigCommands_Show(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "db.yaml", "db:\n  password: ${ORDERS_TEST_DB_PASSWORD}\n")
+	file := writeConfigFile(t, dir, "orders.yaml", "name: billing\n$include: db.yaml\n")
+	t.Setenv("ORDERS_TEST_DB_PASSWORD", "s3cret")
+	t.Setenv("ORDERS_SERVER_PORT", "9090")
+
+	out, err := runConfigCommand(t, ConfigCmdOptions{EnvPrefix: "ORDERS"}, "show", map[string]string{"file": file})
+	if err != nil {
+		t.Fatalf("show error = %v", err)
+	}
+	for _, line := range []string{
+		"name: billing # orders.yaml",
+		"port: 9090 # env:ORDERS_SERVER_PORT",
+		"host: 0.0.0.0 # default",
+		"password: '[REDACTED]' # db.yaml",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("show =\n%s\nwant the line %q", out, line)
+		}
+	}
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("show =\n%s\nthe secret is not redacted", out)
+	}
+
+	out, err = runConfigCommand(t, ConfigCmdOptions{EnvPrefix: "ORDERS"}, "show",
+		map[string]string{"file": file, "output": "json"})
+	if err != nil || strings.Contains(out, "s3cret") ||
+		!strings.Contains(out, `"port": {`+"\n"+`      "value": 9090,`+"\n"+`      "origin": "env:ORDERS_SERVER_PORT"`) {
+		t.Errorf("show = %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"oss.nandlabs.io/golly/semver"
+)
+
+// MaxHeaderLines is the number of lines of a file searched for the version by FileHeaderVersion
+const MaxHeaderLines = 20
+
+// ErrVersionMissing is returned by a VersionRequirement when no version is supplied
+var ErrVersionMissing = errors.New("version is missing")
+
+// VersionExtractor returns the version to check from the context of the command
+type VersionExtractor func(conTxt *Context) (string, error)
+
+// FlagVersion returns the version supplied with the flag
+func FlagVersion(name string) VersionExtractor {
+	return func(conTxt *Context) (string, error) {
+		value := conTxt.GetFlag(name)
+		if value == nil {
+			return "", nil
+		}
+		return strings.TrimSpace(fmt.Sprint(value)), nil
+	}
+}
+
+// EnvVersion returns the version supplied with the environment variable
+func EnvVersion(name string) VersionExtractor {
+	return func(conTxt *Context) (string, error) {
+		return strings.TrimSpace(os.Getenv(name)), nil
+	}
+}
+
+// FileHeaderVersion returns the version declared in the header of the file whose path is supplied with the flag. The
+// version is the value of the first line of the first MaxHeaderLines lines starting with the key followed by : or =,
+// optionally commented with # or //, such as "# version: 1.2.0".
+func FileHeaderVersion(pathFlag, key string) VersionExtractor {
+	return func(conTxt *Context) (version string, err error) {
+		path := FlagVersion(pathFlag)
+		var file string
+		if file, err = path(conTxt); err != nil || file == "" {
+			return
+		}
+		var f *os.File
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for i := 0; i < MaxHeaderLines && scanner.Scan(); i++ {
+			line := strings.TrimSpace(scanner.Text())
+			line = strings.TrimSpace(strings.TrimLeft(strings.TrimPrefix(line, "//"), "#"))
+			if !strings.HasPrefix(line, key) {
+				continue
+			}
+			line = strings.TrimSpace(line[len(key):])
+			if strings.HasPrefix(line, ":") || strings.HasPrefix(line, "=") {
+				version = strings.Trim(strings.TrimSpace(line[1:]), `"'`)
+				return
+			}
+		}
+		err = scanner.Err()
+		return
+	}
+}
+
+// VersionRequirement checks the version of an artifact used by a command, such as a plugin or a configuration file,
+// before the action of the command runs
+type VersionRequirement struct {
+	// Constraint the version must satisfy. The default constraint of the semver.CompatibilityPolicy applies if empty.
+	Constraint string
+	// Subject names the artifact in the messages
+	Subject string
+	// Extractor returns the version to check
+	Extractor VersionExtractor
+	// Available lists the versions of the artifact. The nearest acceptable one is suggested when the version is not
+	// accepted.
+	Available []string
+	// Current is the version of the program, the version of the App if empty
+	Current string
+}
+
+// RequireVersion creates a new VersionRequirement with the constraint. The version is extracted from the version
+// flag unless another extractor is set with From.
+func RequireVersion(constraint string) *VersionRequirement {
+	return &VersionRequirement{Constraint: constraint, Subject: "artifact", Extractor: FlagVersion("version")}
+}
+
+// From sets the extractor of the version
+func (vr *VersionRequirement) From(extractor VersionExtractor) *VersionRequirement {
+	vr.Extractor = extractor
+	return vr
+}
+
+// For sets the subject of the messages
+func (vr *VersionRequirement) For(subject string) *VersionRequirement {
+	vr.Subject = subject
+	return vr
+}
+
+// Suggest sets the available versions of the artifact
+func (vr *VersionRequirement) Suggest(versions ...string) *VersionRequirement {
+	vr.Available = versions
+	return vr
+}
+
+// Guard returns an action running the action once the version is accepted. It can be set as the Action of an App or
+// a Command.
+func (vr *VersionRequirement) Guard(action ActionFunc) ActionFunc {
+	return func(conTxt *Context) error {
+		if err := vr.Check(conTxt); err != nil {
+			return err
+		}
+		return action(conTxt)
+	}
+}
+
+// Check extracts the version and returns a *VersionError if the version is not accepted
+func (vr *VersionRequirement) Check(conTxt *Context) (err error) {
+	var version string
+	if version, err = vr.Extractor(conTxt); err != nil {
+		return fmt.Errorf("%s: %w", vr.Subject, err)
+	}
+	if version == "" {
+		return fmt.Errorf("%s %w", vr.Subject, ErrVersionMissing)
+	}
+	current, program := vr.Current, ""
+	if conTxt.App != nil {
+		program = conTxt.App.Name
+		if current == "" {
+			current = conTxt.App.Version
+		}
+	}
+	if current == "" {
+		current = "0.0.0"
+	}
+	var policy *semver.CompatibilityPolicy
+	if policy, err = semver.NewCompatibilityPolicy(current); err != nil {
+		return
+	}
+	if ok, explanation := policy.Accepts(version, vr.Constraint); !ok {
+		vErr := &VersionError{Subject: vr.Subject, Program: program, Current: current, Explanation: explanation}
+		vErr.Nearest, _ = policy.Nearest(version, explanation.Constraint, vr.Available)
+		err = vErr
+	}
+	return
+}
+
+// VersionError is returned by a VersionRequirement when the version is not accepted. Its message tells the reason and
+// the direction to move to.
+type VersionError struct {
+	// Subject names the artifact
+	Subject string
+	// Program is the name of the App
+	Program string
+	// Current is the version of the program
+	Current string
+	// Explanation of the decision
+	Explanation semver.Explanation
+	// Nearest is the nearest acceptable version among the available ones, empty if there is none
+	Nearest string
+}
+
+// Error renders the reason on the first line and the suggestion on the second
+func (e *VersionError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s is not supported", e.Subject, e.Explanation.Version)
+	if e.Program != "" {
+		fmt.Fprintf(&sb, " by %s %s", e.Program, e.Current)
+	}
+	fmt.Fprintf(&sb, ": %s", e.Explanation)
+	if e.Explanation.Reason == semver.ReasonInvalid {
+		return sb.String()
+	}
+	target := "a version satisfying " + e.Explanation.Constraint
+	if e.Nearest != "" {
+		target = e.Nearest
+	}
+	switch e.Explanation.Reason {
+	case semver.ReasonTooOld:
+		fmt.Fprintf(&sb, "\nupgrade the %s to %s", e.Subject, target)
+	case semver.ReasonTooNew:
+		fmt.Fprintf(&sb, "\ndowngrade the %s to %s, or upgrade %s", e.Subject, target, e.programName())
+	case semver.ReasonPreRelease:
+		if e.Nearest != "" {
+			fmt.Fprintf(&sb, "\nuse a release of the %s such as %s", e.Subject, e.Nearest)
+		} else {
+			fmt.Fprintf(&sb, "\nuse a release of the %s", e.Subject)
+		}
+	default:
+		if e.Nearest != "" {
+			fmt.Fprintf(&sb, "\nuse the %s %s", e.Subject, e.Nearest)
+		} else {
+			fmt.Fprintf(&sb, "\nuse a version of the %s satisfying %s", e.Subject, e.Explanation.Constraint)
+		}
+	}
+	return sb.String()
+}
+
+// programName returns the name of the program in the suggestions
+func (e *VersionError) programName() string {
+	if e.Program != "" {
+		return e.Program
+	}
+	return "the program"
+}
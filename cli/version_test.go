@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func versionContext(version string) *Context {
+	return NewContext(&App{Name: "mytool", Version: version}, nil)
+}
+
+func TestRequireVersion_Golden(t *testing.T) {
+	available := []string{"1.0.0", "1.2.0", "1.4.2", "1.5.0-rc.1", "2.0.0"}
+	tests := []struct {
+		name       string
+		current    string
+		constraint string
+		version    string
+	}{
+		{"too_old", "2.3.0", ">=1.2 <2", "1.1.0"},
+		{"too_new", "2.3.0", ">=1.2 <2", "2.1"},
+		{"too_new_default", "1.3.0", "", "v1.4.2"},
+		{"pre_release", "2.3.0", ">=1.2 <2", "1.5.0-rc.1"},
+		{"excluded", "2.3.0", ">=1.2 <2 !=1.4.2", "1.4.2"},
+		{"no_candidate", "2.3.0", ">=3", "2.0.0"},
+		{"invalid", "2.3.0", ">=1.2 <2", "latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PLUGIN_VERSION", tt.version)
+			req := RequireVersion(tt.constraint).From(EnvVersion("PLUGIN_VERSION")).For("plugin").Suggest(available...)
+			err := req.Check(versionContext(tt.current))
+			var vErr *VersionError
+			if !errors.As(err, &vErr) {
+				t.Fatalf("Check() error = %v, want a VersionError", err)
+			}
+			golden := filepath.Join("testdata", "version_"+tt.name+".golden")
+			if *update {
+				if err = os.WriteFile(golden, []byte(vErr.Error()+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := vErr.Error() + "\n"; got != string(want) {
+				t.Errorf("Error() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestRequireVersion_Guard(t *testing.T) {
+	ran := false
+	action := func(conTxt *Context) error {
+		ran = true
+		return nil
+	}
+	mappedFlags["plugin-version"] = "1.3"
+	defer delete(mappedFlags, "plugin-version")
+	guarded := RequireVersion("^1.2").From(FlagVersion("plugin-version")).Guard(action)
+	if err := guarded(versionContext("1.0.0")); err != nil || !ran {
+		t.Errorf("guarded action = %v, ran %v", err, ran)
+	}
+
+	ran = false
+	mappedFlags["plugin-version"] = "2.0.0"
+	if err := guarded(versionContext("1.0.0")); err == nil || ran {
+		t.Errorf("guarded action = %v, ran %v with a rejected version", err, ran)
+	}
+
+	missing := RequireVersion("^1.2").From(FlagVersion("unset")).For("plugin").Guard(action)
+	if err := missing(versionContext("1.0.0")); !errors.Is(err, ErrVersionMissing) {
+		t.Errorf("error = %v, want the missing version", err)
+	}
+}
+
+func TestFileHeaderVersion(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.yaml":  "# generated by mytool\n# config-version: \"1.4\"\nname: app\n",
+		"app.conf":  "// config-version = 2.0.0\n",
+		"none.yaml": "name: app\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer delete(mappedFlags, "config")
+	extractor := FileHeaderVersion("config", "config-version")
+	for name, want := range map[string]string{"app.yaml": "1.4", "app.conf": "2.0.0", "none.yaml": ""} {
+		mappedFlags["config"] = filepath.Join(dir, name)
+		if got, err := extractor(versionContext("2.0.0")); err != nil || got != want {
+			t.Errorf("version of %s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	// a configuration file written by a newer version is refused
+	mappedFlags["config"] = filepath.Join(dir, "app.conf")
+	err := RequireVersion("").From(extractor).For("config file").Check(versionContext("1.5.0"))
+	var vErr *VersionError
+	if !errors.As(err, &vErr) || vErr.Explanation.Reason != "too new" {
+		t.Errorf("error = %v, want the config file to be too new", err)
+	}
+}
@@ -0,0 +1,272 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcome of a call made with a permit of the AdaptiveLimiter.
+type Outcome int
+
+const (
+	// OutcomeSuccess is a call that completed. Its latency is checked against the latency threshold.
+	OutcomeSuccess Outcome = iota
+	// OutcomeTimeout is a call that timed out. It is a signal of upstream stress.
+	OutcomeTimeout
+	// OutcomeOverload is a call rejected by an overloaded upstream (e.g. HTTP 429 or 503). It is a signal of upstream stress.
+	OutcomeOverload
+	// OutcomeFailure is a call that failed for any other reason. Hard failures are left to the CircuitBreaker and do
+	// not change the limit.
+	OutcomeFailure
+	// OutcomeIgnore is a call whose outcome says nothing about the upstream, e.g. a call cancelled by the caller.
+	OutcomeIgnore
+)
+
+const (
+	defaultMinLimit          = 1
+	defaultMaxLimit          = 200
+	defaultInitialLimit      = 20
+	defaultBackoffRatio      = 0.9
+	defaultLatencyWindow     = 100
+	defaultLatencyPercentile = 0.9
+	defaultLatencyTolerance  = 2.0
+	minLatencySamples        = 10
+	signalTimeout            = "timeout"
+	signalOverload           = "overload"
+	signalLatency            = "latency"
+)
+
+// ErrLimitExceeded is the error returned by Acquire when the limit is reached and FailFast is set.
+var ErrLimitExceeded = errors.New("the adaptive concurrency limit is exceeded")
+
+// AdaptiveOptions holds the configuration parameters of the AdaptiveLimiter.
+type AdaptiveOptions struct {
+	MinLimit          int                          // Lower bound of the limit. Defaults to 1.
+	MaxLimit          int                          // Upper bound of the limit. Defaults to 200.
+	InitialLimit      int                          // Limit to start with. Defaults to 20.
+	BackoffRatio      float64                      // Factor the limit is multiplied by on a stress signal. Defaults to 0.9.
+	LatencyWindow     int                          // Number of recent successful latencies the threshold is computed from. Defaults to 100.
+	LatencyPercentile float64                      // Percentile of the recent latencies used as the baseline. Defaults to 0.9.
+	LatencyTolerance  float64                      // A latency above baseline * LatencyTolerance is a stress signal. Defaults to 2.
+	FailFast          bool                         // Acquire fails with ErrLimitExceeded instead of blocking when at the limit.
+	OnLimitChange     func(oldLimit, newLimit int) // Called after the limit changes.
+	Clock             func() time.Time             // Source of the time the latencies are measured with. Defaults to time.Now.
+}
+
+// AdaptiveMetrics is a snapshot of the state of the AdaptiveLimiter.
+type AdaptiveMetrics struct {
+	Limit        int       // Current limit
+	InFlight     int       // Number of permits in use
+	Waiting      int       // Number of blocked Acquire calls
+	Successes    uint64    // Number of successful calls
+	Timeouts     uint64    // Number of timed out calls
+	Overloads    uint64    // Number of calls rejected by the upstream
+	SlowCalls    uint64    // Number of successful calls above the latency threshold
+	LastSignal   string    // Last stress signal, one of timeout, overload or latency. Empty if none is received.
+	LastSignalAt time.Time // Time of the last stress signal
+}
+
+// AdaptiveLimiter limits the concurrency of outbound calls using AIMD (additive increase, multiplicative decrease).
+// Every successful call increases the limit by 1/limit, that is by about one per window of limit calls. A stress
+// signal, a timeout, an overload or a latency above the moving percentile threshold, multiplies the limit by the
+// BackoffRatio. The limit stays within MinLimit and MaxLimit.
+type AdaptiveLimiter struct {
+	opts      AdaptiveOptions
+	mutex     sync.Mutex
+	limit     float64
+	inFlight  int
+	waiters   []chan struct{}
+	latencies []time.Duration
+	next      int
+	metrics   AdaptiveMetrics
+}
+
+// NewAdaptiveLimiter creates a new AdaptiveLimiter with the provided options.
+// The options that are not set use the default values.
+func NewAdaptiveLimiter(opts AdaptiveOptions) *AdaptiveLimiter {
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = defaultMinLimit
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = defaultMaxLimit
+	}
+	if opts.MaxLimit < opts.MinLimit {
+		opts.MaxLimit = opts.MinLimit
+	}
+	if opts.InitialLimit <= 0 {
+		opts.InitialLimit = defaultInitialLimit
+	}
+	if opts.BackoffRatio <= 0 || opts.BackoffRatio >= 1 {
+		opts.BackoffRatio = defaultBackoffRatio
+	}
+	if opts.LatencyWindow <= 0 {
+		opts.LatencyWindow = defaultLatencyWindow
+	}
+	if opts.LatencyPercentile <= 0 || opts.LatencyPercentile > 1 {
+		opts.LatencyPercentile = defaultLatencyPercentile
+	}
+	if opts.LatencyTolerance <= 1 {
+		opts.LatencyTolerance = defaultLatencyTolerance
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	l := &AdaptiveLimiter{opts: opts}
+	l.limit = math.Min(math.Max(float64(opts.InitialLimit), float64(opts.MinLimit)), float64(opts.MaxLimit))
+	return l
+}
+
+// Acquire acquires a permit for a call. When the limit is reached it blocks until a permit is released or the ctx is
+// done, unless FailFast is set. The returned release must be called once with the outcome of the call.
+func (l *AdaptiveLimiter) Acquire(ctx context.Context) (release func(outcome Outcome), err error) {
+	l.mutex.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mutex.Unlock()
+		release = l.releaser()
+		return
+	}
+	if l.opts.FailFast {
+		l.mutex.Unlock()
+		err = ErrLimitExceeded
+		return
+	}
+	waiter := make(chan struct{}, 1)
+	l.waiters = append(l.waiters, waiter)
+	l.mutex.Unlock()
+	select {
+	case <-waiter:
+		release = l.releaser()
+	case <-ctx.Done():
+		err = ctx.Err()
+		l.mutex.Lock()
+		if !l.removeWaiter(waiter) {
+			// the permit was granted along with the cancellation
+			l.inFlight--
+			l.grant()
+		}
+		l.mutex.Unlock()
+	}
+	return
+}
+
+// Limit returns the current limit.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of permits in use.
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.inFlight
+}
+
+// Metrics returns a snapshot of the limiter state.
+func (l *AdaptiveLimiter) Metrics() AdaptiveMetrics {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	m := l.metrics
+	m.Limit = int(l.limit)
+	m.InFlight = l.inFlight
+	m.Waiting = len(l.waiters)
+	return m
+}
+
+// releaser returns the release function of a permit acquired now.
+func (l *AdaptiveLimiter) releaser() func(outcome Outcome) {
+	start := l.opts.Clock()
+	once := &sync.Once{}
+	return func(outcome Outcome) {
+		once.Do(func() {
+			l.release(outcome, l.opts.Clock().Sub(start))
+		})
+	}
+}
+
+// release returns the permit and adjusts the limit based on the outcome.
+func (l *AdaptiveLimiter) release(outcome Outcome, latency time.Duration) {
+	l.mutex.Lock()
+	oldLimit := int(l.limit)
+	l.inFlight--
+	switch outcome {
+	case OutcomeSuccess:
+		l.metrics.Successes++
+		if l.slow(latency) {
+			l.metrics.SlowCalls++
+			l.decrease(signalLatency)
+		} else {
+			l.limit = math.Min(l.limit+1/l.limit, float64(l.opts.MaxLimit))
+		}
+		// slow calls are recorded too so that the baseline follows a lasting change of the latency
+		l.recordLatency(latency)
+	case OutcomeTimeout:
+		l.metrics.Timeouts++
+		l.decrease(signalTimeout)
+	case OutcomeOverload:
+		l.metrics.Overloads++
+		l.decrease(signalOverload)
+	}
+	l.grant()
+	newLimit := int(l.limit)
+	l.mutex.Unlock()
+	if newLimit != oldLimit && l.opts.OnLimitChange != nil {
+		l.opts.OnLimitChange(oldLimit, newLimit)
+	}
+}
+
+// decrease multiplies the limit by the backoff ratio on a stress signal.
+func (l *AdaptiveLimiter) decrease(signal string) {
+	l.metrics.LastSignal = signal
+	l.metrics.LastSignalAt = l.opts.Clock()
+	l.limit = math.Max(l.limit*l.opts.BackoffRatio, float64(l.opts.MinLimit))
+}
+
+// slow checks if the latency exceeds the tolerated multiple of the latency percentile of the recent calls.
+func (l *AdaptiveLimiter) slow(latency time.Duration) bool {
+	if len(l.latencies) < minLatencySamples {
+		return false
+	}
+	sorted := make([]time.Duration, len(l.latencies))
+	copy(sorted, l.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	baseline := sorted[int(math.Ceil(l.opts.LatencyPercentile*float64(len(sorted))))-1]
+	return float64(latency) > float64(baseline)*l.opts.LatencyTolerance
+}
+
+// recordLatency adds the latency to the window of recent latencies.
+func (l *AdaptiveLimiter) recordLatency(latency time.Duration) {
+	if len(l.latencies) < l.opts.LatencyWindow {
+		l.latencies = append(l.latencies, latency)
+		return
+	}
+	l.latencies[l.next] = latency
+	l.next = (l.next + 1) % l.opts.LatencyWindow
+}
+
+// grant hands the available permits to the waiters in the order they arrived.
+func (l *AdaptiveLimiter) grant() {
+	for len(l.waiters) > 0 && l.inFlight < int(l.limit) {
+		waiter := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.inFlight++
+		waiter <- struct{}{}
+	}
+}
+
+// removeWaiter removes the waiter if it is still waiting for a permit.
+func (l *AdaptiveLimiter) removeWaiter(waiter chan struct{}) bool {
+	for i, w := range l.waiters {
+		if w == waiter {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
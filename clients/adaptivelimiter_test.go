@@ -0,0 +1,159 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is advanced by the test to script the latency of the calls
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// call makes a call through the limiter that takes the latency and ends with the outcome
+func call(t *testing.T, l *AdaptiveLimiter, clock *fakeClock, latency time.Duration, outcome Outcome) {
+	t.Helper()
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	clock.now = clock.now.Add(latency)
+	release(outcome)
+}
+
+func TestAdaptiveLimiter_Trajectory(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var changes int
+	l := NewAdaptiveLimiter(AdaptiveOptions{
+		MinLimit:      2,
+		MaxLimit:      50,
+		InitialLimit:  10,
+		BackoffRatio:  0.5,
+		Clock:         clock.Now,
+		OnLimitChange: func(oldLimit, newLimit int) { changes++ },
+	})
+	// healthy upstream
+	for i := 0; i < 100; i++ {
+		call(t, l, clock, 10*time.Millisecond, OutcomeSuccess)
+	}
+	healthy := l.Limit()
+	if healthy <= 10 {
+		t.Fatalf("Limit() = %d, want it to grow above 10", healthy)
+	}
+	// upstream under stress
+	call(t, l, clock, 10*time.Millisecond, OutcomeOverload)
+	call(t, l, clock, time.Second, OutcomeTimeout)
+	stressed := l.Limit()
+	if stressed >= healthy/2 {
+		t.Fatalf("Limit() = %d, want it to shrink below %d", stressed, healthy/2)
+	}
+	for i := 0; i < 10; i++ {
+		call(t, l, clock, time.Second, OutcomeOverload)
+	}
+	if l.Limit() != 2 {
+		t.Errorf("Limit() = %d, want the MinLimit 2", l.Limit())
+	}
+	// hard failures are left to the circuit breaker
+	call(t, l, clock, 10*time.Millisecond, OutcomeFailure)
+	if l.Limit() != 2 {
+		t.Errorf("Limit() = %d, want 2 after a failure", l.Limit())
+	}
+	// recovery
+	for i := 0; i < 200; i++ {
+		call(t, l, clock, 10*time.Millisecond, OutcomeSuccess)
+	}
+	if l.Limit() <= 10 {
+		t.Errorf("Limit() = %d, want it to recover above 10", l.Limit())
+	}
+	for i := 0; i < 5000; i++ {
+		call(t, l, clock, 10*time.Millisecond, OutcomeSuccess)
+	}
+	if l.Limit() != 50 {
+		t.Errorf("Limit() = %d, want the MaxLimit 50", l.Limit())
+	}
+	m := l.Metrics()
+	if m.Overloads != 11 || m.Timeouts != 1 || m.LastSignal != "overload" || m.InFlight != 0 {
+		t.Errorf("Metrics() = %+v", m)
+	}
+	if changes == 0 {
+		t.Error("OnLimitChange was not called")
+	}
+}
+
+func TestAdaptiveLimiter_Latency(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	l := NewAdaptiveLimiter(AdaptiveOptions{InitialLimit: 20, Clock: clock.Now})
+	for i := 0; i < 20; i++ {
+		call(t, l, clock, 10*time.Millisecond, OutcomeSuccess)
+	}
+	before := l.Limit()
+	call(t, l, clock, 100*time.Millisecond, OutcomeSuccess)
+	if l.Limit() >= before {
+		t.Errorf("Limit() = %d, want it to shrink below %d on a slow call", l.Limit(), before)
+	}
+	m := l.Metrics()
+	if m.SlowCalls != 1 || m.LastSignal != "latency" || !m.LastSignalAt.Equal(clock.now) {
+		t.Errorf("Metrics() = %+v", m)
+	}
+}
+
+func TestAdaptiveLimiter_Blocking(t *testing.T) {
+	l := NewAdaptiveLimiter(AdaptiveOptions{MinLimit: 1, MaxLimit: 1, InitialLimit: 1})
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = l.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if m := l.Metrics(); m.Waiting != 0 || m.InFlight != 1 {
+		t.Errorf("Metrics() = %+v", m)
+	}
+
+	acquired := make(chan func(Outcome))
+	go func() {
+		r, err := l.Acquire(context.Background())
+		if err == nil {
+			acquired <- r
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release(OutcomeIgnore)
+	// releasing twice has no effect
+	release(OutcomeIgnore)
+	select {
+	case r := <-acquired:
+		if l.InFlight() != 1 {
+			t.Errorf("InFlight() = %d, want 1", l.InFlight())
+		}
+		r(OutcomeSuccess)
+	case <-time.After(time.Second):
+		t.Fatal("Acquire() was not unblocked by the release")
+	}
+	if l.InFlight() != 0 {
+		t.Errorf("InFlight() = %d, want 0", l.InFlight())
+	}
+}
+
+func TestAdaptiveLimiter_FailFast(t *testing.T) {
+	l := NewAdaptiveLimiter(AdaptiveOptions{MaxLimit: 1, FailFast: true})
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Acquire(context.Background()); err != ErrLimitExceeded {
+		t.Errorf("Acquire() error = %v, want %v", err, ErrLimitExceeded)
+	}
+	release(OutcomeSuccess)
+	if _, err = l.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire() error = %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package clients
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrInvalidAuth is the error returned by Apply when the credentials of the Auth are incomplete.
+var ErrInvalidAuth = errors.New("invalid authentication credentials")
+
+// Auth adds the credentials of an authentication scheme to the requests. The rest client calls Apply before sending
+// each attempt of a request.
+type Auth interface {
+	// Apply adds the credentials to the request
+	Apply(req *http.Request) error
+}
+
+// APIKeyLocation is the part of the request carrying the API key of an APIKeyAuth.
+type APIKeyLocation int
+
+const (
+	// APIKeyHeader sends the API key in a header
+	APIKeyHeader APIKeyLocation = iota
+	// APIKeyQuery sends the API key in a query parameter
+	APIKeyQuery
+)
+
+// BasicAuth is the Auth of the HTTP Basic authentication scheme.
+type BasicAuth struct {
+	user     string
+	password string
+}
+
+// NewBasicAuth creates a new BasicAuth with the user and the password.
+func NewBasicAuth(user, password string) *BasicAuth {
+	return &BasicAuth{user: user, password: password}
+}
+
+// Apply sets the Authorization header of the request.
+func (a *BasicAuth) Apply(req *http.Request) error {
+	req.SetBasicAuth(a.user, a.password)
+	return nil
+}
+
+// BearerAuth is the Auth of the bearer tokens of RFC 6750.
+type BearerAuth struct {
+	token string
+}
+
+// NewBearerAuth creates a new BearerAuth with the token.
+func NewBearerAuth(token string) *BearerAuth {
+	return &BearerAuth{token: token}
+}
+
+// Apply sets the Authorization header of the request.
+func (a *BearerAuth) Apply(req *http.Request) error {
+	if a.token == "" {
+		return ErrInvalidAuth
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// APIKeyAuth is the Auth of the API keys sent in a header or a query parameter.
+type APIKeyAuth struct {
+	name  string
+	value string
+	in    APIKeyLocation
+}
+
+// NewAPIKeyAuth creates a new APIKeyAuth sending the API key value in the header or the query parameter of the name.
+func NewAPIKeyAuth(name, value string, in APIKeyLocation) *APIKeyAuth {
+	return &APIKeyAuth{name: name, value: value, in: in}
+}
+
+// Apply sets the header or the query parameter of the API key, replacing any previous value.
+func (a *APIKeyAuth) Apply(req *http.Request) error {
+	if a.name == "" {
+		return ErrInvalidAuth
+	}
+	if a.in == APIKeyQuery {
+		query := req.URL.Query()
+		query.Set(a.name, a.value)
+		req.URL.RawQuery = query.Encode()
+		return nil
+	}
+	req.Header.Set(a.name, a.value)
+	return nil
+}
@@ -0,0 +1,45 @@
+package clients
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAuth_Apply(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.com/items?page=2", nil)
+	for _, auth := range []Auth{
+		NewBasicAuth("user", "pass"),
+		NewAPIKeyAuth("X-Api-Key", "k1", APIKeyHeader),
+		NewAPIKeyAuth("api_key", "k 2", APIKeyQuery),
+	} {
+		if err := auth.Apply(req); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Errorf("BasicAuth() = %s, %s, %v", user, pass, ok)
+	}
+	if req.Header.Get("X-Api-Key") != "k1" {
+		t.Errorf("X-Api-Key = %s", req.Header.Get("X-Api-Key"))
+	}
+	if req.URL.RawQuery != "api_key=k+2&page=2" {
+		t.Errorf("RawQuery = %s", req.URL.RawQuery)
+	}
+
+	// the key replaces the previous one
+	_ = NewAPIKeyAuth("api_key", "k3", APIKeyQuery).Apply(req)
+	if req.URL.Query().Get("api_key") != "k3" || len(req.URL.Query()["api_key"]) != 1 {
+		t.Errorf("RawQuery = %s", req.URL.RawQuery)
+	}
+
+	if err := NewBearerAuth("t0ken").Apply(req); err != nil || req.Header.Get("Authorization") != "Bearer t0ken" {
+		t.Errorf("Apply() = %v, Authorization = %s", err, req.Header.Get("Authorization"))
+	}
+	if err := NewBearerAuth("").Apply(req); !errors.Is(err, ErrInvalidAuth) {
+		t.Errorf("Apply() error = %v, want ErrInvalidAuth", err)
+	}
+	if err := NewAPIKeyAuth("", "k", APIKeyHeader).Apply(req); !errors.Is(err, ErrInvalidAuth) {
+		t.Errorf("Apply() error = %v, want ErrInvalidAuth", err)
+	}
+}
@@ -0,0 +1,129 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBulkheadFull is the error returned by Acquire when the concurrent calls and the queue of the Bulkhead are full.
+var ErrBulkheadFull = errors.New("the bulkhead is full")
+
+// ErrBulkheadTimeout is the error returned by Acquire when the call waited in the queue for the queue timeout.
+var ErrBulkheadTimeout = errors.New("the bulkhead queue timeout is exceeded")
+
+// Bulkhead caps the number of concurrent calls to a dependency. Up to maxConcurrent calls proceed, up to maxQueued
+// calls wait in order for a slot to free, and the other calls are rejected with ErrBulkheadFull.
+type Bulkhead struct {
+	maxConcurrent int
+	maxQueued     int
+	queueTimeout  time.Duration
+	mutex         sync.Mutex
+	inFlight      int
+	waiters       []chan struct{}
+}
+
+// NewBulkhead creates a new Bulkhead allowing maxConcurrent concurrent calls, at least 1, and maxQueued waiting
+// calls. The calls wait for at most the queueTimeout; they wait until their context is done if it is not positive.
+func NewBulkhead(maxConcurrent, maxQueued int, queueTimeout time.Duration) *Bulkhead {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+	return &Bulkhead{maxConcurrent: maxConcurrent, maxQueued: maxQueued, queueTimeout: queueTimeout}
+}
+
+// Acquire acquires a slot for a call. When maxConcurrent calls are in flight it waits in the queue until a slot is
+// released, the queue timeout elapses, ErrBulkheadTimeout, or the ctx is done. It fails with ErrBulkheadFull without
+// waiting when the queue is full. The returned release must be called once the call is done.
+func (b *Bulkhead) Acquire(ctx context.Context) (release func(), err error) {
+	b.mutex.Lock()
+	if b.inFlight < b.maxConcurrent && len(b.waiters) == 0 {
+		b.inFlight++
+		b.mutex.Unlock()
+		release = b.releaser()
+		return
+	}
+	if len(b.waiters) >= b.maxQueued {
+		b.mutex.Unlock()
+		err = ErrBulkheadFull
+		return
+	}
+	waiter := make(chan struct{}, 1)
+	b.waiters = append(b.waiters, waiter)
+	b.mutex.Unlock()
+	var timeout <-chan time.Time
+	if b.queueTimeout > 0 {
+		timer := time.NewTimer(b.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-waiter:
+		release = b.releaser()
+		return
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrBulkheadTimeout
+	}
+	b.mutex.Lock()
+	if !b.removeWaiter(waiter) {
+		// the slot was granted along with the cancellation
+		b.inFlight--
+		b.grant()
+	}
+	b.mutex.Unlock()
+	return
+}
+
+// InFlight returns the number of calls in flight.
+func (b *Bulkhead) InFlight() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.inFlight
+}
+
+// Queued returns the number of calls waiting for a slot.
+func (b *Bulkhead) Queued() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.waiters)
+}
+
+// releaser returns the release function of a slot acquired now.
+func (b *Bulkhead) releaser() func() {
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			b.inFlight--
+			b.grant()
+			b.mutex.Unlock()
+		})
+	}
+}
+
+// grant hands the free slots to the waiters in the order they arrived.
+func (b *Bulkhead) grant() {
+	for len(b.waiters) > 0 && b.inFlight < b.maxConcurrent {
+		waiter := b.waiters[0]
+		b.waiters = b.waiters[1:]
+		b.inFlight++
+		waiter <- struct{}{}
+	}
+}
+
+// removeWaiter removes the waiter if it is still waiting for a slot.
+func (b *Bulkhead) removeWaiter(waiter chan struct{}) bool {
+	for i, w := range b.waiters {
+		if w == waiter {
+			b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
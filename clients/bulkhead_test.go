@@ -0,0 +1,129 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBulkhead_Queue(t *testing.T) {
+	b := NewBulkhead(2, 2, 0)
+	release1, err1 := b.Acquire(context.Background())
+	release2, err2 := b.Acquire(context.Background())
+	if err1 != nil || err2 != nil || b.InFlight() != 2 {
+		t.Fatalf("Acquire() = %v, %v, InFlight() = %d", err1, err2, b.InFlight())
+	}
+
+	// the waiters are granted the slots in the order they arrived
+	var order []int
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := b.Acquire(context.Background())
+			if err != nil {
+				t.Errorf("Acquire() = %v", err)
+				return
+			}
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+			release()
+		}(i)
+		for b.Queued() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if _, err := b.Acquire(context.Background()); !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("Acquire() = %v, want ErrBulkheadFull", err)
+	}
+	release1()
+	release1()
+	wg.Wait()
+	if len(order) != 2 || order[0] != 0 || order[1] != 1 {
+		t.Errorf("order = %v", order)
+	}
+	release2()
+	if b.InFlight() != 0 || b.Queued() != 0 {
+		t.Errorf("InFlight() = %d, Queued() = %d", b.InFlight(), b.Queued())
+	}
+}
+
+func TestBulkhead_Timeout(t *testing.T) {
+	b := NewBulkhead(1, 1, 20*time.Millisecond)
+	release, _ := b.Acquire(context.Background())
+	start := time.Now()
+	if _, err := b.Acquire(context.Background()); !errors.Is(err, ErrBulkheadTimeout) {
+		t.Errorf("Acquire() = %v, want ErrBulkheadTimeout", err)
+	}
+	if time.Since(start) < 20*time.Millisecond || b.Queued() != 0 {
+		t.Errorf("Acquire() returned after %v with %d queued", time.Since(start), b.Queued())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() = %v, want context.DeadlineExceeded", err)
+	}
+	release()
+	if b.InFlight() != 0 || b.Queued() != 0 {
+		t.Errorf("InFlight() = %d, Queued() = %d", b.InFlight(), b.Queued())
+	}
+}
+
+func TestBulkhead_Stress(t *testing.T) {
+	const maxConcurrent = 5
+	b := NewBulkhead(maxConcurrent, 20, 0)
+	var inFlight, maxInFlight, completed, rejected atomic.Int32
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				release, err := b.Acquire(ctx)
+				if err != nil {
+					if !errors.Is(err, ErrBulkheadFull) {
+						t.Errorf("Acquire() = %v", err)
+						return
+					}
+					rejected.Add(1)
+					continue
+				}
+				n := inFlight.Add(1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				if b.InFlight() > maxConcurrent || b.Queued() > 20 {
+					t.Errorf("InFlight() = %d, Queued() = %d", b.InFlight(), b.Queued())
+				}
+				if i%10 == 0 {
+					time.Sleep(time.Microsecond)
+				}
+				inFlight.Add(-1)
+				completed.Add(1)
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInFlight.Load() > maxConcurrent {
+		t.Errorf("%d calls in flight, want at most %d", maxInFlight.Load(), maxConcurrent)
+	}
+	if completed.Load()+rejected.Load() != 50*200 || completed.Load() == 0 {
+		t.Errorf("%d completed and %d rejected calls", completed.Load(), rejected.Load())
+	}
+	if b.InFlight() != 0 || b.Queued() != 0 {
+		t.Errorf("InFlight() = %d, Queued() = %d", b.InFlight(), b.Queued())
+	}
+}
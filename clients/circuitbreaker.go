@@ -2,39 +2,77 @@ package clients
 
 import (
 	"errors"
-	"sync/atomic"
+	"sync"
 	"time"
 )
 
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState uint32
+
 // CircuitBreaker states
 const (
-	circuitClosed           uint32 = iota // Circuit is closed and requests can flow through
-	circuitHalfOpen                       // Circuit is partially open and allows limited requests for testing
-	circuitOpen                           // Circuit is open and requests are blocked
+	BreakerClosed   BreakerState = iota // Circuit is closed and requests can flow through
+	BreakerHalfOpen                     // Circuit is partially open and allows limited requests for testing
+	BreakerOpen                         // Circuit is open and requests are blocked
+)
+
+const (
 	defaultTimeout          = 300
 	defaultMaxHalfOpen      = 5
 	defaultSuccessThreshold = 3
 	defaultFailureThreshold = 3
+	defaultFailureWindow    = 100
 )
 
 // CBOpenErr is the error returned when the circuit breaker is open and unable to process requests.
 var CBOpenErr = errors.New("the Circuit breaker is open and unable to process request")
 
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
 // BreakerInfo holds the configuration parameters for the CircuitBreaker.
 type BreakerInfo struct {
-	FailureThreshold uint64 // Number of consecutive failures required to open the circuit
-	SuccessThreshold uint64 // Number of consecutive successes required to close the circuit
-	MaxHalfOpen      uint32 // Maximum number of requests allowed in the half-open state
-	Timeout          uint32 // Timeout duration for the circuit to transition from open to half-open state
+	FailureThreshold     uint64           // Number of consecutive failures required to open the circuit
+	SuccessThreshold     uint64           // Number of consecutive successes required to close the circuit
+	MaxHalfOpen          uint32           // Maximum number of concurrent requests allowed in the half-open state
+	Timeout              uint32           // Timeout duration in seconds for the circuit to transition from open to half-open state
+	FailureRateThreshold float64          // Rate of failures, between 0 and 1, over the last FailureWindow calls opening the circuit instead of the FailureThreshold
+	FailureWindow        int              // Number of calls the failure rate is computed over. Defaults to 100.
+	Clock                func() time.Time // Source of the time of the Timeout. Defaults to time.Now.
+}
+
+// BreakerCounts are the counts of the calls of a CircuitBreaker. The successes, the failures and the consecutive
+// failures are reset when the state changes.
+type BreakerCounts struct {
+	Successes           uint64 // Number of successful calls in the current state
+	Failures            uint64 // Number of failed calls in the current state
+	ConsecutiveFailures uint64 // Number of failed calls since the last successful call
+	Rejected            uint64 // Number of calls rejected since the creation or the last Reset
 }
 
 // CircuitBreaker is a struct that represents a circuit breaker.
 type CircuitBreaker struct {
 	*BreakerInfo
-	currentState    uint32 // Current state of the circuit breaker
-	successCounter  uint64 // Counter for successful requests
-	failureCounter  uint64 // Counter for failed requests
-	halfOpenCounter uint32 // Counter for requests in the half-open state
+	mutex              sync.Mutex
+	currentState       BreakerState // Current state of the circuit breaker
+	openedAt           time.Time    // Time the circuit last opened
+	counts             BreakerCounts
+	consecutiveSuccess uint64 // Number of successful calls since the last failed call
+	halfOpenInFlight   uint32 // Number of requests in progress in the half-open state
+	window             []bool // Outcomes of the last calls in the closed state, true for a failure
+	windowNext         int
+	windowFailures     int
+	onStateChange      []func(from, to BreakerState)
 }
 
 // NewCB creates a new CircuitBreaker instance with the provided BreakerInfo.
@@ -58,88 +96,169 @@ func NewCB(info *BreakerInfo) (cb *CircuitBreaker) {
 	if info.Timeout == 0 {
 		info.Timeout = defaultTimeout
 	}
+	if info.FailureWindow <= 0 {
+		info.FailureWindow = defaultFailureWindow
+	}
+	if info.Clock == nil {
+		info.Clock = time.Now
+	}
 
 	return &CircuitBreaker{
-		BreakerInfo:     info,
-		successCounter:  0,
-		failureCounter:  0,
-		halfOpenCounter: 0,
-		currentState:    circuitClosed,
+		BreakerInfo:  info,
+		currentState: BreakerClosed,
 	}
 }
 
 // CanExecute checks if a request can be executed based on the current state of the circuit breaker.
-// It returns an error if the circuit is open or if the maximum number of requests in the half-open state is reached.
+// It returns an error if the circuit is open or if MaxHalfOpen requests are already in progress in the half-open
+// state. The open circuit moves to the half-open state on the first check after the Timeout.
 func (cb *CircuitBreaker) CanExecute() (err error) {
-	state := cb.getState()
-	if state == circuitOpen {
+	cb.mutex.Lock()
+	from := cb.currentState
+	cb.checkTimeout()
+	switch cb.currentState {
+	case BreakerOpen:
 		err = CBOpenErr
-	} else if state == circuitHalfOpen {
-		val := atomic.AddUint32(&cb.halfOpenCounter, 1)
-		if val > cb.MaxHalfOpen {
-			cb.updateState(circuitHalfOpen, circuitOpen)
+	case BreakerHalfOpen:
+		if cb.halfOpenInFlight < cb.MaxHalfOpen {
+			cb.halfOpenInFlight++
+		} else {
 			err = CBOpenErr
 		}
 	}
+	if err != nil {
+		cb.counts.Rejected++
+	}
+	cb.unlock(from)
 	return
 }
 
 // OnExecution is called after a request is executed.
 // It updates the success or failure counters based on the result of the request.
 // It also checks if the circuit needs to transition to a different state based on the counters and thresholds.
+// A failure in the half-open state opens the circuit again.
 func (cb *CircuitBreaker) OnExecution(success bool) {
-	var val uint64
-	state := cb.getState()
+	cb.mutex.Lock()
+	from := cb.currentState
 	if success {
-		val = atomic.AddUint64(&cb.successCounter, 1)
-		if state == circuitHalfOpen {
-			if val >= cb.SuccessThreshold {
-				cb.updateState(circuitHalfOpen, circuitClosed)
-			}
-		}
+		cb.counts.Successes++
+		cb.counts.ConsecutiveFailures = 0
+		cb.consecutiveSuccess++
 	} else {
-		val = atomic.AddUint64(&cb.failureCounter, 1)
-		// Check if the failure threshold is reached
-		if state == circuitClosed {
-			if val >= cb.FailureThreshold {
-				cb.updateState(circuitClosed, circuitOpen)
-			}
+		cb.counts.Failures++
+		cb.counts.ConsecutiveFailures++
+		cb.consecutiveSuccess = 0
+	}
+	switch cb.currentState {
+	case BreakerClosed:
+		if cb.tripped(!success) {
+			cb.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if cb.halfOpenInFlight > 0 {
+			cb.halfOpenInFlight--
+		}
+		if !success {
+			cb.setState(BreakerOpen)
+		} else if cb.consecutiveSuccess >= cb.SuccessThreshold {
+			cb.setState(BreakerClosed)
 		}
 	}
+	cb.unlock(from)
+}
+
+// State returns the current state of the circuit breaker.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mutex.Lock()
+	from := cb.currentState
+	cb.checkTimeout()
+	state := cb.currentState
+	cb.unlock(from)
+	return state
+}
+
+// Counts returns a snapshot of the counts of the calls.
+func (cb *CircuitBreaker) Counts() BreakerCounts {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.counts
+}
+
+// OnStateChange adds a function called after each change of the state, outside the lock of the circuit breaker.
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to BreakerState)) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.onStateChange = append(cb.onStateChange, fn)
 }
 
 // Reset resets the circuit breaker to its initial state.
 func (cb *CircuitBreaker) Reset() {
-	atomic.StoreUint32(&cb.currentState, circuitClosed)
-	atomic.StoreUint64(&cb.failureCounter, 0)
-	atomic.StoreUint64(&cb.successCounter, 0)
-	atomic.StoreUint32(&cb.halfOpenCounter, 0)
-}
-
-// updateState updates the state of the circuit breaker atomically.
-// It also resets the success and failure counters and starts a timer to transition to the half-open state.
-func (cb *CircuitBreaker) updateState(oldState, newState uint32) {
-	if atomic.CompareAndSwapUint32(&cb.currentState, oldState, newState) {
-		atomic.StoreUint64(&cb.successCounter, 0)
-		atomic.StoreUint64(&cb.failureCounter, 0)
-		atomic.StoreUint32(&cb.halfOpenCounter, 0)
-		// Check if moving to circuitOpen state
-		if newState == circuitOpen {
-			// Start Timer for HalfOpen
-			go func() {
-				select {
-				case <-time.After(time.Second * time.Duration(cb.Timeout)):
-					{
-						cb.updateState(circuitOpen, circuitHalfOpen)
-					}
-				}
-			}()
+	cb.mutex.Lock()
+	from := cb.currentState
+	cb.setState(BreakerClosed)
+	cb.resetCounts()
+	cb.counts.Rejected = 0
+	cb.unlock(from)
+}
+
+// tripped records the outcome of a call in the closed state and checks if the circuit must open, on the failure rate
+// of the window if FailureRateThreshold is set and on the consecutive failures otherwise.
+func (cb *CircuitBreaker) tripped(failure bool) bool {
+	if cb.FailureRateThreshold <= 0 {
+		return cb.counts.ConsecutiveFailures >= cb.FailureThreshold
+	}
+	if len(cb.window) < cb.FailureWindow {
+		cb.window = append(cb.window, failure)
+	} else {
+		if cb.window[cb.windowNext] {
+			cb.windowFailures--
 		}
+		cb.window[cb.windowNext] = failure
+		cb.windowNext = (cb.windowNext + 1) % cb.FailureWindow
+	}
+	if failure {
+		cb.windowFailures++
 	}
+	return len(cb.window) == cb.FailureWindow &&
+		float64(cb.windowFailures) >= cb.FailureRateThreshold*float64(cb.FailureWindow)
 }
 
-// getState returns the current state of the circuit breaker.
-func (cb *CircuitBreaker) getState() (s uint32) {
-	atomic.LoadUint32(&s)
-	return
+// checkTimeout moves the open circuit to the half-open state once the Timeout has elapsed.
+func (cb *CircuitBreaker) checkTimeout() {
+	if cb.currentState == BreakerOpen &&
+		!cb.Clock().Before(cb.openedAt.Add(time.Duration(cb.Timeout)*time.Second)) {
+		cb.setState(BreakerHalfOpen)
+	}
+}
+
+// setState changes the state and resets the counts of the new state.
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	if cb.currentState == state {
+		return
+	}
+	cb.currentState = state
+	cb.resetCounts()
+	if state == BreakerOpen {
+		cb.openedAt = cb.Clock()
+	}
+}
+
+// resetCounts resets the counters of the current state and the failure window.
+func (cb *CircuitBreaker) resetCounts() {
+	cb.counts.Successes, cb.counts.Failures, cb.counts.ConsecutiveFailures = 0, 0, 0
+	cb.consecutiveSuccess = 0
+	cb.halfOpenInFlight = 0
+	cb.window, cb.windowNext, cb.windowFailures = cb.window[:0], 0, 0
+}
+
+// unlock releases the lock and calls the OnStateChange functions if the state is no longer the from state.
+func (cb *CircuitBreaker) unlock(from BreakerState) {
+	to := cb.currentState
+	callbacks := cb.onStateChange
+	cb.mutex.Unlock()
+	if from != to {
+		for _, fn := range callbacks {
+			fn(from, to)
+		}
+	}
 }
@@ -0,0 +1,199 @@
+package clients
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestCB creates a circuit breaker with a fake clock and records its state changes
+func newTestCB(info *BreakerInfo) (cb *CircuitBreaker, clock *fakeClock, changes *[]string) {
+	clock = &fakeClock{now: time.Unix(0, 0)}
+	info.Clock = clock.Now
+	cb = NewCB(info)
+	changes = &[]string{}
+	cb.OnStateChange(func(from, to BreakerState) {
+		*changes = append(*changes, fmt.Sprintf("%s->%s", from, to))
+	})
+	return
+}
+
+func TestCircuitBreaker_States(t *testing.T) {
+	cb, clock, changes := newTestCB(&BreakerInfo{FailureThreshold: 3, SuccessThreshold: 2, MaxHalfOpen: 2, Timeout: 10})
+	// the failures must be consecutive
+	for _, success := range []bool{false, false, true, false, false} {
+		cb.OnExecution(success)
+	}
+	if cb.State() != BreakerClosed {
+		t.Fatalf("State() = %s, want closed", cb.State())
+	}
+	if counts := cb.Counts(); counts.Successes != 1 || counts.Failures != 4 || counts.ConsecutiveFailures != 2 {
+		t.Errorf("Counts() = %+v", counts)
+	}
+	cb.OnExecution(false)
+	if cb.State() != BreakerOpen || cb.CanExecute() != CBOpenErr {
+		t.Fatalf("State() = %s, want open", cb.State())
+	}
+	if counts := cb.Counts(); counts.Rejected != 1 || counts.Failures != 0 {
+		t.Errorf("Counts() = %+v", counts)
+	}
+
+	// half-open after the timeout, a failed probe opens the circuit again
+	clock.now = clock.now.Add(10 * time.Second)
+	if err := cb.CanExecute(); err != nil || cb.State() != BreakerHalfOpen {
+		t.Fatalf("CanExecute() = %v, State() = %s", err, cb.State())
+	}
+	cb.OnExecution(false)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("State() = %s, want open", cb.State())
+	}
+
+	// the successful probes close the circuit
+	clock.now = clock.now.Add(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		if err := cb.CanExecute(); err != nil {
+			t.Fatalf("CanExecute() = %v", err)
+		}
+		cb.OnExecution(true)
+	}
+	if cb.State() != BreakerClosed {
+		t.Fatalf("State() = %s, want closed", cb.State())
+	}
+	want := "[closed->open open->half-open half-open->open open->half-open half-open->closed]"
+	if fmt.Sprint(*changes) != want {
+		t.Errorf("state changes = %v, want %s", *changes, want)
+	}
+
+	cb.OnExecution(false)
+	cb.Reset()
+	if counts := cb.Counts(); counts != (BreakerCounts{}) || cb.State() != BreakerClosed {
+		t.Errorf("Counts() = %+v, State() = %s after Reset", counts, cb.State())
+	}
+}
+
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	cb, _, _ := newTestCB(&BreakerInfo{FailureThreshold: 2, FailureRateThreshold: 0.5, FailureWindow: 10})
+	// alternating failures never reach 2 consecutive failures but reach the rate of the full window
+	for i := 0; i < 9; i++ {
+		cb.OnExecution(i%2 == 0)
+	}
+	if cb.State() != BreakerClosed {
+		t.Fatalf("State() = %s before the window is full", cb.State())
+	}
+	cb.OnExecution(false)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("State() = %s, want open at a failure rate of 0.5", cb.State())
+	}
+
+	// the failures drop out of the window
+	cb, _, _ = newTestCB(&BreakerInfo{FailureRateThreshold: 0.3, FailureWindow: 5})
+	for _, success := range []bool{false, true, true, true, true, false, true, true, true, true} {
+		cb.OnExecution(success)
+	}
+	if cb.State() != BreakerClosed {
+		t.Errorf("State() = %s, want closed at a failure rate of 0.2", cb.State())
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbes(t *testing.T) {
+	const maxHalfOpen = 3
+	cb, clock, _ := newTestCB(&BreakerInfo{FailureThreshold: 1, SuccessThreshold: 100, MaxHalfOpen: maxHalfOpen,
+		Timeout: 1})
+	cb.OnExecution(false)
+	clock.now = clock.now.Add(time.Second)
+	if cb.State() != BreakerHalfOpen {
+		t.Fatalf("State() = %s, want half-open", cb.State())
+	}
+
+	// the probes are held until all the callers have checked
+	var passed, inFlight, maxInFlight atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	checked := make(chan struct{})
+	var checks sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		checks.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := cb.CanExecute()
+			checks.Done()
+			if err != nil {
+				return
+			}
+			passed.Add(1)
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			<-checked
+			inFlight.Add(-1)
+			cb.OnExecution(true)
+		}()
+	}
+	close(start)
+	checks.Wait()
+	if passed.Load() != maxHalfOpen {
+		t.Errorf("%d probes passed, want %d", passed.Load(), maxHalfOpen)
+	}
+	close(checked)
+	wg.Wait()
+	if maxInFlight.Load() > maxHalfOpen {
+		t.Errorf("%d probes in flight, want at most %d", maxInFlight.Load(), maxHalfOpen)
+	}
+	if counts := cb.Counts(); counts.Rejected != 50-maxHalfOpen || counts.Successes != maxHalfOpen {
+		t.Errorf("Counts() = %+v", counts)
+	}
+
+	// the completed probes free their slots
+	if err := cb.CanExecute(); err != nil {
+		t.Errorf("CanExecute() = %v after the probes completed", err)
+	}
+}
+
+func TestCircuitBreaker_Concurrent(t *testing.T) {
+	const maxHalfOpen = 4
+	// the successful probes never close the circuit, so it stays half-open
+	cb, clock, _ := newTestCB(&BreakerInfo{FailureThreshold: 1, SuccessThreshold: 1 << 62, MaxHalfOpen: maxHalfOpen,
+		Timeout: 1})
+	cb.OnExecution(false)
+	clock.now = clock.now.Add(time.Second)
+	var inFlight, maxInFlight, passed atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if cb.CanExecute() != nil {
+					continue
+				}
+				passed.Add(1)
+				n := inFlight.Add(1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				inFlight.Add(-1)
+				cb.OnExecution(true)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInFlight.Load() > maxHalfOpen {
+		t.Errorf("%d probes in flight, want at most %d", maxInFlight.Load(), maxHalfOpen)
+	}
+	counts := cb.Counts()
+	if cb.State() != BreakerHalfOpen || counts.Successes != uint64(passed.Load()) ||
+		counts.Rejected != uint64(16000-passed.Load()) {
+		t.Errorf("State() = %s, Counts() = %+v, %d passed", cb.State(), counts, passed.Load())
+	}
+}
@@ -0,0 +1,65 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Resilience combines a CircuitBreaker, a Bulkhead and a RetryPolicy around the calls to a dependency. Each of them
+// is optional.
+type Resilience struct {
+	breaker  *CircuitBreaker
+	bulkhead *Bulkhead
+	retry    *RetryPolicy
+	// IsFailure checks if the result of a call is a failure for the circuit breaker. Defaults to the errors other
+	// than the context cancellations and the status codes of 500 and above.
+	IsFailure func(statusCode int, err error) bool
+}
+
+// NewResilience creates a new Resilience with the breaker, the bulkhead and the retry policy, any of which may be nil.
+func NewResilience(breaker *CircuitBreaker, bulkhead *Bulkhead, retry *RetryPolicy) *Resilience {
+	return &Resilience{breaker: breaker, bulkhead: bulkhead, retry: retry, IsFailure: isFailure}
+}
+
+// Execute calls the fn, which returns the status code and the error of an attempt, through the resilience patterns.
+// The retry policy is the outermost, so that each attempt goes through the circuit breaker and the bulkhead. An
+// attempt fails fast with CBOpenErr while the circuit is open, then waits for a slot of the bulkhead and is admitted
+// by the circuit breaker once it holds the slot, so that the half-open probes are not held by a full bulkhead. The
+// rejections of the circuit breaker and of the bulkhead are not retried by the default RetryableErrors.
+func (r *Resilience) Execute(ctx context.Context, fn func() (int, error)) (int, error) {
+	return ExecuteWithRetry(ctx, r.retry, func() (statusCode int, err error) {
+		if r.breaker != nil && r.breaker.State() == BreakerOpen {
+			return 0, CBOpenErr
+		}
+		if r.bulkhead != nil {
+			var release func()
+			if release, err = r.bulkhead.Acquire(ctx); err != nil {
+				return
+			}
+			defer release()
+		}
+		if r.breaker != nil {
+			if err = r.breaker.CanExecute(); err != nil {
+				return
+			}
+		}
+		statusCode, err = fn()
+		if r.breaker != nil {
+			failure := r.IsFailure
+			if failure == nil {
+				failure = isFailure
+			}
+			r.breaker.OnExecution(!failure(statusCode, err))
+		}
+		return
+	})
+}
+
+// isFailure is the default IsFailure of the Resilience
+func isFailure(statusCode int, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,99 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResilience_Retry(t *testing.T) {
+	cb := NewCB(&BreakerInfo{FailureThreshold: 10})
+	b := NewBulkhead(1, 0, 0)
+	r := NewResilience(cb, b, &RetryPolicy{RetryInfo: RetryInfo{MaxRetries: 3, Wait: 1}})
+	var calls int
+	code, err := r.Execute(context.Background(), script(&calls, status(503), status(503), status(200)))
+	if code != 200 || err != nil || calls != 3 {
+		t.Errorf("Execute() = %d, %v after %d calls", code, err, calls)
+	}
+	if counts := cb.Counts(); counts.Failures != 2 || counts.Successes != 1 || b.InFlight() != 0 {
+		t.Errorf("Counts() = %+v, InFlight() = %d", counts, b.InFlight())
+	}
+
+	// the client errors are not failures of the breaker
+	calls = 0
+	code, _ = r.Execute(context.Background(), script(&calls, status(404)))
+	if code != 404 || calls != 1 || cb.Counts().Successes != 2 {
+		t.Errorf("Execute() = %d after %d calls, Counts() = %+v", code, calls, cb.Counts())
+	}
+}
+
+func TestResilience_Breaker(t *testing.T) {
+	cb := NewCB(&BreakerInfo{FailureThreshold: 2})
+	r := NewResilience(cb, nil, &RetryPolicy{RetryInfo: RetryInfo{MaxRetries: 5, Wait: 1}})
+	var calls int
+	_, err := r.Execute(context.Background(), script(&calls, failure(errors.New("down"))))
+	if err == nil || calls != 1 || cb.Counts().Failures != 1 {
+		t.Errorf("Execute() = %v after %d calls", err, calls)
+	}
+	// the retry after the failure opening the circuit fails fast and is not retried
+	calls = 0
+	code, err := r.Execute(context.Background(), script(&calls, status(500)))
+	if !errors.Is(err, CBOpenErr) || code != 0 || calls != 1 || cb.State() != BreakerOpen {
+		t.Fatalf("Execute() = %d, %v after %d calls, State() = %s", code, err, calls, cb.State())
+	}
+	calls = 0
+	if _, err = r.Execute(context.Background(), script(&calls, status(200))); !errors.Is(err, CBOpenErr) ||
+		calls != 0 {
+		t.Errorf("Execute() = %v after %d calls, want CBOpenErr", err, calls)
+	}
+}
+
+func TestResilience_Bulkhead(t *testing.T) {
+	const maxConcurrent = 3
+	cb := NewCB(&BreakerInfo{FailureThreshold: 1000})
+	b := NewBulkhead(maxConcurrent, 100, 0)
+	r := NewResilience(cb, b, &RetryPolicy{RetryInfo: RetryInfo{MaxRetries: 2, Wait: 1}})
+	var inFlight, maxInFlight atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				_, err := r.Execute(context.Background(), func() (int, error) {
+					n := inFlight.Add(1)
+					defer inFlight.Add(-1)
+					for {
+						m := maxInFlight.Load()
+						if n <= m || maxInFlight.CompareAndSwap(m, n) {
+							break
+						}
+					}
+					time.Sleep(100 * time.Microsecond)
+					return 200, nil
+				})
+				if err != nil {
+					t.Errorf("Execute() = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInFlight.Load() > maxConcurrent || maxInFlight.Load() == 0 {
+		t.Errorf("%d calls in flight, want at most %d", maxInFlight.Load(), maxConcurrent)
+	}
+	if cb.Counts().Successes != 400 || b.InFlight() != 0 {
+		t.Errorf("Counts() = %+v, InFlight() = %d", cb.Counts(), b.InFlight())
+	}
+
+	// a full bulkhead is not retried
+	full := NewResilience(nil, NewBulkhead(1, 0, 0), &RetryPolicy{RetryInfo: RetryInfo{MaxRetries: 2, Wait: 1}})
+	release, _ := full.bulkhead.Acquire(context.Background())
+	defer release()
+	if _, err := full.Execute(context.Background(), status(200)); !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("Execute() = %v, want ErrBulkheadFull", err)
+	}
+}
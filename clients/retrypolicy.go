@@ -0,0 +1,153 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryableStatusCodes are the status codes retried by a RetryPolicy without RetryableStatusCodes: 408, 429,
+// 500, 502, 503 and 504.
+var DefaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504}
+
+// DefaultRetryableErrors are the predicates of the errors retried by a RetryPolicy without RetryableErrors.
+var DefaultRetryableErrors = []func(err error) bool{IsTimeoutError, IsConnectionError}
+
+// RetryPolicy decides which failed attempts are retried and how long to wait before the next attempt. A failure is
+// either an error or a status code. The context cancellations are never retried.
+type RetryPolicy struct {
+	// RetryInfo holds the maximum number of retries and the wait in milliseconds before the first retry.
+	RetryInfo
+	// BackoffFactor is the factor the wait is multiplied by after each retry. Defaults to 1, a constant wait.
+	BackoffFactor float64
+	// MaxWait is the upper bound of the wait. No bound if not set.
+	MaxWait time.Duration
+	// MaxElapsed is the budget of time across all the attempts and waits. No budget if not set.
+	MaxElapsed time.Duration
+	// RetryableStatusCodes are the status codes retried. Defaults to DefaultRetryableStatusCodes.
+	RetryableStatusCodes []int
+	// RetryableErrors are the predicates of the errors retried. Defaults to DefaultRetryableErrors.
+	RetryableErrors []func(err error) bool
+	// OnRetry is called before waiting for the retry of the attempt.
+	OnRetry func(attempt int, wait time.Duration, reason string)
+	// Clock is the source of the time of the MaxElapsed budget. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// ShouldRetry checks if the attempt, counted from 1, that ended with the status code and the error is retried. An
+// attempt with an error is retried if a predicate of RetryableErrors matches it, an attempt without an error if its
+// status code is one of RetryableStatusCodes. No attempt is retried after MaxRetries retries, or when its error is a
+// context cancellation. The MaxElapsed budget is checked by ExecuteWithRetry.
+func (p *RetryPolicy) ShouldRetry(attempt int, statusCode int, err error) bool {
+	if attempt > p.MaxRetries {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		predicates := p.RetryableErrors
+		if predicates == nil {
+			predicates = DefaultRetryableErrors
+		}
+		for _, retryable := range predicates {
+			if retryable(err) {
+				return true
+			}
+		}
+		return false
+	}
+	codes := p.RetryableStatusCodes
+	if codes == nil {
+		codes = DefaultRetryableStatusCodes
+	}
+	return slices.Contains(codes, statusCode)
+}
+
+// WaitFor returns the wait before the retry of the attempt, counted from 1: Wait milliseconds multiplied by the
+// BackoffFactor for each previous retry, up to MaxWait.
+func (p *RetryPolicy) WaitFor(attempt int) time.Duration {
+	wait := float64(p.Wait) * float64(time.Millisecond)
+	if p.BackoffFactor > 1 && attempt > 1 {
+		wait *= math.Pow(p.BackoffFactor, float64(attempt-1))
+	}
+	if p.MaxWait > 0 && wait > float64(p.MaxWait) {
+		return p.MaxWait
+	}
+	return time.Duration(wait)
+}
+
+// now returns the current time of the Clock
+func (p *RetryPolicy) now() time.Time {
+	if p.Clock != nil {
+		return p.Clock()
+	}
+	return time.Now()
+}
+
+// ExecuteWithRetry calls the fn, which returns the status code and the error of an attempt, until the policy does
+// not retry its result, and returns the result of the last attempt. The retries stop when the wait would exceed the
+// MaxElapsed budget. The ctx is checked before each attempt and during the waits; once it is done, its error is
+// returned. Without a policy the fn is called once.
+func ExecuteWithRetry(ctx context.Context, policy *RetryPolicy, fn func() (int, error)) (statusCode int, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if policy == nil {
+		return fn()
+	}
+	start := policy.now()
+	statusCode, err = fn()
+	for attempt := 1; policy.ShouldRetry(attempt, statusCode, err); attempt++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return statusCode, ctxErr
+		}
+		wait := policy.WaitFor(attempt)
+		if policy.MaxElapsed > 0 && policy.now().Sub(start)+wait > policy.MaxElapsed {
+			return
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, wait, retryReason(statusCode, err))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return statusCode, ctx.Err()
+		case <-timer.C:
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return statusCode, ctxErr
+		}
+		statusCode, err = fn()
+	}
+	return
+}
+
+// retryReason describes the failure of an attempt for OnRetry
+func retryReason(statusCode int, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "status " + strconv.Itoa(statusCode)
+}
+
+// IsTimeoutError checks if the error is a timeout, such as a net.Error timing out or os.ErrDeadlineExceeded.
+func IsTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// IsConnectionError checks if the error is a refused, reset or aborted connection, or a connection closed before the
+// end of the response.
+func IsConnectionError(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,183 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// script returns a fn of ExecuteWithRetry returning the results in order, the last one repeatedly
+func script(calls *int, results ...func() (int, error)) func() (int, error) {
+	return func() (int, error) {
+		i := *calls
+		*calls++
+		if i >= len(results) {
+			i = len(results) - 1
+		}
+		return results[i]()
+	}
+}
+
+func status(code int) func() (int, error) {
+	return func() (int, error) { return code, nil }
+}
+
+func failure(err error) func() (int, error) {
+	return func() (int, error) { return 0, err }
+}
+
+func TestRetryPolicy_StatusCodes(t *testing.T) {
+	p := &RetryPolicy{RetryInfo: RetryInfo{MaxRetries: 3}}
+	for code, want := range map[int]bool{200: false, 400: false, 404: false, 429: true, 500: true, 503: true} {
+		if got := p.ShouldRetry(1, code, nil); got != want {
+			t.Errorf("ShouldRetry(1, %d, nil) = %v, want %v", code, got, want)
+		}
+	}
+	p.RetryableStatusCodes = []int{409}
+	if !p.ShouldRetry(1, 409, nil) || p.ShouldRetry(1, 503, nil) {
+		t.Errorf("ShouldRetry() does not use the RetryableStatusCodes")
+	}
+	if p.ShouldRetry(4, 409, nil) {
+		t.Errorf("ShouldRetry(4, 409, nil) = true after MaxRetries")
+	}
+}
+
+func TestRetryPolicy_Errors(t *testing.T) {
+	p := &RetryPolicy{RetryInfo: RetryInfo{MaxRetries: 3}}
+	timeout := &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{timeout, true},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("bad request"), false},
+		{context.Canceled, false},
+		{fmt.Errorf("call: %w", context.Canceled), false},
+	}
+	for _, tt := range tests {
+		if got := p.ShouldRetry(1, 0, tt.err); got != tt.want {
+			t.Errorf("ShouldRetry(1, 0, %v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	errBusy := errors.New("busy")
+	p.RetryableErrors = []func(err error) bool{func(err error) bool { return errors.Is(err, errBusy) }}
+	if !p.ShouldRetry(1, 0, errBusy) || p.ShouldRetry(1, 0, timeout) {
+		t.Errorf("ShouldRetry() does not use the RetryableErrors")
+	}
+}
+
+func TestRetryPolicy_WaitFor(t *testing.T) {
+	p := &RetryPolicy{RetryInfo: RetryInfo{Wait: 100}}
+	if p.WaitFor(1) != 100*time.Millisecond || p.WaitFor(5) != 100*time.Millisecond {
+		t.Errorf("WaitFor() = %v, %v, want a constant wait", p.WaitFor(1), p.WaitFor(5))
+	}
+	p.BackoffFactor = 2
+	p.MaxWait = time.Second
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond,
+		4: 800 * time.Millisecond, 5: time.Second} {
+		if got := p.WaitFor(attempt); got != want {
+			t.Errorf("WaitFor(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestExecuteWithRetry(t *testing.T) {
+	var retries []string
+	p := &RetryPolicy{
+		RetryInfo: RetryInfo{MaxRetries: 3, Wait: 1},
+		OnRetry: func(attempt int, wait time.Duration, reason string) {
+			retries = append(retries, fmt.Sprintf("%d %v %s", attempt, wait, reason))
+		},
+	}
+	var calls int
+	code, err := ExecuteWithRetry(context.Background(), p, script(&calls, status(503), failure(io.ErrUnexpectedEOF),
+		status(200)))
+	if code != 200 || err != nil || calls != 3 {
+		t.Errorf("ExecuteWithRetry() = %d, %v after %d calls", code, err, calls)
+	}
+	if len(retries) != 2 || retries[0] != "1 1ms status 503" || retries[1] != "2 1ms unexpected EOF" {
+		t.Errorf("OnRetry() calls = %q", retries)
+	}
+
+	// a failure that is not retried
+	calls = 0
+	code, err = ExecuteWithRetry(context.Background(), p, script(&calls, status(400)))
+	if code != 400 || err != nil || calls != 1 {
+		t.Errorf("ExecuteWithRetry() = %d, %v after %d calls", code, err, calls)
+	}
+
+	// the last failure after the retries
+	calls = 0
+	code, err = ExecuteWithRetry(context.Background(), p, script(&calls, status(503)))
+	if code != 503 || err != nil || calls != 4 {
+		t.Errorf("ExecuteWithRetry() = %d, %v after %d calls", code, err, calls)
+	}
+
+	// without a policy
+	calls = 0
+	code, _ = ExecuteWithRetry(context.Background(), nil, script(&calls, status(503)))
+	if code != 503 || calls != 1 {
+		t.Errorf("ExecuteWithRetry() = %d after %d calls", code, calls)
+	}
+}
+
+func TestExecuteWithRetry_Budget(t *testing.T) {
+	now := time.Unix(0, 0)
+	p := &RetryPolicy{
+		RetryInfo:  RetryInfo{MaxRetries: 10, Wait: 1},
+		MaxElapsed: 30 * time.Millisecond,
+		Clock:      func() time.Time { return now },
+	}
+	var calls int
+	code, err := ExecuteWithRetry(context.Background(), p, script(&calls, func() (int, error) {
+		now = now.Add(10 * time.Millisecond)
+		return 502, nil
+	}))
+	// the wait after the 3rd attempt would end past the budget of 30ms
+	if code != 502 || err != nil || calls != 3 {
+		t.Errorf("ExecuteWithRetry() = %d, %v after %d calls", code, err, calls)
+	}
+}
+
+func TestExecuteWithRetry_Cancel(t *testing.T) {
+	p := &RetryPolicy{RetryInfo: RetryInfo{MaxRetries: 3, Wait: 60000}}
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	code, err := ExecuteWithRetry(ctx, p, script(&calls, status(503)))
+	if !errors.Is(err, context.Canceled) || code != 503 || calls != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("ExecuteWithRetry() = %d, %v after %d calls and %v", code, err, calls, time.Since(start))
+	}
+
+	// the cancellation of an attempt is not retried
+	calls = 0
+	ctx, cancel = context.WithCancel(context.Background())
+	code, err = ExecuteWithRetry(ctx, p, script(&calls, func() (int, error) {
+		cancel()
+		return 0, fmt.Errorf("call: %w", context.Canceled)
+	}))
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("ExecuteWithRetry() = %d, %v after %d calls", code, err, calls)
+	}
+
+	// a done ctx is not called
+	calls = 0
+	_, err = ExecuteWithRetry(ctx, p, script(&calls, status(200)))
+	if !errors.Is(err, context.Canceled) || calls != 0 {
+		t.Errorf("ExecuteWithRetry() = %v after %d calls", err, calls)
+	}
+}
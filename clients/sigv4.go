@@ -0,0 +1,219 @@
+package clients
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm     = "AWS4-HMAC-SHA256"
+	sigV4Request       = "aws4_request"
+	sigV4DateFormat    = "20060102T150405Z"
+	sigV4DateHeader    = "X-Amz-Date"
+	sigV4TokenHeader   = "X-Amz-Security-Token"
+	sigV4ContentHeader = "X-Amz-Content-Sha256"
+	sigV4ServiceS3     = "s3"
+)
+
+// sigV4IgnoredHeaders are the headers that are not signed, since they may be changed on the way
+var sigV4IgnoredHeaders = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+	"expect":          true,
+}
+
+// SigV4Auth is the Auth signing the requests with the AWS Signature Version 4, for the AWS services and the services
+// compatible with them such as S3-compatible storages.
+type SigV4Auth struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+	// SessionToken is the token of the temporary credentials, sent in the X-Amz-Security-Token header if set
+	SessionToken string
+	// Clock is the source of the time of the signatures. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// NewSigV4Auth creates a new SigV4Auth with the credentials, for the service in the region.
+func NewSigV4Auth(accessKey, secretKey, region, service string) *SigV4Auth {
+	return &SigV4Auth{accessKey: accessKey, secretKey: secretKey, region: region, service: service}
+}
+
+// Apply signs the request. It sets the X-Amz-Date header and the Authorization header holding the signature of the
+// canonical request, which is made of the method, the path, the query, the headers and the hash of the payload.
+// The headers of the request are signed, except Authorization, User-Agent, X-Amzn-Trace-Id and Expect. The body is
+// buffered if it cannot be read again with GetBody. For S3, the hash of the payload is sent in the
+// X-Amz-Content-Sha256 header, which may be set to UNSIGNED-PAYLOAD beforehand to not hash the payload.
+func (a *SigV4Auth) Apply(req *http.Request) (err error) {
+	if a.accessKey == "" || a.secretKey == "" || a.region == "" || a.service == "" {
+		return ErrInvalidAuth
+	}
+	now := time.Now
+	if a.Clock != nil {
+		now = a.Clock
+	}
+	t := now().UTC()
+	amzDate := t.Format(sigV4DateFormat)
+	req.Header.Del("Authorization")
+	req.Header.Set(sigV4DateHeader, amzDate)
+	if a.SessionToken != "" {
+		req.Header.Set(sigV4TokenHeader, a.SessionToken)
+	}
+	payloadHash := req.Header.Get(sigV4ContentHeader)
+	if payloadHash == "" {
+		if payloadHash, err = hashPayload(req); err != nil {
+			return
+		}
+		if a.service == sigV4ServiceS3 {
+			req.Header.Set(sigV4ContentHeader, payloadHash)
+		}
+	}
+	canonical, signedHeaders := a.canonicalRequest(req, payloadHash)
+	scope := strings.Join([]string{amzDate[:8], a.region, a.service, sigV4Request}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+a.secretKey), amzDate[:8])
+	for _, part := range []string{a.region, a.service, sigV4Request} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, a.accessKey, scope, signedHeaders, signature))
+	return
+}
+
+// canonicalRequest returns the canonical request of the request and the names of its signed headers
+func (a *SigV4Auth) canonicalRequest(req *http.Request, payloadHash string) (canonical, signedHeaders string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string][]string{"host": {host}}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if !sigV4IgnoredHeaders[name] && name != "host" {
+			headers[name] = append(headers[name], values...)
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		values := make([]string, len(headers[name]))
+		for i, v := range headers[name] {
+			// the values are trimmed and their sequential spaces are collapsed
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+	canonical = strings.Join([]string{req.Method, a.canonicalURI(req.URL), canonicalQuery(req.URL),
+		canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	return
+}
+
+// canonicalURI returns the encoded path of the url. The path is normalized, except for S3 whose keys are kept as
+// they are.
+func (a *SigV4Auth) canonicalURI(u *url.URL) string {
+	p := u.Path
+	if a.service != sigV4ServiceS3 {
+		cleaned := path.Clean("/" + p)
+		if strings.HasSuffix(p, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		p = cleaned
+	}
+	if p == "" {
+		return "/"
+	}
+	return sigV4Escape(p, false)
+}
+
+// canonicalQuery returns the encoded query parameters of the url sorted by name and value
+func canonicalQuery(u *url.URL) string {
+	var params []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		name, _ = url.QueryUnescape(name)
+		value, _ = url.QueryUnescape(value)
+		params = append(params, sigV4Escape(name, true)+"="+sigV4Escape(value, true))
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// sigV4Escape percent encodes the characters of the string other than the unreserved characters of RFC 3986, and
+// the slashes unless encodeSlash is set
+func sigV4Escape(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' ||
+			c == '.' || c == '~' || c == '/' && !encodeSlash {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// hashPayload returns the hex SHA-256 of the body of the request, buffering the body if it cannot be read again
+func hashPayload(req *http.Request) (hash string, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return sha256Hex(nil), nil
+	}
+	if req.GetBody == nil {
+		var data []byte
+		data, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return
+		}
+		req.ContentLength = int64(len(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+		req.Body, _ = req.GetBody()
+	}
+	var body io.ReadCloser
+	if body, err = req.GetBody(); err != nil {
+		return
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, body); err == nil {
+		hash = hex.EncodeToString(h.Sum(nil))
+	}
+	return
+}
+
+// sha256Hex returns the hex SHA-256 of the data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,155 @@
+package clients
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sigV4TestTime is the time of the requests of the AWS Signature Version 4 test suite
+var sigV4TestTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func newTestSigV4Auth(service string) *SigV4Auth {
+	a := NewSigV4Auth("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", service)
+	a.Clock = func() time.Time { return sigV4TestTime }
+	return a
+}
+
+// TestSigV4Auth_TestSuite checks the signatures of the requests of the AWS Signature Version 4 test suite
+func TestSigV4Auth_TestSuite(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		contentType string
+		signed      string
+		signature   string
+	}{
+		{"get-vanilla", "GET", "/", "", "", "host;x-amz-date",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "GET", "/?Param2=value2&Param1=value1", "", "", "host;x-amz-date",
+			"b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-vanilla-empty-query-key", "GET", "/?Param1=value1", "", "", "host;x-amz-date",
+			"a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{"get-unreserved", "GET", "/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", "", "",
+			"host;x-amz-date", "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f"},
+		{"get-utf8", "GET", "/ሴ", "", "", "host;x-amz-date",
+			"8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85"},
+		{"get-space", "GET", "/example space/", "", "", "host;x-amz-date",
+			"652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741"},
+		{"get-relative-relative", "GET", "/example1/example2/../..", "", "", "host;x-amz-date",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-slash-dot-slash", "GET", "/./", "", "", "host;x-amz-date",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", "POST", "/", "", "", "host;x-amz-date",
+			"5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-vanilla-query", "POST", "/?Param1=value1", "", "", "host;x-amz-date",
+			"28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11"},
+		{"post-x-www-form-urlencoded", "POST", "/", "Param1=value1", "application/x-www-form-urlencoded",
+			"content-type;host;x-amz-date", "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+	}
+	a := newTestSigV4Auth("service")
+	for _, tt := range tests {
+		var body io.Reader
+		if tt.body != "" {
+			body = strings.NewReader(tt.body)
+		}
+		req, err := http.NewRequest(tt.method, "https://example.amazonaws.com"+tt.path, body)
+		if err != nil {
+			t.Fatalf("%s: NewRequest() error = %v", tt.name, err)
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if err = a.Apply(req); err != nil {
+			t.Fatalf("%s: Apply() error = %v", tt.name, err)
+		}
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" +
+			tt.signed + ", Signature=" + tt.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s: Authorization = %s, want %s", tt.name, got, want)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: X-Amz-Date = %s", tt.name, got)
+		}
+	}
+}
+
+// TestSigV4Auth_Example checks the signature of the example of the AWS Signature Version 4 documentation
+func TestSigV4Auth_Example(t *testing.T) {
+	a := newTestSigV4Auth("iam")
+	req, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	// the headers that are not signed
+	req.Header.Set("User-Agent", "golly")
+	req.Header.Set("Authorization", "Bearer stale")
+	if err := a.Apply(req); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s, want %s", got, want)
+	}
+}
+
+func TestSigV4Auth_Canonical(t *testing.T) {
+	a := newTestSigV4Auth("service")
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com//example//?b=2&a=x%20y&a=w", nil)
+	req.Header.Add("My-Header1", "  value1  ")
+	req.Header.Add("My-Header2", `"a   b   c"`)
+	req.Header.Add("My-Header3", "v1")
+	req.Header.Add("My-Header3", "v2")
+	canonical, signed := a.canonicalRequest(req, "hash")
+	want := "GET\n/example/\na=w&a=x%20y&b=2\nhost:example.amazonaws.com\nmy-header1:value1\n" +
+		"my-header2:\"a b c\"\nmy-header3:v1,v2\n\nhost;my-header1;my-header2;my-header3\nhash"
+	if canonical != want || signed != "host;my-header1;my-header2;my-header3" {
+		t.Errorf("canonicalRequest() = %q, %q", canonical, signed)
+	}
+
+	// the keys of S3 are not normalized
+	s3 := newTestSigV4Auth("s3")
+	req, _ = http.NewRequest("GET", "https://bucket.s3.amazonaws.com/a//b/../c d", nil)
+	if got := s3.canonicalURI(req.URL); got != "/a//b/../c%20d" {
+		t.Errorf("canonicalURI() = %s", got)
+	}
+}
+
+func TestSigV4Auth_Payload(t *testing.T) {
+	a := newTestSigV4Auth("s3")
+	a.SessionToken = "token"
+	// a body that cannot be read again is buffered
+	req, _ := http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/key", io.NopCloser(strings.NewReader("data")))
+	req.GetBody = nil
+	if err := a.Apply(req); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != sha256Hex([]byte("data")) {
+		t.Errorf("X-Amz-Content-Sha256 = %s", got)
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "token" ||
+		!strings.Contains(req.Header.Get("Authorization"), "x-amz-content-sha256;x-amz-date;x-amz-security-token") {
+		t.Errorf("headers = %v", req.Header)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != "data" || req.ContentLength != 4 {
+		t.Errorf("body = %q of %d bytes", b, req.ContentLength)
+	}
+
+	// the unsigned payloads are not hashed
+	req, _ = http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/key", strings.NewReader("data"))
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	_ = a.Apply(req)
+	canonical, _ := a.canonicalRequest(req, req.Header.Get("X-Amz-Content-Sha256"))
+	if !strings.HasSuffix(canonical, "\nUNSIGNED-PAYLOAD") {
+		t.Errorf("canonicalRequest() = %q", canonical)
+	}
+
+	if err := NewSigV4Auth("", "secret", "us-east-1", "s3").Apply(req); !errors.Is(err, ErrInvalidAuth) {
+		t.Errorf("Apply() error = %v, want ErrInvalidAuth", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -262,6 +263,13 @@ func (bc *BaseCodec) EncodeToString(v interface{}) (string, error) {
 
 func (bc *BaseCodec) Read(r io.Reader, v interface{}) (err error) {
 
+	//Decrypt the fields tagged with encrypt before reading
+	if t := reflect.TypeOf(v); hasEncryptedFields(t) {
+		r, err = bc.decryptFields(r, t)
+		if err != nil {
+			return
+		}
+	}
 	err = bc.readerWriter.Read(r, v)
 	//Check if validation is  required after read
 	if err == nil && bc.options != nil {
@@ -280,6 +288,10 @@ func (bc *BaseCodec) Write(v interface{}, w io.Writer) (err error) {
 			err = structValidator.Validate(v)
 		}
 	}
+	//Encrypt the fields tagged with encrypt before writing
+	if err == nil && hasEncryptedFields(reflect.TypeOf(v)) {
+		v, err = bc.encryptFields(v)
+	}
 	if err == nil {
 		err = bc.readerWriter.Write(v, w)
 	}
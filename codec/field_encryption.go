@@ -0,0 +1,546 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+	"oss.nandlabs.io/golly/textutils"
+)
+
+const (
+	// EncryptTag is the struct tag marking the fields that are encrypted on encode. i.e. `encrypt:"true"`
+	EncryptTag = "encrypt"
+	// FieldEncryptorOpt is the codec option to use a FieldEncryptor other than the registered one
+	FieldEncryptorOpt = "FieldEncryptor"
+	// FieldEnvelopeVersion is the version of the envelope format produced by the codec
+	FieldEnvelopeVersion = "v1"
+	fieldEnvelopePrefix  = "$enc$"
+	fieldEnvelopeSep     = "$"
+)
+
+var ErrNoFieldEncryptor = errors.New("no field encryptor available")
+var ErrInvalidFieldEnvelope = errors.New("invalid field envelope")
+var ErrFieldDecryption = errors.New("unable to decrypt field")
+var ErrFieldEncryptionUnsupported = errors.New("field encryption is not supported")
+var ErrDuplicateFieldKey = errors.New("duplicate field key")
+
+var envelopeEncoding = base64.RawURLEncoding
+
+// FieldEnvelope is an encrypted value of a field. Its string form is
+// $enc$<version>$<algorithm>$<key id>$<nonce>$<ciphertext> with the nonce and the ciphertext base64 (url) encoded.
+type FieldEnvelope struct {
+	Version    string
+	Algorithm  string
+	KeyId      string
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// String returns the compact form of the envelope
+func (e *FieldEnvelope) String() string {
+	version := e.Version
+	if version == textutils.EmptyStr {
+		version = FieldEnvelopeVersion
+	}
+	return fieldEnvelopePrefix + strings.Join([]string{version, e.Algorithm, e.KeyId,
+		envelopeEncoding.EncodeToString(e.Nonce), envelopeEncoding.EncodeToString(e.Ciphertext)}, fieldEnvelopeSep)
+}
+
+// AdditionalData returns the header of the envelope (version, algorithm and key id). Encryptors authenticate it
+// along with the ciphertext so that the header cannot be altered either.
+func (e *FieldEnvelope) AdditionalData() []byte {
+	return []byte(fieldEnvelopePrefix + e.Version + fieldEnvelopeSep + e.Algorithm + fieldEnvelopeSep + e.KeyId)
+}
+
+// IsFieldEnvelope reports whether the string is in the envelope form
+func IsFieldEnvelope(s string) bool {
+	return strings.HasPrefix(s, fieldEnvelopePrefix)
+}
+
+// ParseFieldEnvelope parses the compact form of the envelope
+func ParseFieldEnvelope(s string) (envelope *FieldEnvelope, err error) {
+	if !IsFieldEnvelope(s) {
+		err = ErrInvalidFieldEnvelope
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(s, fieldEnvelopePrefix), fieldEnvelopeSep)
+	if len(parts) != 5 {
+		err = ErrInvalidFieldEnvelope
+		return
+	}
+	if parts[0] != FieldEnvelopeVersion {
+		err = fmt.Errorf("%w: unsupported version %s", ErrInvalidFieldEnvelope, parts[0])
+		return
+	}
+	envelope = &FieldEnvelope{Version: parts[0], Algorithm: parts[1], KeyId: parts[2]}
+	envelope.Nonce, err = envelopeEncoding.DecodeString(parts[3])
+	if err == nil {
+		envelope.Ciphertext, err = envelopeEncoding.DecodeString(parts[4])
+	}
+	if err != nil {
+		envelope = nil
+		err = fmt.Errorf("%w: %v", ErrInvalidFieldEnvelope, err)
+	}
+	return
+}
+
+// FieldEncryptor encrypts and decrypts the values of the fields tagged with `encrypt:"true"`.
+// Decrypt must authenticate the envelope and fail if it was tampered with.
+type FieldEncryptor interface {
+	// Encrypt seals the plaintext in an envelope
+	Encrypt(plaintext []byte) (*FieldEnvelope, error)
+	// Decrypt opens the envelope using the key identified by its key id
+	Decrypt(envelope *FieldEnvelope) ([]byte, error)
+}
+
+var fieldEncryptorMutex sync.RWMutex
+var fieldEncryptor FieldEncryptor
+
+// RegisterFieldEncryptor registers the FieldEncryptor used by the codecs that do not have the FieldEncryptorOpt option
+func RegisterFieldEncryptor(encryptor FieldEncryptor) {
+	fieldEncryptorMutex.Lock()
+	defer fieldEncryptorMutex.Unlock()
+	fieldEncryptor = encryptor
+}
+
+// LockedField holds an encrypted value as is. Use it as the type of an encrypted field to read documents
+// without the decryption key; the value is written back unchanged.
+type LockedField struct {
+	envelope string
+}
+
+// NewLockedField creates a LockedField from the compact form of an envelope
+func NewLockedField(envelope string) (l LockedField, err error) {
+	if _, err = ParseFieldEnvelope(envelope); err == nil {
+		l.envelope = envelope
+	}
+	return
+}
+
+// Envelope returns the compact form of the envelope
+func (l LockedField) Envelope() string {
+	return l.envelope
+}
+
+// IsZero reports whether the field holds no value
+func (l LockedField) IsZero() bool {
+	return l.envelope == textutils.EmptyStr
+}
+
+// KeyId returns the id of the key the value is encrypted with
+func (l LockedField) KeyId() (keyId string) {
+	if envelope, err := ParseFieldEnvelope(l.envelope); err == nil {
+		keyId = envelope.KeyId
+	}
+	return
+}
+
+// String never exposes the value
+func (l LockedField) String() string {
+	return "[encrypted]"
+}
+
+func (l LockedField) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.envelope)
+}
+
+func (l *LockedField) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err == nil {
+		err = l.set(s)
+	}
+	return
+}
+
+func (l LockedField) MarshalYAML() (interface{}, error) {
+	return l.envelope, nil
+}
+
+func (l *LockedField) UnmarshalYAML(node *yaml.Node) (err error) {
+	var s string
+	if err = node.Decode(&s); err == nil {
+		err = l.set(s)
+	}
+	return
+}
+
+func (l *LockedField) set(s string) (err error) {
+	if s != textutils.EmptyStr {
+		_, err = ParseFieldEnvelope(s)
+	}
+	if err == nil {
+		l.envelope = s
+	}
+	return
+}
+
+var lockedFieldType = reflect.TypeOf(LockedField{})
+
+// fieldFormat is implemented by the ReaderWriters supporting the encrypted fields.
+// The value is encoded to a generic tree, the encrypted fields of the tree are replaced and the tree is encoded.
+type fieldFormat interface {
+	// fieldName returns the name of the struct field in the format and whether its fields are inlined
+	fieldName(field reflect.StructField) (name string, inline bool)
+	// marshal encodes the value in the format
+	marshal(v interface{}) ([]byte, error)
+	// unmarshalTree decodes the encoded value to a generic tree
+	unmarshalTree(b []byte) (interface{}, error)
+	// fieldKeys returns the keys of the tree map that are decoded to the field with the name
+	fieldKeys(m map[string]interface{}, name string) []string
+}
+
+func (j *jsonRW) fieldName(field reflect.StructField) (name string, inline bool) {
+	name, _, _ = strings.Cut(field.Tag.Get("json"), ",")
+	inline = name == textutils.EmptyStr && field.Anonymous && indirect(field.Type).Kind() == reflect.Struct
+	if name == textutils.EmptyStr {
+		name = field.Name
+	}
+	return
+}
+
+func (j *jsonRW) marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (j *jsonRW) unmarshalTree(b []byte) (tree interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	// numbers are kept as is
+	decoder.UseNumber()
+	err = decoder.Decode(&tree)
+	return
+}
+
+// fieldKeys returns the keys matching the name case-insensitively as encoding/json does
+func (j *jsonRW) fieldKeys(m map[string]interface{}, name string) (keys []string) {
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (y *yamlRW) fieldName(field reflect.StructField) (name string, inline bool) {
+	var opts string
+	name, opts, _ = strings.Cut(field.Tag.Get("yaml"), ",")
+	inline = strings.Contains(opts, "inline")
+	if name == textutils.EmptyStr {
+		name = strings.ToLower(field.Name)
+	}
+	return
+}
+
+func (y *yamlRW) marshal(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (y *yamlRW) unmarshalTree(b []byte) (tree interface{}, err error) {
+	err = yaml.Unmarshal(b, &tree)
+	return
+}
+
+func (y *yamlRW) fieldKeys(m map[string]interface{}, name string) (keys []string) {
+	if _, ok := m[name]; ok {
+		keys = []string{name}
+	}
+	return
+}
+
+// structField is a field of a struct with its name in the format
+type structField struct {
+	index   []int
+	name    string
+	encrypt bool
+	typ     reflect.Type
+}
+
+// fieldsOf returns the fields of the struct type in the format. Fields of inlined structs are flattened.
+func fieldsOf(t reflect.Type, format fieldFormat) (fields []structField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		name, inline := format.fieldName(f)
+		if name == "-" {
+			continue
+		}
+		if inline && f.Tag.Get(EncryptTag) != "true" {
+			for _, inner := range fieldsOf(indirect(f.Type), format) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if f.IsExported() {
+			fields = append(fields, structField{index: []int{i}, name: name, encrypt: f.Tag.Get(EncryptTag) == "true",
+				typ: f.Type})
+		}
+	}
+	return
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+var encryptedTypes sync.Map
+
+// hasEncryptedFields reports whether values of the type have fields tagged with `encrypt:"true"`.
+// Fields of interface types are not inspected.
+func hasEncryptedFields(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	return typeHasEncrypted(t, make(map[reflect.Type]bool))
+}
+
+func typeHasEncrypted(t reflect.Type, visiting map[reflect.Type]bool) (ok bool) {
+	t = indirect(t)
+	if cached, found := encryptedTypes.Load(t); found {
+		return cached.(bool)
+	}
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField() && !ok; i++ {
+			f := t.Field(i)
+			ok = f.Tag.Get(EncryptTag) == "true" || ((f.IsExported() || f.Anonymous) && typeHasEncrypted(f.Type, visiting))
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		ok = typeHasEncrypted(t.Elem(), visiting)
+	}
+	delete(visiting, t)
+	encryptedTypes.Store(t, ok)
+	return
+}
+
+// fieldEncryptor returns the FieldEncryptor of the codec or the registered one
+func (bc *BaseCodec) fieldEncryptor() (encryptor FieldEncryptor) {
+	if bc.options != nil {
+		if v, ok := bc.options[FieldEncryptorOpt]; ok {
+			encryptor, _ = v.(FieldEncryptor)
+		}
+	}
+	if encryptor == nil {
+		fieldEncryptorMutex.RLock()
+		encryptor = fieldEncryptor
+		fieldEncryptorMutex.RUnlock()
+	}
+	return
+}
+
+func (bc *BaseCodec) fieldFormat() (format fieldFormat, err error) {
+	var ok bool
+	if format, ok = bc.readerWriter.(fieldFormat); !ok {
+		err = fmt.Errorf("%w: %v", ErrFieldEncryptionUnsupported, bc.MimeTypes())
+	}
+	return
+}
+
+// encryptFields returns the generic tree of the value with the encrypted fields replaced by their envelopes.
+// LockedField fields are already encrypted and are kept as is.
+func (bc *BaseCodec) encryptFields(v interface{}) (tree interface{}, err error) {
+	var format fieldFormat
+	var b []byte
+	format, err = bc.fieldFormat()
+	if err == nil {
+		b, err = format.marshal(v)
+		if err == nil {
+			tree, err = format.unmarshalTree(b)
+			if err == nil {
+				tree, err = encryptNode(tree, reflect.ValueOf(v), format, bc.fieldEncryptor())
+			}
+		}
+	}
+	return
+}
+
+func encryptNode(node interface{}, v reflect.Value, format fieldFormat, encryptor FieldEncryptor) (
+	result interface{}, err error) {
+	result = node
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, f := range fieldsOf(v.Type(), format) {
+			child, exists := m[f.name]
+			if !exists {
+				continue
+			}
+			var fv reflect.Value
+			if fv, err = v.FieldByIndexErr(f.index); err != nil {
+				// field of a nil embedded struct
+				err = nil
+				continue
+			}
+			switch {
+			case f.encrypt && f.typ != lockedFieldType:
+				var b []byte
+				var envelope *FieldEnvelope
+				if encryptor == nil {
+					err = ErrNoFieldEncryptor
+				} else if b, err = format.marshal(fv.Interface()); err == nil {
+					if envelope, err = encryptor.Encrypt(b); err == nil {
+						m[f.name] = envelope.String()
+					}
+				}
+			case !f.encrypt && hasEncryptedFields(f.typ):
+				m[f.name], err = encryptNode(child, fv, format, encryptor)
+			}
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		s, ok := node.([]interface{})
+		if !ok {
+			return
+		}
+		for i := 0; i < len(s) && i < v.Len(); i++ {
+			if s[i], err = encryptNode(s[i], v.Index(i), format, encryptor); err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		m, ok := node.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if child, exists := m[key]; exists {
+				if m[key], err = encryptNode(child, iter.Value(), format, encryptor); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// decryptFields returns a reader of the document with the envelopes of the encrypted fields of the type replaced
+// by their decrypted values. Envelopes of LockedField fields are kept as is. The encrypted fields holding a value
+// other than an envelope or null fail with ErrFieldDecryption.
+func (bc *BaseCodec) decryptFields(r io.Reader, t reflect.Type) (result io.Reader, err error) {
+	var format fieldFormat
+	var b []byte
+	var tree interface{}
+	format, err = bc.fieldFormat()
+	if err == nil {
+		b, err = io.ReadAll(r)
+		if err == nil {
+			tree, err = format.unmarshalTree(b)
+			if err == nil {
+				tree, err = decryptNode(tree, t, format, bc.fieldEncryptor())
+				if err == nil {
+					b, err = format.marshal(tree)
+					result = bytes.NewReader(b)
+				}
+			}
+		}
+	}
+	return
+}
+
+func decryptNode(node interface{}, t reflect.Type, format fieldFormat, encryptor FieldEncryptor) (
+	result interface{}, err error) {
+	result = node
+	t = indirect(t)
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, f := range fieldsOf(t, format) {
+			keys := format.fieldKeys(m, f.name)
+			if f.encrypt && f.typ != lockedFieldType && len(keys) > 1 {
+				// the decoder would pick one of the values, which must not be a plaintext one
+				err = fmt.Errorf("%w %s: %w %v", ErrFieldDecryption, f.name, ErrDuplicateFieldKey, keys)
+				return
+			}
+			for _, key := range keys {
+				child := m[key]
+				switch {
+				case f.encrypt && f.typ != lockedFieldType:
+					if child == nil {
+						// a null field has no value to decrypt
+						continue
+					}
+					s, isStr := child.(string)
+					if !isStr || !IsFieldEnvelope(s) {
+						// a plaintext value must not replace the ciphertext unnoticed
+						err = fmt.Errorf("%w %s: %w", ErrFieldDecryption, f.name, ErrInvalidFieldEnvelope)
+					} else if m[key], err = decryptValue(s, format, encryptor); err != nil {
+						err = fmt.Errorf("%w %s: %w", ErrFieldDecryption, f.name, err)
+					}
+				case !f.encrypt && hasEncryptedFields(f.typ):
+					m[key], err = decryptNode(child, f.typ, format, encryptor)
+				}
+				if err != nil {
+					return
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if s, ok := node.([]interface{}); ok {
+			for i := range s {
+				if s[i], err = decryptNode(s[i], t.Elem(), format, encryptor); err != nil {
+					return
+				}
+			}
+		}
+	case reflect.Map:
+		if m, ok := node.(map[string]interface{}); ok {
+			for key, child := range m {
+				if m[key], err = decryptNode(child, t.Elem(), format, encryptor); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+func decryptValue(s string, format fieldFormat, encryptor FieldEncryptor) (value interface{}, err error) {
+	var envelope *FieldEnvelope
+	var plaintext []byte
+	if encryptor == nil {
+		err = ErrNoFieldEncryptor
+		return
+	}
+	envelope, err = ParseFieldEnvelope(s)
+	if err == nil {
+		plaintext, err = encryptor.Decrypt(envelope)
+		if err == nil {
+			value, err = format.unmarshalTree(plaintext)
+		}
+	}
+	return
+}
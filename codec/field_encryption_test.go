@@ -0,0 +1,218 @@
+package codec
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// reverseEncryptor is a FieldEncryptor reversing the plaintext. It is only meant for the tests.
+type reverseEncryptor struct {
+	keyId string
+}
+
+func (r *reverseEncryptor) Encrypt(plaintext []byte) (*FieldEnvelope, error) {
+	return &FieldEnvelope{Version: FieldEnvelopeVersion, Algorithm: "REV", KeyId: r.keyId, Nonce: []byte{0},
+		Ciphertext: reverse(plaintext)}, nil
+}
+
+func (r *reverseEncryptor) Decrypt(envelope *FieldEnvelope) ([]byte, error) {
+	if envelope.KeyId != r.keyId {
+		return nil, errors.New("unknown key")
+	}
+	return reverse(envelope.Ciphertext), nil
+}
+
+func reverse(b []byte) (r []byte) {
+	r = make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return
+}
+
+type Address struct {
+	City   string `json:"city" yaml:"city"`
+	Street string `json:"street" yaml:"street" encrypt:"true"`
+}
+
+type Account struct {
+	Number  int64 `json:"number" yaml:"number" encrypt:"true"`
+	Balance int64 `json:"balance" yaml:"balance"`
+}
+
+type Customer struct {
+	Name     string    `json:"name" yaml:"name"`
+	SSN      string    `json:"ssn" yaml:"ssn" encrypt:"true"`
+	Tags     []string  `json:"tags" yaml:"tags" encrypt:"true"`
+	Address  *Address  `json:"address,omitempty" yaml:"address,omitempty"`
+	Accounts []Account `json:"accounts" yaml:"accounts"`
+	Token    *string   `json:"token,omitempty" yaml:"token,omitempty" encrypt:"true"`
+}
+
+type LockedCustomer struct {
+	Name    string      `json:"name" yaml:"name"`
+	SSN     LockedField `json:"ssn" yaml:"ssn" encrypt:"true"`
+	Tags    LockedField `json:"tags" yaml:"tags" encrypt:"true"`
+	Address *struct {
+		City   string      `json:"city" yaml:"city"`
+		Street LockedField `json:"street" yaml:"street" encrypt:"true"`
+	} `json:"address,omitempty" yaml:"address,omitempty"`
+	Accounts []struct {
+		Number  LockedField `json:"number" yaml:"number"`
+		Balance int64       `json:"balance" yaml:"balance"`
+	} `json:"accounts" yaml:"accounts"`
+}
+
+func newCustomer() Customer {
+	token := "secret-token"
+	return Customer{
+		Name:     "John",
+		SSN:      "123-45-6789",
+		Tags:     []string{"vip", "gold"},
+		Address:  &Address{City: "Springfield", Street: "742 Evergreen Terrace"},
+		Accounts: []Account{{Number: 9007199254740993, Balance: 100}, {Number: 42, Balance: -5}},
+		Token:    &token,
+	}
+}
+
+func fieldCodecs(t *testing.T, encryptor FieldEncryptor) map[string]Codec {
+	codecs := make(map[string]Codec)
+	for _, contentType := range []string{ioutils.MimeApplicationJSON, ioutils.MimeTextYAML} {
+		c, err := Get(contentType, map[string]interface{}{FieldEncryptorOpt: encryptor})
+		assert.NoError(t, err)
+		codecs[contentType] = c
+	}
+	return codecs
+}
+
+func TestFieldEncryption_RoundTrip(t *testing.T) {
+	for contentType, c := range fieldCodecs(t, &reverseEncryptor{keyId: "k1"}) {
+		t.Run(contentType, func(t *testing.T) {
+			customer := newCustomer()
+			encoded, err := c.EncodeToString(customer)
+			assert.NoError(t, err)
+			for _, plaintext := range []string{"123-45-6789", "vip", "742 Evergreen Terrace", "secret-token",
+				"9007199254740993"} {
+				assert.False(t, strings.Contains(encoded, plaintext))
+			}
+			assert.True(t, strings.Contains(encoded, "Springfield"))
+			assert.True(t, strings.Contains(encoded, "$enc$v1$REV$k1$"))
+
+			var decoded Customer
+			assert.NoError(t, c.DecodeString(encoded, &decoded))
+			assert.Equal(t, customer, decoded)
+
+			// pointers to the value are encrypted as well
+			encoded, err = c.EncodeToString(&customer)
+			assert.NoError(t, err)
+			assert.False(t, strings.Contains(encoded, "123-45-6789"))
+		})
+	}
+}
+
+func TestFieldEncryption_LockedField(t *testing.T) {
+	for contentType, c := range fieldCodecs(t, &reverseEncryptor{keyId: "k1"}) {
+		t.Run(contentType, func(t *testing.T) {
+			encoded, err := c.EncodeToString(newCustomer())
+			assert.NoError(t, err)
+
+			// a consumer without the key reads the rest of the document
+			noKey, err := GetDefault(contentType)
+			assert.NoError(t, err)
+			var locked LockedCustomer
+			assert.NoError(t, noKey.DecodeString(encoded, &locked))
+			assert.Equal(t, "John", locked.Name)
+			assert.Equal(t, "k1", locked.SSN.KeyId())
+			assert.Equal(t, "[encrypted]", locked.SSN.String())
+			assert.Equal(t, "Springfield", locked.Address.City)
+			assert.Len(t, locked.Accounts, 2)
+			assert.Equal(t, int64(100), locked.Accounts[0].Balance)
+			assert.False(t, locked.Accounts[0].Number.IsZero())
+
+			// the locked fields are written back unchanged and can be decrypted with the key
+			reencoded, err := noKey.EncodeToString(locked)
+			assert.NoError(t, err)
+			var decoded Customer
+			assert.NoError(t, c.DecodeString(reencoded, &decoded))
+			assert.Equal(t, "123-45-6789", decoded.SSN)
+			assert.Equal(t, []string{"vip", "gold"}, decoded.Tags)
+			assert.Equal(t, "742 Evergreen Terrace", decoded.Address.Street)
+			assert.Equal(t, int64(9007199254740993), decoded.Accounts[0].Number)
+
+			// the typed fields cannot be decrypted without the key
+			err = noKey.DecodeString(encoded, &decoded)
+			assert.True(t, errors.Is(err, ErrFieldDecryption))
+			assert.True(t, errors.Is(err, ErrNoFieldEncryptor))
+			wrongKey := fieldCodecs(t, &reverseEncryptor{keyId: "k2"})[contentType]
+			assert.True(t, errors.Is(wrongKey.DecodeString(encoded, &decoded), ErrFieldDecryption))
+		})
+	}
+}
+
+func TestFieldEncryption_Errors(t *testing.T) {
+	_, err := JsonCodec().EncodeToString(newCustomer())
+	assert.True(t, errors.Is(err, ErrNoFieldEncryptor))
+
+	xmlCodec, err := Get(ioutils.MimeTextXML, map[string]interface{}{FieldEncryptorOpt: &reverseEncryptor{}})
+	assert.NoError(t, err)
+	_, err = xmlCodec.EncodeToString(newCustomer())
+	assert.True(t, errors.Is(err, ErrFieldEncryptionUnsupported))
+
+	var locked LockedCustomer
+	err = JsonCodec().DecodeString(`{"name":"John","ssn":"not an envelope"}`, &locked)
+	assert.True(t, errors.Is(err, ErrInvalidFieldEnvelope))
+	// the encrypted fields holding plaintext values are rejected, the null ones are decoded as zero values
+	for contentType, c := range fieldCodecs(t, &reverseEncryptor{keyId: "k1"}) {
+		t.Run(contentType, func(t *testing.T) {
+			var decoded Customer
+			err := c.DecodeString(`{"name":"John","ssn":"attacker-plaintext"}`, &decoded)
+			assert.True(t, errors.Is(err, ErrFieldDecryption))
+			assert.True(t, errors.Is(err, ErrInvalidFieldEnvelope))
+			err = c.DecodeString(`{"name":"John","tags":["vip"]}`, &decoded)
+			assert.True(t, errors.Is(err, ErrFieldDecryption))
+			var account Account
+			err = c.DecodeString(`{"number":1234,"balance":1}`, &account)
+			assert.True(t, errors.Is(err, ErrFieldDecryption))
+			decoded = Customer{}
+			assert.NoError(t, c.DecodeString(`{"name":"John","ssn":null,"token":null}`, &decoded))
+			assert.Equal(t, "", decoded.SSN)
+			assert.True(t, decoded.Token == nil)
+		})
+	}
+	_, err = ParseFieldEnvelope("$enc$v9$REV$k1$AA$AA")
+	assert.True(t, errors.Is(err, ErrInvalidFieldEnvelope))
+	_, err = ParseFieldEnvelope("$enc$v1$REV$k1$!!$AA")
+	assert.True(t, errors.Is(err, ErrInvalidFieldEnvelope))
+
+	// the registered encryptor is used when the codec has none
+	RegisterFieldEncryptor(&reverseEncryptor{keyId: "registered"})
+	defer RegisterFieldEncryptor(nil)
+	encoded, err := JsonCodec().EncodeToString(newCustomer())
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(encoded, "$enc$v1$REV$registered$"))
+}
+
+func TestFieldEncryption_CaseInsensitiveKeys(t *testing.T) {
+	c := fieldCodecs(t, &reverseEncryptor{keyId: "k1"})[ioutils.MimeApplicationJSON]
+	encoded, err := c.EncodeToString(newCustomer())
+	assert.NoError(t, err)
+	var decoded Customer
+	// encoding/json matches the keys case-insensitively, so do the encrypted fields
+	err = c.DecodeString(`{"name":"John","SSN":"plain-injected"}`, &decoded)
+	assert.True(t, errors.Is(err, ErrInvalidFieldEnvelope))
+	err = c.DecodeString(`{"name":"John","address":{"City":"x","STREET":"plain-injected"}}`, &decoded)
+	assert.True(t, errors.Is(err, ErrInvalidFieldEnvelope))
+	// several case variants of an encrypted key are rejected, whichever value the decoder would pick
+	injected := strings.Replace(encoded, `{`, `{"Ssn":"plain-injected",`, 1)
+	err = c.DecodeString(injected, &decoded)
+	assert.True(t, errors.Is(err, ErrFieldDecryption))
+	assert.True(t, errors.Is(err, ErrDuplicateFieldKey))
+	// the envelope of a differently cased key is decrypted
+	decoded = Customer{}
+	assert.NoError(t, c.DecodeString(strings.Replace(encoded, `"ssn"`, `"SSN"`, 1), &decoded))
+	assert.Equal(t, "123-45-6789", decoded.SSN)
+}
@@ -81,6 +81,25 @@ func (sv *StructValidator) Validate(v interface{}) error {
 	return nil
 }
 
+// ValidateAll validates all the fields of the struct and returns the first error of every field violating its
+// constraints by the name of the field
+func (sv *StructValidator) ValidateAll(v interface{}) (errs map[string]error) {
+	sv.fields = sv.cachedTypeFields(v)
+	errs = make(map[string]error)
+	for _, field := range sv.fields.list {
+		if (reflect.DeepEqual(field.constraints[0], tStruct{})) {
+			continue
+		}
+		for _, val := range field.constraints {
+			if err := val.fnc(field, val.value); err != nil {
+				errs[field.name] = err
+				break
+			}
+		}
+	}
+	return
+}
+
 func (sv *StructValidator) validateFields() error {
 	for _, field := range sv.fields.list {
 		// check if the constraints tag is present or not, skip any kind of validation for which the constraints are not passed
@@ -555,3 +555,26 @@ func TestCacheErrs(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateAll(t *testing.T) {
+	input := struct {
+		Name   string `json:"name" constraints:"min-length=5"`
+		Age    int    `json:"age" constraints:"min=10"`
+		Mobile int    `json:"mobile" constraints:"min=100;max=999"`
+		Mode   string `json:"mode" constraints:"enum=dev,prod"`
+	}{Name: "Tom", Age: 20, Mobile: 1000, Mode: "test"}
+	errs := sv.ValidateAll(input)
+	want := map[string]string{
+		"Name":   "min-length validation failed for field Name",
+		"Mobile": "max value validation failed for field Mobile",
+		"Mode":   "enum validation failed for field Mode",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("ValidateAll() = %v, want %v", errs, want)
+	}
+	for name, msg := range want {
+		if errs[name] == nil || errs[name].Error() != msg {
+			t.Errorf("ValidateAll()[%s] = %v, want %s", name, errs[name], msg)
+		}
+	}
+}
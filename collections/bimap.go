@@ -0,0 +1,219 @@
+package collections
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrValueExists is returned by Put when the value is already mapped to another key
+var ErrValueExists = errors.New("value already exists for another key")
+
+// BiMap is a map whose values are unique, so that a key can be looked up by its value as well as a value by its key
+type BiMap[K comparable, V comparable] struct {
+	forward  map[K]V
+	backward map[V]K
+	inverse  *BiMap[V, K]
+}
+
+// NewBiMap creates a new BiMap
+func NewBiMap[K comparable, V comparable]() *BiMap[K, V] {
+	m := &BiMap[K, V]{forward: make(map[K]V), backward: make(map[V]K)}
+	m.inverse = &BiMap[V, K]{forward: m.backward, backward: m.forward, inverse: m}
+	return m
+}
+
+// Put maps the key to the value, replacing the previous value of the key. It returns ErrValueExists and leaves the
+// map unchanged if the value is mapped to another key.
+func (m *BiMap[K, V]) Put(k K, v V) (err error) {
+	if existing, ok := m.backward[v]; ok && existing != k {
+		err = ErrValueExists
+		return
+	}
+	m.ForcePut(k, v)
+	return
+}
+
+// ForcePut maps the key to the value, removing the previous value of the key and the previous key of the value
+func (m *BiMap[K, V]) ForcePut(k K, v V) {
+	if old, ok := m.forward[k]; ok {
+		delete(m.backward, old)
+	}
+	if old, ok := m.backward[v]; ok {
+		delete(m.forward, old)
+	}
+	m.forward[k] = v
+	m.backward[v] = k
+}
+
+// GetByKey returns the value of the key and true if the key exists
+func (m *BiMap[K, V]) GetByKey(k K) (v V, ok bool) {
+	v, ok = m.forward[k]
+	return
+}
+
+// GetByValue returns the key of the value and true if the value exists
+func (m *BiMap[K, V]) GetByValue(v V) (k K, ok bool) {
+	k, ok = m.backward[v]
+	return
+}
+
+// RemoveByKey removes the key and its value. It returns the removed value and true if the key existed.
+func (m *BiMap[K, V]) RemoveByKey(k K) (v V, ok bool) {
+	if v, ok = m.forward[k]; ok {
+		delete(m.forward, k)
+		delete(m.backward, v)
+	}
+	return
+}
+
+// RemoveByValue removes the value and its key. It returns the removed key and true if the value existed.
+func (m *BiMap[K, V]) RemoveByValue(v V) (k K, ok bool) {
+	return m.inverse.RemoveByKey(v)
+}
+
+// Len returns the number of entries
+func (m *BiMap[K, V]) Len() int {
+	return len(m.forward)
+}
+
+// Keys returns the keys in no particular order
+func (m *BiMap[K, V]) Keys() []K {
+	keys := make([]K, 0, len(m.forward))
+	for k := range m.forward {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Values returns the values in no particular order
+func (m *BiMap[K, V]) Values() []V {
+	return m.inverse.Keys()
+}
+
+// Entries returns the entries in no particular order
+func (m *BiMap[K, V]) Entries() []Entry[K, V] {
+	entries := make([]Entry[K, V], 0, len(m.forward))
+	for k, v := range m.forward {
+		entries = append(entries, Entry[K, V]{Key: k, Value: v})
+	}
+	return entries
+}
+
+// Iterator returns an iterator over a snapshot of the entries in no particular order. Its Remove removes the key of
+// the last entry returned from the map.
+func (m *BiMap[K, V]) Iterator() Iterator[Entry[K, V]] {
+	return &biMapIterator[K, V]{m: m, snapshotIterator: snapshotIterator[Entry[K, V]]{elems: m.Entries()}}
+}
+
+// Inverse returns the view of the map from the values to the keys. The view shares the entries of the map, so the
+// changes made through one are visible through the other.
+func (m *BiMap[K, V]) Inverse() *BiMap[V, K] {
+	return m.inverse
+}
+
+// biMapIterator iterates over a snapshot of the entries of a BiMap
+type biMapIterator[K comparable, V comparable] struct {
+	snapshotIterator[Entry[K, V]]
+	m *BiMap[K, V]
+}
+
+// Remove removes the key of the last entry returned from the map
+func (it *biMapIterator[K, V]) Remove() {
+	if it.index > 0 {
+		it.m.RemoveByKey(it.elems[it.index-1].Key)
+	}
+}
+
+// SyncBiMap is a BiMap safe for concurrent use
+type SyncBiMap[K comparable, V comparable] struct {
+	mutex *sync.RWMutex
+	bimap *BiMap[K, V]
+}
+
+// NewSyncBiMap creates a new SyncBiMap
+func NewSyncBiMap[K comparable, V comparable]() *SyncBiMap[K, V] {
+	return &SyncBiMap[K, V]{mutex: &sync.RWMutex{}, bimap: NewBiMap[K, V]()}
+}
+
+// Put maps the key to the value, replacing the previous value of the key. It returns ErrValueExists and leaves the
+// map unchanged if the value is mapped to another key.
+func (m *SyncBiMap[K, V]) Put(k K, v V) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.bimap.Put(k, v)
+}
+
+// ForcePut maps the key to the value, removing the previous value of the key and the previous key of the value
+func (m *SyncBiMap[K, V]) ForcePut(k K, v V) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.bimap.ForcePut(k, v)
+}
+
+// GetByKey returns the value of the key and true if the key exists
+func (m *SyncBiMap[K, V]) GetByKey(k K) (V, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bimap.GetByKey(k)
+}
+
+// GetByValue returns the key of the value and true if the value exists
+func (m *SyncBiMap[K, V]) GetByValue(v V) (K, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bimap.GetByValue(v)
+}
+
+// RemoveByKey removes the key and its value. It returns the removed value and true if the key existed.
+func (m *SyncBiMap[K, V]) RemoveByKey(k K) (V, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.bimap.RemoveByKey(k)
+}
+
+// RemoveByValue removes the value and its key. It returns the removed key and true if the value existed.
+func (m *SyncBiMap[K, V]) RemoveByValue(v V) (K, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.bimap.RemoveByValue(v)
+}
+
+// Len returns the number of entries
+func (m *SyncBiMap[K, V]) Len() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bimap.Len()
+}
+
+// Keys returns a snapshot of the keys in no particular order
+func (m *SyncBiMap[K, V]) Keys() []K {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bimap.Keys()
+}
+
+// Values returns a snapshot of the values in no particular order
+func (m *SyncBiMap[K, V]) Values() []V {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bimap.Values()
+}
+
+// Entries returns a snapshot of the entries in no particular order
+func (m *SyncBiMap[K, V]) Entries() []Entry[K, V] {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.bimap.Entries()
+}
+
+// Iterator returns an iterator over a snapshot of the entries in no particular order. Its Remove does nothing, use
+// RemoveByKey instead.
+func (m *SyncBiMap[K, V]) Iterator() Iterator[Entry[K, V]] {
+	return &snapshotIterator[Entry[K, V]]{elems: m.Entries()}
+}
+
+// Inverse returns the view of the map from the values to the keys. The view shares the entries and the lock of the
+// map.
+func (m *SyncBiMap[K, V]) Inverse() *SyncBiMap[V, K] {
+	return &SyncBiMap[V, K]{mutex: m.mutex, bimap: m.bimap.Inverse()}
+}
@@ -0,0 +1,189 @@
+package collections
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// checkBiMap verifies that the two directions of the map hold the same entries
+func checkBiMap[K comparable, V comparable](t *testing.T, m *BiMap[K, V]) {
+	assert.Equal(t, len(m.forward), len(m.backward))
+	for k, v := range m.forward {
+		back, ok := m.backward[v]
+		assert.True(t, ok)
+		assert.Equal(t, k, back)
+	}
+}
+
+func TestBiMap_Put(t *testing.T) {
+	m := NewBiMap[int, string]()
+	assert.NoError(t, m.Put(1, "one"))
+	assert.NoError(t, m.Put(2, "two"))
+	// the same entry again is accepted
+	assert.NoError(t, m.Put(1, "one"))
+
+	v, ok := m.GetByKey(1)
+	assert.True(t, ok)
+	assert.Equal(t, "one", v)
+	k, ok := m.GetByValue("two")
+	assert.True(t, ok)
+	assert.Equal(t, 2, k)
+
+	// a value of another key is rejected and nothing changes
+	assert.Equal(t, ErrValueExists, m.Put(3, "one"))
+	_, ok = m.GetByKey(3)
+	assert.False(t, ok)
+	k, _ = m.GetByValue("one")
+	assert.Equal(t, 1, k)
+
+	// a new value replaces the value of the key and frees the old one
+	assert.NoError(t, m.Put(1, "uno"))
+	_, ok = m.GetByValue("one")
+	assert.False(t, ok)
+	assert.Equal(t, 2, m.Len())
+	checkBiMap(t, m)
+
+	// ForcePut takes the value away from its key
+	m.ForcePut(3, "two")
+	_, ok = m.GetByKey(2)
+	assert.False(t, ok)
+	k, _ = m.GetByValue("two")
+	assert.Equal(t, 3, k)
+	// and replaces the value of the key
+	m.ForcePut(3, "uno")
+	assert.Equal(t, 1, m.Len())
+	_, ok = m.GetByValue("two")
+	assert.False(t, ok)
+	checkBiMap(t, m)
+}
+
+func TestBiMap_Remove(t *testing.T) {
+	m := NewBiMap[string, int]()
+	for i, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, m.Put(name, i))
+	}
+	v, ok := m.RemoveByKey("b")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	_, ok = m.GetByValue(1)
+	assert.False(t, ok)
+	_, ok = m.RemoveByKey("b")
+	assert.False(t, ok)
+
+	k, ok := m.RemoveByValue(2)
+	assert.True(t, ok)
+	assert.Equal(t, "c", k)
+	_, ok = m.GetByKey("c")
+	assert.False(t, ok)
+	_, ok = m.RemoveByValue(2)
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"a"}, m.Keys())
+	assert.Equal(t, []int{0}, m.Values())
+	checkBiMap(t, m)
+}
+
+func TestBiMap_Inverse(t *testing.T) {
+	m := NewBiMap[int, string]()
+	inverse := m.Inverse()
+	assert.True(t, inverse.Inverse() == m)
+
+	assert.NoError(t, m.Put(1, "one"))
+	k, ok := inverse.GetByKey("one")
+	assert.True(t, ok)
+	assert.Equal(t, 1, k)
+
+	assert.NoError(t, inverse.Put("two", 2))
+	v, _ := m.GetByKey(2)
+	assert.Equal(t, "two", v)
+	assert.Equal(t, ErrValueExists, inverse.Put("three", 1))
+
+	inverse.RemoveByValue(1)
+	assert.Equal(t, 1, m.Len())
+	keys := inverse.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"two"}, keys)
+	checkBiMap(t, m)
+	checkBiMap(t, inverse)
+}
+
+func TestSyncBiMap(t *testing.T) {
+	m := NewSyncBiMap[int, int]()
+	inverse := m.Inverse()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				switch i % 4 {
+				case 0:
+					_ = m.Put(i%20, (i+g)%20)
+				case 1:
+					m.ForcePut(i%20, g)
+				case 2:
+					inverse.RemoveByKey(i % 20)
+				default:
+					inverse.ForcePut(g, i%20)
+				}
+				m.GetByValue(g)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, m.Len(), inverse.Len())
+	checkBiMap(t, m.bimap)
+	values := m.Values()
+	sort.Ints(values)
+	keys := inverse.Keys()
+	sort.Ints(keys)
+	assert.Equal(t, values, keys)
+}
+
+// TestBiMap_Consistency checks that both directions hold the same entries under random operation sequences, through
+// the map and through its inverse
+func TestBiMap_Consistency(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	m := NewBiMap[int, int]()
+	inverse := m.Inverse()
+	for i := 0; i < 5000; i++ {
+		k, v := r.IntN(16), r.IntN(16)
+		switch r.IntN(6) {
+		case 0:
+			owner, taken := m.GetByValue(v)
+			err := m.Put(k, v)
+			assert.Equal(t, taken && owner != k, err == ErrValueExists)
+		case 1:
+			m.ForcePut(k, v)
+			got, _ := m.GetByKey(k)
+			assert.Equal(t, v, got)
+		case 2:
+			inverse.ForcePut(v, k)
+		case 3:
+			m.RemoveByKey(k)
+		case 4:
+			m.RemoveByValue(v)
+		default:
+			it := m.Iterator()
+			for it.HasNext() {
+				if e := it.Next(); e.Key == k {
+					it.Remove()
+				}
+			}
+			_, ok := m.GetByKey(k)
+			assert.False(t, ok)
+		}
+		checkBiMap(t, m)
+		assert.Equal(t, m.Len(), inverse.Len())
+	}
+	entries := m.Entries()
+	assert.Equal(t, m.Len(), len(entries))
+	for _, e := range entries {
+		k, _ := inverse.GetByKey(e.Value)
+		assert.Equal(t, e.Key, k)
+	}
+}
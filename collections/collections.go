@@ -24,29 +24,37 @@ var ErrInvalidCapacity error = errors.New("invalid capacity")
 // ErrInvalidIndex is an error that is returned when an invalid index is specified
 var ErrInvalidIndex error = errors.New("invalid index")
 
-//Collection is a generic interface that defines a collection of elements with various methods to manipulate them.
-//The Collection interface uses a type parameter T to represent the type of elements stored in the collection.
-
-type Collection[T any] interface {
+// ReadOnlyCollection is the read only part of a Collection. It is satisfied by the mutable collections and by the
+// immutable ones, so it can be accepted where the elements are only read.
+type ReadOnlyCollection[T any] interface {
 	//Include Iterable[T]
 	Iterable[T]
 	// Include Stringer
 	fmt.Stringer
 
+	// Contains checks if an element is in the collection
+	Contains(elem T) bool
+	// Return true if the collection is empty
+	IsEmpty() bool
+	// Return the number of elements in the collection
+	Size() int
+}
+
+//Collection is a generic interface that defines a collection of elements with various methods to manipulate them.
+//The Collection interface uses a type parameter T to represent the type of elements stored in the collection.
+
+type Collection[T any] interface {
+	// Include ReadOnlyCollection[T]
+	ReadOnlyCollection[T]
+
 	// Add an element to the collection
 	Add(elem T) error
 	// AddAll adds all elements from another collection to this collection
 	AddAll(coll Collection[T]) error
 	// Clear removes all elements from the collection
 	Clear()
-	// Contains checks if an element is in the collection
-	Contains(elem T) bool
-	// Return true if the collection is empty
-	IsEmpty() bool
 	// Remove an element from the collection
 	Remove(elem T) bool
-	// Return the number of elements in the collection
-	Size() int
 }
 
 // BoundCollection is a generic interface that defines a bounded collection of elements with various methods to manipulate them.
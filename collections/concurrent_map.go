@@ -0,0 +1,170 @@
+package collections
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// DefaultShardCount is the number of shards of a ConcurrentMap created with NewConcurrentMap
+const DefaultShardCount = 32
+
+// ConcurrentMap is a map safe for concurrent use. The keys are spread by hash across shards, each guarded by its own
+// lock, so that the operations on keys of different shards do not contend.
+type ConcurrentMap[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards []*mapShard[K, V]
+}
+
+type mapShard[K comparable, V any] struct {
+	mutex sync.RWMutex
+	items map[K]V
+}
+
+// NewConcurrentMap creates a new ConcurrentMap with DefaultShardCount shards
+func NewConcurrentMap[K comparable, V any]() *ConcurrentMap[K, V] {
+	return NewConcurrentMapWithShards[K, V](DefaultShardCount)
+}
+
+// NewConcurrentMapWithShards creates a new ConcurrentMap with the number of shards, at least one
+func NewConcurrentMapWithShards[K comparable, V any](shards int) *ConcurrentMap[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	m := &ConcurrentMap[K, V]{seed: maphash.MakeSeed(), shards: make([]*mapShard[K, V], shards)}
+	for i := range m.shards {
+		m.shards[i] = &mapShard[K, V]{items: make(map[K]V)}
+	}
+	return m
+}
+
+// shard returns the shard of the key
+func (m *ConcurrentMap[K, V]) shard(k K) *mapShard[K, V] {
+	return m.shards[maphash.Comparable(m.seed, k)%uint64(len(m.shards))]
+}
+
+// Get returns the value of the key and true if the key exists
+func (m *ConcurrentMap[K, V]) Get(k K) (v V, ok bool) {
+	s := m.shard(k)
+	s.mutex.RLock()
+	v, ok = s.items[k]
+	s.mutex.RUnlock()
+	return
+}
+
+// Put sets the value of the key
+func (m *ConcurrentMap[K, V]) Put(k K, v V) {
+	s := m.shard(k)
+	s.mutex.Lock()
+	s.items[k] = v
+	s.mutex.Unlock()
+}
+
+// Delete removes the key and returns true if it existed
+func (m *ConcurrentMap[K, V]) Delete(k K) (ok bool) {
+	s := m.shard(k)
+	s.mutex.Lock()
+	if _, ok = s.items[k]; ok {
+		delete(s.items, k)
+	}
+	s.mutex.Unlock()
+	return
+}
+
+// Len returns the number of keys. The shards are counted one after the other, so the result may not match any
+// single point in time while the map is modified.
+func (m *ConcurrentMap[K, V]) Len() (n int) {
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		n += len(s.items)
+		s.mutex.RUnlock()
+	}
+	return
+}
+
+// GetOrCompute returns the value of the key, computing and storing it with fn if the key does not exist. fn is
+// called at most once per missing key, with the lock of the shard held, so it must not access the map.
+func (m *ConcurrentMap[K, V]) GetOrCompute(k K, fn func() V) V {
+	s := m.shard(k)
+	s.mutex.RLock()
+	v, ok := s.items[k]
+	s.mutex.RUnlock()
+	if ok {
+		return v
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if v, ok = s.items[k]; !ok {
+		v = fn()
+		s.items[k] = v
+	}
+	return v
+}
+
+// Compute atomically updates the value of the key. fn receives the current value and whether the key exists, and
+// returns the new value and whether to keep the key; returning false deletes the key. Compute returns the new value
+// and whether the key exists after the update. fn is called with the lock of the shard held, so it must not access
+// the map.
+func (m *ConcurrentMap[K, V]) Compute(k K, fn func(old V, exists bool) (V, bool)) (v V, ok bool) {
+	s := m.shard(k)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	old, exists := s.items[k]
+	if v, ok = fn(old, exists); ok {
+		s.items[k] = v
+	} else {
+		delete(s.items, k)
+		var zero V
+		v = zero
+	}
+	return
+}
+
+// Keys returns a snapshot of the keys, taken one shard at a time
+func (m *ConcurrentMap[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Len())
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		for k := range s.items {
+			keys = append(keys, k)
+		}
+		s.mutex.RUnlock()
+	}
+	return keys
+}
+
+// Values returns a snapshot of the values, taken one shard at a time
+func (m *ConcurrentMap[K, V]) Values() []V {
+	values := make([]V, 0, m.Len())
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		for _, v := range s.items {
+			values = append(values, v)
+		}
+		s.mutex.RUnlock()
+	}
+	return values
+}
+
+// Range calls fn for each key and value until fn returns false. Each shard is copied under its lock and fn is called
+// without any lock held, so fn may access the map. The keys added or removed during the iteration may or may not be
+// visited.
+func (m *ConcurrentMap[K, V]) Range(fn func(k K, v V) bool) {
+	type entry struct {
+		k K
+		v V
+	}
+	var entries []entry
+	for _, s := range m.shards {
+		s.mutex.RLock()
+		entries = entries[:0]
+		for k, v := range s.items {
+			entries = append(entries, entry{k, v})
+		}
+		s.mutex.RUnlock()
+		for _, e := range entries {
+			if !fn(e.k, e.v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package collections
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestConcurrentMap_Basic(t *testing.T) {
+	m := NewConcurrentMap[string, int]()
+	_, ok := m.Get("a")
+	assert.False(t, ok)
+	m.Put("a", 1)
+	m.Put("b", 2)
+	m.Put("a", 3)
+	v, ok := m.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+	assert.Equal(t, 2, m.Len())
+
+	keys := m.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	values := m.Values()
+	sort.Ints(values)
+	assert.Equal(t, []int{2, 3}, values)
+
+	assert.True(t, m.Delete("a"))
+	assert.False(t, m.Delete("a"))
+	assert.Equal(t, 1, m.Len())
+
+	// a single shard behaves the same
+	single := NewConcurrentMapWithShards[int, int](0)
+	for i := 0; i < 100; i++ {
+		single.Put(i, i*i)
+	}
+	assert.Equal(t, 100, single.Len())
+	v, _ = single.Get(9)
+	assert.Equal(t, 81, v)
+}
+
+func TestConcurrentMap_Compute(t *testing.T) {
+	m := NewConcurrentMap[string, int]()
+	calls := 0
+	assert.Equal(t, 7, m.GetOrCompute("a", func() int { calls++; return 7 }))
+	assert.Equal(t, 7, m.GetOrCompute("a", func() int { calls++; return 8 }))
+	assert.Equal(t, 1, calls)
+
+	v, ok := m.Compute("a", func(old int, exists bool) (int, bool) {
+		assert.True(t, exists)
+		return old + 1, true
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 8, v)
+	v, ok = m.Compute("new", func(old int, exists bool) (int, bool) {
+		assert.False(t, exists)
+		return 1, true
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	// returning false deletes the key
+	_, ok = m.Compute("a", func(old int, exists bool) (int, bool) { return 0, false })
+	assert.False(t, ok)
+	_, ok = m.Get("a")
+	assert.False(t, ok)
+}
+
+func TestConcurrentMap_Concurrent(t *testing.T) {
+	m := NewConcurrentMapWithShards[int, int](8)
+	var computed atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				m.Compute(i%10, func(old int, _ bool) (int, bool) { return old + 1, true })
+				m.GetOrCompute(100, func() int { computed.Add(1); return 0 })
+			}
+		}()
+	}
+	wg.Wait()
+	for k := 0; k < 10; k++ {
+		v, _ := m.Get(k)
+		assert.Equal(t, 1600, v)
+	}
+	assert.Equal(t, int32(1), computed.Load())
+}
+
+func TestConcurrentMap_Range(t *testing.T) {
+	m := NewConcurrentMapWithShards[int, string](4)
+	for i := 0; i < 50; i++ {
+		m.Put(i, strconv.Itoa(i))
+	}
+	seen := map[int]string{}
+	m.Range(func(k int, v string) bool {
+		// no shard lock is held, so the map can be modified while ranging. The added keys may be visited.
+		if k < 1000 {
+			seen[k] = v
+			m.Put(k+1000, v)
+		}
+		return true
+	})
+	assert.Len(t, seen, 50)
+	assert.Equal(t, 100, m.Len())
+	assert.Equal(t, "7", seen[7])
+
+	visited := 0
+	m.Range(func(k int, v string) bool {
+		visited++
+		return visited < 3
+	})
+	assert.Equal(t, 3, visited)
+}
+
+// mutexMap is a map guarded by a single lock, the baseline of the benchmarks
+type mutexMap struct {
+	mutex sync.RWMutex
+	items map[int]int
+}
+
+func (m *mutexMap) Get(k int) (v int, ok bool) {
+	m.mutex.RLock()
+	v, ok = m.items[k]
+	m.mutex.RUnlock()
+	return
+}
+
+func (m *mutexMap) Put(k, v int) {
+	m.mutex.Lock()
+	m.items[k] = v
+	m.mutex.Unlock()
+}
+
+const benchKeys = 1 << 14
+
+func benchmarkMap(b *testing.B, readPercent int, get func(k int), put func(k int)) {
+	for k := 0; k < benchKeys; k++ {
+		put(k)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			k := r.Intn(benchKeys)
+			if r.Intn(100) < readPercent {
+				get(k)
+			} else {
+				put(k)
+			}
+		}
+	})
+}
+
+func BenchmarkConcurrentMap(b *testing.B) {
+	for _, reads := range []int{90, 50} {
+		name := strconv.Itoa(reads) + "-" + strconv.Itoa(100-reads)
+		b.Run("ConcurrentMap/"+name, func(b *testing.B) {
+			m := NewConcurrentMap[int, int]()
+			benchmarkMap(b, reads, func(k int) { m.Get(k) }, func(k int) { m.Put(k, k) })
+		})
+		b.Run("sync.Map/"+name, func(b *testing.B) {
+			var m sync.Map
+			benchmarkMap(b, reads, func(k int) { m.Load(k) }, func(k int) { m.Store(k, k) })
+		})
+		b.Run("RWMutex/"+name, func(b *testing.B) {
+			m := &mutexMap{items: make(map[int]int)}
+			benchmarkMap(b, reads, func(k int) { m.Get(k) }, func(k int) { m.Put(k, k) })
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package collections
+
+// Map returns the results of the function applied to each element of the source, in the order of its iterator
+func Map[T, U any](src Iterable[T], fn func(T) U) []U {
+	result := make([]U, 0, sizeHint(src))
+	it := src.Iterator()
+	for it.HasNext() {
+		result = append(result, fn(it.Next()))
+	}
+	return result
+}
+
+// Filter returns the elements of the source matching the predicate
+func Filter[T any](src Iterable[T], pred func(T) bool) []T {
+	result := make([]T, 0)
+	it := src.Iterator()
+	for it.HasNext() {
+		if v := it.Next(); pred(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Reduce folds the elements of the source into the accumulator starting from initial
+func Reduce[T, A any](src Iterable[T], initial A, fn func(acc A, elem T) A) A {
+	acc := initial
+	it := src.Iterator()
+	for it.HasNext() {
+		acc = fn(acc, it.Next())
+	}
+	return acc
+}
+
+// Find returns the first element of the source matching the predicate. The iteration stops at the match.
+func Find[T any](src Iterable[T], pred func(T) bool) (elem T, found bool) {
+	it := src.Iterator()
+	for it.HasNext() {
+		if v := it.Next(); pred(v) {
+			return v, true
+		}
+	}
+	return
+}
+
+// Any returns true if an element of the source matches the predicate. The iteration stops at the match.
+func Any[T any](src Iterable[T], pred func(T) bool) bool {
+	_, found := Find(src, pred)
+	return found
+}
+
+// All returns true if every element of the source matches the predicate, true for an empty source. The iteration
+// stops at the first element not matching.
+func All[T any](src Iterable[T], pred func(T) bool) bool {
+	_, found := Find(src, func(v T) bool { return !pred(v) })
+	return !found
+}
+
+// GroupBy groups the elements of the source by their key. The elements of a group keep the order of the iterator.
+func GroupBy[T any, K comparable](src Iterable[T], key func(T) K) map[K][]T {
+	groups := make(map[K][]T)
+	it := src.Iterator()
+	for it.HasNext() {
+		v := it.Next()
+		k := key(v)
+		groups[k] = append(groups[k], v)
+	}
+	return groups
+}
+
+// Partition splits the elements of the source into the ones matching the predicate and the others
+func Partition[T any](src Iterable[T], pred func(T) bool) (matched, rest []T) {
+	matched, rest = make([]T, 0), make([]T, 0)
+	it := src.Iterator()
+	for it.HasNext() {
+		if v := it.Next(); pred(v) {
+			matched = append(matched, v)
+		} else {
+			rest = append(rest, v)
+		}
+	}
+	return
+}
+
+// Chunk splits the elements of the source into chunks of the size, the last one holding the remaining elements.
+// It returns ErrInvalidCapacity if the size is not positive.
+func Chunk[T any](src Iterable[T], size int) (chunks [][]T, err error) {
+	if size <= 0 {
+		err = ErrInvalidCapacity
+		return
+	}
+	chunks = make([][]T, 0)
+	var chunk []T
+	it := src.Iterator()
+	for it.HasNext() {
+		if chunk == nil {
+			chunk = make([]T, 0, size)
+		}
+		chunk = append(chunk, it.Next())
+		if len(chunk) == size {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+	}
+	if chunk != nil {
+		chunks = append(chunks, chunk)
+	}
+	return
+}
+
+// Distinct returns the elements of the source without the duplicates, keeping the first occurrence of each
+func Distinct[T comparable](src Iterable[T]) []T {
+	seen := make(map[T]struct{})
+	result := make([]T, 0)
+	it := src.Iterator()
+	for it.HasNext() {
+		v := it.Next()
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// sizeHint returns the size of the source if it is a collection
+func sizeHint[T any](src Iterable[T]) int {
+	switch s := src.(type) {
+	case Collection[T]:
+		return s.Size()
+	case sliceIterable[T]:
+		return len(s)
+	}
+	return 0
+}
+
+// SliceOf returns the slice as an Iterable so that it can be passed to the functional operations
+func SliceOf[T any](elems []T) Iterable[T] {
+	return sliceIterable[T](elems)
+}
+
+type sliceIterable[T any] []T
+
+func (s sliceIterable[T]) Iterator() Iterator[T] {
+	return &sliceIterator[T]{elems: s}
+}
+
+type sliceIterator[T any] struct {
+	elems []T
+	index int
+}
+
+// HasNext returns true if there are more elements in the slice
+func (it *sliceIterator[T]) HasNext() bool {
+	return it.index < len(it.elems)
+}
+
+// Next returns the next element in the slice
+func (it *sliceIterator[T]) Next() T {
+	v := it.elems[it.index]
+	it.index++
+	return v
+}
+
+// Remove does nothing, the slice is never modified
+func (it *sliceIterator[T]) Remove() {}
+
+// Stream is a lazy sequence of elements. The operations are applied to each element as it is pulled by the terminal
+// operation, so no intermediate collection is created and the iteration stops as soon as the result is known.
+// A Stream can be iterated once.
+type Stream[T any] struct {
+	next func() (T, bool)
+}
+
+// Iter returns a Stream over the elements of the source
+func Iter[T any](src Iterable[T]) *Stream[T] {
+	it := src.Iterator()
+	return &Stream[T]{next: func() (v T, ok bool) {
+		if ok = it.HasNext(); ok {
+			v = it.Next()
+		}
+		return
+	}}
+}
+
+// MapStream returns a Stream of the results of the function applied to each element of the stream. Unlike
+// Stream.Map the type of the elements can change.
+func MapStream[T, U any](s *Stream[T], fn func(T) U) *Stream[U] {
+	return &Stream[U]{next: func() (u U, ok bool) {
+		var v T
+		if v, ok = s.next(); ok {
+			u = fn(v)
+		}
+		return
+	}}
+}
+
+// Filter returns a Stream of the elements matching the predicate
+func (s *Stream[T]) Filter(pred func(T) bool) *Stream[T] {
+	return &Stream[T]{next: func() (v T, ok bool) {
+		for v, ok = s.next(); ok; v, ok = s.next() {
+			if pred(v) {
+				return
+			}
+		}
+		return
+	}}
+}
+
+// Map returns a Stream of the results of the function applied to each element. See MapStream to change the type
+// of the elements.
+func (s *Stream[T]) Map(fn func(T) T) *Stream[T] {
+	return MapStream(s, fn)
+}
+
+// Limit returns a Stream of the first n elements
+func (s *Stream[T]) Limit(n int) *Stream[T] {
+	return &Stream[T]{next: func() (v T, ok bool) {
+		if n > 0 {
+			n--
+			v, ok = s.next()
+		}
+		return
+	}}
+}
+
+// Collect returns the elements of the stream
+func (s *Stream[T]) Collect() []T {
+	result := make([]T, 0)
+	for v, ok := s.next(); ok; v, ok = s.next() {
+		result = append(result, v)
+	}
+	return result
+}
+
+// ForEach calls the function with each element of the stream
+func (s *Stream[T]) ForEach(fn func(T)) {
+	for v, ok := s.next(); ok; v, ok = s.next() {
+		fn(v)
+	}
+}
+
+// Count returns the number of elements of the stream
+func (s *Stream[T]) Count() (n int) {
+	for _, ok := s.next(); ok; _, ok = s.next() {
+		n++
+	}
+	return
+}
+
+// Find returns the first element of the stream matching the predicate, pulling no further element
+func (s *Stream[T]) Find(pred func(T) bool) (T, bool) {
+	return s.Filter(pred).next()
+}
+
+// Any returns true if an element of the stream matches the predicate, pulling no further element
+func (s *Stream[T]) Any(pred func(T) bool) bool {
+	_, found := s.Find(pred)
+	return found
+}
+
+// All returns true if every element of the stream matches the predicate, stopping at the first one not matching
+func (s *Stream[T]) All(pred func(T) bool) bool {
+	return !s.Any(func(v T) bool { return !pred(v) })
+}
+
+// Iterator returns an Iterator over the remaining elements of the stream so that a stream can be passed wherever an
+// Iterable is expected. Remove is not supported and does nothing.
+func (s *Stream[T]) Iterator() Iterator[T] {
+	return &streamIterator[T]{stream: s}
+}
+
+type streamIterator[T any] struct {
+	stream  *Stream[T]
+	peeked  bool
+	hasNext bool
+	next    T
+}
+
+// HasNext returns true if the stream has more elements
+func (it *streamIterator[T]) HasNext() bool {
+	if !it.peeked {
+		it.next, it.hasNext = it.stream.next()
+		it.peeked = true
+	}
+	return it.hasNext
+}
+
+// Next returns the next element of the stream
+func (it *streamIterator[T]) Next() (v T) {
+	if it.HasNext() {
+		v = it.next
+		it.peeked = false
+	}
+	return
+}
+
+// Remove does nothing, the streams are read only
+func (it *streamIterator[T]) Remove() {}
@@ -0,0 +1,182 @@
+package collections
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// sources returns the numbers 1 to 6 in the collections and a slice
+func sources() map[string]Iterable[int] {
+	list := NewArrayList[int]()
+	linked := NewLinkedList[int]()
+	for i := 1; i <= 6; i++ {
+		_ = list.Add(i)
+		_ = linked.Add(i)
+	}
+	return map[string]Iterable[int]{
+		"ArrayList":  list,
+		"LinkedList": linked,
+		"slice":      SliceOf([]int{1, 2, 3, 4, 5, 6}),
+	}
+}
+
+func isEven(v int) bool { return v%2 == 0 }
+
+func TestFunctional_Ordered(t *testing.T) {
+	for name, src := range sources() {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, []string{"1", "2", "3", "4", "5", "6"}, Map(src, strconv.Itoa))
+			assert.Equal(t, []int{2, 4, 6}, Filter(src, isEven))
+			assert.Equal(t, 21, Reduce(src, 0, func(acc, v int) int { return acc + v }))
+			assert.Equal(t, "123456", Reduce(src, "", func(acc string, v int) string { return acc + strconv.Itoa(v) }))
+
+			v, found := Find(src, func(v int) bool { return v > 3 })
+			assert.True(t, found)
+			assert.Equal(t, 4, v)
+			_, found = Find(src, func(v int) bool { return v > 6 })
+			assert.False(t, found)
+			assert.True(t, Any(src, isEven))
+			assert.False(t, All(src, isEven))
+			assert.True(t, All(src, func(v int) bool { return v > 0 }))
+
+			groups := GroupBy(src, func(v int) int { return v % 3 })
+			assert.Equal(t, map[int][]int{0: {3, 6}, 1: {1, 4}, 2: {2, 5}}, groups)
+			even, odd := Partition(src, isEven)
+			assert.Equal(t, []int{2, 4, 6}, even)
+			assert.Equal(t, []int{1, 3, 5}, odd)
+
+			chunks, err := Chunk(src, 4)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]int{{1, 2, 3, 4}, {5, 6}}, chunks)
+			chunks, _ = Chunk(src, 3)
+			assert.Equal(t, [][]int{{1, 2, 3}, {4, 5, 6}}, chunks)
+			_, err = Chunk(src, 0)
+			assert.Equal(t, ErrInvalidCapacity, err)
+		})
+	}
+}
+
+func TestFunctional_HashSet(t *testing.T) {
+	set := NewHashSet[int]()
+	for i := 1; i <= 6; i++ {
+		_ = set.Add(i)
+	}
+	even := Filter(set, isEven)
+	sort.Ints(even)
+	assert.Equal(t, []int{2, 4, 6}, even)
+	assert.Equal(t, 21, Reduce(set, 0, func(acc, v int) int { return acc + v }))
+	assert.Len(t, Map(set, strconv.Itoa), 6)
+	assert.True(t, All(set, func(v int) bool { return v <= 6 }))
+}
+
+func TestFunctional_Empty(t *testing.T) {
+	empty := NewArrayList[int]()
+	assert.Equal(t, []int{}, Filter[int](empty, isEven))
+	assert.Equal(t, []string{}, Map[int](empty, strconv.Itoa))
+	assert.True(t, All[int](empty, isEven))
+	assert.False(t, Any[int](empty, isEven))
+	chunks, err := Chunk[int](empty, 2)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 0)
+	assert.Len(t, GroupBy[int](empty, strconv.Itoa), 0)
+}
+
+func TestDistinct(t *testing.T) {
+	assert.Equal(t, []string{"b", "a", "c"}, Distinct(SliceOf([]string{"b", "a", "b", "c", "a"})))
+	list := NewLinkedList[int]()
+	for _, v := range []int{3, 1, 3, 3, 2, 1} {
+		_ = list.Add(v)
+	}
+	assert.Equal(t, []int{3, 1, 2}, Distinct[int](list))
+}
+
+// countingIterable counts the elements pulled from its iterators
+type countingIterable struct {
+	elems  []int
+	pulled int
+}
+
+func (c *countingIterable) Iterator() Iterator[int] {
+	return &countingIterator{src: c}
+}
+
+type countingIterator struct {
+	src   *countingIterable
+	index int
+}
+
+func (it *countingIterator) HasNext() bool { return it.index < len(it.src.elems) }
+
+func (it *countingIterator) Next() int {
+	it.src.pulled++
+	it.index++
+	return it.src.elems[it.index-1]
+}
+
+func (it *countingIterator) Remove() {}
+
+func TestStream(t *testing.T) {
+	for name, src := range sources() {
+		t.Run(name, func(t *testing.T) {
+			got := Iter(src).Filter(isEven).Map(func(v int) int { return v * 10 }).Collect()
+			assert.Equal(t, []int{20, 40, 60}, got)
+			labels := MapStream(Iter(src).Limit(3), strconv.Itoa).Collect()
+			assert.Equal(t, []string{"1", "2", "3"}, labels)
+			assert.Equal(t, 3, Iter(src).Filter(isEven).Count())
+
+			var seen []int
+			Iter(src).Filter(func(v int) bool { return v > 4 }).ForEach(func(v int) { seen = append(seen, v) })
+			assert.Equal(t, []int{5, 6}, seen)
+
+			// a stream is an Iterable for the eager operations
+			assert.Equal(t, []int{3, 6}, Distinct[int](Iter(src).Filter(func(v int) bool { return v%3 == 0 })))
+		})
+	}
+}
+
+func TestStream_ShortCircuit(t *testing.T) {
+	src := &countingIterable{elems: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+	mapped := 0
+	v, found := Iter[int](src).Map(func(v int) int { mapped++; return v * v }).Find(func(v int) bool { return v > 10 })
+	assert.True(t, found)
+	assert.Equal(t, 16, v)
+	assert.Equal(t, 4, src.pulled)
+	assert.Equal(t, 4, mapped)
+
+	src.pulled = 0
+	assert.True(t, Iter[int](src).Filter(isEven).Any(func(v int) bool { return v == 4 }))
+	assert.Equal(t, 4, src.pulled)
+
+	src.pulled = 0
+	assert.False(t, Iter[int](src).All(func(v int) bool { return v < 3 }))
+	assert.Equal(t, 3, src.pulled)
+
+	src.pulled = 0
+	assert.Equal(t, []int{1, 2}, Iter[int](src).Limit(2).Collect())
+	assert.Equal(t, 2, src.pulled)
+
+	// the eager operations stop at the match too
+	src.pulled = 0
+	assert.True(t, Any[int](src, func(v int) bool { return v == 2 }))
+	assert.Equal(t, 2, src.pulled)
+}
+
+func BenchmarkStream_FilterMap(b *testing.B) {
+	list := NewArrayList[int]()
+	for i := 0; i < 10000; i++ {
+		_ = list.Add(i)
+	}
+	b.Run("eager", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = Map(SliceOf(Filter[int](list, isEven)), func(v int) int { return v * 2 })
+		}
+	})
+	b.Run("lazy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = Iter[int](list).Filter(isEven).Map(func(v int) int { return v * 2 }).Collect()
+		}
+	})
+}
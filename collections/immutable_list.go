@@ -0,0 +1,280 @@
+package collections
+
+import (
+	"fmt"
+	"strings"
+
+	"oss.nandlabs.io/golly/assertion"
+)
+
+const (
+	// vectorBits is the number of index bits consumed by each level of the vector trie
+	vectorBits = 5
+	// vectorWidth is the number of children of a vector trie node and the size of the tail
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+// ImmutableList is a persistent list. It is never modified: Add, Set and Remove return a new version of the list that
+// shares most of its structure with the original, so the previous versions stay valid and can be read by several
+// goroutines without any lock.
+//
+// The elements are stored in a trie of 32 wide nodes with the last elements kept in a separate tail, which gives the
+// following complexities:
+//
+//   - Get and Set are O(log32 n), at most 7 levels for 2^32 elements.
+//   - Add is amortized O(1): it copies the tail of at most 32 elements and, once every 32 elements, one path of the trie.
+//   - Remove and RemoveAt are O(n), the elements after the removed one are shifted.
+//   - Iterator walks the list in O(n) and Size and IsEmpty are O(1).
+type ImmutableList[T any] struct {
+	size  int
+	shift uint
+	root  *vectorNode[T]
+	tail  []T
+}
+
+// vectorNode is a node of the vector trie. The inner nodes have children and the leaves have values.
+type vectorNode[T any] struct {
+	children []*vectorNode[T]
+	values   []T
+}
+
+// NewImmutableList creates a new ImmutableList with the elements
+func NewImmutableList[T any](elems ...T) *ImmutableList[T] {
+	l := &ImmutableList[T]{shift: vectorBits, root: &vectorNode[T]{}}
+	for _, elem := range elems {
+		l = l.Add(elem)
+	}
+	return l
+}
+
+// Freeze creates a new ImmutableList with the elements of the collection, in the order of its iterator
+func Freeze[T any](coll Iterable[T]) *ImmutableList[T] {
+	l := NewImmutableList[T]()
+	it := coll.Iterator()
+	for it.HasNext() {
+		l = l.Add(it.Next())
+	}
+	return l
+}
+
+// Thaw returns a new ArrayList with the elements of the list. Changing the ArrayList does not affect the list.
+func (l *ImmutableList[T]) Thaw() *ArrayList[T] {
+	al := &ArrayList[T]{elements: make([]T, 0, l.size)}
+	it := l.Iterator()
+	for it.HasNext() {
+		al.elements = append(al.elements, it.Next())
+	}
+	return al
+}
+
+// tailOffset returns the index of the first element of the tail
+func (l *ImmutableList[T]) tailOffset() int {
+	if l.size < vectorWidth {
+		return 0
+	}
+	return ((l.size - 1) >> vectorBits) << vectorBits
+}
+
+// leaf returns the values of the leaf or the tail holding the index
+func (l *ImmutableList[T]) leaf(index int) []T {
+	if index >= l.tailOffset() {
+		return l.tail
+	}
+	node := l.root
+	for level := l.shift; level > 0; level -= vectorBits {
+		node = node.children[(index>>level)&vectorMask]
+	}
+	return node.values
+}
+
+// Get returns the element at the specified index
+func (l *ImmutableList[T]) Get(index int) (v T, err error) {
+	if index < 0 || index >= l.size {
+		err = ErrIndexOutOfBounds
+		return
+	}
+	v = l.leaf(index)[index&vectorMask]
+	return
+}
+
+// Add returns a new list with the element appended
+func (l *ImmutableList[T]) Add(elem T) *ImmutableList[T] {
+	if l.size-l.tailOffset() < vectorWidth {
+		tail := make([]T, len(l.tail), len(l.tail)+1)
+		copy(tail, l.tail)
+		return &ImmutableList[T]{size: l.size + 1, shift: l.shift, root: l.root, tail: append(tail, elem)}
+	}
+	// the tail is full, it moves into the trie and a new tail is started
+	full := &vectorNode[T]{values: l.tail}
+	root, shift := l.root, l.shift
+	if (l.size >> vectorBits) > (1 << shift) {
+		// the trie is full, it gets a new level
+		root = &vectorNode[T]{children: []*vectorNode[T]{l.root, newVectorPath(shift, full)}}
+		shift += vectorBits
+	} else {
+		root = l.pushTail(shift, l.root, full)
+	}
+	return &ImmutableList[T]{size: l.size + 1, shift: shift, root: root, tail: []T{elem}}
+}
+
+// pushTail returns a copy of the path to the last leaf of the node with the full tail added as the last leaf
+func (l *ImmutableList[T]) pushTail(level uint, parent, full *vectorNode[T]) *vectorNode[T] {
+	index := ((l.size - 1) >> level) & vectorMask
+	node := &vectorNode[T]{children: make([]*vectorNode[T], len(parent.children), index+1)}
+	copy(node.children, parent.children)
+	var child *vectorNode[T]
+	if level == vectorBits {
+		child = full
+	} else if index < len(parent.children) {
+		child = l.pushTail(level-vectorBits, parent.children[index], full)
+	} else {
+		child = newVectorPath(level-vectorBits, full)
+	}
+	if index < len(node.children) {
+		node.children[index] = child
+	} else {
+		node.children = append(node.children, child)
+	}
+	return node
+}
+
+// newVectorPath returns the chain of nodes from the level down to the leaf
+func newVectorPath[T any](level uint, leaf *vectorNode[T]) *vectorNode[T] {
+	if level == 0 {
+		return leaf
+	}
+	return &vectorNode[T]{children: []*vectorNode[T]{newVectorPath(level-vectorBits, leaf)}}
+}
+
+// Set returns a new list with the element at the specified index replaced
+func (l *ImmutableList[T]) Set(index int, elem T) (list *ImmutableList[T], err error) {
+	if index < 0 || index >= l.size {
+		err = ErrIndexOutOfBounds
+		return
+	}
+	list = &ImmutableList[T]{size: l.size, shift: l.shift, root: l.root, tail: l.tail}
+	if index >= l.tailOffset() {
+		list.tail = make([]T, len(l.tail))
+		copy(list.tail, l.tail)
+		list.tail[index&vectorMask] = elem
+	} else {
+		list.root = setVectorValue(l.shift, l.root, index, elem)
+	}
+	return
+}
+
+// setVectorValue returns a copy of the path to the index with the element set in the leaf
+func setVectorValue[T any](level uint, parent *vectorNode[T], index int, elem T) *vectorNode[T] {
+	node := &vectorNode[T]{}
+	if level == 0 {
+		node.values = make([]T, len(parent.values))
+		copy(node.values, parent.values)
+		node.values[index&vectorMask] = elem
+		return node
+	}
+	node.children = make([]*vectorNode[T], len(parent.children))
+	copy(node.children, parent.children)
+	i := (index >> level) & vectorMask
+	node.children[i] = setVectorValue(level-vectorBits, parent.children[i], index, elem)
+	return node
+}
+
+// Remove returns a new list without the first occurrence of the element, or the list itself if the element is not
+// in the list
+func (l *ImmutableList[T]) Remove(elem T) *ImmutableList[T] {
+	index := l.IndexOf(elem)
+	if index < 0 {
+		return l
+	}
+	list, _ := l.RemoveAt(index)
+	return list
+}
+
+// RemoveAt returns a new list without the element at the specified index
+func (l *ImmutableList[T]) RemoveAt(index int) (list *ImmutableList[T], err error) {
+	if index < 0 || index >= l.size {
+		err = ErrIndexOutOfBounds
+		return
+	}
+	list = NewImmutableList[T]()
+	it := l.Iterator()
+	for i := 0; it.HasNext(); i++ {
+		v := it.Next()
+		if i != index {
+			list = list.Add(v)
+		}
+	}
+	return
+}
+
+// Contains checks if the list contains the element
+func (l *ImmutableList[T]) Contains(elem T) bool {
+	return l.IndexOf(elem) >= 0
+}
+
+// IndexOf returns the index of the first occurrence of the element, or -1 if the element is not in the list
+func (l *ImmutableList[T]) IndexOf(elem T) int {
+	it := l.Iterator()
+	for i := 0; it.HasNext(); i++ {
+		if assertion.Equal(it.Next(), elem) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsEmpty checks if the list is empty
+func (l *ImmutableList[T]) IsEmpty() bool {
+	return l.size == 0
+}
+
+// Size returns the number of elements in the list
+func (l *ImmutableList[T]) Size() int {
+	return l.size
+}
+
+// String returns the elements of the list in the format of a slice
+func (l *ImmutableList[T]) String() string {
+	var sb strings.Builder
+	sb.WriteString("[")
+	it := l.Iterator()
+	for i := 0; it.HasNext(); i++ {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(fmt.Sprintf("%v", it.Next()))
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// Iterator returns an iterator over the elements of the list
+func (l *ImmutableList[T]) Iterator() Iterator[T] {
+	return &immutableListIterator[T]{list: l}
+}
+
+// immutableListIterator is an iterator for the ImmutableList. It looks up each leaf once.
+type immutableListIterator[T any] struct {
+	list   *ImmutableList[T]
+	index  int
+	values []T
+}
+
+// HasNext returns true if there are more elements in the list
+func (it *immutableListIterator[T]) HasNext() bool {
+	return it.index < it.list.size
+}
+
+// Next returns the next element in the list
+func (it *immutableListIterator[T]) Next() T {
+	if it.index&vectorMask == 0 {
+		it.values = it.list.leaf(it.index)
+	}
+	v := it.values[it.index&vectorMask]
+	it.index++
+	return v
+}
+
+// Remove does nothing, the list is never modified
+func (it *immutableListIterator[T]) Remove() {}
@@ -0,0 +1,300 @@
+package collections
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/bits"
+	"strings"
+)
+
+// hashBits is the number of hash bits consumed by each level of the hash trie
+const hashBits = 5
+
+// ImmutableSet is a persistent set. It is never modified: Add and Remove return a new version of the set that shares
+// most of its structure with the original, so the previous versions stay valid and can be read by several goroutines
+// without any lock.
+//
+// The elements are stored in a hash array mapped trie of 32 wide nodes, which gives the following complexities:
+//
+//   - Contains, Add and Remove are O(log32 n), Add and Remove copy one path of at most 13 nodes.
+//   - Add of an element in the set and Remove of an element not in the set return the set itself.
+//   - Iterator walks the set in O(n) and Size and IsEmpty are O(1).
+//
+// The iteration order is unspecified.
+type ImmutableSet[T comparable] struct {
+	seed maphash.Seed
+	size int
+	root *hashNode[T]
+}
+
+// hashNode is a node of the hash trie. The bitmap has a bit set for each of the 32 slots holding an entry, and the
+// entries are stored in the order of the slots.
+type hashNode[T comparable] struct {
+	bitmap  uint32
+	entries []hashEntry[T]
+}
+
+// hashEntry is either a child node or a leaf with the elements of a hash. A leaf has more than one element when the
+// hashes collide.
+type hashEntry[T comparable] struct {
+	node  *hashNode[T]
+	hash  uint64
+	elems []T
+}
+
+// NewImmutableSet creates a new ImmutableSet with the elements
+func NewImmutableSet[T comparable](elems ...T) *ImmutableSet[T] {
+	s := &ImmutableSet[T]{seed: maphash.MakeSeed(), root: &hashNode[T]{}}
+	for _, elem := range elems {
+		s = s.Add(elem)
+	}
+	return s
+}
+
+// FreezeSet creates a new ImmutableSet with the elements of the collection
+func FreezeSet[T comparable](coll Iterable[T]) *ImmutableSet[T] {
+	s := NewImmutableSet[T]()
+	it := coll.Iterator()
+	for it.HasNext() {
+		s = s.Add(it.Next())
+	}
+	return s
+}
+
+// Thaw returns a new HashSet with the elements of the set. Changing the HashSet does not affect the set.
+func (s *ImmutableSet[T]) Thaw() *HashSet[T] {
+	hs := &HashSet[T]{hashMap: make(map[T]any, s.size)}
+	it := s.Iterator()
+	for it.HasNext() {
+		hs.hashMap[it.Next()] = nil
+	}
+	return hs
+}
+
+// slot returns the bit of the slot of the hash at the shift and the index of its entry
+func (n *hashNode[T]) slot(hash uint64, shift uint) (bit uint32, index int) {
+	bit = 1 << ((hash >> shift) & 31)
+	index = bits.OnesCount32(n.bitmap & (bit - 1))
+	return
+}
+
+// Contains checks if the set contains the element
+func (s *ImmutableSet[T]) Contains(elem T) bool {
+	hash := maphash.Comparable(s.seed, elem)
+	node := s.root
+	for shift := uint(0); ; shift += hashBits {
+		bit, index := node.slot(hash, shift)
+		if node.bitmap&bit == 0 {
+			return false
+		}
+		e := node.entries[index]
+		if e.node == nil {
+			return e.hash == hash && indexOfElem(e.elems, elem) >= 0
+		}
+		node = e.node
+	}
+}
+
+// Add returns a new set with the element added, or the set itself if the element is in the set
+func (s *ImmutableSet[T]) Add(elem T) *ImmutableSet[T] {
+	root, added := s.root.add(maphash.Comparable(s.seed, elem), 0, elem)
+	if !added {
+		return s
+	}
+	return &ImmutableSet[T]{seed: s.seed, size: s.size + 1, root: root}
+}
+
+// add returns a copy of the node with the element added and true, or the node itself and false if the element exists
+func (n *hashNode[T]) add(hash uint64, shift uint, elem T) (*hashNode[T], bool) {
+	bit, index := n.slot(hash, shift)
+	if n.bitmap&bit == 0 {
+		entries := make([]hashEntry[T], 0, len(n.entries)+1)
+		entries = append(entries, n.entries[:index]...)
+		entries = append(entries, hashEntry[T]{hash: hash, elems: []T{elem}})
+		entries = append(entries, n.entries[index:]...)
+		return &hashNode[T]{bitmap: n.bitmap | bit, entries: entries}, true
+	}
+	e := n.entries[index]
+	switch {
+	case e.node != nil:
+		child, added := e.node.add(hash, shift+hashBits, elem)
+		if !added {
+			return n, false
+		}
+		e = hashEntry[T]{node: child}
+	case e.hash == hash:
+		if indexOfElem(e.elems, elem) >= 0 {
+			return n, false
+		}
+		elems := make([]T, len(e.elems), len(e.elems)+1)
+		copy(elems, e.elems)
+		e = hashEntry[T]{hash: hash, elems: append(elems, elem)}
+	default:
+		e = hashEntry[T]{node: mergeHashLeaves(e, hashEntry[T]{hash: hash, elems: []T{elem}}, shift+hashBits)}
+	}
+	return n.with(index, e), true
+}
+
+// mergeHashLeaves returns a node holding two leaves of different hashes, nested until their slots differ
+func mergeHashLeaves[T comparable](a, b hashEntry[T], shift uint) *hashNode[T] {
+	bitA, bitB := uint32(1)<<((a.hash>>shift)&31), uint32(1)<<((b.hash>>shift)&31)
+	if bitA == bitB {
+		return &hashNode[T]{bitmap: bitA, entries: []hashEntry[T]{{node: mergeHashLeaves(a, b, shift+hashBits)}}}
+	}
+	if bitA > bitB {
+		a, b = b, a
+	}
+	return &hashNode[T]{bitmap: bitA | bitB, entries: []hashEntry[T]{a, b}}
+}
+
+// with returns a copy of the node with the entry at the index replaced
+func (n *hashNode[T]) with(index int, e hashEntry[T]) *hashNode[T] {
+	entries := make([]hashEntry[T], len(n.entries))
+	copy(entries, n.entries)
+	entries[index] = e
+	return &hashNode[T]{bitmap: n.bitmap, entries: entries}
+}
+
+// without returns a copy of the node without the entry of the bit at the index
+func (n *hashNode[T]) without(bit uint32, index int) *hashNode[T] {
+	entries := make([]hashEntry[T], 0, len(n.entries)-1)
+	entries = append(entries, n.entries[:index]...)
+	entries = append(entries, n.entries[index+1:]...)
+	return &hashNode[T]{bitmap: n.bitmap &^ bit, entries: entries}
+}
+
+// Remove returns a new set without the element, or the set itself if the element is not in the set
+func (s *ImmutableSet[T]) Remove(elem T) *ImmutableSet[T] {
+	root, removed := s.root.remove(maphash.Comparable(s.seed, elem), 0, elem)
+	if !removed {
+		return s
+	}
+	return &ImmutableSet[T]{seed: s.seed, size: s.size - 1, root: root}
+}
+
+// remove returns a copy of the node without the element and true, or the node itself and false if the element does
+// not exist
+func (n *hashNode[T]) remove(hash uint64, shift uint, elem T) (*hashNode[T], bool) {
+	bit, index := n.slot(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	e := n.entries[index]
+	if e.node != nil {
+		child, removed := e.node.remove(hash, shift+hashBits, elem)
+		if !removed {
+			return n, false
+		}
+		switch {
+		case len(child.entries) == 0:
+			return n.without(bit, index), true
+		case len(child.entries) == 1 && child.entries[0].node == nil:
+			// a single leaf moves up so that the trie stays as shallow as when the element was never added
+			return n.with(index, child.entries[0]), true
+		}
+		return n.with(index, hashEntry[T]{node: child}), true
+	}
+	i := -1
+	if e.hash == hash {
+		i = indexOfElem(e.elems, elem)
+	}
+	if i < 0 {
+		return n, false
+	}
+	if len(e.elems) == 1 {
+		return n.without(bit, index), true
+	}
+	elems := make([]T, 0, len(e.elems)-1)
+	elems = append(elems, e.elems[:i]...)
+	elems = append(elems, e.elems[i+1:]...)
+	return n.with(index, hashEntry[T]{hash: hash, elems: elems}), true
+}
+
+// indexOfElem returns the index of the element in the slice or -1
+func indexOfElem[T comparable](elems []T, elem T) int {
+	for i, e := range elems {
+		if e == elem {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsEmpty checks if the set is empty
+func (s *ImmutableSet[T]) IsEmpty() bool {
+	return s.size == 0
+}
+
+// Size returns the number of elements in the set
+func (s *ImmutableSet[T]) Size() int {
+	return s.size
+}
+
+// String returns the elements of the set in the format of the HashSet
+func (s *ImmutableSet[T]) String() string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	it := s.Iterator()
+	for it.HasNext() {
+		sb.WriteString(fmt.Sprintf("%v", it.Next()))
+		if it.HasNext() {
+			sb.WriteString(", ")
+		}
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// Iterator returns an iterator over the elements of the set
+func (s *ImmutableSet[T]) Iterator() Iterator[T] {
+	it := &immutableSetIterator[T]{}
+	it.push(s.root)
+	return it
+}
+
+// immutableSetIterator walks the trie depth first with a stack of the nodes being visited
+type immutableSetIterator[T comparable] struct {
+	stack []hashFrame[T]
+	elems []T
+}
+
+// hashFrame is a node being visited and the index of its next entry
+type hashFrame[T comparable] struct {
+	node  *hashNode[T]
+	index int
+}
+
+func (it *immutableSetIterator[T]) push(node *hashNode[T]) {
+	it.stack = append(it.stack, hashFrame[T]{node: node})
+}
+
+// HasNext returns true if there are more elements in the set
+func (it *immutableSetIterator[T]) HasNext() bool {
+	for len(it.elems) == 0 && len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		if top.index == len(top.node.entries) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		e := top.node.entries[top.index]
+		top.index++
+		if e.node != nil {
+			it.push(e.node)
+		} else {
+			it.elems = e.elems
+		}
+	}
+	return len(it.elems) > 0
+}
+
+// Next returns the next element in the set
+func (it *immutableSetIterator[T]) Next() (v T) {
+	if it.HasNext() {
+		v = it.elems[0]
+		it.elems = it.elems[1:]
+	}
+	return
+}
+
+// Remove does nothing, the set is never modified
+func (it *immutableSetIterator[T]) Remove() {}
@@ -0,0 +1,217 @@
+package collections
+
+import (
+	"sync"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// listValues returns the elements of the list through Get
+func listValues[T any](t *testing.T, l *ImmutableList[T]) []T {
+	values := make([]T, 0, l.Size())
+	for i := 0; i < l.Size(); i++ {
+		v, err := l.Get(i)
+		assert.NoError(t, err)
+		values = append(values, v)
+	}
+	return values
+}
+
+func sequence(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
+
+func TestImmutableList_Add(t *testing.T) {
+	empty := NewImmutableList[int]()
+	assert.True(t, empty.IsEmpty())
+	_, err := empty.Get(0)
+	assert.Equal(t, ErrIndexOutOfBounds, err)
+
+	// the sizes cross the tail, the first trie level and the growth of the root
+	versions := []*ImmutableList[int]{empty}
+	for i := 0; i < 1100; i++ {
+		versions = append(versions, versions[i].Add(i))
+	}
+	for n, l := range versions {
+		assert.Equal(t, n, l.Size())
+		if n%97 == 0 || n == 32 || n == 33 || n == 1024 || n == 1025 || n == 1056 || n == 1057 {
+			assert.Equal(t, sequence(n), listValues(t, l))
+			assert.Equal(t, sequence(n), Map[int](l, func(v int) int { return v }))
+		}
+	}
+
+	// a version shares its structure with the later ones and is not changed by them
+	base := NewImmutableList(sequence(40)...)
+	a, b := base.Add(100), base.Add(200)
+	last, _ := a.Get(40)
+	assert.Equal(t, 100, last)
+	last, _ = b.Get(40)
+	assert.Equal(t, 200, last)
+	assert.Equal(t, 40, base.Size())
+	assert.True(t, a.root == base.root)
+}
+
+func TestImmutableList_SetRemove(t *testing.T) {
+	base := NewImmutableList(sequence(100)...)
+	for _, index := range []int{0, 31, 32, 63, 95, 99} {
+		l, err := base.Set(index, -1)
+		assert.NoError(t, err)
+		want := sequence(100)
+		want[index] = -1
+		assert.Equal(t, want, listValues(t, l))
+	}
+	assert.Equal(t, sequence(100), listValues(t, base))
+	_, err := base.Set(100, 0)
+	assert.Equal(t, ErrIndexOutOfBounds, err)
+
+	removed := base.Remove(40)
+	assert.Equal(t, 99, removed.Size())
+	assert.Equal(t, -1, removed.IndexOf(40))
+	assert.Equal(t, 40, removed.IndexOf(41))
+	assert.True(t, base.Contains(40))
+	assert.True(t, base.Remove(1000) == base)
+
+	removed, err = base.RemoveAt(99)
+	assert.NoError(t, err)
+	assert.Equal(t, sequence(99), listValues(t, removed))
+	_, err = base.RemoveAt(-1)
+	assert.Equal(t, ErrIndexOutOfBounds, err)
+
+	assert.Equal(t, "[1 2 3]", NewImmutableList(1, 2, 3).String())
+}
+
+func TestImmutableList_FreezeThaw(t *testing.T) {
+	al := NewArrayList[string]()
+	al.Add("a")
+	al.Add("b")
+	frozen := Freeze[string](al)
+	al.Add("c")
+	assert.Equal(t, 2, frozen.Size())
+
+	thawed := frozen.Thaw()
+	thawed.Add("z")
+	assert.Equal(t, 3, thawed.Size())
+	assert.Equal(t, []string{"a", "b"}, listValues(t, frozen))
+
+	// the immutable list is accepted where the elements are only read
+	var ro ReadOnlyCollection[string] = frozen
+	assert.True(t, ro.Contains("b"))
+	var coll Collection[string] = al
+	ro = coll
+	assert.Equal(t, 3, ro.Size())
+}
+
+func TestImmutableList_Concurrent(t *testing.T) {
+	base := NewImmutableList(sequence(64)...)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			l := base
+			for i := 0; i < 200; i++ {
+				l = l.Add(g)
+				l, _ = l.Set(i%64, g)
+			}
+			for i := 0; i < 64; i++ {
+				v, _ := l.Get(i)
+				assert.Equal(t, g, v)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, sequence(64), listValues(t, base))
+}
+
+func TestImmutableSet(t *testing.T) {
+	empty := NewImmutableSet[int]()
+	assert.True(t, empty.IsEmpty())
+	assert.False(t, empty.Contains(1))
+
+	versions := []*ImmutableSet[int]{empty}
+	for i := 0; i < 2000; i++ {
+		versions = append(versions, versions[i].Add(i))
+	}
+	for n, s := range versions {
+		assert.Equal(t, n, s.Size())
+	}
+	full := versions[2000]
+	for i := 0; i < 2000; i++ {
+		assert.True(t, full.Contains(i))
+		assert.False(t, versions[i].Contains(i))
+	}
+	assert.True(t, full.Add(5) == full)
+	assert.True(t, full.Remove(-5) == full)
+
+	seen := map[int]bool{}
+	it := full.Iterator()
+	for it.HasNext() {
+		seen[it.Next()] = true
+	}
+	assert.Len(t, seen, 2000)
+
+	s := full
+	for i := 0; i < 2000; i += 2 {
+		s = s.Remove(i)
+	}
+	assert.Equal(t, 1000, s.Size())
+	for i := 0; i < 2000; i++ {
+		assert.Equal(t, i%2 == 1, s.Contains(i))
+		assert.True(t, full.Contains(i))
+	}
+	for i := 1; i < 2000; i += 2 {
+		s = s.Remove(i)
+	}
+	assert.True(t, s.IsEmpty())
+	assert.Len(t, s.root.entries, 0)
+	assert.Equal(t, 2000, full.Size())
+}
+
+func TestImmutableSet_FreezeThaw(t *testing.T) {
+	hs := NewHashSet[string]()
+	hs.Add("a")
+	hs.Add("b")
+	frozen := FreezeSet[string](hs)
+	hs.Remove("a")
+	assert.True(t, frozen.Contains("a"))
+
+	thawed := frozen.Thaw()
+	thawed.Add("c")
+	assert.Equal(t, 2, frozen.Size())
+	assert.Equal(t, 3, thawed.Size())
+	assert.Equal(t, "{x}", NewImmutableSet("x").String())
+
+	var ro ReadOnlyCollection[string] = frozen
+	assert.Equal(t, 2, len(Filter[string](ro, func(string) bool { return true })))
+}
+
+func TestImmutableSet_Collisions(t *testing.T) {
+	// the trie is built from chosen hashes: 1 and 33 share the first slot, and 7 is stored twice under one hash
+	root := &hashNode[int]{}
+	root, _ = root.add(1, 0, 1)
+	root, _ = root.add(33, 0, 33)
+	root, _ = root.add(7, 0, 7)
+	withCollision, added := root.add(7, 0, 70)
+	assert.True(t, added)
+	_, added = withCollision.add(7, 0, 70)
+	assert.False(t, added)
+	assert.Len(t, root.entries, 2)
+	assert.True(t, root.entries[0].node != nil)
+
+	// removing a colliding element keeps the other one, removing the sibling moves the leaf back up
+	removed, ok := withCollision.remove(7, 0, 70)
+	assert.True(t, ok)
+	assert.Equal(t, []int{7}, removed.entries[1].elems)
+	assert.Equal(t, []int{7, 70}, withCollision.entries[1].elems)
+	removed, ok = root.remove(33, 0, 33)
+	assert.True(t, ok)
+	assert.True(t, removed.entries[0].node == nil)
+	assert.Equal(t, []int{1}, removed.entries[0].elems)
+	_, ok = root.remove(65, 0, 65)
+	assert.False(t, ok)
+}
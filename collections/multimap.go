@@ -0,0 +1,369 @@
+package collections
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"oss.nandlabs.io/golly/assertion"
+)
+
+// Entry is a key and a value of a map
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// MultiMap maps each key to an ordered list of values. The keys are iterated in the order they were first put, and
+// the values of a key in the order they were put. A key removed and put again comes last.
+type MultiMap[K comparable, V any] struct {
+	values map[K][]V
+	keys   []K
+	size   int
+}
+
+// NewMultiMap creates a new MultiMap
+func NewMultiMap[K comparable, V any]() *MultiMap[K, V] {
+	return &MultiMap[K, V]{values: make(map[K][]V)}
+}
+
+// Put appends the value to the values of the key
+func (m *MultiMap[K, V]) Put(k K, v V) {
+	values, ok := m.values[k]
+	if !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.values[k] = append(values, v)
+	m.size++
+}
+
+// GetAll returns a copy of the values of the key, nil if the key does not exist
+func (m *MultiMap[K, V]) GetAll(k K) []V {
+	values, ok := m.values[k]
+	if !ok {
+		return nil
+	}
+	return append([]V(nil), values...)
+}
+
+// Get returns the first value of the key and true if the key exists
+func (m *MultiMap[K, V]) Get(k K) (v V, ok bool) {
+	var values []V
+	if values, ok = m.values[k]; ok {
+		v = values[0]
+	}
+	return
+}
+
+// Count returns the number of values of the key
+func (m *MultiMap[K, V]) Count(k K) int {
+	return len(m.values[k])
+}
+
+// ContainsKey checks if the key has values
+func (m *MultiMap[K, V]) ContainsKey(k K) bool {
+	_, ok := m.values[k]
+	return ok
+}
+
+// RemoveValue removes every occurrence of the value from the values of the key, the key is removed along with its
+// last value. It returns the number of values removed.
+func (m *MultiMap[K, V]) RemoveValue(k K, v V) (removed int) {
+	values, ok := m.values[k]
+	if !ok {
+		return
+	}
+	kept := values[:0]
+	for _, value := range values {
+		if assertion.Equal(value, v) {
+			removed++
+		} else {
+			kept = append(kept, value)
+		}
+	}
+	clear(values[len(kept):])
+	m.size -= removed
+	m.values[k] = kept
+	if len(kept) == 0 {
+		m.RemoveKey(k)
+	}
+	return
+}
+
+// RemoveKey removes the key and returns its values
+func (m *MultiMap[K, V]) RemoveKey(k K) []V {
+	values, ok := m.values[k]
+	if !ok {
+		return nil
+	}
+	delete(m.values, k)
+	m.size -= len(values)
+	for i, key := range m.keys {
+		if key == k {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	return values
+}
+
+// Clear removes all the keys
+func (m *MultiMap[K, V]) Clear() {
+	m.values = make(map[K][]V)
+	m.keys = nil
+	m.size = 0
+}
+
+// Len returns the number of values of all the keys
+func (m *MultiMap[K, V]) Len() int {
+	return m.size
+}
+
+// IsEmpty checks if the map has no values
+func (m *MultiMap[K, V]) IsEmpty() bool {
+	return m.size == 0
+}
+
+// Keys returns the keys in the order they were first put
+func (m *MultiMap[K, V]) Keys() []K {
+	return append([]K(nil), m.keys...)
+}
+
+// Values returns the values of all the keys, in the order of the keys
+func (m *MultiMap[K, V]) Values() []V {
+	values := make([]V, 0, m.size)
+	for _, k := range m.keys {
+		values = append(values, m.values[k]...)
+	}
+	return values
+}
+
+// Entries returns the entries of all the keys, in the order of the keys
+func (m *MultiMap[K, V]) Entries() []Entry[K, V] {
+	entries := make([]Entry[K, V], 0, m.size)
+	for _, k := range m.keys {
+		for _, v := range m.values[k] {
+			entries = append(entries, Entry[K, V]{Key: k, Value: v})
+		}
+	}
+	return entries
+}
+
+// Iterator returns an iterator over a snapshot of the entries, in the order of Entries. Its Remove removes the last
+// entry returned from the map.
+func (m *MultiMap[K, V]) Iterator() Iterator[Entry[K, V]] {
+	return &multiMapIterator[K, V]{m: m, entries: m.Entries(), index: -1, removed: make(map[K]int)}
+}
+
+// ToMap returns a copy of the map as a map of the keys to their values
+func (m *MultiMap[K, V]) ToMap() map[K][]V {
+	result := make(map[K][]V, len(m.values))
+	for k, values := range m.values {
+		result[k] = append([]V(nil), values...)
+	}
+	return result
+}
+
+// String returns the entries of the keys in order, such as "{a: [1 2], b: [3]}"
+func (m *MultiMap[K, V]) String() string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, k := range m.keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%v: %v", k, m.values[k])
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// multiMapIterator iterates over a snapshot of the entries of a MultiMap
+type multiMapIterator[K comparable, V any] struct {
+	m       *MultiMap[K, V]
+	entries []Entry[K, V]
+	index   int
+	// position is the index of the last entry returned in the values of its key
+	position int
+	// removed is the number of values removed from each key by the iterator
+	removed map[K]int
+}
+
+// HasNext returns true if there are more entries
+func (it *multiMapIterator[K, V]) HasNext() bool {
+	return it.index+1 < len(it.entries)
+}
+
+// Next returns the next entry
+func (it *multiMapIterator[K, V]) Next() Entry[K, V] {
+	it.index++
+	e := it.entries[it.index]
+	if it.index == 0 || it.entries[it.index-1].Key != e.Key {
+		it.position = 0
+	} else {
+		it.position++
+	}
+	return e
+}
+
+// Remove removes the last entry returned from the map
+func (it *multiMapIterator[K, V]) Remove() {
+	if it.index < 0 || it.index >= len(it.entries) {
+		return
+	}
+	k := it.entries[it.index].Key
+	values := it.m.values[k]
+	i := it.position - it.removed[k]
+	if i < 0 || i >= len(values) {
+		return
+	}
+	if len(values) == 1 {
+		it.m.RemoveKey(k)
+	} else {
+		it.m.values[k] = append(values[:i], values[i+1:]...)
+		it.m.size--
+	}
+	it.removed[k]++
+}
+
+// SyncMultiMap is a MultiMap safe for concurrent use
+type SyncMultiMap[K comparable, V any] struct {
+	mutex    sync.RWMutex
+	multimap *MultiMap[K, V]
+}
+
+// NewSyncMultiMap creates a new SyncMultiMap
+func NewSyncMultiMap[K comparable, V any]() *SyncMultiMap[K, V] {
+	return &SyncMultiMap[K, V]{multimap: NewMultiMap[K, V]()}
+}
+
+// Put appends the value to the values of the key
+func (m *SyncMultiMap[K, V]) Put(k K, v V) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.multimap.Put(k, v)
+}
+
+// GetAll returns a copy of the values of the key, nil if the key does not exist
+func (m *SyncMultiMap[K, V]) GetAll(k K) []V {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.GetAll(k)
+}
+
+// Get returns the first value of the key and true if the key exists
+func (m *SyncMultiMap[K, V]) Get(k K) (V, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.Get(k)
+}
+
+// Count returns the number of values of the key
+func (m *SyncMultiMap[K, V]) Count(k K) int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.Count(k)
+}
+
+// ContainsKey checks if the key has values
+func (m *SyncMultiMap[K, V]) ContainsKey(k K) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.ContainsKey(k)
+}
+
+// RemoveValue removes every occurrence of the value from the values of the key and returns the number removed
+func (m *SyncMultiMap[K, V]) RemoveValue(k K, v V) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.multimap.RemoveValue(k, v)
+}
+
+// RemoveKey removes the key and returns its values
+func (m *SyncMultiMap[K, V]) RemoveKey(k K) []V {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.multimap.RemoveKey(k)
+}
+
+// Clear removes all the keys
+func (m *SyncMultiMap[K, V]) Clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.multimap.Clear()
+}
+
+// Len returns the number of values of all the keys
+func (m *SyncMultiMap[K, V]) Len() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.Len()
+}
+
+// IsEmpty checks if the map has no values
+func (m *SyncMultiMap[K, V]) IsEmpty() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.IsEmpty()
+}
+
+// Keys returns a snapshot of the keys in the order they were first put
+func (m *SyncMultiMap[K, V]) Keys() []K {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.Keys()
+}
+
+// Values returns a snapshot of the values of all the keys, in the order of the keys
+func (m *SyncMultiMap[K, V]) Values() []V {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.Values()
+}
+
+// Entries returns a snapshot of the entries of all the keys, in the order of the keys
+func (m *SyncMultiMap[K, V]) Entries() []Entry[K, V] {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.Entries()
+}
+
+// Iterator returns an iterator over a snapshot of the entries. Its Remove does nothing, use RemoveValue instead.
+func (m *SyncMultiMap[K, V]) Iterator() Iterator[Entry[K, V]] {
+	return &snapshotIterator[Entry[K, V]]{elems: m.Entries()}
+}
+
+// ToMap returns a copy of the map as a map of the keys to their values
+func (m *SyncMultiMap[K, V]) ToMap() map[K][]V {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.ToMap()
+}
+
+// String returns the entries of the keys in order
+func (m *SyncMultiMap[K, V]) String() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.multimap.String()
+}
+
+// snapshotIterator iterates over a copy of the elements of a collection. Its Remove does nothing.
+type snapshotIterator[T any] struct {
+	elems []T
+	index int
+}
+
+// HasNext returns true if there are more elements
+func (it *snapshotIterator[T]) HasNext() bool {
+	return it.index < len(it.elems)
+}
+
+// Next returns the next element
+func (it *snapshotIterator[T]) Next() T {
+	e := it.elems[it.index]
+	it.index++
+	return e
+}
+
+// Remove does nothing
+func (it *snapshotIterator[T]) Remove() {}
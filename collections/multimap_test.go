@@ -0,0 +1,152 @@
+package collections
+
+import (
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestMultiMap(t *testing.T) {
+	m := NewMultiMap[string, int]()
+	m.Put("b", 1)
+	m.Put("a", 2)
+	m.Put("b", 3)
+	m.Put("b", 1)
+
+	assert.Equal(t, 4, m.Len())
+	assert.Equal(t, 3, m.Count("b"))
+	assert.Equal(t, 0, m.Count("c"))
+	assert.Equal(t, []int{1, 3, 1}, m.GetAll("b"))
+	assert.Equal(t, []string{"b", "a"}, m.Keys())
+	assert.Equal(t, []int{1, 3, 1, 2}, m.Values())
+	assert.Equal(t, "{b: [1 3 1], a: [2]}", m.String())
+	v, ok := m.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	// the values returned are copies
+	values := m.GetAll("b")
+	values[0] = 100
+	assert.Equal(t, []int{1, 3, 1}, m.GetAll("b"))
+
+	assert.Equal(t, 2, m.RemoveValue("b", 1))
+	assert.Equal(t, []int{3}, m.GetAll("b"))
+	assert.Equal(t, 0, m.RemoveValue("b", 7))
+	assert.Equal(t, 1, m.RemoveValue("b", 3))
+	assert.False(t, m.ContainsKey("b"))
+	assert.Equal(t, 1, m.Len())
+
+	// a key put again after its removal comes last
+	m.Put("b", 4)
+	m.Put("c", 5)
+	assert.Equal(t, []string{"a", "b", "c"}, m.Keys())
+	assert.Equal(t, []int{2}, m.RemoveKey("a"))
+	assert.True(t, m.RemoveKey("a") == nil)
+	assert.Equal(t, map[string][]int{"b": {4}, "c": {5}}, m.ToMap())
+	m.Clear()
+	assert.True(t, m.IsEmpty())
+}
+
+func TestMultiMap_Iterator(t *testing.T) {
+	m := NewMultiMap[string, int]()
+	for i, k := range []string{"x", "y", "x", "x", "y"} {
+		m.Put(k, i)
+	}
+	// the functional operations work over the entries
+	even := Filter[Entry[string, int]](m, func(e Entry[string, int]) bool { return e.Value%2 == 0 })
+	assert.Equal(t, []Entry[string, int]{{"x", 0}, {"x", 2}, {"y", 4}}, even)
+	keys := Map[Entry[string, int]](m, func(e Entry[string, int]) string { return e.Key })
+	assert.Equal(t, []string{"x", "x", "x", "y", "y"}, keys)
+
+	// the iterator removes the entries it returned
+	it := m.Iterator()
+	for it.HasNext() {
+		if e := it.Next(); e.Value == 2 || e.Value == 3 || e.Value == 1 {
+			it.Remove()
+		}
+	}
+	assert.Equal(t, []int{0}, m.GetAll("x"))
+	assert.Equal(t, []int{4}, m.GetAll("y"))
+	assert.Equal(t, 2, m.Len())
+}
+
+// TestMultiMap_Ordering checks the order of the values of each key against a model under random interleaved puts
+// and removals
+func TestMultiMap_Ordering(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	m := NewMultiMap[int, int]()
+	model := map[int][]int{}
+	var order []int
+	for i := 0; i < 5000; i++ {
+		k := r.IntN(8)
+		switch op := r.IntN(10); {
+		case op < 6:
+			if _, ok := model[k]; !ok {
+				order = append(order, k)
+			}
+			v := r.IntN(20)
+			m.Put(k, v)
+			model[k] = append(model[k], v)
+		case op < 9:
+			v := r.IntN(20)
+			kept := []int{}
+			for _, value := range model[k] {
+				if value != v {
+					kept = append(kept, value)
+				}
+			}
+			assert.Equal(t, len(model[k])-len(kept), m.RemoveValue(k, v))
+			if _, ok := model[k]; ok {
+				model[k] = kept
+			}
+		default:
+			m.RemoveKey(k)
+			delete(model, k)
+		}
+		for key, values := range model {
+			if len(values) == 0 {
+				delete(model, key)
+			}
+		}
+		kept := order[:0]
+		for _, key := range order {
+			if _, ok := model[key]; ok {
+				kept = append(kept, key)
+			}
+		}
+		order = kept
+	}
+	size := 0
+	for _, k := range order {
+		assert.Equal(t, model[k], m.GetAll(k))
+		size += len(model[k])
+	}
+	assert.Equal(t, order, m.Keys())
+	assert.Equal(t, size, m.Len())
+}
+
+func TestSyncMultiMap(t *testing.T) {
+	m := NewSyncMultiMap[int, int]()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m.Put(g, i)
+				_ = m.Count(g)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 400, m.Len())
+	for g := 0; g < 4; g++ {
+		values := m.GetAll(g)
+		for i := range values {
+			assert.Equal(t, i, values[i])
+		}
+	}
+	assert.Equal(t, 400, len(Filter[Entry[int, int]](m, func(Entry[int, int]) bool { return true })))
+}
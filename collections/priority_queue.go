@@ -0,0 +1,193 @@
+package collections
+
+import (
+	"fmt"
+
+	"oss.nandlabs.io/golly/assertion"
+)
+
+// PriorityQueue is a Queue dequeuing the elements in the order of a comparison function, the smallest first.
+// It is backed by a binary heap: Enqueue and Dequeue take O(log n) and Front O(1).
+// The iterator returns the elements in the internal heap order, not in the priority order.
+type PriorityQueue[T any] struct {
+	elements []T
+	less     func(a, b T) bool
+}
+
+// NewPriorityQueue creates a new PriorityQueue ordered by less. The element for which less returns true against all
+// the others is dequeued first.
+func NewPriorityQueue[T any](less func(a, b T) bool) *PriorityQueue[T] {
+	return &PriorityQueue[T]{less: less}
+}
+
+// Add adds an element to the queue
+func (pq *PriorityQueue[T]) Add(elem T) error {
+	pq.elements = append(pq.elements, elem)
+	pq.up(len(pq.elements) - 1)
+	return nil
+}
+
+// AddAll adds all elements from another collection to the queue
+func (pq *PriorityQueue[T]) AddAll(coll Collection[T]) (err error) {
+	it := coll.Iterator()
+	for it.HasNext() {
+		_ = pq.Add(it.Next())
+	}
+	return
+}
+
+// Clear removes all elements from the queue
+func (pq *PriorityQueue[T]) Clear() {
+	pq.elements = nil
+}
+
+// Contains checks if an element is in the queue
+func (pq *PriorityQueue[T]) Contains(elem T) bool {
+	return pq.indexOf(elem) >= 0
+}
+
+// IsEmpty returns true if the queue is empty
+func (pq *PriorityQueue[T]) IsEmpty() bool {
+	return len(pq.elements) == 0
+}
+
+// Remove removes an element from the queue
+func (pq *PriorityQueue[T]) Remove(elem T) bool {
+	i := pq.indexOf(elem)
+	if i < 0 {
+		return false
+	}
+	pq.removeAt(i)
+	return true
+}
+
+// Size returns the number of elements in the queue
+func (pq *PriorityQueue[T]) Size() int {
+	return len(pq.elements)
+}
+
+// String returns the elements of the queue in the heap order
+func (pq *PriorityQueue[T]) String() string {
+	return fmt.Sprintf("%v", pq.elements)
+}
+
+// Enqueue adds an element to the queue
+func (pq *PriorityQueue[T]) Enqueue(elem T) error {
+	return pq.Add(elem)
+}
+
+// Dequeue removes and returns the smallest element of the queue
+func (pq *PriorityQueue[T]) Dequeue() (v T, err error) {
+	if pq.IsEmpty() {
+		err = ErrEmptyCollection
+		return
+	}
+	v = pq.removeAt(0)
+	return
+}
+
+// Front returns the smallest element of the queue without removing it
+func (pq *PriorityQueue[T]) Front() (v T, err error) {
+	if pq.IsEmpty() {
+		err = ErrEmptyCollection
+		return
+	}
+	v = pq.elements[0]
+	return
+}
+
+// Fix restores the order of the queue after the smallest element is changed in place.
+// It is cheaper than a Dequeue followed by an Enqueue of the changed element.
+func (pq *PriorityQueue[T]) Fix() {
+	if len(pq.elements) > 0 {
+		pq.down(0)
+	}
+}
+
+// Iterator returns an Iterator over the elements of the queue in the heap order
+func (pq *PriorityQueue[T]) Iterator() Iterator[T] {
+	return &priorityQueueIterator[T]{pq: pq}
+}
+
+// indexOf returns the index of the element in the heap or -1
+func (pq *PriorityQueue[T]) indexOf(elem T) int {
+	for i, e := range pq.elements {
+		if assertion.Equal(e, elem) {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeAt removes the element at the index of the heap and restores the heap order
+func (pq *PriorityQueue[T]) removeAt(i int) (v T) {
+	v = pq.elements[i]
+	last := len(pq.elements) - 1
+	pq.elements[i] = pq.elements[last]
+	var zero T
+	pq.elements[last] = zero
+	pq.elements = pq.elements[:last]
+	if i < last {
+		pq.down(i)
+		pq.up(i)
+	}
+	return
+}
+
+// up moves the element at the index towards the root until its parent is not greater
+func (pq *PriorityQueue[T]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !pq.less(pq.elements[i], pq.elements[parent]) {
+			break
+		}
+		pq.elements[i], pq.elements[parent] = pq.elements[parent], pq.elements[i]
+		i = parent
+	}
+}
+
+// down moves the element at the index towards the leaves until its children are not smaller
+func (pq *PriorityQueue[T]) down(i int) {
+	n := len(pq.elements)
+	for {
+		smallest := i
+		if left := 2*i + 1; left < n && pq.less(pq.elements[left], pq.elements[smallest]) {
+			smallest = left
+		}
+		if right := 2*i + 2; right < n && pq.less(pq.elements[right], pq.elements[smallest]) {
+			smallest = right
+		}
+		if smallest == i {
+			return
+		}
+		pq.elements[i], pq.elements[smallest] = pq.elements[smallest], pq.elements[i]
+		i = smallest
+	}
+}
+
+// priorityQueueIterator is an iterator for the PriorityQueue
+type priorityQueueIterator[T any] struct {
+	pq    *PriorityQueue[T]
+	index int
+}
+
+// HasNext returns true if there are more elements in the queue
+func (it *priorityQueueIterator[T]) HasNext() bool {
+	return it.index < len(it.pq.elements)
+}
+
+// Next returns the next element in the queue
+func (it *priorityQueueIterator[T]) Next() T {
+	v := it.pq.elements[it.index]
+	it.index++
+	return v
+}
+
+// Remove removes the last element returned by the iterator from the queue.
+// The elements moved by the removal may be skipped or returned again by the iteration.
+func (it *priorityQueueIterator[T]) Remove() {
+	if it.index > 0 {
+		it.index--
+		it.pq.removeAt(it.index)
+	}
+}
@@ -0,0 +1,81 @@
+package collections
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestPriorityQueue_Order(t *testing.T) {
+	pq := NewPriorityQueue(func(a, b int) bool { return a < b })
+	values := rand.New(rand.NewSource(1)).Perm(100)
+	for _, v := range values {
+		assert.Nil(t, pq.Enqueue(v))
+	}
+	assert.Equal(t, 100, pq.Size())
+	front, err := pq.Front()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, front)
+	for i := 0; i < 100; i++ {
+		v, err := pq.Dequeue()
+		assert.Nil(t, err)
+		assert.Equal(t, i, v)
+	}
+	assert.True(t, pq.IsEmpty())
+	_, err = pq.Dequeue()
+	assert.Equal(t, ErrEmptyCollection, err)
+	_, err = pq.Front()
+	assert.Equal(t, ErrEmptyCollection, err)
+}
+
+func TestPriorityQueue_RemoveAndFix(t *testing.T) {
+	type item struct {
+		name     string
+		priority int
+	}
+	pq := NewPriorityQueue(func(a, b *item) bool { return a.priority < b.priority })
+	items := []*item{{"a", 5}, {"b", 1}, {"c", 3}, {"d", 4}, {"e", 2}}
+	for _, it := range items {
+		_ = pq.Add(it)
+	}
+	assert.True(t, pq.Contains(items[2]))
+	assert.True(t, pq.Remove(items[2]))
+	assert.False(t, pq.Remove(items[2]))
+	// lower the priority of the front in place
+	front, _ := pq.Front()
+	front.priority = 10
+	pq.Fix()
+	var names []string
+	for !pq.IsEmpty() {
+		it, _ := pq.Dequeue()
+		names = append(names, it.name)
+	}
+	assert.Equal(t, []string{"e", "d", "a", "b"}, names)
+}
+
+func TestPriorityQueue_Iterator(t *testing.T) {
+	pq := NewPriorityQueue(func(a, b int) bool { return a > b })
+	list := NewArrayList[int]()
+	for _, v := range []int{3, 1, 4, 1, 5, 9, 2, 6} {
+		list.Add(v)
+	}
+	assert.Nil(t, pq.AddAll(list))
+	var all []int
+	it := pq.Iterator()
+	for it.HasNext() {
+		v := it.Next()
+		all = append(all, v)
+		if v == 1 {
+			it.Remove()
+		}
+	}
+	sort.Ints(all)
+	assert.Equal(t, []int{1, 1, 2, 3, 4, 5, 6, 9}, all)
+	assert.False(t, pq.Contains(1))
+	v, _ := pq.Dequeue()
+	assert.Equal(t, 9, v)
+	pq.Clear()
+	assert.Equal(t, 0, pq.Size())
+}
@@ -0,0 +1,324 @@
+package collections
+
+import (
+	"errors"
+	"hash/maphash"
+	"maps"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultVersionRetention is the number of prior versions kept by a VersionedMap created with NewVersionedMap
+const DefaultVersionRetention = 10
+
+// ErrVersionNotRetained is returned for the versions of a VersionedMap that are not in its history
+var ErrVersionNotRetained = errors.New("version not retained")
+
+// ReadOnlyMap is an immutable version of a VersionedMap
+type ReadOnlyMap[K comparable, V any] interface {
+	// Get returns the value of the key and true if the key exists
+	Get(k K) (V, bool)
+	// Contains returns true if the key exists
+	Contains(k K) bool
+	// Len returns the number of keys
+	Len() int
+	// Keys returns the keys
+	Keys() []K
+	// Range calls fn for each key and value until fn returns false
+	Range(fn func(k K, v V) bool)
+	// Version returns the version of the map
+	Version() uint64
+}
+
+// MutableDraft is the next version of a VersionedMap being prepared by VersionedMap.Update
+type MutableDraft[K comparable, V any] interface {
+	// Get returns the value of the key and true if the key exists
+	Get(k K) (V, bool)
+	// Contains returns true if the key exists
+	Contains(k K) bool
+	// Len returns the number of keys
+	Len() int
+	// Put sets the value of the key
+	Put(k K, v V)
+	// Delete removes the key and returns true if it existed
+	Delete(k K) bool
+}
+
+// MapDiff lists the keys that differ between two versions of a VersionedMap, in the order they were first updated
+type MapDiff[K comparable] struct {
+	// Added are the keys of the second version that are not in the first
+	Added []K
+	// Removed are the keys of the first version that are not in the second
+	Removed []K
+	// Changed are the keys of both versions whose values differ
+	Changed []K
+}
+
+// VersionedMap is a read-mostly map whose readers see consistent snapshots while a writer prepares the next version.
+// Every version is an immutable snapshot published atomically: Get and Snapshot only load the latest one and take no
+// lock, and a reader holding a snapshot never observes the later updates.
+//
+// The keys are spread by hash across shards as in the ConcurrentMap. An Update copies the shards of the keys it
+// writes, on the first write to each, and shares the other shards with the previous version. An update of a few keys
+// therefore costs the copy of about Len()/DefaultShardCount entries per touched shard, and a version retained in the
+// history holds only the shards it copied. The updates are serialized.
+type VersionedMap[K comparable, V any] struct {
+	seed      maphash.Seed
+	current   atomic.Pointer[mapVersion[K, V]]
+	mutex     sync.Mutex
+	retention int
+	// history holds the retained versions, oldest first, ending with the current one
+	history []*mapVersion[K, V]
+}
+
+// mapVersion is an immutable version of a VersionedMap
+type mapVersion[K comparable, V any] struct {
+	m       *VersionedMap[K, V]
+	version uint64
+	shards  []map[K]V
+	size    int
+	// updated are the keys put or deleted by the update that produced the version
+	updated []K
+}
+
+// NewVersionedMap creates an empty VersionedMap at version 0 keeping DefaultVersionRetention prior versions
+func NewVersionedMap[K comparable, V any]() *VersionedMap[K, V] {
+	return NewVersionedMapWithRetention[K, V](DefaultVersionRetention)
+}
+
+// NewVersionedMapWithRetention creates an empty VersionedMap at version 0 keeping the number of prior versions for
+// At and Diff. A retention of 0 keeps only the current version.
+func NewVersionedMapWithRetention[K comparable, V any](retention int) *VersionedMap[K, V] {
+	if retention < 0 {
+		retention = 0
+	}
+	m := &VersionedMap[K, V]{seed: maphash.MakeSeed(), retention: retention}
+	initial := &mapVersion[K, V]{m: m, shards: make([]map[K]V, DefaultShardCount)}
+	m.current.Store(initial)
+	m.history = []*mapVersion[K, V]{initial}
+	return m
+}
+
+// Get returns the value of the key in the latest version and true if the key exists
+func (m *VersionedMap[K, V]) Get(k K) (V, bool) {
+	return m.current.Load().Get(k)
+}
+
+// Contains returns true if the key exists in the latest version
+func (m *VersionedMap[K, V]) Contains(k K) bool {
+	return m.current.Load().Contains(k)
+}
+
+// Len returns the number of keys of the latest version
+func (m *VersionedMap[K, V]) Len() int {
+	return m.current.Load().size
+}
+
+// Version returns the number of the latest version
+func (m *VersionedMap[K, V]) Version() uint64 {
+	return m.current.Load().version
+}
+
+// Snapshot returns the latest version
+func (m *VersionedMap[K, V]) Snapshot() ReadOnlyMap[K, V] {
+	return m.current.Load()
+}
+
+// Update calls fn with a draft of the next version and publishes it atomically when fn returns, unless fn changed
+// nothing. It returns the number of the latest version. The draft must not be used after fn returns; if fn panics
+// nothing is published.
+func (m *VersionedMap[K, V]) Update(fn func(draft MutableDraft[K, V])) uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	base := m.current.Load()
+	draft := &versionedDraft[K, V]{
+		base:    base,
+		shards:  append([]map[K]V{}, base.shards...),
+		copied:  make([]bool, len(base.shards)),
+		size:    base.size,
+		updated: make(map[K]struct{}),
+	}
+	fn(draft)
+	if len(draft.order) == 0 {
+		return base.version
+	}
+	next := &mapVersion[K, V]{m: m, version: base.version + 1, shards: draft.shards, size: draft.size,
+		updated: draft.order}
+	m.current.Store(next)
+	m.history = append(m.history, next)
+	if extra := len(m.history) - m.retention - 1; extra > 0 {
+		clear(m.history[:extra])
+		m.history = m.history[extra:]
+	}
+	return next.version
+}
+
+// At returns the version if it is retained
+func (m *VersionedMap[K, V]) At(version uint64) (ReadOnlyMap[K, V], bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if v := m.at(version); v != nil {
+		return v, true
+	}
+	return nil, false
+}
+
+// Diff returns the keys added, removed and changed from the version from to the version to. The values are compared
+// with reflect.DeepEqual. ErrVersionNotRetained is returned if either version is not retained.
+func (m *VersionedMap[K, V]) Diff(from, to uint64) (diff MapDiff[K], err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	fromVersion, toVersion := m.at(from), m.at(to)
+	if fromVersion == nil || toVersion == nil {
+		err = ErrVersionNotRetained
+		return
+	}
+	low, high := min(from, to), max(from, to)
+	seen := make(map[K]struct{})
+	// the versions between two retained versions are retained as well
+	for _, v := range m.history {
+		if v.version <= low || v.version > high {
+			continue
+		}
+		for _, k := range v.updated {
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			before, inFrom := fromVersion.Get(k)
+			after, inTo := toVersion.Get(k)
+			switch {
+			case inTo && !inFrom:
+				diff.Added = append(diff.Added, k)
+			case inFrom && !inTo:
+				diff.Removed = append(diff.Removed, k)
+			case inFrom && !reflect.DeepEqual(before, after):
+				diff.Changed = append(diff.Changed, k)
+			}
+		}
+	}
+	return
+}
+
+// at returns the retained version, nil if it is not retained. The caller is expected to hold the mutex.
+func (m *VersionedMap[K, V]) at(version uint64) *mapVersion[K, V] {
+	first := m.history[0].version
+	if version < first || version-first >= uint64(len(m.history)) {
+		return nil
+	}
+	return m.history[version-first]
+}
+
+// shard returns the index of the shard of the key
+func (m *VersionedMap[K, V]) shard(k K) int {
+	return int(maphash.Comparable(m.seed, k) % DefaultShardCount)
+}
+
+// Get returns the value of the key and true if the key exists
+func (v *mapVersion[K, V]) Get(k K) (value V, ok bool) {
+	value, ok = v.shards[v.m.shard(k)][k]
+	return
+}
+
+// Contains returns true if the key exists
+func (v *mapVersion[K, V]) Contains(k K) bool {
+	_, ok := v.shards[v.m.shard(k)][k]
+	return ok
+}
+
+// Len returns the number of keys
+func (v *mapVersion[K, V]) Len() int {
+	return v.size
+}
+
+// Keys returns the keys
+func (v *mapVersion[K, V]) Keys() []K {
+	keys := make([]K, 0, v.size)
+	for _, shard := range v.shards {
+		for k := range shard {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Range calls fn for each key and value until fn returns false
+func (v *mapVersion[K, V]) Range(fn func(k K, v V) bool) {
+	for _, shard := range v.shards {
+		for key, value := range shard {
+			if !fn(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Version returns the version of the map
+func (v *mapVersion[K, V]) Version() uint64 {
+	return v.version
+}
+
+// versionedDraft is the MutableDraft of VersionedMap.Update, copying the shards of the base version on write
+type versionedDraft[K comparable, V any] struct {
+	base    *mapVersion[K, V]
+	shards  []map[K]V
+	copied  []bool
+	size    int
+	updated map[K]struct{}
+	order   []K
+}
+
+// Get returns the value of the key and true if the key exists
+func (d *versionedDraft[K, V]) Get(k K) (v V, ok bool) {
+	v, ok = d.shards[d.base.m.shard(k)][k]
+	return
+}
+
+// Contains returns true if the key exists
+func (d *versionedDraft[K, V]) Contains(k K) bool {
+	_, ok := d.shards[d.base.m.shard(k)][k]
+	return ok
+}
+
+// Len returns the number of keys
+func (d *versionedDraft[K, V]) Len() int {
+	return d.size
+}
+
+// Put sets the value of the key
+func (d *versionedDraft[K, V]) Put(k K, v V) {
+	shard := d.writable(k)
+	if _, ok := shard[k]; !ok {
+		d.size++
+	}
+	shard[k] = v
+}
+
+// Delete removes the key and returns true if it existed
+func (d *versionedDraft[K, V]) Delete(k K) (ok bool) {
+	i := d.base.m.shard(k)
+	if _, ok = d.shards[i][k]; ok {
+		delete(d.writable(k), k)
+		d.size--
+	}
+	return
+}
+
+// writable returns the shard of the key, copied from the base version on the first write, and records the key
+func (d *versionedDraft[K, V]) writable(k K) map[K]V {
+	if _, ok := d.updated[k]; !ok {
+		d.updated[k] = struct{}{}
+		d.order = append(d.order, k)
+	}
+	i := d.base.m.shard(k)
+	if !d.copied[i] {
+		if d.shards[i] == nil {
+			d.shards[i] = make(map[K]V)
+		} else {
+			d.shards[i] = maps.Clone(d.shards[i])
+		}
+		d.copied[i] = true
+	}
+	return d.shards[i]
+}
@@ -0,0 +1,248 @@
+package collections
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestVersionedMap_Update(t *testing.T) {
+	m := NewVersionedMap[string, int]()
+	assert.Equal(t, uint64(0), m.Version())
+	_, ok := m.Get("a")
+	assert.False(t, ok)
+
+	version := m.Update(func(draft MutableDraft[string, int]) {
+		draft.Put("a", 1)
+		draft.Put("b", 2)
+		draft.Put("a", 3)
+		// the draft sees its own writes
+		v, ok := draft.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 3, v)
+		assert.Equal(t, 2, draft.Len())
+		assert.True(t, draft.Delete("b"))
+		assert.False(t, draft.Delete("b"))
+		assert.False(t, draft.Contains("b"))
+	})
+	assert.Equal(t, uint64(1), version)
+	assert.Equal(t, uint64(1), m.Version())
+	assert.Equal(t, 1, m.Len())
+	v, ok := m.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+	assert.False(t, m.Contains("b"))
+
+	// an update changing nothing publishes no version
+	assert.Equal(t, uint64(1), m.Update(func(draft MutableDraft[string, int]) {
+		draft.Delete("missing")
+	}))
+}
+
+func TestVersionedMap_SnapshotIsolation(t *testing.T) {
+	m := NewVersionedMap[int, string]()
+	m.Update(func(draft MutableDraft[int, string]) {
+		for i := 0; i < 100; i++ {
+			draft.Put(i, "v1")
+		}
+	})
+	snapshot := m.Snapshot()
+	m.Update(func(draft MutableDraft[int, string]) {
+		draft.Put(0, "v2")
+		draft.Delete(1)
+		draft.Put(100, "v2")
+	})
+	assert.Equal(t, uint64(1), snapshot.Version())
+	assert.Equal(t, 100, snapshot.Len())
+	v, _ := snapshot.Get(0)
+	assert.Equal(t, "v1", v)
+	assert.True(t, snapshot.Contains(1))
+	assert.False(t, snapshot.Contains(100))
+	count := 0
+	snapshot.Range(func(k int, v string) bool {
+		assert.Equal(t, "v1", v)
+		count++
+		return true
+	})
+	assert.Equal(t, 100, count)
+	keys := snapshot.Keys()
+	sort.Ints(keys)
+	assert.Equal(t, 99, keys[99])
+
+	v, _ = m.Get(0)
+	assert.Equal(t, "v2", v)
+	assert.Equal(t, 100, m.Len())
+}
+
+func TestVersionedMap_ConcurrentReaders(t *testing.T) {
+	m := NewVersionedMap[int, int]()
+	const keys = 64
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// every version holds the same value for all the keys
+				snapshot := m.Snapshot()
+				first, ok := snapshot.Get(0)
+				for k := 1; k < keys && ok; k++ {
+					if v, _ := snapshot.Get(k); v != first {
+						t.Errorf("version %d holds %d and %d", snapshot.Version(), first, v)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		m.Update(func(draft MutableDraft[int, int]) {
+			for k := 0; k < keys; k++ {
+				draft.Put(k, i)
+			}
+		})
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestVersionedMap_History(t *testing.T) {
+	m := NewVersionedMapWithRetention[string, int](2)
+	for i := 1; i <= 4; i++ {
+		m.Update(func(draft MutableDraft[string, int]) {
+			draft.Put("k", i)
+		})
+	}
+	_, ok := m.At(1)
+	assert.False(t, ok)
+	for version := uint64(2); version <= 4; version++ {
+		snapshot, ok := m.At(version)
+		assert.True(t, ok)
+		v, _ := snapshot.Get("k")
+		assert.Equal(t, int(version), v)
+	}
+	_, ok = m.At(5)
+	assert.False(t, ok)
+	_, err := m.Diff(1, 4)
+	assert.Equal(t, ErrVersionNotRetained, err)
+}
+
+func TestVersionedMap_Diff(t *testing.T) {
+	m := NewVersionedMap[string, []string]()
+	from := m.Update(func(draft MutableDraft[string, []string]) {
+		draft.Put("kept", []string{"a"})
+		draft.Put("changed", []string{"a"})
+		draft.Put("removed", []string{"a"})
+		draft.Put("restored", []string{"a"})
+		draft.Put("rewritten", []string{"a"})
+	})
+	m.Update(func(draft MutableDraft[string, []string]) {
+		draft.Put("changed", []string{"b"})
+		draft.Delete("removed")
+		draft.Delete("restored")
+		draft.Put("added", []string{"a"})
+		// a new but equal value is not a change
+		draft.Put("rewritten", []string{"a"})
+	})
+	to := m.Update(func(draft MutableDraft[string, []string]) {
+		draft.Put("restored", []string{"a"})
+		draft.Put("transient", []string{"a"})
+	})
+	m.Update(func(draft MutableDraft[string, []string]) {
+		draft.Delete("transient")
+	})
+
+	diff, err := m.Diff(from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"added", "transient"}, diff.Added)
+	assert.Equal(t, []string{"removed"}, diff.Removed)
+	assert.Equal(t, []string{"changed"}, diff.Changed)
+
+	// the other way around
+	diff, err = m.Diff(to, from)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"removed"}, diff.Added)
+	assert.Equal(t, []string{"added", "transient"}, diff.Removed)
+
+	diff, err = m.Diff(to, to)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(diff.Added)+len(diff.Removed)+len(diff.Changed))
+}
+
+func TestVersionedMap_ReadsDoNotAllocate(t *testing.T) {
+	m := NewVersionedMap[string, int]()
+	m.Update(func(draft MutableDraft[string, int]) {
+		draft.Put("a", 1)
+	})
+	allocs := testing.AllocsPerRun(100, func() {
+		m.Get("a")
+		m.Snapshot().Get("a")
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+// BenchmarkVersionedMap_Reads compares the reads of the VersionedMap, a sync.Map and a map with a RWMutex while a
+// writer updates a key every 10 microseconds
+func BenchmarkVersionedMap_Reads(b *testing.B) {
+	cases := []struct {
+		name  string
+		setup func() (get func(k int), put func(k int))
+	}{
+		{"VersionedMap", func() (func(k int), func(k int)) {
+			m := NewVersionedMap[int, int]()
+			put := func(k int) { m.Update(func(draft MutableDraft[int, int]) { draft.Put(k, k) }) }
+			return func(k int) { m.Get(k) }, put
+		}},
+		{"sync.Map", func() (func(k int), func(k int)) {
+			var m sync.Map
+			return func(k int) { m.Load(k) }, func(k int) { m.Store(k, k) }
+		}},
+		{"RWMutex", func() (func(k int), func(k int)) {
+			m := &mutexMap{items: make(map[int]int)}
+			return func(k int) { m.Get(k) }, func(k int) { m.Put(k, k) }
+		}},
+	}
+	for _, bm := range cases {
+		b.Run(bm.name, func(b *testing.B) {
+			get, put := bm.setup()
+			for k := 0; k < benchKeys; k++ {
+				put(k)
+			}
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(10 * time.Microsecond)
+				defer ticker.Stop()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					case <-ticker.C:
+						put(i % benchKeys)
+					}
+				}
+			}()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					get(r.Intn(benchKeys))
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
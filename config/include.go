@@ -0,0 +1,219 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// IncludeKey is the reserved key of a document listing the files merged at its position
+	IncludeKey = "$include"
+	// OptionalIncludeKey is the reserved key of a document listing the files merged at its position if they exist
+	OptionalIncludeKey = "$include?"
+	// MaxIncludeDepth is the maximum number of nested includes
+	MaxIncludeDepth = 16
+)
+
+// ErrIncludeCycle is returned when a file includes itself, directly or through other files
+var ErrIncludeCycle = errors.New("include cycle")
+
+// ErrIncludeDepth is returned when the includes are nested deeper than MaxIncludeDepth
+var ErrIncludeDepth = errors.New("include depth exceeded")
+
+// ErrIncludeNotFound is returned when a required include matches no file
+var ErrIncludeNotFound = errors.New("include not found")
+
+// Document is a configuration document composed from a YAML or JSON file and the files it includes.
+//
+// An include directive is a reserved key whose value is a path or a list of paths:
+//
+//	$include: ["db.yaml", "features/*.yaml"]
+//	$include?: ["${DEPLOY_ENV}/overrides.yaml"]
+//
+// The paths are relative to the including file, may reference environment variables and are glob expanded in
+// lexical order. The files are deep merged into the mapping holding the directive, at the position of the directive:
+// the keys before it are overridden by the included files and the keys after it override them. Maps are merged key
+// by key, any other value replaces the previous one. A required include matching no file is an error; the optional
+// ones are skipped.
+type Document struct {
+	values  map[string]any
+	origins map[string]string
+}
+
+// Values returns the merged values of the document
+func (d *Document) Values() map[string]any {
+	return d.values
+}
+
+// Origin returns the file that supplied the value of the key, a dot separated path such as db.pool.size.
+// For a map it returns the file of its latest merged key. It returns an empty string if the key does not exist.
+func (d *Document) Origin(key string) string {
+	return d.origins[key]
+}
+
+// Keys returns the sorted dot separated paths of the values of the document, excluding the maps
+func (d *Document) Keys() (keys []string) {
+	for k, v := range d.flatten() {
+		if _, isMap := v.(map[string]any); !isMap {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// Write writes the merged document as YAML
+func (d *Document) Write(w io.Writer) (err error) {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err = enc.Encode(d.values); err == nil {
+		err = enc.Close()
+	}
+	return
+}
+
+// flatten returns the values of the document by their dot separated path
+func (d *Document) flatten() map[string]any {
+	flat := make(map[string]any)
+	var walk func(prefix string, m map[string]any)
+	walk = func(prefix string, m map[string]any) {
+		for k, v := range m {
+			flat[prefix+k] = v
+			if child, ok := v.(map[string]any); ok {
+				walk(prefix+k+".", child)
+			}
+		}
+	}
+	walk("", d.values)
+	return flat
+}
+
+// ResolveIncludes loads the YAML or JSON file at the path and resolves its includes. The result can be written with
+// Document.Write to check the composed configuration.
+func ResolveIncludes(path string) (doc *Document, err error) {
+	r := &includeResolver{origins: make(map[string]string)}
+	var abs string
+	if abs, err = filepath.Abs(path); err == nil {
+		doc = &Document{values: make(map[string]any), origins: r.origins}
+		if err = r.load(abs, doc.values, ""); err != nil {
+			doc = nil
+		}
+	}
+	return
+}
+
+// includeResolver loads the files of a document, tracking the chain of includes
+type includeResolver struct {
+	chain   []string
+	origins map[string]string
+}
+
+// load merges the file into the values, prefixing the origins with the prefix
+func (r *includeResolver) load(path string, values map[string]any, prefix string) (err error) {
+	for _, p := range r.chain {
+		if p == path {
+			return fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(append(r.chain, path), " -> "))
+		}
+	}
+	if len(r.chain) > MaxIncludeDepth {
+		return fmt.Errorf("%w: %s", ErrIncludeDepth, strings.Join(append(r.chain, path), " -> "))
+	}
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil {
+		return
+	}
+	var root yaml.Node
+	if err = yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(root.Content) == 0 {
+		return
+	}
+	node := root.Content[0]
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: the document is not a mapping", path)
+	}
+	r.chain = append(r.chain, path)
+	err = r.merge(node, values, prefix)
+	r.chain = r.chain[:len(r.chain)-1]
+	return
+}
+
+// merge merges the mapping node into the values in order, resolving the include directives
+func (r *includeResolver) merge(node *yaml.Node, values map[string]any, prefix string) (err error) {
+	file := r.chain[len(r.chain)-1]
+	for i := 0; i+1 < len(node.Content) && err == nil; i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch {
+		case key == IncludeKey || key == OptionalIncludeKey:
+			err = r.include(value, values, prefix, key == OptionalIncludeKey)
+		case value.Kind == yaml.MappingNode:
+			child, ok := values[key].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				values[key] = child
+				r.forget(prefix + key)
+			}
+			r.origins[prefix+key] = file
+			err = r.merge(value, child, prefix+key+".")
+		default:
+			var v any
+			if err = value.Decode(&v); err == nil {
+				values[key] = v
+				r.forget(prefix + key)
+				r.origins[prefix+key] = file
+			}
+		}
+	}
+	return
+}
+
+// include merges the files listed by the directive into the values
+func (r *includeResolver) include(value *yaml.Node, values map[string]any, prefix string, optional bool) (err error) {
+	var patterns []string
+	if value.Kind == yaml.ScalarNode {
+		patterns = []string{value.Value}
+	} else if err = value.Decode(&patterns); err != nil {
+		return fmt.Errorf("%s: invalid include %w", r.chain[len(r.chain)-1], err)
+	}
+	dir := filepath.Dir(r.chain[len(r.chain)-1])
+	for _, pattern := range patterns {
+		pattern = os.ExpandEnv(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		var matches []string
+		if matches, err = filepath.Glob(pattern); err != nil {
+			return
+		}
+		if len(matches) == 0 {
+			if optional {
+				continue
+			}
+			return fmt.Errorf("%w: %s included by %s", ErrIncludeNotFound, pattern, r.chain[len(r.chain)-1])
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if err = r.load(match, values, prefix); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// forget removes the origins of the keys under the replaced key
+func (r *includeResolver) forget(key string) {
+	for k := range r.origins {
+		if strings.HasPrefix(k, key+".") {
+			delete(r.origins, k)
+		}
+	}
+}
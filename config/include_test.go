@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files relative to a temporary directory and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestResolveIncludes_Nested(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app.yaml": `name: app
+db:
+  host: localhost
+  port: 5432
+$include: ["conf/db.yaml", "features/*.yaml"]
+log:
+  level: info
+`,
+		"conf/db.yaml": `db:
+  host: db.internal
+  $include: pool/pool.json
+`,
+		"conf/pool/pool.json": `{"pool": {"size": 10, "idle": 2}}`,
+		"features/b.yaml":     "features:\n  search: false\n  export: true\n",
+		"features/a.yaml":     "features:\n  search: true\nlog:\n  level: debug\n",
+	})
+	doc, err := ResolveIncludes(filepath.Join(dir, "app.yaml"))
+	if err != nil {
+		t.Fatalf("ResolveIncludes() error = %v", err)
+	}
+	want := map[string]any{
+		"name": "app",
+		"db": map[string]any{
+			"host": "db.internal",
+			"port": 5432,
+			"pool": map[string]any{"size": 10, "idle": 2},
+		},
+		// b.yaml is merged after a.yaml
+		"features": map[string]any{"search": false, "export": true},
+		// the key after the directive overrides the includes
+		"log": map[string]any{"level": "info"},
+	}
+	if !reflect.DeepEqual(doc.Values(), want) {
+		t.Errorf("Values() = %v, want %v", doc.Values(), want)
+	}
+
+	// the origins point at the leaf files through two include levels
+	origins := map[string]string{
+		"name":            "app.yaml",
+		"db.host":         "conf/db.yaml",
+		"db.port":         "app.yaml",
+		"db.pool.size":    "conf/pool/pool.json",
+		"db.pool.idle":    "conf/pool/pool.json",
+		"features.search": "features/b.yaml",
+		"log.level":       "app.yaml",
+		"missing":         "",
+	}
+	for key, file := range origins {
+		if file != "" {
+			file = filepath.Join(dir, file)
+		}
+		if got := doc.Origin(key); got != file {
+			t.Errorf("Origin(%s) = %s, want %s", key, got, file)
+		}
+	}
+	keys := []string{"db.host", "db.pool.idle", "db.pool.size", "db.port", "features.export", "features.search",
+		"log.level", "name"}
+	if !reflect.DeepEqual(doc.Keys(), keys) {
+		t.Errorf("Keys() = %v", doc.Keys())
+	}
+
+	var buf bytes.Buffer
+	if err = doc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), IncludeKey) || !strings.Contains(buf.String(), "host: db.internal") {
+		t.Errorf("Write() = %s", buf.String())
+	}
+}
+
+func TestResolveIncludes_GlobOrder(t *testing.T) {
+	files := map[string]string{"main.yaml": "$include: parts/*.yaml\n"}
+	for _, name := range []string{"10", "02", "b", "a", "1"} {
+		files["parts/"+name+".yaml"] = "order: [" + name + "]\nlast: " + name + "\n"
+	}
+	dir := writeFiles(t, files)
+	for i := 0; i < 5; i++ {
+		doc, err := ResolveIncludes(filepath.Join(dir, "main.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// lexical order: 02, 1, 10, a, b
+		if got := doc.Values()["last"]; got != "b" {
+			t.Errorf("last = %v, want b", got)
+		}
+		if got := doc.Origin("order"); got != filepath.Join(dir, "parts", "b.yaml") {
+			t.Errorf("Origin(order) = %s", got)
+		}
+	}
+}
+
+func TestResolveIncludes_Cycle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yaml":     "$include: sub/b.yaml\n",
+		"sub/b.yaml": "$include: ../a.yaml\n",
+		"self.yaml":  "$include: self.yaml\n",
+	})
+	_, err := ResolveIncludes(filepath.Join(dir, "a.yaml"))
+	if !errors.Is(err, ErrIncludeCycle) {
+		t.Fatalf("error = %v, want an include cycle", err)
+	}
+	a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "sub", "b.yaml")
+	if want := "include cycle: " + a + " -> " + b + " -> " + a; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	if _, err = ResolveIncludes(filepath.Join(dir, "self.yaml")); !errors.Is(err, ErrIncludeCycle) {
+		t.Errorf("error = %v, want an include cycle", err)
+	}
+}
+
+func TestResolveIncludes_Depth(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i <= MaxIncludeDepth+1; i++ {
+		files[filepath.Join("d", string(rune('a'+i))+".yaml")] = "$include: " + string(rune('a'+i+1)) + ".yaml\n"
+	}
+	files[filepath.Join("d", string(rune('a'+MaxIncludeDepth+2))+".yaml")] = "leaf: true\n"
+	dir := writeFiles(t, files)
+	if _, err := ResolveIncludes(filepath.Join(dir, "d", "a.yaml")); !errors.Is(err, ErrIncludeDepth) {
+		t.Errorf("error = %v, want the depth exceeded", err)
+	}
+	doc, err := ResolveIncludes(filepath.Join(dir, "d", string(rune('a'+2))+".yaml"))
+	if err != nil || doc.Values()["leaf"] != true {
+		t.Errorf("got %v, %v with %d nested includes", doc, err, MaxIncludeDepth)
+	}
+}
+
+func TestResolveIncludes_Optional(t *testing.T) {
+	t.Setenv("DEPLOY_ENV", "staging")
+	dir := writeFiles(t, map[string]string{
+		"app.yaml":               "$include?: [\"${DEPLOY_ENV}/overrides.yaml\", \"local.yaml\"]\nport: 80\n",
+		"staging/overrides.yaml": "port: 8080\nreplicas: 2\n",
+		"required.yaml":          "$include: [\"${DEPLOY_ENV}/missing.yaml\"]\n",
+	})
+	doc, err := ResolveIncludes(filepath.Join(dir, "app.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Values()["port"] != 80 || doc.Values()["replicas"] != 2 {
+		t.Errorf("Values() = %v", doc.Values())
+	}
+	_, err = ResolveIncludes(filepath.Join(dir, "required.yaml"))
+	if !errors.Is(err, ErrIncludeNotFound) || !strings.Contains(err.Error(), filepath.Join("staging", "missing.yaml")) {
+		t.Errorf("error = %v, want the missing include", err)
+	}
+}
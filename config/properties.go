@@ -37,13 +37,10 @@ func NewProperties() *Properties {
 func (p *Properties) resolve(v *value) string {
 	var sb strings.Builder
 	if v.hasVars {
-		//Check if the value starts with a variable
-		if varName, ok := v.vars[0]; ok {
-			sb.WriteString(p.resolveAndGet(varName, createVarStructure(varName)))
-		}
+		//Each content is followed by its variable, if any
 		for i, c := range v.content {
+			sb.WriteString(c)
 			if varName, ok := v.vars[i+1]; ok {
-				sb.WriteString(c)
 				sb.WriteString(p.resolveAndGet(varName, createVarStructure(varName)))
 			}
 		}
@@ -81,8 +78,8 @@ func createValue(k, v string) *value {
 		val.content = append(val.content, v)
 	} else {
 		for i, c := range v {
-			//safe to check the i-1 and i-2 as the min length at this point is at-least 4
-			if c == textutils.OpenBraceChar && v[i-1] == textutils.DollarChar && v[i-2] != textutils.BackSlashChar {
+			if c == textutils.OpenBraceChar && i > 0 && v[i-1] == textutils.DollarChar &&
+				(i < 2 || v[i-2] != textutils.BackSlashChar) {
 				val.content = append(val.content, v[startIndex:i-1])
 				varStart = i + 1
 			} else if varStart > 0 && c == textutils.CloseBraceChar {
@@ -101,8 +98,10 @@ func createValue(k, v string) *value {
 
 		}
 		if varCount == 0 { // No variables were found
-			val.content = append(val.content, v)
+			val.content = []string{v}
 		} else if varStart > 0 { // There may be existing variables but last one was identified without a end
+			val.content[len(val.content)-1] = v[startIndex:]
+		} else { // The content after the last variable
 			val.content = append(val.content, v[startIndex:])
 		}
 	}
@@ -111,13 +110,15 @@ func createValue(k, v string) *value {
 }
 
 // resolveAndGet will dynamically check the variables in the value for the given key. If the key is absent then it will
-// return the default val d passed
+// try the resolver registered for its prefix, if any, and return the default val d passed otherwise. The caller must
+// hold the lock.
 func (p *Properties) resolveAndGet(k, d string) string {
-	p.RLock()
-	defer p.RUnlock()
 	if value, ok := p.props[k]; ok {
 		return p.resolve(value)
 	}
+	if v, ok := resolveVar(k); ok {
+		return v
+	}
 	return d
 }
 
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"strings"
+	"sync"
+)
+
+// VarResolver resolves the name of the variables ${prefix:name} of the properties for the prefix it is registered
+// with
+type VarResolver func(name string) (string, error)
+
+var resolversMutex sync.RWMutex
+var resolvers = make(map[string]VarResolver)
+
+// RegisterResolver registers the resolver of the variables of the prefix, e.g. secret for ${secret:NAME}. The
+// variables are resolved when no property has their name, and are kept as is when the resolver fails.
+func RegisterResolver(prefix string, resolver VarResolver) {
+	resolversMutex.Lock()
+	defer resolversMutex.Unlock()
+	resolvers[prefix] = resolver
+}
+
+// resolveVar resolves the variable with the resolver of its prefix, if any
+func resolveVar(varName string) (v string, ok bool) {
+	prefix, name, found := strings.Cut(varName, ":")
+	if !found {
+		return
+	}
+	resolversMutex.RLock()
+	resolver := resolvers[prefix]
+	resolversMutex.RUnlock()
+	if resolver != nil {
+		var err error
+		v, err = resolver(name)
+		ok = err == nil
+	}
+	return
+}
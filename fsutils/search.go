@@ -0,0 +1,470 @@
+package fsutils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// binaryCheckSize is the size of the first block of a file checked for NUL bytes
+	binaryCheckSize = 8000
+	// inMemorySearchSize is the size up to which a file is read at once. Larger files are scanned line by line.
+	inMemorySearchSize = 8 << 20
+	// maxSearchLineSize is the size of the longest line that can be searched
+	maxSearchLineSize = 16 << 20
+)
+
+// ErrEmptySearchPattern is returned when the search pattern is empty
+var ErrEmptySearchPattern = errors.New("search pattern is empty")
+
+// SearchOptions for searching the content of files.
+type SearchOptions struct {
+	// Pattern to search for
+	Pattern string
+	// Regexp indicates that the Pattern is a regular expression. Otherwise, it is searched literally.
+	Regexp bool
+	// IgnoreCase makes the search case-insensitive
+	IgnoreCase bool
+	// Include globs of the files searched. The globs are matched with path.Match against the name and the slash
+	// separated path relative to the root. All the files are searched if empty.
+	Include []string
+	// Exclude globs of the files and the directories skipped. The globs are matched like the Include globs.
+	Exclude []string
+	// MaxFileSize skips the files larger than the size in bytes. No limit if 0.
+	MaxFileSize int64
+	// MaxResults stops the search once the number of matching lines is reached. No limit if 0.
+	MaxResults int
+	// Before is the number of lines of context before the matching lines
+	Before int
+	// After is the number of lines of context after the matching lines
+	After int
+	// Concurrency is the number of files searched concurrently. Defaults to runtime.NumCPU().
+	Concurrency int
+	// IncludeBinary searches the binary files. Files with a NUL byte in the first block are binary and are skipped
+	// by default.
+	IncludeBinary bool
+	// MaxDepth is the depth of directories below the root that are searched. No limit if 0.
+	MaxDepth int
+	// FollowSymlinks follows the symbolic links. Links to the directories already visited are skipped.
+	FollowSymlinks bool
+}
+
+// SearchResult is a line matching the pattern, or an error searching a file if Err is set.
+type SearchResult struct {
+	// Path of the file
+	Path string
+	// Line number starting at 1
+	Line int
+	// Text of the line
+	Text string
+	// Matches are the index pairs of the matches in the Text and of their submatches as reported by
+	// regexp.Regexp.FindAllStringSubmatchIndex
+	Matches [][]int
+	// Before are the lines of context before the line
+	Before []string
+	// After are the lines of context after the line
+	After []string
+	// Err is the error searching the file
+	Err error
+}
+
+// SearchSource is a file searched by a Searcher
+type SearchSource struct {
+	// Path of the file reported in the results
+	Path string
+	// Size of the file in bytes
+	Size int64
+	// Open opens the content of the file
+	Open func() (io.ReadCloser, error)
+}
+
+// SourceWalker calls visit with the files to search until visit returns false
+type SourceWalker func(ctx context.Context, visit func(src SearchSource) bool) error
+
+// Searcher searches the content of files
+type Searcher struct {
+	opts    SearchOptions
+	re      *regexp.Regexp
+	literal []byte
+}
+
+// NewSearcher creates a new Searcher with the options
+func NewSearcher(opts SearchOptions) (s *Searcher, err error) {
+	if opts.Pattern == "" {
+		err = ErrEmptySearchPattern
+		return
+	}
+	for _, glob := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err = path.Match(glob, ""); err != nil {
+			err = fmt.Errorf("%w: %s", err, glob)
+			return
+		}
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = runtime.NumCPU()
+	}
+	s = &Searcher{opts: opts}
+	expr := opts.Pattern
+	if !opts.Regexp {
+		expr = regexp.QuoteMeta(expr)
+		if !opts.IgnoreCase {
+			s.literal = []byte(opts.Pattern)
+		}
+	}
+	if opts.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	if s.re, err = regexp.Compile(expr); err != nil {
+		s = nil
+	}
+	return
+}
+
+// Included reports whether the file at the slash separated path relative to the root is searched
+func (s *Searcher) Included(rel string) bool {
+	if len(s.opts.Include) == 0 {
+		return true
+	}
+	return matchesGlob(s.opts.Include, rel)
+}
+
+// Excluded reports whether the file or the directory at the slash separated path relative to the root or one of its
+// parent directories is excluded
+func (s *Searcher) Excluded(rel string) bool {
+	if len(s.opts.Exclude) == 0 {
+		return false
+	}
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' && matchesGlob(s.opts.Exclude, rel[:i]) {
+			return true
+		}
+	}
+	return matchesGlob(s.opts.Exclude, rel)
+}
+
+// Deep reports whether the slash separated path relative to the root is below the MaxDepth
+func (s *Searcher) Deep(rel string) bool {
+	return s.opts.MaxDepth > 0 && strings.Count(rel, "/") > s.opts.MaxDepth
+}
+
+func matchesGlob(globs []string, rel string) bool {
+	name := path.Base(rel)
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Search searches the files of the walker concurrently. The results are sent over the returned channel which is
+// closed once the search is complete. The channel must be drained or the context cancelled to stop the search.
+func (s *Searcher) Search(ctx context.Context, walker SourceWalker) <-chan SearchResult {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan SearchResult, s.opts.Concurrency)
+	sources := make(chan SearchSource, s.opts.Concurrency)
+	var count atomic.Int64
+	emit := func(result SearchResult) bool {
+		done := ctx.Done()
+		if s.opts.MaxResults > 0 && result.Err == nil {
+			n := count.Add(1)
+			if n > int64(s.opts.MaxResults) {
+				cancel()
+				return false
+			}
+			if n == int64(s.opts.MaxResults) {
+				defer cancel()
+			}
+			// the results within the limit are sent even if another worker reached the limit
+			done = parent.Done()
+		}
+		select {
+		case results <- result:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(sources)
+		err := walker(ctx, func(src SearchSource) bool {
+			select {
+			case sources <- src:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			emit(SearchResult{Err: err})
+		}
+	}()
+	for i := 0; i < s.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range sources {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := s.searchSource(ctx, src, emit); err != nil && ctx.Err() == nil {
+					emit(SearchResult{Path: src.Path, Err: err})
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(results)
+	}()
+	return results
+}
+
+// searchSource searches the file sending the matching lines to emit
+func (s *Searcher) searchSource(ctx context.Context, src SearchSource, emit func(SearchResult) bool) (err error) {
+	if s.opts.MaxFileSize > 0 && src.Size > s.opts.MaxFileSize {
+		return
+	}
+	var rc io.ReadCloser
+	if rc, err = src.Open(); err != nil {
+		return
+	}
+	defer rc.Close()
+	reader := bufio.NewReaderSize(rc, 64*1024)
+	if !s.opts.IncludeBinary {
+		head, _ := reader.Peek(binaryCheckSize)
+		if bytes.IndexByte(head, 0) >= 0 {
+			return
+		}
+	}
+	var scanner *bufio.Scanner
+	if src.Size >= 0 && src.Size <= inMemorySearchSize {
+		var content []byte
+		if content, err = io.ReadAll(reader); err != nil {
+			return
+		}
+		if !s.match(content) {
+			return
+		}
+		scanner = bufio.NewScanner(bytes.NewReader(content))
+	} else {
+		scanner = bufio.NewScanner(reader)
+	}
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSearchLineSize)
+	return s.scan(ctx, src.Path, scanner, emit)
+}
+
+// match reports whether the content matches the pattern
+func (s *Searcher) match(content []byte) bool {
+	if s.literal != nil {
+		return bytes.Contains(content, s.literal)
+	}
+	return s.re.Match(content)
+}
+
+// scan emits the matching lines of the scanner with their context lines
+func (s *Searcher) scan(ctx context.Context, path string, scanner *bufio.Scanner, emit func(SearchResult) bool) (err error) {
+	var before []string
+	var pending []*SearchResult
+	lineNum := 0
+	for scanner.Scan() {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		lineNum++
+		line := scanner.Bytes()
+		var text string
+		if s.opts.After > 0 && len(pending) > 0 {
+			text = string(line)
+			for _, result := range pending {
+				result.After = append(result.After, text)
+			}
+			for len(pending) > 0 && len(pending[0].After) == s.opts.After {
+				if !emit(*pending[0]) {
+					return
+				}
+				pending = pending[1:]
+			}
+		}
+		if s.match(line) {
+			if text == "" {
+				text = string(line)
+			}
+			result := &SearchResult{
+				Path:    path,
+				Line:    lineNum,
+				Text:    text,
+				Matches: s.re.FindAllStringSubmatchIndex(text, -1),
+				Before:  append([]string(nil), before...),
+			}
+			if s.opts.After > 0 {
+				pending = append(pending, result)
+			} else if !emit(*result) {
+				return
+			}
+		}
+		if s.opts.Before > 0 {
+			if text == "" {
+				text = string(line)
+			}
+			if len(before) == s.opts.Before {
+				before = before[1:]
+			}
+			before = append(before, text)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	for _, result := range pending {
+		if !emit(*result) {
+			return
+		}
+	}
+	return
+}
+
+// Search searches the files under the root directory. The root can also be a file.
+// See SearchContext.
+func Search(root string, opts SearchOptions) (<-chan SearchResult, error) {
+	return SearchContext(context.Background(), root, opts)
+}
+
+// SearchContext searches the files under the root directory concurrently. The root can also be a file.
+// The matching lines are sent over the returned channel which is closed once the search is complete. The errors
+// reading the files are sent as results with the Err set. Cancelling the context stops the search.
+func SearchContext(ctx context.Context, root string, opts SearchOptions) (results <-chan SearchResult, err error) {
+	var s *Searcher
+	var info os.FileInfo
+	if info, err = os.Stat(root); err != nil {
+		return
+	}
+	if s, err = NewSearcher(opts); err != nil {
+		return
+	}
+	results = s.Search(ctx, func(ctx context.Context, visit func(src SearchSource) bool) error {
+		if !info.IsDir() {
+			visit(localSource(root, info))
+			return nil
+		}
+		w := &localWalker{searcher: s, visit: visit, visited: make(map[string]bool)}
+		_, walkErr := w.walk(ctx, root, "")
+		return walkErr
+	})
+	return
+}
+
+// SearchCollect searches the files under the root directory and returns the matching lines. The search stops at the
+// MaxResults. The first error reading the files is returned along with the results.
+func SearchCollect(ctx context.Context, root string, opts SearchOptions) (matches []SearchResult, err error) {
+	var results <-chan SearchResult
+	if results, err = SearchContext(ctx, root, opts); err != nil {
+		return
+	}
+	for result := range results {
+		if result.Err != nil {
+			if err == nil {
+				err = result.Err
+			}
+			continue
+		}
+		matches = append(matches, result)
+	}
+	return
+}
+
+func localSource(p string, info os.FileInfo) SearchSource {
+	return SearchSource{
+		Path: p,
+		Size: info.Size(),
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(p)
+		},
+	}
+}
+
+// localWalker walks the local directories for the Searcher
+type localWalker struct {
+	searcher *Searcher
+	visit    func(src SearchSource) bool
+	visited  map[string]bool
+}
+
+// walk visits the files of the directory. It returns false once the visit is stopped.
+func (w *localWalker) walk(ctx context.Context, dir, rel string) (ok bool, err error) {
+	if w.searcher.opts.FollowSymlinks {
+		var real string
+		if real, err = filepath.EvalSymlinks(dir); err != nil {
+			return
+		}
+		if w.visited[real] {
+			ok = true
+			return
+		}
+		w.visited[real] = true
+	}
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		p := filepath.Join(dir, entry.Name())
+		childRel := entry.Name()
+		if rel != "" {
+			childRel = rel + "/" + entry.Name()
+		}
+		if w.searcher.Excluded(childRel) {
+			continue
+		}
+		var childInfo os.FileInfo
+		if entry.Type()&os.ModeSymlink != 0 {
+			if !w.searcher.opts.FollowSymlinks {
+				continue
+			}
+			if childInfo, err = os.Stat(p); err != nil {
+				// broken links are skipped
+				err = nil
+				continue
+			}
+		} else if childInfo, err = entry.Info(); err != nil {
+			return
+		}
+		if childInfo.IsDir() {
+			if w.searcher.Deep(childRel + "/") {
+				continue
+			}
+			if ok, err = w.walk(ctx, p, childRel); err != nil || !ok {
+				return
+			}
+		} else if childInfo.Mode().IsRegular() && w.searcher.Included(childRel) {
+			if !w.visit(localSource(p, childInfo)) {
+				return
+			}
+		}
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,233 @@
+package fsutils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeSearchTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func relPaths(t *testing.T, root string, results []SearchResult) (paths []string) {
+	for _, result := range results {
+		rel, err := filepath.Rel(root, result.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.ToSlash(rel)+":"+strconv.Itoa(result.Line))
+	}
+	sort.Strings(paths)
+	return
+}
+
+func TestSearchCollect_RegexpSubmatches(t *testing.T) {
+	root := writeSearchTree(t, map[string]string{
+		"a.txt": "key=value\nnothing here\nname=golly\n",
+	})
+	results, err := SearchCollect(context.Background(), root, SearchOptions{Pattern: `(\w+)=(\w+)`, Regexp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("SearchCollect() got %d results, want 2", len(results))
+	}
+	if results[0].Line != 1 || results[0].Text != "key=value" {
+		t.Errorf("SearchCollect() got %d:%s", results[0].Line, results[0].Text)
+	}
+	want := [][]int{{0, 9, 0, 3, 4, 9}}
+	if !reflect.DeepEqual(results[0].Matches, want) {
+		t.Errorf("SearchCollect() Matches = %v, want %v", results[0].Matches, want)
+	}
+	if results[1].Line != 3 {
+		t.Errorf("SearchCollect() Line = %d, want 3", results[1].Line)
+	}
+}
+
+func TestSearchCollect_LiteralAndIgnoreCase(t *testing.T) {
+	root := writeSearchTree(t, map[string]string{
+		"a.txt": "a.b\naxb\nA.B\n",
+	})
+	results, err := SearchCollect(context.Background(), root, SearchOptions{Pattern: "a.b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relPaths(t, root, results); !reflect.DeepEqual(got, []string{"a.txt:1"}) {
+		t.Errorf("SearchCollect() = %v", got)
+	}
+	results, err = SearchCollect(context.Background(), root, SearchOptions{Pattern: "a.b", IgnoreCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relPaths(t, root, results); !reflect.DeepEqual(got, []string{"a.txt:1", "a.txt:3"}) {
+		t.Errorf("SearchCollect() = %v", got)
+	}
+}
+
+func TestSearchCollect_ContextLines(t *testing.T) {
+	root := writeSearchTree(t, map[string]string{
+		"a.txt": "match 1\nline 2\nline 3\nline 4\nmatch 5\n",
+	})
+	results, err := SearchCollect(context.Background(), root, SearchOptions{Pattern: "match", Before: 2, After: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("SearchCollect() got %d results, want 2", len(results))
+	}
+	first, last := results[0], results[1]
+	if first.Line != 1 || len(first.Before) != 0 || !reflect.DeepEqual(first.After, []string{"line 2", "line 3"}) {
+		t.Errorf("SearchCollect() first = %+v", first)
+	}
+	if last.Line != 5 || !reflect.DeepEqual(last.Before, []string{"line 3", "line 4"}) || len(last.After) != 0 {
+		t.Errorf("SearchCollect() last = %+v", last)
+	}
+}
+
+func TestSearchCollect_SkipsBinary(t *testing.T) {
+	root := writeSearchTree(t, map[string]string{
+		"text.txt": "golly\n",
+		"bin.dat":  "golly\x00\x01\x02\n",
+	})
+	results, err := SearchCollect(context.Background(), root, SearchOptions{Pattern: "golly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relPaths(t, root, results); !reflect.DeepEqual(got, []string{"text.txt:1"}) {
+		t.Errorf("SearchCollect() = %v", got)
+	}
+	results, err = SearchCollect(context.Background(), root, SearchOptions{Pattern: "golly", IncludeBinary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relPaths(t, root, results); !reflect.DeepEqual(got, []string{"bin.dat:1", "text.txt:1"}) {
+		t.Errorf("SearchCollect() = %v", got)
+	}
+}
+
+func TestSearchCollect_Globs(t *testing.T) {
+	root := writeSearchTree(t, map[string]string{
+		"main.go":            "golly\n",
+		"main_test.go":       "golly\n",
+		"README.md":          "golly\n",
+		"vendor/lib/lib.go":  "golly\n",
+		"internal/deep/x.go": "golly\n",
+	})
+	results, err := SearchCollect(context.Background(), root, SearchOptions{
+		Pattern: "golly",
+		Include: []string{"*.go"},
+		Exclude: []string{"*_test.go", "vendor"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"internal/deep/x.go:1", "main.go:1"}
+	if got := relPaths(t, root, results); !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchCollect() = %v, want %v", got, want)
+	}
+	results, err = SearchCollect(context.Background(), root, SearchOptions{Pattern: "golly", Include: []string{"*.go"}, MaxDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"main.go:1", "main_test.go:1"}
+	if got := relPaths(t, root, results); !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchCollect() = %v, want %v", got, want)
+	}
+}
+
+func TestSearchCollect_MaxResultsAndFileSize(t *testing.T) {
+	root := writeSearchTree(t, map[string]string{
+		"a.txt":   strings.Repeat("golly\n", 50),
+		"big.txt": strings.Repeat("golly golly golly\n", 100),
+	})
+	results, err := SearchCollect(context.Background(), root, SearchOptions{Pattern: "golly", MaxResults: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 10 {
+		t.Errorf("SearchCollect() got %d results, want 10", len(results))
+	}
+	results, err = SearchCollect(context.Background(), root, SearchOptions{Pattern: "golly", MaxFileSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 50 {
+		t.Errorf("SearchCollect() got %d results, want 50", len(results))
+	}
+}
+
+func TestSearch_Cancel(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		files["dir"+strconv.Itoa(i%10)+"/f"+strconv.Itoa(i)+".txt"] = strings.Repeat("golly\n", 100)
+	}
+	root := writeSearchTree(t, files)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := SearchContext(ctx, root, SearchOptions{Pattern: "golly", Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for range results {
+		count++
+		if count == 5 {
+			cancel()
+		}
+	}
+	if count >= 200*100 {
+		t.Errorf("Search() did not stop after cancel, got %d results", count)
+	}
+}
+
+func TestSearch_SymlinkLoop(t *testing.T) {
+	root := writeSearchTree(t, map[string]string{"dir/a.txt": "golly\n"})
+	if err := os.Symlink(root, filepath.Join(root, "dir", "loop")); err != nil {
+		t.Skip("symlinks are not supported", err)
+	}
+	results, err := SearchCollect(context.Background(), root, SearchOptions{Pattern: "golly", FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("SearchCollect() got %d results, want 1", len(results))
+	}
+}
+
+func TestSearch_Errors(t *testing.T) {
+	if _, err := Search("testdata", SearchOptions{}); err != ErrEmptySearchPattern {
+		t.Errorf("Search() error = %v, want %v", err, ErrEmptySearchPattern)
+	}
+	if _, err := Search("testdata", SearchOptions{Pattern: "(", Regexp: true}); err == nil {
+		t.Error("Search() expected an error for an invalid regexp")
+	}
+	if _, err := Search("testdata-missing", SearchOptions{Pattern: "golly"}); err == nil {
+		t.Error("Search() expected an error for a missing root")
+	}
+}
+
+// BenchmarkSearch searches the module source tree
+func BenchmarkSearch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := SearchCollect(context.Background(), "..", SearchOptions{Pattern: `func \w+Error`, Regexp: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
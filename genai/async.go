@@ -0,0 +1,371 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/lifecycle"
+	"oss.nandlabs.io/golly/messaging"
+	"oss.nandlabs.io/golly/rest/client"
+	"oss.nandlabs.io/golly/uuid"
+)
+
+const (
+	// AsyncErrModelNotFound is the error code of the results of the requests for an unknown model
+	AsyncErrModelNotFound = "model_not_found"
+	// AsyncErrGeneration is the error code of the results of the requests whose generation failed
+	AsyncErrGeneration = "generation_failed"
+	// AsyncErrTimeout is the error code of the results of the requests whose generation timed out
+	AsyncErrTimeout = "timeout"
+	// PoisonReason is the dead letter error of the malformed requests
+	PoisonReason = "malformed generation request"
+	defaultWorkerId = "genai-async-worker"
+)
+
+// ErrGenerationTimeout is the error of the asynchronous generations exceeding the timeout of the worker
+var ErrGenerationTimeout = errors.New("the generation timed out")
+
+// ErrModelNotFound is returned by a ModelResolver for an unknown model
+var ErrModelNotFound = errors.New("model not found")
+
+// ErrNoDestination is returned when an asynchronous request has neither a reply to destination nor a callback url
+var ErrNoDestination = errors.New("the request has no reply to destination or callback url")
+
+// ModelResolver returns the model with the name
+type ModelResolver func(name string) (Model, error)
+
+// Models returns a ModelResolver resolving the models by their name
+func Models(models ...Model) ModelResolver {
+	byName := make(map[string]Model, len(models))
+	for _, m := range models {
+		byName[m.Name()] = m
+	}
+	return func(name string) (model Model, err error) {
+		var ok bool
+		if model, ok = byName[name]; !ok {
+			err = fmt.Errorf("%w: %s", ErrModelNotFound, name)
+		}
+		return
+	}
+}
+
+// AsyncMessage is a message of an asynchronous request or result. The text messages hold their content in Text and
+// the others in Data.
+type AsyncMessage struct {
+	Actor Actor  `json:"actor"`
+	Mime  string `json:"mime"`
+	Text  string `json:"text,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// AsyncRequest is a generation request processed by an AsyncWorker
+type AsyncRequest struct {
+	// CorrelationId is copied to the result. SubmitAsync generates one if empty.
+	CorrelationId string `json:"correlation_id"`
+	// Model is the name of the model resolved by the worker
+	Model string `json:"model"`
+	// Messages are the messages of the exchange sent to the model
+	Messages []AsyncMessage `json:"messages"`
+	// ReplyTo is the url of the messaging destination of the result
+	ReplyTo string `json:"reply_to,omitempty"`
+	// CallbackUrl is the url the result is posted to
+	CallbackUrl string `json:"callback_url,omitempty"`
+}
+
+// AsyncError is the error of an AsyncResult
+type AsyncError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// AsyncResult is the result of an AsyncRequest
+type AsyncResult struct {
+	CorrelationId string `json:"correlation_id"`
+	Model         string `json:"model"`
+	// Messages are the messages of the response of the model
+	Messages []AsyncMessage `json:"messages,omitempty"`
+	// Error is set if the generation failed
+	Error *AsyncError `json:"error,omitempty"`
+}
+
+// WorkerConfig configures an AsyncWorker
+type WorkerConfig struct {
+	// Id is the id of the worker component, genai-async-worker by default
+	Id string
+	// Source is the destination of the requests
+	Source *url.URL
+	// Concurrency is the number of requests processed at a time, 4 by default
+	Concurrency int
+	// Timeout is the maximum duration of a generation, 2 minutes by default
+	Timeout time.Duration
+	// MaxRetries is the number of times a failed generation is retried
+	MaxRetries int
+	// RetryWait is the wait before a retry
+	RetryWait time.Duration
+	// DeadLetter is the destination of the requests that failed every attempt and of the malformed requests
+	DeadLetter *url.URL
+	// Signing signs the requests posting the results to the callback urls if set
+	Signing *client.SigningOptions
+}
+
+// AsyncWorker generates the requests received from a messaging destination and delivers the results to the reply to
+// destination or the callback url of each request. The failed generations are retried and then dead lettered with
+// the retry policy of the messaging manager; an error result is delivered once the last attempt failed. The malformed
+// requests are dead lettered at once.
+//
+// The worker is a lifecycle.Component: Start adds the listener of the source and Stop removes it, waiting for the
+// requests being processed.
+type AsyncWorker struct {
+	*lifecycle.SimpleComponent
+	manager    messaging.Manager
+	models     ModelResolver
+	config     WorkerConfig
+	client     *client.Client
+	listenerId string
+}
+
+// NewAsyncWorker creates a new AsyncWorker consuming the requests of the source of the config
+func NewAsyncWorker(manager messaging.Manager, models ModelResolver, cfg WorkerConfig) (worker *AsyncWorker, err error) {
+	if cfg.Source == nil {
+		err = errors.New("the source of the worker is required")
+		return
+	}
+	if cfg.Id == "" {
+		cfg.Id = defaultWorkerId
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Minute
+	}
+	worker = &AsyncWorker{manager: manager, models: models, config: cfg, client: client.NewClient()}
+	if cfg.Signing != nil {
+		worker.client.SignRequests(*cfg.Signing)
+	}
+	worker.SimpleComponent = &lifecycle.SimpleComponent{
+		CompId:    cfg.Id,
+		StartFunc: worker.start,
+		StopFunc:  worker.stop,
+	}
+	return
+}
+
+func (w *AsyncWorker) start() (err error) {
+	options := []messaging.Option{messaging.WithConcurrency(w.config.Concurrency)}
+	if w.config.MaxRetries > 0 {
+		options = append(options, messaging.WithRetryPolicy(w.config.MaxRetries, int(w.config.RetryWait.Milliseconds())))
+	}
+	if w.config.DeadLetter != nil {
+		options = append(options, messaging.WithDeadLetter(w.config.DeadLetter))
+	}
+	w.listenerId, err = w.manager.AddListenerWithOptions(w.config.Source, w.handle, options...)
+	return
+}
+
+func (w *AsyncWorker) stop() error {
+	return w.manager.RemoveListener(w.listenerId)
+}
+
+// handle processes a request. A panic is a failed attempt for the retry policy of the manager.
+func (w *AsyncWorker) handle(msg messaging.Message) {
+	req := &AsyncRequest{}
+	if err := msg.ReadJSON(req); err != nil || req.Model == "" || (req.ReplyTo == "" && req.CallbackUrl == "") {
+		if err == nil {
+			err = fmt.Errorf("model %q: %w", req.Model, ErrNoDestination)
+		}
+		w.poison(msg, err)
+		return
+	}
+	if req.CorrelationId == "" {
+		req.CorrelationId, _ = msg.GetStrHeader(messaging.CorrelationIdHeader)
+	}
+	result := &AsyncResult{CorrelationId: req.CorrelationId, Model: req.Model}
+	model, err := w.models(req.Model)
+	if err != nil {
+		result.Error = &AsyncError{Code: AsyncErrModelNotFound, Message: err.Error()}
+	} else if result.Messages, err = w.generate(model, req); err != nil {
+		LOGGER.WarnF("the generation %s failed on attempt %d: %v", req.CorrelationId, msg.DeliveryCount(), err)
+		if msg.DeliveryCount() <= w.config.MaxRetries {
+			w.fail(err)
+			return
+		}
+		result.Error = &AsyncError{Code: AsyncErrGeneration, Message: err.Error()}
+		if errors.Is(err, ErrGenerationTimeout) {
+			result.Error.Code = AsyncErrTimeout
+		}
+	}
+	if deliverErr := w.deliver(req, result); deliverErr != nil {
+		w.fail(deliverErr)
+	} else if result.Error != nil && result.Error.Code != AsyncErrModelNotFound && w.config.DeadLetter != nil {
+		// the last attempt failed, the request goes to the dead letter destination
+		panic(err)
+	}
+}
+
+// fail fails the attempt so that the request is retried or dead lettered. Without a retry policy nor a dead letter
+// destination the manager would requeue the request forever, so it is dropped instead.
+func (w *AsyncWorker) fail(err error) {
+	if w.config.MaxRetries > 0 || w.config.DeadLetter != nil {
+		panic(err)
+	}
+	LOGGER.ErrorF("dropping the generation request: %v", err)
+}
+
+// poison sends the malformed request to the dead letter destination with the reason
+func (w *AsyncWorker) poison(msg messaging.Message, cause error) {
+	LOGGER.ErrorF("dropping the malformed generation request %s: %v", msg.Id(), cause)
+	if w.config.DeadLetter == nil {
+		return
+	}
+	msg.SetStrHeader(messaging.DeadLetterErrorHeader, PoisonReason+": "+cause.Error())
+	msg.SetIntHeader(messaging.DeadLetterAttemptsHeader, msg.DeliveryCount())
+	msg.SetStrHeader(messaging.DeadLetterSourceHeader, w.config.Source.String())
+	if err := w.manager.Send(w.config.DeadLetter, msg); err != nil {
+		LOGGER.ErrorF("unable to dead letter the malformed request %s: %v", msg.Id(), err)
+	}
+}
+
+// generate runs the exchange of the request through the model within the timeout and returns the response
+func (w *AsyncWorker) generate(model Model, req *AsyncRequest) (response []AsyncMessage, err error) {
+	exchange := NewExchange(req.CorrelationId)
+	for _, m := range req.Messages {
+		if isText(m.Mime) {
+			var msg *Message
+			if msg, err = exchange.AddTxtMsg(m.Text, m.Actor); err == nil {
+				msg.SetMime(m.Mime)
+			}
+		} else {
+			_, err = exchange.AddBinMsg(m.Data, m.Mime, m.Actor)
+		}
+		if err != nil {
+			return
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("the model panicked: %v", r)
+			}
+		}()
+		done <- model.Generate(exchange)
+	}()
+	timer := time.NewTimer(w.config.Timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		// the model has no way to be cancelled, its result is discarded
+		return nil, ErrGenerationTimeout
+	}
+	if err == nil {
+		_, messages := splitResponse(exchange)
+		if len(messages) == 0 {
+			return nil, ErrNoResponse
+		}
+		for _, msg := range messages {
+			m := AsyncMessage{Actor: msg.Actor(), Mime: msg.Mime()}
+			if isText(m.Mime) {
+				m.Text = messageContent(msg)
+			} else {
+				m.Data = []byte(messageContent(msg))
+			}
+			response = append(response, m)
+		}
+	}
+	return
+}
+
+// deliver sends the result to the reply to destination and posts it to the callback url of the request
+func (w *AsyncWorker) deliver(req *AsyncRequest, result *AsyncResult) (err error) {
+	if req.ReplyTo != "" {
+		var replyTo *url.URL
+		var reply messaging.Message
+		if replyTo, err = url.Parse(req.ReplyTo); err == nil {
+			if reply, err = w.manager.NewMessage(replyTo.Scheme); err == nil {
+				reply.SetStrHeader(messaging.CorrelationIdHeader, result.CorrelationId)
+				if err = reply.SetBodyObject(result, ioutils.MimeApplicationJSON); err == nil {
+					err = w.manager.Send(replyTo, reply)
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("unable to reply to %s: %w", req.ReplyTo, err)
+		}
+	}
+	if req.CallbackUrl != "" {
+		r := w.client.NewRequest(req.CallbackUrl, http.MethodPost).
+			AddHeader(messaging.CorrelationIdHeader, result.CorrelationId).
+			SetContentType(ioutils.MimeApplicationJSON).
+			SetBody(result)
+		var res *client.Response
+		if res, err = w.client.Execute(r); err == nil && !res.IsSuccess() {
+			err = fmt.Errorf("status %d", res.StatusCode())
+		}
+		if err != nil {
+			return fmt.Errorf("unable to post to the callback %s: %w", req.CallbackUrl, err)
+		}
+	}
+	return
+}
+
+// isText returns true for the MIME types of the text messages
+func isText(mime string) bool {
+	switch mime {
+	case ioutils.MimeTextPlain, ioutils.MimeTextHTML, ioutils.MimeMarkDown, ioutils.MimeTextYAML:
+		return true
+	}
+	return false
+}
+
+// SubmitAsync sends the request to the destination of an AsyncWorker and returns its correlation id, generated if
+// the request has none
+func SubmitAsync(manager messaging.Manager, dest *url.URL, request *AsyncRequest) (correlationId string, err error) {
+	if request.ReplyTo == "" && request.CallbackUrl == "" {
+		return "", ErrNoDestination
+	}
+	if request.CorrelationId == "" {
+		var uid *uuid.UUID
+		if uid, err = uuid.V4(); err != nil {
+			return
+		}
+		request.CorrelationId = uid.String()
+	}
+	var msg messaging.Message
+	if msg, err = manager.NewMessage(dest.Scheme); err == nil {
+		msg.SetStrHeader(messaging.CorrelationIdHeader, request.CorrelationId)
+		if err = msg.SetBodyObject(request, ioutils.MimeApplicationJSON); err == nil {
+			err = manager.Send(dest, msg)
+		}
+	}
+	if err == nil {
+		correlationId = request.CorrelationId
+	}
+	return
+}
+
+// AwaitResult receives the results from the reply to destination until the one with the correlation id or the end
+// of the context. The other results received meanwhile are discarded, so it suits a destination with a single
+// waiting caller, such as in the tests.
+func AwaitResult(ctx context.Context, manager messaging.Manager, replyTo *url.URL,
+	correlationId string) (result *AsyncResult, err error) {
+	for {
+		var msg messaging.Message
+		if msg, err = manager.ReceiveCtx(ctx, replyTo); err != nil {
+			return
+		}
+		result = &AsyncResult{}
+		err = msg.ReadJSON(result)
+		_ = msg.Ack()
+		if err == nil && result.CorrelationId == correlationId {
+			return
+		}
+		LOGGER.DebugF("discarding the result %s while waiting for %s", result.CorrelationId, correlationId)
+	}
+}
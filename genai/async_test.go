@@ -0,0 +1,284 @@
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/lifecycle"
+	"oss.nandlabs.io/golly/messaging"
+	"oss.nandlabs.io/golly/rest/client"
+	"oss.nandlabs.io/golly/rest/server"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// asyncModel is a fake model replying with the reply function and counting the generations
+type asyncModel struct {
+	scriptedModel
+	calls atomic.Int32
+	reply func(exchange Exchange) error
+}
+
+func (m *asyncModel) Generate(exchange Exchange) error {
+	m.calls.Add(1)
+	return m.reply(exchange)
+}
+
+func echoModel(name string) *asyncModel {
+	return &asyncModel{scriptedModel: scriptedModel{AbstractModel: AbstractModel{name: name}},
+		reply: func(exchange Exchange) error {
+			user := exchange.MsgsByActors(UserActor)
+			_, err := exchange.AddTxtMsg("echo: "+messageContent(user[len(user)-1]), AIActor)
+			return err
+		}}
+}
+
+func destination(t *testing.T, name string) *url.URL {
+	u, _ := url.Parse("chan://" + t.Name() + "-" + name)
+	return u
+}
+
+func startWorker(t *testing.T, models ModelResolver, cfg WorkerConfig) *AsyncWorker {
+	worker, err := NewAsyncWorker(messaging.GetManager(), models, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Start())
+	assert.Equal(t, lifecycle.Running, worker.State())
+	t.Cleanup(func() {
+		if worker.State() == lifecycle.Running {
+			_ = worker.Stop()
+		}
+	})
+	return worker
+}
+
+func userRequest(model, text string, replyTo *url.URL) *AsyncRequest {
+	return &AsyncRequest{Model: model, ReplyTo: replyTo.String(),
+		Messages: []AsyncMessage{{Actor: UserActor, Mime: ioutils.MimeTextPlain, Text: text}}}
+}
+
+func await(t *testing.T, replyTo *url.URL, correlationId string) *AsyncResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := AwaitResult(ctx, messaging.GetManager(), replyTo, correlationId)
+	assert.NoError(t, err)
+	return result
+}
+
+// collect receives n results from the reply to destination by correlation id
+func collect(t *testing.T, replyTo *url.URL, n int) map[string]*AsyncResult {
+	results := map[string]*AsyncResult{}
+	for i := 0; i < n; i++ {
+		result := &AsyncResult{}
+		assert.NoError(t, receive(t, replyTo).ReadJSON(result))
+		results[result.CorrelationId] = result
+	}
+	return results
+}
+
+func receive(t *testing.T, u *url.URL) messaging.Message {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := messaging.GetManager().ReceiveCtx(ctx, u)
+	assert.NoError(t, err)
+	return msg
+}
+
+func TestAsyncWorker_ReplyTo(t *testing.T) {
+	manager := messaging.GetManager()
+	source, replyTo := destination(t, "requests"), destination(t, "replies")
+	startWorker(t, Models(echoModel("echo")), WorkerConfig{Source: source})
+
+	ids := map[string]string{}
+	for _, text := range []string{"one", "two", "three"} {
+		req := userRequest("echo", text, replyTo)
+		if text == "two" {
+			req.CorrelationId = "client-supplied"
+		}
+		id, err := SubmitAsync(manager, source, req)
+		assert.NoError(t, err)
+		ids[id] = text
+	}
+	_, ok := ids["client-supplied"]
+	assert.True(t, ok)
+	results := collect(t, replyTo, len(ids))
+	for id, text := range ids {
+		result := results[id]
+		assert.Equal(t, id, result.CorrelationId)
+		assert.Equal(t, "echo", result.Model)
+		assert.True(t, result.Error == nil)
+		assert.Equal(t, []AsyncMessage{{Actor: AIActor, Mime: ioutils.MimeTextPlain, Text: "echo: " + text}},
+			result.Messages)
+	}
+
+	// an unknown model gets an error result
+	id, err := SubmitAsync(manager, source, userRequest("other", "hi", replyTo))
+	assert.NoError(t, err)
+	result := await(t, replyTo, id)
+	assert.Equal(t, AsyncErrModelNotFound, result.Error.Code)
+
+	_, err = SubmitAsync(manager, source, &AsyncRequest{Model: "echo"})
+	assert.True(t, errors.Is(err, ErrNoDestination))
+}
+
+func TestAsyncWorker_Callback(t *testing.T) {
+	key := []byte("callback secret")
+	received := make(chan *AsyncResult, 1)
+	var correlationHeader atomic.Value
+	callback := httptest.NewServer(server.SignatureVerificationMiddleware(func(keyId string) ([]byte, error) {
+		if keyId != "genai-worker" {
+			return nil, errors.New("unknown key")
+		}
+		return key, nil
+	}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationHeader.Store(r.Header.Get(messaging.CorrelationIdHeader))
+		result := &AsyncResult{}
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, result); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- result
+	})))
+	defer callback.Close()
+
+	source := destination(t, "requests")
+	startWorker(t, Models(echoModel("echo")), WorkerConfig{Source: source,
+		Signing: &client.SigningOptions{KeyId: "genai-worker", Key: key}})
+	id, err := SubmitAsync(messaging.GetManager(), source, &AsyncRequest{Model: "echo",
+		CallbackUrl: callback.URL + "/results",
+		Messages:    []AsyncMessage{{Actor: UserActor, Mime: ioutils.MimeTextPlain, Text: "by callback"}}})
+	assert.NoError(t, err)
+	select {
+	case result := <-received:
+		assert.Equal(t, id, result.CorrelationId)
+		assert.Equal(t, "echo: by callback", result.Messages[0].Text)
+		assert.Equal(t, id, correlationHeader.Load())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the callback")
+	}
+}
+
+func TestAsyncWorker_RetryThenDeadLetter(t *testing.T) {
+	source, replyTo, dlq := destination(t, "requests"), destination(t, "replies"), destination(t, "dlq")
+	failing := &asyncModel{scriptedModel: scriptedModel{AbstractModel: AbstractModel{name: "failing"}},
+		reply: func(exchange Exchange) error { return errors.New("provider unavailable") }}
+	recovering := echoModel("recovering")
+	reply := recovering.reply
+	recovering.reply = func(exchange Exchange) error {
+		if recovering.calls.Load() < 2 {
+			return errors.New("rate limited")
+		}
+		return reply(exchange)
+	}
+	startWorker(t, Models(failing, recovering), WorkerConfig{Source: source, MaxRetries: 2, DeadLetter: dlq})
+
+	// a transient failure is retried
+	id, err := SubmitAsync(messaging.GetManager(), source, userRequest("recovering", "again", replyTo))
+	assert.NoError(t, err)
+	result := await(t, replyTo, id)
+	assert.True(t, result.Error == nil)
+	assert.Equal(t, "echo: again", result.Messages[0].Text)
+	assert.Equal(t, int32(2), recovering.calls.Load())
+
+	// a persistent failure gets an error result once the retries are exhausted and is dead lettered
+	id, err = SubmitAsync(messaging.GetManager(), source, userRequest("failing", "hi", replyTo))
+	assert.NoError(t, err)
+	result = await(t, replyTo, id)
+	assert.Equal(t, AsyncErrGeneration, result.Error.Code)
+	assert.Equal(t, "provider unavailable", result.Error.Message)
+	assert.Equal(t, int32(3), failing.calls.Load())
+
+	dead := receive(t, dlq)
+	attempts, _ := dead.GetIntHeader(messaging.DeadLetterAttemptsHeader)
+	assert.Equal(t, 3, attempts)
+	req := &AsyncRequest{}
+	assert.NoError(t, dead.ReadJSON(req))
+	assert.Equal(t, id, req.CorrelationId)
+}
+
+func TestAsyncWorker_Poison(t *testing.T) {
+	manager := messaging.GetManager()
+	source, dlq := destination(t, "requests"), destination(t, "dlq")
+	model := echoModel("echo")
+	startWorker(t, Models(model), WorkerConfig{Source: source, MaxRetries: 3, DeadLetter: dlq})
+
+	for _, body := range []string{`{"model": `, `{"model": "echo", "messages": []}`} {
+		msg, err := manager.NewMessage(source.Scheme)
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(body)
+		assert.NoError(t, manager.Send(source, msg))
+
+		dead := receive(t, dlq)
+		assert.Equal(t, body, dead.ReadAsStr())
+		reason, _ := dead.GetStrHeader(messaging.DeadLetterErrorHeader)
+		assert.True(t, strings.HasPrefix(reason, PoisonReason))
+		// the malformed requests are not retried
+		attempts, _ := dead.GetIntHeader(messaging.DeadLetterAttemptsHeader)
+		assert.Equal(t, 1, attempts)
+	}
+	assert.Equal(t, int32(0), model.calls.Load())
+}
+
+func TestAsyncWorker_Timeout(t *testing.T) {
+	source, replyTo := destination(t, "requests"), destination(t, "replies")
+	release := make(chan struct{})
+	defer close(release)
+	stuck := &asyncModel{scriptedModel: scriptedModel{AbstractModel: AbstractModel{name: "stuck"}},
+		reply: func(exchange Exchange) error { <-release; return nil }}
+	startWorker(t, Models(stuck), WorkerConfig{Source: source, Timeout: 50 * time.Millisecond})
+	id, err := SubmitAsync(messaging.GetManager(), source, userRequest("stuck", "hi", replyTo))
+	assert.NoError(t, err)
+	assert.Equal(t, AsyncErrTimeout, await(t, replyTo, id).Error.Code)
+}
+
+func TestAsyncWorker_Drain(t *testing.T) {
+	source, replyTo := destination(t, "requests"), destination(t, "replies")
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	slow := echoModel("slow")
+	reply := slow.reply
+	slow.reply = func(exchange Exchange) error {
+		started <- struct{}{}
+		<-release
+		return reply(exchange)
+	}
+	worker := startWorker(t, Models(slow), WorkerConfig{Source: source, Concurrency: 2})
+	var ids []string
+	for _, text := range []string{"a", "b"} {
+		id, err := SubmitAsync(messaging.GetManager(), source, userRequest("slow", text, replyTo))
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	<-started
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- worker.Stop() }()
+	select {
+	case <-stopped:
+		t.Fatal("Stop() returned with requests in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Stop()")
+	}
+	assert.Equal(t, lifecycle.Stopped, worker.State())
+	// the in flight requests were delivered
+	results := collect(t, replyTo, len(ids))
+	for _, id := range ids {
+		assert.True(t, results[id] != nil && results[id].Error == nil)
+	}
+}
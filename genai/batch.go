@@ -0,0 +1,110 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultBatchConcurrency = 4
+
+// ErrCancelled is the error of the exchanges of a batch that were not generated because the context was cancelled
+var ErrCancelled = errors.New("the batch was cancelled before the exchange was generated")
+
+// BatchOption configures GenerateBatch
+type BatchOption func(cfg *batchConfig)
+
+// batchConfig holds the configuration of a batch
+type batchConfig struct {
+	concurrency int
+	retries     int
+	backoff     time.Duration
+	retryable   func(err error) bool
+	onProgress  func(done, total int)
+}
+
+// WithConcurrency sets the number of exchanges generated at a time. Defaults to 4.
+func WithConcurrency(n int) BatchOption {
+	return func(cfg *batchConfig) {
+		if n > 0 {
+			cfg.concurrency = n
+		}
+	}
+}
+
+// WithRetry retries the generation of an exchange up to retries times, waiting backoff before each retry, while
+// retryable returns true for the error. A nil retryable retries all the errors but ErrContextExceeded.
+func WithRetry(retries int, backoff time.Duration, retryable func(err error) bool) BatchOption {
+	return func(cfg *batchConfig) {
+		cfg.retries, cfg.backoff, cfg.retryable = retries, backoff, retryable
+	}
+}
+
+// WithOnProgress sets a callback invoked each time an exchange is done, successfully or not. It is called from the
+// goroutines of the batch, one call at a time.
+func WithOnProgress(fn func(done, total int)) BatchOption {
+	return func(cfg *batchConfig) {
+		cfg.onProgress = fn
+	}
+}
+
+// GenerateBatch generates the exchanges with the model, at most the configured concurrency at a time. The returned
+// errors are indexed like the exchanges, with a nil error for each exchange generated. Once the context is done no
+// more exchanges are started: the ones that were not are left untouched and their error is ErrCancelled, while the
+// ones in flight complete.
+func GenerateBatch(ctx context.Context, model Model, exchanges []Exchange, opts ...BatchOption) []error {
+	cfg := &batchConfig{concurrency: defaultBatchConcurrency}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.retryable == nil {
+		cfg.retryable = func(err error) bool { return !errors.Is(err, ErrContextExceeded) }
+	}
+	errs := make([]error, len(exchanges))
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	done := 0
+	slots := make(chan struct{}, cfg.concurrency)
+	for i := range exchanges {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			for j := i; j < len(exchanges); j++ {
+				errs[j] = ErrCancelled
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = cfg.generate(ctx, model, exchanges[i])
+			if cfg.onProgress != nil {
+				mutex.Lock()
+				done++
+				cfg.onProgress(done, len(exchanges))
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// generate generates the exchange retrying the errors as configured
+func (cfg *batchConfig) generate(ctx context.Context, model Model, exchange Exchange) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = model.Generate(exchange); err == nil || attempt >= cfg.retries || !cfg.retryable(err) {
+			return
+		}
+		LOGGER.DebugF("retrying the exchange %s after the error %v", exchange.Id(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.backoff):
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+var errFlaky = errors.New("flaky")
+
+// slowModel is a Model replying after a delay and failing the first attempts of the scripted exchanges
+type slowModel struct {
+	scriptedModel
+	delay    time.Duration
+	failures map[string]int
+	mutex    sync.Mutex
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (m *slowModel) Generate(exchange Exchange) (err error) {
+	n := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		peak := m.peak.Load()
+		if n <= peak || m.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(m.delay)
+	m.mutex.Lock()
+	if m.failures[exchange.Id()] > 0 {
+		m.failures[exchange.Id()]--
+		err = errFlaky
+	}
+	m.mutex.Unlock()
+	if err == nil {
+		_, err = exchange.AddTxtMsg("reply to "+exchange.Id(), AIActor)
+	}
+	return
+}
+
+func batchExchanges(n int) (exchanges []Exchange) {
+	for i := 0; i < n; i++ {
+		exchanges = append(exchanges, NewExchange(fmt.Sprintf("%d", i)))
+	}
+	return
+}
+
+func TestGenerateBatch(t *testing.T) {
+	model := &slowModel{delay: 5 * time.Millisecond, failures: map[string]int{"3": 1, "7": 5}}
+	exchanges := batchExchanges(20)
+	var progress []int
+	errs := GenerateBatch(context.Background(), model, exchanges, WithConcurrency(3),
+		WithRetry(2, time.Millisecond, nil), WithOnProgress(func(done, total int) {
+			assert.Equal(t, 20, total)
+			progress = append(progress, done)
+		}))
+	assert.Equal(t, 20, len(errs))
+	for i, exchange := range exchanges {
+		if i == 7 {
+			assert.True(t, errors.Is(errs[i], errFlaky))
+			continue
+		}
+		assert.NoError(t, errs[i])
+		assert.Equal(t, "reply to "+exchange.Id(), exchange.Messages()[0].String())
+	}
+	assert.Equal(t, int32(3), model.peak.Load())
+	assert.Equal(t, 20, len(progress))
+	assert.Equal(t, 20, progress[19])
+}
+
+func TestGenerateBatch_Cancelled(t *testing.T) {
+	model := &slowModel{delay: 20 * time.Millisecond}
+	exchanges := batchExchanges(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	errs := GenerateBatch(ctx, model, exchanges, WithConcurrency(2))
+	cancelled := 0
+	for i, err := range errs {
+		if err == nil {
+			assert.Equal(t, 1, len(exchanges[i].Messages()))
+		} else {
+			assert.Equal(t, ErrCancelled, err)
+			assert.Equal(t, 0, len(exchanges[i].Messages()))
+			cancelled++
+		}
+	}
+	assert.True(t, cancelled >= 4 && cancelled <= 8)
+	// the errors that are not retryable are returned at once
+	model = &slowModel{failures: map[string]int{"0": 1}}
+	errs = GenerateBatch(context.Background(), model, batchExchanges(1),
+		WithRetry(3, 0, func(err error) bool { return false }))
+	assert.Equal(t, errFlaky, errs[0])
+}
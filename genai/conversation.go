@@ -0,0 +1,241 @@
+package genai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/uuid"
+)
+
+const (
+	summaryPrefix   = "Summary of the earlier conversation: "
+	summarizePrompt = `Summarize the following conversation in a few sentences. Keep the facts, names, decisions and open questions.
+
+`
+)
+
+var ErrNoResponse = errors.New("model returned no response")
+
+// HistoryPolicy decides which messages of the history of a Conversation are kept and sent to the model.
+// Apply is called with the history including the new message before every turn and returns the history to keep.
+type HistoryPolicy interface {
+	Apply(history []*Message) ([]*Message, error)
+}
+
+// HistoryPolicyFunc is a function implementing the HistoryPolicy
+type HistoryPolicyFunc func(history []*Message) ([]*Message, error)
+
+// Apply calls the function
+func (f HistoryPolicyFunc) Apply(history []*Message) ([]*Message, error) {
+	return f(history)
+}
+
+// TokenCounter returns the number of tokens of a message
+type TokenCounter func(msg *Message) int
+
+// ApproxTokenCount approximates the number of tokens of a message as one token for every four bytes of its content
+func ApproxTokenCount(msg *Message) int {
+	return (len(messageContent(msg)) + 3) / 4
+}
+
+// KeepLastN is a HistoryPolicy keeping the last n messages
+func KeepLastN(n int) HistoryPolicy {
+	return HistoryPolicyFunc(func(history []*Message) ([]*Message, error) {
+		if n > 0 && len(history) > n {
+			history = history[len(history)-n:]
+		}
+		return history, nil
+	})
+}
+
+// MaxTokens is a HistoryPolicy keeping the latest messages that fit in the token budget. The latest message is
+// always kept. The ApproxTokenCount is used if the counter is nil.
+func MaxTokens(budget int, counter TokenCounter) HistoryPolicy {
+	if counter == nil {
+		counter = ApproxTokenCount
+	}
+	return HistoryPolicyFunc(func(history []*Message) ([]*Message, error) {
+		total := 0
+		start := len(history)
+		for start > 0 {
+			count := counter(history[start-1])
+			if total+count > budget && start < len(history) {
+				break
+			}
+			total += count
+			start--
+		}
+		return history[start:], nil
+	})
+}
+
+// SummarizeOlder is a HistoryPolicy keeping the last n messages and replacing the older ones with a summary generated
+// by the model. The summary is a SystemActor message and is summarized again along with the messages that
+// become older in the later turns.
+func SummarizeOlder(model Model, keep int) HistoryPolicy {
+	return HistoryPolicyFunc(func(history []*Message) (kept []*Message, err error) {
+		if keep <= 0 || len(history) <= keep {
+			kept = history
+			return
+		}
+		older := history[:len(history)-keep]
+		var summary strings.Builder
+		summary.WriteString(summarizePrompt)
+		for _, msg := range older {
+			summary.WriteString(fmt.Sprintf("%s: %s\n", msg.Actor(), messageContent(msg)))
+		}
+		var id string
+		if id, err = newExchangeId(); err != nil {
+			return
+		}
+		exchange := NewExchange(id)
+		if _, err = exchange.AddTxtMsg(summary.String(), UserActor); err != nil {
+			return
+		}
+		if err = model.Generate(exchange); err != nil {
+			return
+		}
+		responses := exchange.MsgsByActors(AIActor)
+		if len(responses) == 0 {
+			err = fmt.Errorf("%w: summary", ErrNoResponse)
+			return
+		}
+		kept = append([]*Message{newTextMessage(summaryPrefix+messageContent(responses[len(responses)-1]),
+			SystemActor)}, history[len(history)-keep:]...)
+		return
+	})
+}
+
+// Conversation maintains the history of the messages exchanged with a model over multiple turns.
+// The system instruction is sent first in every turn and is not a part of the history.
+type Conversation struct {
+	mutex   sync.Mutex
+	model   Model
+	policy  HistoryPolicy
+	system  string
+	history []*Message
+}
+
+// NewConversation creates a new Conversation with the model. The whole history is kept if the policy is nil.
+func NewConversation(model Model, policy HistoryPolicy) *Conversation {
+	return &Conversation{
+		model:  model,
+		policy: policy,
+	}
+}
+
+// SetSystemInstruction sets the system instruction sent in every turn of the conversation
+func (c *Conversation) SetSystemInstruction(text string) *Conversation {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.system = text
+	return c
+}
+
+// Send sends the text as a user message and returns the last message of the AI
+func (c *Conversation) Send(ctx context.Context, text string) (*Message, error) {
+	return c.SendMessage(ctx, newTextMessage(text, UserActor))
+}
+
+// SendMessage sends the message and returns the last message of the AI. The messages added by the model to the
+// exchange, including the function and the tool messages, are added to the history.
+// The history is left unchanged if the model fails.
+func (c *Conversation) SendMessage(ctx context.Context, msg *Message) (response *Message, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	history := append(append([]*Message{}, c.history...), msg)
+	if c.policy != nil {
+		if history, err = c.policy.Apply(history); err != nil {
+			return
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	var id string
+	if id, err = newExchangeId(); err != nil {
+		return
+	}
+	exchange := NewExchange(id)
+	if c.system != "" {
+		exchange.Add(newTextMessage(c.system, SystemActor))
+	}
+	for _, m := range history {
+		exchange.Add(m.clone())
+	}
+	sent := len(exchange.Messages())
+	if err = c.model.Generate(exchange); err != nil {
+		return
+	}
+	for _, m := range exchange.Messages()[sent:] {
+		history = append(history, m.clone())
+		if m.Actor() == AIActor {
+			response = m
+		}
+	}
+	if response == nil {
+		err = ErrNoResponse
+		return
+	}
+	c.history = history
+	return
+}
+
+// History returns the messages of the conversation
+func (c *Conversation) History() []*Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*Message{}, c.history...)
+}
+
+// Reset clears the history of the conversation. The system instruction is kept.
+func (c *Conversation) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.history = nil
+}
+
+func newExchangeId() (id string, err error) {
+	var uid *uuid.UUID
+	if uid, err = uuid.V4(); err == nil {
+		id = uid.String()
+	}
+	return
+}
+
+func newTextMessage(text string, actor Actor) *Message {
+	return &Message{
+		rwer:     bytes.NewBufferString(text),
+		mimeType: ioutils.MimeTextPlain,
+		msgActor: actor,
+	}
+}
+
+// clone copies the message so that reading it does not consume the content of the original
+func (m *Message) clone() *Message {
+	c := *m
+	c.done = false
+	if buf, ok := m.rwer.(*bytes.Buffer); ok {
+		c.rwer = bytes.NewBuffer(append([]byte{}, buf.Bytes()...))
+	}
+	return &c
+}
+
+// messageContent returns the content of the message without consuming it, or the url for file messages
+func messageContent(msg *Message) string {
+	if buf, ok := msg.rwer.(*bytes.Buffer); ok {
+		return buf.String()
+	}
+	if msg.u != nil {
+		return msg.u.String()
+	}
+	return ""
+}
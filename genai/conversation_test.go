@@ -0,0 +1,178 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// scriptedModel is a Model replying with the scripted responses and recording the exchanges it received
+type scriptedModel struct {
+	AbstractModel
+	replies  []func(exchange Exchange) error
+	received [][]string
+}
+
+func (m *scriptedModel) Supports(mime string) (consumer bool, provider bool) {
+	return true, true
+}
+
+func (m *scriptedModel) Accepts() []string {
+	return nil
+}
+
+func (m *scriptedModel) Produces() []string {
+	return nil
+}
+
+func (m *scriptedModel) Generate(exchange Exchange) (err error) {
+	var received []string
+	for _, msg := range exchange.Messages() {
+		// read the messages as a model would
+		b := make([]byte, 1024)
+		n, _ := msg.Read(b)
+		received = append(received, fmt.Sprintf("%s:%s", msg.Actor(), b[:n]))
+	}
+	m.received = append(m.received, received)
+	if len(m.replies) == 0 {
+		_, err = exchange.AddTxtMsg(fmt.Sprintf("reply %d", len(m.received)), AIActor)
+		return
+	}
+	reply := m.replies[0]
+	m.replies = m.replies[1:]
+	return reply(exchange)
+}
+
+func (m *scriptedModel) GenerateStream(exchange Exchange) error {
+	return m.Generate(exchange)
+}
+
+func historyOf(c *Conversation) (contents []string) {
+	for _, msg := range c.History() {
+		contents = append(contents, fmt.Sprintf("%s:%s", msg.Actor(), messageContent(msg)))
+	}
+	return
+}
+
+func TestConversation_Send(t *testing.T) {
+	model := &scriptedModel{replies: []func(exchange Exchange) error{
+		func(exchange Exchange) error {
+			_, _ = exchange.AddJsonMsg(map[string]string{"city": "Paris"}, FunctionActor)
+			_, _ = exchange.AddTxtMsg("tool result", ToolActor)
+			_, err := exchange.AddTxtMsg("It is sunny in Paris", AIActor)
+			return err
+		},
+	}}
+	c := NewConversation(model, nil).SetSystemInstruction("be brief")
+	res, err := c.Send(context.Background(), "weather in Paris?")
+	assert.NoError(t, err)
+	assert.Equal(t, "It is sunny in Paris", res.String())
+	res, err = c.Send(context.Background(), "and tomorrow?")
+	assert.NoError(t, err)
+	assert.Equal(t, "reply 2", res.String())
+
+	// the system instruction is sent in every turn and the history is resent intact
+	assert.Equal(t, []string{"SYSTEM:be brief", "USER:weather in Paris?"}, model.received[0])
+	assert.Equal(t, []string{"SYSTEM:be brief", "USER:weather in Paris?", "FUNCTION:{\"city\":\"Paris\"}\n",
+		"TOOL:tool result", "AI:It is sunny in Paris", "USER:and tomorrow?"}, model.received[1])
+	assert.Len(t, c.History(), 6)
+
+	c.Reset()
+	assert.Len(t, c.History(), 0)
+	_, err = c.Send(context.Background(), "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SYSTEM:be brief", "USER:hello"}, model.received[2])
+}
+
+func TestConversation_Errors(t *testing.T) {
+	model := &scriptedModel{replies: []func(exchange Exchange) error{
+		func(exchange Exchange) error { return errors.New("model failed") },
+		func(exchange Exchange) error { return nil },
+	}}
+	c := NewConversation(model, nil)
+	_, err := c.Send(context.Background(), "one")
+	assert.Error(t, err)
+	_, err = c.Send(context.Background(), "two")
+	assert.True(t, errors.Is(err, ErrNoResponse))
+	// the failed turns are not added to the history
+	assert.Len(t, c.History(), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Send(ctx, "three")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Len(t, model.received, 2)
+}
+
+func TestConversation_HistoryPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy func(model Model) HistoryPolicy
+		// sent is the history sent to the model in the last turn
+		sent []string
+		want []string
+	}{
+		{
+			name:   "keep-last-n",
+			policy: func(model Model) HistoryPolicy { return KeepLastN(3) },
+			sent:   []string{"USER:q2", "AI:reply 2", "USER:q3"},
+			want:   []string{"USER:q2", "AI:reply 2", "USER:q3", "AI:reply 3"},
+		},
+		{
+			name: "max-tokens",
+			policy: func(model Model) HistoryPolicy {
+				// every message counts as its length
+				return MaxTokens(12, func(msg *Message) int { return len(messageContent(msg)) })
+			},
+			sent: []string{"USER:q2", "AI:reply 2", "USER:q3"},
+			want: []string{"USER:q2", "AI:reply 2", "USER:q3", "AI:reply 3"},
+		},
+		{
+			name: "summarize-older",
+			policy: func(model Model) HistoryPolicy {
+				return SummarizeOlder(model, 2)
+			},
+			// the summaries are the replies 2 and 4, the replies to the questions are 1, 3 and 5
+			sent: []string{"SYSTEM:Summary of the earlier conversation: reply 4", "AI:reply 3", "USER:q3"},
+			want: []string{"SYSTEM:Summary of the earlier conversation: reply 4", "AI:reply 3", "USER:q3",
+				"AI:reply 5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &scriptedModel{}
+			c := NewConversation(model, tt.policy(model))
+			for i := 1; i <= 3; i++ {
+				_, err := c.Send(context.Background(), fmt.Sprintf("q%d", i))
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.sent, model.received[len(model.received)-1])
+			assert.Equal(t, tt.want, historyOf(c))
+		})
+	}
+}
+
+func TestSummarizeOlder(t *testing.T) {
+	model := &scriptedModel{}
+	history := []*Message{newTextMessage("my name is Joe", UserActor), newTextMessage("hi Joe", AIActor),
+		newTextMessage("what is my name?", UserActor)}
+	kept, err := SummarizeOlder(model, 1).Apply(history)
+	assert.NoError(t, err)
+	assert.Len(t, kept, 2)
+	assert.Equal(t, SystemActor, kept[0].Actor())
+	assert.Len(t, model.received, 1)
+	prompt := model.received[0][0]
+	assert.True(t, strings.Contains(prompt, "USER: my name is Joe"))
+	assert.True(t, strings.Contains(prompt, "AI: hi Joe"))
+	assert.False(t, strings.Contains(prompt, "what is my name?"))
+	assert.Equal(t, "what is my name?", messageContent(kept[1]))
+
+	// the latest message is kept even if it is over the budget
+	kept, err = MaxTokens(1, nil).Apply(history)
+	assert.NoError(t, err)
+	assert.Len(t, kept, 1)
+}
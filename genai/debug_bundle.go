@@ -0,0 +1,617 @@
+package genai
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/codec"
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/l3"
+	"oss.nandlabs.io/golly/uuid"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+// A debug bundle holds everything needed to reproduce a generation in a single zip archive: the request, the
+// response, the decisions of the wrapped models, the logs and the timings of the generation and the environment.
+// The entries are encoded from fixed structs of allow-listed fields, the texts are passed through the redaction of
+// the secrets and the messages through the optional PII masking, so that no key or configuration secret can reach
+// a bundle.
+
+const (
+	// DebugAttribute is the attribute of the exchange requesting a debug bundle of its generation when set to true
+	DebugAttribute = "genai.debug"
+	// DebugBundleAttribute is the attribute of the exchange set to the id of its debug bundle
+	DebugBundleAttribute = "genai.debug_bundle"
+	// RequestHashAttribute is the attribute of the exchange set to the hash of its request, to bundle it later with
+	// DebugBundler.Bundle
+	RequestHashAttribute = "genai.request_hash"
+	// DebugBundleFormat is the version of the format of the debug bundles
+	DebugBundleFormat = "1"
+	// BundleManifestEntry is the name of the manifest entry of the debug bundles
+	BundleManifestEntry = "manifest.json"
+
+	defaultBundleRecent = 100
+	bundleExt           = ".zip"
+	redactedText        = "[REDACTED]"
+	maskedText          = "[MASKED]"
+)
+
+var ErrBundleLocationRequired = errors.New("the location of the debug bundles is required")
+var ErrBundleNotRecorded = errors.New("the request is not recorded")
+
+// BundleTrigger is the reason a debug bundle is generated
+type BundleTrigger string
+
+const (
+	// BundleRequested is the trigger of the bundles requested by the DebugAttribute of the exchange
+	BundleRequested BundleTrigger = "requested"
+	// BundleSampled is the trigger of the bundles of the sampled exchanges
+	BundleSampled BundleTrigger = "sampled"
+	// BundleRetroactive is the trigger of the bundles of the recorded requests bundled with DebugBundler.Bundle
+	BundleRetroactive BundleTrigger = "retroactive"
+)
+
+var (
+	// secretPatterns match the common forms of the keys and the tokens
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`),
+		regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`),
+		regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`),
+		regexp.MustCompile(`(?i)\b(api[_-]?key|access[_-]?token|auth[_-]?token|secret|password|passwd)(["']?\s*[=:]\s*["']?)[^\s"'&,;]+`),
+	}
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	phonePattern = regexp.MustCompile(`\+?\(?\d{1,4}\)?[ .-]?\(?\d{2,4}\)?[ .-]?\d{3,4}[ .-]?\d{3,4}\b`)
+)
+
+// BundlerOptions configures the DebugBundler
+type BundlerOptions struct {
+	// Manager is the file system the bundles are written to. Defaults to vfs.GetManager().
+	Manager vfs.Manager
+	// Location is the base url of the bundles, required. The bundles are written to <location>/<id>.zip.
+	Location string
+	// SampleRate is the fraction of the exchanges bundled, 0 bundles only the exchanges requesting a bundle with
+	// the DebugAttribute
+	SampleRate float64
+	// Random returns a number in [0,1) to sample the exchanges. Defaults to math/rand.
+	Random func() float64
+	// Mask masks the personal information of the text messages, such as MaskPII. The messages are not masked if nil.
+	Mask func(text string) string
+	// Secrets are values always redacted from the bundles, such as the keys of the providers, in addition to the
+	// common forms of the keys and the tokens
+	Secrets []string
+	// Recent is the number of the latest requests recorded to be bundled later with Bundle. 0 records 100 requests,
+	// a negative number none.
+	Recent int
+	// CaptureLogs records the messages logged during the generations with an l3.Capture. The loggers carry no
+	// context, so the messages logged concurrently by the other generations are recorded as well.
+	CaptureLogs bool
+	// LogSize is the number of messages recorded per generation. Defaults to 1000.
+	LogSize int
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// BundleManifest is the first entry of a debug bundle
+type BundleManifest struct {
+	// Id of the bundle
+	Id string `json:"id"`
+	// Format is the DebugBundleFormat of the bundle
+	Format string `json:"format"`
+	// Created is the time the bundle was written
+	Created time.Time `json:"created"`
+	// Trigger is the reason the bundle was generated
+	Trigger BundleTrigger `json:"trigger"`
+	// RequestHash is the hash of the request
+	RequestHash string `json:"request_hash"`
+	// Masked is true if the messages are masked
+	Masked bool `json:"masked"`
+	// Entries are the other entries of the bundle
+	Entries []BundleEntry `json:"entries"`
+}
+
+// BundleEntry describes an entry of a debug bundle
+type BundleEntry struct {
+	// Name of the entry in the archive
+	Name string `json:"name"`
+	// Size is the uncompressed size of the entry
+	Size int `json:"size"`
+	// Sha256 is the hex encoded digest of the entry
+	Sha256 string `json:"sha256"`
+}
+
+// BundleMessage is a message of a debug bundle. The text messages hold their masked text, the other messages only
+// their size and digest.
+type BundleMessage struct {
+	Actor  Actor  `json:"actor"`
+	Mime   string `json:"mime"`
+	Text   string `json:"text,omitempty"`
+	Url    string `json:"url,omitempty"`
+	Size   int    `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// BundleOptions are the generation options of a debug bundle
+type BundleOptions struct {
+	MaxTokens         int      `json:"max_tokens,omitempty"`
+	MinTokens         int      `json:"min_tokens,omitempty"`
+	Temperature       float32  `json:"temperature,omitempty"`
+	CandidateCount    int      `json:"candidate_count,omitempty"`
+	StopWords         []string `json:"stop_words,omitempty"`
+	TopK              int      `json:"top_k,omitempty"`
+	TopP              float64  `json:"top_p,omitempty"`
+	Seed              int      `json:"seed,omitempty"`
+	MinLength         int      `json:"min_length,omitempty"`
+	MaxLength         int      `json:"max_length,omitempty"`
+	RepetitionPenalty float64  `json:"repetition_penalty,omitempty"`
+	FrequencyPenalty  float64  `json:"frequency_penalty,omitempty"`
+	PresencePenalty   float64  `json:"presence_penalty,omitempty"`
+	Stream            bool     `json:"stream"`
+}
+
+// BundleRequest is the request entry of a debug bundle
+type BundleRequest struct {
+	// Model is the name of the model
+	Model string `json:"model"`
+	// ExchangeId is the id of the exchange
+	ExchangeId string `json:"exchange_id"`
+	// Options are the options of the model, nil if the model does not expose them
+	Options *BundleOptions `json:"options,omitempty"`
+	// Messages of the request
+	Messages []BundleMessage `json:"messages"`
+}
+
+// BundleUsage is the estimated number of tokens of an exchange, see CountTokens
+type BundleUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// BundleResponse is the response entry of a debug bundle
+type BundleResponse struct {
+	// Candidates are the messages added to the exchange by the model
+	Candidates []BundleMessage `json:"candidates"`
+	// FinishReason of the response
+	FinishReason FinishReason `json:"finish_reason"`
+	// Error of the generation, empty if none
+	Error string `json:"error,omitempty"`
+	// Usage is the estimated number of tokens
+	Usage BundleUsage `json:"usage"`
+}
+
+// BundleDecisions is the decisions entry of a debug bundle, the choices of the wrapped models
+type BundleDecisions struct {
+	// Attributes are the attributes of the exchange prefixed with genai. and holding a string, a number or a boolean,
+	// through which the wrapped models record their decisions
+	Attributes map[string]any `json:"attributes,omitempty"`
+	// Truncation is the fit report of an exchange rejected by GuardContext
+	Truncation *FitReport `json:"truncation,omitempty"`
+	// Blocked are the categories of an exchange blocked by WithModeration
+	Blocked []string `json:"blocked,omitempty"`
+}
+
+// BundleTiming is the timing entry of a debug bundle
+type BundleTiming struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Generate time.Duration `json:"generate"`
+}
+
+// BundleEnvironment is the environment entry of a debug bundle
+type BundleEnvironment struct {
+	GollyVersion string `json:"golly_version"`
+	GoVersion    string `json:"go_version"`
+	OS           string `json:"os"`
+	Arch         string `json:"arch"`
+	Model        string `json:"model"`
+	ModelVersion string `json:"model_version,omitempty"`
+	ModelAuthor  string `json:"model_author,omitempty"`
+}
+
+// bundleRecord holds the entries of a generation, already masked and redacted
+type bundleRecord struct {
+	hash        string
+	request     *BundleRequest
+	response    *BundleResponse
+	decisions   *BundleDecisions
+	logs        []l3.CapturedLog
+	timing      *BundleTiming
+	environment *BundleEnvironment
+}
+
+// DebugBundler writes the debug bundles of the generations of the models it wraps
+type DebugBundler struct {
+	opts     BundlerOptions
+	base     *url.URL
+	mutex    sync.Mutex
+	recorded map[string]*bundleRecord
+	order    []string
+}
+
+// NewDebugBundler creates a DebugBundler writing the bundles to the location of the options
+func NewDebugBundler(opts BundlerOptions) (b *DebugBundler, err error) {
+	if opts.Location == "" {
+		err = ErrBundleLocationRequired
+		return
+	}
+	if opts.Manager == nil {
+		opts.Manager = vfs.GetManager()
+	}
+	if opts.Random == nil {
+		opts.Random = rand.Float64
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	if opts.Recent == 0 {
+		opts.Recent = defaultBundleRecent
+	}
+	var base *url.URL
+	if base, err = url.Parse(strings.TrimSuffix(opts.Location, "/")); err != nil {
+		return
+	}
+	var dir vfs.VFile
+	if dir, err = opts.Manager.MkdirAll(base); err != nil {
+		return
+	}
+	ioutils.CloserFunc(dir)
+	b = &DebugBundler{opts: opts, base: base, recorded: make(map[string]*bundleRecord)}
+	return
+}
+
+// Wrap wraps the model so that its generations are bundled when requested or sampled, and recorded to be bundled
+// later. Wrap the other wrappers of the model, such as GuardContext, so that their decisions are bundled.
+func (b *DebugBundler) Wrap(model Model) Model {
+	return &bundledModel{Model: model, bundler: b}
+}
+
+// Bundle writes the bundle of a recorded request, identified by the RequestHashAttribute of its exchange, and
+// returns its id. ErrBundleNotRecorded is returned if the request is not among the recent ones.
+func (b *DebugBundler) Bundle(requestHash string) (id string, err error) {
+	b.mutex.Lock()
+	record, ok := b.recorded[requestHash]
+	b.mutex.Unlock()
+	if !ok {
+		err = fmt.Errorf("%w: %s", ErrBundleNotRecorded, requestHash)
+		return
+	}
+	return b.write(record, BundleRetroactive)
+}
+
+// record keeps the record among the recent ones, replacing the earlier record of the same request
+func (b *DebugBundler) record(record *bundleRecord) {
+	if b.opts.Recent < 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.recorded[record.hash]; !ok {
+		b.order = append(b.order, record.hash)
+	}
+	b.recorded[record.hash] = record
+	for len(b.order) > b.opts.Recent {
+		delete(b.recorded, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// write writes the bundle of the record and returns its id
+func (b *DebugBundler) write(record *bundleRecord, trigger BundleTrigger) (id string, err error) {
+	var u *uuid.UUID
+	if u, err = uuid.V4(); err != nil {
+		return
+	}
+	manifest := &BundleManifest{
+		Id:          u.String(),
+		Format:      DebugBundleFormat,
+		Created:     b.opts.Clock().UTC(),
+		Trigger:     trigger,
+		RequestHash: record.hash,
+		Masked:      b.opts.Mask != nil,
+	}
+	entries := []struct {
+		name  string
+		value any
+	}{
+		{"request.json", record.request},
+		{"response.json", record.response},
+		{"decisions.json", record.decisions},
+		{"logs.json", record.logs},
+		{"timing.json", record.timing},
+		{"environment.json", record.environment},
+	}
+	c := codec.JsonCodec()
+	contents := make([][]byte, len(entries))
+	for i, entry := range entries {
+		if contents[i], err = c.EncodeToBytes(entry.value); err != nil {
+			return
+		}
+		manifest.Entries = append(manifest.Entries, BundleEntry{Name: entry.name, Size: len(contents[i]),
+			Sha256: sha256Hex(contents[i])})
+	}
+	var manifestContent []byte
+	if manifestContent, err = c.EncodeToBytes(manifest); err != nil {
+		return
+	}
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	if err = writeZipEntry(archive, BundleManifestEntry, manifestContent); err != nil {
+		return
+	}
+	for i, entry := range entries {
+		if err = writeZipEntry(archive, entry.name, contents[i]); err != nil {
+			return
+		}
+	}
+	if err = archive.Close(); err != nil {
+		return
+	}
+	bundleUrl := b.base.JoinPath(manifest.Id + bundleExt)
+	var file vfs.VFile
+	if file, err = b.opts.Manager.Create(bundleUrl); err != nil {
+		return
+	}
+	_, err = file.Write(buf.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		id = manifest.Id
+		LOGGER.InfoF("wrote the debug bundle %s of the request %s", id, record.hash)
+	}
+	return
+}
+
+// writeZipEntry writes a compressed entry to the archive
+func writeZipEntry(archive *zip.Writer, name string, content []byte) (err error) {
+	var w io.Writer
+	if w, err = archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate}); err == nil {
+		_, err = w.Write(content)
+	}
+	return
+}
+
+// redact replaces the secrets of the options and the common forms of the keys and the tokens in the text
+func (b *DebugBundler) redact(text string) string {
+	for _, secret := range b.opts.Secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, redactedText)
+		}
+	}
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if sub := pattern.FindStringSubmatch(match); len(sub) == 3 {
+				return sub[1] + sub[2] + redactedText
+			}
+			return redactedText
+		})
+	}
+	return text
+}
+
+// messages converts the messages to their bundle form
+func (b *DebugBundler) messages(msgs []*Message) (bundled []BundleMessage) {
+	bundled = make([]BundleMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		m := BundleMessage{Actor: msg.Actor(), Mime: msg.Mime()}
+		if msg.u != nil {
+			// the credentials and the parameters of the urls may hold secrets
+			u := *msg.u
+			u.User, u.RawQuery, u.Fragment = nil, "", ""
+			m.Url = b.redact(u.String())
+		}
+		content := messageBytes(msg)
+		m.Size = len(content)
+		if len(content) > 0 {
+			m.Sha256 = sha256Hex(content)
+		}
+		if isTextMime(m.Mime) || m.Mime == "" {
+			text := string(content)
+			if b.opts.Mask != nil {
+				text = b.opts.Mask(text)
+			}
+			m.Text = b.redact(text)
+			// the digest of the original text would allow to confirm a guess of the masked content
+			if b.opts.Mask != nil {
+				m.Sha256 = ""
+			}
+		}
+		bundled = append(bundled, m)
+	}
+	return
+}
+
+// decisions returns the decisions of the wrapped models recorded in the attributes and the error
+func (b *DebugBundler) decisions(exchange Exchange, genErr error) *BundleDecisions {
+	d := &BundleDecisions{}
+	for key, value := range exchange.Attributes() {
+		if !strings.HasPrefix(key, "genai.") || value == nil {
+			continue
+		}
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.String:
+			value = b.redact(v.String())
+		case reflect.Bool:
+			value = v.Bool()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value = v.Uint()
+		case reflect.Float32, reflect.Float64:
+			value = v.Float()
+		default:
+			continue
+		}
+		if d.Attributes == nil {
+			d.Attributes = make(map[string]any)
+		}
+		d.Attributes[key] = value
+	}
+	var overflow *ContextOverflowError
+	if errors.As(genErr, &overflow) {
+		report := overflow.Report
+		d.Truncation = &report
+	}
+	var blocked *ContentBlockedError
+	if errors.As(genErr, &blocked) {
+		d.Blocked = blocked.Categories
+	}
+	return d
+}
+
+// bundledModel is a Model bundling its generations
+type bundledModel struct {
+	Model
+	bundler *DebugBundler
+}
+
+// Generate calls the model and bundles the generation
+func (m *bundledModel) Generate(exchange Exchange) error {
+	return m.generate(exchange, m.Model.Generate, false)
+}
+
+// GenerateStream calls the model and bundles the generation once the response is complete
+func (m *bundledModel) GenerateStream(exchange Exchange) error {
+	return m.generate(exchange, m.Model.GenerateStream, true)
+}
+
+// generate records the request, calls the model and bundles the generation if it is requested or sampled
+func (m *bundledModel) generate(exchange Exchange, generate func(Exchange) error, stream bool) (err error) {
+	b := m.bundler
+	requested, _ := exchange.Attributes()[DebugAttribute].(bool)
+	var trigger BundleTrigger
+	if requested {
+		trigger = BundleRequested
+	} else if b.opts.SampleRate > 0 && b.opts.Random() < b.opts.SampleRate {
+		trigger = BundleSampled
+	}
+	if trigger == "" && b.opts.Recent < 0 {
+		return generate(exchange)
+	}
+	request := append([]*Message{}, exchange.Messages()...)
+	record := &bundleRecord{
+		hash: requestHash(m.Name(), request),
+		request: &BundleRequest{
+			Model:      m.Name(),
+			ExchangeId: exchange.Id(),
+			Options:    bundleOptions(m.Model, stream),
+			Messages:   b.messages(request),
+		},
+	}
+	exchange.Attributes()[RequestHashAttribute] = record.hash
+	var capture *l3.Capture
+	if b.opts.CaptureLogs {
+		capture = l3.StartCapture(b.opts.LogSize)
+	}
+	start := b.opts.Clock()
+	err = generate(exchange)
+	end := b.opts.Clock()
+	if capture != nil {
+		record.logs = capture.Stop()
+		for i := range record.logs {
+			record.logs[i].Message = b.redact(record.logs[i].Message)
+		}
+	}
+	var response []*Message
+	if messages := exchange.Messages(); len(messages) > len(request) {
+		response = messages[len(request):]
+	}
+	record.response = &BundleResponse{Candidates: b.messages(response), FinishReason: FinishError}
+	if err != nil {
+		record.response.Error = b.redact(err.Error())
+	} else {
+		record.response.FinishReason = finishReasonOf(exchange, response)
+	}
+	for _, msg := range request {
+		record.response.Usage.InputTokens += CountTokens(msg)
+	}
+	for _, msg := range response {
+		record.response.Usage.OutputTokens += CountTokens(msg)
+	}
+	record.decisions = b.decisions(exchange, err)
+	record.timing = &BundleTiming{Start: start.UTC(), End: end.UTC(), Generate: end.Sub(start)}
+	record.environment = &BundleEnvironment{
+		GollyVersion: gollyVersion(),
+		GoVersion:    runtime.Version(),
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Model:        m.Name(),
+		ModelVersion: m.Version(),
+		ModelAuthor:  m.Author(),
+	}
+	b.record(record)
+	if trigger != "" {
+		if id, bundleErr := b.write(record, trigger); bundleErr == nil {
+			exchange.Attributes()[DebugBundleAttribute] = id
+		} else {
+			LOGGER.ErrorF("unable to write the debug bundle of the exchange %s: %v", exchange.Id(), bundleErr)
+		}
+	}
+	return
+}
+
+// requestHash returns the hash of the model and the messages of the request
+func requestHash(model string, msgs []*Message) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	for _, msg := range msgs {
+		h.Write([]byte{0})
+		h.Write([]byte(msg.Actor()))
+		h.Write([]byte{0})
+		h.Write([]byte(msg.Mime()))
+		h.Write([]byte{0})
+		if msg.u != nil {
+			h.Write([]byte(msg.u.String()))
+		}
+		h.Write([]byte{0})
+		h.Write(messageBytes(msg))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// bundleOptions returns the options of the model if it exposes them, such as the AbstractModel
+func bundleOptions(model Model, stream bool) *BundleOptions {
+	m, ok := model.(interface{ Options() *Options })
+	if !ok || m.Options() == nil {
+		return nil
+	}
+	o := m.Options()
+	return &BundleOptions{
+		MaxTokens:         o.MaxTokens,
+		MinTokens:         o.MinTokens,
+		Temperature:       o.Temperature,
+		CandidateCount:    o.CandidateCount,
+		StopWords:         o.StopWords,
+		TopK:              o.TopK,
+		TopP:              o.TopP,
+		Seed:              o.Seed,
+		MinLength:         o.MinLength,
+		MaxLength:         o.MaxLength,
+		RepetitionPenalty: o.RepetitionPenalty,
+		FrequencyPenalty:  o.FrequencyPenalty,
+		PresencePenalty:   o.PresencePenalty,
+		Stream:            stream,
+	}
+}
+
+// MaskPII masks the email addresses, the card numbers and the phone numbers of the text. It can be used as the Mask
+// of the BundlerOptions.
+func MaskPII(text string) string {
+	text = emailPattern.ReplaceAllString(text, maskedText)
+	text = cardPattern.ReplaceAllString(text, maskedText)
+	return phonePattern.ReplaceAllString(text, maskedText)
+}
@@ -0,0 +1,280 @@
+package genai
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"sort"
+	"strings"
+)
+
+// PartKind is the kind of a part of the input of a model
+type PartKind string
+
+const (
+	// PartSystem is a system instruction
+	PartSystem PartKind = "system"
+	// PartTurn is a turn of the conversation
+	PartTurn PartKind = "turn"
+	// PartToolCall is a call of a function or a tool
+	PartToolCall PartKind = "tool_call"
+	// PartToolResult is the result of a function or a tool
+	PartToolResult PartKind = "tool_result"
+	// PartImage is an image
+	PartImage PartKind = "image"
+	// PartBinary is a binary content other than an image
+	PartBinary PartKind = "binary"
+)
+
+// SuggestionKind is the kind of action reducing the tokens of the input of a model
+type SuggestionKind string
+
+const (
+	// SuggestDropTurn suggests dropping an old turn of the conversation
+	SuggestDropTurn SuggestionKind = "drop_turn"
+	// SuggestTrimToolResult suggests trimming or summarizing a large tool result
+	SuggestTrimToolResult SuggestionKind = "trim_tool_result"
+	// SuggestReduceImage suggests sending an image with a lower detail level
+	SuggestReduceImage SuggestionKind = "reduce_image"
+	// SuggestReduceOutput suggests reserving fewer output tokens
+	SuggestReduceOutput SuggestionKind = "reduce_output"
+)
+
+// reducedImageSize is the longest side of the images suggested with SuggestReduceImage
+const reducedImageSize = 512
+
+// ImageTokenFunc returns the number of tokens of an image of the dimensions
+type ImageTokenFunc func(width, height int) int
+
+// OpenAIImageTokens counts the tokens of an image sent to the OpenAI models with the high detail level. The image is
+// scaled to fit in 2048x2048, then its shortest side to 768, and costs 170 tokens for each 512x512 tile plus 85.
+func OpenAIImageTokens(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	w, h := float64(width), float64(height)
+	if scale := 2048 / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	if scale := 768 / math.Min(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	tiles := int(math.Ceil(math.Round(w)/512) * math.Ceil(math.Round(h)/512))
+	return 170*tiles + 85
+}
+
+// AnthropicImageTokens counts the tokens of an image sent to the Claude models as width*height/750. The image is
+// scaled so that its longest side is at most 1568 pixels and it costs at most 1600 tokens.
+func AnthropicImageTokens(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	w, h := float64(width), float64(height)
+	if scale := 1568 / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	return min(int(math.Ceil(w*h/750)), 1600)
+}
+
+// GeminiImageTokens counts the tokens of an image sent to the Gemini models. The images up to 384 pixels on both
+// sides cost 258 tokens, the larger ones 258 tokens for each 768x768 tile.
+func GeminiImageTokens(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	if width <= 384 && height <= 384 {
+		return 258
+	}
+	return 258 * ((width + 767) / 768) * ((height + 767) / 768)
+}
+
+// FitPart is the estimate of a message of the input
+type FitPart struct {
+	// Index is the index of the message in the input
+	Index int `json:"index"`
+	// Actor is the actor of the message
+	Actor Actor `json:"actor"`
+	// Kind is the kind of the message
+	Kind PartKind `json:"kind"`
+	// Mime is the MIME type of the message
+	Mime string `json:"mime"`
+	// Tokens is the estimated number of tokens of the message
+	Tokens int `json:"tokens"`
+	// Pinned is true for the system instructions and the latest message which the suggestions never drop
+	Pinned bool `json:"pinned"`
+	// Width and Height are the dimensions of an image, 0 if unknown
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// FitSuggestion is an action reducing the tokens of the input
+type FitSuggestion struct {
+	// Kind is the kind of the action
+	Kind SuggestionKind `json:"kind"`
+	// Index is the index of the message the action applies to, -1 for SuggestReduceOutput
+	Index int `json:"index"`
+	// Tokens is the estimated number of tokens saved by the action
+	Tokens int `json:"tokens"`
+}
+
+// FitReport is the breakdown of the tokens of the input of a model against its context window
+type FitReport struct {
+	// Model is the name of the model
+	Model string `json:"model"`
+	// ContextWindow is the number of tokens of the input and the output together
+	ContextWindow int `json:"context_window"`
+	// InputTokens is the estimated number of tokens of the messages
+	InputTokens int `json:"input_tokens"`
+	// OutputTokens is the number of tokens reserved for the output, the MaxTokens of the options
+	OutputTokens int `json:"output_tokens"`
+	// Margin is the number of tokens left in the context window, negative when the input does not fit
+	Margin int `json:"margin"`
+	// DoesFit is true if the input and the output fit in the context window
+	DoesFit bool `json:"does_fit"`
+	// Overflow is the number of tokens to cut for the input to fit, 0 if it fits
+	Overflow int `json:"overflow"`
+	// Parts are the estimates of the messages, the largest first
+	Parts []FitPart `json:"parts"`
+	// Suggestions are the actions covering the overflow: the oldest unpinned turns first, then the largest tool
+	// results, the image detail and the output tokens. Empty if the input fits.
+	Suggestions []FitSuggestion `json:"suggestions,omitempty"`
+}
+
+// ContextOverflowError is the error of the exchanges that do not fit in the context window of the model
+type ContextOverflowError struct {
+	// Report is the breakdown of the tokens of the exchange
+	Report FitReport
+}
+
+func (e *ContextOverflowError) Error() string {
+	return fmt.Sprintf("%v: about %d tokens in %d messages and %d output tokens exceed the %d tokens of %s",
+		ErrContextExceeded, e.Report.InputTokens, len(e.Report.Parts), e.Report.OutputTokens, e.Report.ContextWindow,
+		e.Report.Model)
+}
+
+func (e *ContextOverflowError) Unwrap() error {
+	return ErrContextExceeded
+}
+
+// AnalyzeFit estimates the tokens of each message and checks them, along with the MaxTokens of the options, against
+// the context window of the model. The limits registered for the model are used if limits is nil and
+// ErrUnknownModel is returned if there are none. The images of known dimensions are counted with the ImageTokens
+// of the limits, the other messages with its Counter. The counts are estimates and the real ones differ by provider.
+func AnalyzeFit(model string, limits *ModelLimits, msgs []*Message, opts *Options) (report FitReport, err error) {
+	if limits == nil {
+		l, ok := GetModelLimits(model)
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrUnknownModel, model)
+			return
+		}
+		limits = &l
+	}
+	counter := limits.Counter
+	if counter == nil {
+		counter = CountTokens
+	}
+	report.Model = model
+	report.ContextWindow = limits.ContextWindow
+	if opts != nil {
+		report.OutputTokens = opts.MaxTokens
+	}
+	report.Parts = make([]FitPart, len(msgs))
+	for i, msg := range msgs {
+		part := FitPart{Index: i, Actor: msg.Actor(), Kind: partKind(msg), Mime: msg.Mime(),
+			Pinned: msg.Actor() == SystemActor || i == len(msgs)-1}
+		if part.Kind == PartImage {
+			part.Width, part.Height = imageSize(msg)
+		}
+		if part.Width > 0 && limits.ImageTokens != nil {
+			part.Tokens = messageOverhead + limits.ImageTokens(part.Width, part.Height)
+		} else {
+			part.Tokens = counter(msg)
+		}
+		report.InputTokens += part.Tokens
+		report.Parts[i] = part
+	}
+	report.Margin = report.ContextWindow - report.InputTokens - report.OutputTokens
+	report.DoesFit = report.Margin >= 0
+	if !report.DoesFit {
+		report.Overflow = -report.Margin
+		report.Suggestions = suggest(report, limits.ImageTokens)
+	}
+	sort.SliceStable(report.Parts, func(i, j int) bool {
+		return report.Parts[i].Tokens > report.Parts[j].Tokens
+	})
+	return
+}
+
+// suggest returns the actions covering the overflow of the report. The parts are in the order of the messages.
+func suggest(report FitReport, imageTokens ImageTokenFunc) (suggestions []FitSuggestion) {
+	// the oldest unpinned turns, up to the overflow
+	saved := 0
+	for _, part := range report.Parts {
+		if saved >= report.Overflow {
+			break
+		}
+		if !part.Pinned {
+			suggestions = append(suggestions, FitSuggestion{Kind: SuggestDropTurn, Index: part.Index, Tokens: part.Tokens})
+			saved += part.Tokens
+		}
+	}
+	// the largest tool results and images, which can be trimmed or reduced instead of being dropped
+	var reductions []FitSuggestion
+	for _, part := range report.Parts {
+		switch {
+		case part.Kind == PartToolResult && part.Tokens > messageOverhead+toolOverhead:
+			reductions = append(reductions, FitSuggestion{Kind: SuggestTrimToolResult, Index: part.Index,
+				Tokens: part.Tokens - messageOverhead - toolOverhead})
+		case part.Kind == PartImage && part.Width > 0 && imageTokens != nil:
+			scale := min(1, float64(reducedImageSize)/float64(max(part.Width, part.Height)))
+			reduced := messageOverhead + imageTokens(max(1, int(float64(part.Width)*scale)),
+				max(1, int(float64(part.Height)*scale)))
+			if reduced < part.Tokens {
+				reductions = append(reductions, FitSuggestion{Kind: SuggestReduceImage, Index: part.Index,
+					Tokens: part.Tokens - reduced})
+			}
+		}
+	}
+	sort.SliceStable(reductions, func(i, j int) bool {
+		return reductions[i].Tokens > reductions[j].Tokens
+	})
+	suggestions = append(suggestions, reductions...)
+	if report.OutputTokens > 0 {
+		suggestions = append(suggestions, FitSuggestion{Kind: SuggestReduceOutput, Index: -1,
+			Tokens: min(report.OutputTokens, report.Overflow)})
+	}
+	return
+}
+
+// partKind returns the kind of the message
+func partKind(msg *Message) PartKind {
+	mime := msg.Mime()
+	switch {
+	case strings.HasPrefix(mime, "image/"):
+		return PartImage
+	case mime != "" && !isTextMime(mime):
+		return PartBinary
+	case msg.Actor() == SystemActor:
+		return PartSystem
+	case msg.Actor() == FunctionActor:
+		return PartToolCall
+	case msg.Actor() == ToolActor:
+		return PartToolResult
+	}
+	return PartTurn
+}
+
+// imageSize returns the dimensions of the image of the message, 0 if the image is not in the message or its format
+// is not known
+func imageSize(msg *Message) (width, height int) {
+	if buf, ok := msg.rwer.(*bytes.Buffer); ok {
+		if config, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes())); err == nil {
+			width, height = config.Width, config.Height
+		}
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package genai
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func pngImage(t *testing.T, width, height int) []byte {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, png.Encode(buf, image.NewGray(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func TestImageTokens(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		tokens        ImageTokenFunc
+		width, height int
+		want          int
+	}{
+		// the examples of the OpenAI vision guide
+		{"openai 1024x1024", OpenAIImageTokens, 1024, 1024, 765},
+		{"openai 2048x4096", OpenAIImageTokens, 2048, 4096, 1105},
+		{"openai 512x512", OpenAIImageTokens, 512, 512, 255},
+		// the examples of the Anthropic vision guide
+		{"anthropic 200x200", AnthropicImageTokens, 200, 200, 54},
+		{"anthropic 1000x1000", AnthropicImageTokens, 1000, 1000, 1334},
+		{"anthropic 1092x1092", AnthropicImageTokens, 1092, 1092, 1590},
+		{"anthropic 4000x3000", AnthropicImageTokens, 4000, 3000, 1600},
+		{"gemini 384x384", GeminiImageTokens, 384, 384, 258},
+		{"gemini 1024x1024", GeminiImageTokens, 1024, 1024, 1032},
+		{"gemini 768x400", GeminiImageTokens, 768, 400, 258},
+		{"empty", OpenAIImageTokens, 0, 10, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.tokens(tt.width, tt.height))
+		})
+	}
+}
+
+func TestAnalyzeFit(t *testing.T) {
+	exchange := NewExchange("fit")
+	_, _ = exchange.AddTxtMsg(strings.Repeat("s", 400), SystemActor)
+	_, _ = exchange.AddTxtMsg(strings.Repeat("q", 400), UserActor)
+	_, _ = exchange.AddJsonMsg(map[string]string{"q": strings.Repeat("c", 290)}, FunctionActor)
+	_, _ = exchange.AddTxtMsg(strings.Repeat("r", 3000), ToolActor)
+	_, _ = exchange.AddBinMsg(pngImage(t, 1024, 1024), "image/png", UserActor)
+	_, _ = exchange.AddTxtMsg(strings.Repeat("a", 400), UserActor)
+
+	limits := &ModelLimits{ContextWindow: 4000, ImageTokens: OpenAIImageTokens}
+	report, err := AnalyzeFit("vision", limits, exchange.Messages(), nil)
+	assert.NoError(t, err)
+	assert.True(t, report.DoesFit)
+	// 104 + 104 + 112 + 1012 + 769 + 104
+	assert.Equal(t, 2205, report.InputTokens)
+	assert.Equal(t, 4000-2205, report.Margin)
+	assert.Equal(t, 0, len(report.Suggestions))
+	assert.Equal(t, 3, report.Parts[0].Index)
+	assert.Equal(t, PartToolResult, report.Parts[0].Kind)
+	assert.Equal(t, PartImage, report.Parts[1].Kind)
+	assert.Equal(t, 1024, report.Parts[1].Width)
+	assert.Equal(t, 769, report.Parts[1].Tokens)
+	assert.Equal(t, PartToolCall, report.Parts[2].Kind)
+
+	report, err = AnalyzeFit("vision", &ModelLimits{ContextWindow: 2000, ImageTokens: OpenAIImageTokens},
+		exchange.Messages(), (&Options{}).SetMaxTokens(500))
+	assert.NoError(t, err)
+	assert.False(t, report.DoesFit)
+	assert.Equal(t, 705, report.Overflow)
+	assert.Equal(t, -705, report.Margin)
+	// the system instruction and the latest message are pinned, the oldest turns are dropped first
+	assert.Equal(t, []FitSuggestion{
+		{Kind: SuggestDropTurn, Index: 1, Tokens: 104},
+		{Kind: SuggestDropTurn, Index: 2, Tokens: 112},
+		{Kind: SuggestDropTurn, Index: 3, Tokens: 1012},
+		{Kind: SuggestTrimToolResult, Index: 3, Tokens: 1000},
+		{Kind: SuggestReduceImage, Index: 4, Tokens: 510},
+		{Kind: SuggestReduceOutput, Index: -1, Tokens: 500},
+	}, report.Suggestions)
+
+	// without an image formula the image counts as the other binary content
+	report, _ = AnalyzeFit("vision", &ModelLimits{ContextWindow: 4000}, exchange.Messages(), nil)
+	assert.Equal(t, 260, report.Parts[1].Tokens)
+	_, err = AnalyzeFit("unknown-model", nil, exchange.Messages(), nil)
+	assert.True(t, errors.Is(err, ErrUnknownModel))
+}
+
+func TestGuardContext_Overflow(t *testing.T) {
+	RegisterModelLimits("overflow-model", ModelLimits{ContextWindow: 1000, ImageTokens: AnthropicImageTokens})
+	model := &scriptedModel{AbstractModel: AbstractModel{name: "overflow-model"}}
+	guarded := GuardContext(model, (&Options{}).SetMaxTokens(200))
+
+	exchange := NewExchange("oversized")
+	_, _ = exchange.AddTxtMsg("You are a helpful assistant.", SystemActor)
+	for i := 0; i < 5; i++ {
+		_, _ = exchange.AddTxtMsg(strings.Repeat("q", 200), UserActor)
+		_, _ = exchange.AddTxtMsg(strings.Repeat("a", 200), AIActor)
+	}
+	_, _ = exchange.AddBinMsg(pngImage(t, 800, 600), "image/png", UserActor)
+	_, _ = exchange.AddTxtMsg("What is in the picture?", UserActor)
+
+	err := guarded.Generate(exchange)
+	assert.True(t, errors.Is(err, ErrContextExceeded))
+	overflow := &ContextOverflowError{}
+	assert.True(t, errors.As(err, &overflow))
+	report := overflow.Report
+	assert.Equal(t, "overflow-model", report.Model)
+	assert.False(t, report.DoesFit)
+	// 11 + 10 * 54 + 4 + 640 + 10
+	assert.Equal(t, 1205, report.InputTokens)
+	assert.Equal(t, 405, report.Overflow)
+	assert.Equal(t, PartImage, report.Parts[0].Kind)
+	assert.Equal(t, 644, report.Parts[0].Tokens)
+	// eight turns of 54 tokens cover the overflow
+	assert.Equal(t, 10, len(report.Suggestions))
+	assert.Equal(t, SuggestDropTurn, report.Suggestions[7].Kind)
+	assert.Equal(t, SuggestReduceImage, report.Suggestions[8].Kind)
+	assert.Equal(t, SuggestReduceOutput, report.Suggestions[9].Kind)
+	assert.Equal(t, 0, len(model.received))
+}
@@ -0,0 +1,557 @@
+package genai
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"oss.nandlabs.io/golly/codec"
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+// TerminologyAttribute is the attribute of the exchange holding the TermMatch audit records of the enforcement
+const TerminologyAttribute = "genai.terminology"
+
+var (
+	// ErrTerminologyViolation is the error of the exchanges whose response uses a term rejected by the glossary
+	ErrTerminologyViolation = errors.New("the content violates the terminology")
+	// ErrInvalidTerm is returned for the terms without variants to enforce or canonical form to replace them with
+	ErrInvalidTerm = errors.New("invalid term")
+)
+
+// TermPolicy is the action taken when a forbidden variant of a term is found in the generated content
+type TermPolicy string
+
+const (
+	// TermReplace replaces the variants with the canonical form
+	TermReplace TermPolicy = "replace"
+	// TermReject fails the exchange with a TerminologyViolationError
+	TermReject TermPolicy = "reject"
+	// TermFlag keeps the content and records the match in the TerminologyAttribute
+	TermFlag TermPolicy = "flag"
+)
+
+// TermScope selects the side of the exchange a term applies to
+type TermScope string
+
+const (
+	// TermInput terms are only part of the terminology instruction sent to the model
+	TermInput TermScope = "input"
+	// TermOutput terms are only enforced on the generated content
+	TermOutput TermScope = "output"
+	// TermBoth terms are sent to the model and enforced on the generated content
+	TermBoth TermScope = "both"
+)
+
+// Term is an entry of a Glossary
+type Term struct {
+	// Canonical is the only accepted form of the term. It is empty for the banned terms.
+	Canonical string `json:"canonical" yaml:"canonical"`
+	// Variants are the forbidden forms of the term
+	Variants []string `json:"variants" yaml:"variants"`
+	// Inflections are the suffixes the variants may carry, such as "s" or "'s", kept when a variant is replaced
+	Inflections []string `json:"inflections" yaml:"inflections"`
+	// Topics are the words of the input for which the term is relevant besides its forms
+	Topics []string `json:"topics" yaml:"topics"`
+	// CaseSensitive matches the variants with their case. Otherwise any case of the variants but the canonical form
+	// itself is a violation.
+	CaseSensitive bool `json:"case_sensitive" yaml:"case_sensitive"`
+	// Policy is the action taken on the variants. Defaults to TermReplace for the terms with a canonical form and to
+	// TermReject for the banned terms.
+	Policy TermPolicy `json:"policy" yaml:"policy"`
+	// AppliesTo is the side of the exchange the term applies to. Defaults to TermBoth.
+	AppliesTo TermScope `json:"applies_to" yaml:"applies_to"`
+}
+
+// Name returns the canonical form of the term, or its first variant for the banned terms
+func (t *Term) Name() string {
+	if t.Canonical != "" || len(t.Variants) == 0 {
+		return t.Canonical
+	}
+	return t.Variants[0]
+}
+
+// TermMatch is the audit record of a variant found in the generated content
+type TermMatch struct {
+	// Term is the name of the term
+	Term string `json:"term"`
+	// Policy is the policy applied
+	Policy TermPolicy `json:"policy"`
+	// Text is the text matched, with its inflection
+	Text string `json:"text"`
+	// Replacement is the text written instead with the TermReplace policy
+	Replacement string `json:"replacement,omitempty"`
+	// Message is the index of the message in the response
+	Message int `json:"message"`
+	// Offset is the byte offset of the match in the original text of the message
+	Offset int `json:"offset"`
+}
+
+// TerminologyViolationError reports the rejected terms found in the response
+type TerminologyViolationError struct {
+	// Matches are the matches of the terms with the TermReject policy
+	Matches []TermMatch
+}
+
+func (e *TerminologyViolationError) Error() string {
+	terms := make([]string, 0, len(e.Matches))
+	for _, m := range e.Matches {
+		terms = append(terms, fmt.Sprintf("%q", m.Text))
+	}
+	return fmt.Sprintf("%v: %s", ErrTerminologyViolation, strings.Join(terms, ", "))
+}
+
+func (e *TerminologyViolationError) Unwrap() error {
+	return ErrTerminologyViolation
+}
+
+// glossaryFile is the YAML document of LoadGlossary
+type glossaryFile struct {
+	Terms []Term `json:"terms" yaml:"terms"`
+}
+
+// Glossary holds the terminology of the generated content: the canonical forms of the product names and the banned
+// mentions. InjectTerminology sends the relevant terms to the model and EnforceTerminology checks its responses.
+type Glossary struct {
+	mutex sync.RWMutex
+	terms []*glossaryTerm
+}
+
+// glossaryTerm is a term with the pattern of its variants
+type glossaryTerm struct {
+	Term
+	pattern *regexp.Regexp
+	// lower are the lower case forms and topics of the term for the relevance
+	lower []string
+}
+
+// NewGlossary creates an empty Glossary
+func NewGlossary() *Glossary {
+	return &Glossary{}
+}
+
+// LoadGlossary creates a Glossary with the terms of the YAML file at the url, a document with a list of terms
+func LoadGlossary(u string) (g *Glossary, err error) {
+	var file vfs.VFile
+	if file, err = vfs.GetManager().OpenRaw(u); err != nil {
+		return
+	}
+	defer file.Close()
+	var c codec.Codec
+	doc := &glossaryFile{}
+	if c, err = codec.GetDefault(ioutils.MimeTextYAML); err == nil {
+		if err = c.Read(file, doc); err == nil {
+			g = NewGlossary()
+			if err = g.Add(doc.Terms...); err != nil {
+				g = nil
+			}
+		}
+	}
+	return
+}
+
+// Add adds the terms to the glossary. ErrInvalidTerm is returned, and no term is added, if a term has neither a
+// canonical form nor variants, or the TermReplace policy without a canonical form.
+func (g *Glossary) Add(terms ...Term) (err error) {
+	compiled := make([]*glossaryTerm, 0, len(terms))
+	for _, t := range terms {
+		var gt *glossaryTerm
+		if gt, err = compileTerm(t); err != nil {
+			return
+		}
+		compiled = append(compiled, gt)
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.terms = append(g.terms, compiled...)
+	return
+}
+
+// Terms returns the terms of the glossary with their defaults applied
+func (g *Glossary) Terms() (terms []Term) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	for _, t := range g.terms {
+		terms = append(terms, t.Term)
+	}
+	return
+}
+
+// Relevant returns the terms applying to the input whose forms or topics appear in the input, in any case
+func (g *Glossary) Relevant(input string) (terms []Term) {
+	input = strings.ToLower(input)
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	for _, t := range g.terms {
+		if t.AppliesTo == TermOutput {
+			continue
+		}
+		for _, s := range t.lower {
+			if strings.Contains(input, s) {
+				terms = append(terms, t.Term)
+				break
+			}
+		}
+	}
+	return
+}
+
+// Instruction returns the terminology instruction for the terms relevant to the input, empty if there are none
+func (g *Glossary) Instruction(input string) string {
+	terms := g.Relevant(input)
+	if len(terms) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("Terminology:")
+	for _, t := range terms {
+		sb.WriteString("\n- ")
+		switch {
+		case t.Canonical == "":
+			fmt.Fprintf(&sb, "Do not mention %s.", quoteAll(t.Variants))
+		case len(t.Variants) == 0:
+			fmt.Fprintf(&sb, "Always write %q.", t.Canonical)
+		default:
+			fmt.Fprintf(&sb, "Always write %q, never %s.", t.Canonical, quoteAll(t.Variants))
+		}
+	}
+	return sb.String()
+}
+
+// Enforce checks the text against the terms applying to the output. It returns the text with the variants of the
+// TermReplace terms replaced and the matches of all the policies, in the order of the text. The variants within
+// fenced or inline code, urls and link targets are left as is, and so are those within a word: a variant followed by
+// one of its inflections is replaced along with the inflection kept.
+func (g *Glossary) Enforce(text string) (result string, matches []TermMatch) {
+	protected := protectedSpans(text)
+	g.mutex.RLock()
+	for _, t := range g.terms {
+		if t.AppliesTo != TermInput && t.pattern != nil {
+			matches = append(matches, t.find(text, protected)...)
+		}
+	}
+	g.mutex.RUnlock()
+	// the earliest and then the longest of the overlapping matches wins
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Offset != matches[j].Offset {
+			return matches[i].Offset < matches[j].Offset
+		}
+		return len(matches[i].Text) > len(matches[j].Text)
+	})
+	var sb strings.Builder
+	kept, last := matches[:0], 0
+	for _, m := range matches {
+		if m.Offset < last {
+			continue
+		}
+		kept = append(kept, m)
+		sb.WriteString(text[last:m.Offset])
+		if m.Policy == TermReplace {
+			sb.WriteString(m.Replacement)
+		} else {
+			sb.WriteString(m.Text)
+		}
+		last = m.Offset + len(m.Text)
+	}
+	sb.WriteString(text[last:])
+	return sb.String(), kept
+}
+
+// compileTerm applies the defaults of the term and compiles the pattern of its variants
+func compileTerm(t Term) (gt *glossaryTerm, err error) {
+	if t.Canonical == "" && len(t.Variants) == 0 {
+		return nil, fmt.Errorf("%w: the term has no canonical form nor variants", ErrInvalidTerm)
+	}
+	if t.Policy == "" {
+		t.Policy = TermReplace
+		if t.Canonical == "" {
+			t.Policy = TermReject
+		}
+	}
+	if t.Policy == TermReplace && t.Canonical == "" {
+		return nil, fmt.Errorf("%w: the banned term %q cannot be replaced", ErrInvalidTerm, t.Name())
+	}
+	switch t.Policy {
+	case TermReplace, TermReject, TermFlag:
+	default:
+		return nil, fmt.Errorf("%w: unknown policy %q of the term %q", ErrInvalidTerm, t.Policy, t.Name())
+	}
+	if t.AppliesTo == "" {
+		t.AppliesTo = TermBoth
+	}
+	gt = &glossaryTerm{Term: t}
+	for _, s := range append(append([]string{t.Canonical}, t.Variants...), t.Topics...) {
+		if s != "" {
+			gt.lower = append(gt.lower, strings.ToLower(s))
+		}
+	}
+	variants := t.Variants
+	if !t.CaseSensitive && t.Canonical != "" {
+		// any other case of the canonical form is a variant
+		variants = append([]string{t.Canonical}, variants...)
+	}
+	if len(variants) > 0 {
+		expr := "(" + alternation(variants) + ")(?:" + alternation(t.Inflections) + ")?"
+		if !t.CaseSensitive {
+			expr = "(?i)" + expr
+		}
+		gt.pattern, err = regexp.Compile(expr)
+	}
+	return
+}
+
+// find returns the matches of the variants of the term in the text out of the protected spans
+func (t *glossaryTerm) find(text string, protected [][2]int) (matches []TermMatch) {
+	for _, loc := range t.pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := loc[0], loc[1]
+		// the inflection is part of the match only if the word ends with it
+		if !wordEndsAt(text, end) {
+			end = loc[3]
+		}
+		if !wordStartsAt(text, start) || !wordEndsAt(text, end) || overlaps(protected, start, end) {
+			continue
+		}
+		variant := text[start:loc[3]]
+		if variant == t.Canonical {
+			continue
+		}
+		m := TermMatch{Term: t.Name(), Policy: t.Policy, Text: text[start:end], Offset: start}
+		if t.Policy == TermReplace {
+			m.Replacement = t.Canonical + text[loc[3]:end]
+		}
+		matches = append(matches, m)
+	}
+	return
+}
+
+// alternation returns the alternation of the quoted strings, longest first so that the longest variant matches
+func alternation(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			quoted = append(quoted, regexp.QuoteMeta(v))
+		}
+	}
+	sort.SliceStable(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return strings.Join(quoted, "|")
+}
+
+// isWordRune checks if the rune is part of a word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// wordStartsAt checks that the offset of the text is not within a word
+func wordStartsAt(text string, offset int) bool {
+	if offset == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(text[:offset])
+	if offset < len(text) {
+		if first, _ := utf8.DecodeRuneInString(text[offset:]); !isWordRune(first) {
+			return true
+		}
+	}
+	return !isWordRune(r)
+}
+
+// wordEndsAt checks that the end offset of the text is not within a word
+func wordEndsAt(text string, offset int) bool {
+	if offset >= len(text) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(text[offset:])
+	if offset > 0 {
+		if last, _ := utf8.DecodeLastRuneInString(text[:offset]); !isWordRune(last) {
+			return true
+		}
+	}
+	return !isWordRune(r)
+}
+
+var (
+	// inlineCodePattern matches the inline code spans
+	inlineCodePattern = regexp.MustCompile("`[^`\n]+`")
+	// urlPattern matches the urls with a scheme and the targets of the markdown links
+	urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s<>"'` + "`" + `]+|\]\([^)\s]*\)`)
+)
+
+// protectedSpans returns the spans of the text that are never rewritten: the fenced code blocks, the inline code,
+// the urls and the targets of the links
+func protectedSpans(text string) (spans [][2]int) {
+	fence, start := "", 0
+	for offset := 0; offset < len(text); {
+		end := strings.IndexByte(text[offset:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += offset + 1
+		}
+		line := strings.TrimLeft(text[offset:end], " \t")
+		switch {
+		case fence == "" && (strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")):
+			fence, start = line[:3], offset
+		case fence != "" && strings.HasPrefix(line, fence):
+			spans = append(spans, [2]int{start, end})
+			fence = ""
+		}
+		offset = end
+	}
+	if fence != "" {
+		// an unterminated fence runs to the end of the text
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	for _, pattern := range []*regexp.Regexp{inlineCodePattern, urlPattern} {
+		for _, loc := range pattern.FindAllStringIndex(text, -1) {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+	}
+	return
+}
+
+// overlaps checks if the range of the text overlaps one of the spans
+func overlaps(spans [][2]int, start, end int) bool {
+	for _, span := range spans {
+		if start < span[1] && span[0] < end {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteAll returns the quoted values separated with commas
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// injectedModel is a Model adding the terminology instruction of a glossary to the system instructions
+type injectedModel struct {
+	Model
+	glossary *Glossary
+}
+
+// InjectTerminology wraps the model so that the terminology instruction of the terms relevant to the text messages of
+// the user is added to the system instructions of the exchange, after the last leading system message or first if
+// there is none. Only the relevant terms are sent to save tokens.
+func InjectTerminology(model Model, glossary *Glossary) Model {
+	return &injectedModel{Model: model, glossary: glossary}
+}
+
+// Generate adds the terminology instruction and calls the model
+func (m *injectedModel) Generate(exchange Exchange) error {
+	m.inject(exchange)
+	return m.Model.Generate(exchange)
+}
+
+// GenerateStream adds the terminology instruction and calls the model
+func (m *injectedModel) GenerateStream(exchange Exchange) error {
+	m.inject(exchange)
+	return m.Model.GenerateStream(exchange)
+}
+
+// inject adds the instruction of the terms relevant to the user messages to the exchange
+func (m *injectedModel) inject(exchange Exchange) {
+	var input strings.Builder
+	for _, msg := range exchange.MsgsByActors(UserActor) {
+		if isEnforcedMime(msg.Mime()) {
+			input.Write(messageBytes(msg))
+			input.WriteByte('\n')
+		}
+	}
+	instruction := m.glossary.Instruction(input.String())
+	if instruction == "" {
+		return
+	}
+	messages := exchange.Messages()
+	last := -1
+	for i := 0; i < len(messages) && messages[i].Actor() == SystemActor && isEnforcedMime(messages[i].Mime()); i++ {
+		last = i
+	}
+	if last < 0 {
+		if p, ok := exchange.(interface{ Prepend(message ...*Message) }); ok {
+			p.Prepend(newTextMessage(instruction, SystemActor))
+		} else {
+			LOGGER.WarnF("the terminology instruction cannot be added to the exchange %s", exchange.Id())
+		}
+		return
+	}
+	system := string(messageBytes(messages[last]))
+	messages[last] = newTextMessage(system+"\n\n"+instruction, SystemActor)
+}
+
+// enforcedModel is a Model enforcing the terminology of a glossary on its responses
+type enforcedModel struct {
+	Model
+	glossary *Glossary
+}
+
+// EnforceTerminology wraps the model so that the text messages of its responses are checked against the glossary.
+// The variants of the TermReplace terms are replaced in the messages and the matches of all the terms are set to the
+// TerminologyAttribute of the exchange for auditing. The exchanges whose response uses a TermReject term fail with a
+// TerminologyViolationError.
+//
+// GenerateStream enforces the terminology on the aggregated messages once the model completed: the chunks already
+// read from a streamed message are not rewritten.
+func EnforceTerminology(model Model, glossary *Glossary) Model {
+	return &enforcedModel{Model: model, glossary: glossary}
+}
+
+// Generate calls the model and enforces the terminology on the response
+func (m *enforcedModel) Generate(exchange Exchange) error {
+	return m.generate(exchange, m.Model.Generate)
+}
+
+// GenerateStream calls the model and enforces the terminology on the aggregated response
+func (m *enforcedModel) GenerateStream(exchange Exchange) error {
+	return m.generate(exchange, m.Model.GenerateStream)
+}
+
+// generate calls the model and enforces the terminology on the messages it added
+func (m *enforcedModel) generate(exchange Exchange, generate func(Exchange) error) (err error) {
+	before := len(exchange.Messages())
+	if err = generate(exchange); err != nil {
+		return
+	}
+	var matches, rejected []TermMatch
+	for i, msg := range exchange.Messages()[before:] {
+		if msg.Actor() != AIActor || !isEnforcedMime(msg.Mime()) {
+			continue
+		}
+		text := string(messageBytes(msg))
+		result, found := m.glossary.Enforce(text)
+		if result != text {
+			msg.rwer = bytes.NewBufferString(result)
+		}
+		for _, match := range found {
+			match.Message = i
+			matches = append(matches, match)
+			if match.Policy == TermReject {
+				rejected = append(rejected, match)
+			}
+		}
+	}
+	if len(matches) > 0 {
+		exchange.Attributes()[TerminologyAttribute] = matches
+		LOGGER.InfoF("the terminology of the exchange %s matched %d times", exchange.Id(), len(matches))
+	}
+	if len(rejected) > 0 {
+		err = &TerminologyViolationError{Matches: rejected}
+	}
+	return
+}
+
+// isEnforcedMime checks if the terminology applies to the content of the mime type
+func isEnforcedMime(mime string) bool {
+	return strings.HasPrefix(mime, "text/") || mime == ioutils.MimeMarkDown
+}
@@ -0,0 +1,195 @@
+package genai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+func testGlossary(t *testing.T) *Glossary {
+	g := NewGlossary()
+	assert.NoError(t, g.Add(
+		Term{Canonical: "Acme Cloud", Variants: []string{"AcmeCloud", "Acme-Cloud"}, Inflections: []string{"'s", "s"},
+			Topics: []string{"hosting"}},
+		Term{Variants: []string{"Globex"}, Topics: []string{"competitor"}},
+		Term{Canonical: "GoLLy", Variants: []string{"golly-lib"}, CaseSensitive: true, Policy: TermFlag,
+			AppliesTo: TermOutput},
+		Term{Canonical: "OrbitDB", AppliesTo: TermInput},
+	))
+	return g
+}
+
+func TestGlossary_Add(t *testing.T) {
+	g := NewGlossary()
+	err := g.Add(Term{Canonical: "ok"}, Term{})
+	assert.True(t, errors.Is(err, ErrInvalidTerm))
+	err = g.Add(Term{Variants: []string{"Globex"}, Policy: TermReplace})
+	assert.True(t, errors.Is(err, ErrInvalidTerm))
+	err = g.Add(Term{Canonical: "ok", Policy: "ignore"})
+	assert.True(t, errors.Is(err, ErrInvalidTerm))
+	// no term of a failed call is added
+	assert.Equal(t, 0, len(g.Terms()))
+
+	assert.NoError(t, g.Add(Term{Canonical: "Acme"}, Term{Variants: []string{"Globex"}}))
+	terms := g.Terms()
+	assert.Equal(t, TermReplace, terms[0].Policy)
+	assert.Equal(t, TermBoth, terms[0].AppliesTo)
+	assert.Equal(t, TermReject, terms[1].Policy)
+	assert.Equal(t, "Globex", terms[1].Name())
+}
+
+func TestGlossary_Enforce(t *testing.T) {
+	g := testGlossary(t)
+	tests := []struct {
+		name, text, want string
+		terms            []string
+	}{
+		{"start and end", "acme cloud hosts it on AcmeCloud", "Acme Cloud hosts it on Acme Cloud",
+			[]string{"Acme Cloud", "Acme Cloud"}},
+		{"canonical", "Acme Cloud is fine", "Acme Cloud is fine", nil},
+		{"punctuation", "(Acme-Cloud), \"acmecloud\"!", "(Acme Cloud), \"Acme Cloud\"!",
+			[]string{"Acme Cloud", "Acme Cloud"}},
+		{"inflections", "AcmeCloud's uptime beats other ACME CLOUDs", "Acme Cloud's uptime beats other Acme Clouds",
+			[]string{"Acme Cloud", "Acme Cloud"}},
+		{"inside words", "MyAcmeCloud and AcmeCloudy and AcmeClouds2", "MyAcmeCloud and AcmeCloudy and AcmeClouds2",
+			nil},
+		{"fenced code", "Use AcmeCloud:\n```go\nclient := AcmeCloud.New()\n```\nthen AcmeCloud",
+			"Use Acme Cloud:\n```go\nclient := AcmeCloud.New()\n```\nthen Acme Cloud", []string{"Acme Cloud", "Acme Cloud"}},
+		{"unterminated fence", "~~~\nAcmeCloud", "~~~\nAcmeCloud", nil},
+		{"inline code and urls",
+			"Run `acmecloud login` at https://acmecloud.example.com/AcmeCloud or [AcmeCloud](https://x.io/acme-cloud)",
+			"Run `acmecloud login` at https://acmecloud.example.com/AcmeCloud or [Acme Cloud](https://x.io/acme-cloud)",
+			[]string{"Acme Cloud"}},
+		{"case sensitive flag", "golly-lib, Golly-Lib and GoLLy", "golly-lib, Golly-Lib and GoLLy", []string{"GoLLy"}},
+		{"reject", "unlike Globex", "unlike Globex", []string{"Globex"}},
+		{"input only", "orbitdb", "orbitdb", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, matches := g.Enforce(tt.text)
+			assert.Equal(t, tt.want, result)
+			var terms []string
+			for _, m := range matches {
+				terms = append(terms, m.Term)
+				assert.Equal(t, m.Text, tt.text[m.Offset:m.Offset+len(m.Text)])
+			}
+			assert.Equal(t, tt.terms, terms)
+		})
+	}
+}
+
+func TestGlossary_Instruction(t *testing.T) {
+	g := testGlossary(t)
+	assert.Equal(t, "", g.Instruction("what is the weather?"))
+	// only the terms whose forms or topics are in the input
+	assert.Equal(t, "Terminology:\n- Always write \"Acme Cloud\", never \"AcmeCloud\", \"Acme-Cloud\".",
+		g.Instruction("Which HOSTING do you recommend?"))
+	assert.Equal(t, "Terminology:\n- Do not mention \"Globex\".\n- Always write \"OrbitDB\".",
+		g.Instruction("a competitor of orbitdb"))
+	// the output only terms are never sent
+	assert.Equal(t, "", g.Instruction("golly-lib"))
+}
+
+func TestInjectTerminology(t *testing.T) {
+	g := testGlossary(t)
+	model := &scriptedModel{}
+	wrapped := InjectTerminology(model, g)
+
+	exchange := NewExchange("system")
+	_, _ = exchange.AddTxtMsg("You are a support agent.", SystemActor)
+	_, _ = exchange.AddTxtMsg("Is Globex cheaper?", UserActor)
+	assert.NoError(t, wrapped.Generate(exchange))
+	assert.Equal(t, []string{"SYSTEM:You are a support agent.\n\nTerminology:\n- Do not mention \"Globex\".",
+		"USER:Is Globex cheaper?"}, model.received[0])
+
+	exchange = NewExchange("no-system")
+	_, _ = exchange.AddTxtMsg("Tell me about acme cloud", UserActor)
+	assert.NoError(t, wrapped.GenerateStream(exchange))
+	assert.Equal(t, "SYSTEM:Terminology:\n- Always write \"Acme Cloud\", never \"AcmeCloud\", \"Acme-Cloud\".",
+		model.received[1][0])
+
+	exchange = NewExchange("irrelevant")
+	_, _ = exchange.AddTxtMsg("hello", UserActor)
+	assert.NoError(t, wrapped.Generate(exchange))
+	assert.Equal(t, []string{"USER:hello"}, model.received[2])
+}
+
+func TestEnforceTerminology(t *testing.T) {
+	g := testGlossary(t)
+	reply := func(text string) func(exchange Exchange) error {
+		return func(exchange Exchange) (err error) {
+			_, err = exchange.AddTxtMsg(text, AIActor)
+			return
+		}
+	}
+	model := &scriptedModel{replies: []func(exchange Exchange) error{
+		reply("AcmeCloud and golly-lib"),
+		reply("Globex is cheaper than AcmeCloud"),
+		reply("Acme Cloud"),
+	}}
+	wrapped := EnforceTerminology(model, g)
+
+	// replaced and flagged
+	exchange := newTestExchange("replace", "acmecloud?")
+	assert.NoError(t, wrapped.Generate(exchange))
+	assert.Equal(t, "Acme Cloud and golly-lib", messageContent(exchange.Messages()[1]))
+	matches, _ := exchange.Attributes()[TerminologyAttribute].([]TermMatch)
+	assert.Equal(t, []TermMatch{
+		{Term: "Acme Cloud", Policy: TermReplace, Text: "AcmeCloud", Replacement: "Acme Cloud", Message: 0, Offset: 0},
+		{Term: "GoLLy", Policy: TermFlag, Text: "golly-lib", Message: 0, Offset: 14},
+	}, matches)
+
+	// rejected
+	exchange = newTestExchange("reject", "compare")
+	err := wrapped.GenerateStream(exchange)
+	assert.True(t, errors.Is(err, ErrTerminologyViolation))
+	var violation *TerminologyViolationError
+	assert.True(t, errors.As(err, &violation))
+	assert.Equal(t, 1, len(violation.Matches))
+	assert.Equal(t, "Globex", violation.Matches[0].Text)
+	assert.True(t, strings.HasSuffix(err.Error(), `"Globex"`))
+	// the replacements are applied and audited along with the rejection
+	assert.Equal(t, "Globex is cheaper than Acme Cloud", messageContent(exchange.Messages()[1]))
+	matches, _ = exchange.Attributes()[TerminologyAttribute].([]TermMatch)
+	assert.Equal(t, 2, len(matches))
+
+	exchange = newTestExchange("clean", "hi")
+	assert.NoError(t, wrapped.Generate(exchange))
+	_, ok := exchange.Attributes()[TerminologyAttribute]
+	assert.False(t, ok)
+}
+
+func TestLoadGlossary(t *testing.T) {
+	vfs.GetManager().Register(vfs.NewMemFs())
+	_, err := vfs.GetManager().MkdirAllRaw("mem://glossaries")
+	assert.NoError(t, err)
+	u := "mem://glossaries/" + t.Name() + ".yaml"
+	file, err := vfs.GetManager().CreateRaw(u)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`terms:
+  - canonical: Acme Cloud
+    variants: [AcmeCloud]
+    inflections: ["s"]
+  - variants: [Globex]
+    policy: flag
+    applies_to: output
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	g, err := LoadGlossary(u)
+	assert.NoError(t, err)
+	terms := g.Terms()
+	assert.Equal(t, 2, len(terms))
+	assert.Equal(t, []string{"s"}, terms[0].Inflections)
+	assert.Equal(t, TermFlag, terms[1].Policy)
+	assert.Equal(t, TermOutput, terms[1].AppliesTo)
+	result, _ := g.Enforce("AcmeClouds")
+	assert.Equal(t, "Acme Clouds", result)
+
+	_, err = LoadGlossary("mem://glossaries/missing.yaml")
+	assert.Error(t, err)
+}
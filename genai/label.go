@@ -0,0 +1,376 @@
+package genai
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"oss.nandlabs.io/golly/vfs"
+)
+
+// Content labels disclose that a content was generated by a model. A label records the provider and the model, the
+// time of the generation, the hash of the request and of the content, and is signed with an HMAC-SHA256 over the
+// content hash and the fields of the label using a key of the application. A label cannot be forged without the key
+// nor transplanted onto another content.
+//
+// Labels are metadata travelling along with the content. They are not a watermark of the content: anyone removing the
+// label, or changing the content and dropping the label, removes the disclosure, and a content without a label is
+// not proof that it was written by a person.
+
+const (
+	// LabelAttribute is the attribute of the exchange holding the ContentLabel of the response set by WithLabels
+	LabelAttribute = "genai.content_label"
+	// LabelGenerator is the name of the generator of the labels
+	LabelGenerator = "oss.nandlabs.io/golly"
+	// labelDigitalSourceType is the IPTC digital source type of the content created by a model
+	labelDigitalSourceType = "http://cv.iptc.org/newscodes/digitalsourcetype/trainedAlgorithmicMedia"
+	labelFootnote          = "ai-generated"
+)
+
+// ErrLabelKeyRequired is returned when a label is requested without a signing key
+var ErrLabelKeyRequired = errors.New("a key is required to sign the content label")
+
+// ErrLabelNotFound is returned when a text or a sidecar does not hold a content label
+var ErrLabelNotFound = errors.New("content label not found")
+
+// LabelFormat selects how a label is embedded in a rendered text
+type LabelFormat int
+
+const (
+	// LabelHTMLComment embeds the label as an HTML comment appended to the content
+	LabelHTMLComment LabelFormat = iota
+	// LabelMarkdownFootnote embeds the label as a markdown footnote appended to the content
+	LabelMarkdownFootnote
+)
+
+// LabelOptions configures the content labels
+type LabelOptions struct {
+	// Provider is the name of the provider of the model, e.g. openai
+	Provider string
+	// Model is the name of the model. WithLabels uses the name of the wrapped model if empty.
+	Model string
+	// Organization is an optional identifier of the organization publishing the content
+	Organization string
+	// Key is the secret of the HMAC signing the labels
+	Key []byte
+}
+
+// ContentLabel discloses that a content was generated by a model
+type ContentLabel struct {
+	// Provider is the name of the provider of the model
+	Provider string `json:"provider,omitempty"`
+	// Model is the name of the model
+	Model string `json:"model,omitempty"`
+	// Generated is the time of the generation, in UTC
+	Generated time.Time `json:"generated"`
+	// RequestSha256 is the hex encoded SHA-256 of the request, empty if the request is not known
+	RequestSha256 string `json:"request_sha256,omitempty"`
+	// ContentSha256 is the hex encoded SHA-256 of the labeled content
+	ContentSha256 string `json:"content_sha256"`
+	// Generator is the name and the version of the library that created the label
+	Generator string `json:"generator"`
+	// Organization is the identifier of the organization publishing the content
+	Organization string `json:"organization,omitempty"`
+	// Signature is the base64 encoded HMAC-SHA256 of the label without its signature
+	Signature string `json:"signature"`
+}
+
+// labelNow returns the time of the labels, replaced by the tests
+var labelNow = time.Now
+
+// NewContentLabel creates the label of the content generated for the request. The request may be nil.
+func NewContentLabel(content, request []byte, opts LabelOptions) (label ContentLabel, err error) {
+	if len(opts.Key) == 0 {
+		err = ErrLabelKeyRequired
+		return
+	}
+	label = ContentLabel{
+		Provider:      opts.Provider,
+		Model:         opts.Model,
+		Generated:     labelNow().UTC(),
+		ContentSha256: sha256Hex(content),
+		Generator:     LabelGenerator + "/" + gollyVersion(),
+		Organization:  opts.Organization,
+	}
+	if request != nil {
+		label.RequestSha256 = sha256Hex(request)
+	}
+	label.Signature, err = label.sign(opts.Key)
+	return
+}
+
+// LabelResponse creates the label of the response of the exchange. The response is made of the AI messages following
+// the last message of another actor, and the request of the messages before them. The messages read by the model
+// lose their content, so the request hash is only meaningful for the messages not read yet; WithLabels reads the
+// request before calling the model.
+func LabelResponse(exchange Exchange, opts LabelOptions) (label ContentLabel, err error) {
+	request, response := splitResponse(exchange)
+	if len(response) == 0 {
+		err = ErrNoResponse
+		return
+	}
+	return NewContentLabel(ResponseContent(exchange), contentOf(request, true), opts)
+}
+
+// ResponseContent returns the content of the response of the exchange labeled by LabelResponse, the contents of its
+// messages in order
+func ResponseContent(exchange Exchange) []byte {
+	_, response := splitResponse(exchange)
+	return contentOf(response, false)
+}
+
+// splitResponse splits the messages of the exchange into the request and the response
+func splitResponse(exchange Exchange) (request, response []*Message) {
+	messages := exchange.Messages()
+	i := len(messages)
+	for i > 0 && messages[i-1].Actor() == AIActor {
+		i--
+	}
+	return messages[:i], messages[i:]
+}
+
+// contentOf concatenates the content of the messages, prefixed by the actor of each message if withActor is true
+func contentOf(messages []*Message, withActor bool) []byte {
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		if withActor {
+			buf.WriteString(string(msg.Actor()) + ":")
+		}
+		buf.WriteString(messageContent(msg))
+		if withActor {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// VerifyLabel returns true if the label was signed with the key for this content and none of its fields changed
+func VerifyLabel(content []byte, label ContentLabel, key []byte) bool {
+	if len(key) == 0 || label.ContentSha256 != sha256Hex(content) {
+		return false
+	}
+	expected, err := label.sign(key)
+	return err == nil && hmac.Equal([]byte(expected), []byte(label.Signature))
+}
+
+// sign returns the signature of the label
+func (l ContentLabel) sign(key []byte) (signature string, err error) {
+	l.Signature = ""
+	var data []byte
+	if data, err = json.Marshal(l); err == nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return
+}
+
+// token returns the label as base64 encoded JSON
+func (l ContentLabel) token() string {
+	data, _ := json.Marshal(l)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Embed returns the text with the label appended in the format. ExtractLabel returns the text and the label back.
+func (l ContentLabel) Embed(text string, format LabelFormat) string {
+	switch format {
+	case LabelMarkdownFootnote:
+		return fmt.Sprintf("%s[^%s]\n\n[^%s]: Generated by %s on %s. Label: %s\n", text, labelFootnote, labelFootnote,
+			l.agent(), l.Generated.Format(time.RFC3339), l.token())
+	default:
+		return fmt.Sprintf("%s\n<!-- %s %s by %s: %s -->\n", text, labelFootnote, l.Generated.Format(time.RFC3339),
+			l.agent(), l.token())
+	}
+}
+
+// agent returns the provider and the model of the label
+func (l ContentLabel) agent() string {
+	if l.Provider == "" {
+		return l.Model
+	}
+	return l.Provider + "/" + l.Model
+}
+
+var embeddedLabel = regexp.MustCompile(`(?:\n<!-- ` + labelFootnote + ` \S+ by .*?: ([A-Za-z0-9_-]+) -->|` +
+	`\[\^` + labelFootnote + `\]\n\n\[\^` + labelFootnote + `\]: Generated by .* Label: ([A-Za-z0-9_-]+))\n$`)
+
+// ExtractLabel returns the text without the label embedded by ContentLabel.Embed, and the label. The label is not
+// verified.
+func ExtractLabel(text string) (content string, label ContentLabel, err error) {
+	loc := embeddedLabel.FindStringSubmatchIndex(text)
+	if loc == nil {
+		err = ErrLabelNotFound
+		return
+	}
+	var token string
+	if loc[2] >= 0 {
+		token = text[loc[2]:loc[3]]
+	} else {
+		token = text[loc[4]:loc[5]]
+	}
+	var data []byte
+	if data, err = base64.RawURLEncoding.DecodeString(token); err == nil {
+		if err = json.Unmarshal(data, &label); err == nil {
+			content = text[:loc[0]]
+		}
+	}
+	return
+}
+
+// LabelSidecar is a C2PA style manifest of a generated media stored beside the media
+type LabelSidecar struct {
+	// ClaimGenerator is the generator of the manifest
+	ClaimGenerator string `json:"claim_generator"`
+	// Format is the MIME type of the media
+	Format string `json:"format,omitempty"`
+	// Asset identifies the media by its hash
+	Asset LabelAsset `json:"asset"`
+	// Assertions describe how the media was created
+	Assertions []LabelAssertion `json:"assertions"`
+	// Label is the signed content label of the media
+	Label ContentLabel `json:"golly.content_label"`
+}
+
+// LabelAsset identifies a media by its hash
+type LabelAsset struct {
+	// Alg is the hash algorithm, sha256
+	Alg string `json:"alg"`
+	// Hash is the hex encoded hash of the media
+	Hash string `json:"hash"`
+}
+
+// LabelAssertion is an assertion of the manifest
+type LabelAssertion struct {
+	// Label is the name of the assertion, e.g. c2pa.actions
+	Label string `json:"label"`
+	// Data is the content of the assertion
+	Data map[string]any `json:"data"`
+}
+
+// Sidecar returns the manifest of the media labeled by l, with the MIME type of the media
+func (l ContentLabel) Sidecar(mimeType string) *LabelSidecar {
+	return &LabelSidecar{
+		ClaimGenerator: l.Generator,
+		Format:         mimeType,
+		Asset:          LabelAsset{Alg: "sha256", Hash: l.ContentSha256},
+		Assertions: []LabelAssertion{{
+			Label: "c2pa.actions",
+			Data: map[string]any{"actions": []map[string]any{{
+				"action":            "c2pa.created",
+				"when":              l.Generated.Format(time.RFC3339),
+				"softwareAgent":     l.agent(),
+				"digitalSourceType": labelDigitalSourceType,
+			}}},
+		}},
+		Label: l,
+	}
+}
+
+// PutLabeled stores the media and its sidecar manifest in the blob store and returns their digests. The digest of
+// the media is the content hash of the label, so the sidecar found for a digest is checked against the stored media
+// with VerifyLabel.
+func PutLabeled(store *vfs.BlobStore, media []byte, mimeType string, label ContentLabel) (digest, sidecarDigest string,
+	err error) {
+	var sidecar []byte
+	if sidecar, err = json.Marshal(label.Sidecar(mimeType)); err != nil {
+		return
+	}
+	if digest, _, err = store.Put(bytes.NewReader(media)); err == nil {
+		sidecarDigest, _, err = store.Put(bytes.NewReader(sidecar))
+	}
+	return
+}
+
+// GetSidecar reads the sidecar manifest stored in the blob store
+func GetSidecar(store *vfs.BlobStore, sidecarDigest string) (sidecar *LabelSidecar, err error) {
+	var file vfs.VFile
+	if file, err = store.Get(sidecarDigest); err != nil {
+		return
+	}
+	defer file.Close()
+	var data []byte
+	if data, err = file.AsBytes(); err == nil {
+		sidecar = &LabelSidecar{}
+		if err = json.Unmarshal(data, sidecar); err == nil && sidecar.Label.Signature == "" {
+			sidecar, err = nil, ErrLabelNotFound
+		}
+	}
+	return
+}
+
+// labeledModel is a Model labeling the responses of the model
+type labeledModel struct {
+	Model
+	opts LabelOptions
+}
+
+// WithLabels wraps the model so that the label of each response is set to the LabelAttribute of the exchange. The
+// streamed responses are labeled once the model added the complete response to the exchange.
+func WithLabels(model Model, opts LabelOptions) Model {
+	if opts.Model == "" {
+		opts.Model = model.Name()
+	}
+	return &labeledModel{Model: model, opts: opts}
+}
+
+// Generate calls the model and labels the response
+func (m *labeledModel) Generate(exchange Exchange) (err error) {
+	request := contentOf(exchange.Messages(), true)
+	if err = m.Model.Generate(exchange); err == nil {
+		err = m.label(exchange, request)
+	}
+	return
+}
+
+// GenerateStream calls the model and labels the aggregated response
+func (m *labeledModel) GenerateStream(exchange Exchange) (err error) {
+	request := contentOf(exchange.Messages(), true)
+	if err = m.Model.GenerateStream(exchange); err == nil {
+		err = m.label(exchange, request)
+	}
+	return
+}
+
+// label sets the label of the response to the exchange. The request is read before calling the model, which consumes
+// the content of the messages.
+func (m *labeledModel) label(exchange Exchange, request []byte) (err error) {
+	_, response := splitResponse(exchange)
+	if len(response) == 0 {
+		return ErrNoResponse
+	}
+	var label ContentLabel
+	if label, err = NewContentLabel(contentOf(response, false), request, m.opts); err == nil {
+		exchange.Attributes()[LabelAttribute] = label
+	}
+	return
+}
+
+// sha256Hex returns the hex encoded SHA-256 of the data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// gollyVersion returns the version of the golly module in the build
+func gollyVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == LabelGenerator {
+			return strings.TrimPrefix(info.Main.Version, "v")
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == LabelGenerator {
+				return strings.TrimPrefix(dep.Version, "v")
+			}
+		}
+	}
+	return "(devel)"
+}
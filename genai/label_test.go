@@ -0,0 +1,194 @@
+package genai
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/testing/assert"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+var labelKey = []byte("label secret")
+
+func testLabel(t *testing.T, content string) ContentLabel {
+	labelNow = func() time.Time { return time.Date(2026, 10, 15, 9, 30, 0, 0, time.FixedZone("CEST", 7200)) }
+	t.Cleanup(func() { labelNow = time.Now })
+	label, err := NewContentLabel([]byte(content), []byte("USER:hello\n"), LabelOptions{
+		Provider: "openai", Model: "gpt-4o", Organization: "acme", Key: labelKey,
+	})
+	assert.NoError(t, err)
+	return label
+}
+
+func TestNewContentLabel(t *testing.T) {
+	label := testLabel(t, "generated text")
+	assert.Equal(t, "2026-10-15T07:30:00Z", label.Generated.Format(time.RFC3339))
+	assert.Equal(t, sha256Hex([]byte("generated text")), label.ContentSha256)
+	assert.Equal(t, sha256Hex([]byte("USER:hello\n")), label.RequestSha256)
+	assert.True(t, strings.HasPrefix(label.Generator, LabelGenerator+"/"))
+	assert.True(t, VerifyLabel([]byte("generated text"), label, labelKey))
+
+	_, err := NewContentLabel([]byte("generated text"), nil, LabelOptions{})
+	assert.True(t, errors.Is(err, ErrLabelKeyRequired))
+}
+
+func TestVerifyLabel_Tampering(t *testing.T) {
+	content := []byte("generated text")
+	label := testLabel(t, string(content))
+	assert.False(t, VerifyLabel([]byte("generated text!"), label, labelKey))
+	assert.False(t, VerifyLabel(content, label, []byte("other key")))
+	assert.False(t, VerifyLabel(content, label, nil))
+
+	// the label of another content cannot be transplanted
+	other := testLabel(t, "other text")
+	assert.False(t, VerifyLabel(content, other, labelKey))
+
+	for name, tamper := range map[string]func(l *ContentLabel){
+		"provider":     func(l *ContentLabel) { l.Provider = "local" },
+		"model":        func(l *ContentLabel) { l.Model = "gpt-4o-mini" },
+		"generated":    func(l *ContentLabel) { l.Generated = l.Generated.Add(time.Second) },
+		"request":      func(l *ContentLabel) { l.RequestSha256 = "" },
+		"organization": func(l *ContentLabel) { l.Organization = "other" },
+		"generator":    func(l *ContentLabel) { l.Generator = "other" },
+		"signature":    func(l *ContentLabel) { l.Signature = other.Signature },
+	} {
+		tampered := label
+		tamper(&tampered)
+		if VerifyLabel(content, tampered, labelKey) {
+			t.Errorf("the label with a tampered %s is verified", name)
+		}
+	}
+}
+
+func TestContentLabel_Embed(t *testing.T) {
+	text := "# Answer\n\nIt is sunny in Paris."
+	label := testLabel(t, text)
+	for _, format := range []LabelFormat{LabelHTMLComment, LabelMarkdownFootnote} {
+		embedded := label.Embed(text, format)
+		assert.True(t, strings.Contains(embedded, "openai/gpt-4o"))
+		content, extracted, err := ExtractLabel(embedded)
+		assert.NoError(t, err)
+		assert.Equal(t, text, content)
+		assert.Equal(t, label, extracted)
+		assert.True(t, VerifyLabel([]byte(content), extracted, labelKey))
+
+		// the content changed after the embedding
+		content, extracted, err = ExtractLabel(strings.Replace(embedded, "sunny", "rainy", 1))
+		assert.NoError(t, err)
+		assert.False(t, VerifyLabel([]byte(content), extracted, labelKey))
+	}
+	assert.True(t, strings.HasPrefix(label.Embed(text, LabelMarkdownFootnote), text+"[^ai-generated]\n\n"))
+	assert.True(t, strings.HasPrefix(label.Embed(text, LabelHTMLComment), text+"\n<!-- ai-generated "))
+
+	_, _, err := ExtractLabel(text)
+	assert.True(t, errors.Is(err, ErrLabelNotFound))
+}
+
+func TestPutLabeled(t *testing.T) {
+	vfs.GetManager().Register(vfs.NewMemFs())
+	store, err := vfs.NewBlobStore(vfs.GetManager(), "mem://labels/"+t.Name(), vfs.BlobStoreOptions{})
+	assert.NoError(t, err)
+	media := []byte("\x89PNG generated image")
+	label := testLabel(t, string(media))
+	digest, sidecarDigest, err := PutLabeled(store, media, "image/png", label)
+	assert.NoError(t, err)
+	assert.Equal(t, label.ContentSha256, digest)
+
+	sidecar, err := GetSidecar(store, sidecarDigest)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", sidecar.Format)
+	assert.Equal(t, LabelAsset{Alg: "sha256", Hash: digest}, sidecar.Asset)
+	assert.Equal(t, "c2pa.actions", sidecar.Assertions[0].Label)
+	assert.Equal(t, label, sidecar.Label)
+
+	file, err := store.Get(digest)
+	assert.NoError(t, err)
+	stored, err := file.AsBytes()
+	assert.NoError(t, err)
+	_ = file.Close()
+	assert.True(t, VerifyLabel(stored, sidecar.Label, labelKey))
+
+	// a blob that is not a sidecar
+	_, err = GetSidecar(store, digest)
+	assert.Error(t, err)
+}
+
+// streamingModel streams the reply in chunks to the handler before adding the aggregated reply to the exchange
+type streamingModel struct {
+	scriptedModel
+	chunks  []string
+	handler func(reader io.Reader) error
+}
+
+func (m *streamingModel) GenerateStream(exchange Exchange) (err error) {
+	var aggregated strings.Builder
+	for _, chunk := range m.chunks {
+		aggregated.WriteString(chunk)
+		if err = m.handler(strings.NewReader(chunk)); err != nil {
+			return
+		}
+	}
+	_, err = exchange.AddTxtMsg(aggregated.String(), AIActor)
+	return
+}
+
+func TestWithLabels(t *testing.T) {
+	model := &scriptedModel{AbstractModel: AbstractModel{name: "gpt-4o"}}
+	labeled := WithLabels(model, LabelOptions{Provider: "openai", Key: labelKey})
+	exchange := NewExchange("labels")
+	_, _ = exchange.AddTxtMsg("hello", UserActor)
+	assert.NoError(t, labeled.Generate(exchange))
+
+	label, ok := exchange.Attributes()[LabelAttribute].(ContentLabel)
+	assert.True(t, ok)
+	assert.Equal(t, "gpt-4o", label.Model)
+	assert.Equal(t, "reply 1", string(ResponseContent(exchange)))
+	assert.Equal(t, sha256Hex([]byte("USER:hello\n")), label.RequestSha256)
+	assert.True(t, VerifyLabel(ResponseContent(exchange), label, labelKey))
+
+	// the streamed response is labeled from the aggregated content, not from the chunks
+	var streamed []string
+	stream := &streamingModel{scriptedModel: *model, chunks: []string{"It is ", "sunny"},
+		handler: func(reader io.Reader) error {
+			b, err := io.ReadAll(reader)
+			streamed = append(streamed, string(b))
+			return err
+		}}
+	exchange = NewExchange("stream")
+	_, _ = exchange.AddTxtMsg("weather?", UserActor)
+	assert.NoError(t, WithLabels(stream, LabelOptions{Key: labelKey}).GenerateStream(exchange))
+	assert.Equal(t, []string{"It is ", "sunny"}, streamed)
+	label = exchange.Attributes()[LabelAttribute].(ContentLabel)
+	assert.True(t, VerifyLabel([]byte("It is sunny"), label, labelKey))
+	assert.False(t, VerifyLabel([]byte("sunny"), label, labelKey))
+
+	// an exchange without a response is not labeled
+	silent := &scriptedModel{replies: []func(exchange Exchange) error{
+		func(exchange Exchange) error { return nil },
+	}}
+	exchange = NewExchange("empty")
+	_, _ = exchange.AddBinMsg([]byte("data"), ioutils.MimeApplicationOctetStream, UserActor)
+	err := WithLabels(silent, LabelOptions{Key: labelKey}).Generate(exchange)
+	assert.True(t, errors.Is(err, ErrNoResponse))
+	_, ok = exchange.Attributes()[LabelAttribute]
+	assert.False(t, ok)
+}
+
+func TestLabelResponse(t *testing.T) {
+	exchange := NewExchange("response")
+	_, err := LabelResponse(exchange, LabelOptions{Key: labelKey})
+	assert.True(t, errors.Is(err, ErrNoResponse))
+
+	_, _ = exchange.AddTxtMsg("hello", UserActor)
+	_, _ = exchange.AddTxtMsg("Hi", AIActor)
+	_, _ = exchange.AddTxtMsg(" there", AIActor)
+	label, err := LabelResponse(exchange, LabelOptions{Model: "local", Key: labelKey})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi there", string(ResponseContent(exchange)))
+	assert.Equal(t, sha256Hex([]byte("USER:hello\n")), label.RequestSha256)
+	assert.True(t, VerifyLabel([]byte("Hi there"), label, labelKey))
+}
@@ -0,0 +1,187 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/rest/client"
+)
+
+const (
+	// OpenAIBaseUrl is the default base url of the OpenAI API
+	OpenAIBaseUrl = "https://api.openai.com/v1"
+	// OpenAIModerationModel is the default model of the OpenAI moderations endpoint
+	OpenAIModerationModel = "omni-moderation-latest"
+)
+
+// ErrContentBlocked is the error of the exchanges whose content is blocked by the moderation policy
+var ErrContentBlocked = errors.New("the content is blocked by the moderation policy")
+
+// ContentBlockedError reports the categories of the moderation policy exceeded by the content
+type ContentBlockedError struct {
+	// Categories are the names of the categories exceeding their threshold, sorted
+	Categories []string
+	// Result is the result of the moderation
+	Result *ModerationResult
+}
+
+func (e *ContentBlockedError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrContentBlocked, strings.Join(e.Categories, ", "))
+}
+
+func (e *ContentBlockedError) Unwrap() error {
+	return ErrContentBlocked
+}
+
+// ModerationResult is the result of the moderation of a content
+type ModerationResult struct {
+	// Flagged is true if the moderator flagged the content
+	Flagged bool `json:"flagged"`
+	// Categories holds the categories flagged by the moderator
+	Categories map[string]bool `json:"categories"`
+	// Scores holds the score of each category, between 0 and 1
+	Scores map[string]float64 `json:"category_scores"`
+}
+
+// Moderator checks a content for the categories of unsafe content
+type Moderator interface {
+	// Moderate checks the input with the moderation model. An empty model selects the default of the moderator.
+	Moderate(ctx context.Context, model string, input string) (*ModerationResult, error)
+}
+
+// ModerationPolicy decides whether a moderated content is blocked
+type ModerationPolicy struct {
+	// Model is the moderation model passed to the moderator
+	Model string
+	// Thresholds holds the score from which each category is blocked
+	Thresholds map[string]float64
+	// DefaultThreshold is the score from which the categories without a threshold are blocked. With a zero value
+	// they are blocked only when the moderator flags them.
+	DefaultThreshold float64
+}
+
+// Blocked returns the sorted names of the categories of the result that are blocked by the policy
+func (p *ModerationPolicy) Blocked(result *ModerationResult) (categories []string) {
+	for category, score := range result.Scores {
+		threshold, ok := p.Thresholds[category]
+		if !ok {
+			threshold = p.DefaultThreshold
+		}
+		if (threshold > 0 && score >= threshold) || (threshold == 0 && result.Categories[category]) {
+			categories = append(categories, category)
+		}
+	}
+	for category, flagged := range result.Categories {
+		if _, scored := result.Scores[category]; flagged && !scored {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return
+}
+
+// moderatedModel is a Model moderating the user text messages of the exchange before calling the model
+type moderatedModel struct {
+	Model
+	moderator Moderator
+	policy    *ModerationPolicy
+}
+
+// WithModeration wraps the model so that the text messages of the user are moderated before calling the model.
+// The exchanges with a message blocked by the policy fail with a ContentBlockedError. A nil policy blocks the
+// categories flagged by the moderator.
+func WithModeration(model Model, moderator Moderator, policy *ModerationPolicy) Model {
+	if policy == nil {
+		policy = &ModerationPolicy{}
+	}
+	return &moderatedModel{Model: model, moderator: moderator, policy: policy}
+}
+
+// Generate moderates the exchange and calls the model
+func (m *moderatedModel) Generate(exchange Exchange) (err error) {
+	if err = m.moderate(exchange); err == nil {
+		err = m.Model.Generate(exchange)
+	}
+	return
+}
+
+// GenerateStream moderates the exchange and calls the model
+func (m *moderatedModel) GenerateStream(exchange Exchange) (err error) {
+	if err = m.moderate(exchange); err == nil {
+		err = m.Model.GenerateStream(exchange)
+	}
+	return
+}
+
+// moderate checks each text message of the user in the exchange against the policy
+func (m *moderatedModel) moderate(exchange Exchange) (err error) {
+	for _, msg := range exchange.MsgsByActors(UserActor) {
+		switch msg.Mime() {
+		case ioutils.MimeTextPlain, ioutils.MimeTextHTML, ioutils.MimeMarkDown, ioutils.MimeTextYAML:
+		default:
+			continue
+		}
+		var result *ModerationResult
+		if result, err = m.moderator.Moderate(context.Background(), m.policy.Model, msg.String()); err != nil {
+			return
+		}
+		if categories := m.policy.Blocked(result); len(categories) > 0 {
+			LOGGER.WarnF("the exchange %s is blocked by the moderation for %v", exchange.Id(), categories)
+			return &ContentBlockedError{Categories: categories, Result: result}
+		}
+	}
+	return
+}
+
+// OpenAIModerator is a Moderator backed by the moderations endpoint of the OpenAI API
+type OpenAIModerator struct {
+	apiKey  string
+	baseUrl string
+	client  *client.Client
+}
+
+// NewOpenAIModerator creates a new OpenAIModerator with the api key. An empty base url selects OpenAIBaseUrl.
+func NewOpenAIModerator(apiKey, baseUrl string) *OpenAIModerator {
+	if baseUrl == "" {
+		baseUrl = OpenAIBaseUrl
+	}
+	return &OpenAIModerator{
+		apiKey:  apiKey,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		client:  client.NewClient(),
+	}
+}
+
+// Moderate checks the input with the moderations endpoint
+func (o *OpenAIModerator) Moderate(ctx context.Context, model string, input string) (result *ModerationResult, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if model == "" {
+		model = OpenAIModerationModel
+	}
+	req := o.client.NewRequest(o.baseUrl+"/moderations", http.MethodPost).
+		AddHeader("Authorization", "Bearer "+o.apiKey).
+		SetContentType(ioutils.MimeApplicationJSON).
+		SetBody(map[string]string{"model": model, "input": input})
+	var res *client.Response
+	if res, err = o.client.Execute(req); err != nil {
+		return
+	}
+	var body struct {
+		Results []*ModerationResult `json:"results"`
+	}
+	if err = res.Decode(&body); err == nil {
+		if len(body.Results) == 0 {
+			err = ErrNoResponse
+		} else {
+			result = body.Results[0]
+		}
+	}
+	return
+}
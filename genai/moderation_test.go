@@ -0,0 +1,97 @@
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+const cannedModeration = `{"id":"modr-1","model":"omni-moderation-latest","results":[{"flagged":%s,
+"categories":{"harassment":%s,"violence":false,"self-harm":false},
+"category_scores":{"harassment":%s,"violence":0.4,"self-harm":0.01}}]}`
+
+// moderationServer replies with a flagged result to the inputs containing "insult"
+func moderationServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/moderations", r.URL.Path)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		var req map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		assert.Equal(t, OpenAIModerationModel, req["model"])
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req["input"], "insult") {
+			_, _ = fmt.Fprintf(w, cannedModeration, "true", "true", "0.92")
+		} else {
+			_, _ = fmt.Fprintf(w, cannedModeration, "false", "false", "0.02")
+		}
+	}))
+}
+
+func TestOpenAIModerator(t *testing.T) {
+	server := moderationServer(t)
+	defer server.Close()
+	moderator := NewOpenAIModerator("test-key", server.URL+"/v1/")
+	result, err := moderator.Moderate(context.Background(), "", "hello")
+	assert.NoError(t, err)
+	assert.False(t, result.Flagged)
+	assert.Equal(t, 0.4, result.Scores["violence"])
+
+	result, err = moderator.Moderate(context.Background(), "", "an insult")
+	assert.NoError(t, err)
+	assert.True(t, result.Flagged)
+	assert.True(t, result.Categories["harassment"])
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = moderator.Moderate(cancelled, "", "hello")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestModerationPolicy_Blocked(t *testing.T) {
+	result := &ModerationResult{
+		Flagged:    true,
+		Categories: map[string]bool{"harassment": true, "hate": true},
+		Scores:     map[string]float64{"harassment": 0.6, "violence": 0.4, "sexual": 0.1},
+	}
+	policy := &ModerationPolicy{}
+	assert.Equal(t, []string{"harassment", "hate"}, policy.Blocked(result))
+	policy = &ModerationPolicy{Thresholds: map[string]float64{"harassment": 0.7, "violence": 0.3}}
+	assert.Equal(t, []string{"hate", "violence"}, policy.Blocked(result))
+	policy = &ModerationPolicy{Thresholds: map[string]float64{"harassment": 0.7}, DefaultThreshold: 0.05}
+	assert.Equal(t, []string{"hate", "sexual", "violence"}, policy.Blocked(result))
+}
+
+func TestWithModeration(t *testing.T) {
+	server := moderationServer(t)
+	defer server.Close()
+	model := &scriptedModel{}
+	moderated := WithModeration(model, NewOpenAIModerator("test-key", server.URL+"/v1"), nil)
+
+	exchange := NewExchange("safe")
+	_, _ = exchange.AddTxtMsg("hello", UserActor)
+	_, _ = exchange.AddTxtMsg("an insult from the assistant is not moderated", AIActor)
+	assert.NoError(t, moderated.Generate(exchange))
+	assert.Equal(t, 1, len(model.received))
+
+	exchange = NewExchange("unsafe")
+	_, _ = exchange.AddTxtMsg("an insult", UserActor)
+	err := moderated.GenerateStream(exchange)
+	var blocked *ContentBlockedError
+	assert.True(t, errors.As(err, &blocked))
+	assert.True(t, errors.Is(err, ErrContentBlocked))
+	assert.Equal(t, []string{"harassment"}, blocked.Categories)
+	assert.Equal(t, 1, len(model.received))
+
+	// the policy thresholds override the flags of the moderator
+	lenient := WithModeration(model, NewOpenAIModerator("test-key", server.URL+"/v1"),
+		&ModerationPolicy{Thresholds: map[string]float64{"harassment": 0.95}})
+	assert.NoError(t, lenient.Generate(exchange))
+	assert.Equal(t, 2, len(model.received))
+}
@@ -0,0 +1,659 @@
+package genai
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// Prompt telemetry aggregates the usage of the models without retaining the content of the exchanges. The features
+// of each exchange are extracted when it passes through the model and are folded into counts and histograms; the
+// aggregate is flushed to a sink at an interval and reset. The records are fixed structs whose text fields only hold
+// values of closed sets, the actors, the language codes, the finish reasons and the ids of the registered templates,
+// so that no content of a message can reach the sink.
+
+// FinishReasonAttribute is the attribute of the exchange holding the finish reason of the response set by the model
+const FinishReasonAttribute = "genai.finish_reason"
+
+// TelemetryFeature is a feature of the exchanges aggregated by the PromptTelemetry
+type TelemetryFeature uint
+
+const (
+	// TelemetryMessages is the histogram of the number of messages of the requests
+	TelemetryMessages TelemetryFeature = 1 << iota
+	// TelemetryTokens is the histogram of the estimated tokens of the requests and the responses per actor
+	TelemetryTokens
+	// TelemetryLanguage is the count of the requests per detected language of the user messages
+	TelemetryLanguage
+	// TelemetryContent is the count of the exchanges with images, tool calls and JSON responses
+	TelemetryContent
+	// TelemetryTemplate is the count of the requests per registered prompt template
+	TelemetryTemplate
+	// TelemetryFinishReason is the count of the responses per finish reason
+	TelemetryFinishReason
+	// TelemetryLatency is the histogram of the latency of the model in milliseconds
+	TelemetryLatency
+)
+
+// Language is the ISO 639 code of a language
+type Language string
+
+// LanguageUndetermined is the language of the requests whose language is not detected
+const LanguageUndetermined Language = "und"
+
+// FinishReason is the reason the model stopped generating a response
+type FinishReason string
+
+const (
+	// FinishStop is the finish reason of a response completed by the model
+	FinishStop FinishReason = "stop"
+	// FinishLength is the finish reason of a response truncated at the token limit
+	FinishLength FinishReason = "length"
+	// FinishToolCalls is the finish reason of a response calling tools
+	FinishToolCalls FinishReason = "tool_calls"
+	// FinishContentFilter is the finish reason of a response blocked by a content filter
+	FinishContentFilter FinishReason = "content_filter"
+	// FinishError is the finish reason of a failed generation
+	FinishError FinishReason = "error"
+	// FinishUnknown is the finish reason of a response whose reason is not set or not known
+	FinishUnknown FinishReason = "unknown"
+)
+
+// TemplateId is the id of a registered prompt template
+type TemplateId string
+
+var (
+	// telemetryActors are the actors whose tokens are aggregated
+	telemetryActors = []Actor{UserActor, SystemActor, AIActor, FunctionActor, ToolActor, AgentActor, PromptActor}
+	// finishReasons maps the finish reasons reported by the providers to the FinishReason
+	finishReasons = map[string]FinishReason{
+		"stop": FinishStop, "end_turn": FinishStop, "stop_sequence": FinishStop, "complete": FinishStop,
+		"length": FinishLength, "max_tokens": FinishLength,
+		"tool_calls": FinishToolCalls, "tool_use": FinishToolCalls, "function_call": FinishToolCalls,
+		"content_filter": FinishContentFilter, "safety": FinishContentFilter, "blocked": FinishContentFilter,
+	}
+	languageCodeRegex = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+	messageBounds = []int64{1, 2, 4, 8, 16, 32, 64}
+	tokenBounds   = []int64{16, 64, 256, 1024, 4096, 16384, 65536}
+	latencyBounds = []int64{100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+)
+
+// TelemetryHistogram counts the observed values in buckets. Counts[i] is the number of values not greater than
+// Bounds[i] and greater than the previous bound, the last count is the number of values greater than the last bound.
+type TelemetryHistogram struct {
+	// Bounds are the inclusive upper bounds of the buckets
+	Bounds []int64 `json:"bounds"`
+	// Counts are the number of values of each bucket
+	Counts []int64 `json:"counts"`
+	// Count is the number of values
+	Count int64 `json:"count"`
+	// Sum is the sum of the values
+	Sum int64 `json:"sum"`
+}
+
+// RoleTokens is the histogram of the tokens of the messages of an actor
+type RoleTokens struct {
+	// Role is the actor of the messages
+	Role Actor `json:"role"`
+	// Tokens is the histogram of the tokens of the messages of the actor per exchange
+	Tokens *TelemetryHistogram `json:"tokens"`
+}
+
+// LanguageCount is the number of requests of a language
+type LanguageCount struct {
+	Language Language `json:"language"`
+	Count    int64    `json:"count"`
+}
+
+// TemplateCount is the number of requests formatted with a template
+type TemplateCount struct {
+	Template TemplateId `json:"template"`
+	Count    int64      `json:"count"`
+}
+
+// FinishReasonCount is the number of responses of a finish reason
+type FinishReasonCount struct {
+	Reason FinishReason `json:"reason"`
+	Count  int64        `json:"count"`
+}
+
+// ContentUsage is the number of exchanges using the content types
+type ContentUsage struct {
+	// Images is the number of requests with an image
+	Images int64 `json:"images"`
+	// Tools is the number of exchanges with a function or a tool message
+	Tools int64 `json:"tools"`
+	// JSONMode is the number of responses in JSON
+	JSONMode int64 `json:"json_mode"`
+}
+
+// TelemetryRecord is the aggregate of the exchanges of a flush interval. The features disabled in the
+// TelemetryOptions are omitted.
+type TelemetryRecord struct {
+	// Start is the start of the interval
+	Start time.Time `json:"start"`
+	// End is the end of the interval
+	End time.Time `json:"end"`
+	// Requests is the number of exchanges
+	Requests int64 `json:"requests"`
+	// Errors is the number of failed generations
+	Errors int64 `json:"errors"`
+	// Messages is the histogram of the number of messages of the requests
+	Messages *TelemetryHistogram `json:"messages,omitempty"`
+	// Tokens are the histograms of the tokens per actor
+	Tokens []RoleTokens `json:"tokens,omitempty"`
+	// Languages are the number of requests per language
+	Languages []LanguageCount `json:"languages,omitempty"`
+	// Content is the usage of the content types
+	Content *ContentUsage `json:"content,omitempty"`
+	// Templates are the number of requests per template
+	Templates []TemplateCount `json:"templates,omitempty"`
+	// FinishReasons are the number of responses per finish reason
+	FinishReasons []FinishReasonCount `json:"finish_reasons,omitempty"`
+	// Latency is the histogram of the latency of the model in milliseconds
+	Latency *TelemetryHistogram `json:"latency,omitempty"`
+}
+
+// TelemetrySink receives the records of the PromptTelemetry
+type TelemetrySink interface {
+	// Emit sends the record
+	Emit(record *TelemetryRecord) error
+}
+
+// TelemetrySinkFunc is a function implementing the TelemetrySink
+type TelemetrySinkFunc func(record *TelemetryRecord) error
+
+// Emit calls the function with the record
+func (f TelemetrySinkFunc) Emit(record *TelemetryRecord) error {
+	return f(record)
+}
+
+// messagingTelemetrySink sends the records to a messaging destination
+type messagingTelemetrySink struct {
+	manager messaging.Manager
+	dest    *url.URL
+}
+
+// NewMessagingTelemetrySink creates a TelemetrySink sending each record as a JSON message to the destination
+func NewMessagingTelemetrySink(manager messaging.Manager, dest *url.URL) TelemetrySink {
+	return &messagingTelemetrySink{manager: manager, dest: dest}
+}
+
+// Emit sends the record to the destination
+func (s *messagingTelemetrySink) Emit(record *TelemetryRecord) (err error) {
+	var msg messaging.Message
+	if msg, err = s.manager.NewMessage(s.dest.Scheme); err == nil {
+		if err = msg.SetBodyObject(record, ioutils.MimeApplicationJSON); err == nil {
+			err = s.manager.Send(s.dest, msg)
+		}
+	}
+	return
+}
+
+// FileTelemetrySink appends the records to a file as newline delimited JSON
+type FileTelemetrySink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileTelemetrySink opens the file at the path, creating it if it does not exist, to append the records
+func NewFileTelemetrySink(path string) (sink *FileTelemetrySink, err error) {
+	var file *os.File
+	if file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644); err == nil {
+		sink = &FileTelemetrySink{file: file}
+	}
+	return
+}
+
+// Emit appends the record to the file
+func (s *FileTelemetrySink) Emit(record *TelemetryRecord) (err error) {
+	var data []byte
+	if data, err = json.Marshal(record); err == nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		_, err = s.file.Write(append(data, '\n'))
+	}
+	return
+}
+
+// Close closes the file
+func (s *FileTelemetrySink) Close() error {
+	return s.file.Close()
+}
+
+// TelemetryOptions configures the PromptTelemetry
+type TelemetryOptions struct {
+	// Sink receives the records
+	Sink TelemetrySink
+	// FlushInterval is the interval of the records. 0 selects one minute, a negative interval disables the periodic
+	// flush and the records are only emitted by Flush.
+	FlushInterval time.Duration
+	// Disabled are the features not aggregated
+	Disabled TelemetryFeature
+	// DetectLanguage returns the ISO 639 code of the language of the text. The default detector recognizes the
+	// scripts and the common words of a few languages. The codes not made of two or three lowercase letters are
+	// recorded as undetermined.
+	DetectLanguage func(text string) Language
+	// NoiseThreshold enables the noise on the counts of the languages, the templates, the finish reasons and the
+	// content types lower than the threshold. 0 disables the noise.
+	NoiseThreshold int64
+	// NoiseScale is the scale of the Laplace noise added to the counts. 0 selects 1.
+	NoiseScale float64
+	// Rand is the source of the noise. The default source is used if nil.
+	Rand *rand.Rand
+}
+
+// PromptTelemetry aggregates the features of the exchanges of the models it wraps
+type PromptTelemetry struct {
+	opts   TelemetryOptions
+	mutex  sync.Mutex
+	record *TelemetryRecord
+	// the categorical counts are kept in maps until the flush
+	languages map[Language]int64
+	templates map[TemplateId]int64
+	reasons   map[FinishReason]int64
+	tokens    map[Actor]*TelemetryHistogram
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewPromptTelemetry creates a PromptTelemetry emitting the records to the sink of the options
+func NewPromptTelemetry(opts TelemetryOptions) *PromptTelemetry {
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = time.Minute
+	}
+	if opts.NoiseScale <= 0 {
+		opts.NoiseScale = 1
+	}
+	if opts.DetectLanguage == nil {
+		opts.DetectLanguage = detectLanguage
+	}
+	t := &PromptTelemetry{opts: opts}
+	t.reset(time.Now())
+	if opts.FlushInterval > 0 {
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.run()
+	}
+	return t
+}
+
+// Wrap wraps the model so that the features of its exchanges are aggregated
+func (t *PromptTelemetry) Wrap(model Model) Model {
+	return &telemetryModel{Model: model, telemetry: t}
+}
+
+// Flush emits the aggregate of the exchanges since the last flush and resets it. Nothing is emitted if no exchange
+// was aggregated.
+func (t *PromptTelemetry) Flush() (err error) {
+	t.mutex.Lock()
+	record := t.snapshot(time.Now())
+	t.mutex.Unlock()
+	if record != nil && t.opts.Sink != nil {
+		err = t.opts.Sink.Emit(record)
+	}
+	return
+}
+
+// Close stops the periodic flush and flushes the aggregate
+func (t *PromptTelemetry) Close() error {
+	if t.stop != nil {
+		close(t.stop)
+		<-t.done
+		t.stop = nil
+	}
+	return t.Flush()
+}
+
+// run flushes the aggregate at the interval until the telemetry is closed
+func (t *PromptTelemetry) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				LOGGER.ErrorF("unable to emit the prompt telemetry: %v", err)
+			}
+		}
+	}
+}
+
+// enabled checks if the feature is aggregated
+func (t *PromptTelemetry) enabled(feature TelemetryFeature) bool {
+	return t.opts.Disabled&feature == 0
+}
+
+// reset starts a new aggregate
+func (t *PromptTelemetry) reset(start time.Time) {
+	t.record = &TelemetryRecord{Start: start}
+	if t.enabled(TelemetryMessages) {
+		t.record.Messages = newHistogram(messageBounds)
+	}
+	if t.enabled(TelemetryContent) {
+		t.record.Content = &ContentUsage{}
+	}
+	if t.enabled(TelemetryLatency) {
+		t.record.Latency = newHistogram(latencyBounds)
+	}
+	t.languages = map[Language]int64{}
+	t.templates = map[TemplateId]int64{}
+	t.reasons = map[FinishReason]int64{}
+	t.tokens = map[Actor]*TelemetryHistogram{}
+}
+
+// snapshot returns the aggregate ending at the time and resets it, nil if no exchange was aggregated
+func (t *PromptTelemetry) snapshot(end time.Time) (record *TelemetryRecord) {
+	if t.record.Requests == 0 {
+		return
+	}
+	record = t.record
+	record.End = end
+	for _, actor := range telemetryActors {
+		if h, ok := t.tokens[actor]; ok {
+			record.Tokens = append(record.Tokens, RoleTokens{Role: actor, Tokens: h})
+		}
+	}
+	for _, language := range sortedKeys(t.languages) {
+		if count := t.noisy(t.languages[language]); count > 0 {
+			record.Languages = append(record.Languages, LanguageCount{Language: language, Count: count})
+		}
+	}
+	for _, template := range sortedKeys(t.templates) {
+		if count := t.noisy(t.templates[template]); count > 0 {
+			record.Templates = append(record.Templates, TemplateCount{Template: template, Count: count})
+		}
+	}
+	for _, reason := range sortedKeys(t.reasons) {
+		if count := t.noisy(t.reasons[reason]); count > 0 {
+			record.FinishReasons = append(record.FinishReasons, FinishReasonCount{Reason: reason, Count: count})
+		}
+	}
+	if record.Content != nil {
+		record.Content.Images = t.noisy(record.Content.Images)
+		record.Content.Tools = t.noisy(record.Content.Tools)
+		record.Content.JSONMode = t.noisy(record.Content.JSONMode)
+	}
+	t.reset(end)
+	return
+}
+
+// noisy adds the Laplace noise to the positive counts lower than the threshold. The noisy counts are rounded and
+// never negative.
+func (t *PromptTelemetry) noisy(count int64) int64 {
+	if count <= 0 || count >= t.opts.NoiseThreshold {
+		return count
+	}
+	var u float64
+	if t.opts.Rand != nil {
+		u = t.opts.Rand.Float64()
+	} else {
+		u = rand.Float64()
+	}
+	u -= 0.5
+	noise := -t.opts.NoiseScale * math.Copysign(math.Log(1-2*math.Abs(u)), u)
+	return max(0, int64(math.Round(float64(count)+noise)))
+}
+
+// exchangeFeatures are the features of an exchange
+type exchangeFeatures struct {
+	messages int64
+	tokens   map[Actor]int64
+	language Language
+	images   bool
+	tools    bool
+	template TemplateId
+}
+
+// extract returns the features of the request. It is called before the model consumes the content of the messages.
+func (t *PromptTelemetry) extract(exchange Exchange) (f *exchangeFeatures) {
+	messages := exchange.Messages()
+	f = &exchangeFeatures{messages: int64(len(messages)), tokens: map[Actor]int64{}}
+	var text strings.Builder
+	for _, msg := range messages {
+		f.tokens[msg.Actor()] += int64(CountTokens(msg))
+		f.images = f.images || strings.HasPrefix(msg.Mime(), "image/")
+		f.tools = f.tools || msg.Actor() == FunctionActor || msg.Actor() == ToolActor
+		if msg.Actor() == UserActor && (isTextMime(msg.Mime()) || msg.Mime() == "") && t.enabled(TelemetryLanguage) {
+			text.WriteString(messageContent(msg))
+			text.WriteByte(' ')
+		}
+	}
+	if t.enabled(TelemetryLanguage) {
+		f.language = t.opts.DetectLanguage(text.String())
+		if !languageCodeRegex.MatchString(string(f.language)) {
+			f.language = LanguageUndetermined
+		}
+	}
+	if id, ok := exchange.Attributes()[TemplateAttribute].(string); ok && GetPromptTemplate(id) != nil {
+		f.template = TemplateId(id)
+	}
+	return
+}
+
+// observe aggregates the features of the exchange with its response
+func (t *PromptTelemetry) observe(f *exchangeFeatures, response []*Message, reason FinishReason, genErr error,
+	latency time.Duration) {
+	jsonMode := false
+	for _, msg := range response {
+		f.tokens[msg.Actor()] += int64(CountTokens(msg))
+		f.tools = f.tools || msg.Actor() == FunctionActor || msg.Actor() == ToolActor
+		jsonMode = jsonMode || msg.Mime() == ioutils.MimeApplicationJSON
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	r := t.record
+	r.Requests++
+	if genErr != nil {
+		r.Errors++
+	}
+	if r.Messages != nil {
+		r.Messages.observe(f.messages)
+	}
+	if t.enabled(TelemetryTokens) {
+		for actor, tokens := range f.tokens {
+			if !slices.Contains(telemetryActors, actor) {
+				continue
+			}
+			h, ok := t.tokens[actor]
+			if !ok {
+				h = newHistogram(tokenBounds)
+				t.tokens[actor] = h
+			}
+			h.observe(tokens)
+		}
+	}
+	if t.enabled(TelemetryLanguage) {
+		t.languages[f.language]++
+	}
+	if c := r.Content; c != nil {
+		c.Images += boolCount(f.images)
+		c.Tools += boolCount(f.tools)
+		c.JSONMode += boolCount(jsonMode)
+	}
+	if t.enabled(TelemetryTemplate) && f.template != "" {
+		t.templates[f.template]++
+	}
+	if t.enabled(TelemetryFinishReason) {
+		t.reasons[reason]++
+	}
+	if r.Latency != nil {
+		r.Latency.observe(latency.Milliseconds())
+	}
+}
+
+// telemetryModel is a Model aggregating the features of its exchanges
+type telemetryModel struct {
+	Model
+	telemetry *PromptTelemetry
+}
+
+// Generate calls the model and aggregates the features of the exchange
+func (m *telemetryModel) Generate(exchange Exchange) error {
+	return m.generate(exchange, m.Model.Generate)
+}
+
+// GenerateStream calls the model and aggregates the features of the exchange once the response is complete
+func (m *telemetryModel) GenerateStream(exchange Exchange) error {
+	return m.generate(exchange, m.Model.GenerateStream)
+}
+
+// generate extracts the features of the request, calls the model and aggregates the features with the response
+func (m *telemetryModel) generate(exchange Exchange, generate func(Exchange) error) (err error) {
+	features := m.telemetry.extract(exchange)
+	start := time.Now()
+	err = generate(exchange)
+	latency := time.Since(start)
+	var response []*Message
+	if messages := exchange.Messages(); len(messages) > int(features.messages) {
+		response = messages[features.messages:]
+	}
+	reason := FinishError
+	if err == nil {
+		reason = finishReasonOf(exchange, response)
+	}
+	m.telemetry.observe(features, response, reason, err, latency)
+	return
+}
+
+// finishReasonOf returns the finish reason of the response set to the FinishReasonAttribute, the tool calls if the
+// response calls tools and the unknown reason otherwise
+func finishReasonOf(exchange Exchange, response []*Message) FinishReason {
+	var value string
+	switch v := exchange.Attributes()[FinishReasonAttribute].(type) {
+	case FinishReason:
+		value = string(v)
+	case string:
+		value = v
+	}
+	if reason, ok := finishReasons[strings.ToLower(value)]; ok {
+		return reason
+	}
+	if value == "" {
+		for _, msg := range response {
+			if msg.Actor() == FunctionActor || msg.Actor() == ToolActor {
+				return FinishToolCalls
+			}
+		}
+	}
+	return FinishUnknown
+}
+
+func newHistogram(bounds []int64) *TelemetryHistogram {
+	return &TelemetryHistogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+}
+
+// observe adds the value to the histogram
+func (h *TelemetryHistogram) observe(v int64) {
+	h.Counts[sort.Search(len(h.Bounds), func(i int) bool { return h.Bounds[i] >= v })]++
+	h.Count++
+	h.Sum += v
+}
+
+func boolCount(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[K ~string](m map[K]int64) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+var (
+	// languageScripts are the languages detected by the script of the letters
+	languageScripts = []struct {
+		table    *unicode.RangeTable
+		language Language
+	}{
+		{unicode.Hiragana, "ja"}, {unicode.Katakana, "ja"}, {unicode.Hangul, "ko"}, {unicode.Han, "zh"},
+		{unicode.Cyrillic, "ru"}, {unicode.Arabic, "ar"}, {unicode.Hebrew, "he"}, {unicode.Greek, "el"},
+		{unicode.Devanagari, "hi"}, {unicode.Thai, "th"},
+	}
+	// languageWords are the common words of the languages using the latin script
+	languageWords = map[string][]Language{
+		"the": {"en"}, "and": {"en"}, "is": {"en"}, "of": {"en"}, "to": {"en"}, "what": {"en"}, "how": {"en"},
+		"you": {"en"}, "this": {"en"}, "with": {"en"},
+		"el": {"es"}, "los": {"es"}, "las": {"es"}, "es": {"es"}, "y": {"es"}, "por": {"es"}, "qué": {"es"},
+		"cómo": {"es"}, "una": {"es", "it"}, "para": {"es", "pt"}, "de": {"es", "fr", "pt", "nl"},
+		"le": {"fr"}, "les": {"fr"}, "et": {"fr"}, "est": {"fr"}, "des": {"fr"}, "une": {"fr"}, "pour": {"fr"},
+		"vous": {"fr"}, "la": {"es", "fr", "it"},
+		"der": {"de"}, "die": {"de"}, "und": {"de"}, "ist": {"de"}, "nicht": {"de"}, "ein": {"de"}, "ich": {"de"},
+		"wie": {"de"}, "das": {"de"},
+		"il": {"it"}, "di": {"it"}, "che": {"it"}, "è": {"it"}, "non": {"it"}, "per": {"it"},
+		"o": {"pt"}, "os": {"pt"}, "não": {"pt"}, "um": {"pt"}, "é": {"pt"}, "você": {"pt"}, "como": {"es", "pt"},
+		"het": {"nl"}, "een": {"nl"}, "en": {"nl"}, "niet": {"nl"}, "ik": {"nl"}, "wat": {"nl"}, "zijn": {"nl"},
+	}
+)
+
+// detectLanguage detects the language of the text by the script of its letters and, for the latin script, by its
+// common words. The undetermined language is returned if neither is conclusive.
+func detectLanguage(text string) Language {
+	scripts := map[Language]int{}
+	latin := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range languageScripts {
+			if unicode.Is(s.table, r) {
+				scripts[s.language]++
+				break
+			}
+		}
+	}
+	best, count := LanguageUndetermined, latin
+	for _, s := range languageScripts {
+		if n := scripts[s.language]; n > count {
+			best, count = s.language, n
+		}
+	}
+	// the kana are written along with the han
+	if best == "zh" && scripts["ja"] > 0 {
+		best = "ja"
+	}
+	if best != LanguageUndetermined || latin == 0 {
+		return best
+	}
+	words := map[Language]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for _, language := range languageWords[word] {
+			words[language]++
+		}
+	}
+	count = 0
+	for _, language := range []Language{"en", "es", "fr", "de", "it", "pt", "nl"} {
+		if words[language] > count {
+			best, count = language, words[language]
+		}
+	}
+	return best
+}
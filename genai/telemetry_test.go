@@ -0,0 +1,301 @@
+package genai
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// recordingSink is a TelemetrySink keeping the records emitted
+type recordingSink struct {
+	records []*TelemetryRecord
+}
+
+func (s *recordingSink) Emit(record *TelemetryRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func newTelemetry(opts TelemetryOptions) (*PromptTelemetry, *recordingSink) {
+	sink := &recordingSink{}
+	opts.Sink = sink
+	opts.FlushInterval = -1
+	return NewPromptTelemetry(opts), sink
+}
+
+func TestPromptTelemetry_Features(t *testing.T) {
+	tmpl, err := GetOrCreatePrompt("telemetry-greeting", "Hello {{.name}}, what is the weather today?")
+	assert.NoError(t, err)
+	telemetry, sink := newTelemetry(TelemetryOptions{})
+	model := telemetry.Wrap(&scriptedModel{replies: []func(exchange Exchange) error{
+		func(exchange Exchange) error {
+			exchange.Attributes()[FinishReasonAttribute] = "end_turn"
+			_, err := exchange.AddJsonMsg(map[string]string{"forecast": "sunny"}, AIActor)
+			return err
+		},
+		func(exchange Exchange) error {
+			_, err := exchange.AddTxtMsg("get_weather(paris)", ToolActor)
+			return err
+		},
+		func(exchange Exchange) error {
+			return errors.New("unavailable")
+		},
+	}})
+
+	first := NewExchange("1")
+	_, _ = first.AddTxtMsg("You are a forecaster", SystemActor)
+	_, err = AddTemplateMsg(first, tmpl, map[string]any{"name": "Ana"}, UserActor)
+	assert.NoError(t, err)
+	assert.Equal(t, "telemetry-greeting", first.Attributes()[TemplateAttribute])
+	assert.NoError(t, model.Generate(first))
+
+	second := NewExchange("2")
+	_, _ = second.AddTxtMsg("Quel temps fait-il à Paris et est-ce que le soleil est là pour les vacances ?", UserActor)
+	_, _ = second.AddBinMsg([]byte{0x89, 'P', 'N', 'G'}, "image/png", UserActor)
+	// the ids of the unregistered templates are not recorded
+	second.Attributes()[TemplateAttribute] = "My secret prompt"
+	assert.NoError(t, model.GenerateStream(second))
+
+	third := NewExchange("3")
+	_, _ = third.AddTxtMsg("Какая погода в Москве?", UserActor)
+	assert.Error(t, model.Generate(third))
+
+	assert.NoError(t, telemetry.Flush())
+	assert.Equal(t, 1, len(sink.records))
+	r := sink.records[0]
+	assert.Equal(t, int64(3), r.Requests)
+	assert.Equal(t, int64(1), r.Errors)
+	assert.Equal(t, []int64{1, 2, 0, 0, 0, 0, 0, 0}, r.Messages.Counts)
+	assert.Equal(t, int64(5), r.Messages.Sum)
+	assert.Equal(t, []LanguageCount{{"en", 1}, {"fr", 1}, {"ru", 1}}, r.Languages)
+	assert.Equal(t, &ContentUsage{Images: 1, Tools: 1, JSONMode: 1}, r.Content)
+	assert.Equal(t, []TemplateCount{{"telemetry-greeting", 1}}, r.Templates)
+	assert.Equal(t, []FinishReasonCount{{FinishError, 1}, {FinishStop, 1}, {FinishToolCalls, 1}}, r.FinishReasons)
+	assert.Equal(t, int64(3), r.Latency.Count)
+	assert.Equal(t, int64(3), r.Latency.Counts[0])
+
+	roles := map[Actor]*TelemetryHistogram{}
+	for _, rt := range r.Tokens {
+		roles[rt.Role] = rt.Tokens
+	}
+	assert.Equal(t, 4, len(roles))
+	assert.Equal(t, int64(3), roles[UserActor].Count)
+	assert.Equal(t, int64(1), roles[SystemActor].Count)
+	assert.Equal(t, int64(1), roles[AIActor].Count)
+	assert.Equal(t, int64(1), roles[ToolActor].Count)
+
+	// the record holds none of the content
+	data, err := json.Marshal(r)
+	assert.NoError(t, err)
+	for _, content := range []string{"forecaster", "Ana", "Paris", "secret", "sunny", "paris", "Москве"} {
+		assert.False(t, strings.Contains(string(data), content))
+	}
+}
+
+// TestTelemetryRecord_NoFreeText checks that every text of the records has a type of the allow list
+func TestTelemetryRecord_NoFreeText(t *testing.T) {
+	allowed := map[reflect.Type]bool{
+		reflect.TypeOf(Actor("")):        true,
+		reflect.TypeOf(Language("")):     true,
+		reflect.TypeOf(FinishReason("")): true,
+		reflect.TypeOf(TemplateId("")):   true,
+	}
+	visited := map[reflect.Type]bool{}
+	var check func(typ reflect.Type, path string)
+	check = func(typ reflect.Type, path string) {
+		if visited[typ] {
+			return
+		}
+		visited[typ] = true
+		switch typ.Kind() {
+		case reflect.String:
+			if !allowed[typ] {
+				t.Errorf("%s is a free text of type %s", path, typ)
+			}
+		case reflect.Map, reflect.Interface, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			t.Errorf("%s has the type %s", path, typ)
+		case reflect.Pointer, reflect.Slice, reflect.Array:
+			check(typ.Elem(), path+"[]")
+		case reflect.Struct:
+			if typ == reflect.TypeOf(time.Time{}) {
+				return
+			}
+			for i := 0; i < typ.NumField(); i++ {
+				field := typ.Field(i)
+				if !field.IsExported() {
+					t.Errorf("%s.%s is not exported", path, field.Name)
+				}
+				check(field.Type, path+"."+field.Name)
+			}
+		}
+	}
+	check(reflect.TypeOf(TelemetryRecord{}), "TelemetryRecord")
+}
+
+func TestPromptTelemetry_Flush(t *testing.T) {
+	telemetry, sink := newTelemetry(TelemetryOptions{})
+	model := telemetry.Wrap(&scriptedModel{})
+	// the empty intervals are not emitted
+	assert.NoError(t, telemetry.Flush())
+	assert.Equal(t, 0, len(sink.records))
+
+	for i := 0; i < 3; i++ {
+		exchange := NewExchange("batch")
+		_, _ = exchange.AddTxtMsg("how is the weather", UserActor)
+		assert.NoError(t, model.Generate(exchange))
+	}
+	assert.NoError(t, telemetry.Flush())
+	exchange := NewExchange("next")
+	_, _ = exchange.AddTxtMsg("how is the weather", UserActor)
+	assert.NoError(t, model.Generate(exchange))
+	assert.NoError(t, telemetry.Close())
+
+	assert.Equal(t, 2, len(sink.records))
+	assert.Equal(t, int64(3), sink.records[0].Requests)
+	assert.Equal(t, []FinishReasonCount{{FinishUnknown, 3}}, sink.records[0].FinishReasons)
+	assert.Equal(t, int64(1), sink.records[1].Requests)
+	assert.Equal(t, []LanguageCount{{"en", 1}}, sink.records[1].Languages)
+	assert.False(t, sink.records[0].End.After(sink.records[1].Start))
+}
+
+func TestPromptTelemetry_Interval(t *testing.T) {
+	records := make(chan *TelemetryRecord, 4)
+	telemetry := NewPromptTelemetry(TelemetryOptions{
+		FlushInterval: 10 * time.Millisecond,
+		Sink: TelemetrySinkFunc(func(record *TelemetryRecord) error {
+			records <- record
+			return nil
+		}),
+	})
+	defer telemetry.Close()
+	exchange := NewExchange("tick")
+	_, _ = exchange.AddTxtMsg("hello", UserActor)
+	assert.NoError(t, telemetry.Wrap(&scriptedModel{}).Generate(exchange))
+	select {
+	case record := <-records:
+		assert.Equal(t, int64(1), record.Requests)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the record was not flushed")
+	}
+}
+
+func TestPromptTelemetry_DisabledFeatures(t *testing.T) {
+	fields := map[TelemetryFeature]string{
+		TelemetryMessages:     "messages",
+		TelemetryTokens:       "tokens",
+		TelemetryLanguage:     "languages",
+		TelemetryContent:      "content",
+		TelemetryTemplate:     "templates",
+		TelemetryFinishReason: "finish_reasons",
+		TelemetryLatency:      "latency",
+	}
+	_, err := GetOrCreatePrompt("telemetry-disabled", "the weather of {{.city}}")
+	assert.NoError(t, err)
+	for feature, field := range fields {
+		t.Run(field, func(t *testing.T) {
+			telemetry, sink := newTelemetry(TelemetryOptions{Disabled: feature})
+			exchange := NewExchange("disabled")
+			_, _ = exchange.AddTxtMsg("what is the weather", UserActor)
+			exchange.Attributes()[TemplateAttribute] = "telemetry-disabled"
+			assert.NoError(t, telemetry.Wrap(&scriptedModel{}).Generate(exchange))
+			assert.NoError(t, telemetry.Flush())
+
+			var record map[string]any
+			data, _ := json.Marshal(sink.records[0])
+			assert.NoError(t, json.Unmarshal(data, &record))
+			for other, name := range fields {
+				_, ok := record[name]
+				assert.Equal(t, other != feature, ok)
+			}
+		})
+	}
+}
+
+func TestPromptTelemetry_Noise(t *testing.T) {
+	telemetry, sink := newTelemetry(TelemetryOptions{
+		NoiseThreshold: 5,
+		NoiseScale:     2,
+		Rand:           rand.New(rand.NewPCG(1, 2)),
+		DetectLanguage: func(text string) Language {
+			if strings.Contains(text, "rare") {
+				return "fr"
+			}
+			return "en"
+		},
+	})
+	model := telemetry.Wrap(&scriptedModel{})
+	changed := false
+	for i := 0; i < 50; i++ {
+		for _, text := range []string{"rare", "common", "common", "common", "common", "common", "common"} {
+			exchange := NewExchange("noise")
+			_, _ = exchange.AddTxtMsg(text, UserActor)
+			assert.NoError(t, model.Generate(exchange))
+		}
+		assert.NoError(t, telemetry.Flush())
+		r := sink.records[len(sink.records)-1]
+		// the counts above the threshold are exact
+		assert.Equal(t, LanguageCount{"en", 6}, r.Languages[0])
+		if len(r.Languages) == 1 {
+			// the noisy count rounded to 0 is dropped
+			changed = true
+			continue
+		}
+		assert.True(t, r.Languages[1].Count > 0)
+		changed = changed || r.Languages[1].Count != 1
+	}
+	assert.True(t, changed)
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for text, want := range map[string]Language{
+		"What is the capital of France?":            "en",
+		"¿Cuál es el clima para los próximos días?": "es",
+		"Wie ist das Wetter und was ist nicht gut?": "de",
+		"今日の天気はどうですか":                               "ja",
+		"今天天气怎么样":                                   "zh",
+		"오늘 날씨 어때요":                                 "ko",
+		"Какая сегодня погода?":                     "ru",
+		"1234 ???":    LanguageUndetermined,
+		"xyzzy plugh": LanguageUndetermined,
+	} {
+		assert.Equal(t, want, detectLanguage(text))
+	}
+	// the invalid codes of a detector are undetermined
+	telemetry, sink := newTelemetry(TelemetryOptions{DetectLanguage: func(string) Language { return "Hello world" }})
+	exchange := NewExchange("invalid")
+	_, _ = exchange.AddTxtMsg("Hello world", UserActor)
+	assert.NoError(t, telemetry.Wrap(&scriptedModel{}).Generate(exchange))
+	assert.NoError(t, telemetry.Flush())
+	assert.Equal(t, []LanguageCount{{LanguageUndetermined, 1}}, sink.records[0].Languages)
+}
+
+func TestFileTelemetrySink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.ndjson")
+	sink, err := NewFileTelemetrySink(path)
+	assert.NoError(t, err)
+	for i := int64(1); i <= 2; i++ {
+		assert.NoError(t, sink.Emit(&TelemetryRecord{Requests: i, Content: &ContentUsage{Images: i}}))
+	}
+	assert.NoError(t, sink.Close())
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var requests []int64
+	for scanner.Scan() {
+		var record TelemetryRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		requests = append(requests, record.Requests)
+	}
+	assert.Equal(t, []int64{1, 2}, requests)
+}
@@ -23,6 +23,9 @@ const (
 	GoTextTemplate TemplateType = "go-text"
 )
 
+// TemplateAttribute is the attribute of the exchange holding the id of the prompt template set by AddTemplateMsg
+const TemplateAttribute = "genai.prompt_template"
+
 // PromptTemplate is the interface that represents a prompt template
 type PromptTemplate interface {
 	// Id returns the id of the template. This is expected to be unique.
@@ -131,6 +134,20 @@ func GetOrCreatePrompt(id, content string) (PromptTemplate, error) {
 
 }
 
+// AddTemplateMsg formats the template with the data and adds it to the exchange as a text message of the actor. The
+// id of the template is recorded to the TemplateAttribute of the exchange so that the middlewares can attribute the
+// exchange to the template without reading its content.
+func AddTemplateMsg(exchange Exchange, tmpl PromptTemplate, data map[string]any, actor Actor) (message *Message,
+	err error) {
+	var text string
+	if text, err = tmpl.FormatAsText(data); err == nil {
+		if message, err = exchange.AddTxtMsg(text, actor); err == nil {
+			exchange.Attributes()[TemplateAttribute] = tmpl.Id()
+		}
+	}
+	return
+}
+
 // init initializes the cache
 func init() {
 	cacheMutex = sync.RWMutex{}
@@ -0,0 +1,191 @@
+package genai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// messageOverhead is the number of tokens added to every message for the role and the formatting
+	messageOverhead = 4
+	// toolOverhead is the number of tokens added to the function and the tool messages for the call framing
+	toolOverhead = 8
+	// binaryTokens is the number of tokens counted for a binary part such as an image
+	binaryTokens = 256
+)
+
+var ErrUnknownModel = errors.New("the limits of the model are unknown")
+var ErrContextExceeded = errors.New("the messages exceed the context window of the model")
+
+// ModelLimits holds the token limits of a model
+type ModelLimits struct {
+	// ContextWindow is the number of tokens of the input and the output together
+	ContextWindow int
+	// MaxOutput is the maximum number of tokens generated. 0 if the model has no separate output limit.
+	MaxOutput int
+	// Counter counts the tokens of a message for the model. CountTokens is used if nil.
+	Counter TokenCounter
+	// ImageTokens counts the tokens of an image from its dimensions, following the formula documented by the
+	// provider. The images count as the other binary content if nil.
+	ImageTokens ImageTokenFunc
+}
+
+var limitsMutex sync.RWMutex
+
+// modelLimits maps the model names to their limits. The names are matched as prefixes so that the dated and the
+// tagged variants of a model share the limits of the model.
+var modelLimits = map[string]ModelLimits{
+	"gpt-3.5-turbo":     {ContextWindow: 16385, MaxOutput: 4096},
+	"gpt-4":             {ContextWindow: 8192, MaxOutput: 8192},
+	"gpt-4-turbo":       {ContextWindow: 128000, MaxOutput: 4096, ImageTokens: OpenAIImageTokens},
+	"gpt-4o":            {ContextWindow: 128000, MaxOutput: 16384, ImageTokens: OpenAIImageTokens},
+	"gpt-4o-mini":       {ContextWindow: 128000, MaxOutput: 16384, ImageTokens: OpenAIImageTokens},
+	"gpt-4.1":           {ContextWindow: 1047576, MaxOutput: 32768, ImageTokens: OpenAIImageTokens},
+	"o1":                {ContextWindow: 200000, MaxOutput: 100000, ImageTokens: OpenAIImageTokens},
+	"o3":                {ContextWindow: 200000, MaxOutput: 100000, ImageTokens: OpenAIImageTokens},
+	"o4-mini":           {ContextWindow: 200000, MaxOutput: 100000, ImageTokens: OpenAIImageTokens},
+	"claude-3-haiku":    {ContextWindow: 200000, MaxOutput: 4096, ImageTokens: AnthropicImageTokens},
+	"claude-3-opus":     {ContextWindow: 200000, MaxOutput: 4096, ImageTokens: AnthropicImageTokens},
+	"claude-3-5-haiku":  {ContextWindow: 200000, MaxOutput: 8192, ImageTokens: AnthropicImageTokens},
+	"claude-3-5-sonnet": {ContextWindow: 200000, MaxOutput: 8192, ImageTokens: AnthropicImageTokens},
+	"claude-3-7-sonnet": {ContextWindow: 200000, MaxOutput: 64000, ImageTokens: AnthropicImageTokens},
+	"claude-sonnet-4":   {ContextWindow: 200000, MaxOutput: 64000, ImageTokens: AnthropicImageTokens},
+	"claude-opus-4":     {ContextWindow: 200000, MaxOutput: 32000, ImageTokens: AnthropicImageTokens},
+	"llama2":            {ContextWindow: 4096},
+	"llama3":            {ContextWindow: 8192},
+	"llama3.1":          {ContextWindow: 131072},
+	"llama3.2":          {ContextWindow: 131072},
+	"llama3.3":          {ContextWindow: 131072},
+	"mistral":           {ContextWindow: 32768},
+	"gemini-1.5-flash":  {ContextWindow: 1048576, MaxOutput: 8192, ImageTokens: GeminiImageTokens},
+	"gemini-1.5-pro":    {ContextWindow: 2097152, MaxOutput: 8192, ImageTokens: GeminiImageTokens},
+}
+
+// RegisterModelLimits registers the limits of the model, replacing the existing ones.
+// The name is matched as a prefix of the model names, the longest registered prefix wins.
+func RegisterModelLimits(name string, limits ModelLimits) {
+	limitsMutex.Lock()
+	defer limitsMutex.Unlock()
+	modelLimits[name] = limits
+}
+
+// GetModelLimits returns the limits registered for the longest prefix of the model name
+func GetModelLimits(model string) (limits ModelLimits, ok bool) {
+	limitsMutex.RLock()
+	defer limitsMutex.RUnlock()
+	matched := -1
+	for name, l := range modelLimits {
+		if strings.HasPrefix(model, name) && len(name) > matched {
+			limits, matched, ok = l, len(name), true
+		}
+	}
+	return
+}
+
+// CountTokens estimates the number of tokens of a message. The text is counted as one token for every four bytes,
+// or every three bytes for JSON and the function and tool messages as they tokenize denser. Every message adds an
+// overhead for the role, the function and the tool messages a further overhead for the call framing, and the binary
+// content counts as a fixed number of tokens.
+func CountTokens(msg *Message) int {
+	count := messageOverhead
+	tool := msg.Actor() == FunctionActor || msg.Actor() == ToolActor
+	if tool {
+		count += toolOverhead
+	}
+	mime := msg.Mime()
+	switch {
+	case msg.URL() != nil && !isTextMime(mime):
+		count += binaryTokens
+	case isTextMime(mime) || mime == "":
+		n := len(messageContent(msg))
+		if tool || strings.Contains(mime, "json") {
+			count += (n + 2) / 3
+		} else {
+			count += (n + 3) / 4
+		}
+	default:
+		count += binaryTokens
+	}
+	return count
+}
+
+// FitsContext checks if the messages fit in the context window of the model along with the MaxTokens of the options.
+// It returns the estimated number of tokens of the messages, counted with the Counter of the model limits.
+// ErrUnknownModel is returned if no limits are registered for the model.
+func FitsContext(model string, msgs []*Message, opts *Options) (fits bool, tokens int, err error) {
+	limits, ok := GetModelLimits(model)
+	if !ok {
+		err = fmt.Errorf("%w: %s", ErrUnknownModel, model)
+		return
+	}
+	counter := limits.Counter
+	if counter == nil {
+		counter = CountTokens
+	}
+	for _, msg := range msgs {
+		tokens += counter(msg)
+	}
+	output := 0
+	if opts != nil {
+		output = opts.MaxTokens
+	}
+	if limits.MaxOutput > 0 && output > limits.MaxOutput {
+		err = fmt.Errorf("%w: %d output tokens requested, %s generates at most %d", ErrContextExceeded, output,
+			model, limits.MaxOutput)
+		return
+	}
+	fits = tokens+output <= limits.ContextWindow
+	return
+}
+
+// contextGuard is a Model checking that the exchange fits in the context window before calling the model
+type contextGuard struct {
+	Model
+	opts *Options
+}
+
+// GuardContext wraps the model so that the exchanges exceeding its context window, along with the MaxTokens of the
+// options, are rejected with a ContextOverflowError, wrapping ErrContextExceeded, before calling the model. The limits
+// are looked up with the name of the model; the exchanges of the models without registered limits are passed through.
+func GuardContext(model Model, opts *Options) Model {
+	return &contextGuard{Model: model, opts: opts}
+}
+
+// Generate checks the exchange and calls the model
+func (g *contextGuard) Generate(exchange Exchange) (err error) {
+	if err = g.check(exchange); err == nil {
+		err = g.Model.Generate(exchange)
+	}
+	return
+}
+
+// GenerateStream checks the exchange and calls the model
+func (g *contextGuard) GenerateStream(exchange Exchange) (err error) {
+	if err = g.check(exchange); err == nil {
+		err = g.Model.GenerateStream(exchange)
+	}
+	return
+}
+
+// check returns a ContextOverflowError with the breakdown of the tokens if the exchange does not fit in the context
+// window
+func (g *contextGuard) check(exchange Exchange) (err error) {
+	name := g.Name()
+	report, err := AnalyzeFit(name, nil, exchange.Messages(), g.opts)
+	if errors.Is(err, ErrUnknownModel) {
+		LOGGER.DebugF("skipping the context check of the unknown model %s", name)
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	if limits, _ := GetModelLimits(name); limits.MaxOutput > 0 && report.OutputTokens > limits.MaxOutput {
+		err = fmt.Errorf("%w: %d output tokens requested, %s generates at most %d", ErrContextExceeded,
+			report.OutputTokens, name, limits.MaxOutput)
+	} else if !report.DoesFit {
+		err = &ContextOverflowError{Report: report}
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package genai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestCountTokens(t *testing.T) {
+	exchange := NewExchange("tokens")
+	text, _ := exchange.AddTxtMsg(strings.Repeat("a", 400), UserActor)
+	call, _ := exchange.AddJsonMsg(map[string]string{"query": strings.Repeat("b", 285)}, FunctionActor)
+	result, _ := exchange.AddTxtMsg(strings.Repeat("c", 300), ToolActor)
+	image, _ := exchange.AddBinMsg(make([]byte, 100000), "image/png", UserActor)
+	file, _ := exchange.AddFileMsg("file:///tmp/report.pdf", "application/pdf", UserActor)
+
+	assert.Equal(t, 104, CountTokens(text))
+	// the json of the function call is 300 bytes counted at three bytes a token
+	assert.Equal(t, 112, CountTokens(call))
+	assert.Equal(t, 112, CountTokens(result))
+	// the binary parts count the same regardless of their size
+	assert.Equal(t, 260, CountTokens(image))
+	assert.Equal(t, 260, CountTokens(file))
+	// counting does not consume the messages
+	assert.Equal(t, strings.Repeat("a", 400), text.String())
+}
+
+func TestFitsContext(t *testing.T) {
+	RegisterModelLimits("test-model", ModelLimits{ContextWindow: 300, MaxOutput: 100})
+	RegisterModelLimits("test-model-large", ModelLimits{
+		ContextWindow: 1000,
+		Counter:       func(msg *Message) int { return 10 },
+	})
+	msgs := []*Message{
+		newTextMessage(strings.Repeat("a", 400), UserActor),
+		newTextMessage(strings.Repeat("b", 400), AIActor),
+	}
+	fits, tokens, err := FitsContext("test-model-v2", msgs, nil)
+	assert.NoError(t, err)
+	assert.True(t, fits)
+	assert.Equal(t, 208, tokens)
+	fits, _, err = FitsContext("test-model-v2", msgs, (&Options{}).SetMaxTokens(100))
+	assert.NoError(t, err)
+	assert.False(t, fits)
+	_, _, err = FitsContext("test-model", msgs, (&Options{}).SetMaxTokens(200))
+	assert.True(t, errors.Is(err, ErrContextExceeded))
+
+	// the longest prefix wins with its counter
+	fits, tokens, err = FitsContext("test-model-large:latest", msgs, nil)
+	assert.NoError(t, err)
+	assert.True(t, fits)
+	assert.Equal(t, 20, tokens)
+
+	_, _, err = FitsContext("unknown-model", msgs, nil)
+	assert.True(t, errors.Is(err, ErrUnknownModel))
+	limits, ok := GetModelLimits("gpt-4o-mini-2024-07-18")
+	assert.True(t, ok)
+	assert.Equal(t, 128000, limits.ContextWindow)
+	limits, _ = GetModelLimits("llama3.1:8b")
+	assert.Equal(t, 131072, limits.ContextWindow)
+}
+
+func TestGuardContext(t *testing.T) {
+	RegisterModelLimits("guarded-model", ModelLimits{ContextWindow: 100})
+	model := &scriptedModel{AbstractModel: AbstractModel{name: "guarded-model"}}
+	guarded := GuardContext(model, nil)
+
+	exchange := NewExchange("small")
+	_, _ = exchange.AddTxtMsg("hello", UserActor)
+	assert.NoError(t, guarded.Generate(exchange))
+	assert.Equal(t, 1, len(model.received))
+
+	exchange = NewExchange("large")
+	_, _ = exchange.AddTxtMsg(strings.Repeat("a", 1000), UserActor)
+	err := guarded.GenerateStream(exchange)
+	assert.True(t, errors.Is(err, ErrContextExceeded))
+	assert.True(t, strings.Contains(err.Error(), "about 254 tokens in 1 messages"))
+	assert.Equal(t, 1, len(model.received))
+
+	// models without limits are not checked
+	unknown := GuardContext(&scriptedModel{AbstractModel: AbstractModel{name: "unknown"}}, nil)
+	assert.NoError(t, unknown.Generate(exchange))
+}
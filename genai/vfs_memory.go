@@ -0,0 +1,648 @@
+package genai
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/codec"
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/uuid"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+const (
+	vfsMemoryType      = "vfs"
+	vfsMemoryId        = "vfs-memory"
+	sessionFileExt     = ".ndjson"
+	sessionTmpSuffix   = ".tmp-"
+	defaultSessionPage = 100
+)
+
+var ErrEncryptorRequired = errors.New("an encryptor is required for the encryption mode")
+var ErrCorruptSession = errors.New("corrupt session file")
+
+// EncryptionMode selects what the VFSMemory encrypts
+type EncryptionMode int
+
+const (
+	// EncryptNone stores the sessions in plain text
+	EncryptNone EncryptionMode = iota
+	// EncryptFields encrypts the content of the messages and the attributes of the exchanges. The ids, actors, mime
+	// types and times stay readable.
+	EncryptFields
+	// EncryptFile encrypts every line of the session file as a whole
+	EncryptFile
+)
+
+// VFSMemoryOptions are the options of the VFSMemory
+type VFSMemoryOptions struct {
+	// Id of the memory. Defaults to vfs-memory.
+	Id string
+	// Encryption mode of the sessions
+	Encryption EncryptionMode
+	// Encryptor encrypts the sessions. secrets.FieldKeyring can be used. Required unless the Encryption is EncryptNone.
+	Encryptor codec.FieldEncryptor
+	// MaxMessages is the maximum number of messages kept per session. The oldest exchanges are dropped when a new
+	// exchange exceeds it. The latest exchange is always kept. No limit if 0.
+	MaxMessages int
+	// MaxAge is the time after the last exchange of a session after which Purge removes the session. No limit if 0.
+	MaxAge time.Duration
+	// Anonymize makes Purge erase the content and the attributes of the expired sessions instead of deleting them
+	Anonymize bool
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// SessionInfo is the metadata of a session stored in the VFSMemory
+type SessionInfo struct {
+	Id         string
+	Created    time.Time
+	Updated    time.Time
+	Exchanges  int
+	Messages   int
+	Anonymized bool
+}
+
+// SessionQuery filters and pages the sessions listed by the VFSMemory
+type SessionQuery struct {
+	// CreatedAfter lists the sessions created at or after the time if set
+	CreatedAfter time.Time
+	// CreatedBefore lists the sessions created before the time if set
+	CreatedBefore time.Time
+	// MinMessages lists the sessions with at least the number of messages
+	MinMessages int
+	// MaxMessages lists the sessions with at most the number of messages if greater than 0
+	MaxMessages int
+	// PageSize is the maximum number of sessions returned. Defaults to 100.
+	PageSize int
+	// PageToken is the token of the next page returned by the previous List
+	PageToken string
+}
+
+// PurgeReport lists the sessions removed by Purge
+type PurgeReport struct {
+	Deleted    []string
+	Anonymized []string
+}
+
+// VFSMemory is a Memory storing the sessions on any file system of the vfs.Manager. Every session is a NDJSON file
+// with one line per exchange at <base>/<shard>/<encoded session id>.ndjson. The lines with the same exchange id
+// are read as one exchange, the last line replacing the earlier ones in place.
+//
+// The files are written to a temporary file that is moved over the session file, so a session file is never left
+// partially written by the VFSMemory. A trailing partial line written otherwise is dropped on read. The writes of a
+// session are serialized within the process only.
+type VFSMemory struct {
+	manager vfs.Manager
+	base    *url.URL
+	opts    VFSMemoryOptions
+	locks   sync.Map
+}
+
+// storedMessage is a message as stored in the session file
+type storedMessage struct {
+	Actor Actor  `json:"actor"`
+	Mime  string `json:"mime"`
+	Url   string `json:"url,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+	Enc   string `json:"enc,omitempty"`
+}
+
+// storedExchange is a line of the session file
+type storedExchange struct {
+	Id            string           `json:"id"`
+	Time          time.Time        `json:"time"`
+	Attributes    map[string]any   `json:"attributes,omitempty"`
+	AttributesEnc string           `json:"attributes_enc,omitempty"`
+	Messages      []*storedMessage `json:"messages"`
+	Anonymized    bool             `json:"anonymized,omitempty"`
+}
+
+// NewVFSMemory creates a new VFSMemory storing the sessions under the base url
+func NewVFSMemory(manager vfs.Manager, baseURL string, opts VFSMemoryOptions) (m *VFSMemory, err error) {
+	if opts.Encryption != EncryptNone && opts.Encryptor == nil {
+		err = ErrEncryptorRequired
+		return
+	}
+	var base *url.URL
+	if base, err = url.Parse(strings.TrimSuffix(baseURL, "/")); err != nil {
+		return
+	}
+	var dir vfs.VFile
+	if dir, err = manager.MkdirAll(base); err != nil {
+		return
+	}
+	ioutils.CloserFunc(dir)
+	if opts.Id == "" {
+		opts.Id = vfsMemoryId
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	m = &VFSMemory{manager: manager, base: base, opts: opts}
+	return
+}
+
+// Id returns the id of the memory
+func (m *VFSMemory) Id() string {
+	return m.opts.Id
+}
+
+// Type returns the type of the memory
+func (m *VFSMemory) Type() string {
+	return vfsMemoryType
+}
+
+// Fetch returns the exchanges of the session
+func (m *VFSMemory) Fetch(sessionId, query string) ([]Exchange, error) {
+	//TODO implement query
+	return m.Last(sessionId, -1)
+}
+
+// Last returns the last n exchanges of the session. All the exchanges are returned if n <= 0.
+func (m *VFSMemory) Last(sessionId string, n int) (exchanges []Exchange, err error) {
+	var records []*storedExchange
+	if records, err = m.load(sessionId); err != nil {
+		return
+	}
+	if n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+	for _, record := range records {
+		var exchange Exchange
+		if exchange, err = m.toExchange(record); err != nil {
+			exchanges = nil
+			return
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return
+}
+
+// Add adds the exchange to the session replacing the exchange with the same id
+func (m *VFSMemory) Add(sessionId string, exchange Exchange) (err error) {
+	var record *storedExchange
+	if record, err = m.toRecord(exchange); err != nil {
+		return
+	}
+	lock := m.lock(sessionId)
+	lock.Lock()
+	defer lock.Unlock()
+	var records []*storedExchange
+	records, err = m.load(sessionId)
+	if err != nil && !errors.Is(err, ErrInvalidSession) {
+		return
+	}
+	replaced := false
+	for i, r := range records {
+		if r.Id == record.Id {
+			records[i] = record
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		records = append(records, record)
+	}
+	err = m.save(sessionId, m.trim(records))
+	return
+}
+
+// Erase deletes the session
+func (m *VFSMemory) Erase(sessionId string) (err error) {
+	lock := m.lock(sessionId)
+	lock.Lock()
+	defer lock.Unlock()
+	if err = m.manager.Delete(m.sessionUrl(sessionId)); errors.Is(err, fs.ErrNotExist) {
+		err = ErrInvalidSession
+	}
+	return
+}
+
+// Info returns the metadata of the session
+func (m *VFSMemory) Info(sessionId string) (info SessionInfo, err error) {
+	var records []*storedExchange
+	if records, err = m.load(sessionId); err == nil {
+		info = sessionInfo(sessionId, records)
+	}
+	return
+}
+
+// List returns the sessions matching the query sorted by their id, and the token of the next page. The token is
+// empty on the last page.
+func (m *VFSMemory) List(query SessionQuery) (sessions []SessionInfo, next string, err error) {
+	if query.PageSize <= 0 {
+		query.PageSize = defaultSessionPage
+	}
+	var ids []string
+	if ids, err = m.sessionIds(); err != nil {
+		return
+	}
+	for _, id := range ids {
+		if query.PageToken != "" && id <= query.PageToken {
+			continue
+		}
+		var info SessionInfo
+		if info, err = m.Info(id); err != nil {
+			if errors.Is(err, ErrInvalidSession) {
+				// erased while listing
+				err = nil
+				continue
+			}
+			return
+		}
+		if !query.matches(info) {
+			continue
+		}
+		if len(sessions) == query.PageSize {
+			next = sessions[len(sessions)-1].Id
+			return
+		}
+		sessions = append(sessions, info)
+	}
+	return
+}
+
+// Purge removes the sessions without exchanges within the MaxAge. The sessions are deleted, or anonymized if
+// Anonymize is set. Purge stops when the ctx is done and reports the sessions removed until then.
+func (m *VFSMemory) Purge(ctx context.Context) (report PurgeReport, err error) {
+	if m.opts.MaxAge <= 0 {
+		return
+	}
+	var ids []string
+	if ids, err = m.sessionIds(); err != nil {
+		return
+	}
+	expiry := m.opts.Clock().Add(-m.opts.MaxAge)
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var purged, anonymized bool
+		if purged, anonymized, err = m.purge(id, expiry); err != nil {
+			return
+		}
+		if anonymized {
+			report.Anonymized = append(report.Anonymized, id)
+		} else if purged {
+			report.Deleted = append(report.Deleted, id)
+		}
+	}
+	return
+}
+
+// purge removes the session if it expired before the expiry
+func (m *VFSMemory) purge(sessionId string, expiry time.Time) (purged, anonymized bool, err error) {
+	lock := m.lock(sessionId)
+	lock.Lock()
+	defer lock.Unlock()
+	var records []*storedExchange
+	if records, err = m.load(sessionId); err != nil {
+		if errors.Is(err, ErrInvalidSession) {
+			err = nil
+		}
+		return
+	}
+	info := sessionInfo(sessionId, records)
+	if !info.Updated.Before(expiry) || info.Anonymized {
+		return
+	}
+	if !m.opts.Anonymize {
+		purged = true
+		err = m.manager.Delete(m.sessionUrl(sessionId))
+		return
+	}
+	for _, record := range records {
+		record.Attributes = nil
+		record.AttributesEnc = ""
+		record.Anonymized = true
+		for _, msg := range record.Messages {
+			msg.Url, msg.Text, msg.Data, msg.Enc = "", "", nil, ""
+		}
+	}
+	purged, anonymized = true, true
+	err = m.save(sessionId, records)
+	return
+}
+
+// trim drops the oldest exchanges exceeding the MaxMessages
+func (m *VFSMemory) trim(records []*storedExchange) []*storedExchange {
+	if m.opts.MaxMessages <= 0 {
+		return records
+	}
+	total := 0
+	start := len(records)
+	for start > 0 {
+		count := len(records[start-1].Messages)
+		if total+count > m.opts.MaxMessages && start < len(records) {
+			break
+		}
+		total += count
+		start--
+	}
+	return records[start:]
+}
+
+// load reads the exchanges of the session. The exchanges added again replace the earlier ones in place.
+func (m *VFSMemory) load(sessionId string) (records []*storedExchange, err error) {
+	var file vfs.VFile
+	if file, err = m.manager.Open(m.sessionUrl(sessionId)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = ErrInvalidSession
+		}
+		return
+	}
+	defer ioutils.CloserFunc(file)
+	var content []byte
+	if content, err = io.ReadAll(file); err != nil {
+		return
+	}
+	lines := bytes.Split(content, []byte("\n"))
+	positions := make(map[string]int)
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var record *storedExchange
+		if record, err = m.decode(line); err != nil {
+			if i == len(lines)-1 {
+				// a partial line is left by an interrupted write
+				err = nil
+				break
+			}
+			err = fmt.Errorf("%w: %s line %d: %v", ErrCorruptSession, sessionId, i+1, err)
+			records = nil
+			return
+		}
+		if pos, ok := positions[record.Id]; ok {
+			records[pos] = record
+		} else {
+			positions[record.Id] = len(records)
+			records = append(records, record)
+		}
+	}
+	return
+}
+
+// save writes the exchanges to a temporary file moved over the session file
+func (m *VFSMemory) save(sessionId string, records []*storedExchange) (err error) {
+	buf := &bytes.Buffer{}
+	for _, record := range records {
+		var line []byte
+		if line, err = m.encode(record); err != nil {
+			return
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	sessionUrl := m.sessionUrl(sessionId)
+	var dir, file vfs.VFile
+	if dir, err = m.manager.MkdirAll(sessionUrl.JoinPath("..")); err != nil {
+		return
+	}
+	ioutils.CloserFunc(dir)
+	var id *uuid.UUID
+	if id, err = uuid.V4(); err != nil {
+		return
+	}
+	tmpUrl := *sessionUrl
+	tmpUrl.Path += sessionTmpSuffix + id.String()
+	if file, err = m.manager.Create(&tmpUrl); err != nil {
+		return
+	}
+	_, err = file.Write(buf.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = m.manager.Move(&tmpUrl, sessionUrl)
+	}
+	if err != nil {
+		_ = m.manager.Delete(&tmpUrl)
+	}
+	return
+}
+
+// encode returns the line of the exchange
+func (m *VFSMemory) encode(record *storedExchange) (line []byte, err error) {
+	if line, err = json.Marshal(record); err != nil || m.opts.Encryption != EncryptFile {
+		return
+	}
+	var envelope *codec.FieldEnvelope
+	if envelope, err = m.opts.Encryptor.Encrypt(line); err == nil {
+		line = []byte(envelope.String())
+	}
+	return
+}
+
+// decode parses the line of the exchange
+func (m *VFSMemory) decode(line []byte) (record *storedExchange, err error) {
+	if m.opts.Encryption == EncryptFile {
+		if line, err = m.decrypt(string(line)); err != nil {
+			return
+		}
+	}
+	record = &storedExchange{}
+	if err = json.Unmarshal(line, record); err != nil {
+		record = nil
+	}
+	return
+}
+
+// decrypt opens the envelope
+func (m *VFSMemory) decrypt(s string) (plaintext []byte, err error) {
+	var envelope *codec.FieldEnvelope
+	if envelope, err = codec.ParseFieldEnvelope(s); err == nil {
+		plaintext, err = m.opts.Encryptor.Decrypt(envelope)
+	}
+	return
+}
+
+// encrypt seals the plaintext in an envelope
+func (m *VFSMemory) encrypt(plaintext []byte) (s string, err error) {
+	var envelope *codec.FieldEnvelope
+	if envelope, err = m.opts.Encryptor.Encrypt(plaintext); err == nil {
+		s = envelope.String()
+	}
+	return
+}
+
+// toRecord converts the exchange to its stored form
+func (m *VFSMemory) toRecord(exchange Exchange) (record *storedExchange, err error) {
+	record = &storedExchange{Id: exchange.Id(), Time: m.opts.Clock().UTC()}
+	if attrs := exchange.Attributes(); len(attrs) > 0 {
+		record.Attributes = attrs
+		if m.opts.Encryption == EncryptFields {
+			var b []byte
+			if b, err = json.Marshal(attrs); err != nil {
+				return
+			}
+			record.Attributes = nil
+			if record.AttributesEnc, err = m.encrypt(b); err != nil {
+				return
+			}
+		}
+	}
+	for _, msg := range exchange.Messages() {
+		stored := &storedMessage{Actor: msg.Actor(), Mime: msg.Mime()}
+		if msg.u != nil {
+			stored.Url = msg.u.String()
+		}
+		content := messageBytes(msg)
+		if m.opts.Encryption == EncryptFields && (len(content) > 0 || stored.Url != "") {
+			var b []byte
+			if b, err = json.Marshal(&storedMessage{Url: stored.Url, Data: content}); err != nil {
+				return
+			}
+			stored.Url = ""
+			if stored.Enc, err = m.encrypt(b); err != nil {
+				return
+			}
+		} else if isTextMime(stored.Mime) {
+			stored.Text = string(content)
+		} else {
+			stored.Data = content
+		}
+		record.Messages = append(record.Messages, stored)
+	}
+	return
+}
+
+// toExchange converts the stored form to an exchange
+func (m *VFSMemory) toExchange(record *storedExchange) (exchange Exchange, err error) {
+	exchange = NewExchange(record.Id)
+	attrs := record.Attributes
+	if record.AttributesEnc != "" {
+		var b []byte
+		if b, err = m.decrypt(record.AttributesEnc); err != nil {
+			return
+		}
+		if err = json.Unmarshal(b, &attrs); err != nil {
+			return
+		}
+	}
+	for k, v := range attrs {
+		exchange.Attributes()[k] = v
+	}
+	for _, stored := range record.Messages {
+		content := stored.Data
+		if stored.Text != "" {
+			content = []byte(stored.Text)
+		}
+		rawUrl := stored.Url
+		if stored.Enc != "" {
+			var b []byte
+			if b, err = m.decrypt(stored.Enc); err != nil {
+				return
+			}
+			sealed := &storedMessage{}
+			if err = json.Unmarshal(b, sealed); err != nil {
+				return
+			}
+			content, rawUrl = sealed.Data, sealed.Url
+		}
+		msg := &Message{rwer: bytes.NewBuffer(content), mimeType: stored.Mime, msgActor: stored.Actor, done: true}
+		if rawUrl != "" {
+			if msg.u, err = url.Parse(rawUrl); err != nil {
+				return
+			}
+		}
+		exchange.Add(msg)
+	}
+	return
+}
+
+// sessionIds returns the ids of the stored sessions sorted
+func (m *VFSMemory) sessionIds() (ids []string, err error) {
+	err = m.manager.Walk(m.base, func(file vfs.VFile) error {
+		name := path.Base(file.Url().Path)
+		if !strings.HasSuffix(name, sessionFileExt) {
+			return nil
+		}
+		id, decodeErr := base64.RawURLEncoding.DecodeString(strings.TrimSuffix(name, sessionFileExt))
+		if decodeErr == nil {
+			ids = append(ids, string(id))
+		}
+		return nil
+	})
+	sort.Strings(ids)
+	return
+}
+
+// sessionUrl returns the url of the session file. The sessions are sharded by the first byte of the hash of their id.
+func (m *VFSMemory) sessionUrl(sessionId string) *url.URL {
+	sum := sha256.Sum256([]byte(sessionId))
+	return m.base.JoinPath(hex.EncodeToString(sum[:1]), base64.RawURLEncoding.EncodeToString([]byte(sessionId))+sessionFileExt)
+}
+
+// lock returns the lock serializing the writes of the session
+func (m *VFSMemory) lock(sessionId string) *sync.Mutex {
+	lock, _ := m.locks.LoadOrStore(sessionId, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// matches checks if the session matches the query filters
+func (q *SessionQuery) matches(info SessionInfo) bool {
+	if !q.CreatedAfter.IsZero() && info.Created.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !info.Created.Before(q.CreatedBefore) {
+		return false
+	}
+	if info.Messages < q.MinMessages {
+		return false
+	}
+	return q.MaxMessages <= 0 || info.Messages <= q.MaxMessages
+}
+
+// sessionInfo returns the metadata of the session
+func sessionInfo(sessionId string, records []*storedExchange) (info SessionInfo) {
+	info.Id = sessionId
+	info.Exchanges = len(records)
+	info.Anonymized = len(records) > 0
+	for _, record := range records {
+		if info.Created.IsZero() || record.Time.Before(info.Created) {
+			info.Created = record.Time
+		}
+		if record.Time.After(info.Updated) {
+			info.Updated = record.Time
+		}
+		info.Messages += len(record.Messages)
+		info.Anonymized = info.Anonymized && record.Anonymized
+	}
+	return
+}
+
+// messageBytes returns the content of the message without consuming it
+func messageBytes(msg *Message) (content []byte) {
+	switch rw := msg.rwer.(type) {
+	case nil:
+	case *bytes.Buffer:
+		content = rw.Bytes()
+	default:
+		content, _ = io.ReadAll(rw)
+		msg.rwer = bytes.NewBuffer(content)
+	}
+	return
+}
+
+// isTextMime checks if the content of the mime type is stored as text
+func isTextMime(mime string) bool {
+	return strings.HasPrefix(mime, "text/") || mime == ioutils.MimeApplicationJSON || mime == ioutils.MimeMarkDown ||
+		mime == ioutils.MimeTextYAML
+}
@@ -0,0 +1,288 @@
+package genai
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/secrets"
+	"oss.nandlabs.io/golly/testing/assert"
+	"oss.nandlabs.io/golly/vfs"
+)
+
+// vfsMemoryBases returns the base urls of the mem and file backends
+func vfsMemoryBases(t *testing.T) map[string]string {
+	vfs.GetManager().Register(vfs.NewMemFs())
+	return map[string]string{
+		"mem":  "mem://sessions/" + t.Name(),
+		"file": "file://" + t.TempDir() + "/sessions",
+	}
+}
+
+func newTestExchange(id string, texts ...string) Exchange {
+	exchange := NewExchange(id)
+	exchange.Attributes()["user"] = "golly"
+	for i, text := range texts {
+		actor := UserActor
+		if i%2 == 1 {
+			actor = AIActor
+		}
+		_, _ = exchange.AddTxtMsg(text, actor)
+	}
+	return exchange
+}
+
+func texts(exchanges []Exchange) (all []string) {
+	for _, exchange := range exchanges {
+		for _, msg := range exchange.Messages() {
+			all = append(all, fmt.Sprintf("%s:%s", msg.Actor(), msg.String()))
+		}
+	}
+	return
+}
+
+func TestVFSMemory_AddLastErase(t *testing.T) {
+	for name, base := range vfsMemoryBases(t) {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewVFSMemory(vfs.GetManager(), base, VFSMemoryOptions{})
+			assert.NoError(t, err)
+			_, err = m.Last("s1", 1)
+			assert.Equal(t, ErrInvalidSession, err)
+
+			assert.NoError(t, m.Add("s1", newTestExchange("e1", "hi", "hello")))
+			assert.NoError(t, m.Add("s1", newTestExchange("e2", "how are you")))
+			exchange := newTestExchange("e3")
+			_, _ = exchange.AddBinMsg([]byte{0, 1, 2}, "image/png", UserActor)
+			_, _ = exchange.AddFileMsg("file:///tmp/a.txt", "text/plain", UserActor)
+			assert.NoError(t, m.Add("s1", exchange))
+			// replaces the exchange in place
+			assert.NoError(t, m.Add("s1", newTestExchange("e2", "how are you?", "fine")))
+
+			exchanges, err := m.Fetch("s1", "")
+			assert.NoError(t, err)
+			assert.Equal(t, 3, len(exchanges))
+			assert.Equal(t, "e2", exchanges[1].Id())
+			assert.ElementsMatch(t, texts(exchanges[:2]), "USER:hi", "AI:hello", "USER:how are you?", "AI:fine")
+			assert.Equal(t, "golly", exchanges[0].Attributes()["user"])
+			bin := exchanges[2].Messages()[0]
+			assert.Equal(t, "image/png", bin.Mime())
+			b := make([]byte, 3)
+			_, _ = bin.Read(b)
+			assert.Equal(t, []byte{0, 1, 2}, b)
+			assert.Equal(t, "file:///tmp/a.txt", exchanges[2].Messages()[1].URL().String())
+
+			last, err := m.Last("s1", 1)
+			assert.NoError(t, err)
+			assert.Equal(t, "e3", last[0].Id())
+
+			assert.NoError(t, m.Erase("s1"))
+			_, err = m.Last("s1", 1)
+			assert.Equal(t, ErrInvalidSession, err)
+			assert.Equal(t, ErrInvalidSession, m.Erase("s1"))
+		})
+	}
+}
+
+func TestVFSMemory_ConcurrentAdds(t *testing.T) {
+	for name, base := range vfsMemoryBases(t) {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewVFSMemory(vfs.GetManager(), base, VFSMemoryOptions{})
+			assert.NoError(t, err)
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					assert.NoError(t, m.Add("shared/session", newTestExchange(fmt.Sprintf("e%d", i), "msg")))
+				}(i)
+			}
+			wg.Wait()
+			exchanges, err := m.Last("shared/session", -1)
+			assert.NoError(t, err)
+			assert.Equal(t, 20, len(exchanges))
+		})
+	}
+}
+
+func TestVFSMemory_Encryption(t *testing.T) {
+	keyring, err := secrets.NewFieldKeyring("k1", []byte("0123456789abcdef0123456789abcdef"))
+	assert.NoError(t, err)
+	_, err = NewVFSMemory(vfs.GetManager(), "mem://sessions/enc", VFSMemoryOptions{Encryption: EncryptFile})
+	assert.Equal(t, ErrEncryptorRequired, err)
+
+	for name, base := range vfsMemoryBases(t) {
+		for _, mode := range []EncryptionMode{EncryptFields, EncryptFile} {
+			t.Run(fmt.Sprintf("%s-%d", name, mode), func(t *testing.T) {
+				opts := VFSMemoryOptions{Encryption: mode, Encryptor: keyring}
+				m, err := NewVFSMemory(vfs.GetManager(), fmt.Sprintf("%s/%d", base, mode), opts)
+				assert.NoError(t, err)
+				assert.NoError(t, m.Add("s1", newTestExchange("e1", "my secret", "noted")))
+
+				file, err := vfs.GetManager().Open(m.sessionUrl("s1"))
+				assert.NoError(t, err)
+				raw, err := file.AsString()
+				assert.NoError(t, err)
+				_ = file.Close()
+				assert.False(t, strings.Contains(raw, "my secret"))
+				assert.False(t, strings.Contains(raw, "golly"))
+				assert.Equal(t, mode == EncryptFields, strings.Contains(raw, `"actor":"USER"`))
+
+				exchanges, err := m.Last("s1", -1)
+				assert.NoError(t, err)
+				assert.ElementsMatch(t, texts(exchanges), "USER:my secret", "AI:noted")
+				assert.Equal(t, "golly", exchanges[0].Attributes()["user"])
+			})
+		}
+	}
+}
+
+func TestVFSMemory_Retention(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	for name, base := range vfsMemoryBases(t) {
+		for _, anonymize := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s-%v", name, anonymize), func(t *testing.T) {
+				m, err := NewVFSMemory(vfs.GetManager(), fmt.Sprintf("%s/%v", base, anonymize), VFSMemoryOptions{
+					MaxMessages: 3,
+					MaxAge:      24 * time.Hour,
+					Anonymize:   anonymize,
+					Clock:       clock,
+				})
+				assert.NoError(t, err)
+				start := now
+				assert.NoError(t, m.Add("old", newTestExchange("e1", "a", "b")))
+				now = now.Add(12 * time.Hour)
+				assert.NoError(t, m.Add("recent", newTestExchange("e1", "a", "b")))
+				assert.NoError(t, m.Add("recent", newTestExchange("e2", "c", "d")))
+				// the oldest exchange is dropped beyond the MaxMessages
+				info, err := m.Info("recent")
+				assert.NoError(t, err)
+				assert.Equal(t, 1, info.Exchanges)
+				assert.Equal(t, 2, info.Messages)
+
+				now = start.Add(30 * time.Hour)
+				report, err := m.Purge(context.Background())
+				assert.NoError(t, err)
+				if anonymize {
+					assert.ElementsMatch(t, report.Anonymized, "old")
+					assert.Equal(t, 0, len(report.Deleted))
+					exchanges, err := m.Last("old", -1)
+					assert.NoError(t, err)
+					assert.ElementsMatch(t, texts(exchanges), "USER:", "AI:")
+					assert.Equal(t, 0, len(exchanges[0].Attributes()))
+					info, err := m.Info("old")
+					assert.NoError(t, err)
+					assert.True(t, info.Anonymized)
+					// anonymized sessions are not reported again
+					report, err = m.Purge(context.Background())
+					assert.NoError(t, err)
+					assert.Equal(t, 0, len(report.Anonymized))
+				} else {
+					assert.ElementsMatch(t, report.Deleted, "old")
+					_, err = m.Last("old", -1)
+					assert.Equal(t, ErrInvalidSession, err)
+				}
+				exchanges, err := m.Last("recent", -1)
+				assert.NoError(t, err)
+				assert.ElementsMatch(t, texts(exchanges), "USER:c", "AI:d")
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err = m.Purge(ctx)
+				assert.Equal(t, context.Canceled, err)
+			})
+		}
+	}
+}
+
+func TestVFSMemory_List(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m, err := NewVFSMemory(vfs.GetManager(), vfsMemoryBases(t)["mem"], VFSMemoryOptions{
+		Clock: func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Hour)
+		msgs := make([]string, i+1)
+		for j := range msgs {
+			msgs[j] = "msg"
+		}
+		assert.NoError(t, m.Add(fmt.Sprintf("s%d", i), newTestExchange("e1", msgs...)))
+	}
+	page, next, err := m.List(SessionQuery{PageSize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page))
+	assert.Equal(t, "s0", page[0].Id)
+	assert.Equal(t, "s1", next)
+	page, next, err = m.List(SessionQuery{PageSize: 2, PageToken: next})
+	assert.NoError(t, err)
+	assert.Equal(t, "s2", page[0].Id)
+	page, next, err = m.List(SessionQuery{PageSize: 2, PageToken: next})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(page))
+	assert.Equal(t, "", next)
+
+	page, _, err = m.List(SessionQuery{
+		CreatedAfter:  time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC),
+		CreatedBefore: time.Date(2026, 1, 1, 5, 0, 0, 0, time.UTC),
+		MinMessages:   3,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page))
+	assert.Equal(t, "s2", page[0].Id)
+	assert.Equal(t, 3, page[0].Messages)
+	assert.Equal(t, "s3", page[1].Id)
+}
+
+func TestVFSMemory_PartialLine(t *testing.T) {
+	for name, base := range vfsMemoryBases(t) {
+		t.Run(name, func(t *testing.T) {
+			m, err := NewVFSMemory(vfs.GetManager(), base, VFSMemoryOptions{})
+			assert.NoError(t, err)
+			assert.NoError(t, m.Add("s1", newTestExchange("e1", "a")))
+			// simulate a crash while appending
+			u := m.sessionUrl("s1")
+			file, err := vfs.GetManager().Open(u)
+			assert.NoError(t, err)
+			content, err := file.AsString()
+			assert.NoError(t, err)
+			_ = file.Close()
+			file, err = vfs.GetManager().Create(u)
+			assert.NoError(t, err)
+			_, err = file.WriteString(content + `{"id":"e2","messages":[{"act`)
+			assert.NoError(t, err)
+			_ = file.Close()
+
+			exchanges, err := m.Last("s1", -1)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(exchanges))
+			assert.NoError(t, m.Add("s1", newTestExchange("e3", "b")))
+			exchanges, err = m.Last("s1", -1)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, texts(exchanges), "USER:a", "USER:b")
+
+			// a corrupt line before the last one is an error
+			file, err = vfs.GetManager().Create(u)
+			assert.NoError(t, err)
+			_, err = file.WriteString("not json\n" + content)
+			assert.NoError(t, err)
+			_ = file.Close()
+			_, err = m.Last("s1", -1)
+			assert.True(t, err != nil && strings.Contains(err.Error(), ErrCorruptSession.Error()))
+		})
+	}
+}
+
+func TestVFSMemory_SessionUrl(t *testing.T) {
+	m, err := NewVFSMemory(vfs.GetManager(), "mem://sessions/url/", VFSMemoryOptions{})
+	assert.NoError(t, err)
+	u := m.sessionUrl("a/b")
+	_, err = url.Parse(u.String())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u.Path, "/url/"))
+	assert.True(t, strings.HasSuffix(u.Path, "/YS9i.ndjson"))
+}
@@ -1,5 +1,5 @@
 module oss.nandlabs.io/golly
 
-go 1.22.1
+go 1.24
 
 require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,375 @@
+package ioutils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"oss.nandlabs.io/golly/collections"
+)
+
+const (
+	defaultDelimiter   = '\n'
+	defaultBufferSize  = 64 * 1024
+	defaultMemoryLimit = 64 * 1024 * 1024
+)
+
+// DuplicatePolicy defines how the merge handles the duplicate lines
+type DuplicatePolicy int
+
+const (
+	// KeepAll writes all the lines
+	KeepAll DuplicatePolicy = iota
+	// DropExact drops the lines that are byte for byte equal to a line already written
+	DropExact
+	// KeepFirst writes only the first of the adjacent lines with equal keys in the merged order. Among the lines
+	// comparing equal it is the one of the input with the lowest index.
+	KeepFirst
+	// KeepLast writes only the last of the adjacent lines with equal keys in the merged order. Among the lines
+	// comparing equal it is the one of the input with the highest index.
+	KeepLast
+)
+
+// ErrUnsorted is the error of a strict merge when an input is not sorted
+var ErrUnsorted = errors.New("the input is not sorted")
+
+// UnsortedError reports the line of an input that is out of order
+type UnsortedError struct {
+	// Input is the index of the input
+	Input int
+	// Offset is the byte offset of the line in the input
+	Offset int64
+}
+
+func (e *UnsortedError) Error() string {
+	return fmt.Sprintf("%v: input %d at offset %d", ErrUnsorted, e.Input, e.Offset)
+}
+
+func (e *UnsortedError) Unwrap() error {
+	return ErrUnsorted
+}
+
+// MergeOptions configures MergeSortedWith
+type MergeOptions struct {
+	// Delimiter separates the lines. Defaults to '\n'.
+	Delimiter byte
+	// Duplicates is the policy for the duplicate lines. Defaults to KeepAll.
+	Duplicates DuplicatePolicy
+	// Key extracts the key compared by KeepFirst and KeepLast. Defaults to the whole line.
+	// The inputs must be sorted so that the lines with equal keys are adjacent.
+	Key func(line []byte) []byte
+	// Strict fails the merge with an UnsortedError when a line of an input is smaller than the previous one
+	Strict bool
+	// BufferSize is the size of the read buffer of each input and of the write buffer. Defaults to 64KiB.
+	BufferSize int
+}
+
+// MergeStats reports the work done by a merge
+type MergeStats struct {
+	// LinesRead is the number of lines read from each input
+	LinesRead []int64
+	// Duplicates is the number of lines dropped by the duplicate policy
+	Duplicates int64
+	// BytesWritten is the number of bytes written to the destination
+	BytesWritten int64
+}
+
+// SortOptions configures SortLargeFile
+type SortOptions struct {
+	MergeOptions
+	// MemoryLimit is the number of bytes of lines sorted in memory at a time. Defaults to 64MiB.
+	MemoryLimit int
+	// TempDir is the directory of the sorted runs. Defaults to the directory of the file.
+	TempDir string
+	// Output is the path of the sorted file. Defaults to the path of the file, which is replaced.
+	Output string
+}
+
+// MergeSorted merges the sorted line oriented inputs into dst. See MergeSortedWith.
+func MergeSorted(dst io.Writer, cmp func(a, b []byte) int, srcs ...io.Reader) (MergeStats, error) {
+	return MergeSortedWith(dst, cmp, MergeOptions{}, srcs...)
+}
+
+// MergeSortedWith merges the inputs, each sorted by cmp, into dst streaming their lines through a k-way merge.
+// Only the current line of every input is held in memory. The lines comparing equal are written in the order of
+// the index of their input. Every line is written with the delimiter, including the last line of an input that
+// does not end with one.
+func MergeSortedWith(dst io.Writer, cmp func(a, b []byte) int, opts MergeOptions,
+	srcs ...io.Reader) (stats MergeStats, err error) {
+	opts.defaults()
+	stats.LinesRead = make([]int64, len(srcs))
+	queue := collections.NewPriorityQueue(func(a, b *mergeInput) bool {
+		c := cmp(a.line, b.line)
+		return c < 0 || (c == 0 && a.index < b.index)
+	})
+	for i, src := range srcs {
+		in := &mergeInput{index: i, r: bufio.NewReaderSize(src, opts.BufferSize)}
+		var ok bool
+		if ok, err = in.next(cmp, opts); err != nil {
+			return
+		} else if ok {
+			stats.LinesRead[i]++
+			_ = queue.Add(in)
+		}
+	}
+	out := &mergeWriter{w: bufio.NewWriterSize(dst, opts.BufferSize), opts: opts, cmp: cmp, stats: &stats}
+	for !queue.IsEmpty() && err == nil {
+		in, _ := queue.Front()
+		if err = out.write(in.line); err != nil {
+			break
+		}
+		var ok bool
+		if ok, err = in.next(cmp, opts); ok {
+			stats.LinesRead[in.index]++
+			queue.Fix()
+		} else if err == nil {
+			_, _ = queue.Dequeue()
+		}
+	}
+	if err == nil {
+		err = out.flush()
+	}
+	return
+}
+
+// SortLargeFile sorts the lines of a file that may be larger than the memory. The lines are sorted in runs of
+// MemoryLimit bytes spilled to temporary files which are then merged with MergeSortedWith. The output is written to a
+// temporary file renamed to the Output once complete, so that it is either absent or complete even if the sort
+// fails. The duplicate policy of the options applies to the whole file, the lines equal under cmp keep the order of
+// the file. The LinesRead of the stats are the lines of each sorted run.
+func SortLargeFile(path string, cmp func(a, b []byte) int, opts SortOptions) (stats MergeStats, err error) {
+	opts.defaults()
+	if opts.MemoryLimit <= 0 {
+		opts.MemoryLimit = defaultMemoryLimit
+	}
+	if opts.TempDir == "" {
+		opts.TempDir = filepath.Dir(path)
+	}
+	if opts.Output == "" {
+		opts.Output = path
+	}
+	var runs []string
+	defer func() {
+		for _, run := range runs {
+			_ = os.Remove(run)
+		}
+	}()
+	if runs, err = spillRuns(path, cmp, opts); err != nil {
+		return
+	}
+	files := make([]io.Reader, 0, len(runs))
+	for _, run := range runs {
+		var f *os.File
+		if f, err = os.Open(run); err != nil {
+			return
+		}
+		defer CloserFunc(f)
+		files = append(files, f)
+	}
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(filepath.Dir(opts.Output), "."+filepath.Base(opts.Output)+".*.tmp"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	mergeOpts := opts.MergeOptions
+	// the runs are sorted by construction
+	mergeOpts.Strict = false
+	if stats, err = MergeSortedWith(tmp, cmp, mergeOpts, files...); err == nil {
+		if err = tmp.Sync(); err == nil {
+			if err = tmp.Close(); err == nil {
+				err = os.Rename(tmp.Name(), opts.Output)
+			}
+		}
+	}
+	return
+}
+
+// spillRuns writes the lines of the file to sorted runs of at most MemoryLimit bytes
+func spillRuns(path string, cmp func(a, b []byte) int, opts SortOptions) (runs []string, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer CloserFunc(f)
+	r := bufio.NewReaderSize(f, opts.BufferSize)
+	var lines [][]byte
+	size := 0
+	for eof := false; !eof && err == nil; {
+		var line []byte
+		line, err = r.ReadBytes(opts.Delimiter)
+		if err == io.EOF {
+			eof, err = true, nil
+		}
+		if err != nil {
+			break
+		}
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(line, []byte{opts.Delimiter})
+			lines = append(lines, line)
+			size += len(line)
+		}
+		if (size >= opts.MemoryLimit || eof) && len(lines) > 0 {
+			var run string
+			if run, err = writeRun(lines, cmp, opts); err == nil {
+				runs = append(runs, run)
+			}
+			lines, size = lines[:0], 0
+		}
+	}
+	return
+}
+
+// writeRun sorts the lines and writes them to a temporary file
+func writeRun(lines [][]byte, cmp func(a, b []byte) int, opts SortOptions) (name string, err error) {
+	sort.SliceStable(lines, func(i, j int) bool { return cmp(lines[i], lines[j]) < 0 })
+	var f *os.File
+	if f, err = os.CreateTemp(opts.TempDir, ".sort-run-*"); err != nil {
+		return
+	}
+	name = f.Name()
+	w := bufio.NewWriterSize(f, opts.BufferSize)
+	for _, line := range lines {
+		if _, err = w.Write(line); err == nil {
+			err = w.WriteByte(opts.Delimiter)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(name)
+	}
+	return
+}
+
+// defaults sets the default values of the options that are not set
+func (opts *MergeOptions) defaults() {
+	if opts.Delimiter == 0 {
+		opts.Delimiter = defaultDelimiter
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if opts.Key == nil {
+		opts.Key = func(line []byte) []byte { return line }
+	}
+}
+
+// mergeInput is an input of the merge with its current line
+type mergeInput struct {
+	index int
+	r     *bufio.Reader
+	line  []byte
+	read  int64
+}
+
+// next reads the next line of the input. It returns false at the end of the input.
+func (in *mergeInput) next(cmp func(a, b []byte) int, opts MergeOptions) (ok bool, err error) {
+	line, err := in.r.ReadBytes(opts.Delimiter)
+	if err == io.EOF {
+		err = nil
+		if len(line) == 0 {
+			return
+		}
+	} else if err != nil {
+		return
+	}
+	offset := in.read
+	in.read += int64(len(line))
+	line = bytes.TrimSuffix(line, []byte{opts.Delimiter})
+	if opts.Strict && in.line != nil && cmp(in.line, line) > 0 {
+		err = &UnsortedError{Input: in.index, Offset: offset}
+		return
+	}
+	in.line, ok = line, true
+	return
+}
+
+// mergeWriter writes the merged lines applying the duplicate policy
+type mergeWriter struct {
+	w     *bufio.Writer
+	opts  MergeOptions
+	cmp   func(a, b []byte) int
+	stats *MergeStats
+	// run holds the lines written that compare equal, used by DropExact
+	run [][]byte
+	// last is the line last written or pending, used by KeepFirst and KeepLast
+	last    []byte
+	pending bool
+}
+
+// write writes the line unless it is a duplicate
+func (mw *mergeWriter) write(line []byte) (err error) {
+	switch mw.opts.Duplicates {
+	case DropExact:
+		if len(mw.run) > 0 && mw.cmp(mw.run[0], line) != 0 {
+			mw.run = mw.run[:0]
+		}
+		for _, l := range mw.run {
+			if bytes.Equal(l, line) {
+				mw.stats.Duplicates++
+				return
+			}
+		}
+		mw.run = append(mw.run, line)
+		err = mw.emit(line)
+	case KeepFirst:
+		if mw.pending && bytes.Equal(mw.opts.Key(mw.last), mw.opts.Key(line)) {
+			mw.stats.Duplicates++
+			return
+		}
+		mw.last, mw.pending = line, true
+		err = mw.emit(line)
+	case KeepLast:
+		if mw.pending {
+			if bytes.Equal(mw.opts.Key(mw.last), mw.opts.Key(line)) {
+				mw.stats.Duplicates++
+			} else {
+				err = mw.emit(mw.last)
+			}
+		}
+		mw.last, mw.pending = line, true
+	default:
+		err = mw.emit(line)
+	}
+	return
+}
+
+// flush writes the pending line and flushes the buffer
+func (mw *mergeWriter) flush() (err error) {
+	if mw.opts.Duplicates == KeepLast && mw.pending {
+		err = mw.emit(mw.last)
+		mw.pending = false
+	}
+	if err == nil {
+		err = mw.w.Flush()
+	}
+	return
+}
+
+// emit writes the line followed by the delimiter
+func (mw *mergeWriter) emit(line []byte) (err error) {
+	var n int
+	if n, err = mw.w.Write(line); err == nil {
+		err = mw.w.WriteByte(mw.opts.Delimiter)
+		n++
+	}
+	mw.stats.BytesWritten += int64(n)
+	return
+}
@@ -0,0 +1,166 @@
+package ioutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// keyOf returns the part of the line before the first comma
+func keyOf(line []byte) []byte {
+	if i := bytes.IndexByte(line, ','); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// byKey compares the lines by their key
+func byKey(a, b []byte) int {
+	return bytes.Compare(keyOf(a), keyOf(b))
+}
+
+func merge(t *testing.T, opts MergeOptions, cmp func(a, b []byte) int, inputs ...string) (string, MergeStats) {
+	t.Helper()
+	var out bytes.Buffer
+	srcs := make([]io.Reader, len(inputs))
+	for i, in := range inputs {
+		srcs[i] = strings.NewReader(in)
+	}
+	stats, err := MergeSortedWith(&out, cmp, opts, srcs...)
+	if err != nil {
+		t.Fatalf("MergeSortedWith() error = %v", err)
+	}
+	return out.String(), stats
+}
+
+func TestMergeSorted(t *testing.T) {
+	var out bytes.Buffer
+	stats, err := MergeSorted(&out, bytes.Compare,
+		strings.NewReader("a\nc\ne\ng\n"), strings.NewReader(""), strings.NewReader("b\nc\nd\nh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "a\nb\nc\nc\nd\ne\ng\nh\n" {
+		t.Errorf("got %q", out.String())
+	}
+	if fmt.Sprint(stats.LinesRead) != "[4 0 4]" || stats.BytesWritten != 16 || stats.Duplicates != 0 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestMergeSorted_Duplicates(t *testing.T) {
+	inputs := []string{"a,1\nb,1\nb,1\nc,1\n", "a,2\nb,1\nb,2\n", "b,3\nd,3\n"}
+	tests := []struct {
+		policy     DuplicatePolicy
+		want       string
+		duplicates int64
+	}{
+		{KeepAll, "a,1 a,2 b,1 b,1 b,1 b,2 b,3 c,1 d,3", 0},
+		{DropExact, "a,1 a,2 b,1 b,2 b,3 c,1 d,3", 2},
+		{KeepFirst, "a,1 b,1 c,1 d,3", 5},
+		{KeepLast, "a,2 b,3 c,1 d,3", 5},
+	}
+	for _, tt := range tests {
+		got, stats := merge(t, MergeOptions{Duplicates: tt.policy, Key: keyOf}, byKey, inputs...)
+		if strings.Join(strings.Fields(got), " ") != tt.want ||
+			stats.Duplicates != tt.duplicates {
+			t.Errorf("policy %d: got %q with %d duplicates, want %q with %d", tt.policy, got, stats.Duplicates,
+				tt.want, tt.duplicates)
+		}
+	}
+}
+
+func TestMergeSorted_Strict(t *testing.T) {
+	var out bytes.Buffer
+	_, err := MergeSortedWith(&out, bytes.Compare, MergeOptions{Strict: true, Delimiter: ';'},
+		strings.NewReader("a;b;c;"), strings.NewReader("a;d;b;e;"))
+	var unsorted *UnsortedError
+	if !errors.As(err, &unsorted) || !errors.Is(err, ErrUnsorted) {
+		t.Fatalf("got %v, want an UnsortedError", err)
+	}
+	if unsorted.Input != 1 || unsorted.Offset != 4 {
+		t.Errorf("got %+v, want input 1 at offset 4", unsorted)
+	}
+	// the same inputs merge without the strict mode
+	out.Reset()
+	if _, err = MergeSortedWith(&out, bytes.Compare, MergeOptions{Delimiter: ';'},
+		strings.NewReader("a;b;c;"), strings.NewReader("a;d;b;e;")); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+// writeShuffled writes n shuffled lines to a file and returns its path along with the sorted lines
+func writeShuffled(tb testing.TB, n int) (string, []string) {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%08d,%s", i%(n/2), strings.Repeat("x", 40))
+	}
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(n, func(i, j int) { lines[i], lines[j] = lines[j], lines[i] })
+	path := filepath.Join(tb.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	sorted := append([]string{}, lines...)
+	sort.Strings(sorted)
+	return path, sorted
+}
+
+func TestSortLargeFile(t *testing.T) {
+	path, sorted := writeShuffled(t, 10000)
+	output := filepath.Join(filepath.Dir(path), "sorted.txt")
+	stats, err := SortLargeFile(path, bytes.Compare, SortOptions{MemoryLimit: 64 * 1024, Output: output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != strings.Join(sorted, "\n")+"\n" {
+		t.Error("the output is not sorted")
+	}
+	if len(stats.LinesRead) < 5 {
+		t.Errorf("got %d runs, want the input spilled in several runs", len(stats.LinesRead))
+	}
+	// only the input and the output are left
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 2 {
+		t.Errorf("got %d files, want the temporary files removed", len(entries))
+	}
+
+	// in place with the duplicates dropped by key
+	stats, err = SortLargeFile(path, byKey, SortOptions{MergeOptions: MergeOptions{Duplicates: KeepFirst, Key: keyOf},
+		MemoryLimit: 64 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(path)
+	if lines := strings.Count(string(b), "\n"); lines != 5000 || stats.Duplicates != 5000 {
+		t.Errorf("got %d lines and %d duplicates, want 5000", lines, stats.Duplicates)
+	}
+
+	if _, err = SortLargeFile(filepath.Join(t.TempDir(), "missing"), bytes.Compare, SortOptions{}); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}
+
+func BenchmarkSortLargeFile(b *testing.B) {
+	// the input is about 40 times the memory limit
+	path, _ := writeShuffled(b, 50000)
+	output := filepath.Join(filepath.Dir(path), "sorted.txt")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := SortLargeFile(path, bytes.Compare, SortOptions{MemoryLimit: 64 * 1024, Output: output}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
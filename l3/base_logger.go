@@ -44,6 +44,7 @@ type BaseLogger struct {
 	traceEnabled    bool
 	includeFunction bool
 	includeLine     bool
+	dedup           *dedupState
 }
 
 // Map to hold loggers. This is updated in case the log config is reloaded
@@ -179,11 +180,15 @@ func Get() Logger {
 
 	if _, ok := loggers[pkgName]; !ok {
 		Level := logConfig.DefaultLvl
+		dedupWindow := logConfig.DedupWindow
 
 		if logConfig.PkgConfigs != nil && len(logConfig.PkgConfigs) > 0 {
 			for _, pkgConfig := range logConfig.PkgConfigs {
 				if pkgConfig.PackageName == pkgName {
 					Level = pkgConfig.Level
+					if pkgConfig.DedupWindow != "" {
+						dedupWindow = pkgConfig.DedupWindow
+					}
 				}
 			}
 		}
@@ -195,6 +200,14 @@ func Get() Logger {
 			includeLine:     logConfig.IncludeLineNum,
 		}
 		_ = logger.updateLvlFlags()
+		if dedupWindow != "" {
+			window, err := time.ParseDuration(dedupWindow)
+			if err != nil {
+				writeLog(os.Stderr, "Invalid dedup window for package", pkgName, err)
+			} else if window > 0 {
+				logger.dedup = newDedupState(window, logConfig.DedupCompare, logConfig.DedupLevels)
+			}
+		}
 		loggers[pkgName] = logger
 	}
 
@@ -248,7 +261,7 @@ func formatTimeToBytes(t time.Time, layout string) []byte {
 }
 
 // createLogMessage function creates a new log message with actual content variables
-func handleLog(l *BaseLogger, logMsg *LogMessage) {
+func handleLog(l *BaseLogger, logMsg *LogMessage, f string, a []interface{}) {
 	if l.includeFunction {
 		pc, _, no, _ := runtime.Caller(2)
 		details := runtime.FuncForPC(pc)
@@ -258,7 +271,21 @@ func handleLog(l *BaseLogger, logMsg *LogMessage) {
 			logMsg.Line = no
 		}
 	}
+	if err := firstError(a); err != nil {
+		logMsg.Fingerprint = fingerprintAt(err, callerFrame(2))
+		recordError(logMsg.Fingerprint, err)
+	}
+	captureLog(logMsg)
+
+	if l.dedup != nil {
+		l.dedup.log(logMsg, f, a)
+	} else {
+		dispatch(logMsg)
+	}
+}
 
+// dispatch sends the log message to the writers
+func dispatch(logMsg *LogMessage) {
 	if logConfig.Async {
 		logMsgChannel <- logMsg
 	} else {
@@ -305,28 +332,28 @@ func (l *BaseLogger) IsEnabled(sev Level) bool {
 // Error BaseLogger
 func (l *BaseLogger) Error(a ...interface{}) {
 	if l.errorEnabled && a != nil && len(a) > 0 {
-		handleLog(l, getLogMessage(Err, a...))
+		handleLog(l, getLogMessage(Err, a...), textutils.EmptyStr, a)
 	}
 }
 
 // ErrorF BaseLogger with formatting of the messages
 func (l *BaseLogger) ErrorF(f string, a ...interface{}) {
 	if l.errorEnabled {
-		handleLog(l, getLogMessageF(Err, f, a...))
+		handleLog(l, getLogMessageF(Err, f, a...), f, a)
 	}
 }
 
 // Warn BaseLogger
 func (l *BaseLogger) Warn(a ...interface{}) {
 	if l.warnEnabled && a != nil && len(a) > 0 {
-		handleLog(l, getLogMessage(Warn, a...))
+		handleLog(l, getLogMessage(Warn, a...), textutils.EmptyStr, a)
 	}
 }
 
 // WarnF BaseLogger with formatting of the messages
 func (l *BaseLogger) WarnF(f string, a ...interface{}) {
 	if l.warnEnabled {
-		handleLog(l, getLogMessageF(Warn, f, a...))
+		handleLog(l, getLogMessageF(Warn, f, a...), f, a)
 
 	}
 }
@@ -334,14 +361,14 @@ func (l *BaseLogger) WarnF(f string, a ...interface{}) {
 // Info BaseLogger
 func (l *BaseLogger) Info(a ...interface{}) {
 	if l.infoEnabled && a != nil && len(a) > 0 {
-		handleLog(l, getLogMessage(Info, a...))
+		handleLog(l, getLogMessage(Info, a...), textutils.EmptyStr, a)
 	}
 }
 
 // InfoF BaseLogger
 func (l *BaseLogger) InfoF(f string, a ...interface{}) {
 	if l.infoEnabled {
-		handleLog(l, getLogMessageF(Info, f, a...))
+		handleLog(l, getLogMessageF(Info, f, a...), f, a)
 
 	}
 }
@@ -349,21 +376,21 @@ func (l *BaseLogger) InfoF(f string, a ...interface{}) {
 // Debug BaseLogger
 func (l *BaseLogger) Debug(a ...interface{}) {
 	if l.debugEnabled && a != nil && len(a) > 0 {
-		handleLog(l, getLogMessage(Debug, a...))
+		handleLog(l, getLogMessage(Debug, a...), textutils.EmptyStr, a)
 	}
 }
 
 // DebugF BaseLogger
 func (l *BaseLogger) DebugF(f string, a ...interface{}) {
 	if l.debugEnabled {
-		handleLog(l, getLogMessageF(Debug, f, a...))
+		handleLog(l, getLogMessageF(Debug, f, a...), f, a)
 	}
 }
 
 // Trace BaseLogger
 func (l *BaseLogger) Trace(a ...interface{}) {
 	if l.traceEnabled && a != nil && len(a) > 0 {
-		handleLog(l, getLogMessage(Trace, a...))
+		handleLog(l, getLogMessage(Trace, a...), textutils.EmptyStr, a)
 
 	}
 }
@@ -371,6 +398,6 @@ func (l *BaseLogger) Trace(a ...interface{}) {
 // TraceF BaseLogger
 func (l *BaseLogger) TraceF(f string, a ...interface{}) {
 	if l.traceEnabled {
-		handleLog(l, getLogMessageF(Trace, f, a...))
+		handleLog(l, getLogMessageF(Trace, f, a...), f, a)
 	}
 }
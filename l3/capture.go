@@ -0,0 +1,114 @@
+package l3
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCaptureSize is the number of messages kept by a Capture if the size is not set
+const defaultCaptureSize = 1000
+
+// CapturedLog is a log message recorded by a Capture
+type CapturedLog struct {
+	// Time of the message
+	Time time.Time `json:"timestamp"`
+	// Level of the message
+	Level string `json:"level"`
+	// Function that logged the message, if the function is included by the configuration
+	Function string `json:"function,omitempty"`
+	// Line that logged the message, if the line is included by the configuration
+	Line int `json:"line,omitempty"`
+	// Message is the content of the message
+	Message string `json:"msg"`
+	// Fingerprint is the fingerprint of the error logged, see Fingerprint
+	Fingerprint string `json:"error_fingerprint,omitempty"`
+}
+
+// Capture records the messages logged while it is active, regardless of the writers, so that the logs of an
+// operation can be attached to its report. The loggers carry no context, so the messages logged concurrently by
+// other operations are recorded as well.
+type Capture struct {
+	mutex   sync.Mutex
+	size    int
+	logs    []CapturedLog
+	dropped int
+}
+
+var captures = make(map[*Capture]struct{})
+var capturesMutex sync.RWMutex
+
+// activeCaptures is the number of active captures, checked without the lock on every message
+var activeCaptures atomic.Int32
+
+// StartCapture starts recording the messages logged at the enabled levels until Stop is called. Once size messages
+// are recorded the oldest ones are dropped; 0 keeps 1000 messages.
+func StartCapture(size int) *Capture {
+	if size <= 0 {
+		size = defaultCaptureSize
+	}
+	c := &Capture{size: size}
+	capturesMutex.Lock()
+	defer capturesMutex.Unlock()
+	captures[c] = struct{}{}
+	activeCaptures.Add(1)
+	return c
+}
+
+// Stop stops recording and returns the recorded messages
+func (c *Capture) Stop() []CapturedLog {
+	capturesMutex.Lock()
+	if _, ok := captures[c]; ok {
+		delete(captures, c)
+		activeCaptures.Add(-1)
+	}
+	capturesMutex.Unlock()
+	return c.Logs()
+}
+
+// Logs returns a copy of the recorded messages, oldest first
+func (c *Capture) Logs() []CapturedLog {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]CapturedLog{}, c.logs...)
+}
+
+// Dropped returns the number of messages dropped once the capture was full
+func (c *Capture) Dropped() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.dropped
+}
+
+// add records the message, dropping the oldest one if the capture is full
+func (c *Capture) add(log CapturedLog) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.logs) == c.size {
+		copy(c.logs, c.logs[1:])
+		c.logs = c.logs[:len(c.logs)-1]
+		c.dropped++
+	}
+	c.logs = append(c.logs, log)
+}
+
+// captureLog records the message in the active captures
+func captureLog(logMsg *LogMessage) {
+	if activeCaptures.Load() == 0 {
+		return
+	}
+	level, _ := logMsg.Level.String()
+	log := CapturedLog{
+		Time:        logMsg.Time,
+		Level:       level,
+		Function:    logMsg.FnName,
+		Line:        logMsg.Line,
+		Message:     logMsg.Content.String(),
+		Fingerprint: logMsg.Fingerprint,
+	}
+	capturesMutex.RLock()
+	defer capturesMutex.RUnlock()
+	for c := range captures {
+		c.add(log)
+	}
+}
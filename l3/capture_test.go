@@ -0,0 +1,33 @@
+package l3
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	l, _ := fingerprintLogger(t)
+	l.Info("before")
+	capture := StartCapture(2)
+	nested := StartCapture(0)
+	l.Info("first")
+	l.DebugF("second %d", 2)
+	l.Error("third", errors.New("failed"))
+	logs := capture.Stop()
+	l.Info("after")
+	if len(logs) != 2 || logs[0].Message != "second 2" || logs[0].Level != "DEBUG" || logs[1].Level != "ERROR" ||
+		logs[1].Fingerprint == "" {
+		t.Errorf("logs = %+v", logs)
+	}
+	if capture.Dropped() != 1 {
+		t.Errorf("Dropped() = %d", capture.Dropped())
+	}
+	if logs = nested.Stop(); len(logs) != 4 || logs[0].Message != "first" || logs[3].Message != "after" {
+		t.Errorf("nested logs = %+v", logs)
+	}
+	// stopping twice is harmless
+	nested.Stop()
+	if activeCaptures.Load() != 0 {
+		t.Errorf("active captures = %d", activeCaptures.Load())
+	}
+}
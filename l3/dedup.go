@@ -0,0 +1,146 @@
+package l3
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	// DedupCompareMessage compares the formatted messages of the entries. This is the default.
+	DedupCompareMessage = "message"
+	// DedupCompareTemplate compares the format of the message and a hash of its arguments
+	DedupCompareTemplate = "template"
+	dedupSummaryFmt      = "last message repeated %d times (first: %s, last: %s)"
+)
+
+// dedupState suppresses the consecutive duplicate entries of a logger. Only the last entry is held so the memory
+// used is bounded to one message per logger.
+type dedupState struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	compare string
+	levels  map[Level]bool
+	// last written entry
+	key    string
+	level  Level
+	fnName string
+	line   int
+	since  time.Time
+	// suppressed duplicates of the last entry
+	count       int
+	first, last time.Time
+	timer       *time.Timer
+	generation  int
+}
+
+// newDedupState creates the state of a logger. All the levels are deduplicated if levels is empty.
+func newDedupState(window time.Duration, compare string, levels []string) *dedupState {
+	if compare == "" {
+		compare = DedupCompareMessage
+	}
+	d := &dedupState{window: window, compare: compare}
+	if len(levels) > 0 {
+		d.levels = make(map[Level]bool)
+		for _, level := range levels {
+			d.levels[LevelsMap[level]] = true
+		}
+	}
+	return d
+}
+
+// dedupKey returns the comparison key of the entry
+func (d *dedupState) dedupKey(logMsg *LogMessage, f string, a []interface{}) string {
+	if d.compare == DedupCompareTemplate {
+		h := fnv.New64a()
+		_, _ = fmt.Fprint(h, a...)
+		return fmt.Sprintf("%s\x00%x", f, h.Sum64())
+	}
+	return logMsg.Content.String()
+}
+
+// log dispatches the entry unless it is a duplicate of the last entry within the window. The summary of the
+// suppressed duplicates is dispatched before a different entry. The entries are dispatched under the lock so that
+// the summary is always written before the entry that ended the duplicates.
+func (d *dedupState) log(logMsg *LogMessage, f string, a []interface{}) {
+	var key string
+	dedup := d.levels == nil || d.levels[logMsg.Level]
+	if dedup {
+		key = d.dedupKey(logMsg, f, a)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if dedup && key == d.key && logMsg.Level == d.level && logMsg.FnName == d.fnName && logMsg.Line == d.line &&
+		logMsg.Time.Sub(d.since) < d.window {
+		d.count++
+		if d.count == 1 {
+			d.first = logMsg.Time
+			generation := d.generation
+			d.timer = time.AfterFunc(d.since.Add(d.window).Sub(logMsg.Time), func() {
+				d.expire(generation)
+			})
+		}
+		d.last = logMsg.Time
+		putLogMessage(logMsg)
+		return
+	}
+	d.flushLocked()
+	if dedup {
+		d.key, d.level, d.fnName, d.line, d.since = key, logMsg.Level, logMsg.FnName, logMsg.Line, logMsg.Time
+	} else {
+		d.key, d.since = "", time.Time{}
+	}
+	dispatch(logMsg)
+}
+
+// expire emits the summary once the window of the entry is over. The next duplicate is then written again.
+func (d *dedupState) expire(generation int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if generation == d.generation {
+		d.flushLocked()
+		d.key, d.since = "", time.Time{}
+	}
+}
+
+// flush emits the summary of the suppressed duplicates
+func (d *dedupState) flush() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.flushLocked()
+}
+
+func (d *dedupState) flushLocked() {
+	if d.count == 0 {
+		return
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	summary := getLogMessageF(d.level, dedupSummaryFmt, d.count, d.first.Format(logConfig.DatePattern),
+		d.last.Format(logConfig.DatePattern))
+	summary.Time = d.last
+	summary.FnName = d.fnName
+	summary.Line = d.line
+	d.count = 0
+	d.generation++
+	dispatch(summary)
+}
+
+// Flush emits the summaries of the duplicate entries suppressed by the loggers. Call it before the application exits
+// so that no count is lost.
+func Flush() {
+	mutex.Lock()
+	states := make([]*dedupState, 0, len(loggers))
+	for _, l := range loggers {
+		if l.dedup != nil {
+			states = append(states, l.dedup)
+		}
+	}
+	mutex.Unlock()
+	for _, d := range states {
+		d.flush()
+	}
+}
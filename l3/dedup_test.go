@@ -0,0 +1,171 @@
+package l3
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordWriter struct {
+	mutex   sync.Mutex
+	entries []string
+}
+
+func (r *recordWriter) InitConfig(w *WriterConfig) {}
+
+func (r *recordWriter) DoLog(logMsg *LogMessage) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = append(r.entries, Levels[logMsg.Level]+" "+logMsg.Content.String())
+}
+
+func (r *recordWriter) Close() error {
+	return nil
+}
+
+func (r *recordWriter) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.entries...)
+}
+
+// dedupLogger returns a logger with the dedup window writing to a recordWriter
+func dedupLogger(t *testing.T, window time.Duration, compare string, levels ...string) (*BaseLogger, *recordWriter) {
+	recorder := &recordWriter{}
+	mutex.Lock()
+	previous := writers
+	writers = []LogWriter{recorder}
+	mutex.Unlock()
+	l := &BaseLogger{level: Trace, pkgName: t.Name(), dedup: newDedupState(window, compare, levels)}
+	_ = l.updateLvlFlags()
+	t.Cleanup(func() {
+		l.dedup.flush()
+		mutex.Lock()
+		writers = previous
+		mutex.Unlock()
+	})
+	return l, recorder
+}
+
+func checkEntries(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("entry %d = %q, want prefix %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDedup_BurstThenChange(t *testing.T) {
+	l, recorder := dedupLogger(t, time.Minute, "")
+	for i := 0; i < 5; i++ {
+		l.ErrorF("connection to %s refused", "db")
+	}
+	l.Info("connection to db refused")
+	l.ErrorF("connection to %s refused", "db")
+	l.ErrorF("connection to %s refused", "cache")
+	checkEntries(t, recorder.get(),
+		"ERROR connection to db refused",
+		"ERROR last message repeated 4 times (first: ",
+		"INFO connection to db refused",
+		"ERROR connection to db refused",
+		"ERROR connection to cache refused")
+}
+
+func TestDedup_WindowExpiry(t *testing.T) {
+	l, recorder := dedupLogger(t, 100*time.Millisecond, "")
+	l.Error("timeout")
+	l.Error("timeout")
+	l.Error("timeout")
+	checkEntries(t, recorder.get(), "ERROR timeout")
+	time.Sleep(200 * time.Millisecond)
+	checkEntries(t, recorder.get(), "ERROR timeout", "ERROR last message repeated 2 times")
+	// the window of the next entry starts again
+	l.Error("timeout")
+	l.Error("timeout")
+	checkEntries(t, recorder.get(), "ERROR timeout", "ERROR last message repeated 2 times", "ERROR timeout")
+}
+
+func TestDedup_Compare(t *testing.T) {
+	tests := []struct {
+		name    string
+		compare string
+		want    []string
+	}{
+		{
+			name:    "message",
+			compare: DedupCompareMessage,
+			want:    []string{"WARN retry 1 of 3", "WARN last message repeated 1 times", "WARN retry 2 of 3"},
+		},
+		{
+			name:    "template",
+			compare: DedupCompareTemplate,
+			want: []string{"WARN retry 1 of 3", "WARN last message repeated 1 times", "WARN retry 2 of 3",
+				"WARN retry 2 of 3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, recorder := dedupLogger(t, time.Minute, tt.compare)
+			l.WarnF("retry %d of %d", 1, 3)
+			l.WarnF("retry %d of %d", 1, 3)
+			l.WarnF("retry %d of %d", 2, 3)
+			// same formatted message from a different format
+			l.WarnF("retry %s of %d", "2", 3)
+			checkEntries(t, recorder.get(), tt.want...)
+		})
+	}
+}
+
+func TestDedup_Levels(t *testing.T) {
+	l, recorder := dedupLogger(t, time.Minute, "", "ERROR")
+	l.Info("polling")
+	l.Info("polling")
+	l.Error("failed")
+	l.Error("failed")
+	l.dedup.flush()
+	checkEntries(t, recorder.get(), "INFO polling", "INFO polling", "ERROR failed",
+		"ERROR last message repeated 1 times")
+}
+
+func TestFlush(t *testing.T) {
+	l, recorder := dedupLogger(t, time.Minute, "")
+	mutex.Lock()
+	loggers[l.pkgName] = l
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		delete(loggers, l.pkgName)
+		mutex.Unlock()
+	}()
+	for i := 0; i < 848; i++ {
+		l.Error("disk full")
+	}
+	Flush()
+	checkEntries(t, recorder.get(), "ERROR disk full", "ERROR last message repeated 847 times")
+	// nothing is pending after the flush
+	Flush()
+	checkEntries(t, recorder.get(), "ERROR disk full", "ERROR last message repeated 847 times")
+}
+
+func TestGet_DedupWindow(t *testing.T) {
+	mutex.Lock()
+	previous := logConfig.DedupWindow
+	logConfig.DedupWindow = "10s"
+	delete(loggers, "l3")
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		logConfig.DedupWindow = previous
+		delete(loggers, "l3")
+		mutex.Unlock()
+	}()
+	l := Get().(*BaseLogger)
+	if l.dedup == nil || l.dedup.window != 10*time.Second || l.dedup.compare != DedupCompareMessage {
+		t.Errorf("Get() dedup = %+v, want a 10s window", l.dedup)
+	}
+}
@@ -0,0 +1,193 @@
+package l3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// errorBucket is the granularity of the windows of the error summary
+	errorBucket = time.Minute
+	// errorRetention is the longest window of the error summary
+	errorRetention = 24 * time.Hour
+	// maxErrorGroups is the number of fingerprints kept by the error summary, the least recently seen are dropped
+	maxErrorGroups = 1000
+)
+
+// fingerprintComponents returns the components of the fingerprint of an error
+var fingerprintComponents = ErrorTypes
+
+// errorNow returns the current time, replaced by the tests
+var errorNow = time.Now
+
+// ErrorGroup is the summary of the errors logged with a fingerprint
+type ErrorGroup struct {
+	// Fingerprint of the errors
+	Fingerprint string `json:"fingerprint"`
+	// Count is the number of errors logged in the window
+	Count int `json:"count"`
+	// FirstSeen is the time the fingerprint was first logged
+	FirstSeen time.Time `json:"firstSeen"`
+	// LastSeen is the time the fingerprint was last logged
+	LastSeen time.Time `json:"lastSeen"`
+	// Example is the message of the last error logged
+	Example string `json:"example"`
+}
+
+// errorGroup holds the counts of a fingerprint in buckets of errorBucket
+type errorGroup struct {
+	ErrorGroup
+	buckets map[int64]int
+}
+
+var errorGroups = make(map[string]*errorGroup)
+var errorGroupsMutex sync.Mutex
+
+// ErrorTypes returns the type names of the errors of the chain, depth first through the joined errors. It is the
+// default for the components of a fingerprint.
+func ErrorTypes(err error) (components []string) {
+	for err != nil {
+		components = append(components, fmt.Sprintf("%T", err))
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				components = append(components, ErrorTypes(inner)...)
+			}
+			return
+		default:
+			err = errors.Unwrap(err)
+		}
+	}
+	return
+}
+
+// SetFingerprintComponents replaces the function returning the components of the fingerprint of an error, for
+// instance to add the error codes of the application. A nil function restores ErrorTypes. The components must not
+// contain variable content such as identifiers or times.
+func SetFingerprintComponents(fn func(err error) []string) {
+	if fn == nil {
+		fn = ErrorTypes
+	}
+	fingerprintComponents = fn
+}
+
+// Fingerprint returns a stable hash of the components of the error, by default the types of its chain. The message
+// of the error is not part of the fingerprint so that the errors differing only by the identifiers or the times in
+// their message share a fingerprint.
+func Fingerprint(err error) string {
+	return fingerprintAt(err, "")
+}
+
+// fingerprintAt returns the fingerprint of the error logged from the frame
+func fingerprintAt(err error, frame string) string {
+	h := sha256.New()
+	for _, c := range fingerprintComponents(err) {
+		h.Write([]byte(c))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(frame))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// callerFrame returns the function and the line of the caller, without the path of the file that depends on the
+// location of the sources
+func callerFrame(skip int) string {
+	pc, _, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+	name := ""
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+	}
+	return name + ":" + strconv.Itoa(line)
+}
+
+// firstError returns the first argument that is an error
+func firstError(a []interface{}) error {
+	for _, v := range a {
+		if err, ok := v.(error); ok && err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordError counts the error of the fingerprint in the error summary
+func recordError(fingerprint string, err error) {
+	// the example is exposed by the error summary, it is scrubbed as the messages are
+	example := fmt.Sprint(scrubArgs([]interface{}{err})...)
+	now := errorNow()
+	bucket := now.Truncate(errorBucket).Unix()
+	errorGroupsMutex.Lock()
+	defer errorGroupsMutex.Unlock()
+	group, ok := errorGroups[fingerprint]
+	if !ok {
+		if len(errorGroups) >= maxErrorGroups {
+			evictErrorGroup()
+		}
+		group = &errorGroup{
+			ErrorGroup: ErrorGroup{Fingerprint: fingerprint, FirstSeen: now},
+			buckets:    make(map[int64]int),
+		}
+		errorGroups[fingerprint] = group
+	}
+	group.LastSeen = now
+	group.Example = example
+	group.buckets[bucket]++
+	expired := now.Add(-errorRetention).Unix()
+	for b := range group.buckets {
+		if b < expired {
+			delete(group.buckets, b)
+		}
+	}
+}
+
+// evictErrorGroup removes the group seen least recently
+func evictErrorGroup() {
+	var oldest *errorGroup
+	for _, group := range errorGroups {
+		if oldest == nil || group.LastSeen.Before(oldest.LastSeen) {
+			oldest = group
+		}
+	}
+	delete(errorGroups, oldest.Fingerprint)
+}
+
+// ErrorSummary returns the top fingerprints of the errors logged in the window, the most frequent first. The window is
+// counted in whole minutes and at most 24 hours. A top of 0 or less returns all the fingerprints. It is meant for an
+// operations endpoint when the logs are not aggregated elsewhere.
+func ErrorSummary(window time.Duration, top int) (groups []ErrorGroup) {
+	from := errorNow().Add(-window).Truncate(errorBucket).Unix()
+	errorGroupsMutex.Lock()
+	for _, group := range errorGroups {
+		summary := group.ErrorGroup
+		for bucket, count := range group.buckets {
+			if bucket >= from {
+				summary.Count += count
+			}
+		}
+		if summary.Count > 0 {
+			groups = append(groups, summary)
+		}
+	}
+	errorGroupsMutex.Unlock()
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return strings.Compare(groups[i].Fingerprint, groups[j].Fingerprint) < 0
+	})
+	if top > 0 && len(groups) > top {
+		groups = groups[:top]
+	}
+	return
+}
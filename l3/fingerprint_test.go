@@ -0,0 +1,132 @@
+package l3
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fingerprintWriter struct {
+	mutex        sync.Mutex
+	fingerprints []string
+}
+
+func (f *fingerprintWriter) InitConfig(w *WriterConfig) {}
+
+func (f *fingerprintWriter) DoLog(logMsg *LogMessage) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fingerprints = append(f.fingerprints, logMsg.Fingerprint)
+}
+
+func (f *fingerprintWriter) Close() error {
+	return nil
+}
+
+// fingerprintLogger returns a logger writing the fingerprints to a fingerprintWriter
+func fingerprintLogger(t *testing.T) (*BaseLogger, *fingerprintWriter) {
+	recorder := &fingerprintWriter{}
+	mutex.Lock()
+	previous := writers
+	writers = []LogWriter{recorder}
+	mutex.Unlock()
+	l := &BaseLogger{level: Trace, pkgName: t.Name()}
+	_ = l.updateLvlFlags()
+	t.Cleanup(func() {
+		mutex.Lock()
+		writers = previous
+		mutex.Unlock()
+	})
+	return l, recorder
+}
+
+// resetErrorSummary clears the error summary and sets its time until the end of the test
+func resetErrorSummary(t *testing.T, now *time.Time) {
+	errorGroupsMutex.Lock()
+	errorGroups = make(map[string]*errorGroup)
+	errorGroupsMutex.Unlock()
+	errorNow = func() time.Time { return *now }
+	t.Cleanup(func() { errorNow = time.Now })
+}
+
+func TestFingerprint(t *testing.T) {
+	// the fingerprints are the same in every run and build
+	if got := Fingerprint(errors.New("user 42 not found")); got != "a2b6a503780ee4cf" {
+		t.Errorf("Fingerprint() = %s", got)
+	}
+	pathErr := fmt.Errorf("loading: %w", &os.PathError{Op: "open", Path: "/tmp/a", Err: os.ErrNotExist})
+	if got := Fingerprint(pathErr); got != "5c7b80288f4e6421" {
+		t.Errorf("Fingerprint() = %s", got)
+	}
+	// the messages are not part of the fingerprint
+	other := fmt.Errorf("saving: %w", &os.PathError{Op: "write", Path: "/var/b", Err: os.ErrPermission})
+	if Fingerprint(pathErr) != Fingerprint(other) {
+		t.Error("the fingerprints differ by the messages")
+	}
+	if Fingerprint(pathErr) == Fingerprint(errors.Join(pathErr, errors.New("b"))) {
+		t.Error("the fingerprints of different chains are equal")
+	}
+
+	SetFingerprintComponents(func(err error) []string {
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			return append(ErrorTypes(err), pe.Op)
+		}
+		return ErrorTypes(err)
+	})
+	defer SetFingerprintComponents(nil)
+	if Fingerprint(pathErr) == Fingerprint(other) {
+		t.Error("the custom components are not part of the fingerprint")
+	}
+}
+
+func TestLogger_Fingerprint(t *testing.T) {
+	now := time.Now()
+	resetErrorSummary(t, &now)
+	l, recorder := fingerprintLogger(t)
+	for i := 0; i < 3; i++ {
+		l.ErrorF("request %d failed: %v", i, fmt.Errorf("request %d: %w", i, os.ErrNotExist))
+	}
+	l.Error("request failed:", os.ErrNotExist)
+	l.Info("no error here")
+	fp := recorder.fingerprints
+	if len(fp) != 5 || fp[0] == "" || fp[0] != fp[1] || fp[1] != fp[2] || fp[3] == fp[0] || fp[3] == "" || fp[4] != "" {
+		t.Errorf("fingerprints = %q, want the lines logging the errors to have distinct fingerprints", fp)
+	}
+}
+
+func TestErrorSummary(t *testing.T) {
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	resetErrorSummary(t, &now)
+	l, _ := fingerprintLogger(t)
+	logTimeout := func(id int) { l.ErrorF("timeout: %v", fmt.Errorf("call %d: %w", id, os.ErrDeadlineExceeded)) }
+	logMissing := func(id int) { l.ErrorF("missing: %v", fmt.Errorf("user %d: %w", id, os.ErrNotExist)) }
+	logMissing(1)
+	now = now.Add(10 * time.Minute)
+	for i := 0; i < 3; i++ {
+		logTimeout(i)
+	}
+	logMissing(2)
+
+	groups := ErrorSummary(time.Hour, 0)
+	if len(groups) != 2 || groups[0].Count != 3 || groups[1].Count != 2 {
+		t.Fatalf("ErrorSummary() = %+v", groups)
+	}
+	missing := groups[1]
+	if !missing.FirstSeen.Equal(now.Add(-10*time.Minute)) || !missing.LastSeen.Equal(now) ||
+		missing.Example != "user 2: file does not exist" {
+		t.Errorf("group = %+v", missing)
+	}
+	// the window excludes the errors logged before it
+	groups = ErrorSummary(5*time.Minute, 1)
+	if len(groups) != 1 || groups[0].Count != 3 {
+		t.Errorf("ErrorSummary() = %+v", groups)
+	}
+	now = now.Add(2 * time.Hour)
+	if groups = ErrorSummary(time.Hour, 0); len(groups) != 0 {
+		t.Errorf("ErrorSummary() = %+v, want none in the window", groups)
+	}
+}
@@ -23,6 +23,17 @@ type LogConfig struct {
 	IncludeLineNum bool `json:"includeLineNum,omitempty" yaml:"includeLineNum,omitempty"`
 	//DefaultLvl that will be used as default
 	DefaultLvl string `json:"defaultLvl" yaml:"defaultLvl"`
+	//DedupWindow enables the suppression of the consecutive duplicate entries of a logger within the window.
+	//The value is parsed with time.ParseDuration e.g. 10s. The duplicates are replaced by a summary entry.
+	//Default value : disabled
+	DedupWindow string `json:"dedupWindow,omitempty" yaml:"dedupWindow,omitempty"`
+	//DedupCompare how the entries are compared. valid values are message,template
+	//message compares the formatted message. template compares the format and a hash of the arguments.
+	//Default value : message
+	DedupCompare string `json:"dedupCompare,omitempty" yaml:"dedupCompare,omitempty"`
+	//DedupLevels the levels deduplicated if the DedupWindow is set. valid values : ERROR,WARN,INFO,DEBUG,TRACE
+	//Default value : all levels
+	DedupLevels []string `json:"dedupLevels,omitempty" yaml:"dedupLevels,omitempty"`
 	//PackageConfig that can be used to
 	PkgConfigs []*PackageConfig `json:"pkgConfigs" yaml:"pkgConfigs"`
 	//Writers writers for the logger. Need one for all levels
@@ -36,6 +47,8 @@ type PackageConfig struct {
 	PackageName string `json:"pkgName" yaml:"pkgName"`
 	//Level to be set valid values : OFF,ERROR,WARN,INFO,DEBUG,TRACE
 	Level string `json:"level" yaml:"level"`
+	//DedupWindow overrides the DedupWindow of the LogConfig for the package
+	DedupWindow string `json:"dedupWindow,omitempty" yaml:"dedupWindow,omitempty"`
 }
 
 // WriterConfig struct
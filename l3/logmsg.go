@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"oss.nandlabs.io/golly/textutils"
@@ -28,17 +29,48 @@ type LogMessage struct {
 	Line    int           `json:"line,omitempty"`
 	Content *bytes.Buffer `json:"msg"`
 	Level   Level         `json:"level"`
-	Buf     *bytes.Buffer
+	// Fingerprint is the fingerprint of the error logged, see Fingerprint
+	Fingerprint string `json:"error_fingerprint,omitempty"`
+	Buf         *bytes.Buffer
 	//SevBytes []byte
 }
 
+// argScrubber replaces the arguments of the entries before they are formatted, see SetArgScrubber.
+// It is read by every logging goroutine and is therefore stored atomically.
+var argScrubber atomic.Pointer[func(v any) any]
+
+// SetArgScrubber sets the function replacing each argument of the entries before it is formatted, for instance with a
+// copy masking its secrets. The examples of the error summary and the captured logs are scrubbed as well. A nil
+// function restores the arguments as they are. The function must not log.
+func SetArgScrubber(fn func(v any) any) {
+	if fn == nil {
+		argScrubber.Store(nil)
+		return
+	}
+	argScrubber.Store(&fn)
+}
+
+// scrubArgs returns the arguments replaced by the argScrubber, the arguments of the caller are not modified
+func scrubArgs(v []interface{}) []interface{} {
+	scrub := argScrubber.Load()
+	if scrub == nil || len(v) == 0 {
+		return v
+	}
+	scrubbed := make([]interface{}, len(v))
+	for i, a := range v {
+		scrubbed[i] = (*scrub)(a)
+	}
+	return scrubbed
+}
+
 func getLogMessageF(level Level, f string, v ...interface{}) *LogMessage {
 	msg := logMsgPool.Get().(*LogMessage)
 	msg.Level = level
 	msg.Time = time.Now()
 	msg.FnName = textutils.EmptyStr
 	msg.Line = 0
-	_, _ = fmt.Fprintf(msg.Content, f, v...)
+	msg.Fingerprint = textutils.EmptyStr
+	_, _ = fmt.Fprintf(msg.Content, f, scrubArgs(v)...)
 	return msg
 }
 
@@ -48,7 +80,8 @@ func getLogMessage(level Level, v ...interface{}) *LogMessage {
 	msg.Time = time.Now()
 	msg.FnName = textutils.EmptyStr
 	msg.Line = 0
-	_, _ = fmt.Fprint(msg.Content, v...)
+	msg.Fingerprint = textutils.EmptyStr
+	_, _ = fmt.Fprint(msg.Content, scrubArgs(v)...)
 	return msg
 }
 
@@ -0,0 +1,79 @@
+package l3
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scrubHunter2 masks the arguments holding hunter2
+func scrubHunter2(v any) any {
+	switch a := v.(type) {
+	case string:
+		return strings.ReplaceAll(a, "hunter2", "***")
+	case error:
+		return errors.New(strings.ReplaceAll(a.Error(), "hunter2", "***"))
+	}
+	return v
+}
+
+func TestSetArgScrubber(t *testing.T) {
+	l, _ := fingerprintLogger(t)
+	SetArgScrubber(scrubHunter2)
+	defer SetArgScrubber(nil)
+	capture := StartCapture(0)
+	args := []interface{}{"password", "hunter2"}
+	l.Info(args...)
+	l.InfoF("%s=%s", args...)
+	SetArgScrubber(nil)
+	l.InfoF("%s=%s", args...)
+	logs := capture.Stop()
+	if len(logs) != 3 || logs[0].Message != "password***" || logs[1].Message != "password=***" ||
+		logs[2].Message != "password=hunter2" {
+		t.Errorf("logs = %+v", logs)
+	}
+	if args[1] != "hunter2" {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestSetArgScrubber_ErrorSummary(t *testing.T) {
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	resetErrorSummary(t, &now)
+	l, _ := fingerprintLogger(t)
+	SetArgScrubber(scrubHunter2)
+	defer SetArgScrubber(nil)
+	capture := StartCapture(0)
+	l.ErrorF("login failed: %v", errors.New("password=hunter2"))
+	logs := capture.Stop()
+	if len(logs) != 1 || logs[0].Message != "login failed: password=***" {
+		t.Errorf("logs = %+v", logs)
+	}
+	groups := ErrorSummary(time.Hour, 0)
+	if len(groups) != 1 || groups[0].Example != "password=***" {
+		t.Errorf("ErrorSummary() = %+v", groups)
+	}
+}
+
+func TestSetArgScrubber_Concurrent(t *testing.T) {
+	l, _ := fingerprintLogger(t)
+	defer SetArgScrubber(nil)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			SetArgScrubber(scrubHunter2)
+			SetArgScrubber(nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			l.Info("password", "hunter2")
+		}
+	}()
+	wg.Wait()
+}
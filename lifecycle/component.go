@@ -1,6 +1,12 @@
 package lifecycle
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
 
 type ComponentState int
 
@@ -17,6 +23,8 @@ const (
 	Running
 	// Starting is the state of the component when it is starting.
 	Starting
+	// Failed is the state of the component when it did not start or stop within its timeout.
+	Failed
 )
 
 var ErrCompNotFound = errors.New("component not found")
@@ -27,6 +35,12 @@ var ErrCompAlreadyStopped = errors.New("component already stopped")
 
 var ErrInvalidComponentState = errors.New("invalid component state")
 
+var ErrCyclicDependency = errors.New("cyclic dependency between components")
+
+var ErrCompTimeout = errors.New("component timed out")
+
+var ErrDependencyNotRunning = errors.New("dependency not running")
+
 // Component is the interface that wraps the basic Start and Stop methods.
 type Component interface {
 	// Id is the unique identifier for the component.
@@ -41,27 +55,78 @@ type Component interface {
 	State() ComponentState
 }
 
+// ContextComponent is a Component whose start and stop can be abandoned once a context is done.
+type ContextComponent interface {
+	Component
+	// StartCtx will start the LifeCycle, failing the component if it does not start before the context is done.
+	StartCtx(ctx context.Context) error
+	// StopCtx will stop the LifeCycle, failing the component if it does not stop before the context is done.
+	StopCtx(ctx context.Context) error
+}
+
 // ComponentManager is the interface that manages multiple components.
 type ComponentManager interface {
+	// AddDependency will register that the component with the given id depends on the components with the dependsOn ids.
+	// StartAll starts a component once its dependencies are running and StopAll stops it before its dependencies.
+	// It returns ErrCyclicDependency naming the cycle if a component would depend on itself.
+	AddDependency(id string, dependsOn ...string) error
+	// DependencyGraph will return the ids of the components each component depends on.
+	DependencyGraph() map[string][]string
+	// ExportDot will write the dependency graph in the Graphviz dot format.
+	ExportDot(w io.Writer) error
 	// GetState will return the current state of the LifeCycle for the component with the given id.
 	GetState(id string) ComponentState
 	//List will return a list of all the Components.
 	List() []Component
+	// OnShutdown will add a hook called by WaitForShutdown once the components are stopped.
+	OnShutdown(hook func())
 	// Register will register a new Components.
 	Register(component Component) Component
-	// StartAll will start all the Components. Returns the number of components started
+	// SetMaxParallel will cap the number of components started at the same time by StartAll. 0 removes the cap.
+	SetMaxParallel(n int)
+	// StartAll will start all the Components and wait up to the DefaultStartTimeout for them to be running, as
+	// StartAllCtx.
 	StartAll() error
+	// StartAllCtx will start all the Components and wait for them to be running. The components that fail to start,
+	// that do not start before the context is done or whose dependencies are not running are reported in the error.
+	StartAllCtx(ctx context.Context) error
+	// StartOrder will return the component ids in topological layers, every component depending only on the
+	// components of the earlier layers.
+	StartOrder() ([][]string, error)
 	//StartAndWait will start all the Components and wait for them to finish.
 	StartAndWait()
 	// Start will start the LifeCycle for the component with the given id.
 	// It returns an error if the component was not found or if the component failed to start.
 	Start(id string) error
-	// StopAll will stop all the Components.
+	// StopAll will stop all the Components, as StopAllCtx with the background context.
 	StopAll() error
+	// StopAllCtx will stop all the Components, continuing with the remaining components when one fails to stop.
+	// The components that fail to stop or do not stop before the context is done are reported in the error.
+	StopAllCtx(ctx context.Context) error
+	// Shutdown will make WaitForShutdown stop the components. Calling it again while they are stopping forces the
+	// shutdown.
+	Shutdown()
 	// Stop will stop the LifeCycle for the component with the given id. It returns if the component was stopped.
 	Stop(id string) error
 	// Unregister will unregister a Component.
 	Unregister(id string)
+	// SetShutdownGrace will set the time given to the components to stop by WaitForShutdown.
+	SetShutdownGrace(grace time.Duration)
+	// SetRestartPolicy will set the policy applied when the component with the given id fails to start or is
+	// unhealthy.
+	SetRestartPolicy(id string, policy RestartPolicy) error
+	// Info will return the state, the restart policy, the restarts and the last failure of the component with the
+	// given id.
+	Info(id string) (ComponentInfo, error)
+	// AddStateListener will add a listener called for each state transition of the components driven by the manager.
+	AddStateListener(listener StateListener)
+	// MonitorHealth will check the health of the components at each interval and restart them as per their restart
+	// policy until the context is done.
+	MonitorHealth(ctx context.Context, interval time.Duration)
 	// Wait will wait for all the Components to finish.
 	Wait()
+	// WaitForShutdown will block until one of the signals, SIGINT or SIGTERM by default, is received or Shutdown is
+	// called, then stop all the Components within the shutdown grace period and call the shutdown hooks.
+	// It returns ErrForcedShutdown if a second signal or Shutdown call forces the shutdown.
+	WaitForShutdown(signals ...os.Signal) error
 }
@@ -0,0 +1,104 @@
+package lifecycle
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// DependencyGraph returns the ids of the components each registered component depends on.
+func (scm *SimpleComponentManager) DependencyGraph() map[string][]string {
+	scm.cMutex.RLock()
+	defer scm.cMutex.RUnlock()
+	graph := make(map[string][]string, len(scm.order))
+	for _, id := range scm.order {
+		graph[id] = slices.Clone(scm.dependencies[id])
+	}
+	return graph
+}
+
+// StartOrder returns the component ids in topological layers: every component depends only on components of the
+// earlier layers. StartAll starts a component as soon as its dependencies are running, so the components of a layer
+// start in parallel. The ids of a layer retain the order of registration.
+func (scm *SimpleComponentManager) StartOrder() ([][]string, error) {
+	scm.cMutex.RLock()
+	defer scm.cMutex.RUnlock()
+	return scm.startLayers()
+}
+
+// startLayers returns the topological layers of the components. It is called with the cMutex held.
+func (scm *SimpleComponentManager) startLayers() (layers [][]string, err error) {
+	layerOf := make(map[string]int, len(scm.order))
+	for placed := 0; placed < len(scm.order); {
+		var layer []string
+		for _, id := range scm.order {
+			if _, done := layerOf[id]; done {
+				continue
+			}
+			ready := true
+			for _, dependency := range scm.dependencies[id] {
+				if l, done := layerOf[dependency]; !done || l == len(layers) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				layer = append(layer, id)
+				layerOf[id] = len(layers)
+			}
+		}
+		if len(layer) == 0 {
+			err = ErrCyclicDependency
+			return
+		}
+		placed += len(layer)
+		layers = append(layers, layer)
+	}
+	return
+}
+
+// ExportDot writes the dependency graph in the Graphviz dot format, with an edge from each component to the
+// components it depends on.
+func (scm *SimpleComponentManager) ExportDot(w io.Writer) (err error) {
+	scm.cMutex.RLock()
+	defer scm.cMutex.RUnlock()
+	var sb strings.Builder
+	sb.WriteString("digraph components {\n")
+	for _, id := range scm.order {
+		sb.WriteString(fmt.Sprintf("\t%q;\n", id))
+	}
+	for _, id := range scm.order {
+		for _, dependency := range scm.dependencies[id] {
+			sb.WriteString(fmt.Sprintf("\t%q -> %q;\n", id, dependency))
+		}
+	}
+	sb.WriteString("}\n")
+	_, err = io.WriteString(w, sb.String())
+	return
+}
+
+// SetMaxParallel caps the number of components started at the same time by StartAll. 0 removes the cap.
+func (scm *SimpleComponentManager) SetMaxParallel(n int) {
+	scm.cMutex.Lock()
+	defer scm.cMutex.Unlock()
+	scm.maxParallel = n
+}
+
+// dependencyPath returns the path of the dependencies from the component with the id to the target, nil if the
+// component does not depend on the target. It is called with the cMutex held.
+func (scm *SimpleComponentManager) dependencyPath(id, target string, visited map[string]bool) []string {
+	if id == target {
+		return []string{id}
+	}
+	if visited[id] {
+		return nil
+	}
+	visited[id] = true
+	for _, dependency := range scm.dependencies[id] {
+		if path := scm.dependencyPath(dependency, target, visited); path != nil {
+			return append([]string{id}, path...)
+		}
+	}
+	return nil
+}
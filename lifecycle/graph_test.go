@@ -0,0 +1,93 @@
+package lifecycle
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newGraphManager registers web -> api -> (db, cache), worker -> db and a standalone metrics component.
+func newGraphManager(t *testing.T) ComponentManager {
+	manager := NewSimpleComponentManager()
+	for _, id := range []string{"web", "api", "worker", "db", "cache", "metrics"} {
+		manager.Register(&SimpleComponent{CompId: id, StartFunc: func() error { return nil }})
+	}
+	for id, dependencies := range map[string][]string{"web": {"api"}, "api": {"db", "cache"}, "worker": {"db"}} {
+		if err := manager.AddDependency(id, dependencies...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manager
+}
+
+// TestSimpleComponentManager_StartOrder tests the topological layers of the components.
+func TestSimpleComponentManager_StartOrder(t *testing.T) {
+	manager := newGraphManager(t)
+	layers, err := manager.StartOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"db", "cache", "metrics"}, {"api", "worker"}, {"web"}}
+	if fmt.Sprint(layers) != fmt.Sprint(want) {
+		t.Errorf("StartOrder() = %v, want %v", layers, want)
+	}
+	graph := manager.DependencyGraph()
+	if len(graph) != 6 || fmt.Sprint(graph["api"]) != "[db cache]" || len(graph["db"]) != 0 {
+		t.Errorf("DependencyGraph() = %v", graph)
+	}
+	// the graph is a copy
+	graph["api"][0] = "changed"
+	if manager.DependencyGraph()["api"][0] != "db" {
+		t.Errorf("DependencyGraph() shares the dependencies")
+	}
+}
+
+// TestSimpleComponentManager_ExportDot tests the Graphviz export of the dependency graph.
+func TestSimpleComponentManager_ExportDot(t *testing.T) {
+	manager := newGraphManager(t)
+	var sb strings.Builder
+	if err := manager.ExportDot(&sb); err != nil {
+		t.Fatal(err)
+	}
+	dot := sb.String()
+	if !strings.HasPrefix(dot, "digraph components {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Errorf("ExportDot() = %s", dot)
+	}
+	for _, want := range []string{"\t\"metrics\";\n", "\t\"web\" -> \"api\";\n", "\t\"api\" -> \"db\";\n",
+		"\t\"api\" -> \"cache\";\n", "\t\"worker\" -> \"db\";\n"} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("ExportDot() = %s, want %q", dot, want)
+		}
+	}
+}
+
+// TestSimpleComponentManager_SetMaxParallel tests that StartAll does not start more components at the same time than
+// the cap.
+func TestSimpleComponentManager_SetMaxParallel(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var running, peak atomic.Int32
+	for i := 0; i < 6; i++ {
+		manager.Register(&SimpleComponent{CompId: fmt.Sprintf("c%d", i), StartFunc: func() error {
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		}})
+	}
+	manager.SetMaxParallel(2)
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	if peak.Load() != 2 {
+		t.Errorf("peak = %d, want 2", peak.Load())
+	}
+	for _, c := range manager.List() {
+		if c.State() != Running {
+			t.Errorf("%s state = %v", c.Id(), c.State())
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package lifecycle
+
+import (
+	"context"
+	"time"
+
+	"oss.nandlabs.io/golly/clients"
+)
+
+// RestartMode is the mode of a restart policy.
+type RestartMode int
+
+const (
+	// RestartNever does not restart the component. It is the default.
+	RestartNever RestartMode = iota
+	// RestartOnFailure retries a failing start and restarts an unhealthy component, up to the MaxRetries of the
+	// policy.
+	RestartOnFailure
+	// RestartAlways retries a failing start and restarts an unhealthy component without limit, and restarts the
+	// component when it fails or stops on its own.
+	RestartAlways
+)
+
+// RestartPolicy is the policy the manager applies when a component fails.
+type RestartPolicy struct {
+	// Mode is the mode of the policy.
+	Mode RestartMode
+	// Retry holds the maximum number of restarts of RestartOnFailure and the wait in milliseconds before each
+	// restart.
+	Retry clients.RetryInfo
+}
+
+// retries returns if the component is restarted after the given number of restarts.
+func (p RestartPolicy) retries(restarts int) bool {
+	switch p.Mode {
+	case RestartOnFailure:
+		return restarts < p.Retry.MaxRetries
+	case RestartAlways:
+		return true
+	}
+	return false
+}
+
+// HealthChecker is implemented by the components reporting their health.
+type HealthChecker interface {
+	// Health returns an error if the component is not healthy.
+	Health() error
+}
+
+// StateListener is the function called by the manager for each state transition of the components it starts, stops
+// or restarts.
+type StateListener func(id string, prevState, newState ComponentState)
+
+// ComponentInfo is the state of a component along with its restarts.
+type ComponentInfo struct {
+	// Id is the id of the component.
+	Id string
+	// State is the current state of the component.
+	State ComponentState
+	// Policy is the restart policy of the component.
+	Policy RestartPolicy
+	// Restarts is the number of times the component was restarted, including the retries of a failing start.
+	Restarts int
+	// LastFailure is the error of the last failed start or health check. nil if the component never failed.
+	LastFailure error
+	// LastFailureTime is the time of the last failure.
+	LastFailureTime time.Time
+}
+
+// componentInfo holds the restart state of a component.
+type componentInfo struct {
+	policy          RestartPolicy
+	restarts        int
+	lastFailure     error
+	lastFailureTime time.Time
+	// stopped is true if the component was stopped through the manager, so RestartAlways does not restart it.
+	stopped bool
+}
+
+// SetRestartPolicy sets the restart policy of the component with the given id.
+func (scm *SimpleComponentManager) SetRestartPolicy(id string, policy RestartPolicy) error {
+	scm.cMutex.RLock()
+	_, exists := scm.components[id]
+	scm.cMutex.RUnlock()
+	if !exists {
+		return ErrCompNotFound
+	}
+	scm.infoMutex.Lock()
+	defer scm.infoMutex.Unlock()
+	scm.info(id).policy = policy
+	return nil
+}
+
+// Info returns the state and the restarts of the component with the given id.
+func (scm *SimpleComponentManager) Info(id string) (info ComponentInfo, err error) {
+	scm.cMutex.RLock()
+	component, exists := scm.components[id]
+	scm.cMutex.RUnlock()
+	if !exists {
+		err = ErrCompNotFound
+		return
+	}
+	scm.infoMutex.Lock()
+	defer scm.infoMutex.Unlock()
+	ci := scm.info(id)
+	info = ComponentInfo{
+		Id:              id,
+		State:           component.State(),
+		Policy:          ci.policy,
+		Restarts:        ci.restarts,
+		LastFailure:     ci.lastFailure,
+		LastFailureTime: ci.lastFailureTime,
+	}
+	return
+}
+
+// AddStateListener adds a listener called for each state transition of the components started, stopped or
+// restarted by the manager.
+func (scm *SimpleComponentManager) AddStateListener(listener StateListener) {
+	scm.infoMutex.Lock()
+	defer scm.infoMutex.Unlock()
+	scm.listeners = append(scm.listeners, listener)
+}
+
+// MonitorHealth checks the components at each interval until the context is done. A running component whose
+// Health returns an error is restarted as per its policy, up to the MaxRetries for RestartOnFailure, and a component
+// with RestartAlways that failed or stopped on its own is restarted. The components depending on a restarted
+// component are stopped before it and started again after it. The components are not checked while StopAll is
+// stopping them.
+func (scm *SimpleComponentManager) MonitorHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scm.checkHealth(ctx)
+		}
+	}
+}
+
+// checkHealth restarts the components that are unhealthy or failed as per their policy.
+func (scm *SimpleComponentManager) checkHealth(ctx context.Context) {
+	scm.cMutex.RLock()
+	order, err := scm.startOrder()
+	components := make(map[string]Component, len(scm.components))
+	for id, component := range scm.components {
+		components[id] = component
+	}
+	scm.cMutex.RUnlock()
+	if err != nil {
+		return
+	}
+	for _, id := range order {
+		component := components[id]
+		scm.infoMutex.Lock()
+		policy, restarts, stopped, stopping := scm.info(id).policy, scm.info(id).restarts, scm.info(id).stopped,
+			scm.stopping
+		scm.infoMutex.Unlock()
+		if stopping || ctx.Err() != nil {
+			return
+		}
+		switch state := component.State(); {
+		case state == Running:
+			checker, ok := component.(HealthChecker)
+			if !ok || policy.Mode == RestartNever {
+				continue
+			}
+			if e := checker.Health(); e != nil {
+				logger.ErrorF("Component %s is unhealthy: %v", id, e)
+				scm.recordFailure(id, e)
+				if !policy.retries(restarts) {
+					logger.ErrorF("Not restarting component %s after %d restarts", id, restarts)
+					continue
+				}
+				scm.restart(ctx, id)
+			}
+		case policy.Mode == RestartAlways && (state == Error || state == Failed || state == Stopped && !stopped) &&
+			scm.dependenciesRunning(id):
+			logger.InfoF("Restarting component %s in state %d", id, state)
+			scm.restart(ctx, id)
+		}
+	}
+}
+
+// restart stops the component with the given id after the running components depending on it, starts it again as
+// per its policy and then starts the stopped dependents in the dependency order.
+func (scm *SimpleComponentManager) restart(ctx context.Context, id string) {
+	scm.cMutex.RLock()
+	order, err := scm.startOrder()
+	if err != nil {
+		scm.cMutex.RUnlock()
+		return
+	}
+	affected := map[string]bool{id: true}
+	var dependents []Component
+	for _, cid := range order {
+		for _, dependency := range scm.dependencies[cid] {
+			if affected[dependency] && !affected[cid] {
+				affected[cid] = true
+				dependents = append(dependents, scm.components[cid])
+			}
+		}
+	}
+	component := scm.components[id]
+	scm.cMutex.RUnlock()
+
+	var restart []Component
+	for i := len(dependents) - 1; i >= 0; i-- {
+		if dependents[i].State() == Running {
+			if e := scm.stopOnce(ctx, dependents[i]); e != nil {
+				logger.ErrorF("Error stopping component %s: %v", dependents[i].Id(), e)
+			}
+			restart = append([]Component{dependents[i]}, restart...)
+		}
+	}
+	if component.State() == Running {
+		if e := scm.stopOnce(ctx, component); e != nil {
+			logger.ErrorF("Error stopping component %s: %v", id, e)
+		}
+	}
+	scm.infoMutex.Lock()
+	scm.info(id).restarts++
+	wait := scm.info(id).policy.Retry.Wait
+	scm.infoMutex.Unlock()
+	if !sleep(ctx, wait) {
+		return
+	}
+	if err = scm.startWithPolicy(ctx, component); err != nil {
+		logger.ErrorF("Not restarting the dependents of component %s: %v", id, err)
+		return
+	}
+	for _, dependent := range restart {
+		if e := scm.startWithPolicy(ctx, dependent); e != nil {
+			logger.ErrorF("Error restarting component %s: %v", dependent.Id(), e)
+		}
+	}
+}
+
+// dependenciesRunning returns true if the components the component with the given id depends on are running.
+func (scm *SimpleComponentManager) dependenciesRunning(id string) bool {
+	scm.cMutex.RLock()
+	defer scm.cMutex.RUnlock()
+	for _, dependency := range scm.dependencies[id] {
+		if scm.components[dependency].State() != Running {
+			return false
+		}
+	}
+	return true
+}
+
+// startWithPolicy starts the component, retrying the failing starts as per its restart policy.
+func (scm *SimpleComponentManager) startWithPolicy(ctx context.Context, component Component) (err error) {
+	id := component.Id()
+	scm.infoMutex.Lock()
+	scm.info(id).stopped = false
+	policy := scm.info(id).policy
+	scm.infoMutex.Unlock()
+	for attempt := 0; ; attempt++ {
+		if err = scm.startOnce(ctx, component); err == nil {
+			return
+		}
+		scm.recordFailure(id, err)
+		if !policy.retries(attempt) || ctx.Err() != nil {
+			return
+		}
+		logger.InfoF("Retrying the start of component %s after %d ms", id, policy.Retry.Wait)
+		if !sleep(ctx, policy.Retry.Wait) {
+			return
+		}
+		scm.infoMutex.Lock()
+		scm.info(id).restarts++
+		scm.infoMutex.Unlock()
+	}
+}
+
+// startOnce starts the component and notifies the listeners of its transitions.
+func (scm *SimpleComponentManager) startOnce(ctx context.Context, component Component) (err error) {
+	prevState := component.State()
+	scm.notify(component.Id(), prevState, Starting)
+	err = startComponent(ctx, component)
+	if state := component.State(); err != nil && state == Starting {
+		scm.notify(component.Id(), Starting, Error)
+	} else {
+		scm.notify(component.Id(), Starting, state)
+	}
+	return
+}
+
+// stopOnce stops the component and notifies the listeners of its transitions.
+func (scm *SimpleComponentManager) stopOnce(ctx context.Context, component Component) (err error) {
+	scm.notify(component.Id(), component.State(), Stopping)
+	err = stopComponent(ctx, component)
+	scm.notify(component.Id(), Stopping, component.State())
+	return
+}
+
+// recordFailure records the error as the last failure of the component.
+func (scm *SimpleComponentManager) recordFailure(id string, err error) {
+	scm.infoMutex.Lock()
+	defer scm.infoMutex.Unlock()
+	info := scm.info(id)
+	info.lastFailure = err
+	info.lastFailureTime = time.Now()
+}
+
+// notify calls the listeners with the transition. The transitions to the same state are skipped.
+func (scm *SimpleComponentManager) notify(id string, prevState, newState ComponentState) {
+	if prevState == newState {
+		return
+	}
+	scm.infoMutex.Lock()
+	listeners := scm.listeners
+	scm.infoMutex.Unlock()
+	for _, listener := range listeners {
+		listener(id, prevState, newState)
+	}
+}
+
+// info returns the restart state of the component, creating it if needed. It is called with the infoMutex held.
+func (scm *SimpleComponentManager) info(id string) *componentInfo {
+	info, ok := scm.infos[id]
+	if !ok {
+		info = &componentInfo{}
+		scm.infos[id] = info
+	}
+	return info
+}
+
+// sleep waits for the milliseconds and returns false if the context is done before.
+func sleep(ctx context.Context, millis int) bool {
+	if millis <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(time.Duration(millis) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
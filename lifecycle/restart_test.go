@@ -0,0 +1,215 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/clients"
+)
+
+// transitionRecorder records the transitions notified by the manager.
+type transitionRecorder struct {
+	mutex       sync.Mutex
+	transitions []string
+}
+
+func (r *transitionRecorder) listen(id string, prevState, newState ComponentState) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.transitions = append(r.transitions, fmt.Sprintf("%s:%d>%d", id, prevState, newState))
+}
+
+func (r *transitionRecorder) recorded() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.transitions...)
+}
+
+// TestSimpleComponentManager_RestartOnFailure tests that a flaky component is started on the third attempt.
+func TestSimpleComponentManager_RestartOnFailure(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	attempts := 0
+	flaky := &SimpleComponent{
+		CompId: "flaky",
+		StartFunc: func() error {
+			attempts++
+			if attempts < 3 {
+				return fmt.Errorf("attempt %d failed", attempts)
+			}
+			return nil
+		},
+	}
+	manager.Register(flaky)
+	recorder := &transitionRecorder{}
+	manager.AddStateListener(recorder.listen)
+	if err := manager.SetRestartPolicy("flaky", RestartPolicy{
+		Mode:  RestartOnFailure,
+		Retry: clients.RetryInfo{MaxRetries: 2, Wait: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.StartAll(); err != nil {
+		t.Fatalf("StartAll() error = %v", err)
+	}
+	info, err := manager.Info("flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != Running || info.Restarts != 2 || info.LastFailure == nil ||
+		info.LastFailure.Error() != "attempt 2 failed" || info.LastFailureTime.IsZero() {
+		t.Errorf("Info() = %+v", info)
+	}
+	want := []string{"flaky:0>5", "flaky:5>1", "flaky:1>5", "flaky:5>1", "flaky:1>5", "flaky:5>4"}
+	if got := recorder.recorded(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+	if _, err = manager.Info("unknown"); err != ErrCompNotFound {
+		t.Errorf("Info() error = %v, want %v", err, ErrCompNotFound)
+	}
+	if err = manager.SetRestartPolicy("unknown", RestartPolicy{}); err != ErrCompNotFound {
+		t.Errorf("SetRestartPolicy() error = %v, want %v", err, ErrCompNotFound)
+	}
+}
+
+// TestSimpleComponentManager_RestartExhausted tests that the start fails once the retries of the policy are
+// exhausted and that a component without a policy is not retried.
+func TestSimpleComponentManager_RestartExhausted(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var attempts, noPolicyAttempts int
+	manager.Register(&SimpleComponent{CompId: "broken", StartFunc: func() error {
+		attempts++
+		return errors.New("broken")
+	}})
+	manager.Register(&SimpleComponent{CompId: "plain", StartFunc: func() error {
+		noPolicyAttempts++
+		return errors.New("plain")
+	}})
+	_ = manager.SetRestartPolicy("broken", RestartPolicy{Mode: RestartOnFailure, Retry: clients.RetryInfo{MaxRetries: 1}})
+	if err := manager.StartAll(); err == nil {
+		t.Fatal("StartAll() succeeded")
+	}
+	info, _ := manager.Info("broken")
+	if attempts != 2 || noPolicyAttempts != 1 || info.Restarts != 1 || info.State != Error {
+		t.Errorf("attempts = %d, %d, info = %+v", attempts, noPolicyAttempts, info)
+	}
+}
+
+// TestSimpleComponentManager_MonitorHealth tests that an unhealthy component is restarted after stopping its
+// dependents, which are started again after it.
+func TestSimpleComponentManager_MonitorHealth(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var healthy atomic.Bool
+	healthy.Store(true)
+	db := &SimpleComponent{
+		CompId:    "db",
+		StartFunc: func() error { return nil },
+		StopFunc:  func() error { return nil },
+		HealthFunc: func() error {
+			if !healthy.Load() {
+				healthy.Store(true)
+				return errors.New("connection lost")
+			}
+			return nil
+		},
+	}
+	api := &SimpleComponent{
+		CompId:    "api",
+		StartFunc: func() error { return nil },
+		StopFunc:  func() error { return nil },
+	}
+	manager.Register(api)
+	manager.Register(db)
+	_ = manager.AddDependency("api", "db")
+	_ = manager.SetRestartPolicy("db", RestartPolicy{Mode: RestartOnFailure, Retry: clients.RetryInfo{MaxRetries: 3}})
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	recorder := &transitionRecorder{}
+	manager.AddStateListener(recorder.listen)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		manager.MonitorHealth(ctx, 5*time.Millisecond)
+	}()
+	healthy.Store(false)
+	for deadline := time.Now().Add(5 * time.Second); len(recorder.recorded()) < 8; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("db was not restarted: %v", recorder.recorded())
+		}
+	}
+	cancel()
+	<-done
+	want := []string{"api:4>3", "api:3>2", "db:4>3", "db:3>2", "db:2>5", "db:5>4", "api:2>5", "api:5>4"}
+	if got := recorder.recorded(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+	info, _ := manager.Info("db")
+	if info.Restarts != 1 || info.LastFailure == nil || info.LastFailure.Error() != "connection lost" {
+		t.Errorf("Info() = %+v", info)
+	}
+	if api.State() != Running || db.State() != Running {
+		t.Errorf("states = %v, %v", api.State(), db.State())
+	}
+}
+
+// TestSimpleComponentManager_MonitorHealthExhausted tests that an unhealthy component with RestartOnFailure is no
+// longer restarted once it was restarted MaxRetries times.
+func TestSimpleComponentManager_MonitorHealthExhausted(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var starts atomic.Int32
+	db := &SimpleComponent{
+		CompId:     "db",
+		StartFunc:  func() error { starts.Add(1); return nil },
+		StopFunc:   func() error { return nil },
+		HealthFunc: func() error { return errors.New("connection lost") },
+	}
+	manager.Register(db)
+	_ = manager.SetRestartPolicy("db", RestartPolicy{Mode: RestartOnFailure, Retry: clients.RetryInfo{MaxRetries: 2}})
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	scm := manager.(*SimpleComponentManager)
+	for i := 0; i < 5; i++ {
+		scm.checkHealth(context.Background())
+	}
+	info, _ := manager.Info("db")
+	if starts.Load() != 3 || info.Restarts != 2 || db.State() != Running {
+		t.Errorf("starts = %d, Info() = %+v", starts.Load(), info)
+	}
+}
+
+// TestSimpleComponentManager_RestartAlways tests that a component with RestartAlways is restarted when it fails on
+// its own but not when it is stopped through the manager.
+func TestSimpleComponentManager_RestartAlways(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var starts atomic.Int32
+	worker := &SimpleComponent{
+		CompId:    "worker",
+		StartFunc: func() error { starts.Add(1); return nil },
+		StopFunc:  func() error { return nil },
+	}
+	manager.Register(worker)
+	_ = manager.SetRestartPolicy("worker", RestartPolicy{Mode: RestartAlways})
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	scm := manager.(*SimpleComponentManager)
+	worker.CompState = Error
+	scm.checkHealth(context.Background())
+	if starts.Load() != 2 || worker.State() != Running {
+		t.Errorf("starts = %d, state = %v", starts.Load(), worker.State())
+	}
+	if err := manager.Stop("worker"); err != nil {
+		t.Fatal(err)
+	}
+	scm.checkHealth(context.Background())
+	if starts.Load() != 2 || worker.State() != Stopped {
+		t.Errorf("starts = %d, state = %v", starts.Load(), worker.State())
+	}
+}
@@ -0,0 +1,83 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownGrace is the default time given to the components to stop by WaitForShutdown.
+const DefaultShutdownGrace = 30 * time.Second
+
+var ErrForcedShutdown = errors.New("shutdown forced before the components stopped")
+
+// SetShutdownGrace sets the time given to the components to stop by WaitForShutdown.
+func (scm *SimpleComponentManager) SetShutdownGrace(grace time.Duration) {
+	scm.infoMutex.Lock()
+	defer scm.infoMutex.Unlock()
+	scm.shutdownGrace = grace
+}
+
+// OnShutdown adds a hook called by WaitForShutdown once the components are stopped, such as flushing the logs.
+// The hooks are called in the order they are added.
+func (scm *SimpleComponentManager) OnShutdown(hook func()) {
+	scm.infoMutex.Lock()
+	defer scm.infoMutex.Unlock()
+	scm.shutdownHooks = append(scm.shutdownHooks, hook)
+}
+
+// Shutdown makes WaitForShutdown stop the components, as if it received a signal. Calling it again while the
+// components are stopping forces the shutdown.
+func (scm *SimpleComponentManager) Shutdown() {
+	select {
+	case scm.shutdownChan <- struct{}{}:
+	default:
+	}
+}
+
+// WaitForShutdown blocks until one of the signals, SIGINT or SIGTERM if none are given, is received or Shutdown is
+// called. It then stops all the components within the shutdown grace period and calls the shutdown hooks. A second
+// signal or call to Shutdown while the components are stopping abandons them and returns ErrForcedShutdown without
+// calling the hooks, leaving the exit to the caller. The errors of the components that failed to stop are returned.
+func (scm *SimpleComponentManager) WaitForShutdown(signals ...os.Signal) (err error) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, signals...)
+	defer signal.Stop(signalChan)
+	select {
+	case sig := <-signalChan:
+		logger.InfoF("Received the signal %v, shutting down", sig)
+	case <-scm.shutdownChan:
+		logger.InfoF("Shutting down")
+	}
+	scm.infoMutex.Lock()
+	grace := scm.shutdownGrace
+	scm.infoMutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- scm.StopAllCtx(ctx)
+	}()
+	select {
+	case err = <-stopped:
+	case sig := <-signalChan:
+		logger.ErrorF("Received the signal %v while shutting down, forcing the shutdown", sig)
+		return ErrForcedShutdown
+	case <-scm.shutdownChan:
+		logger.ErrorF("Forcing the shutdown")
+		return ErrForcedShutdown
+	}
+	scm.infoMutex.Lock()
+	hooks := append([]func(){}, scm.shutdownHooks...)
+	scm.infoMutex.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	return
+}
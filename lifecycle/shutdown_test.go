@@ -0,0 +1,151 @@
+package lifecycle
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSimpleComponentManager_WaitForShutdown tests that Shutdown stops the components and then calls the hooks in
+// their order.
+func TestSimpleComponentManager_WaitForShutdown(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	for _, id := range []string{"db", "api"} {
+		manager.Register(&SimpleComponent{
+			CompId:    id,
+			StartFunc: func() error { return nil },
+			StopFunc: func() error {
+				record("stop:" + id)
+				return nil
+			},
+		})
+	}
+	if err := manager.AddDependency("api", "db"); err != nil {
+		t.Fatal(err)
+	}
+	manager.OnShutdown(func() { record("hook:flush") })
+	manager.OnShutdown(func() { record("hook:close") })
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	go manager.Shutdown()
+	if err := manager.WaitForShutdown(); err != nil {
+		t.Fatalf("WaitForShutdown() error = %v", err)
+	}
+	want := []string{"stop:api", "stop:db", "hook:flush", "hook:close"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+// TestSimpleComponentManager_ForcedShutdown tests that a second Shutdown returns ErrForcedShutdown without waiting
+// for the components or calling the hooks.
+func TestSimpleComponentManager_ForcedShutdown(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	release := make(chan struct{})
+	defer close(release)
+	stopping := make(chan struct{})
+	manager.Register(&SimpleComponent{
+		CompId:    "stuck",
+		StartFunc: func() error { return nil },
+		StopFunc: func() error {
+			close(stopping)
+			<-release
+			return nil
+		},
+	})
+	hooked := false
+	manager.OnShutdown(func() { hooked = true })
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		manager.Shutdown()
+		<-stopping
+		manager.Shutdown()
+	}()
+	start := time.Now()
+	if err := manager.WaitForShutdown(); err != ErrForcedShutdown {
+		t.Errorf("WaitForShutdown() error = %v, want %v", err, ErrForcedShutdown)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || hooked {
+		t.Errorf("WaitForShutdown() took %v, hooked = %v", elapsed, hooked)
+	}
+}
+
+// TestSimpleComponentManager_ShutdownGrace tests that the components not stopping within the grace period are
+// reported and the hooks are still called.
+func TestSimpleComponentManager_ShutdownGrace(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	release := make(chan struct{})
+	defer close(release)
+	manager.Register(&SimpleComponent{
+		CompId:    "slow",
+		StartFunc: func() error { return nil },
+		StopFunc: func() error {
+			<-release
+			return nil
+		},
+	})
+	hooked := false
+	manager.OnShutdown(func() { hooked = true })
+	manager.SetShutdownGrace(50 * time.Millisecond)
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	manager.Shutdown()
+	if err := manager.WaitForShutdown(); err == nil || !hooked {
+		t.Errorf("WaitForShutdown() error = %v, hooked = %v", err, hooked)
+	}
+}
+
+// TestSimpleComponentManager_StopAllTwice tests that WaitForShutdown can be combined with StopAll and that the calls
+// after the first one stop nothing.
+func TestSimpleComponentManager_StopAllTwice(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var stops sync.WaitGroup
+	var count int
+	var mutex sync.Mutex
+	manager.Register(&SimpleComponent{
+		CompId:    "db",
+		StartFunc: func() error { return nil },
+		StopFunc: func() error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			count++
+			return nil
+		},
+	})
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	stops.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer stops.Done()
+			if err := manager.StopAll(); err != nil {
+				t.Errorf("StopAll() error = %v", err)
+			}
+		}()
+	}
+	go manager.Shutdown()
+	if err := manager.WaitForShutdown(); err != nil {
+		t.Fatalf("WaitForShutdown() error = %v", err)
+	}
+	stops.Wait()
+	if err := manager.StopAll(); err != nil {
+		t.Errorf("StopAll() error = %v", err)
+	}
+	manager.Wait()
+	if count != 1 {
+		t.Errorf("stopped %d times, want 1", count)
+	}
+}
@@ -1,10 +1,15 @@
 package lifecycle
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"oss.nandlabs.io/golly/errutils"
 )
@@ -22,8 +27,10 @@ type SimpleComponent struct {
 	AfterStop func(err error)
 	// BeforeStop is the function that will be called before the component is stopped.
 	BeforeStop func()
-	// CompState is the current state of the component.
+	// CompState is the current state of the component. Once the component is in use, the state is read with State.
 	CompState ComponentState
+	// stateMutex guards the CompState as the managers read it while the component starts or stops.
+	stateMutex sync.RWMutex
 	// OnStateChange is the function that will be called when the component state changes.
 	OnStateChange func(prevState, newState ComponentState)
 	//StartFunc is the function that will be called when the component is started.
@@ -32,6 +39,15 @@ type SimpleComponent struct {
 	// StopFunc is the function that will be called when the component is stopped.
 	// It returns an error if the component failed to stop.
 	StopFunc func() error
+	// StartTimeout is the maximum duration of the StartFunc. The component is Failed if the StartFunc does not
+	// return in time. 0 waits for the StartFunc to return.
+	StartTimeout time.Duration
+	// StopTimeout is the maximum duration of the StopFunc. The component is Failed if the StopFunc does not
+	// return in time. 0 waits for the StopFunc to return.
+	StopTimeout time.Duration
+	// HealthFunc is the function that will be called to check the health of the running component.
+	// It returns an error if the component is not healthy.
+	HealthFunc func() error
 }
 
 // ComponentId is the unique identifier for the component.
@@ -52,18 +68,29 @@ func (sc *SimpleComponent) OnChange(prevState, newState ComponentState) {
 }
 
 // Start will starting the LifeCycle.
-func (sc *SimpleComponent) Start() (err error) {
+func (sc *SimpleComponent) Start() error {
+	return sc.StartCtx(context.Background())
+}
+
+// StartCtx will start the LifeCycle. The component is Failed if the StartFunc does not return before the context is
+// done or the StartTimeout elapsed.
+func (sc *SimpleComponent) StartCtx(ctx context.Context) (err error) {
 	if sc.StartFunc != nil {
-		sc.OnChange(sc.CompState, Starting)
-		sc.CompState = Starting
-		err = sc.StartFunc()
-		if err != nil {
-			sc.CompState = Error
+		sc.OnChange(sc.State(), Starting)
+		sc.setState(Starting)
+		var abandoned bool
+		var state ComponentState
+		err, abandoned = callWithin(ctx, sc.StartTimeout, sc.StartFunc)
+		if abandoned {
+			state = Failed
+		} else if err != nil {
+			state = Error
 		} else {
-			sc.CompState = Running
+			state = Running
 		}
+		sc.setState(state)
 		if sc.OnStateChange != nil {
-			sc.OnStateChange(Starting, sc.CompState)
+			sc.OnStateChange(Starting, state)
 		}
 		if sc.AfterStart != nil {
 			sc.AfterStart(err)
@@ -74,18 +101,29 @@ func (sc *SimpleComponent) Start() (err error) {
 }
 
 // Stop will stop the LifeCycle.
-func (sc *SimpleComponent) Stop() (err error) {
+func (sc *SimpleComponent) Stop() error {
+	return sc.StopCtx(context.Background())
+}
+
+// StopCtx will stop the LifeCycle. The component is Failed if the StopFunc does not return before the context is
+// done or the StopTimeout elapsed.
+func (sc *SimpleComponent) StopCtx(ctx context.Context) (err error) {
 	if sc.StopFunc != nil {
-		sc.OnChange(sc.CompState, Stopping)
-		sc.CompState = Stopping
-		err = sc.StopFunc()
-		if err != nil {
-			sc.CompState = Error
+		sc.OnChange(sc.State(), Stopping)
+		sc.setState(Stopping)
+		var abandoned bool
+		var state ComponentState
+		err, abandoned = callWithin(ctx, sc.StopTimeout, sc.StopFunc)
+		if abandoned {
+			state = Failed
+		} else if err != nil {
+			state = Error
 		} else {
-			sc.CompState = Stopped
+			state = Stopped
 		}
+		sc.setState(state)
 		if sc.OnStateChange != nil {
-			sc.OnStateChange(Stopping, sc.CompState)
+			sc.OnStateChange(Stopping, state)
 		}
 		if sc.AfterStop != nil {
 			sc.AfterStop(err)
@@ -96,18 +134,96 @@ func (sc *SimpleComponent) Stop() (err error) {
 	return
 }
 
+// callWithin calls the function and returns its error, or abandons the call once the context is done or the timeout
+// elapsed. The function keeps running in the background when it is abandoned.
+func callWithin(ctx context.Context, timeout time.Duration, fn func() error) (err error, abandoned bool) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		err = fn()
+		return
+	}
+	if ctx.Err() == nil {
+		result := make(chan error, 1)
+		go func() {
+			result <- fn()
+		}()
+		select {
+		case err = <-result:
+			return
+		case <-ctx.Done():
+		}
+	}
+	err, abandoned = contextError(ctx), true
+	return
+}
+
+// contextError returns the error of the done context, wrapped with ErrCompTimeout if its deadline exceeded.
+func contextError(ctx context.Context) (err error) {
+	err = ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: %w", ErrCompTimeout, err)
+	}
+	return
+}
+
 // State will return the current state of the LifeCycle.
 func (sc *SimpleComponent) State() ComponentState {
+	sc.stateMutex.RLock()
+	defer sc.stateMutex.RUnlock()
 	return sc.CompState
 }
 
+// setState sets the current state of the LifeCycle.
+func (sc *SimpleComponent) setState(state ComponentState) {
+	sc.stateMutex.Lock()
+	defer sc.stateMutex.Unlock()
+	sc.CompState = state
+}
+
+// Health returns the error of the HealthFunc, nil if the component has no HealthFunc.
+func (sc *SimpleComponent) Health() error {
+	if sc.HealthFunc != nil {
+		return sc.HealthFunc()
+	}
+	return nil
+}
+
 // SimpleComponentManager is the struct that manages the component.
 type SimpleComponentManager struct {
 	components map[string]Component
-	cMutex     *sync.RWMutex
-	waitChan   chan struct{}
+	// dependencies holds the ids of the components each component depends on.
+	dependencies map[string][]string
+	// order holds the component ids in the order of registration.
+	order    []string
+	cMutex   *sync.RWMutex
+	waitChan chan struct{}
+	// waitOnce closes the waitChan once the components are stopped for the first time.
+	waitOnce sync.Once
+	// infoMutex guards the restart state of the components, the listeners, the stopping flag and the shutdown
+	// settings.
+	infoMutex sync.Mutex
+	infos     map[string]*componentInfo
+	listeners []StateListener
+	// stopping is true while StopAll stops the components, so they are not restarted.
+	stopping bool
+	// maxParallel caps the number of components started at the same time, 0 if there is no cap.
+	maxParallel int
+	// shutdownChan receives the calls of Shutdown.
+	shutdownChan  chan struct{}
+	shutdownGrace time.Duration
+	shutdownHooks []func()
 }
 
+// stateCheckInterval is the interval at which the state of a starting component is checked.
+const stateCheckInterval = 10 * time.Millisecond
+
+// DefaultStartTimeout is the time given to the components to be running by StartAll.
+const DefaultStartTimeout = time.Minute
+
 // GetState will return the current state of the LifeCycle for the component with the given id.
 func (scm *SimpleComponentManager) GetState(id string) ComponentState {
 	scm.cMutex.RLock()
@@ -140,19 +256,156 @@ func (scm *SimpleComponentManager) Register(component Component) Component {
 	oldComponent, exists := scm.components[component.Id()]
 	if !exists {
 		scm.components[component.Id()] = component
+		scm.order = append(scm.order, component.Id())
 	}
 	return oldComponent
 }
 
-// StartAll will start all the Components. Returns the number of components started
+// AddDependency will register that the component with the given id depends on the components with the dependsOn ids.
+// It returns ErrCyclicDependency naming the cycle if a component would depend on itself, and then adds none of the
+// dependencies.
+func (scm *SimpleComponentManager) AddDependency(id string, dependsOn ...string) error {
+	scm.cMutex.Lock()
+	defer scm.cMutex.Unlock()
+	if _, exists := scm.components[id]; !exists {
+		return ErrCompNotFound
+	}
+	for _, dependency := range dependsOn {
+		if _, exists := scm.components[dependency]; !exists {
+			return ErrCompNotFound
+		}
+	}
+	for _, dependency := range dependsOn {
+		if path := scm.dependencyPath(dependency, id, map[string]bool{}); path != nil {
+			return fmt.Errorf("%w: %s", ErrCyclicDependency, strings.Join(append([]string{id}, path...), " -> "))
+		}
+	}
+	for _, dependency := range dependsOn {
+		known := false
+		for _, d := range scm.dependencies[id] {
+			if d == dependency {
+				known = true
+				break
+			}
+		}
+		if !known {
+			scm.dependencies[id] = append(scm.dependencies[id], dependency)
+		}
+	}
+	return nil
+}
+
+// startOrder returns the component ids ordered such that every component comes after its dependencies.
+// Components without any dependency between them retain the order of registration.
+func (scm *SimpleComponentManager) startOrder() (order []string, err error) {
+	pending := make(map[string]int, len(scm.order))
+	dependents := make(map[string][]string)
+	for _, id := range scm.order {
+		pending[id] = len(scm.dependencies[id])
+		for _, dependency := range scm.dependencies[id] {
+			dependents[dependency] = append(dependents[dependency], id)
+		}
+	}
+	for len(order) < len(scm.order) {
+		progressed := false
+		for _, id := range scm.order {
+			if pending[id] == 0 {
+				order = append(order, id)
+				pending[id] = -1
+				progressed = true
+				for _, dependent := range dependents[id] {
+					pending[dependent]--
+				}
+			}
+		}
+		if !progressed {
+			err = ErrCyclicDependency
+			return
+		}
+	}
+	return
+}
+
+// StartAll will start all the Components and wait up to the DefaultStartTimeout for them to be running.
+// A component is started once all the components it depends on are running. A failing start is retried as per the
+// restart policy of the component. A component that is not running once the DefaultStartTimeout elapsed, such as one
+// whose Start blocks without reporting Running, is reported in the returned error. Use StartAllCtx for another bound.
 func (scm *SimpleComponentManager) StartAll() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStartTimeout)
+	defer cancel()
+	return scm.StartAllCtx(ctx)
+}
+
+// StartAllCtx will start all the Components and wait for them to be running or the context to be done.
+// A component is started once all the components it depends on are running. The components that fail to start, do not
+// start before the context is done or whose dependencies are not running are reported in the returned error.
+func (scm *SimpleComponentManager) StartAllCtx(ctx context.Context) error {
 	var err *errutils.MultiError = errutils.NewMultiErr(nil)
-	for id := range scm.components {
-		e := scm.Start(id)
-		if e != nil {
-			err.Add(e)
+	scm.infoMutex.Lock()
+	scm.stopping = false
+	scm.infoMutex.Unlock()
+	scm.cMutex.Lock()
+	order, e := scm.startOrder()
+	if e != nil {
+		scm.cMutex.Unlock()
+		return e
+	}
+	started := make(map[string]chan struct{}, len(order))
+	var slots chan struct{}
+	if scm.maxParallel > 0 {
+		slots = make(chan struct{}, scm.maxParallel)
+	}
+	wg := &sync.WaitGroup{}
+	for _, id := range order {
+		component := scm.components[id]
+		done := make(chan struct{})
+		started[id] = done
+		if component.State() == Running {
+			err.Add(ErrCompAlreadyStarted)
+			close(done)
+			continue
 		}
+		dependencies := make(map[string]Component, len(scm.dependencies[id]))
+		waitFor := make([]chan struct{}, 0, len(scm.dependencies[id]))
+		for _, dependency := range scm.dependencies[id] {
+			dependencies[dependency] = scm.components[dependency]
+			waitFor = append(waitFor, started[dependency])
+		}
+		wg.Add(1)
+		go func(c Component, done chan struct{}) {
+			defer wg.Done()
+			defer close(done)
+			for _, ch := range waitFor {
+				select {
+				case <-ch:
+				case <-ctx.Done():
+					err.Add(fmt.Errorf("component %s not started: %w", c.Id(), contextError(ctx)))
+					return
+				}
+			}
+			for depId, dependency := range dependencies {
+				if dependency.State() != Running {
+					logger.ErrorF("Not starting component %s as the dependency %s is not running", c.Id(), depId)
+					err.Add(fmt.Errorf("component %s not started: %w: %s", c.Id(), ErrDependencyNotRunning, depId))
+					return
+				}
+			}
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					err.Add(fmt.Errorf("component %s not started: %w", c.Id(), contextError(ctx)))
+					return
+				}
+			}
+			if e := scm.startWithPolicy(ctx, c); e != nil {
+				err.Add(fmt.Errorf("component %s: %w", c.Id(), e))
+			}
+		}(component, done)
 	}
+	scm.cMutex.Unlock()
+	wg.Wait()
 	if err.HasErrors() {
 		return err
 	} else {
@@ -160,6 +413,43 @@ func (scm *SimpleComponentManager) StartAll() error {
 	}
 }
 
+// startComponent starts the component and returns once the component is running, the start of the component
+// returns or the context is done. It returns the error of the start.
+func startComponent(ctx context.Context, component Component) error {
+	result := make(chan error, 1)
+	go func() {
+		var err error
+		if cc, ok := component.(ContextComponent); ok {
+			err = cc.StartCtx(ctx)
+		} else {
+			err = component.Start()
+		}
+		if err != nil {
+			logger.ErrorF("Error starting component: %v", err)
+		}
+		result <- err
+	}()
+	// Start may block for components like servers, hence the state is checked
+	ticker := time.NewTicker(stateCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-result:
+			return err
+		case <-ticker.C:
+			if component.State() == Running {
+				return nil
+			}
+		case <-ctx.Done():
+			if _, ok := component.(ContextComponent); ok {
+				// the component abandons its start on its own
+				return <-result
+			}
+			return contextError(ctx)
+		}
+	}
+}
+
 // StartAndWait will start all the Components. And will wait for them to be stopped.
 func (scm *SimpleComponentManager) StartAndWait() {
 	scm.StartAll() // Start all the components
@@ -174,14 +464,10 @@ func (scm *SimpleComponentManager) Start(id string) (err error) {
 	component, exists := scm.components[id]
 	if exists {
 		if component.State() != Running {
-			var err error = nil
-			go func(c Component, scm *SimpleComponentManager) {
-				err = component.Start()
-				if err != nil {
-					logger.ErrorF("Error starting component: %v", err)
-				}
-			}(component, scm)
-			return err
+			go func(c Component) {
+				_ = scm.startWithPolicy(context.Background(), c)
+			}(component)
+			return nil
 		} else {
 			return ErrCompAlreadyStarted
 		}
@@ -191,27 +477,74 @@ func (scm *SimpleComponentManager) Start(id string) (err error) {
 
 // StopAll will stop all the Components.
 func (scm *SimpleComponentManager) StopAll() error {
+	return scm.StopAllCtx(context.Background())
+}
+
+// StopAllCtx will stop all the Components. A component is stopped after the components depending on it, and the
+// remaining components are stopped even if one fails to stop. The components that fail to stop or do not stop before
+// the context is done are reported in the returned error. A call made while the components are stopping, or once they
+// are stopped, waits for the first one to complete and does nothing else.
+func (scm *SimpleComponentManager) StopAllCtx(ctx context.Context) error {
+	scm.infoMutex.Lock()
+	if scm.stopping {
+		scm.infoMutex.Unlock()
+		select {
+		case <-scm.waitChan:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	scm.stopping = true
+	scm.infoMutex.Unlock()
 	logger.InfoF("Stopping all components")
 	err := errutils.NewMultiErr(nil)
 	scm.cMutex.Lock()
 	defer scm.cMutex.Unlock()
+	// A component is stopped only after all the components depending on it are stopped.
+	dependents := make(map[string][]string)
+	if _, e := scm.startOrder(); e == nil {
+		for id, dependencies := range scm.dependencies {
+			for _, dependency := range dependencies {
+				dependents[dependency] = append(dependents[dependency], id)
+			}
+		}
+	} else {
+		logger.ErrorF("Stopping components ignoring their dependencies: %v", e)
+	}
+	stopped := make(map[string]chan struct{}, len(scm.components))
+	for id := range scm.components {
+		stopped[id] = make(chan struct{})
+	}
 	wg := &sync.WaitGroup{}
-	for _, component := range scm.components {
-		if component.State() == Running {
-			wg.Add(1)
-			go func(c Component, wg *sync.WaitGroup) {
-				e := component.Stop()
+	for id, component := range scm.components {
+		waitFor := make([]chan struct{}, 0, len(dependents[id]))
+		for _, dependent := range dependents[id] {
+			waitFor = append(waitFor, stopped[dependent])
+		}
+		wg.Add(1)
+		go func(c Component, done chan struct{}, wg *sync.WaitGroup) {
+			defer wg.Done()
+			defer close(done)
+			for _, ch := range waitFor {
+				select {
+				case <-ch:
+				case <-ctx.Done():
+				}
+			}
+			if c.State() == Running {
+				e := scm.stopOnce(ctx, c)
 				if e != nil {
-					logger.ErrorF("Error stopping component: %v", err)
-					err.Add(e)
+					logger.ErrorF("Error stopping component: %v", e)
+					err.Add(fmt.Errorf("component %s: %w", c.Id(), e))
 				}
-
-				wg.Done()
-			}(component, wg)
-		}
+			}
+		}(component, stopped[id], wg)
 	}
 	wg.Wait()
-	close(scm.waitChan)
+	scm.waitOnce.Do(func() {
+		close(scm.waitChan)
+	})
 	if err.HasErrors() {
 		return err
 	} else {
@@ -219,6 +552,15 @@ func (scm *SimpleComponentManager) StopAll() error {
 	}
 }
 
+// stopComponent stops the component and returns the error of the stop, or abandons the stop once the context is done.
+func stopComponent(ctx context.Context, component Component) (err error) {
+	if cc, ok := component.(ContextComponent); ok {
+		return cc.StopCtx(ctx)
+	}
+	err, _ = callWithin(ctx, 0, component.Stop)
+	return
+}
+
 // Stop will stop the LifeCycle for the component with the given id. It returns if the component was stopped.
 func (scm *SimpleComponentManager) Stop(id string) error {
 	scm.cMutex.Lock()
@@ -226,7 +568,10 @@ func (scm *SimpleComponentManager) Stop(id string) error {
 	component, exists := scm.components[id]
 	if exists {
 		if component.State() == Running {
-			err := component.Stop()
+			scm.infoMutex.Lock()
+			scm.info(id).stopped = true
+			scm.infoMutex.Unlock()
+			err := scm.stopOnce(context.Background(), component)
 			if err != nil {
 				logger.ErrorF("Error stopping component: %v", err)
 			}
@@ -251,6 +596,24 @@ func (scm *SimpleComponentManager) Unregister(id string) {
 			component.Stop()
 		}
 		delete(scm.components, id)
+		delete(scm.dependencies, id)
+		scm.infoMutex.Lock()
+		delete(scm.infos, id)
+		scm.infoMutex.Unlock()
+		for i, registered := range scm.order {
+			if registered == id {
+				scm.order = append(scm.order[:i], scm.order[i+1:]...)
+				break
+			}
+		}
+		for dependent, dependencies := range scm.dependencies {
+			for i, dependency := range dependencies {
+				if dependency == id {
+					scm.dependencies[dependent] = append(dependencies[:i], dependencies[i+1:]...)
+					break
+				}
+			}
+		}
 	}
 }
 
@@ -270,9 +633,13 @@ func (scm *SimpleComponentManager) Wait() {
 // NewSimpleComponentManager will return a new SimpleComponentManager.
 func NewSimpleComponentManager() ComponentManager {
 	manager := &SimpleComponentManager{
-		components: make(map[string]Component),
-		cMutex:     &sync.RWMutex{},
-		waitChan:   make(chan struct{}),
+		components:    make(map[string]Component),
+		dependencies:  make(map[string][]string),
+		cMutex:        &sync.RWMutex{},
+		waitChan:      make(chan struct{}),
+		infos:         make(map[string]*componentInfo),
+		shutdownChan:  make(chan struct{}, 1),
+		shutdownGrace: DefaultShutdownGrace,
 	}
 	return manager
 }
@@ -1,9 +1,15 @@
 package lifecycle
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"oss.nandlabs.io/golly/errutils"
 )
 
 // TestSimpleComponent_Start tests the Start method of the SimpleComponent struct.
@@ -255,3 +261,248 @@ func TestSimpleComponentManager_List(t *testing.T) {
 		t.Errorf("List() len = %v, want %v", len(components), 1)
 	}
 }
+
+// TestSimpleComponentManager_Dependencies tests that StartAll and StopAll honour the dependencies between components.
+func TestSimpleComponentManager_Dependencies(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	newComponent := func(id string) *SimpleComponent {
+		return &SimpleComponent{
+			CompId: id,
+			StartFunc: func() error {
+				time.Sleep(20 * time.Millisecond)
+				record("start:" + id)
+				return nil
+			},
+			StopFunc: func() error {
+				time.Sleep(20 * time.Millisecond)
+				record("stop:" + id)
+				return nil
+			},
+		}
+	}
+	// register the dependents first to ensure the registration order is not used
+	api := newComponent("api")
+	cache := newComponent("cache")
+	db := newComponent("db")
+	manager.Register(api)
+	manager.Register(cache)
+	manager.Register(db)
+	if err := manager.AddDependency("api", "cache", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddDependency("cache", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddDependency("api", "unknown"); err != ErrCompNotFound {
+		t.Errorf("AddDependency() error = %v, want %v", err, ErrCompNotFound)
+	}
+	if err := manager.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if err := manager.StopAll(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start:db", "start:cache", "start:api", "stop:api", "stop:cache", "stop:db"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+// TestSimpleComponentManager_DependencyNotRunning tests that a component is not started if its dependency failed.
+func TestSimpleComponentManager_DependencyNotRunning(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	db := &SimpleComponent{
+		CompId: "db",
+		StartFunc: func() error {
+			return fmt.Errorf("db unavailable")
+		},
+	}
+	started := false
+	api := &SimpleComponent{
+		CompId: "api",
+		StartFunc: func() error {
+			started = true
+			return nil
+		},
+	}
+	manager.Register(db)
+	manager.Register(api)
+	if err := manager.AddDependency("api", "db"); err != nil {
+		t.Fatal(err)
+	}
+	err := manager.StartAll()
+	if err == nil || !strings.Contains(err.Error(), "component db: db unavailable") ||
+		!strings.Contains(err.Error(), "component api not started: dependency not running: db") {
+		t.Errorf("StartAll() error = %v", err)
+	}
+	if started || api.State() == Running {
+		t.Errorf("api started even though the dependency failed to start")
+	}
+	if db.State() != Error {
+		t.Errorf("db state = %v, want %v", db.State(), Error)
+	}
+}
+
+// TestSimpleComponentManager_CyclicDependency tests that AddDependency rejects the dependencies creating a cycle.
+func TestSimpleComponentManager_CyclicDependency(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	manager.Register(&SimpleComponent{CompId: "a", StartFunc: func() error { return nil }})
+	manager.Register(&SimpleComponent{CompId: "b", StartFunc: func() error { return nil }})
+	manager.Register(&SimpleComponent{CompId: "c", StartFunc: func() error { return nil }})
+	if err := manager.AddDependency("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddDependency("b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	err := manager.AddDependency("c", "b", "a")
+	if !errors.Is(err, ErrCyclicDependency) || !strings.HasSuffix(err.Error(), ": c -> b -> c") {
+		t.Errorf("AddDependency() error = %v", err)
+	}
+	if err = manager.AddDependency("a", "a"); err == nil || !strings.HasSuffix(err.Error(), ": a -> a") {
+		t.Errorf("AddDependency() error = %v", err)
+	}
+	if graph := manager.DependencyGraph(); len(graph["c"]) != 0 {
+		t.Errorf("the dependencies of c were added: %v", graph["c"])
+	}
+	if err = manager.StartAll(); err != nil {
+		t.Errorf("StartAll() error = %v", err)
+	}
+}
+
+// hangingComponent is a Component whose Start and Stop block until released, without support for a context.
+type hangingComponent struct {
+	id      string
+	state   ComponentState
+	release chan struct{}
+}
+
+func (h *hangingComponent) Id() string                                  { return h.id }
+func (h *hangingComponent) OnChange(prevState, newState ComponentState) {}
+func (h *hangingComponent) State() ComponentState                       { return h.state }
+func (h *hangingComponent) Start() error                                { <-h.release; return nil }
+func (h *hangingComponent) Stop() error                                 { <-h.release; return nil }
+
+// TestSimpleComponentManager_StartAllCtx tests that the components not starting within their timeout are Failed and
+// that their dependents are not started.
+func TestSimpleComponentManager_StartAllCtx(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	release := make(chan struct{})
+	defer close(release)
+	cache := &SimpleComponent{CompId: "cache", StartFunc: func() error { return nil }}
+	db := &SimpleComponent{
+		CompId:       "db",
+		StartTimeout: 50 * time.Millisecond,
+		StartFunc: func() error {
+			<-release
+			return nil
+		},
+	}
+	api := &SimpleComponent{CompId: "api", StartFunc: func() error { return nil }}
+	manager.Register(cache)
+	manager.Register(db)
+	manager.Register(api)
+	if err := manager.AddDependency("api", "db", "cache"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err := manager.StartAllCtx(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("StartAllCtx() took %v", elapsed)
+	}
+	multiErr, ok := err.(*errutils.MultiError)
+	if !ok || !multiErr.HasError(ErrCompTimeout) || !multiErr.HasError(ErrDependencyNotRunning) {
+		t.Fatalf("StartAllCtx() error = %v", err)
+	}
+	if !strings.Contains(err.Error(), "component db: component timed out") ||
+		!strings.Contains(err.Error(), "component api not started: dependency not running: db") {
+		t.Errorf("StartAllCtx() error = %v", err)
+	}
+	if db.State() != Failed || api.State() != Unknown || cache.State() != Running {
+		t.Errorf("states = %v, %v, %v", db.State(), api.State(), cache.State())
+	}
+}
+
+// TestSimpleComponentManager_StartAllCtxDeadline tests that the deadline of the context bounds the start of the
+// components not supporting a context.
+func TestSimpleComponentManager_StartAllCtxDeadline(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	release := make(chan struct{})
+	defer close(release)
+	manager.Register(&hangingComponent{id: "legacy", release: release})
+	worker := &SimpleComponent{CompId: "worker", StartFunc: func() error {
+		<-release
+		return nil
+	}}
+	manager.Register(worker)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := manager.StartAllCtx(ctx)
+	multiErr, ok := err.(*errutils.MultiError)
+	if !ok || !multiErr.HasError(context.DeadlineExceeded) || !strings.Contains(err.Error(), "component legacy: ") ||
+		!strings.Contains(err.Error(), "component worker: ") {
+		t.Errorf("StartAllCtx() error = %v", err)
+	}
+	if worker.State() != Failed {
+		t.Errorf("worker state = %v, want %v", worker.State(), Failed)
+	}
+}
+
+// TestSimpleComponentManager_StopAllCtx tests that StopAllCtx stops the remaining components when some fail or time
+// out and reports all of them.
+func TestSimpleComponentManager_StopAllCtx(t *testing.T) {
+	manager := NewSimpleComponentManager()
+	release := make(chan struct{})
+	defer close(release)
+	var stopped sync.Map
+	newComponent := func(id string, stop func() error) *SimpleComponent {
+		return &SimpleComponent{
+			CompId:      id,
+			CompState:   Running,
+			StopTimeout: 50 * time.Millisecond,
+			StopFunc: func() error {
+				stopped.Store(id, true)
+				return stop()
+			},
+		}
+	}
+	hung := newComponent("hung", func() error {
+		<-release
+		return nil
+	})
+	broken := newComponent("broken", func() error { return fmt.Errorf("close failed") })
+	db := newComponent("db", func() error { return nil })
+	manager.Register(hung)
+	manager.Register(broken)
+	manager.Register(db)
+	manager.Register(&hangingComponent{id: "legacy", state: Running, release: release})
+	if err := manager.AddDependency("hung", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddDependency("broken", "db"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := manager.StopAllCtx(ctx)
+	for _, want := range []string{"component hung: component timed out", "component broken: close failed",
+		"component legacy: component timed out"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("StopAllCtx() error = %v, want %q", err, want)
+		}
+	}
+	if _, ok := stopped.Load("db"); !ok || db.State() != Stopped {
+		t.Errorf("db was not stopped")
+	}
+	if hung.State() != Failed || broken.State() != Error {
+		t.Errorf("states = %v, %v", hung.State(), broken.State())
+	}
+}
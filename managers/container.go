@@ -0,0 +1,299 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"oss.nandlabs.io/golly/lifecycle"
+)
+
+// ErrNotProvided is returned when no registration exists for the name being resolved.
+var ErrNotProvided = errors.New("no registration found")
+
+// ErrTypeMismatch is returned when the registered value is not of the type being resolved.
+var ErrTypeMismatch = errors.New("registered value is not of the requested type")
+
+// ErrCyclicDependency is returned when the resolution of a name requires the name itself.
+var ErrCyclicDependency = errors.New("cyclic dependency")
+
+// Container is a typed service locator that lazily constructs the registered values.
+// Values are constructed on the first Resolve and the same value is returned for every subsequent Resolve.
+type Container struct {
+	// parent container whose registrations are shadowed by this container
+	parent *Container
+	// bindings registered with this container
+	bindings ItemManager[*binding]
+	// componentManager to register the resolved components with
+	componentManager lifecycle.ComponentManager
+	// chain of names being resolved. This is only set for the container passed to the factories.
+	chain []string
+	// deps collects the component ids resolved by a factory. This is only set for the container passed to the factories.
+	deps *[]string
+	// resolution the factory is called for. This is only set for the container passed to the factories.
+	resolution *resolution
+}
+
+// binding holds a registration of the container. Its state is guarded by the resolveMutex.
+type binding struct {
+	name    string
+	typ     reflect.Type
+	factory func(c *Container) (any, error)
+	// resolved is true once the value is constructed
+	resolved bool
+	value    any
+	// componentIds are the ids of the components this value is or depends on.
+	componentIds []string
+	// resolving is the resolution constructing the value, nil if none
+	resolving *resolution
+	// done is closed once the resolving resolution is done constructing the value
+	done chan struct{}
+}
+
+// resolution is a call of Resolve along with the resolutions of the dependencies made by the factories it calls.
+// The factories are called without holding a lock; a resolution needing a value being constructed by another one
+// waits for it unless that other resolution waits for this one.
+type resolution struct {
+	// waiting is the binding whose construction by another resolution this resolution waits for
+	waiting *binding
+}
+
+// resolveMutex guards the state of the bindings and the resolutions.
+var resolveMutex sync.Mutex
+
+// waitsFor checks if the resolution r is, or waits directly or through other resolutions for, the other resolution.
+// It is called with the resolveMutex held.
+func (r *resolution) waitsFor(other *resolution) bool {
+	for r != nil {
+		if r == other {
+			return true
+		}
+		if r.waiting == nil {
+			return false
+		}
+		r = r.waiting.resolving
+	}
+	return false
+}
+
+// NewContainer creates a new Container.
+func NewContainer() *Container {
+	return &Container{
+		bindings: NewItemManager[*binding](),
+	}
+}
+
+// SetComponentManager sets the component manager with which the resolved values implementing
+// lifecycle.Component or io.Closer are registered. The dependencies between the components are registered
+// with the manager as they are resolved, so StartAll and StopAll follow the order of construction.
+func (c *Container) SetComponentManager(manager lifecycle.ComponentManager) *Container {
+	c.componentManager = manager
+	return c
+}
+
+// Scope creates a child container. Registrations of the child container shadow the ones of this container
+// and are not visible to it.
+func (c *Container) Scope() *Container {
+	return &Container{
+		parent:           c.root(),
+		bindings:         NewItemManager[*binding](),
+		componentManager: c.componentManager,
+	}
+}
+
+// Override registers the value for the name replacing any existing registration of this container.
+func (c *Container) Override(name string, value any) {
+	c.bindings.Register(name, &binding{
+		name:     name,
+		typ:      reflect.TypeOf(value),
+		resolved: true,
+		value:    value,
+	})
+}
+
+// Provide registers a factory for the name. The factory is invoked on the first Resolve of the name and
+// is passed the container to resolve its own dependencies.
+func Provide[T any](c *Container, name string, factory func(c *Container) (T, error)) {
+	c.bindings.Register(name, &binding{
+		name: name,
+		typ:  reflect.TypeOf((*T)(nil)).Elem(),
+		factory: func(c *Container) (any, error) {
+			return factory(c)
+		},
+	})
+}
+
+// Resolve returns the value registered for the name constructing it if required.
+func Resolve[T any](c *Container, name string) (value T, err error) {
+	var v any
+	v, err = c.resolve(name)
+	if err == nil {
+		var ok bool
+		if value, ok = v.(T); !ok {
+			err = fmt.Errorf("%w: %s is %T", ErrTypeMismatch, name, v)
+		}
+	}
+	return
+}
+
+// ResolveAll returns the values of every registration assignable to T sorted by name.
+// Only the matching registrations are constructed.
+func ResolveAll[T any](c *Container) (values []T, err error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	var names []string
+	seen := make(map[string]bool)
+	for container := c; container != nil; container = container.parent {
+		for _, b := range container.bindings.Items() {
+			if !seen[b.name] {
+				seen[b.name] = true
+				if b.typ != nil && b.typ.AssignableTo(typ) {
+					names = append(names, b.name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var value T
+		value, err = Resolve[T](c, name)
+		if err != nil {
+			return
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+// root returns the container that owns the registrations when c is a container passed to a factory.
+func (c *Container) root() *Container {
+	if c.chain == nil {
+		return c
+	}
+	return &Container{
+		parent:           c.parent,
+		bindings:         c.bindings,
+		componentManager: c.componentManager,
+	}
+}
+
+// lookup finds the binding for the name and the container that owns it.
+func (c *Container) lookup(name string) (b *binding, owner *Container) {
+	for owner = c; owner != nil; owner = owner.parent {
+		if b = owner.bindings.Get(name); b != nil {
+			return
+		}
+	}
+	return nil, nil
+}
+
+func (c *Container) resolve(name string) (value any, err error) {
+	chain := append(append([]string{}, c.chain...), name)
+	for _, n := range c.chain {
+		if n == name {
+			err = fmt.Errorf("%w: %s", ErrCyclicDependency, strings.Join(chain, " -> "))
+			return
+		}
+	}
+	b, owner := c.lookup(name)
+	if b == nil {
+		err = fmt.Errorf("%w: %s", ErrNotProvided, name)
+		return
+	}
+	r := c.resolution
+	if r == nil {
+		r = &resolution{}
+	}
+	resolveMutex.Lock()
+	for !b.resolved && b.resolving != nil {
+		// two resolutions waiting for each other resolve a cycle from its opposite ends
+		if b.resolving.waitsFor(r) {
+			resolveMutex.Unlock()
+			err = fmt.Errorf("%w: %s", ErrCyclicDependency, strings.Join(chain, " -> "))
+			return
+		}
+		done := b.done
+		r.waiting = b
+		resolveMutex.Unlock()
+		<-done
+		resolveMutex.Lock()
+		r.waiting = nil
+	}
+	if !b.resolved {
+		b.resolving, b.done = r, make(chan struct{})
+		resolveMutex.Unlock()
+		var ids []string
+		value, ids, err = owner.construct(b, chain, r)
+		resolveMutex.Lock()
+		if err == nil {
+			b.value, b.componentIds, b.resolved = value, ids, true
+		}
+		b.resolving = nil
+		close(b.done)
+		if err != nil {
+			resolveMutex.Unlock()
+			return
+		}
+	}
+	value = b.value
+	ids := b.componentIds
+	resolveMutex.Unlock()
+	if c.deps != nil {
+		*c.deps = append(*c.deps, ids...)
+	}
+	return
+}
+
+// construct calls the factory of the binding owned by the container and registers the value with the component
+// manager. It returns the value and the component ids that the dependents of the value depend on.
+func (c *Container) construct(b *binding, chain []string, r *resolution) (value any, ids []string, err error) {
+	var deps []string
+	// the factory resolves its dependencies against the owner so values of a parent
+	// never depend on registrations of a child scope
+	resolver := &Container{
+		parent:           c.parent,
+		bindings:         c.bindings,
+		componentManager: c.componentManager,
+		chain:            chain,
+		deps:             &deps,
+		resolution:       r,
+	}
+	value, err = b.factory(resolver)
+	if err == nil {
+		ids, err = c.registerComponent(b.name, value, deps)
+	}
+	return
+}
+
+// registerComponent registers the value with the component manager if it is a lifecycle.Component or an io.Closer.
+// It returns the component ids that the dependents of this value depend on.
+func (c *Container) registerComponent(name string, value any, deps []string) (ids []string, err error) {
+	ids = deps
+	if c.componentManager == nil {
+		return
+	}
+	var component lifecycle.Component
+	switch v := value.(type) {
+	case lifecycle.Component:
+		component = v
+	case io.Closer:
+		component = &lifecycle.SimpleComponent{
+			CompId: name,
+			StartFunc: func() error {
+				return nil
+			},
+			StopFunc: v.Close,
+		}
+	default:
+		return
+	}
+	c.componentManager.Register(component)
+	if len(deps) > 0 {
+		err = c.componentManager.AddDependency(component.Id(), deps...)
+	}
+	ids = []string{component.Id()}
+	return
+}
@@ -0,0 +1,265 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/lifecycle"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+type greeter interface {
+	Greet() string
+}
+
+type simpleGreeter struct {
+	name string
+}
+
+func (g *simpleGreeter) Greet() string {
+	return "hello " + g.name
+}
+
+type closer struct {
+	name   string
+	closed *[]string
+	mutex  *sync.Mutex
+}
+
+func (c *closer) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	*c.closed = append(*c.closed, c.name)
+	return nil
+}
+
+func TestContainer_ResolveMemoized(t *testing.T) {
+	c := NewContainer()
+	var calls int32
+	Provide(c, "greeter", func(c *Container) (*simpleGreeter, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return &simpleGreeter{name: "golly"}, nil
+	})
+	wg := sync.WaitGroup{}
+	results := make([]*simpleGreeter, 50)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g, err := Resolve[*simpleGreeter](c, "greeter")
+			assert.NoError(t, err)
+			results[i] = g
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, g := range results {
+		assert.True(t, g == results[0])
+	}
+}
+
+func TestContainer_ResolveDependencies(t *testing.T) {
+	c := NewContainer()
+	Provide(c, "name", func(c *Container) (string, error) {
+		return "world", nil
+	})
+	Provide(c, "greeter", func(c *Container) (greeter, error) {
+		name, err := Resolve[string](c, "name")
+		if err != nil {
+			return nil, err
+		}
+		return &simpleGreeter{name: name}, nil
+	})
+	g, err := Resolve[greeter](c, "greeter")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", g.Greet())
+}
+
+func TestContainer_ResolveErrors(t *testing.T) {
+	c := NewContainer()
+	Provide(c, "name", func(c *Container) (string, error) {
+		return "world", nil
+	})
+	Provide(c, "failing", func(c *Container) (string, error) {
+		return "", fmt.Errorf("boom")
+	})
+	_, err := Resolve[string](c, "missing")
+	assert.True(t, errors.Is(err, ErrNotProvided))
+	_, err = Resolve[int](c, "name")
+	assert.True(t, errors.Is(err, ErrTypeMismatch))
+	_, err = Resolve[string](c, "failing")
+	assert.Error(t, err)
+	assert.Equal(t, "boom", err.Error())
+}
+
+func TestContainer_CyclicDependency(t *testing.T) {
+	c := NewContainer()
+	Provide(c, "a", func(c *Container) (string, error) {
+		return Resolve[string](c, "b")
+	})
+	Provide(c, "b", func(c *Container) (string, error) {
+		return Resolve[string](c, "c")
+	})
+	Provide(c, "c", func(c *Container) (string, error) {
+		return Resolve[string](c, "a")
+	})
+	_, err := Resolve[string](c, "a")
+	assert.True(t, errors.Is(err, ErrCyclicDependency))
+	assert.True(t, strings.Contains(err.Error(), "a -> b -> c -> a"))
+}
+
+func TestContainer_ConcurrentCyclicDependency(t *testing.T) {
+	c := NewContainer()
+	// both factories are running before either resolves its dependency
+	var ready sync.WaitGroup
+	ready.Add(2)
+	var onceA, onceB sync.Once
+	Provide(c, "a", func(c *Container) (string, error) {
+		onceA.Do(func() {
+			ready.Done()
+			ready.Wait()
+		})
+		return Resolve[string](c, "b")
+	})
+	Provide(c, "b", func(c *Container) (string, error) {
+		onceB.Do(func() {
+			ready.Done()
+			ready.Wait()
+		})
+		return Resolve[string](c, "a")
+	})
+	errs := make(chan error, 2)
+	for _, name := range []string{"a", "b"} {
+		go func(name string) {
+			_, err := Resolve[string](c, name)
+			errs <- err
+		}(name)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.True(t, errors.Is(err, ErrCyclicDependency))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out resolving the cycle from its opposite ends")
+		}
+	}
+}
+
+func TestContainer_ResolveAll(t *testing.T) {
+	c := NewContainer()
+	var constructed int32
+	Provide(c, "plugin-b", func(c *Container) (*simpleGreeter, error) {
+		atomic.AddInt32(&constructed, 1)
+		return &simpleGreeter{name: "b"}, nil
+	})
+	Provide(c, "plugin-a", func(c *Container) (greeter, error) {
+		atomic.AddInt32(&constructed, 1)
+		return &simpleGreeter{name: "a"}, nil
+	})
+	Provide(c, "other", func(c *Container) (string, error) {
+		atomic.AddInt32(&constructed, 1)
+		return "not a greeter", nil
+	})
+	greeters, err := ResolveAll[greeter](c)
+	assert.NoError(t, err)
+	assert.Len(t, greeters, 2)
+	assert.Equal(t, "hello a", greeters[0].Greet())
+	assert.Equal(t, "hello b", greeters[1].Greet())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&constructed))
+}
+
+func TestContainer_ScopeOverride(t *testing.T) {
+	c := NewContainer()
+	Provide(c, "name", func(c *Container) (string, error) {
+		return "parent", nil
+	})
+	Provide(c, "greeter", func(c *Container) (*simpleGreeter, error) {
+		name, err := Resolve[string](c, "name")
+		return &simpleGreeter{name: name}, err
+	})
+	scope := c.Scope()
+	scope.Override("name", "tenant")
+	Provide(scope, "scoped", func(c *Container) (*simpleGreeter, error) {
+		name, err := Resolve[string](c, "name")
+		return &simpleGreeter{name: name}, err
+	})
+
+	name, err := Resolve[string](scope, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant", name)
+	scoped, err := Resolve[*simpleGreeter](scope, "scoped")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello tenant", scoped.Greet())
+	// values of the parent are constructed with the registrations of the parent
+	g, err := Resolve[*simpleGreeter](scope, "greeter")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello parent", g.Greet())
+
+	name, err = Resolve[string](c, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "parent", name)
+	_, err = Resolve[*simpleGreeter](c, "scoped")
+	assert.True(t, errors.Is(err, ErrNotProvided))
+
+	c.Override("name", "overridden")
+	name, err = Resolve[string](c, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "overridden", name)
+}
+
+func TestContainer_ComponentRegistration(t *testing.T) {
+	manager := lifecycle.NewSimpleComponentManager()
+	c := NewContainer().SetComponentManager(manager)
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	newComponent := func(id string) *lifecycle.SimpleComponent {
+		return &lifecycle.SimpleComponent{
+			CompId: id,
+			StartFunc: func() error {
+				time.Sleep(10 * time.Millisecond)
+				record("start:" + id)
+				return nil
+			},
+			StopFunc: func() error {
+				time.Sleep(10 * time.Millisecond)
+				record("stop:" + id)
+				return nil
+			},
+		}
+	}
+	Provide(c, "db", func(c *Container) (*lifecycle.SimpleComponent, error) {
+		return newComponent("db"), nil
+	})
+	Provide(c, "pool", func(c *Container) (*closer, error) {
+		_, err := Resolve[*lifecycle.SimpleComponent](c, "db")
+		return &closer{name: "pool", closed: &events, mutex: &mutex}, err
+	})
+	// config is not a component, the server depends on the pool through it
+	Provide(c, "config", func(c *Container) (string, error) {
+		_, err := Resolve[*closer](c, "pool")
+		return "config", err
+	})
+	Provide(c, "server", func(c *Container) (lifecycle.Component, error) {
+		_, err := Resolve[string](c, "config")
+		return newComponent("server"), err
+	})
+	_, err := Resolve[lifecycle.Component](c, "server")
+	assert.NoError(t, err)
+	assert.Len(t, manager.List(), 3)
+
+	assert.NoError(t, manager.StartAll())
+	time.Sleep(300 * time.Millisecond)
+	assert.NoError(t, manager.StopAll())
+	assert.Equal(t, []string{"start:db", "start:server", "stop:server", "pool", "stop:db"}, events)
+}
@@ -2,6 +2,8 @@ package messaging
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"reflect"
 
@@ -9,6 +11,12 @@ import (
 	"oss.nandlabs.io/golly/ioutils"
 )
 
+// ContentTypeHeader is the header holding the content type of the message body
+const ContentTypeHeader = "Content-Type"
+
+// ErrNoCodec is returned when there is no codec available for the content type of the message body
+var ErrNoCodec = errors.New("no codec found for content type")
+
 type BaseMessage struct {
 	id          string
 	headers     map[string]interface{}
@@ -55,8 +63,50 @@ func (bm *BaseMessage) WriteContent(input interface{}, contentType string) (err
 	return
 }
 
+// SetBodyObject replaces the body with the object encoded using the codec for the contentType
+// and sets the content type header of the message
+func (bm *BaseMessage) SetBodyObject(v any, contentType string) (err error) {
+	var cdc codec.Codec
+	cdc, err = getCodec(contentType)
+	if err == nil {
+		buf := &bytes.Buffer{}
+		err = cdc.Write(v, buf)
+		if err == nil {
+			bm.body.Reset()
+			_, err = bm.body.Write(buf.Bytes())
+			bm.SetStrHeader(ContentTypeHeader, contentType)
+		}
+	}
+	return
+}
+
+// ReadAsObject decodes the body using the codec for the content type header of the message.
+// JSON is used if the message does not have a content type header
+func (bm *BaseMessage) ReadAsObject(v any) (err error) {
+	contentType := ioutils.MimeApplicationJSON
+	if ct, ok := bm.headers[ContentTypeHeader].(string); ok && ct != "" {
+		contentType = ct
+	}
+	var cdc codec.Codec
+	cdc, err = getCodec(contentType)
+	if err == nil {
+		err = cdc.Read(bytes.NewReader(bm.body.Bytes()), v)
+	}
+	return
+}
+
+// getCodec returns the default codec for the content type
+func getCodec(contentType string) (cdc codec.Codec, err error) {
+	cdc, err = codec.GetDefault(contentType)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrNoCodec, contentType)
+	}
+	return
+}
+
+// ReadBody returns a reader of the body. Reading the body does not consume it, so that it can be read again.
 func (bm *BaseMessage) ReadBody() io.Reader {
-	return bm.body
+	return bytes.NewReader(bm.body.Bytes())
 }
 
 func (bm *BaseMessage) ReadBytes() []byte {
@@ -82,14 +132,18 @@ func (bm *BaseMessage) ReadContent(out interface{}, contentType string) (err err
 	// TODO: provide options to customise codec options
 	cdc, err = codec.GetDefault(contentType)
 	if err == nil {
-		err = cdc.Read(bm.body, out)
+		err = cdc.Read(bytes.NewReader(bm.body.Bytes()), out)
 	}
 	return
 }
 
-func (bm *BaseMessage) SetHeader(key string, value []byte) {
+func (bm *BaseMessage) SetHeader(key string, value any) {
 	bm.headers[key] = value
-	bm.headerTypes[key] = reflect.Array
+	if value == nil {
+		bm.headerTypes[key] = reflect.Invalid
+	} else {
+		bm.headerTypes[key] = reflect.TypeOf(value).Kind()
+	}
 }
 
 func (bm *BaseMessage) SetStrHeader(key string, value string) {
@@ -137,11 +191,15 @@ func (bm *BaseMessage) SetFloat64Header(key string, value float64) {
 	bm.headerTypes[key] = reflect.Float64
 }
 
-func (bm *BaseMessage) GetHeader(key string) (value []byte, exists bool) {
-	var v interface{}
-	v, exists = bm.headers[key]
-	if exists {
-		value = v.([]byte)
+func (bm *BaseMessage) GetHeader(key string) (value any, exists bool) {
+	value, exists = bm.headers[key]
+	return
+}
+
+func (bm *BaseMessage) Headers() (headers map[string]any) {
+	headers = make(map[string]any, len(bm.headers))
+	for k, v := range bm.headers {
+		headers[k] = v
 	}
 	return
 }
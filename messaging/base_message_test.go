@@ -2,6 +2,7 @@ package messaging
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -105,7 +106,7 @@ func TestLocalMessage_Header(t *testing.T) {
 	message.SetHeader("key", []byte("test"))
 	got, exists := message.GetHeader("key")
 	if exists {
-		if string(got) != "test" {
+		if string(got.([]byte)) != "test" {
 			t.Errorf("Error Header Setters/Getters, got : %v, want : test", got)
 		}
 	}
@@ -245,3 +246,59 @@ func TestLocalMessage_Float64Header(t *testing.T) {
 		}
 	}
 }
+
+type testObject struct {
+	Name  string `json:"name" yaml:"name"`
+	Count int    `json:"count" yaml:"count"`
+}
+
+func TestLocalMessage_BodyObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{name: "JSON", contentType: "application/json"},
+		{name: "YAML", contentType: "text/yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := NewLocalMessage()
+			assert.NoError(t, err)
+			_, _ = message.SetBodyStr("existing body")
+			input := testObject{Name: "golly", Count: 2}
+			assert.NoError(t, message.SetBodyObject(input, tt.contentType))
+			ct, ok := message.GetStrHeader(ContentTypeHeader)
+			assert.True(t, ok)
+			assert.Equal(t, tt.contentType, ct)
+			var got testObject
+			assert.NoError(t, message.ReadAsObject(&got))
+			assert.Equal(t, input, got)
+			// the body is still accessible as bytes
+			assert.NotEmpty(t, message.ReadBytes())
+			assert.False(t, strings.Contains(message.ReadAsStr(), "existing body"))
+		})
+	}
+}
+
+func TestLocalMessage_ReadAsObjectDefaultJSON(t *testing.T) {
+	message, err := NewLocalMessage()
+	assert.NoError(t, err)
+	_, _ = message.SetBodyStr(`{"name":"golly","count":3}`)
+	var got testObject
+	assert.NoError(t, message.ReadAsObject(&got))
+	assert.Equal(t, testObject{Name: "golly", Count: 3}, got)
+}
+
+func TestLocalMessage_BodyObjectNoCodec(t *testing.T) {
+	message, err := NewLocalMessage()
+	assert.NoError(t, err)
+	err = message.SetBodyObject(testObject{}, "application/x-unknown")
+	assert.True(t, errors.Is(err, ErrNoCodec))
+
+	message.SetStrHeader(ContentTypeHeader, "application/octet-stream")
+	_, _ = message.SetBodyBytes([]byte{0x01, 0x02})
+	var got testObject
+	err = message.ReadAsObject(&got)
+	assert.True(t, errors.Is(err, ErrNoCodec))
+	assert.Equal(t, []byte{0x01, 0x02}, message.ReadBytes())
+}
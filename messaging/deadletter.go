@@ -0,0 +1,140 @@
+package messaging
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"oss.nandlabs.io/golly/clients"
+)
+
+const (
+	// DeadLetterErrorHeader is the header holding the error of the last failed attempt of a dead lettered message
+	DeadLetterErrorHeader = "X-Dead-Letter-Error"
+	// DeadLetterAttemptsHeader is the header holding the number of attempts made to process a dead lettered message
+	DeadLetterAttemptsHeader = "X-Dead-Letter-Attempts"
+	// DeadLetterFirstSeenHeader is the header holding the time (RFC3339) the message was first delivered to the listener
+	DeadLetterFirstSeenHeader = "X-Dead-Letter-First-Seen"
+	// DeadLetterSourceHeader is the header holding the url of the destination the message was dead lettered from
+	DeadLetterSourceHeader = "X-Dead-Letter-Source"
+	// DefaultMaxRedeliveries is the number of times a failing message is requeued if the listener has neither a retry
+	// policy nor a dead letter destination
+	DefaultMaxRedeliveries = 3
+)
+
+// Stats holds the counters of the messages handled by the manager
+type Stats struct {
+	// DeadLettered is the number of messages forwarded to the dead letter destination by the source destination url
+	DeadLettered map[string]int64
+}
+
+// listenerPolicy defines how the failures of a listener are handled
+type listenerPolicy struct {
+	source     *url.URL
+	manualAck  bool
+	retry      *clients.RetryInfo
+	deadLetter *url.URL
+}
+
+func newListenerPolicy(u *url.URL, options ...Option) (policy *listenerPolicy) {
+	policy = &listenerPolicy{source: u}
+	policy.manualAck, _ = GetOptValue[bool](ManualAck, options...)
+	policy.retry, _ = GetOptValue[*clients.RetryInfo](RetryOpts, options...)
+	policy.deadLetter, _ = GetOptValue[*url.URL](DeadLetter, options...)
+	return
+}
+
+// wrapListener wraps the listener to acknowledge the messages once the listener returns.
+// A panic of the listener is a failed attempt. The message is requeued until the retries of the policy, or the
+// DefaultMaxRedeliveries without a policy nor a dead letter destination, are exhausted. It is then forwarded to the
+// dead letter destination if one is set or else rejected.
+// Failures are not handled for listeners with the ManualAck option other than logging them.
+func (m *managerImpl) wrapListener(listener func(msg Message), policy *listenerPolicy) func(msg Message) {
+	return func(msg Message) {
+		if policy.deadLetter != nil && !policy.manualAck {
+			if _, ok := msg.GetStrHeader(DeadLetterFirstSeenHeader); !ok {
+				msg.SetStrHeader(DeadLetterFirstSeenHeader, time.Now().UTC().Format(time.RFC3339Nano))
+			}
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				logger.ErrorF("listener failed to process message %s: %v", msg.Id(), r)
+				if !policy.manualAck {
+					m.handleFailure(msg, policy, r)
+				}
+			}
+		}()
+		listener(msg)
+		if !policy.manualAck {
+			if err := msg.Ack(); err != nil {
+				logger.ErrorF("unable to acknowledge message %s: %v", msg.Id(), err)
+			}
+		}
+	}
+}
+
+// handleFailure requeues, dead letters or rejects the message that failed to be processed. The message is requeued
+// once the wait of the retry policy has elapsed.
+func (m *managerImpl) handleFailure(msg Message, policy *listenerPolicy, cause any) {
+	var err error
+	attempts := msg.DeliveryCount()
+	maxRetries := 0
+	if policy.retry != nil {
+		maxRetries = policy.retry.MaxRetries
+	} else if policy.deadLetter == nil {
+		// a poison message must not be redelivered forever
+		maxRetries = DefaultMaxRedeliveries
+	}
+	switch {
+	case attempts <= maxRetries:
+		if policy.retry != nil && policy.retry.Wait > 0 {
+			// the message is requeued by a timer so the listener is not blocked during the wait
+			time.AfterFunc(time.Duration(policy.retry.Wait)*time.Millisecond, func() {
+				if err := msg.Nack(true); err != nil {
+					logger.ErrorF("unable to requeue message %s: %v", msg.Id(), err)
+				}
+			})
+			return
+		}
+		err = msg.Nack(true)
+	case policy.deadLetter != nil:
+		m.forwardToDeadLetter(msg, policy, attempts, cause)
+	default:
+		logger.WarnF("dropping message %s after %d attempts", msg.Id(), attempts)
+		err = msg.Nack(false)
+	}
+	if err != nil {
+		logger.ErrorF("unable to requeue message %s: %v", msg.Id(), err)
+	}
+}
+
+// forwardToDeadLetter acknowledges the message and sends it to the dead letter destination of the policy
+// along with the failure headers.
+func (m *managerImpl) forwardToDeadLetter(msg Message, policy *listenerPolicy, attempts int, cause any) {
+	msg.SetStrHeader(DeadLetterErrorHeader, fmt.Sprint(cause))
+	msg.SetIntHeader(DeadLetterAttemptsHeader, attempts)
+	msg.SetStrHeader(DeadLetterSourceHeader, policy.source.String())
+	// the message is settled before it is sent as providers may deliver the same message instance
+	if err := msg.Ack(); err != nil {
+		logger.ErrorF("unable to acknowledge message %s: %v", msg.Id(), err)
+	}
+	if err := m.Send(policy.deadLetter, msg); err != nil {
+		logger.ErrorF("unable to send message %s to dead letter destination %s: %v", msg.Id(),
+			policy.deadLetter.String(), err)
+		return
+	}
+	m.mutex.Lock()
+	m.deadLettered[policy.source.String()]++
+	m.mutex.Unlock()
+}
+
+// Stats returns a snapshot of the counters of the manager
+func (m *managerImpl) Stats() (stats Stats) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats.DeadLettered = make(map[string]int64, len(m.deadLettered))
+	for k, v := range m.deadLettered {
+		stats.DeadLettered[k] = v
+	}
+	return
+}
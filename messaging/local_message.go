@@ -3,12 +3,17 @@ package messaging
 import (
 	"bytes"
 	"reflect"
+	"sync"
 
 	"oss.nandlabs.io/golly/uuid"
 )
 
 type LocalMessage struct {
 	*BaseMessage
+	mutex         sync.Mutex
+	deliveryCount int
+	settled       bool
+	requeue       func(msg *LocalMessage) error
 }
 
 func NewLocalMessage() (msg Message, err error) {
@@ -28,7 +33,74 @@ func NewLocalMessage() (msg Message, err error) {
 	return
 }
 
+// Rsvp acknowledges the message if yes is true, else the message is rejected without requeue.
 func (lm *LocalMessage) Rsvp(yes bool, options ...Option) (err error) {
-	// Local message does not support RSVP
+	if yes {
+		err = lm.Ack()
+	} else {
+		err = lm.Nack(false)
+	}
+	return
+}
+
+// Ack acknowledges the message. This is a no-op for the local provider.
+func (lm *LocalMessage) Ack() (err error) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	lm.settled = true
 	return
 }
+
+// Nack rejects the message. If requeue is true the message is added back to the destination it was
+// received from and is delivered again after the messages already waiting at the destination.
+// Nack has no effect if the message is already acknowledged or rejected.
+func (lm *LocalMessage) Nack(requeue bool) (err error) {
+	lm.mutex.Lock()
+	if lm.settled {
+		lm.mutex.Unlock()
+		return
+	}
+	lm.settled = true
+	requeueFn := lm.requeue
+	lm.mutex.Unlock()
+	if requeue && requeueFn != nil {
+		err = requeueFn(lm)
+	}
+	return
+}
+
+// DeliveryCount returns the number of times the message is delivered.
+func (lm *LocalMessage) DeliveryCount() int {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	return lm.deliveryCount
+}
+
+// delivered records a delivery of the message.
+func (lm *LocalMessage) delivered(requeue func(msg *LocalMessage) error) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	lm.deliveryCount++
+	lm.settled = false
+	lm.requeue = requeue
+}
+
+// copy returns a copy of the message with the same id, headers and body.
+func (lm *LocalMessage) copy() *LocalMessage {
+	headers := make(map[string]interface{}, len(lm.headers))
+	for k, v := range lm.headers {
+		headers[k] = v
+	}
+	headerTypes := make(map[string]reflect.Kind, len(lm.headerTypes))
+	for k, v := range lm.headerTypes {
+		headerTypes[k] = v
+	}
+	return &LocalMessage{
+		BaseMessage: &BaseMessage{
+			id:          lm.id,
+			headers:     headers,
+			headerTypes: headerTypes,
+			body:        bytes.NewBuffer(append([]byte{}, lm.body.Bytes()...)),
+		},
+	}
+}
@@ -1,11 +1,16 @@
 package messaging
 
 import (
-	"math/rand"
+	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
-	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/uuid"
 )
 
 const (
@@ -15,11 +20,166 @@ const (
 
 var localProviderSchemes = []string{LocalMsgScheme}
 
+// ErrDestinationClosed is returned when a message is sent to or received from a closed local destination.
+var ErrDestinationClosed = errors.New("destination closed")
+
+// localQueue is an unbounded FIFO queue of messages.
+// If priority is true the messages with a higher priority are ahead of the messages with a lower priority.
+type localQueue struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	messages []Message
+	closed   bool
+	priority bool
+}
+
+func newLocalQueue() *localQueue {
+	q := &localQueue{}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// push adds the message to the tail of the queue.
+func (q *localQueue) push(msg Message) (err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		err = ErrDestinationClosed
+		return
+	}
+	if q.priority {
+		p := messagePriority(msg)
+		i := sort.Search(len(q.messages), func(i int) bool {
+			return messagePriority(q.messages[i]) < p
+		})
+		q.messages = append(q.messages, nil)
+		copy(q.messages[i+1:], q.messages[i:])
+		q.messages[i] = msg
+	} else {
+		q.messages = append(q.messages, msg)
+	}
+	q.cond.Signal()
+	return
+}
+
+// pushFront adds the message back to the head of the queue, ahead of the messages of the same priority.
+func (q *localQueue) pushFront(msg Message) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return
+	}
+	i := 0
+	if q.priority {
+		p := messagePriority(msg)
+		i = sort.Search(len(q.messages), func(i int) bool {
+			return messagePriority(q.messages[i]) <= p
+		})
+	}
+	q.messages = append(q.messages, nil)
+	copy(q.messages[i+1:], q.messages[i:])
+	q.messages[i] = msg
+	q.cond.Signal()
+}
+
+// enablePriority orders the messages of the queue by priority.
+func (q *localQueue) enablePriority() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if !q.priority {
+		q.priority = true
+		sort.SliceStable(q.messages, func(i, j int) bool {
+			return messagePriority(q.messages[i]) > messagePriority(q.messages[j])
+		})
+	}
+}
+
+// pop removes the message at the head of the queue waiting for one to arrive if the queue is empty.
+// Expired messages are dropped. It returns false once the queue is closed or stopped (if provided) returns true.
+// stopped is called holding the mutex of the queue.
+func (q *localQueue) pop(stopped func() bool) (msg Message, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		for len(q.messages) == 0 && !q.closed && (stopped == nil || !stopped()) {
+			q.cond.Wait()
+		}
+		if q.closed || (stopped != nil && stopped()) {
+			return
+		}
+		msg = q.messages[0]
+		q.messages[0] = nil
+		q.messages = q.messages[1:]
+		if !isExpired(msg, time.Now()) {
+			ok = true
+			return
+		}
+		logger.TraceF("dropping expired message %s", msg.Id())
+	}
+}
+
+// drain removes all the messages available in the queue without waiting. Expired messages are dropped.
+func (q *localQueue) drain() (msgs []Message) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	now := time.Now()
+	for _, msg := range q.messages {
+		if isExpired(msg, now) {
+			logger.TraceF("dropping expired message %s", msg.Id())
+		} else {
+			msgs = append(msgs, msg)
+		}
+	}
+	q.messages = nil
+	return
+}
+
+func (q *localQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// localListener is a listener registered for a destination along with its workers.
+type localListener struct {
+	id       string
+	listener func(msg Message)
+	// removed is guarded by the mutex of the subscription queue
+	removed bool
+	workers sync.WaitGroup
+}
+
+// localSubscription is the queue of messages for the listeners of a group. The listeners of the group
+// compete for the messages of the subscription.
+type localSubscription struct {
+	queue     *localQueue
+	listeners map[string]*localListener
+}
+
+// localDestination holds the messages sent to a destination and the subscriptions of the listener groups.
+type localDestination struct {
+	queue         *localQueue
+	subscriptions map[string]*localSubscription
+	// dispatcher is the generation of the running dispatcher, 0 if the destination has no listeners.
+	// It is changed holding both the mutex of the provider and the mutex of the queue.
+	dispatcher  int
+	generations int
+	priority    bool
+}
+
+// localListenerRef locates a listener within the destinations of the local provider.
+type localListenerRef struct {
+	host  string
+	group string
+}
+
 // LocalProvider is an implementation of the Provider interface
 type LocalProvider struct {
-	mutex        sync.Mutex
-	destinations map[string]chan Message
-	listeners    map[string]map[string][]func(msg Message)
+	mutex         sync.Mutex
+	destinations  map[string]*localDestination
+	listeners     map[string]localListenerRef
+	listenerCount int
 }
 
 func (lp *LocalProvider) Id() string {
@@ -31,31 +191,45 @@ func (lp *LocalProvider) NewMessage(scheme string, options ...Option) (msg Messa
 	return
 }
 
-func (lp *LocalProvider) getChan(url *url.URL) (result chan Message) {
+// getDestination returns the destination for the url creating it if required.
+// The PriorityQueue option orders the messages of the destination by their priority.
+func (lp *LocalProvider) getDestination(url *url.URL, options ...Option) (result *localDestination) {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
 	var ok bool
 	result, ok = lp.destinations[url.Host]
 	if !ok {
-		lp.mutex.Lock()
-		defer lp.mutex.Unlock()
-		localMsgChannel := make(chan Message)
-		lp.destinations[url.Host] = localMsgChannel
-		result = localMsgChannel
+		result = &localDestination{
+			queue:         newLocalQueue(),
+			subscriptions: make(map[string]*localSubscription),
+		}
+		lp.destinations[url.Host] = result
+	}
+	if priority, _ := GetOptValue[bool](PriorityQueue, options...); priority && !result.priority {
+		result.priority = true
+		result.queue.enablePriority()
+		for _, subscription := range result.subscriptions {
+			subscription.queue.enablePriority()
+		}
 	}
 	return
 }
 
+// Send adds the message to the destination. Messages are delivered in the order they are sent unless the
+// destination is a priority queue. The TimestampHeader is set if the message does not have one.
 func (lp *LocalProvider) Send(url *url.URL, msg Message, options ...Option) (err error) {
-	destination := lp.getChan(url)
-	go func() {
-		logger.TraceF("sending message to channel %s", url.Host)
-		destination <- msg
-	}()
+	destination := lp.getDestination(url, options...)
+	if _, ok := msg.GetHeader(TimestampHeader); !ok {
+		msg.SetHeader(TimestampHeader, time.Now())
+	}
+	logger.TraceF("sending message to channel %s", url.Host)
+	err = destination.queue.push(msg)
 	return
 }
 
 func (lp *LocalProvider) SendBatch(url *url.URL, msgs []Message, options ...Option) (err error) {
 	for _, message := range msgs {
-		err = lp.Send(url, message)
+		err = lp.Send(url, message, options...)
 		if err != nil {
 			return
 		}
@@ -63,74 +237,297 @@ func (lp *LocalProvider) SendBatch(url *url.URL, msgs []Message, options ...Opti
 	return
 }
 
+// Receive waits for the next message sent to the destination. While the destination has listeners, the messages are
+// dispatched to the listeners and Receive competes with them for the messages.
 func (lp *LocalProvider) Receive(url *url.URL, options ...Option) (msg Message, err error) {
-	receiver := lp.getChan(url)
-	for m := range receiver {
-		msg = m
+	destination := lp.getDestination(url, options...)
+	var ok bool
+	msg, ok = destination.queue.pop(nil)
+	if !ok {
+		err = ErrDestinationClosed
+		return
 	}
+	prepareDelivery(msg, destination.queue)
 	return
 }
 
+// ReceiveCtx waits for the next message sent to the destination until the ctx is done. No message is taken from the
+// destination once the ctx is done.
+func (lp *LocalProvider) ReceiveCtx(ctx context.Context, url *url.URL, options ...Option) (msg Message, err error) {
+	destination := lp.getDestination(url, options...)
+	queue := destination.queue
+	stop := context.AfterFunc(ctx, func() {
+		queue.mutex.Lock()
+		queue.cond.Broadcast()
+		queue.mutex.Unlock()
+	})
+	defer stop()
+	var ok bool
+	msg, ok = queue.pop(func() bool {
+		return ctx.Err() != nil
+	})
+	if !ok {
+		if err = ctx.Err(); err == nil {
+			err = ErrDestinationClosed
+		}
+		return
+	}
+	prepareDelivery(msg, queue)
+	return
+}
+
+// ReceiveBatch waits for the next message sent to the destination and returns it along with all the
+// other messages available at the destination.
 func (lp *LocalProvider) ReceiveBatch(url *url.URL, options ...Option) (msgs []Message, err error) {
-	receiver := lp.getChan(url)
-	for m := range receiver {
-		msgs = append(msgs, m)
+	destination := lp.getDestination(url, options...)
+	msg, ok := destination.queue.pop(nil)
+	if !ok {
+		err = ErrDestinationClosed
+		return
+	}
+	msgs = append([]Message{msg}, destination.queue.drain()...)
+	for _, m := range msgs {
+		prepareDelivery(m, destination.queue)
 	}
 	return
 }
 
+// AddListener registers a listener for the destination.
+// Listeners registered with the same group (NamedListener option) compete for the messages, every group
+// and every listener without a group receives a copy of each message.
+// The Concurrency option sets the number of workers processing the messages for the listener.
 func (lp *LocalProvider) AddListener(url *url.URL, listener func(msg Message), options ...Option) (err error) {
-	// Get channel first before locking to avoid dead locl
-	channel := lp.getChan(url)
+	destination := lp.getDestination(url, options...)
 	lp.mutex.Lock()
 	defer lp.mutex.Unlock()
-	createListener := false
-	if _, ok := lp.listeners[url.Host]; !ok {
+	optionsResolver := NewOptionsResolver(options...)
+	lp.listenerCount++
+	id, hasId := ResolveOptValue[string](ListenerId, optionsResolver)
+	if !hasId {
+		id = "local-listener-" + strconv.Itoa(lp.listenerCount)
+	}
+	if _, exists := lp.listeners[id]; exists {
+		err = fmt.Errorf("%w: %s", ErrDuplicateListener, id)
+		return
+	}
+	group, hasGroup := ResolveOptValue[string](NamedListener, optionsResolver)
+	if !hasGroup {
+		group = unnamedListeners + id
+	}
+	concurrency, _ := ResolveOptValue[int](Concurrency, optionsResolver)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	subscription, ok := destination.subscriptions[group]
+	if !ok {
+		subscription = &localSubscription{
+			queue:     newLocalQueue(),
+			listeners: make(map[string]*localListener),
+		}
+		subscription.queue.priority = destination.priority
+		destination.subscriptions[group] = subscription
+	}
+	l := &localListener{
+		id:       id,
+		listener: listener,
+	}
+	subscription.listeners[id] = l
+	lp.listeners[id] = localListenerRef{
+		host:  url.Host,
+		group: group,
+	}
+	l.workers.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go consume(subscription.queue, l)
+	}
 
-		lp.listeners[url.Host] = make(map[string][]func(msg Message))
+	if destination.dispatcher == 0 {
+		destination.queue.mutex.Lock()
+		destination.generations++
+		destination.dispatcher = destination.generations
+		destination.queue.mutex.Unlock()
+		go lp.dispatch(destination, destination.dispatcher)
+	}
+	return
+}
+
+// RemoveListener removes the listener with the id. It waits for the messages being processed by
+// the listener to complete before returning, hence it must not be called by the listener itself, which can remove
+// itself from another goroutine instead.
+// The messages pending for the group of its last listener are kept at the destination for Receive if the
+// destination has no other listeners. Otherwise they are dropped as the other groups received their own copies.
+func (lp *LocalProvider) RemoveListener(id string) (err error) {
+	lp.mutex.Lock()
+	ref, ok := lp.listeners[id]
+	if !ok {
+		lp.mutex.Unlock()
+		err = fmt.Errorf("%w: %s", ErrListenerNotFound, id)
+		return
+	}
+	delete(lp.listeners, id)
+	subscription := lp.destinations[ref.host].subscriptions[ref.group]
+	l := subscription.listeners[id]
+	delete(subscription.listeners, id)
+	destination := lp.destinations[ref.host]
+	unsubscribed := len(subscription.listeners) == 0
+	lastSubscription := false
+	if unsubscribed {
+		delete(destination.subscriptions, ref.group)
+		if len(destination.subscriptions) == 0 {
+			// the messages are kept at the destination for Receive once the last listener is removed
+			lastSubscription = true
+			destination.queue.mutex.Lock()
+			destination.dispatcher = 0
+			destination.queue.cond.Broadcast()
+			destination.queue.mutex.Unlock()
+		}
+	}
+	lp.mutex.Unlock()
 
-		createListener = true
+	subscription.queue.mutex.Lock()
+	l.removed = true
+	subscription.queue.cond.Broadcast()
+	subscription.queue.mutex.Unlock()
+	l.workers.Wait()
+	if unsubscribed {
+		// the messages requeued once the subscription is closed are rejected by the queue
+		subscription.queue.close()
+		pending := subscription.queue.drain()
+		if lastSubscription {
+			for i := len(pending) - 1; i >= 0; i-- {
+				destination.queue.pushFront(pending[i])
+			}
+		} else if len(pending) > 0 {
+			logger.WarnF("dropping %d messages pending for the removed listener %s of channel %s", len(pending),
+				id, ref.host)
+		}
 	}
+	return
+}
 
-	optionsResolver := NewOptionsResolver(options...)
-	namedListener, hasNamed := ResolveOptValue[string]("NamedListener", optionsResolver)
-	if hasNamed {
-		lp.listeners[url.Host][namedListener] = append(lp.listeners[url.Host][namedListener], listener)
-	} else {
-		lp.listeners[url.Host][unnamedListeners] = append(lp.listeners[url.Host][unnamedListeners], listener)
-	}
-
-	if createListener {
-		go func() {
-
-			for m := range channel {
-				for name, listener := range lp.listeners[url.Host] {
-					if name == unnamedListeners {
-						for _, l := range listener {
-							go l(m)
-						}
-					} else {
-						lIDx := rand.Intn(len(listener))
-						go listener[lIDx](m)
-					}
-				}
+// dispatch delivers a copy of every message sent to the destination to each of its subscriptions.
+// It returns once the dispatcher of the generation is stopped as the last listener of the destination is removed.
+func (lp *LocalProvider) dispatch(destination *localDestination, generation int) {
+	stopped := func() bool {
+		return destination.dispatcher != generation
+	}
+	for {
+		msg, ok := destination.queue.pop(stopped)
+		if !ok {
+			return
+		}
+		lp.mutex.Lock()
+		if stopped() {
+			// the listeners are removed after the message is popped
+			destination.queue.pushFront(msg)
+			lp.mutex.Unlock()
+			return
+		}
+		subscriptions := make([]*localSubscription, 0, len(destination.subscriptions))
+		for _, subscription := range destination.subscriptions {
+			subscriptions = append(subscriptions, subscription)
+		}
+		// the copies are made before any is pushed as the listeners may modify the message once it is pushed
+		msgs := make([]Message, len(subscriptions))
+		for i := range subscriptions {
+			msgs[i] = msg
+			if i > 0 {
+				msgs[i] = copyMessage(msg)
 			}
-		}()
+		}
+		// the messages are pushed holding the mutex so none is pushed to a subscription once it is removed
+		for i, subscription := range subscriptions {
+			_ = subscription.queue.push(msgs[i])
+		}
+		lp.mutex.Unlock()
+	}
+}
+
+// consume delivers the messages of the subscription queue to the listener until the listener is removed.
+// A message requeued by the listener is added back to the subscription queue.
+func consume(queue *localQueue, l *localListener) {
+	defer l.workers.Done()
+	for {
+		msg, ok := queue.pop(func() bool {
+			return l.removed
+		})
+		if !ok {
+			return
+		}
+		prepareDelivery(msg, queue)
+		l.listener(msg)
+	}
+}
+
+// messagePriority returns the priority of the message limited to the range 0 to MaxPriority.
+func messagePriority(msg Message) (priority int) {
+	priority = DefaultPriority
+	if v, ok := msg.GetHeader(PriorityHeader); ok {
+		if p, isInt := v.(int); isInt {
+			priority = min(max(p, 0), MaxPriority)
+		}
+	}
+	return
+}
+
+// isExpired checks if the TTLHeader of the message has elapsed since its TimestampHeader.
+func isExpired(msg Message, now time.Time) bool {
+	v, ok := msg.GetHeader(TTLHeader)
+	ttl, isDuration := v.(time.Duration)
+	if !ok || !isDuration || ttl <= 0 {
+		return false
+	}
+	v, ok = msg.GetHeader(TimestampHeader)
+	timestamp, isTime := v.(time.Time)
+	return ok && isTime && now.After(timestamp.Add(ttl))
+}
+
+// prepareDelivery records the delivery of a local message and the queue it is requeued to.
+func prepareDelivery(msg Message, queue *localQueue) {
+	if lm, ok := msg.(*LocalMessage); ok {
+		lm.delivered(func(m *LocalMessage) error {
+			return queue.push(m)
+		})
+	}
+}
+
+// copyMessage returns a copy of the local message. Any other message is returned as is.
+func copyMessage(msg Message) Message {
+	if lm, ok := msg.(*LocalMessage); ok {
+		return lm.copy()
+	}
+	return msg
+}
+
+// NewReplyDestination creates a private channel with a random name for the replies of the requests.
+func (lp *LocalProvider) NewReplyDestination() (u *url.URL, err error) {
+	var uid *uuid.UUID
+	uid, err = uuid.V4()
+	if err == nil {
+		u = &url.URL{
+			Scheme: LocalMsgScheme,
+			Host:   "reply-" + uid.String(),
+		}
 	}
 	return
 }
 
 func (lp *LocalProvider) Setup() (err error) {
 	lp.mutex = sync.Mutex{}
-	lp.destinations = make(map[string]chan Message)
-	lp.listeners = make(map[string]map[string][]func(msg Message))
+	lp.destinations = make(map[string]*localDestination)
+	lp.listeners = make(map[string]localListenerRef)
 	return nil
 }
 
 func (lp *LocalProvider) Close() (err error) {
-	for dest, ch := range lp.destinations {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	for dest, destination := range lp.destinations {
 		logger.TraceF("closing channel for desination %s", dest)
-		ioutils.CloseChannel[Message](ch)
+		destination.queue.close()
+		for _, subscription := range destination.subscriptions {
+			subscription.queue.close()
+		}
 	}
 	return
 }
@@ -1,8 +1,14 @@
 package messaging
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"oss.nandlabs.io/golly/testing/assert"
 )
@@ -75,3 +81,605 @@ func TestLocalProvider_AddListener(t *testing.T) {
 	}
 	_ = lms.Send(uri, msg1)
 }
+
+func TestLocalProvider_Receive(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://receive-test")
+	for _, body := range []string{"first", "second", "third"} {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(body)
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	msg, err := lms.Receive(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", msg.ReadAsStr())
+	assert.Equal(t, 1, msg.DeliveryCount())
+	// requeue adds the message after the messages already waiting
+	assert.NoError(t, msg.Nack(true))
+	msgs, err := lms.ReceiveBatch(uri)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.Equal(t, "second", msgs[0].ReadAsStr())
+	assert.Equal(t, "third", msgs[1].ReadAsStr())
+	assert.Equal(t, "first", msgs[2].ReadAsStr())
+	assert.Equal(t, 2, msgs[2].DeliveryCount())
+}
+
+func TestLocalProvider_ManualAckRequeue(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://manual-ack-test")
+	var mutex sync.Mutex
+	var deliveries []string
+	done := make(chan struct{})
+	err := lms.AddListener(uri, func(msg Message) {
+		mutex.Lock()
+		deliveries = append(deliveries, fmt.Sprintf("%s:%d", msg.ReadAsStr(), msg.DeliveryCount()))
+		count := len(deliveries)
+		mutex.Unlock()
+		if msg.ReadAsStr() == "m1" && msg.DeliveryCount() == 1 {
+			assert.NoError(t, msg.Nack(true))
+		} else {
+			assert.NoError(t, msg.Ack())
+		}
+		if count == 4 {
+			close(done)
+		}
+	}, WithManualAck())
+	assert.NoError(t, err)
+	for _, body := range []string{"m1", "m2", "m3"} {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(body)
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the messages")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"m1:1", "m2:1", "m3:1", "m1:2"}, deliveries)
+}
+
+func TestLocalProvider_AutoAck(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://auto-ack-test")
+	var mutex sync.Mutex
+	var deliveries []string
+	done := make(chan struct{})
+	err := lms.AddListener(uri, func(msg Message) {
+		mutex.Lock()
+		deliveries = append(deliveries, fmt.Sprintf("%s:%d", msg.ReadAsStr(), msg.DeliveryCount()))
+		count := len(deliveries)
+		mutex.Unlock()
+		if count == 3 {
+			close(done)
+		}
+		if msg.ReadAsStr() == "poison" && msg.DeliveryCount() == 1 {
+			panic("unable to process message")
+		}
+	})
+	assert.NoError(t, err)
+	for _, body := range []string{"poison", "ok"} {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(body)
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the messages")
+	}
+	// acknowledged messages are not delivered again
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"poison:1", "ok:1", "poison:2"}, deliveries)
+}
+
+func TestManager_SendReceiveObject(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://object-test")
+	input := map[string]any{"name": "golly"}
+	assert.NoError(t, lms.SendObject(uri, input))
+	var got map[string]any
+	msg, err := lms.ReceiveObject(uri, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, input, got)
+	ct, _ := msg.GetStrHeader(ContentTypeHeader)
+	assert.Equal(t, "application/json", ct)
+}
+
+func TestLocalProvider_ListenerGroups(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://group-test")
+	const total = 5000
+	const concurrency = 4
+	var workers, groupInFlight, groupMaxInFlight int32
+	var auditCount int32
+	wg := sync.WaitGroup{}
+	wg.Add(2 * total)
+	seen := sync.Map{}
+	worker := func(msg Message) {
+		defer wg.Done()
+		inFlight := atomic.AddInt32(&groupInFlight, 1)
+		for {
+			max := atomic.LoadInt32(&groupMaxInFlight)
+			if inFlight <= max || atomic.CompareAndSwapInt32(&groupMaxInFlight, max, inFlight) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Microsecond)
+		atomic.AddInt32(&workers, 1)
+		if _, dup := seen.LoadOrStore(msg.ReadAsStr(), true); dup {
+			t.Errorf("message %s delivered more than once to the group", msg.ReadAsStr())
+		}
+		atomic.AddInt32(&groupInFlight, -1)
+	}
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, err := lms.AddListenerWithOptions(uri, worker, WithGroup("workers"), WithConcurrency(concurrency))
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		defer wg.Done()
+		atomic.AddInt32(&auditCount, 1)
+	}, WithGroup("audit"))
+	assert.NoError(t, err)
+	ids = append(ids, id)
+	defer func() {
+		for _, id := range ids {
+			assert.NoError(t, lms.RemoveListener(id))
+		}
+	}()
+	for i := 0; i < total; i++ {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(fmt.Sprintf("m%d", i))
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for the messages")
+	}
+	assert.Equal(t, int32(total), atomic.LoadInt32(&workers))
+	assert.Equal(t, int32(total), atomic.LoadInt32(&auditCount))
+	max := atomic.LoadInt32(&groupMaxInFlight)
+	assert.True(t, max > 1)
+	assert.True(t, max <= 2*concurrency)
+}
+
+func TestLocalProvider_RemoveListener(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://remove-listener-test")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var delivered, completed int32
+	id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		if atomic.AddInt32(&delivered, 1) == 1 {
+			close(started)
+		}
+		<-release
+		atomic.AddInt32(&completed, 1)
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(fmt.Sprintf("m%d", i))
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	<-started
+	removed := make(chan error)
+	go func() {
+		removed <- lms.RemoveListener(id)
+	}()
+	select {
+	case <-removed:
+		t.Fatal("RemoveListener returned before the in-flight callback completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err = <-removed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for RemoveListener")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&completed))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&delivered))
+	// the pending messages are kept at the destination
+	for i := 1; i < 3; i++ {
+		msg, err := lms.Receive(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("m%d", i), msg.ReadAsStr())
+	}
+
+	err = lms.RemoveListener(id)
+	assert.True(t, errors.Is(err, ErrListenerNotFound))
+}
+
+func TestLocalProvider_RemoveListenerFromListener(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://remove-from-listener-test")
+	removed := make(chan error, 1)
+	var id string
+	var delivered int32
+	id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		if atomic.AddInt32(&delivered, 1) == 1 {
+			// RemoveListener waits for the listener to return, hence it is called from another goroutine
+			go func() {
+				removed <- lms.RemoveListener(id)
+			}()
+		}
+	})
+	assert.NoError(t, err)
+	msg, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	assert.NoError(t, lms.Send(uri, msg))
+	select {
+	case err = <-removed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for RemoveListener")
+	}
+	msg, err = lms.NewMessage("chan")
+	assert.NoError(t, err)
+	_, _ = msg.SetBodyStr("after")
+	assert.NoError(t, lms.Send(uri, msg))
+	msg, err = lms.Receive(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "after", msg.ReadAsStr())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&delivered))
+}
+
+func TestLocalProvider_RemoveGroupDropsPending(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://remove-group-test")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var slowDelivered int32
+	slowId, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		if atomic.AddInt32(&slowDelivered, 1) == 1 {
+			close(started)
+		}
+		<-release
+	}, WithGroup("slow"))
+	assert.NoError(t, err)
+	fast := make(chan string, 3)
+	fastId, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		fast <- msg.ReadAsStr()
+	}, WithGroup("fast"))
+	assert.NoError(t, err)
+	defer lms.RemoveListener(fastId)
+	for i := 0; i < 3; i++ {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(fmt.Sprintf("m%d", i))
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	<-started
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-fast:
+			assert.Equal(t, fmt.Sprintf("m%d", i), got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the fast group")
+		}
+	}
+	removed := make(chan error)
+	go func() {
+		removed <- lms.RemoveListener(slowId)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	assert.NoError(t, <-removed)
+	// the messages pending for the removed group are dropped as the fast group received its own copies
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = lms.ReceiveCtx(ctx, uri)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slowDelivered))
+}
+
+func TestLocalProvider_ReceiveAfterRemoveListener(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://receive-after-remove-test")
+	received := make(chan Message, 1)
+	id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		received <- msg
+	})
+	assert.NoError(t, err)
+	msg, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	_, _ = msg.SetBodyStr("listened")
+	assert.NoError(t, lms.Send(uri, msg))
+	select {
+	case msg = <-received:
+		assert.Equal(t, "listened", msg.ReadAsStr())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the listener")
+	}
+	assert.NoError(t, lms.RemoveListener(id))
+
+	for i := 0; i < 3; i++ {
+		msg, err = lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(fmt.Sprintf("m%d", i))
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	for i := 0; i < 3; i++ {
+		msg, err = lms.Receive(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("m%d", i), msg.ReadAsStr())
+	}
+	select {
+	case msg = <-received:
+		t.Fatalf("message %s delivered to a removed listener", msg.ReadAsStr())
+	default:
+	}
+}
+
+func TestManager_DeadLetter(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://dead-letter-source")
+	dlq, _ := url.Parse("chan://dead-letter-destination")
+	// the stats of the manager are shared by the test runs
+	deadLettered := lms.Stats().DeadLettered[uri.String()]
+	var attempts int32
+	id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		atomic.AddInt32(&attempts, 1)
+		panic("unable to process message")
+	}, WithRetryPolicy(2, 10), WithDeadLetter(dlq))
+	assert.NoError(t, err)
+	defer lms.RemoveListener(id)
+	dead := make(chan Message, 1)
+	dlqId, err := lms.AddListenerWithOptions(dlq, func(msg Message) {
+		dead <- msg
+	})
+	assert.NoError(t, err)
+	defer lms.RemoveListener(dlqId)
+
+	msg, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	_, _ = msg.SetBodyStr("poison")
+	assert.NoError(t, lms.Send(uri, msg))
+	var got Message
+	select {
+	case got = <-dead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the dead lettered message")
+	}
+	assert.Equal(t, "poison", got.ReadAsStr())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	cause, _ := got.GetStrHeader(DeadLetterErrorHeader)
+	assert.Equal(t, "unable to process message", cause)
+	count, _ := got.GetIntHeader(DeadLetterAttemptsHeader)
+	assert.Equal(t, 3, count)
+	source, _ := got.GetStrHeader(DeadLetterSourceHeader)
+	assert.Equal(t, uri.String(), source)
+	firstSeen, ok := got.GetStrHeader(DeadLetterFirstSeenHeader)
+	assert.True(t, ok)
+	_, err = time.Parse(time.RFC3339Nano, firstSeen)
+	assert.NoError(t, err)
+	assert.Equal(t, deadLettered+1, lms.Stats().DeadLettered[uri.String()])
+}
+
+func TestManager_RetryWaitDoesNotBlock(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://retry-wait-test")
+	processed := make(chan string, 2)
+	id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		if msg.ReadAsStr() == "failing" && msg.DeliveryCount() == 1 {
+			panic("failed")
+		}
+		processed <- msg.ReadAsStr()
+	}, WithRetryPolicy(1, 300))
+	assert.NoError(t, err)
+	defer lms.RemoveListener(id)
+	for _, body := range []string{"failing", "next"} {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(body)
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	start := time.Now()
+	for _, want := range []string{"next", "failing"} {
+		select {
+		case got := <-processed:
+			assert.Equal(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %s", want)
+		}
+		if want == "next" {
+			assert.True(t, time.Since(start) < 300*time.Millisecond)
+		}
+	}
+	assert.True(t, time.Since(start) >= 300*time.Millisecond)
+}
+
+func TestManager_RetryPolicy(t *testing.T) {
+	lms := GetManager()
+	recoversDlq, _ := url.Parse("chan://retry-recovers-dlq")
+	noRetriesDlq, _ := url.Parse("chan://retry-none-dlq")
+	tests := []struct {
+		name     string
+		options  []Option
+		fail     int32
+		want     int32
+		wantDead int64
+	}{
+		{
+			name:    "RecoversWithinRetries",
+			options: []Option{WithRetryPolicy(2, 0), WithDeadLetter(recoversDlq)},
+			fail:    2,
+			want:    3,
+		},
+		{
+			name:    "DroppedWithoutDeadLetter",
+			options: []Option{WithRetryPolicy(2, 0)},
+			fail:    100,
+			want:    3,
+		},
+		{
+			name: "DroppedByDefault",
+			fail: 100,
+			want: DefaultMaxRedeliveries + 1,
+		},
+		{
+			name:     "DeadLetterWithoutRetries",
+			options:  []Option{WithDeadLetter(noRetriesDlq)},
+			fail:     100,
+			want:     1,
+			wantDead: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri, _ := url.Parse("chan://retry-" + tt.name)
+			deadLettered := lms.Stats().DeadLettered[uri.String()]
+			var attempts int32
+			id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+				if atomic.AddInt32(&attempts, 1) <= tt.fail {
+					panic("failed")
+				}
+			}, tt.options...)
+			assert.NoError(t, err)
+			msg, err := lms.NewMessage("chan")
+			assert.NoError(t, err)
+			assert.NoError(t, lms.Send(uri, msg))
+			time.Sleep(200 * time.Millisecond)
+			assert.NoError(t, lms.RemoveListener(id))
+			assert.Equal(t, tt.want, atomic.LoadInt32(&attempts))
+			assert.Equal(t, deadLettered+tt.wantDead, lms.Stats().DeadLettered[uri.String()])
+		})
+	}
+}
+
+func TestLocalProvider_Headers(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://headers-test")
+	received := make(chan Message, 1)
+	id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+		received <- msg
+	})
+	assert.NoError(t, err)
+	defer lms.RemoveListener(id)
+	msg, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	msg.SetHeader(CorrelationIdHeader, "correlation-1")
+	msg.SetHeader(PriorityHeader, 7)
+	msg.SetHeader("X-Tags", []string{"a", "b"})
+	assert.NoError(t, lms.Send(uri, msg))
+	var got Message
+	select {
+	case got = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+	headers := got.Headers()
+	assert.Equal(t, "correlation-1", headers[CorrelationIdHeader])
+	assert.Equal(t, 7, headers[PriorityHeader])
+	assert.Equal(t, []string{"a", "b"}, headers["X-Tags"])
+	_, ok := headers[TimestampHeader].(time.Time)
+	assert.True(t, ok)
+	// headers is a copy of the headers of the message
+	headers["X-Tags"] = nil
+	tags, _ := got.GetHeader("X-Tags")
+	assert.Equal(t, []string{"a", "b"}, tags)
+}
+
+func TestLocalProvider_TTL(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://ttl-test")
+	for _, body := range []string{"expires", "stays"} {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(body)
+		if body == "expires" {
+			msg.SetHeader(TTLHeader, 20*time.Millisecond)
+		} else {
+			msg.SetHeader(TTLHeader, time.Minute)
+		}
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	time.Sleep(50 * time.Millisecond)
+	msg, err := lms.Receive(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "stays", msg.ReadAsStr())
+
+	expired, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	expired.SetHeader(TimestampHeader, time.Now().Add(-time.Hour))
+	expired.SetHeader(TTLHeader, time.Minute)
+	live, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	_, _ = live.SetBodyStr("live")
+	assert.NoError(t, lms.SendBatch(uri, []Message{expired, live}))
+	msgs, err := lms.ReceiveBatch(uri)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "live", msgs[0].ReadAsStr())
+}
+
+func TestLocalProvider_PriorityQueue(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://priority-test")
+	for i, priority := range []int{1, 5, -1, 9, 5, 12} {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		_, _ = msg.SetBodyStr(fmt.Sprintf("m%d", i))
+		if priority >= 0 {
+			msg.SetHeader(PriorityHeader, priority)
+		}
+		assert.NoError(t, lms.Send(uri, msg, WithPriorityQueue()))
+	}
+	var got []string
+	for i := 0; i < 6; i++ {
+		msg, err := lms.Receive(uri)
+		assert.NoError(t, err)
+		got = append(got, msg.ReadAsStr())
+	}
+	// 12 is limited to 9, messages of the same priority are in the order they are sent
+	assert.Equal(t, []string{"m3", "m5", "m1", "m4", "m2", "m0"}, got)
+}
+
+func TestLocalProvider_FanOutCopies(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://fan-out-copies-test")
+	const total = 200
+	wg := sync.WaitGroup{}
+	wg.Add(2 * total)
+	var ids []string
+	for _, name := range []string{"first", "second"} {
+		id, err := lms.AddListenerWithOptions(uri, func(msg Message) {
+			defer wg.Done()
+			msg.SetHeader("listener", name)
+			if v, _ := msg.GetHeader("listener"); v != name {
+				t.Errorf("header listener = %v, want %s", v, name)
+			}
+		})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	for i := 0; i < total; i++ {
+		msg, err := lms.NewMessage("chan")
+		assert.NoError(t, err)
+		assert.NoError(t, lms.Send(uri, msg))
+	}
+	wg.Wait()
+	for _, id := range ids {
+		assert.NoError(t, lms.RemoveListener(id))
+	}
+}
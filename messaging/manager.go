@@ -1,11 +1,14 @@
 package messaging
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"sync"
 
 	"oss.nandlabs.io/golly/errutils"
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/uuid"
 )
 
 var defaultManager Manager
@@ -16,14 +19,42 @@ type Manager interface {
 	Provider
 	Wait()
 	Register(Provider)
+	// AddListenerWithOptions registers a listener for the url and returns the id of the listener.
+	// Options like WithConcurrency and WithGroup configure the delivery of messages to the listener.
+	AddListenerWithOptions(u *url.URL, listener func(msg Message), options ...Option) (string, error)
+	// SendObject sends the object encoded as JSON to the url
+	SendObject(u *url.URL, v any, options ...Option) error
+	// ReceiveObject receives a single message from the url and decodes its body into v.
+	// The codec is selected using the content type header of the message. The message is acknowledged once decoded.
+	ReceiveObject(u *url.URL, v any, options ...Option) (Message, error)
+	// Stats returns the counters of the messages handled by the manager
+	Stats() Stats
+	// Request sends the message to the destination and waits for the reply or the context to be done
+	Request(ctx context.Context, dest *url.URL, msg Message) (Message, error)
+	// ReplyHandler registers the handler for the requests sent to the destination and sends the returned
+	// message to the reply to destination of the request. It returns the id of the listener.
+	ReplyHandler(dest *url.URL, fn func(msg Message) (Message, error), options ...Option) (string, error)
+	// SendCtx injects the trace context of the ctx into the message and sends it to the url
+	SendCtx(ctx context.Context, u *url.URL, msg Message, options ...Option) error
+	// ReceiveCtx receives a single message from the url waiting at most until the ctx is done
+	ReceiveCtx(ctx context.Context, u *url.URL, options ...Option) (Message, error)
+	// AddListenerCtx registers a listener called with a context carrying the trace context of the message and
+	// returns the id of the listener
+	AddListenerCtx(u *url.URL, listener func(ctx context.Context, msg Message), options ...Option) (string, error)
 }
 
 // managerImpl struct is used to manage the known Messaging providers.
 // It includes a mutex to handle concurrent access to the known providers
 type managerImpl struct {
 	knownProviders map[string]Provider
+	listeners      map[string]Provider
+	deadLettered   map[string]int64
 	mutex          sync.Mutex
 	waitgroup      sync.WaitGroup
+	// replyMutex guards the reply destinations and the requests waiting for a reply
+	replyMutex        sync.Mutex
+	replyDestinations map[string]*url.URL
+	pendingReplies    map[string]chan Message
 }
 
 // Id returns the id of the manager
@@ -61,12 +92,78 @@ func (m *managerImpl) Receive(u *url.URL, options ...Option) (msg Message, err e
 	return
 }
 
-// AddListener registers a listener for the message using the appropriate provider
+// AddListener registers a listener for the message using the appropriate provider.
+// Unless the ManualAck option is set, the message is acknowledged once the listener returns
+// and is rejected with requeue if the listener panics, at most DefaultMaxRedeliveries times. The retry policy
+// (RetryOpts) and the dead letter destination (DeadLetter) options set the number of times a failing message is
+// requeued.
 func (m *managerImpl) AddListener(u *url.URL, listener func(msg Message), options ...Option) (err error) {
+	_, err = m.AddListenerWithOptions(u, listener, options...)
+	return
+}
+
+// AddListenerWithOptions registers a listener for the message using the appropriate provider and
+// returns the id of the listener that can be used to remove it.
+func (m *managerImpl) AddListenerWithOptions(u *url.URL, listener func(msg Message), options ...Option) (id string, err error) {
 	var provider Provider
 	provider, err = m.getFor(u.Scheme)
 	if err == nil {
-		err = provider.AddListener(u, listener, options...)
+		var ok bool
+		if id, ok = GetOptValue[string](ListenerId, options...); !ok {
+			var uid *uuid.UUID
+			uid, err = uuid.V4()
+			if err != nil {
+				return
+			}
+			id = uid.String()
+			options = append(options, Option{Key: ListenerId, Value: id})
+		}
+		err = provider.AddListener(u, m.wrapListener(listener, newListenerPolicy(u, options...)), options...)
+		if err == nil {
+			m.mutex.Lock()
+			m.listeners[id] = provider
+			m.mutex.Unlock()
+		}
+	}
+	return
+}
+
+// RemoveListener removes the listener with the id. It waits for the messages being processed by the listener,
+// hence a listener removing itself calls it from another goroutine.
+func (m *managerImpl) RemoveListener(id string) (err error) {
+	m.mutex.Lock()
+	provider, ok := m.listeners[id]
+	delete(m.listeners, id)
+	m.mutex.Unlock()
+	if !ok {
+		err = fmt.Errorf("%w: %s", ErrListenerNotFound, id)
+		return
+	}
+	err = provider.RemoveListener(id)
+	return
+}
+
+// SendObject sends the object encoded as JSON using the appropriate provider
+func (m *managerImpl) SendObject(u *url.URL, v any, options ...Option) (err error) {
+	var msg Message
+	msg, err = m.NewMessage(u.Scheme, options...)
+	if err == nil {
+		err = msg.SetBodyObject(v, ioutils.MimeApplicationJSON)
+		if err == nil {
+			err = m.Send(u, msg, options...)
+		}
+	}
+	return
+}
+
+// ReceiveObject receives a single message using the appropriate provider and decodes its body into v
+func (m *managerImpl) ReceiveObject(u *url.URL, v any, options ...Option) (msg Message, err error) {
+	msg, err = m.Receive(u, options...)
+	if err == nil {
+		err = msg.ReadAsObject(v)
+		if err == nil {
+			err = msg.Ack()
+		}
 	}
 	return
 }
@@ -169,8 +266,12 @@ func GetManager() Manager {
 		defer mutex.Unlock()
 		if defaultManager == nil {
 			defaultManager = &managerImpl{
-				knownProviders: make(map[string]Provider),
-				mutex:          sync.Mutex{},
+				knownProviders:    make(map[string]Provider),
+				listeners:         make(map[string]Provider),
+				deadLettered:      make(map[string]int64),
+				replyDestinations: make(map[string]*url.URL),
+				pendingReplies:    make(map[string]chan Message),
+				mutex:             sync.Mutex{},
 			}
 			defaultManager.Setup()
 			localProvider := &LocalProvider{}
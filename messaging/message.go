@@ -4,12 +4,29 @@ import (
 	"io"
 )
 
+// Well known headers of the messages. The local provider uses these to order and expire the messages.
+const (
+	// TimestampHeader is the header holding the time.Time the message is sent at.
+	// The local provider sets it on Send if it is not set.
+	TimestampHeader = "X-Timestamp"
+	// PriorityHeader is the header holding the int priority of the message from 0 (lowest) to 9 (highest).
+	// Messages without a priority have the DefaultPriority.
+	PriorityHeader = "X-Priority"
+	// TTLHeader is the header holding the time.Duration after the timestamp of the message when the message expires.
+	// Expired messages are dropped on receive.
+	TTLHeader = "X-TTL"
+	// DefaultPriority is the priority of the messages without the PriorityHeader
+	DefaultPriority = 4
+	// MaxPriority is the highest priority of a message
+	MaxPriority = 9
+)
+
 // Header defines all the header interfaces required by the messaging clients
 type Header interface {
 	// Id returns the message id of the message
 	Id() string
-	// SetHeader sets the byte header value for the Message header
-	SetHeader(key string, value []byte)
+	// SetHeader sets the header value for the Message header
+	SetHeader(key string, value any)
 	// SetStrHeader sets the string header value for the Message header
 	SetStrHeader(key string, value string)
 	// SetBoolHeader sets the boolean header value for the Message header
@@ -29,8 +46,10 @@ type Header interface {
 	// SetFloat64Header sets the float64 header value for the Message header
 	SetFloat64Header(key string, value float64)
 
-	// GetHeader returns the value of the key set in the headers if exists in the byte[] value
-	GetHeader(key string) (value []byte, exists bool)
+	// GetHeader returns the value of the key set in the headers if exists
+	GetHeader(key string) (value any, exists bool)
+	// Headers returns a copy of all the headers of the message
+	Headers() map[string]any
 	// GetStrHeader returns the value of the key set in the headers if exists in the string value
 	GetStrHeader(key string) (value string, exists bool)
 	// GetBoolHeader returns the value of the key set in the headers if exists in the bool value
@@ -65,6 +84,9 @@ type Body interface {
 	WriteXML(in interface{}) error
 	// WriteContent sets the custom body type based on the contentType to the Message structure
 	WriteContent(in interface{}, contentType string) error
+	// SetBodyObject replaces the body with the object encoded using the codec for the contentType
+	// and sets the content type header of the message
+	SetBodyObject(v any, contentType string) error
 
 	// ReadBody reads the Reader body from the Message structure
 	ReadBody() io.Reader
@@ -78,6 +100,9 @@ type Body interface {
 	ReadXML(out interface{}) error
 	// ReadContent reads the content body based on the contentType from the Message structure
 	ReadContent(out interface{}, contentType string) error
+	// ReadAsObject decodes the body using the codec for the content type header of the message.
+	// JSON is used if the message does not have a content type header
+	ReadAsObject(v any) error
 }
 
 // Message interface wil be implemented by all third party implementation such as
@@ -92,4 +117,12 @@ type Message interface {
 	//Additional options can be set for indicating further actions.
 	//This functionality is purely dependent on the capability of the provider to accept an acknowledgement.
 	Rsvp(bool, ...Option) error
+	// Ack acknowledges the successful processing of the message to the provider.
+	Ack() error
+	// Nack indicates the failure in processing the message to the provider.
+	// If requeue is true the provider is expected to deliver the message again.
+	Nack(requeue bool) error
+	// DeliveryCount returns the number of times the message is delivered by the provider.
+	// This can be used by the consumers to handle the messages that fail repeatedly.
+	DeliveryCount() int
 }
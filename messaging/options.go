@@ -1,11 +1,20 @@
 package messaging
 
-import "oss.nandlabs.io/golly/clients"
+import (
+	"net/url"
+
+	"oss.nandlabs.io/golly/clients"
+)
 
 const (
 	CircuitBreakerOpts = "CircuitBreakerOption"
-	RetryOpts          = "CircuitBreakerOption"
+	RetryOpts          = "RetryOption"
 	NamedListener      = "NamedListener"
+	ManualAck          = "ManualAck"
+	Concurrency        = "Concurrency"
+	ListenerId         = "ListenerId"
+	DeadLetter         = "DeadLetter"
+	PriorityQueue      = "PriorityQueue"
 )
 
 type Option struct {
@@ -73,6 +82,78 @@ func (ob *OptionsBuilder) AddNamedListener(name string) *OptionsBuilder {
 	return ob.Add(NamedListener, name)
 }
 
+// AddManualAck disables the automatic acknowledgement of the messages delivered to a listener.
+func (ob *OptionsBuilder) AddManualAck() *OptionsBuilder {
+	return ob.Add(ManualAck, true)
+}
+
+// AddConcurrency sets the number of workers processing the messages delivered to a listener.
+func (ob *OptionsBuilder) AddConcurrency(n int) *OptionsBuilder {
+	return ob.Add(Concurrency, n)
+}
+
+// WithConcurrency returns the option to set the number of workers processing the messages delivered to a listener.
+func WithConcurrency(n int) Option {
+	return Option{
+		Key:   Concurrency,
+		Value: n,
+	}
+}
+
+// WithGroup returns the option to add a listener to a group. Listeners of the same group compete for the
+// messages while every group receives a copy of each message. This is the same as a named listener.
+func WithGroup(name string) Option {
+	return Option{
+		Key:   NamedListener,
+		Value: name,
+	}
+}
+
+// AddDeadLetter sets the destination to which the messages are sent once a listener fails to process them.
+func (ob *OptionsBuilder) AddDeadLetter(u *url.URL) *OptionsBuilder {
+	return ob.Add(DeadLetter, u)
+}
+
+// WithRetryPolicy returns the option to requeue a message at most maxRetries times when a listener fails to
+// process it. The message is requeued after waiting for wait milliseconds.
+func WithRetryPolicy(maxRetries, wait int) Option {
+	return Option{
+		Key: RetryOpts,
+		Value: &clients.RetryInfo{
+			MaxRetries: maxRetries,
+			Wait:       wait,
+		},
+	}
+}
+
+// WithDeadLetter returns the option to send the messages a listener fails to process to the destination u.
+// The message is sent once the retries of the retry policy are exhausted, or on the first failure if no
+// retry policy is set.
+func WithDeadLetter(u *url.URL) Option {
+	return Option{
+		Key:   DeadLetter,
+		Value: u,
+	}
+}
+
+// WithPriorityQueue returns the option to order the messages of a destination by their PriorityHeader.
+// Once set with any of Send, Receive or AddListener the destination remains a priority queue.
+func WithPriorityQueue() Option {
+	return Option{
+		Key:   PriorityQueue,
+		Value: true,
+	}
+}
+
+// WithManualAck returns the option to disable the automatic acknowledgement of the messages delivered to a listener.
+// The listener is then expected to call Ack or Nack on each message.
+func WithManualAck() Option {
+	return Option{
+		Key:   ManualAck,
+		Value: true,
+	}
+}
+
 func GetOptValue[T any](key string, opts ...Option) (value T, has bool) {
 	defer func() {
 		if r := recover(); r != nil {
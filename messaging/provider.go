@@ -1,10 +1,18 @@
 package messaging
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/url"
 )
 
+// ErrListenerNotFound is returned when removing a listener that is not registered
+var ErrListenerNotFound = errors.New("listener not found")
+
+// ErrDuplicateListener is returned when a listener is added with the id of an existing listener
+var ErrDuplicateListener = errors.New("listener already exists")
+
 // Producer interface is used to send message(s) to a specific provider
 type Producer interface {
 	// Send function sends an individual message to the url
@@ -21,8 +29,12 @@ type Receiver interface {
 	// ReceiveBatch function performs on-demand receive of a batch of messages.
 	// This function may or may not wait for the messages to arrive. This is purely dependent on the implementation.
 	ReceiveBatch(*url.URL, ...Option) ([]Message, error)
-	// AddListener registers a listener for the message
+	// AddListener registers a listener for the message.
+	// The ListenerId option provides the id with which the listener can be removed.
 	AddListener(*url.URL, func(msg Message), ...Option) error
+	// RemoveListener removes the listener with the id waiting for the messages being processed by it.
+	// It must not be called by the listener itself.
+	RemoveListener(id string) error
 }
 
 // Provider interface exposes methods for a messaging provider
@@ -46,3 +58,16 @@ type Provider interface {
 	// NewMessage function creates a new message that can be used by the clients. It expects the scheme to be provided
 	NewMessage(string, ...Option) (Message, error)
 }
+
+// ReplyDestinationProvider is implemented by the providers that can create private destinations
+// to which the replies of the requests are sent.
+type ReplyDestinationProvider interface {
+	// NewReplyDestination creates a new destination that is only known to the caller
+	NewReplyDestination() (*url.URL, error)
+}
+
+// ContextReceiver is implemented by the providers whose receive can be abandoned once a context is done.
+type ContextReceiver interface {
+	// ReceiveCtx waits for the next message of the destination until the ctx is done
+	ReceiveCtx(context.Context, *url.URL, ...Option) (Message, error)
+}
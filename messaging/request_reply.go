@@ -0,0 +1,150 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"oss.nandlabs.io/golly/uuid"
+)
+
+const (
+	// CorrelationIdHeader is the header that correlates a reply with its request
+	CorrelationIdHeader = "X-Correlation-Id"
+	// ReplyToHeader is the header holding the url of the destination to which the reply of a request is sent
+	ReplyToHeader = "X-Reply-To"
+	// ReplyErrorHeader is the header holding the error of the handler that failed to process a request
+	ReplyErrorHeader = "X-Reply-Error"
+)
+
+// ErrReplyNotSupported is returned when the provider cannot create destinations for the replies
+var ErrReplyNotSupported = errors.New("reply destinations are not supported")
+
+// ErrReplyFailed is returned when the handler of a request fails to process it
+var ErrReplyFailed = errors.New("request failed")
+
+// Request sends the message to the destination and waits for its reply or the context to be done.
+// The correlation id and the reply to headers of the message are set by the Request along with the trace context of
+// the ctx. The reply carries the trace headers of the request.
+// If the handler of the request fails, the reply is returned along with ErrReplyFailed.
+func (m *managerImpl) Request(ctx context.Context, dest *url.URL, msg Message) (reply Message, err error) {
+	var replyTo *url.URL
+	replyTo, err = m.replyDestination(dest.Scheme)
+	if err != nil {
+		return
+	}
+	var uid *uuid.UUID
+	uid, err = uuid.V4()
+	if err != nil {
+		return
+	}
+	correlationId := uid.String()
+	msg.SetStrHeader(CorrelationIdHeader, correlationId)
+	msg.SetStrHeader(ReplyToHeader, replyTo.String())
+	replies := make(chan Message, 1)
+	m.replyMutex.Lock()
+	m.pendingReplies[correlationId] = replies
+	m.replyMutex.Unlock()
+	defer func() {
+		m.replyMutex.Lock()
+		delete(m.pendingReplies, correlationId)
+		m.replyMutex.Unlock()
+	}()
+	if err = m.SendCtx(ctx, dest, msg); err != nil {
+		return
+	}
+	select {
+	case reply = <-replies:
+		if cause, failed := reply.GetStrHeader(ReplyErrorHeader); failed {
+			err = fmt.Errorf("%w: %s", ErrReplyFailed, cause)
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// ReplyHandler registers the handler for the requests sent to the destination. The message returned by
+// the handler is sent to the reply to destination of the request. If the handler returns an error, an empty
+// reply with the ReplyErrorHeader is sent instead. It returns the id of the listener of the destination.
+func (m *managerImpl) ReplyHandler(dest *url.URL, fn func(msg Message) (Message, error), options ...Option) (string, error) {
+	return m.AddListenerWithOptions(dest, func(msg Message) {
+		reply, err := fn(msg)
+		replyTo, ok := msg.GetStrHeader(ReplyToHeader)
+		if !ok {
+			logger.WarnF("dropping the reply of message %s without the %s header", msg.Id(), ReplyToHeader)
+			return
+		}
+		if err = m.sendReply(replyTo, msg, reply, err); err != nil {
+			logger.ErrorF("unable to send the reply of message %s to %s: %v", msg.Id(), replyTo, err)
+		}
+	}, options...)
+}
+
+// sendReply sends the reply of the request. A new reply is created if the handler did not return one
+// or failed with the error cause.
+func (m *managerImpl) sendReply(raw string, request, reply Message, cause error) (err error) {
+	var replyTo *url.URL
+	replyTo, err = url.Parse(raw)
+	if err == nil && (reply == nil || cause != nil) {
+		reply, err = m.NewMessage(replyTo.Scheme)
+		if err == nil && cause != nil {
+			reply.SetStrHeader(ReplyErrorHeader, cause.Error())
+		}
+	}
+	if err == nil {
+		correlationId, _ := request.GetStrHeader(CorrelationIdHeader)
+		reply.SetStrHeader(CorrelationIdHeader, correlationId)
+		copyTraceHeaders(request, reply)
+		err = m.SendCtx(ExtractTraceContext(request), replyTo, reply)
+	}
+	return
+}
+
+// replyDestination returns the destination for the replies of the requests sent with the scheme.
+// The destination is created on the first request and a listener routes the replies to the pending requests.
+func (m *managerImpl) replyDestination(scheme string) (replyTo *url.URL, err error) {
+	m.replyMutex.Lock()
+	defer m.replyMutex.Unlock()
+	var ok bool
+	if replyTo, ok = m.replyDestinations[scheme]; ok {
+		return
+	}
+	var provider Provider
+	provider, err = m.getFor(scheme)
+	if err != nil {
+		return
+	}
+	replyProvider, ok := provider.(ReplyDestinationProvider)
+	if !ok {
+		err = fmt.Errorf("%w: %s", ErrReplyNotSupported, scheme)
+		return
+	}
+	replyTo, err = replyProvider.NewReplyDestination()
+	if err == nil {
+		err = m.AddListener(replyTo, m.routeReply)
+		if err == nil {
+			m.replyDestinations[scheme] = replyTo
+		}
+	}
+	return
+}
+
+// routeReply delivers the reply to the pending request with the same correlation id.
+// Replies of requests that are no longer waiting are dropped.
+func (m *managerImpl) routeReply(msg Message) {
+	correlationId, _ := msg.GetStrHeader(CorrelationIdHeader)
+	m.replyMutex.Lock()
+	replies, ok := m.pendingReplies[correlationId]
+	m.replyMutex.Unlock()
+	if !ok {
+		logger.WarnF("dropping reply %s for unknown correlation id %s", msg.Id(), correlationId)
+		return
+	}
+	select {
+	case replies <- msg:
+	default:
+		logger.WarnF("dropping duplicate reply %s for correlation id %s", msg.Id(), correlationId)
+	}
+}
@@ -0,0 +1,76 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestManager_RequestReply(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://request-reply-test")
+	id, err := lms.ReplyHandler(uri, func(msg Message) (Message, error) {
+		if msg.ReadAsStr() == "fail" {
+			return nil, errors.New("unable to process request")
+		}
+		reply, err := lms.NewMessage("chan")
+		if err == nil {
+			_, err = reply.SetBodyStr(strings.ToUpper(msg.ReadAsStr()))
+		}
+		return reply, err
+	})
+	assert.NoError(t, err)
+	defer lms.RemoveListener(id)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			msg, err := lms.NewMessage("chan")
+			assert.NoError(t, err)
+			_, _ = msg.SetBodyStr(fmt.Sprintf("request-%d", i))
+			reply, err := lms.Request(ctx, uri, msg)
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("REQUEST-%d", i), reply.ReadAsStr())
+			requestId, _ := msg.GetStrHeader(CorrelationIdHeader)
+			replyId, _ := reply.GetStrHeader(CorrelationIdHeader)
+			assert.Equal(t, requestId, replyId)
+		}(i)
+	}
+	wg.Wait()
+
+	msg, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	_, _ = msg.SetBodyStr("fail")
+	reply, err := lms.Request(context.Background(), uri, msg)
+	assert.True(t, errors.Is(err, ErrReplyFailed))
+	assert.True(t, strings.Contains(err.Error(), "unable to process request"))
+	cause, _ := reply.GetStrHeader(ReplyErrorHeader)
+	assert.Equal(t, "unable to process request", cause)
+}
+
+func TestManager_RequestTimeout(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://request-timeout-test")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	msg, err := lms.NewMessage("chan")
+	assert.NoError(t, err)
+	_, err = lms.Request(ctx, uri, msg)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	manager := lms.(*managerImpl)
+	manager.replyMutex.Lock()
+	defer manager.replyMutex.Unlock()
+	assert.Len(t, manager.pendingReplies, 0)
+}
@@ -0,0 +1,195 @@
+package messaging
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// TraceParentHeader is the header holding the W3C trace context traceparent of the message
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the header holding the W3C trace context tracestate of the message
+	TraceStateHeader = "tracestate"
+	// RequestIdHeader is the header holding the id of the request the message is sent for
+	RequestIdHeader = "X-Request-Id"
+	// HopsHeader is the header holding the services the message was sent by, as a comma separated list of
+	// <service>@<RFC3339 time> oldest first
+	HopsHeader = "X-Message-Hops"
+	// MaxHops is the number of the latest hops kept in the HopsHeader
+	MaxHops = 16
+)
+
+// traceContextKey is the context key of the TraceContext
+type traceContextKey struct{}
+
+// serviceName is the name recorded in the hops of the messages sent by this process
+var serviceName atomic.Value
+
+// TraceContext holds the trace values propagated along with the messages
+type TraceContext struct {
+	// TraceParent is the W3C traceparent
+	TraceParent string
+	// TraceState is the W3C tracestate
+	TraceState string
+	// RequestId is the id of the request the messages are sent for
+	RequestId string
+}
+
+// Hop is a service the message was sent by
+type Hop struct {
+	Service string
+	Time    time.Time
+}
+
+// SetServiceName sets the name of the service recorded in the HopsHeader of the messages sent with a context.
+// No hops are recorded if it is not set.
+func SetServiceName(name string) {
+	serviceName.Store(name)
+}
+
+// WithTraceContext returns a copy of the ctx carrying the trace context
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFrom returns the trace context carried by the ctx
+func TraceContextFrom(ctx context.Context) (tc TraceContext, ok bool) {
+	tc, ok = ctx.Value(traceContextKey{}).(TraceContext)
+	return
+}
+
+// InjectTraceContext copies the trace context of the ctx into the headers of the message and records a hop of the
+// service if its name is set. The values that are not set in the ctx are left unchanged in the message.
+func InjectTraceContext(ctx context.Context, msg Message) {
+	if tc, ok := TraceContextFrom(ctx); ok {
+		setIfPresent(msg, TraceParentHeader, tc.TraceParent)
+		setIfPresent(msg, TraceStateHeader, tc.TraceState)
+		setIfPresent(msg, RequestIdHeader, tc.RequestId)
+	}
+	if name, _ := serviceName.Load().(string); name != "" {
+		addHop(msg, name, time.Now())
+	}
+}
+
+// ExtractTraceContext returns a context carrying the trace context of the headers of the message
+func ExtractTraceContext(msg Message) context.Context {
+	return extractTraceContext(context.Background(), msg)
+}
+
+// extractTraceContext returns a copy of the ctx carrying the trace context of the headers of the message.
+// The ctx is returned as is if the message has none of the trace headers.
+func extractTraceContext(ctx context.Context, msg Message) context.Context {
+	var tc TraceContext
+	tc.TraceParent, _ = msg.GetStrHeader(TraceParentHeader)
+	tc.TraceState, _ = msg.GetStrHeader(TraceStateHeader)
+	tc.RequestId, _ = msg.GetStrHeader(RequestIdHeader)
+	if tc == (TraceContext{}) {
+		return ctx
+	}
+	return WithTraceContext(ctx, tc)
+}
+
+// copyTraceHeaders copies the trace headers and the hops of the source message to the target message
+func copyTraceHeaders(source, target Message) {
+	for _, h := range []string{TraceParentHeader, TraceStateHeader, RequestIdHeader, HopsHeader} {
+		if v, ok := source.GetStrHeader(h); ok {
+			target.SetStrHeader(h, v)
+		}
+	}
+}
+
+// Hops returns the hops recorded in the HopsHeader of the message oldest first
+func Hops(msg Message) (hops []Hop) {
+	value, _ := msg.GetStrHeader(HopsHeader)
+	for _, entry := range strings.Split(value, ",") {
+		if service, at, ok := strings.Cut(entry, "@"); ok {
+			hop := Hop{Service: service}
+			hop.Time, _ = time.Parse(time.RFC3339Nano, at)
+			hops = append(hops, hop)
+		}
+	}
+	return
+}
+
+// addHop appends the hop to the HopsHeader of the message keeping the latest MaxHops
+func addHop(msg Message, service string, at time.Time) {
+	var entries []string
+	if value, ok := msg.GetStrHeader(HopsHeader); ok && value != "" {
+		entries = strings.Split(value, ",")
+	}
+	service = strings.NewReplacer(",", "_", "@", "_").Replace(service)
+	entries = append(entries, service+"@"+at.UTC().Format(time.RFC3339Nano))
+	if len(entries) > MaxHops {
+		entries = entries[len(entries)-MaxHops:]
+	}
+	msg.SetStrHeader(HopsHeader, strings.Join(entries, ","))
+}
+
+// setIfPresent sets the header if the value is not empty
+func setIfPresent(msg Message, key, value string) {
+	if value != "" {
+		msg.SetStrHeader(key, value)
+	}
+}
+
+// SendCtx injects the trace context of the ctx into the message and sends it using the appropriate provider
+func (m *managerImpl) SendCtx(ctx context.Context, u *url.URL, msg Message, options ...Option) (err error) {
+	if err = ctx.Err(); err == nil {
+		InjectTraceContext(ctx, msg)
+		err = m.Send(u, msg, options...)
+	}
+	return
+}
+
+// ReceiveCtx receives a single message using the appropriate provider waiting at most until the ctx is done.
+// The providers implementing ContextReceiver stop receiving once the ctx is done. For the other providers the
+// receive keeps waiting in the background until a message arrives, and that message is rejected with requeue, which
+// may deliver it after the messages sent later.
+func (m *managerImpl) ReceiveCtx(ctx context.Context, u *url.URL, options ...Option) (msg Message, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	var provider Provider
+	if provider, err = m.getFor(u.Scheme); err != nil {
+		return
+	}
+	if receiver, ok := provider.(ContextReceiver); ok {
+		msg, err = receiver.ReceiveCtx(ctx, u, options...)
+		return
+	}
+	type received struct {
+		msg Message
+		err error
+	}
+	result := make(chan received, 1)
+	go func() {
+		msg, err := provider.Receive(u, options...)
+		result <- received{msg: msg, err: err}
+	}()
+	select {
+	case r := <-result:
+		msg, err = r.msg, r.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		go func() {
+			if r := <-result; r.err == nil {
+				if nackErr := r.msg.Nack(true); nackErr != nil {
+					logger.ErrorF("unable to requeue message %s received after the cancellation: %v", r.msg.Id(), nackErr)
+				}
+			}
+		}()
+	}
+	return
+}
+
+// AddListenerCtx registers a listener called with a context carrying the trace context of the message.
+// It returns the id of the listener. The options are the ones of AddListenerWithOptions.
+func (m *managerImpl) AddListenerCtx(u *url.URL, listener func(ctx context.Context, msg Message),
+	options ...Option) (string, error) {
+	return m.AddListenerWithOptions(u, func(msg Message) {
+		listener(ExtractTraceContext(msg), msg)
+	}, options...)
+}
@@ -0,0 +1,138 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+var testTrace = TraceContext{
+	TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	TraceState:  "golly=t61rcWkgMzE",
+	RequestId:   "req-42",
+}
+
+func TestInjectExtractTraceContext(t *testing.T) {
+	SetServiceName("orders")
+	defer SetServiceName("")
+	msg, err := NewLocalMessage()
+	assert.NoError(t, err)
+	InjectTraceContext(WithTraceContext(context.Background(), testTrace), msg)
+	traceParent, _ := msg.GetStrHeader(TraceParentHeader)
+	assert.Equal(t, testTrace.TraceParent, traceParent)
+	tc, ok := TraceContextFrom(ExtractTraceContext(msg))
+	assert.True(t, ok)
+	assert.Equal(t, testTrace, tc)
+
+	// a context without trace values leaves the headers unchanged
+	InjectTraceContext(context.Background(), msg)
+	tc, _ = TraceContextFrom(ExtractTraceContext(msg))
+	assert.Equal(t, testTrace, tc)
+	empty, _ := NewLocalMessage()
+	_, ok = TraceContextFrom(ExtractTraceContext(empty))
+	assert.False(t, ok)
+
+	hops := Hops(msg)
+	assert.Equal(t, 2, len(hops))
+	assert.Equal(t, "orders", hops[0].Service)
+	assert.False(t, hops[0].Time.IsZero())
+	for i := 0; i < 2*MaxHops; i++ {
+		SetServiceName(fmt.Sprintf("svc@%d,x", i))
+		InjectTraceContext(context.Background(), msg)
+	}
+	hops = Hops(msg)
+	assert.Equal(t, MaxHops, len(hops))
+	assert.Equal(t, fmt.Sprintf("svc_%d_x", 2*MaxHops-1), hops[MaxHops-1].Service)
+}
+
+func TestManager_TracePropagation(t *testing.T) {
+	SetServiceName("gateway")
+	defer SetServiceName("")
+	lms := GetManager()
+	ctx := WithTraceContext(context.Background(), testTrace)
+
+	// listener
+	uri, _ := url.Parse("chan://trace-listener-test")
+	received := make(chan TraceContext, 1)
+	id, err := lms.AddListenerCtx(uri, func(ctx context.Context, msg Message) {
+		tc, _ := TraceContextFrom(ctx)
+		received <- tc
+	})
+	assert.NoError(t, err)
+	defer lms.RemoveListener(id)
+	msg, _ := lms.NewMessage("chan")
+	assert.NoError(t, lms.SendCtx(ctx, uri, msg))
+	select {
+	case tc := <-received:
+		assert.Equal(t, testTrace, tc)
+	case <-time.After(time.Second):
+		t.Fatal("the listener was not called")
+	}
+
+	// request and reply
+	service, _ := url.Parse("chan://trace-reply-test")
+	replyId, err := lms.ReplyHandler(service, func(msg Message) (Message, error) {
+		return lms.NewMessage("chan")
+	})
+	assert.NoError(t, err)
+	defer lms.RemoveListener(replyId)
+	request, _ := lms.NewMessage("chan")
+	reply, err := lms.Request(ctx, service, request)
+	assert.NoError(t, err)
+	tc, _ := TraceContextFrom(ExtractTraceContext(reply))
+	assert.Equal(t, testTrace, tc)
+	assert.Equal(t, 2, len(Hops(reply)))
+
+	// retries and dead letters
+	source, _ := url.Parse("chan://trace-retry-test")
+	dlq, _ := url.Parse("chan://trace-retry-test-dlq")
+	retryId, err := lms.AddListenerWithOptions(source, func(msg Message) {
+		panic(errors.New("failed"))
+	}, WithRetryPolicy(1, 0), WithDeadLetter(dlq))
+	assert.NoError(t, err)
+	defer lms.RemoveListener(retryId)
+	msg, _ = lms.NewMessage("chan")
+	assert.NoError(t, lms.SendCtx(ctx, source, msg))
+	receiveCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	dead, err := lms.ReceiveCtx(receiveCtx, dlq)
+	assert.NoError(t, err)
+	tc, _ = TraceContextFrom(ExtractTraceContext(dead))
+	assert.Equal(t, testTrace, tc)
+}
+
+func TestManager_ReceiveCtx(t *testing.T) {
+	lms := GetManager()
+	uri, _ := url.Parse("chan://receive-ctx-test")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := lms.ReceiveCtx(ctx, uri)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the abandoned receive takes no message, the messages are received in order
+	msg, _ := lms.NewMessage("chan")
+	_, _ = msg.SetBodyStr("late")
+	assert.NoError(t, lms.Send(uri, msg))
+	next, _ := lms.NewMessage("chan")
+	_, _ = next.SetBodyStr("next")
+	assert.NoError(t, lms.Send(uri, next))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	received, err := lms.ReceiveCtx(ctx, uri)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(received.ReadAsStr(), "late"))
+	assert.Equal(t, 1, received.(*LocalMessage).DeliveryCount())
+	received, err = lms.ReceiveCtx(ctx, uri)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(received.ReadAsStr(), "next"))
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	assert.Equal(t, context.Canceled, lms.SendCtx(cancelled, uri, msg))
+}
@@ -0,0 +1,539 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/clients"
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/lifecycle"
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/rest/client"
+	"oss.nandlabs.io/golly/uuid"
+)
+
+// Outbound webhooks. The events published to the source destination of a WebhookDispatcher are posted to the
+// active subscribers whose event types match. The requests are signed with the secret of the subscriber using the
+// request signing scheme of the rest package, the key id being the id of the subscriber, so that a receiver verifies
+// them with server.SignatureVerificationMiddleware. A failed delivery is retried after each wait of the retry tiers
+// and is dead lettered once they are exhausted. The deliveries can be retried later through Redeliver.
+//
+// Each subscriber has its own clients.CircuitBreaker: once the breaker of an endpoint is open its attempts fail without
+// calling it, so that a dead endpoint exhausts its own retries without holding back the other subscribers.
+
+const (
+	// WebhookEventHeader is the header of the type of the event of a webhook request
+	WebhookEventHeader = "X-Webhook-Event"
+	// WebhookEventIdHeader is the header of the id of the event of a webhook request
+	WebhookEventIdHeader = "X-Webhook-Event-Id"
+	// WebhookDeliveryHeader is the header of the id of the delivery of a webhook request, the same for its retries
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+	// WebhookAttemptHeader is the header of the attempt of the delivery of a webhook request, starting at 1
+	WebhookAttemptHeader = "X-Webhook-Attempt"
+
+	defaultWebhookDispatcherId = "webhook-dispatcher"
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookBreakerLimit = 5
+	defaultWebhookCooldown     = time.Minute
+	defaultWebhookMaxLogs      = 100
+)
+
+var (
+	// ErrSubscriberNotFound is returned when a subscriber is not in the store
+	ErrSubscriberNotFound = errors.New("subscriber not found")
+	// ErrInvalidSubscriber is returned when a subscriber has no id, secret or http url
+	ErrInvalidSubscriber = errors.New("invalid subscriber")
+	// ErrDeliveryNotFound is returned when a delivery to redeliver is not a dead lettered delivery
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrWebhookCircuitOpen is the error of the attempts made while the circuit breaker of the subscriber is open
+	ErrWebhookCircuitOpen = errors.New("the circuit breaker of the subscriber is open")
+
+	// defaultWebhookRetryTiers are the waits before the retries of a failed delivery
+	defaultWebhookRetryTiers = []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute, time.Hour}
+)
+
+// WebhookEvent is the envelope of the events delivered to the subscribers. Data holds the payload of the event
+// and Schema identifies the schema of the payload for the consumers validating it.
+type WebhookEvent struct {
+	Id     string          `json:"id"`
+	Type   string          `json:"type"`
+	Schema string          `json:"schema,omitempty"`
+	Time   time.Time       `json:"time"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// NewWebhookEvent creates an event of the type with the data encoded as JSON
+func NewWebhookEvent(eventType string, data any) (event *WebhookEvent, err error) {
+	var id *uuid.UUID
+	var raw []byte
+	if id, err = uuid.V4(); err == nil {
+		if raw, err = json.Marshal(data); err == nil {
+			event = &WebhookEvent{Id: id.String(), Type: eventType, Time: time.Now().UTC(), Data: raw}
+		}
+	}
+	return
+}
+
+// PublishWebhookEvent sends the event to the source destination of a WebhookDispatcher
+func PublishWebhookEvent(manager Manager, dest *url.URL, event *WebhookEvent) (err error) {
+	var msg Message
+	if msg, err = manager.NewMessage(dest.Scheme); err == nil {
+		if err = msg.SetBodyObject(event, ioutils.MimeApplicationJSON); err == nil {
+			err = manager.Send(dest, msg)
+		}
+	}
+	return
+}
+
+// Subscriber is an endpoint receiving the events of the webhooks
+type Subscriber struct {
+	// Id identifies the subscriber and is the key id of the signatures
+	Id string `json:"id"`
+	// Url is the http or https url the events are posted to
+	Url string `json:"url"`
+	// Secret is the key of the signatures
+	Secret string `json:"secret,omitempty"`
+	// EventTypes are the types of the events delivered to the subscriber. A type ending with ".*" matches the types
+	// with its prefix and "*" matches all the types. The subscriber receives all the events if empty.
+	EventTypes []string `json:"event_types,omitempty"`
+	// Active enables the deliveries to the subscriber
+	Active bool `json:"active"`
+	// RetryTiers are the waits before the retries of a failed delivery, overriding the tiers of the dispatcher
+	RetryTiers []time.Duration `json:"retry_tiers,omitempty"`
+}
+
+// Validate checks that the subscriber has an id, a secret and an http url
+func (s *Subscriber) Validate() (err error) {
+	u, e := url.Parse(s.Url)
+	switch {
+	case s.Id == "":
+		err = fmt.Errorf("%w: the id is required", ErrInvalidSubscriber)
+	case s.Secret == "":
+		err = fmt.Errorf("%w: the secret is required", ErrInvalidSubscriber)
+	case e != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		err = fmt.Errorf("%w: %q is not an http url", ErrInvalidSubscriber, s.Url)
+	}
+	return
+}
+
+// Matches checks if the subscriber receives the events of the type
+func (s *Subscriber) Matches(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == "*" || t == eventType ||
+			(strings.HasSuffix(t, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus is the outcome of an attempt to deliver an event
+type DeliveryStatus string
+
+const (
+	// DeliverySucceeded is the status of an attempt answered with a 2xx
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryRetrying is the status of a failed attempt that is retried
+	DeliveryRetrying DeliveryStatus = "retrying"
+	// DeliveryDead is the status of the last failed attempt of a delivery, which is then dead lettered
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// DeliveryLog records an attempt to deliver an event to a subscriber
+type DeliveryLog struct {
+	DeliveryId string         `json:"delivery_id"`
+	EventId    string         `json:"event_id"`
+	EventType  string         `json:"event_type"`
+	Attempt    int            `json:"attempt"`
+	Status     DeliveryStatus `json:"status"`
+	StatusCode int            `json:"status_code,omitempty"`
+	Latency    time.Duration  `json:"latency"`
+	Error      string         `json:"error,omitempty"`
+	Time       time.Time      `json:"time"`
+}
+
+// WebhookDeadLetter is the record of a delivery whose retries are exhausted
+type WebhookDeadLetter struct {
+	DeliveryId   string        `json:"delivery_id"`
+	SubscriberId string        `json:"subscriber_id"`
+	Attempts     int           `json:"attempts"`
+	Error        string        `json:"error"`
+	Time         time.Time     `json:"time"`
+	Event        *WebhookEvent `json:"event"`
+}
+
+// DispatcherOptions configures the WebhookDispatcher
+type DispatcherOptions struct {
+	// Id is the id of the dispatcher component, webhook-dispatcher if empty
+	Id string
+	// Source is the destination of the events to deliver
+	Source *url.URL
+	// RetryTiers are the waits before the retries of a failed delivery. Defaults to 10s, 1m, 10m and 1h.
+	RetryTiers []time.Duration
+	// Timeout is the timeout of a request, rounded up to the second. Defaults to 10 seconds.
+	Timeout time.Duration
+	// DeadLetter is the destination the WebhookDeadLetter records are sent to, if set
+	DeadLetter *url.URL
+	// BreakerThreshold is the number of consecutive failures opening the circuit breaker of a subscriber.
+	// Defaults to 5.
+	BreakerThreshold int
+	// BreakerCooldown is the time the circuit breaker of a subscriber stays open before an attempt is let through,
+	// rounded up to the second. Defaults to 1 minute.
+	BreakerCooldown time.Duration
+	// MaxLogs is the number of delivery logs and dead letters kept per subscriber. Defaults to 100.
+	MaxLogs int
+}
+
+// webhookDelivery is a delivery of an event to a subscriber
+type webhookDelivery struct {
+	id           string
+	subscriberId string
+	event        *WebhookEvent
+	body         []byte
+	attempts     int
+	timer        *time.Timer
+	deadLetter   *WebhookDeadLetter
+}
+
+// WebhookDispatcher delivers the events published to its source to the subscribers of its store.
+// The dispatcher is a lifecycle.Component: Start adds the listener of the source and Stop removes it, waiting for the
+// requests in flight. The deliveries waiting for a retry are dropped when the dispatcher stops.
+type WebhookDispatcher struct {
+	*lifecycle.SimpleComponent
+	manager    Manager
+	store      SubscriberStore
+	opts       DispatcherOptions
+	listenerId string
+	mutex      sync.Mutex
+	closed     bool
+	wg         sync.WaitGroup
+	// pending are the deliveries waiting for an attempt by id
+	pending map[string]*webhookDelivery
+	// dead are the dead lettered deliveries by subscriber, oldest first
+	dead     map[string][]*webhookDelivery
+	logs     map[string][]DeliveryLog
+	breakers map[string]*clients.CircuitBreaker
+	clients  map[string]*subscriberClient
+}
+
+// subscriberClient is the rest client signing the requests with the secret of a subscriber
+type subscriberClient struct {
+	secret string
+	client *client.Client
+}
+
+// NewWebhookDispatcher creates a WebhookDispatcher delivering the events of the source of the options to the
+// subscribers of the store
+func NewWebhookDispatcher(manager Manager, store SubscriberStore, opts DispatcherOptions) (d *WebhookDispatcher,
+	err error) {
+	if opts.Source == nil {
+		err = errors.New("the source of the dispatcher is required")
+		return
+	}
+	if opts.Id == "" {
+		opts.Id = defaultWebhookDispatcherId
+	}
+	if len(opts.RetryTiers) == 0 {
+		opts.RetryTiers = defaultWebhookRetryTiers
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultWebhookTimeout
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = defaultWebhookBreakerLimit
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = defaultWebhookCooldown
+	}
+	if opts.MaxLogs <= 0 {
+		opts.MaxLogs = defaultWebhookMaxLogs
+	}
+	d = &WebhookDispatcher{
+		manager:  manager,
+		store:    store,
+		opts:     opts,
+		pending:  map[string]*webhookDelivery{},
+		dead:     map[string][]*webhookDelivery{},
+		logs:     map[string][]DeliveryLog{},
+		breakers: map[string]*clients.CircuitBreaker{},
+		clients:  map[string]*subscriberClient{},
+	}
+	d.SimpleComponent = &lifecycle.SimpleComponent{
+		CompId:    opts.Id,
+		StartFunc: d.start,
+		StopFunc:  d.stop,
+	}
+	return
+}
+
+// Store returns the store of the subscribers
+func (d *WebhookDispatcher) Store() SubscriberStore {
+	return d.store
+}
+
+// Deliveries returns the logs of the attempts to deliver the events to the subscriber, oldest first
+func (d *WebhookDispatcher) Deliveries(subscriberId string) []DeliveryLog {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]DeliveryLog(nil), d.logs[subscriberId]...)
+}
+
+// DeadLetters returns the dead lettered deliveries of the subscriber, oldest first
+func (d *WebhookDispatcher) DeadLetters(subscriberId string) (records []WebhookDeadLetter) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, delivery := range d.dead[subscriberId] {
+		records = append(records, *delivery.deadLetter)
+	}
+	return
+}
+
+// Redeliver retries the dead lettered delivery with the retry tiers starting over. ErrDeliveryNotFound is returned
+// if the delivery is not dead lettered.
+func (d *WebhookDispatcher) Redeliver(deliveryId string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for subscriberId, deliveries := range d.dead {
+		for i, delivery := range deliveries {
+			if delivery.id == deliveryId {
+				d.dead[subscriberId] = append(deliveries[:i:i], deliveries[i+1:]...)
+				delivery.attempts, delivery.deadLetter = 0, nil
+				d.schedule(delivery, 0)
+				return nil
+			}
+		}
+	}
+	return ErrDeliveryNotFound
+}
+
+func (d *WebhookDispatcher) start() (err error) {
+	d.mutex.Lock()
+	d.closed = false
+	d.mutex.Unlock()
+	d.listenerId, err = d.manager.AddListenerWithOptions(d.opts.Source, d.handle)
+	return
+}
+
+func (d *WebhookDispatcher) stop() (err error) {
+	err = d.manager.RemoveListener(d.listenerId)
+	d.mutex.Lock()
+	d.closed = true
+	for id, delivery := range d.pending {
+		if delivery.timer != nil && delivery.timer.Stop() {
+			d.wg.Done()
+		}
+		delete(d.pending, id)
+	}
+	d.mutex.Unlock()
+	d.wg.Wait()
+	return
+}
+
+// handle fans the event of the message out to the matching subscribers. The malformed events are dropped.
+func (d *WebhookDispatcher) handle(msg Message) {
+	event := &WebhookEvent{}
+	if err := msg.ReadJSON(event); err != nil || event.Id == "" || event.Type == "" {
+		logger.ErrorF("dropping the malformed webhook event %s: %v", msg.Id(), err)
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.ErrorF("dropping the webhook event %s: %v", event.Id, err)
+		return
+	}
+	subscribers, err := d.store.List()
+	if err != nil {
+		// the listener fails so that the message is retried if the source has a retry policy
+		panic(fmt.Errorf("unable to list the subscribers of the event %s: %w", event.Id, err))
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, subscriber := range subscribers {
+		if !subscriber.Active || !subscriber.Matches(event.Type) {
+			continue
+		}
+		id, err := uuid.V4()
+		if err != nil {
+			logger.ErrorF("unable to deliver the event %s to %s: %v", event.Id, subscriber.Id, err)
+			continue
+		}
+		d.schedule(&webhookDelivery{id: id.String(), subscriberId: subscriber.Id, event: event, body: body}, 0)
+	}
+}
+
+// schedule runs the next attempt of the delivery after the wait. It is called with the mutex held.
+func (d *WebhookDispatcher) schedule(delivery *webhookDelivery, wait time.Duration) {
+	if d.closed {
+		return
+	}
+	d.pending[delivery.id] = delivery
+	d.wg.Add(1)
+	if wait <= 0 {
+		go d.attempt(delivery)
+	} else {
+		delivery.timer = time.AfterFunc(wait, func() {
+			d.attempt(delivery)
+		})
+	}
+}
+
+// attempt posts the event to the subscriber and records the outcome. The delivery is retried after the next tier
+// if it failed, or dead lettered once the tiers are exhausted.
+func (d *WebhookDispatcher) attempt(delivery *webhookDelivery) {
+	defer d.wg.Done()
+	d.mutex.Lock()
+	delivery.timer = nil
+	delivery.attempts++
+	d.mutex.Unlock()
+
+	subscriber, err := d.store.Get(delivery.subscriberId)
+	if err != nil || !subscriber.Active {
+		logger.InfoF("dropping the delivery %s as the subscriber %s is removed or inactive", delivery.id,
+			delivery.subscriberId)
+		d.mutex.Lock()
+		delete(d.pending, delivery.id)
+		d.mutex.Unlock()
+		return
+	}
+	entry := DeliveryLog{
+		DeliveryId: delivery.id,
+		EventId:    delivery.event.Id,
+		EventType:  delivery.event.Type,
+		Attempt:    delivery.attempts,
+		Time:       time.Now().UTC(),
+	}
+	breaker := d.breakerOf(subscriber.Id)
+	if breaker.CanExecute() == nil {
+		start := time.Now()
+		entry.StatusCode, err = d.post(subscriber, delivery)
+		entry.Latency = time.Since(start)
+		breaker.OnExecution(err == nil)
+	} else {
+		err = ErrWebhookCircuitOpen
+	}
+
+	tiers := d.opts.RetryTiers
+	if len(subscriber.RetryTiers) > 0 {
+		tiers = subscriber.RetryTiers
+	}
+	d.mutex.Lock()
+	delete(d.pending, delivery.id)
+	switch {
+	case err == nil:
+		entry.Status = DeliverySucceeded
+	case delivery.attempts <= len(tiers):
+		entry.Status, entry.Error = DeliveryRetrying, err.Error()
+		d.schedule(delivery, tiers[delivery.attempts-1])
+	default:
+		entry.Status, entry.Error = DeliveryDead, err.Error()
+		d.deadLetter(delivery, err)
+	}
+	d.log(subscriber.Id, entry)
+	d.mutex.Unlock()
+	if entry.Status == DeliveryDead && d.opts.DeadLetter != nil {
+		if err = d.manager.SendObject(d.opts.DeadLetter, delivery.deadLetter); err != nil {
+			logger.ErrorF("unable to dead letter the delivery %s: %v", delivery.id, err)
+		}
+	}
+}
+
+// post sends the event to the subscriber and returns the status code of the response. The responses other than
+// 2xx are errors.
+func (d *WebhookDispatcher) post(subscriber *Subscriber, delivery *webhookDelivery) (status int, err error) {
+	c := d.clientOf(subscriber)
+	req := c.NewRequest(subscriber.Url, http.MethodPost).
+		AddHeader(WebhookEventHeader, delivery.event.Type).
+		AddHeader(WebhookEventIdHeader, delivery.event.Id).
+		AddHeader(WebhookDeliveryHeader, delivery.id).
+		AddHeader(WebhookAttemptHeader, strconv.Itoa(delivery.attempts)).
+		SetContentType(ioutils.MimeApplicationJSON).
+		SeBodyReader(bytes.NewReader(delivery.body))
+	var res *client.Response
+	if res, err = c.Execute(req); err == nil {
+		ioutils.CloserFunc(res.Raw().Body)
+		status = res.StatusCode()
+		if status < 200 || status > 299 {
+			err = fmt.Errorf("the subscriber responded with %s", res.Status())
+		}
+	}
+	return
+}
+
+// clientOf returns the client signing the requests with the secret of the subscriber. The client of a previous
+// secret is closed.
+func (d *WebhookDispatcher) clientOf(subscriber *Subscriber) *client.Client {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	c, ok := d.clients[subscriber.Id]
+	if !ok || c.secret != subscriber.Secret {
+		if ok {
+			_ = c.client.Close()
+		}
+		c = &subscriberClient{secret: subscriber.Secret, client: client.NewClient()}
+		c.client.ReqTimeout(uint(math.Ceil(d.opts.Timeout.Seconds())))
+		c.client.SignRequests(client.SigningOptions{
+			KeyId: subscriber.Id,
+			Key:   []byte(subscriber.Secret),
+			Headers: []string{rest.ContentTypeHeader, WebhookEventHeader, WebhookEventIdHeader, WebhookDeliveryHeader,
+				WebhookAttemptHeader},
+		})
+		d.clients[subscriber.Id] = c
+	}
+	return c.client
+}
+
+// breakerOf returns the circuit breaker of the subscriber. It opens after the threshold of consecutive failures and
+// lets an attempt through once the cooldown elapsed, which closes it if it succeeds or opens it again.
+func (d *WebhookDispatcher) breakerOf(subscriberId string) *clients.CircuitBreaker {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	breaker, ok := d.breakers[subscriberId]
+	if !ok {
+		breaker = clients.NewCB(&clients.BreakerInfo{
+			FailureThreshold: uint64(d.opts.BreakerThreshold),
+			SuccessThreshold: 1,
+			MaxHalfOpen:      1,
+			Timeout:          uint32(math.Ceil(d.opts.BreakerCooldown.Seconds())),
+		})
+		d.breakers[subscriberId] = breaker
+	}
+	return breaker
+}
+
+// deadLetter keeps the delivery with its dead letter record for a redelivery. It is called with the mutex held.
+func (d *WebhookDispatcher) deadLetter(delivery *webhookDelivery, cause error) {
+	delivery.deadLetter = &WebhookDeadLetter{
+		DeliveryId:   delivery.id,
+		SubscriberId: delivery.subscriberId,
+		Attempts:     delivery.attempts,
+		Error:        cause.Error(),
+		Time:         time.Now().UTC(),
+		Event:        delivery.event,
+	}
+	dead := append(d.dead[delivery.subscriberId], delivery)
+	if len(dead) > d.opts.MaxLogs {
+		dead = dead[len(dead)-d.opts.MaxLogs:]
+	}
+	d.dead[delivery.subscriberId] = dead
+}
+
+// log appends the entry to the logs of the subscriber. It is called with the mutex held.
+func (d *WebhookDispatcher) log(subscriberId string, entry DeliveryLog) {
+	logs := append(d.logs[subscriberId], entry)
+	if len(logs) > d.opts.MaxLogs {
+		logs = logs[len(logs)-d.opts.MaxLogs:]
+	}
+	d.logs[subscriberId] = logs
+}
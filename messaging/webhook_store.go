@@ -0,0 +1,169 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// SubscriberStore persists the subscribers of the webhooks. Implementations must be safe for concurrent use and
+// must not share the subscribers they return with the store.
+type SubscriberStore interface {
+	// Get returns the subscriber with the id or ErrSubscriberNotFound
+	Get(id string) (*Subscriber, error)
+	// List returns the subscribers ordered by id
+	List() ([]*Subscriber, error)
+	// Put adds the subscriber or replaces the subscriber with the same id
+	Put(subscriber *Subscriber) error
+	// Delete removes the subscriber with the id or returns ErrSubscriberNotFound
+	Delete(id string) error
+}
+
+// MemSubscriberStore is a SubscriberStore keeping the subscribers in memory
+type MemSubscriberStore struct {
+	mutex       sync.RWMutex
+	subscribers map[string]*Subscriber
+}
+
+// NewMemSubscriberStore creates an empty MemSubscriberStore
+func NewMemSubscriberStore() *MemSubscriberStore {
+	return &MemSubscriberStore{subscribers: map[string]*Subscriber{}}
+}
+
+// Get returns the subscriber with the id
+func (s *MemSubscriberStore) Get(id string) (*Subscriber, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if subscriber, ok := s.subscribers[id]; ok {
+		return cloneSubscriber(subscriber), nil
+	}
+	return nil, ErrSubscriberNotFound
+}
+
+// List returns the subscribers ordered by id
+func (s *MemSubscriberStore) List() ([]*Subscriber, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.list(), nil
+}
+
+func (s *MemSubscriberStore) list() []*Subscriber {
+	subscribers := make([]*Subscriber, 0, len(s.subscribers))
+	for _, subscriber := range s.subscribers {
+		subscribers = append(subscribers, cloneSubscriber(subscriber))
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].Id < subscribers[j].Id
+	})
+	return subscribers
+}
+
+// Put adds or replaces the subscriber after validating it
+func (s *MemSubscriberStore) Put(subscriber *Subscriber) (err error) {
+	if err = subscriber.Validate(); err == nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.subscribers[subscriber.Id] = cloneSubscriber(subscriber)
+	}
+	return
+}
+
+// Delete removes the subscriber with the id
+func (s *MemSubscriberStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.subscribers[id]; !ok {
+		return ErrSubscriberNotFound
+	}
+	delete(s.subscribers, id)
+	return nil
+}
+
+// FileSubscriberStore is a SubscriberStore keeping the subscribers in a JSON file. The file is read when the store is
+// opened and is rewritten on each change, so it is not meant to be shared by several processes.
+type FileSubscriberStore struct {
+	MemSubscriberStore
+	path string
+}
+
+// NewFileSubscriberStore opens the store of the file, which is created on the first change if it does not exist
+func NewFileSubscriberStore(path string) (store *FileSubscriberStore, err error) {
+	store = &FileSubscriberStore{MemSubscriberStore: MemSubscriberStore{subscribers: map[string]*Subscriber{}}, path: path}
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		} else {
+			store = nil
+		}
+		return
+	}
+	var subscribers []*Subscriber
+	if err = json.Unmarshal(data, &subscribers); err != nil {
+		store = nil
+		return
+	}
+	for _, subscriber := range subscribers {
+		store.subscribers[subscriber.Id] = subscriber
+	}
+	return
+}
+
+// Put adds or replaces the subscriber and rewrites the file
+func (s *FileSubscriberStore) Put(subscriber *Subscriber) (err error) {
+	if err = subscriber.Validate(); err == nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		previous, existed := s.subscribers[subscriber.Id]
+		s.subscribers[subscriber.Id] = cloneSubscriber(subscriber)
+		if err = s.write(); err != nil {
+			if existed {
+				s.subscribers[subscriber.Id] = previous
+			} else {
+				delete(s.subscribers, subscriber.Id)
+			}
+		}
+	}
+	return
+}
+
+// Delete removes the subscriber with the id and rewrites the file
+func (s *FileSubscriberStore) Delete(id string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	previous, ok := s.subscribers[id]
+	if !ok {
+		return ErrSubscriberNotFound
+	}
+	delete(s.subscribers, id)
+	if err = s.write(); err != nil {
+		s.subscribers[id] = previous
+	}
+	return
+}
+
+// write replaces the file with the subscribers. It is called with the mutex held.
+func (s *FileSubscriberStore) write() (err error) {
+	var data []byte
+	if data, err = json.MarshalIndent(s.list(), "", "  "); err != nil {
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		if err = os.Rename(tmp, s.path); err != nil {
+			_ = os.Remove(tmp)
+		}
+	}
+	return
+}
+
+func cloneSubscriber(subscriber *Subscriber) *Subscriber {
+	clone := *subscriber
+	clone.EventTypes = slices.Clone(subscriber.EventTypes)
+	clone.RetryTiers = slices.Clone(subscriber.RetryTiers)
+	return &clone
+}
@@ -0,0 +1,265 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// webhookReceiver is a subscriber endpoint verifying the signatures of the requests as a receiver would
+type webhookReceiver struct {
+	*httptest.Server
+	secret string
+	// status returns the status of the response to the attempt
+	status   func(attempt int) int
+	mutex    sync.Mutex
+	events   []*WebhookEvent
+	attempts []int
+	calls    atomic.Int32
+}
+
+func newWebhookReceiver(t *testing.T, secret string, status func(attempt int) int) *webhookReceiver {
+	r := &webhookReceiver{secret: secret, status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.calls.Add(1)
+		body, _ := io.ReadAll(req.Body)
+		canonical := rest.CanonicalString(req.Method, req.URL, req.Header, rest.BodySha256(body))
+		if req.Header.Get(rest.SignatureHeader) != rest.SignCanonical([]byte(r.secret), canonical) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		attempt, _ := strconv.Atoi(req.Header.Get(WebhookAttemptHeader))
+		event := &WebhookEvent{}
+		if err := json.Unmarshal(body, event); err != nil || event.Type != req.Header.Get(WebhookEventHeader) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.mutex.Lock()
+		r.events = append(r.events, event)
+		r.attempts = append(r.attempts, attempt)
+		r.mutex.Unlock()
+		w.WriteHeader(r.status(attempt))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) received() (types []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	return
+}
+
+func alwaysStatus(status int) func(int) int {
+	return func(int) int { return status }
+}
+
+// waitUntil polls the condition until it holds or a few seconds elapsed
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatal("the condition was not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestDispatcher(t *testing.T, source string, opts DispatcherOptions,
+	subscribers ...*Subscriber) *WebhookDispatcher {
+	store := NewMemSubscriberStore()
+	for _, subscriber := range subscribers {
+		assert.NoError(t, store.Put(subscriber))
+	}
+	opts.Source, _ = url.Parse(source)
+	d, err := NewWebhookDispatcher(GetManager(), store, opts)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Start())
+	t.Cleanup(func() {
+		_ = d.Stop()
+	})
+	return d
+}
+
+func publish(t *testing.T, d *WebhookDispatcher, eventType string, data any) *WebhookEvent {
+	event, err := NewWebhookEvent(eventType, data)
+	assert.NoError(t, err)
+	assert.NoError(t, PublishWebhookEvent(GetManager(), d.opts.Source, event))
+	return event
+}
+
+func TestSubscriber_Matches(t *testing.T) {
+	for _, tt := range []struct {
+		types     []string
+		eventType string
+		want      bool
+	}{
+		{nil, "order.created", true},
+		{[]string{"order.created"}, "order.created", true},
+		{[]string{"order.created"}, "order.deleted", false},
+		{[]string{"order.*"}, "order.created", true},
+		{[]string{"order.*"}, "orders.created", false},
+		{[]string{"invoice.paid", "*"}, "user.created", true},
+	} {
+		s := &Subscriber{EventTypes: tt.types}
+		assert.Equal(t, tt.want, s.Matches(tt.eventType))
+	}
+}
+
+func TestSubscriberStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscribers.json")
+	file, err := NewFileSubscriberStore(path)
+	assert.NoError(t, err)
+	for _, store := range []SubscriberStore{NewMemSubscriberStore(), file} {
+		err = store.Put(&Subscriber{Id: "a", Url: "ftp://example.com", Secret: "s"})
+		assert.True(t, errors.Is(err, ErrInvalidSubscriber))
+		subscriber := &Subscriber{Id: "b", Url: "https://example.com/hook", Secret: "s", EventTypes: []string{"x"}}
+		assert.NoError(t, store.Put(subscriber))
+		assert.NoError(t, store.Put(&Subscriber{Id: "a", Url: "http://example.com", Secret: "s", Active: true}))
+		// the store does not share the subscribers
+		subscriber.EventTypes[0] = "y"
+		got, err := store.Get("b")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"x"}, got.EventTypes)
+		list, err := store.List()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(list))
+		assert.Equal(t, "a", list[0].Id)
+		assert.NoError(t, store.Delete("b"))
+		assert.True(t, errors.Is(store.Delete("b"), ErrSubscriberNotFound))
+		_, err = store.Get("b")
+		assert.True(t, errors.Is(err, ErrSubscriberNotFound))
+	}
+	reopened, err := NewFileSubscriberStore(path)
+	assert.NoError(t, err)
+	list, err := reopened.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, &Subscriber{Id: "a", Url: "http://example.com", Secret: "s", Active: true}, list[0])
+}
+
+func TestWebhookDispatcher_Delivery(t *testing.T) {
+	orders := newWebhookReceiver(t, "orders-secret", alwaysStatus(http.StatusOK))
+	all := newWebhookReceiver(t, "all-secret", alwaysStatus(http.StatusNoContent))
+	inactive := newWebhookReceiver(t, "inactive-secret", alwaysStatus(http.StatusOK))
+	d := newTestDispatcher(t, "chan://webhook-delivery", DispatcherOptions{},
+		&Subscriber{Id: "orders", Url: orders.URL, Secret: orders.secret, EventTypes: []string{"order.*"}, Active: true},
+		&Subscriber{Id: "all", Url: all.URL, Secret: all.secret, Active: true},
+		&Subscriber{Id: "inactive", Url: inactive.URL, Secret: inactive.secret})
+
+	created := publish(t, d, "order.created", map[string]any{"id": 42})
+	publish(t, d, "invoice.paid", map[string]any{"id": 7})
+	waitUntil(t, func() bool {
+		return len(orders.received()) == 1 && len(all.received()) == 2
+	})
+	assert.Equal(t, []string{"order.created"}, orders.received())
+	assert.Equal(t, int32(0), inactive.calls.Load())
+	assert.Equal(t, created.Id, orders.events[0].Id)
+	assert.Equal(t, `{"id":42}`, string(orders.events[0].Data))
+
+	// the receiver rejects the requests signed with another secret
+	assert.NoError(t, d.Store().Put(&Subscriber{Id: "orders", Url: orders.URL, Secret: "rotated", Active: true}))
+	publish(t, d, "order.deleted", nil)
+	waitUntil(t, func() bool {
+		return len(d.Deliveries("orders")) == 2
+	})
+	logs := d.Deliveries("orders")
+	assert.Equal(t, DeliverySucceeded, logs[0].Status)
+	assert.Equal(t, http.StatusOK, logs[0].StatusCode)
+	assert.Equal(t, 1, logs[0].Attempt)
+	assert.Equal(t, DeliveryRetrying, logs[1].Status)
+	assert.Equal(t, http.StatusUnauthorized, logs[1].StatusCode)
+}
+
+func TestWebhookDispatcher_RetryAndDeadLetter(t *testing.T) {
+	flaky := newWebhookReceiver(t, "flaky", func(attempt int) int {
+		if attempt < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	down := newWebhookReceiver(t, "down", alwaysStatus(http.StatusInternalServerError))
+	deadLetters, _ := url.Parse("chan://webhook-dlq")
+	d := newTestDispatcher(t, "chan://webhook-retry", DispatcherOptions{
+		RetryTiers: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+		DeadLetter: deadLetters,
+	},
+		&Subscriber{Id: "flaky", Url: flaky.URL, Secret: flaky.secret, Active: true},
+		&Subscriber{Id: "down", Url: down.URL, Secret: down.secret, Active: true,
+			RetryTiers: []time.Duration{5 * time.Millisecond}})
+
+	event := publish(t, d, "order.created", nil)
+	var record WebhookDeadLetter
+	_, err := GetManager().ReceiveObject(deadLetters, &record)
+	assert.NoError(t, err)
+	assert.Equal(t, "down", record.SubscriberId)
+	assert.Equal(t, 2, record.Attempts)
+	assert.Equal(t, event.Id, record.Event.Id)
+
+	waitUntil(t, func() bool {
+		return len(d.Deliveries("flaky")) == 3
+	})
+	var statuses []DeliveryStatus
+	for _, log := range d.Deliveries("flaky") {
+		statuses = append(statuses, log.Status)
+		assert.Equal(t, d.Deliveries("flaky")[0].DeliveryId, log.DeliveryId)
+	}
+	assert.Equal(t, []DeliveryStatus{DeliveryRetrying, DeliveryRetrying, DeliverySucceeded}, statuses)
+	assert.Equal(t, []int{1, 2, 3}, flaky.attempts)
+	assert.Equal(t, 0, len(d.DeadLetters("flaky")))
+
+	dead := d.DeadLetters("down")
+	assert.Equal(t, 1, len(dead))
+	assert.Equal(t, DeliveryDead, d.Deliveries("down")[1].Status)
+	assert.True(t, errors.Is(d.Redeliver("unknown"), ErrDeliveryNotFound))
+}
+
+func TestWebhookDispatcher_BreakerIsolation(t *testing.T) {
+	down := newWebhookReceiver(t, "down", alwaysStatus(http.StatusBadGateway))
+	healthy := newWebhookReceiver(t, "healthy", alwaysStatus(http.StatusOK))
+	d := newTestDispatcher(t, "chan://webhook-breaker", DispatcherOptions{
+		RetryTiers:       []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond},
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+	},
+		&Subscriber{Id: "down", Url: down.URL, Secret: down.secret, Active: true},
+		&Subscriber{Id: "healthy", Url: healthy.URL, Secret: healthy.secret, Active: true})
+
+	publish(t, d, "order.created", 0)
+	waitUntil(t, func() bool {
+		return len(d.DeadLetters("down")) == 1
+	})
+	for i := 1; i < 3; i++ {
+		publish(t, d, "order.created", i)
+	}
+	waitUntil(t, func() bool {
+		return len(d.DeadLetters("down")) == 3 && len(healthy.received()) == 3
+	})
+	// the breaker opened after two calls, the other attempts failed without calling the endpoint
+	assert.Equal(t, int32(2), down.calls.Load())
+	open := 0
+	for _, log := range d.Deliveries("down") {
+		if log.Error == ErrWebhookCircuitOpen.Error() {
+			open++
+		}
+	}
+	assert.Equal(t, 10, open)
+	for _, log := range d.Deliveries("healthy") {
+		assert.Equal(t, DeliverySucceeded, log.Status)
+	}
+}
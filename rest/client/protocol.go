@@ -0,0 +1,262 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// ProtocolPolicy controls the HTTP protocol versions the client uses.
+type ProtocolPolicy int
+
+const (
+	// AutoALPN negotiates HTTP/2 over TLS using ALPN and falls back to HTTP/1.1. This is the default policy.
+	AutoALPN ProtocolPolicy = iota
+	// ForceHTTP1 disables HTTP/2, useful for servers with broken HTTP/2 implementations.
+	ForceHTTP1
+	// ForceHTTP2 requires HTTP/2 over TLS. Requests fail with ErrHTTP2Required if the server cannot negotiate it.
+	ForceHTTP2
+	// H2CPriorKnowledge uses cleartext HTTP/2 without an upgrade for http urls and HTTP/2 over TLS for https urls.
+	H2CPriorKnowledge
+)
+
+var protocolPolicyNames = map[ProtocolPolicy]string{
+	AutoALPN:          "AutoALPN",
+	ForceHTTP1:        "ForceHTTP1",
+	ForceHTTP2:        "ForceHTTP2",
+	H2CPriorKnowledge: "H2CPriorKnowledge",
+}
+
+var ErrHTTP2Required = errors.New("http2 is required by the protocol policy")
+
+func (p ProtocolPolicy) String() string {
+	if name, ok := protocolPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("ProtocolPolicy(%d)", int(p))
+}
+
+// protocols returns the protocols of the transport for the policy. Nil leaves the transport defaults.
+func (p ProtocolPolicy) protocols() (protocols *http.Protocols) {
+	switch p {
+	case ForceHTTP1:
+		protocols = &http.Protocols{}
+		protocols.SetHTTP1(true)
+	case ForceHTTP2:
+		protocols = &http.Protocols{}
+		protocols.SetHTTP2(true)
+	case H2CPriorKnowledge:
+		protocols = &http.Protocols{}
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	return
+}
+
+// HTTP2ErrCode is the error code of an HTTP/2 GOAWAY or RST_STREAM frame.
+type HTTP2ErrCode uint32
+
+const (
+	HTTP2NoError            HTTP2ErrCode = 0x0
+	HTTP2ProtocolError      HTTP2ErrCode = 0x1
+	HTTP2InternalError      HTTP2ErrCode = 0x2
+	HTTP2FlowControlError   HTTP2ErrCode = 0x3
+	HTTP2SettingsTimeout    HTTP2ErrCode = 0x4
+	HTTP2StreamClosed       HTTP2ErrCode = 0x5
+	HTTP2FrameSizeError     HTTP2ErrCode = 0x6
+	HTTP2RefusedStream      HTTP2ErrCode = 0x7
+	HTTP2Cancel             HTTP2ErrCode = 0x8
+	HTTP2CompressionError   HTTP2ErrCode = 0x9
+	HTTP2ConnectError       HTTP2ErrCode = 0xa
+	HTTP2EnhanceYourCalm    HTTP2ErrCode = 0xb
+	HTTP2InadequateSecurity HTTP2ErrCode = 0xc
+	HTTP2HTTP11Required     HTTP2ErrCode = 0xd
+)
+
+var http2ErrCodeNames = map[HTTP2ErrCode]string{
+	HTTP2NoError:            "NO_ERROR",
+	HTTP2ProtocolError:      "PROTOCOL_ERROR",
+	HTTP2InternalError:      "INTERNAL_ERROR",
+	HTTP2FlowControlError:   "FLOW_CONTROL_ERROR",
+	HTTP2SettingsTimeout:    "SETTINGS_TIMEOUT",
+	HTTP2StreamClosed:       "STREAM_CLOSED",
+	HTTP2FrameSizeError:     "FRAME_SIZE_ERROR",
+	HTTP2RefusedStream:      "REFUSED_STREAM",
+	HTTP2Cancel:             "CANCEL",
+	HTTP2CompressionError:   "COMPRESSION_ERROR",
+	HTTP2ConnectError:       "CONNECT_ERROR",
+	HTTP2EnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	HTTP2InadequateSecurity: "INADEQUATE_SECURITY",
+	HTTP2HTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c HTTP2ErrCode) String() string {
+	if name, ok := http2ErrCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ERR_UNKNOWN_%d", uint32(c))
+}
+
+// GoAwayError is returned when the server sent a GOAWAY frame and closed the connection while the request was
+// in flight.
+type GoAwayError struct {
+	LastStreamID uint32
+	ErrCode      HTTP2ErrCode
+	DebugData    string
+	err          error
+}
+
+func (e *GoAwayError) Error() string {
+	return fmt.Sprintf("http2: server sent GOAWAY; last stream id %d, error code %s, debug %q", e.LastStreamID,
+		e.ErrCode, e.DebugData)
+}
+
+func (e *GoAwayError) Unwrap() error {
+	return e.err
+}
+
+// RetrySafe reports whether the request can be retried. A GOAWAY with NO_ERROR is a graceful shutdown of the
+// connection by the server.
+func (e *GoAwayError) RetrySafe() bool {
+	return e.ErrCode == HTTP2NoError
+}
+
+// StreamResetError is returned when the stream of the request was reset with a RST_STREAM frame.
+type StreamResetError struct {
+	StreamID uint32
+	ErrCode  HTTP2ErrCode
+	err      error
+}
+
+func (e *StreamResetError) Error() string {
+	return fmt.Sprintf("http2: stream %d reset with error code %s", e.StreamID, e.ErrCode)
+}
+
+func (e *StreamResetError) Unwrap() error {
+	return e.err
+}
+
+// RetrySafe reports whether the request can be retried. A stream refused by the server was not processed.
+func (e *StreamResetError) RetrySafe() bool {
+	return e.ErrCode == HTTP2RefusedStream
+}
+
+// IsRetrySafe reports whether the error is an HTTP/2 error after which the request can be safely retried.
+func IsRetrySafe(err error) bool {
+	var goAway *GoAwayError
+	var reset *StreamResetError
+	if errors.As(err, &goAway) {
+		return goAway.RetrySafe()
+	}
+	if errors.As(err, &reset) {
+		return reset.RetrySafe()
+	}
+	return false
+}
+
+// isHTTP2Error reports whether the error is a GoAwayError or a StreamResetError
+func isHTTP2Error(err error) bool {
+	var goAway *GoAwayError
+	var reset *StreamResetError
+	return errors.As(err, &goAway) || errors.As(err, &reset)
+}
+
+// toProtocolError converts the HTTP/2 errors of the net/http transport into a GoAwayError or a StreamResetError.
+// The transport does not export its error types, their fields are read by reflection.
+func toProtocolError(err error) error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		v := reflect.ValueOf(e)
+		if v.Kind() != reflect.Struct || !strings.HasPrefix(v.Type().PkgPath(), "net/http") {
+			continue
+		}
+		switch strings.TrimPrefix(v.Type().Name(), "http2") {
+		case "GoAwayError":
+			return &GoAwayError{
+				LastStreamID: uint32(v.FieldByName("LastStreamID").Uint()),
+				ErrCode:      HTTP2ErrCode(v.FieldByName("ErrCode").Uint()),
+				DebugData:    v.FieldByName("DebugData").String(),
+				err:          err,
+			}
+		case "StreamError":
+			return &StreamResetError{
+				StreamID: uint32(v.FieldByName("StreamID").Uint()),
+				ErrCode:  HTTP2ErrCode(v.FieldByName("Code").Uint()),
+				err:      err,
+			}
+		}
+	}
+	return err
+}
+
+// protocolBody converts the HTTP/2 errors of reading the body of the response
+type protocolBody struct {
+	io.ReadCloser
+}
+
+func (b *protocolBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = toProtocolError(err)
+	}
+	return
+}
+
+// SetProtocolPolicy sets the protocol policy of the client. Requests can override it with
+// Request.SetProtocolPolicy.
+func (c *Client) SetProtocolPolicy(policy ProtocolPolicy) *Client {
+	c.protocolPolicy = policy
+	return c
+}
+
+// httpClientFor returns the http client for the policy. The clients of the policies other than AutoALPN use a clone
+// of the transport of the client with the protocols of the policy.
+func (c *Client) httpClientFor(policy ProtocolPolicy) *http.Client {
+	base, ok := c.httpClient.Transport.(*http.Transport)
+	if policy == AutoALPN || !ok {
+		return &c.httpClient
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.protocolBase != base {
+		c.protocolBase = base
+		c.protocolTransports = make(map[ProtocolPolicy]*http.Transport)
+	}
+	transport, ok := c.protocolTransports[policy]
+	if !ok {
+		transport = base.Clone()
+		transport.Protocols = policy.protocols()
+		// the clone inherits the ALPN setup of the base transport, it is redone for the protocols of the policy
+		transport.TLSNextProto = nil
+		if transport.TLSClientConfig != nil {
+			transport.TLSClientConfig.NextProtos = nil
+		}
+		c.protocolTransports[policy] = transport
+	}
+	httpClient := c.httpClient
+	httpClient.Transport = transport
+	return &httpClient
+}
+
+// do sends the request with the protocol policy
+func (c *Client) do(httpReq *http.Request, policy ProtocolPolicy) (httpRes *http.Response, err error) {
+	if policy == ForceHTTP2 && httpReq.URL.Scheme != "https" {
+		err = fmt.Errorf("%w: http2 cannot be negotiated for %s", ErrHTTP2Required, httpReq.URL.Redacted())
+		return
+	}
+	httpRes, err = c.httpClientFor(policy).Do(httpReq)
+	if err != nil {
+		err = toProtocolError(err)
+		return
+	}
+	if (policy == ForceHTTP2 || policy == H2CPriorKnowledge) && httpRes.ProtoMajor != 2 {
+		_ = httpRes.Body.Close()
+		err = fmt.Errorf("%w: server responded with %s", ErrHTTP2Required, httpRes.Proto)
+		httpRes = nil
+		return
+	}
+	httpRes.Body = &protocolBody{ReadCloser: httpRes.Body}
+	return
+}
@@ -0,0 +1,247 @@
+package client
+
+import (
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func protoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+}
+
+func newH2CServer() *httptest.Server {
+	s := httptest.NewUnstartedServer(protoHandler())
+	s.Config.Protocols = &http.Protocols{}
+	s.Config.Protocols.SetHTTP1(true)
+	s.Config.Protocols.SetUnencryptedHTTP2(true)
+	s.Start()
+	return s
+}
+
+func newH2Server() *httptest.Server {
+	s := httptest.NewUnstartedServer(protoHandler())
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	return s
+}
+
+// trustServer adds the certificate of the TLS test server to the CA certificates of the client
+func trustServer(t *testing.T, c *Client, s *httptest.Server) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(path, cert, 0o600))
+	_, err := c.SetCACerts(path)
+	assert.NoError(t, err)
+}
+
+func TestClient_ProtocolPolicy(t *testing.T) {
+	h1 := httptest.NewServer(protoHandler())
+	defer h1.Close()
+	h2 := newH2Server()
+	defer h2.Close()
+	h2c := newH2CServer()
+	defer h2c.Close()
+
+	tests := []struct {
+		name      string
+		url       string
+		policy    ProtocolPolicy
+		reqPolicy *ProtocolPolicy
+		want      string
+		wantErr   error
+	}{
+		{name: "auto/http1", url: h1.URL, policy: AutoALPN, want: "HTTP/1.1"},
+		{name: "auto/h2", url: h2.URL, policy: AutoALPN, want: "HTTP/2.0"},
+		{name: "auto/h2c", url: h2c.URL, policy: AutoALPN, want: "HTTP/1.1"},
+		{name: "http1/h2", url: h2.URL, policy: ForceHTTP1, want: "HTTP/1.1"},
+		{name: "http2/h2", url: h2.URL, policy: ForceHTTP2, want: "HTTP/2.0"},
+		{name: "http2/http1", url: h1.URL, policy: ForceHTTP2, wantErr: ErrHTTP2Required},
+		{name: "h2c/h2c", url: h2c.URL, policy: H2CPriorKnowledge, want: "HTTP/2.0"},
+		{name: "h2c/h2", url: h2.URL, policy: H2CPriorKnowledge, want: "HTTP/2.0"},
+		{name: "override", url: h2c.URL, policy: ForceHTTP1, reqPolicy: policyOf(H2CPriorKnowledge),
+			want: "HTTP/2.0"},
+		{name: "override/auto", url: h2.URL, policy: ForceHTTP2, reqPolicy: policyOf(AutoALPN), want: "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient()
+			_, err := c.SSlVerify(true)
+			assert.NoError(t, err)
+			trustServer(t, c, h2)
+			c.SetProtocolPolicy(tt.policy)
+			defer c.Close()
+			req := c.NewRequest(tt.url, http.MethodGet)
+			if tt.reqPolicy != nil {
+				req.SetProtocolPolicy(*tt.reqPolicy)
+			}
+			res, err := c.Execute(req)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, res.Proto())
+			body, err := io.ReadAll(res.Raw().Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+func TestClient_ForceHTTP2FailFast(t *testing.T) {
+	h1TLS := httptest.NewTLSServer(protoHandler())
+	defer h1TLS.Close()
+	c := NewClient().SetProtocolPolicy(ForceHTTP2)
+	_, err := c.SSlVerify(true)
+	assert.NoError(t, err)
+	trustServer(t, c, h1TLS)
+	defer c.Close()
+	res, err := c.Execute(c.NewRequest(h1TLS.URL, http.MethodGet))
+	assert.Error(t, err)
+	assert.True(t, res == nil)
+}
+
+func policyOf(p ProtocolPolicy) *ProtocolPolicy {
+	return &p
+}
+
+const (
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FrameRSTStream    = 0x3
+	h2FrameSettings     = 0x4
+	h2FrameGoAway       = 0x7
+	h2FlagAck           = 0x1
+	h2FlagEndHeaders    = 0x4
+	h2ClientPrefaceSize = 24
+)
+
+func writeH2Frame(w io.Writer, typ, flags byte, streamId uint32, payload []byte) error {
+	header := make([]byte, 9)
+	header[0], header[1], header[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	header[3], header[4] = typ, flags
+	binary.BigEndian.PutUint32(header[5:], streamId)
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// rawH2CServer starts a cleartext HTTP/2 server speaking raw frames. It answers the first request with the headers
+// and a part of the body and then calls abort with the stream id.
+func rawH2CServer(t *testing.T, abort func(conn net.Conn, streamId uint32)) (addr string, closer func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err = io.ReadFull(conn, make([]byte, h2ClientPrefaceSize)); err != nil {
+			return
+		}
+		if err = writeH2Frame(conn, h2FrameSettings, 0, 0, nil); err != nil {
+			return
+		}
+		header := make([]byte, 9)
+		for {
+			if _, err = io.ReadFull(conn, header); err != nil {
+				return
+			}
+			length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+			streamId := binary.BigEndian.Uint32(header[5:]) & 0x7fffffff
+			if _, err = io.ReadFull(conn, make([]byte, length)); err != nil {
+				return
+			}
+			switch {
+			case header[3] == h2FrameSettings && header[4]&h2FlagAck == 0:
+				_ = writeH2Frame(conn, h2FrameSettings, h2FlagAck, 0, nil)
+			case header[3] == h2FrameHeaders:
+				// 0x88 is the indexed :status 200 header of the HPACK static table
+				_ = writeH2Frame(conn, h2FrameHeaders, h2FlagEndHeaders, streamId, []byte{0x88})
+				_ = writeH2Frame(conn, h2FrameData, 0, streamId, []byte("partial"))
+				abort(conn, streamId)
+			}
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { _ = l.Close() }
+}
+
+func TestClient_HTTP2Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		abort     func(conn net.Conn, streamId uint32)
+		check     func(t *testing.T, err error)
+		retrySafe bool
+	}{
+		{
+			name: "reset",
+			abort: func(conn net.Conn, streamId uint32) {
+				payload := binary.BigEndian.AppendUint32(nil, uint32(HTTP2InternalError))
+				_ = writeH2Frame(conn, h2FrameRSTStream, 0, streamId, payload)
+			},
+			check: func(t *testing.T, err error) {
+				var reset *StreamResetError
+				assert.True(t, errors.As(err, &reset))
+				assert.Equal(t, uint32(1), reset.StreamID)
+				assert.Equal(t, HTTP2InternalError, reset.ErrCode)
+			},
+		},
+		{
+			name: "refused",
+			abort: func(conn net.Conn, streamId uint32) {
+				payload := binary.BigEndian.AppendUint32(nil, uint32(HTTP2RefusedStream))
+				_ = writeH2Frame(conn, h2FrameRSTStream, 0, streamId, payload)
+			},
+			check: func(t *testing.T, err error) {
+				var reset *StreamResetError
+				assert.True(t, errors.As(err, &reset))
+				assert.Equal(t, HTTP2RefusedStream, reset.ErrCode)
+			},
+			retrySafe: true,
+		},
+		{
+			name: "goaway",
+			abort: func(conn net.Conn, streamId uint32) {
+				payload := binary.BigEndian.AppendUint32(nil, streamId)
+				payload = binary.BigEndian.AppendUint32(payload, uint32(HTTP2EnhanceYourCalm))
+				payload = append(payload, "slow down"...)
+				_ = writeH2Frame(conn, h2FrameGoAway, 0, 0, payload)
+				_ = conn.(*net.TCPConn).CloseWrite()
+			},
+			check: func(t *testing.T, err error) {
+				var goAway *GoAwayError
+				assert.True(t, errors.As(err, &goAway))
+				assert.Equal(t, uint32(1), goAway.LastStreamID)
+				assert.Equal(t, HTTP2EnhanceYourCalm, goAway.ErrCode)
+				assert.Equal(t, "slow down", goAway.DebugData)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, closer := rawH2CServer(t, tt.abort)
+			defer closer()
+			c := NewClient().SetProtocolPolicy(H2CPriorKnowledge)
+			defer c.Close()
+			res, err := c.Execute(c.NewRequest(addr, http.MethodGet))
+			assert.NoError(t, err)
+			assert.Equal(t, "HTTP/2.0", res.Proto())
+			_, err = io.ReadAll(res.Raw().Body)
+			assert.Error(t, err)
+			tt.check(t, err)
+			assert.Equal(t, tt.retrySafe, IsRetrySafe(err))
+		})
+	}
+}
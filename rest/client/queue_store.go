@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueDedupWindow = 24 * time.Hour
+	// queueCompactThreshold is the number of obsolete lines of the file from which it is compacted
+	queueCompactThreshold = 1000
+)
+
+// QueuedRequest is a request of a QueuedSender with its body captured when it was enqueued
+type QueuedRequest struct {
+	// Id identifies the request in the queue
+	Id string `json:"id"`
+	// OrderingKey groups the requests delivered in order, the requests of different keys are delivered in parallel
+	OrderingKey string `json:"orderingKey,omitempty"`
+	// IdempotencyKey is the value of the Idempotency-Key header, the requests with a key are delivered once
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Method of the request
+	Method string `json:"method"`
+	// Url of the request
+	Url string `json:"url"`
+	// Header of the request
+	Header http.Header `json:"header,omitempty"`
+	// Body of the request
+	Body []byte `json:"body,omitempty"`
+	// Enqueued is the time the request was enqueued
+	Enqueued time.Time `json:"enqueued"`
+	// Attempts is the number of deliveries attempted since the sender started
+	Attempts int `json:"-"`
+}
+
+// QueueStore persists the requests of a QueuedSender. The store of a queue must be used by a single sender.
+type QueueStore interface {
+	// Add appends the request to the queue
+	Add(q *QueuedRequest) error
+	// Remove removes the request from the queue. The idempotency key of a delivered request is remembered so that the
+	// request is not delivered again, it is empty for the requests evicted.
+	Remove(id string, deliveredKey string) error
+	// Pending returns the requests of the queue in the order they were added
+	Pending() ([]*QueuedRequest, error)
+	// Delivered checks if a request with the idempotency key was delivered
+	Delivered(idempotencyKey string) (bool, error)
+}
+
+// queueEntry is a line of the file of a FileQueueStore
+type queueEntry struct {
+	Op      string         `json:"op"`
+	Request *QueuedRequest `json:"request,omitempty"`
+	Id      string         `json:"id,omitempty"`
+	Key     string         `json:"key,omitempty"`
+	At      time.Time      `json:"at,omitempty"`
+}
+
+// FileQueueStore is a QueueStore appending the changes of the queue to a file of JSON lines. The requests added are
+// synced to the disk before Add returns, so they survive a crash of the process; a removal lost in a crash delivers
+// the request again, with its Idempotency-Key if it has one. The file is compacted once it holds more removed
+// requests than pending ones. The idempotency keys of the delivered requests are remembered for the dedup window.
+type FileQueueStore struct {
+	path        string
+	dedupWindow time.Duration
+	mutex       sync.Mutex
+	file        *os.File
+	pending     map[string]*QueuedRequest
+	order       []string
+	delivered   map[string]time.Time
+	obsolete    int
+}
+
+// NewFileQueueStore opens the queue store of the file, creating it if it does not exist. A dedup window of 0
+// selects 24 hours.
+func NewFileQueueStore(path string, dedupWindow time.Duration) (store *FileQueueStore, err error) {
+	if dedupWindow <= 0 {
+		dedupWindow = defaultQueueDedupWindow
+	}
+	store = &FileQueueStore{
+		path:        path,
+		dedupWindow: dedupWindow,
+		pending:     map[string]*QueuedRequest{},
+		delivered:   map[string]time.Time{},
+	}
+	if err = store.read(); err == nil {
+		err = store.compact()
+	}
+	if err != nil {
+		store = nil
+	}
+	return
+}
+
+// Add appends the request to the file and syncs it
+func (s *FileQueueStore) Add(q *QueuedRequest) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err = s.append(&queueEntry{Op: "add", Request: q}); err == nil {
+		if err = s.file.Sync(); err == nil {
+			s.pending[q.Id] = q
+			s.order = append(s.order, q.Id)
+		}
+	}
+	return
+}
+
+// Remove appends the removal of the request to the file
+func (s *FileQueueStore) Remove(id string, deliveredKey string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if err = s.append(&queueEntry{Op: "remove", Id: id, Key: deliveredKey, At: now}); err != nil {
+		return
+	}
+	s.apply(id, deliveredKey, now)
+	if s.obsolete > queueCompactThreshold && s.obsolete > 2*len(s.pending) {
+		err = s.compact()
+	}
+	return
+}
+
+// Pending returns the requests of the queue in the order they were added
+func (s *FileQueueStore) Pending() (requests []*QueuedRequest, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range s.order {
+		if q, ok := s.pending[id]; ok {
+			requests = append(requests, q)
+		}
+	}
+	return
+}
+
+// Delivered checks if a request with the idempotency key was delivered within the dedup window
+func (s *FileQueueStore) Delivered(idempotencyKey string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	at, ok := s.delivered[idempotencyKey]
+	return ok && time.Since(at) < s.dedupWindow, nil
+}
+
+// Close closes the file
+func (s *FileQueueStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// apply removes the request and remembers its idempotency key
+func (s *FileQueueStore) apply(id, deliveredKey string, at time.Time) {
+	if _, ok := s.pending[id]; ok {
+		delete(s.pending, id)
+		s.obsolete++
+	}
+	if deliveredKey != "" {
+		s.delivered[deliveredKey] = at
+	}
+}
+
+// append writes the entry as a line of the file
+func (s *FileQueueStore) append(entry *queueEntry) (err error) {
+	var data []byte
+	if data, err = json.Marshal(entry); err == nil {
+		_, err = s.file.Write(append(data, '\n'))
+	}
+	return
+}
+
+// read replays the lines of the file. A last line cut by a crash is ignored.
+func (s *FileQueueStore) read() (err error) {
+	var f *os.File
+	if f, err = os.Open(s.path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for {
+		var line []byte
+		if line, err = reader.ReadBytes('\n'); err != nil {
+			// the line without its newline was not written entirely
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return
+		}
+		var entry queueEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return
+		}
+		switch {
+		case entry.Op == "add" && entry.Request != nil:
+			s.pending[entry.Request.Id] = entry.Request
+			s.order = append(s.order, entry.Request.Id)
+		case entry.Op == "remove":
+			s.apply(entry.Id, entry.Key, entry.At)
+		}
+	}
+}
+
+// compact replaces the file with the pending requests and the delivered keys of the dedup window through a
+// temporary file, and opens it for the appends
+func (s *FileQueueStore) compact() (err error) {
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp"); err != nil {
+		return
+	}
+	s.file, tmp = tmp, s.file
+	order := make([]string, 0, len(s.pending))
+	for _, id := range s.order {
+		if q, ok := s.pending[id]; ok && err == nil {
+			order = append(order, id)
+			err = s.append(&queueEntry{Op: "add", Request: q})
+		}
+	}
+	expired := time.Now().Add(-s.dedupWindow)
+	for key, at := range s.delivered {
+		if at.Before(expired) {
+			delete(s.delivered, key)
+		} else if err == nil {
+			err = s.append(&queueEntry{Op: "remove", Key: key, At: at})
+		}
+	}
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(s.file.Name(), s.path)
+	}
+	if err != nil {
+		_ = s.file.Close()
+		_ = os.Remove(s.file.Name())
+		s.file = tmp
+		return
+	}
+	if tmp != nil {
+		_ = tmp.Close()
+	}
+	s.order, s.obsolete = order, 0
+	return
+}
@@ -0,0 +1,418 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/uuid"
+)
+
+const (
+	defaultQueueMaxSize    = 10000
+	defaultQueueWorkers    = 4
+	defaultQueueMinBackoff = time.Second
+	defaultQueueMaxBackoff = 5 * time.Minute
+)
+
+var (
+	// ErrQueueFull is the eviction reason of the oldest requests once the queue reached its MaxSize
+	ErrQueueFull = errors.New("the request queue is full")
+	// ErrQueueExpired is the eviction reason of the requests queued for longer than the MaxAge
+	ErrQueueExpired = errors.New("the request expired in the queue")
+	// ErrDuplicateRequest is returned by Enqueue for a request whose idempotency key is queued or delivered
+	ErrDuplicateRequest = errors.New("a request with the idempotency key is already queued or delivered")
+	// ErrSenderClosed is returned by Enqueue once the sender is closed
+	ErrSenderClosed = errors.New("the queued sender is closed")
+)
+
+// QueuedOptions configures a QueuedSender
+type QueuedOptions struct {
+	// OrderingKey returns the key of the requests delivered in order. The requests of different keys are delivered in
+	// parallel. All the requests are delivered in a strict global order if nil.
+	OrderingKey func(q *QueuedRequest) string
+	// Workers is the number of ordering keys delivered at once. Defaults to 4.
+	Workers int
+	// MaxSize is the number of requests queued. Once it is reached the oldest request is evicted for a new one.
+	// Defaults to 10000.
+	MaxSize int
+	// MaxAge is the longest time a request is queued before it is evicted, no limit if 0
+	MaxAge time.Duration
+	// OnEvict is called with the requests dropped from the queue along with ErrQueueFull or ErrQueueExpired
+	OnEvict func(q *QueuedRequest, reason error)
+	// OnResponse is called with the responses of the requests enqueued without a callback, the responses are
+	// discarded if nil. It is also called for the requests recovered from the store.
+	OnResponse func(q *QueuedRequest, res *Response)
+	// MinBackoff is the wait before probing the connectivity again after the first failure. It doubles with every
+	// failure up to MaxBackoff. Defaults to 1 second.
+	MinBackoff time.Duration
+	// MaxBackoff is the longest wait between two probes. Defaults to 5 minutes.
+	MaxBackoff time.Duration
+}
+
+// QueueStats are the counters of a QueuedSender
+type QueueStats struct {
+	// Depth is the number of requests queued, including the ones being sent
+	Depth int
+	// OldestAge is the time the oldest request has been queued for
+	OldestAge time.Duration
+	// Delivered is the number of requests that got a successful response
+	Delivered uint64
+	// Failed is the number of requests that got a client error response, they are not retried
+	Failed uint64
+	// Retries is the number of attempts that failed to reach the server or got a server error response
+	Retries uint64
+	// Evicted is the number of requests dropped from the queue
+	Evicted uint64
+	// Duplicates is the number of requests rejected for their idempotency key
+	Duplicates uint64
+	// Offline is true while the sender is backing off after a failed attempt
+	Offline bool
+}
+
+// QueuedSender delivers the requests of a client through a persisted queue, so that they are not lost while the
+// server cannot be reached. The requests are attempted with the retry policy of the client; the ones that fail to
+// reach the server, or get a 408, a 429 or a server error, stay at the head of the queue and are replayed once a probe
+// after an exponential backoff succeeds. The requests with an Idempotency-Key header are delivered once.
+type QueuedSender struct {
+	client *Client
+	store  QueueStore
+	opts   QueuedOptions
+	mutex  sync.Mutex
+	// pending are the requests queued, in order. inFlight maps the ordering keys to the id of the request sent.
+	pending   []*QueuedRequest
+	inFlight  map[string]string
+	callbacks map[string]func(res *Response, err error)
+	stats     QueueStats
+	backoff   time.Duration
+	retryAt   time.Time
+	// changed is closed and replaced on every change of the queue
+	changed chan struct{}
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewQueuedSender creates a new QueuedSender delivering the requests of the store through the client. The requests
+// left in the store by a previous process are delivered first. Close the sender to stop the deliveries.
+func NewQueuedSender(client *Client, store QueueStore, opts QueuedOptions) (sender *QueuedSender, err error) {
+	if opts.Workers <= 0 {
+		opts.Workers = defaultQueueWorkers
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultQueueMaxSize
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defaultQueueMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(defaultQueueMaxBackoff, opts.MinBackoff)
+	}
+	var pending []*QueuedRequest
+	if pending, err = store.Pending(); err != nil {
+		return
+	}
+	sender = &QueuedSender{
+		client:    client,
+		store:     store,
+		opts:      opts,
+		pending:   pending,
+		inFlight:  map[string]string{},
+		callbacks: map[string]func(res *Response, err error){},
+		changed:   make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go sender.run()
+	return
+}
+
+// Enqueue captures the request and persists it in the queue. The callback, which may be nil, is called with the
+// response once the request is delivered, or with the eviction reason; it is not persisted, the requests recovered
+// by another sender report to the OnResponse of its options. ErrDuplicateRequest is returned if a request with the
+// same Idempotency-Key header is queued or was delivered.
+func (s *QueuedSender) Enqueue(req *Request, callback func(res *Response, err error)) (id string, err error) {
+	var httpReq *http.Request
+	if httpReq, err = req.toHttpRequest(); err != nil {
+		return
+	}
+	q := &QueuedRequest{
+		Method:         httpReq.Method,
+		Url:            httpReq.URL.String(),
+		Header:         httpReq.Header.Clone(),
+		IdempotencyKey: httpReq.Header.Get(rest.IdempotencyKeyHeader),
+		Enqueued:       time.Now(),
+	}
+	if httpReq.Body != nil {
+		// the body is read now, the readers of the request may not be valid later
+		q.Body, err = io.ReadAll(httpReq.Body)
+		ioutils.CloserFunc(httpReq.Body)
+		if err != nil {
+			return
+		}
+	}
+	var u *uuid.UUID
+	if u, err = uuid.V4(); err != nil {
+		return
+	}
+	q.Id = u.String()
+	if s.opts.OrderingKey != nil {
+		q.OrderingKey = s.opts.OrderingKey(q)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return "", ErrSenderClosed
+	}
+	if q.IdempotencyKey != "" {
+		duplicate := false
+		for _, p := range s.pending {
+			duplicate = duplicate || p.IdempotencyKey == q.IdempotencyKey
+		}
+		if !duplicate {
+			if duplicate, err = s.store.Delivered(q.IdempotencyKey); err != nil {
+				return
+			}
+		}
+		if duplicate {
+			s.stats.Duplicates++
+			return "", ErrDuplicateRequest
+		}
+	}
+	for len(s.pending) >= s.opts.MaxSize {
+		if !s.evictOldest() {
+			break
+		}
+	}
+	if err = s.store.Add(q); err != nil {
+		return
+	}
+	s.pending = append(s.pending, q)
+	if callback != nil {
+		s.callbacks[q.Id] = callback
+	}
+	id = q.Id
+	s.notify()
+	return
+}
+
+// Stats returns the counters of the sender
+func (s *QueuedSender) Stats() QueueStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.stats
+	stats.Depth = len(s.pending)
+	if len(s.pending) > 0 {
+		stats.OldestAge = time.Since(s.pending[0].Enqueued)
+	}
+	stats.Offline = !s.retryAt.IsZero()
+	return stats
+}
+
+// Drain waits until the queue is empty or the context is done
+func (s *QueuedSender) Drain(ctx context.Context) error {
+	for {
+		s.mutex.Lock()
+		empty, changed := len(s.pending) == 0, s.changed
+		s.mutex.Unlock()
+		if empty {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Close stops the deliveries and waits for the requests being sent. The requests left in the queue stay in the store.
+func (s *QueuedSender) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mutex.Unlock()
+	close(s.stop)
+	<-s.done
+	s.wg.Wait()
+	return nil
+}
+
+// run dispatches the requests until the sender is closed
+func (s *QueuedSender) run() {
+	defer close(s.done)
+	for {
+		var timer *time.Timer
+		var expire <-chan time.Time
+		if wait := s.dispatch(); wait > 0 {
+			timer = time.NewTimer(wait)
+			expire = timer.C
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		case <-expire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// dispatch evicts the expired requests and starts sending the head of the ordering keys that are not being sent.
+// While offline only one request probes the server. It returns the time to wait for the next dispatch, 0 to wait for
+// a change.
+func (s *QueuedSender) dispatch() (wait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if s.opts.MaxAge > 0 {
+		for i := 0; i < len(s.pending); {
+			q := s.pending[i]
+			if s.inFlight[q.OrderingKey] != q.Id && now.Sub(q.Enqueued) > s.opts.MaxAge {
+				s.evict(i, ErrQueueExpired)
+				continue
+			}
+			i++
+		}
+	}
+	if !s.retryAt.IsZero() && now.Before(s.retryAt) {
+		return s.retryAt.Sub(now)
+	}
+	workers := s.opts.Workers
+	if !s.retryAt.IsZero() {
+		workers = 1
+	}
+	seen := map[string]bool{}
+	for _, q := range s.pending {
+		if len(s.inFlight) >= workers {
+			break
+		}
+		_, sending := s.inFlight[q.OrderingKey]
+		if seen[q.OrderingKey] || sending {
+			seen[q.OrderingKey] = true
+			continue
+		}
+		seen[q.OrderingKey] = true
+		s.inFlight[q.OrderingKey] = q.Id
+		q.Attempts++
+		s.wg.Add(1)
+		go s.send(q)
+	}
+	return
+}
+
+// send delivers the request and records its outcome
+func (s *QueuedSender) send(q *QueuedRequest) {
+	defer s.wg.Done()
+	req := s.client.NewRequest(q.Url, q.Method)
+	for k, v := range q.Header {
+		req.AddHeader(k, v...)
+	}
+	if len(q.Body) > 0 {
+		req.SeBodyReader(bytes.NewReader(q.Body))
+	}
+	res, err := s.client.Execute(req)
+	if err == nil && retryableStatus(res.StatusCode()) {
+		ioutils.CloserFunc(res.raw.Body)
+		err = res.GetError()
+	}
+
+	s.mutex.Lock()
+	delete(s.inFlight, q.OrderingKey)
+	if err != nil {
+		s.stats.Retries++
+		s.backoff = min(max(2*s.backoff, s.opts.MinBackoff), s.opts.MaxBackoff)
+		s.retryAt = time.Now().Add(s.backoff)
+		s.notify()
+		s.mutex.Unlock()
+		return
+	}
+	s.backoff, s.retryAt = 0, time.Time{}
+	if res.IsSuccess() {
+		s.stats.Delivered++
+	} else {
+		s.stats.Failed++
+	}
+	// a request whose removal fails stays in the store, the next sender delivers it again with its idempotency key
+	_ = s.store.Remove(q.Id, q.IdempotencyKey)
+	s.remove(q.Id)
+	callback := s.callbacks[q.Id]
+	delete(s.callbacks, q.Id)
+	s.notify()
+	s.mutex.Unlock()
+
+	defer ioutils.CloserFunc(res.raw.Body)
+	if callback != nil {
+		callback(res, nil)
+	} else if s.opts.OnResponse != nil {
+		s.opts.OnResponse(q, res)
+	}
+}
+
+// evictOldest evicts the oldest request that is not being sent, it returns false if there is none
+func (s *QueuedSender) evictOldest() bool {
+	for i, q := range s.pending {
+		if s.inFlight[q.OrderingKey] != q.Id {
+			s.evict(i, ErrQueueFull)
+			return true
+		}
+	}
+	return false
+}
+
+// evict drops the request at the index from the queue and reports it
+func (s *QueuedSender) evict(i int, reason error) {
+	q := s.pending[i]
+	_ = s.store.Remove(q.Id, "")
+	s.remove(q.Id)
+	s.stats.Evicted++
+	callback := s.callbacks[q.Id]
+	delete(s.callbacks, q.Id)
+	if callback != nil {
+		go callback(nil, reason)
+	}
+	if s.opts.OnEvict != nil {
+		go s.opts.OnEvict(q, reason)
+	}
+	s.notify()
+}
+
+// remove removes the request from the pending ones
+func (s *QueuedSender) remove(id string) {
+	for i, q := range s.pending {
+		if q.Id == id {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify wakes the dispatcher and the callers of Drain
+func (s *QueuedSender) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retryableStatus checks if the status tells that the request can be delivered later
+func retryableStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
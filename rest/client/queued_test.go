@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/rest"
+)
+
+// queueServer is a httptest server recording the bodies it receives while it is up, responding 503 otherwise
+type queueServer struct {
+	*httptest.Server
+	up     atomic.Bool
+	mutex  sync.Mutex
+	bodies map[string][]string
+}
+
+func newQueueServer(t *testing.T, up bool) *queueServer {
+	qs := &queueServer{bodies: map[string][]string{}}
+	qs.up.Store(up)
+	qs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !qs.up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		qs.mutex.Lock()
+		qs.bodies[r.Header.Get("X-Device")] = append(qs.bodies[r.Header.Get("X-Device")], string(body))
+		qs.mutex.Unlock()
+	}))
+	t.Cleanup(qs.Close)
+	return qs
+}
+
+func (qs *queueServer) received(device string) []string {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	return append([]string(nil), qs.bodies[device]...)
+}
+
+func newTestQueueStore(t *testing.T, path string) *FileQueueStore {
+	store, err := NewFileQueueStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileQueueStore() error = %v", err)
+	}
+	return store
+}
+
+func newTestSender(t *testing.T, store QueueStore, opts QueuedOptions) *QueuedSender {
+	opts.MinBackoff, opts.MaxBackoff = 10*time.Millisecond, 40*time.Millisecond
+	sender, err := NewQueuedSender(NewClient(), store, opts)
+	if err != nil {
+		t.Fatalf("NewQueuedSender() error = %v", err)
+	}
+	t.Cleanup(func() { _ = sender.Close() })
+	return sender
+}
+
+func enqueue(t *testing.T, sender *QueuedSender, url, device, body string) string {
+	req := NewClient().NewRequest(url, http.MethodPost).AddHeader("X-Device", device)
+	id, err := sender.Enqueue(req.SeBodyReader(stringsReader(body)), nil)
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	return id
+}
+
+// stringsReader returns a reader of the string that cannot be read again, like the body of an incoming request
+func stringsReader(s string) io.Reader {
+	return io.MultiReader(strings.NewReader(s))
+}
+
+func drain(t *testing.T, sender *QueuedSender) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sender.Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v with %+v", err, sender.Stats())
+	}
+}
+
+func TestQueuedSender_Availability(t *testing.T) {
+	server := newQueueServer(t, false)
+	sender := newTestSender(t, newTestQueueStore(t, filepath.Join(t.TempDir(), "queue.ndjson")), QueuedOptions{})
+	for _, body := range []string{"a", "b", "c"} {
+		enqueue(t, sender, server.URL, "d1", body)
+	}
+	time.Sleep(100 * time.Millisecond)
+	stats := sender.Stats()
+	if stats.Depth != 3 || stats.Retries == 0 || !stats.Offline || stats.OldestAge < 100*time.Millisecond {
+		t.Errorf("got %+v while the server is down", stats)
+	}
+
+	server.up.Store(true)
+	drain(t, sender)
+	if got := server.received("d1"); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("server received %v", got)
+	}
+	if stats = sender.Stats(); stats.Delivered != 3 || stats.Depth != 0 || stats.Offline {
+		t.Errorf("got %+v once the server is up", stats)
+	}
+}
+
+func TestQueuedSender_Recovery(t *testing.T) {
+	server := newQueueServer(t, false)
+	path := filepath.Join(t.TempDir(), "queue.ndjson")
+	store := newTestQueueStore(t, path)
+	sender := newTestSender(t, store, QueuedOptions{})
+	type event struct {
+		Name string `json:"name"`
+	}
+	for _, name := range []string{"boot", "alarm"} {
+		req := NewClient().NewRequest(server.URL, http.MethodPost).SetBody(&event{Name: name})
+		if _, err := sender.Enqueue(req.SetContentType(rest.JSONContentType).AddHeader("X-Device", "d1"),
+			nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	// the process stops while the server is down
+	_ = sender.Close()
+	_ = store.Close()
+
+	server.up.Store(true)
+	var responses atomic.Int32
+	store = newTestQueueStore(t, path)
+	defer store.Close()
+	sender = newTestSender(t, store, QueuedOptions{OnResponse: func(q *QueuedRequest, res *Response) {
+		if res.StatusCode() == http.StatusOK {
+			responses.Add(1)
+		}
+	}})
+	if stats := sender.Stats(); stats.Depth != 2 {
+		t.Fatalf("recovered %d requests, want 2", stats.Depth)
+	}
+	drain(t, sender)
+	want := []string{`{"name":"boot"}`, `{"name":"alarm"}`}
+	got := server.received("d1")
+	for i := range got {
+		got[i] = strings.TrimSpace(got[i])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("server received %q, want %q", got, want)
+	}
+	_ = sender.Close()
+	if responses.Load() != 2 {
+		t.Errorf("got %d responses, want the recovered requests reported", responses.Load())
+	}
+}
+
+func TestQueuedSender_OrderPerKey(t *testing.T) {
+	server := newQueueServer(t, true)
+	sender := newTestSender(t, newTestQueueStore(t, filepath.Join(t.TempDir(), "queue.ndjson")),
+		QueuedOptions{OrderingKey: func(q *QueuedRequest) string { return q.Header.Get("X-Device") }})
+	devices := []string{"d1", "d2", "d3"}
+	for i := 0; i < 20; i++ {
+		for _, device := range devices {
+			enqueue(t, sender, server.URL, device, string(rune('a'+i)))
+		}
+	}
+	drain(t, sender)
+	for _, device := range devices {
+		got := server.received(device)
+		for i := range got {
+			if got[i] != string(rune('a'+i)) {
+				t.Errorf("device %s received %v out of order", device, got)
+				break
+			}
+		}
+		if len(got) != 20 {
+			t.Errorf("device %s received %d requests, want 20", device, len(got))
+		}
+	}
+}
+
+func TestQueuedSender_Eviction(t *testing.T) {
+	sending, release := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sending <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer server.Close()
+	var mutex sync.Mutex
+	var evicted []string
+	sender := newTestSender(t, newTestQueueStore(t, filepath.Join(t.TempDir(), "queue.ndjson")), QueuedOptions{
+		MaxSize: 3,
+		OnEvict: func(q *QueuedRequest, reason error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if errors.Is(reason, ErrQueueFull) {
+				evicted = append(evicted, string(q.Body))
+			}
+		},
+	})
+	enqueue(t, sender, server.URL, "d1", "1")
+	<-sending
+	for _, body := range []string{"2", "3", "4", "5", "6"} {
+		enqueue(t, sender, server.URL, "d1", body)
+	}
+	var bodies []string
+	sender.mutex.Lock()
+	for _, q := range sender.pending {
+		bodies = append(bodies, string(q.Body))
+	}
+	sender.mutex.Unlock()
+	close(release)
+	drain(t, sender)
+
+	// the request being sent is never evicted
+	if !reflect.DeepEqual(bodies, []string{"1", "5", "6"}) {
+		t.Errorf("queued %v, want the oldest requests evicted", bodies)
+	}
+	time.Sleep(20 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(evicted) != 3 || sender.Stats().Evicted != 3 {
+		t.Errorf("evicted %v", evicted)
+	}
+}
+
+func TestQueuedSender_Dedup(t *testing.T) {
+	server := newQueueServer(t, true)
+	path := filepath.Join(t.TempDir(), "queue.ndjson")
+	store := newTestQueueStore(t, path)
+	sender := newTestSender(t, store, QueuedOptions{})
+	send := func(s *QueuedSender, key string) error {
+		req := NewClient().NewRequest(server.URL, http.MethodPut).AddHeader(rest.IdempotencyKeyHeader, key)
+		_, err := s.Enqueue(req.AddHeader("X-Device", "d1").SeBodyReader(stringsReader(key)), nil)
+		return err
+	}
+	if err := send(sender, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	drain(t, sender)
+	_ = sender.Close()
+	_ = store.Close()
+
+	// the application replays its submissions after a restart
+	store = newTestQueueStore(t, path)
+	defer store.Close()
+	sender = newTestSender(t, store, QueuedOptions{})
+	if err := send(sender, "k1"); !errors.Is(err, ErrDuplicateRequest) {
+		t.Errorf("Enqueue() error = %v, want the delivered request rejected", err)
+	}
+	if err := send(sender, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	drain(t, sender)
+	if got := server.received("d1"); !reflect.DeepEqual(got, []string{"k1", "k2"}) {
+		t.Errorf("server received %v", got)
+	}
+	if stats := sender.Stats(); stats.Duplicates != 1 || stats.Delivered != 1 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestFileQueueStore_TruncatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.ndjson")
+	store := newTestQueueStore(t, path)
+	for _, id := range []string{"1", "2", "3"} {
+		if err := store.Add(&QueuedRequest{Id: id, Method: http.MethodPost, Url: "http://localhost"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.Remove("1", "k1")
+	_ = store.Close()
+	// the process crashed while writing a line
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	_, _ = f.WriteString(`{"op":"remove","id":"2"`)
+	_ = f.Close()
+
+	store = newTestQueueStore(t, path)
+	defer store.Close()
+	pending, _ := store.Pending()
+	if len(pending) != 2 || pending[0].Id != "2" || pending[1].Id != "3" {
+		t.Errorf("recovered %v, want 2 and 3", pending)
+	}
+	if delivered, _ := store.Delivered("k1"); !delivered {
+		t.Error("the delivered key was not recovered")
+	}
+}
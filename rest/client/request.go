@@ -37,6 +37,7 @@ type Request struct {
 	contentType    string
 	client         *Client
 	multiPartFiles []*MultipartFile
+	protocolPolicy *ProtocolPolicy
 }
 
 type MultipartFile struct {
@@ -124,6 +125,12 @@ func (r *Request) SetContentType(contentType string) *Request {
 	return r
 }
 
+// SetProtocolPolicy overrides the protocol policy of the client for this Request
+func (r *Request) SetProtocolPolicy(policy ProtocolPolicy) *Request {
+	r.protocolPolicy = &policy
+	return r
+}
+
 func (r *Request) SetMultipartFiles(files ...*MultipartFile) *Request {
 	if r.multiPartFiles == nil {
 		r.multiPartFiles = make([]*MultipartFile, 0)
@@ -202,12 +209,12 @@ func (r *Request) toHttpRequest() (httpReq *http.Request, err error) {
 			if r.bodyReader == nil && r.body != nil {
 				pr, pw := io.Pipe()
 				go func() {
-					defer ioutils.CloserFunc(pw)
-					var c codec.Codec
-					c, err = codec.Get(r.contentType, r.client.codecOptions)
-					if err == nil {
-						err = c.Write(r.body, pw)
+					// the encoding error is returned to the reader of the body
+					c, encErr := codec.Get(r.contentType, r.client.codecOptions)
+					if encErr == nil {
+						encErr = c.Write(r.body, pw)
 					}
+					_ = pw.CloseWithError(encErr)
 				}()
 				r.bodyReader = pr
 			}
@@ -55,6 +55,11 @@ func (r *Response) StatusCode() int {
 	return r.Raw().StatusCode
 }
 
+// Proto provides the protocol of the response, e.g. HTTP/1.1 or HTTP/2.0
+func (r *Response) Proto() string {
+	return r.Raw().Proto
+}
+
 // Raw Provides the backend raw response
 func (r *Response) Raw() *http.Response {
 	return r.raw
@@ -1,14 +1,17 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"errors"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"oss.nandlabs.io/golly/clients"
@@ -30,6 +33,8 @@ const (
 type Client struct {
 	retryInfo      *clients.RetryInfo
 	circuitBreaker *clients.CircuitBreaker
+	// resilience set by UseResilience replaces the circuit breaker and the retry configuration
+	resilience     *clients.Resilience
 	errorOnMap     map[int]int
 	proxyBasicAuth string
 	httpClient     http.Client
@@ -37,6 +42,18 @@ type Client struct {
 	tlsConfig      *tls.Config
 	codecOptions   map[string]interface{}
 	baseUrl        *url.URL
+	protocolPolicy ProtocolPolicy
+	// protocolTransports are the transports of the protocol policies cloned from protocolBase
+	protocolTransports map[ProtocolPolicy]*http.Transport
+	protocolBase       *http.Transport
+	// limiterOpts are the options of the adaptive limiters created per host in limiters
+	limiterOpts *clients.AdaptiveOptions
+	limiters    map[string]*clients.AdaptiveLimiter
+	mutex       sync.Mutex
+	// signing are the options of the request signatures set by SignRequests
+	signing *SigningOptions
+	// auth adds the credentials to the requests, set by UseAuth
+	auth clients.Auth
 }
 
 // NewClient creates a new REST client with default values.
@@ -46,6 +63,7 @@ func NewClient() *Client {
 		IdleConnTimeout:       defaultIdleConnTimeout,
 		ExpectContinueTimeout: defaultExpectContinueTimeout,
 		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ForceAttemptHTTP2:     true,
 	}
 	httpClient := http.Client{
 		Transport: transport,
@@ -121,13 +139,14 @@ func (c *Client) MaxIdlePerHost(maxIdleConnPerHost int) *Client {
 	return c
 }
 
-// SSlVerify sets the SSL verify value.
+// SSlVerify enables or disables the verification of the certificates of the servers.
 func (c *Client) SSlVerify(verify bool) (*Client, error) {
 	conf, err := c.setTlSConfig()
 	if err != nil {
 		return nil, err
 	}
-	conf.InsecureSkipVerify = verify
+	conf.InsecureSkipVerify = !verify
+	c.setSSL(conf)
 	return c, nil
 }
 
@@ -180,10 +199,7 @@ func (c *Client) SetTLSCerts(certs ...tls.Certificate) (*Client, error) {
 func (c *Client) setSSL(conf *tls.Config) {
 	// Load client cert
 	c.tlsConfig = conf
-	transport := &http.Transport{
-		TLSClientConfig: conf,
-	}
-	c.httpTransport = transport
+	c.httpTransport.TLSClientConfig = conf
 }
 
 // UseEnvProxy ensures that the proxy settings are loaded using environment parameters.
@@ -221,6 +237,40 @@ func (c *Client) UseCircuitBreaker(failureThreshold, successThreshold uint64, ma
 	return c
 }
 
+// UseAuth adds the credentials of the auth, such as clients.NewAPIKeyAuth or clients.NewSigV4Auth, to each attempt of
+// the requests before it is signed and sent.
+func (c *Client) UseAuth(auth clients.Auth) *Client {
+	c.auth = auth
+	return c
+}
+
+// UseResilience sends the requests through the circuit breaker, the bulkhead and the retry policy of the resilience.
+// It has higher precedence than UseCircuitBreaker and Retry. The failed responses are closed before they are retried.
+func (c *Client) UseResilience(resilience *clients.Resilience) *Client {
+	c.resilience = resilience
+	return c
+}
+
+// UseAdaptiveLimiter limits the concurrent requests to each host with an adaptive concurrency limiter created with
+// the options. The limit shrinks on timeouts, HTTP 429 and 503 responses and slow responses, and grows back as the host
+// recovers. The limiter works alongside the circuit breaker: the breaker handles the hard failures, the limiter
+// handles the degradation.
+func (c *Client) UseAdaptiveLimiter(opts clients.AdaptiveOptions) *Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.limiterOpts = &opts
+	c.limiters = make(map[string]*clients.AdaptiveLimiter)
+	return c
+}
+
+// AdaptiveLimiter returns the adaptive limiter of the host for its metrics.
+// It returns nil if UseAdaptiveLimiter is not set or no request is made to the host yet.
+func (c *Client) AdaptiveLimiter(host string) *clients.AdaptiveLimiter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.limiters[host]
+}
+
 // NewRequest creates a new request object for the client.
 func (c *Client) NewRequest(reqUrl, method string) *Request {
 	finalUrl := reqUrl
@@ -248,27 +298,33 @@ func (c *Client) Execute(req *Request) (res *Response, err error) {
 	if c.proxyBasicAuth != "" {
 		httpReq.Header.Set(proxyAuthHdr, c.proxyBasicAuth)
 	}
+	policy := c.protocolPolicy
+	if req.protocolPolicy != nil {
+		policy = *req.protocolPolicy
+	}
 	if err == nil {
-		if c.circuitBreaker != nil {
+		if c.resilience != nil {
+			httpRes, err = c.doResilient(httpReq, policy)
+		} else if c.circuitBreaker != nil {
 			// Use Circuit Breaker
 			err = c.circuitBreaker.CanExecute()
 			if err == nil {
-				httpRes, err = c.httpClient.Do(httpReq)
-				c.circuitBreaker.OnExecution(c.isError(err, httpRes))
+				httpRes, err = c.doLimited(httpReq, policy)
+				c.circuitBreaker.OnExecution(!c.isError(err, httpRes))
 			}
 		} else if c.retryInfo != nil {
-			httpRes, err = c.httpClient.Do(httpReq)
+			httpRes, err = c.doLimited(httpReq, policy)
 
-			for i := 0; c.isError(err, httpRes) && i < c.retryInfo.MaxRetries; i++ {
+			for i := 0; c.isError(err, httpRes) && c.canRetry(err) && i < c.retryInfo.MaxRetries; i++ {
 				err = fnutils.ExecuteAfterSecs(func() {
-					httpRes, err = c.httpClient.Do(httpReq)
+					httpRes, err = c.doLimited(httpReq, policy)
 				}, c.retryInfo.Wait)
 				if err != nil {
 					return
 				}
 			}
 		} else {
-			httpRes, err = c.httpClient.Do(httpReq)
+			httpRes, err = c.doLimited(httpReq, policy)
 		}
 		if err == nil {
 			res = &Response{raw: httpRes, client: c}
@@ -277,6 +333,100 @@ func (c *Client) Execute(req *Request) (res *Response, err error) {
 	return
 }
 
+// doResilient sends the request with the resilience, resetting the body of the request before each retry
+func (c *Client) doResilient(httpReq *http.Request, policy ProtocolPolicy) (httpRes *http.Response, err error) {
+	if err = bufferBody(httpReq); err != nil {
+		return
+	}
+	attempts := 0
+	_, err = c.resilience.Execute(httpReq.Context(), func() (statusCode int, attemptErr error) {
+		if attempts++; attempts > 1 {
+			if httpRes != nil {
+				_ = httpRes.Body.Close()
+				httpRes = nil
+			}
+			if httpReq.GetBody != nil {
+				if httpReq.Body, attemptErr = httpReq.GetBody(); attemptErr != nil {
+					return
+				}
+			}
+		}
+		if httpRes, attemptErr = c.doLimited(httpReq, policy); attemptErr == nil {
+			statusCode = httpRes.StatusCode
+		}
+		return
+	})
+	if err != nil && httpRes != nil {
+		_ = httpRes.Body.Close()
+		httpRes = nil
+	}
+	return
+}
+
+// doLimited applies the auth if UseAuth is set, signs the request if SignRequests is set and sends it within the
+// adaptive limit of the host if UseAdaptiveLimiter is set.
+func (c *Client) doLimited(httpReq *http.Request, policy ProtocolPolicy) (httpRes *http.Response, err error) {
+	if c.auth != nil {
+		if err = c.auth.Apply(httpReq); err != nil {
+			return
+		}
+	}
+	if c.signing != nil {
+		if err = c.signing.sign(httpReq); err != nil {
+			return
+		}
+	}
+	limiter := c.limiterFor(httpReq.URL.Host)
+	if limiter == nil {
+		return c.do(httpReq, policy)
+	}
+	var release func(outcome clients.Outcome)
+	if release, err = limiter.Acquire(httpReq.Context()); err != nil {
+		return
+	}
+	httpRes, err = c.do(httpReq, policy)
+	release(limiterOutcome(httpReq, httpRes, err))
+	return
+}
+
+// limiterFor returns the adaptive limiter of the host creating it if required.
+func (c *Client) limiterFor(host string) (limiter *clients.AdaptiveLimiter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.limiterOpts == nil {
+		return
+	}
+	limiter = c.limiters[host]
+	if limiter == nil {
+		limiter = clients.NewAdaptiveLimiter(*c.limiterOpts)
+		c.limiters[host] = limiter
+	}
+	return
+}
+
+// limiterOutcome classifies the result of the request for the adaptive limiter.
+func limiterOutcome(httpReq *http.Request, httpRes *http.Response, err error) clients.Outcome {
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return clients.OutcomeTimeout
+		}
+		if httpReq.Context().Err() != nil {
+			return clients.OutcomeIgnore
+		}
+		return clients.OutcomeFailure
+	}
+	if httpRes.StatusCode == http.StatusTooManyRequests || httpRes.StatusCode == http.StatusServiceUnavailable {
+		return clients.OutcomeOverload
+	}
+	return clients.OutcomeSuccess
+}
+
+// canRetry checks if the request can be retried after the error. HTTP/2 errors are retried only when retry safe.
+func (c *Client) canRetry(err error) bool {
+	return !isHTTP2Error(err) || IsRetrySafe(err)
+}
+
 // isError checks if the response is an error response or an error has been received.
 func (c *Client) isError(err error, httpRes *http.Response) (isErr bool) {
 	isErr = err != nil
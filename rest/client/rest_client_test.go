@@ -2,11 +2,17 @@ package client
 
 import (
 	"crypto/tls"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 
+	"oss.nandlabs.io/golly/clients"
 	"oss.nandlabs.io/golly/codec"
+	"oss.nandlabs.io/golly/rest"
 	"oss.nandlabs.io/golly/testing/assert"
 )
 
@@ -143,7 +149,7 @@ func TestClient_SSlVerify(t *testing.T) {
 	if err != nil {
 		t.Errorf("Got: %s, want: %s", err.Error(), want)
 	}
-	if client.tlsConfig.InsecureSkipVerify != true {
+	if client.tlsConfig.InsecureSkipVerify {
 		t.Error("client SSL setup incorrect")
 	}
 }
@@ -175,3 +181,77 @@ func TestClient_Execute(t *testing.T) {
 		})
 	}
 }
+
+// TestClient_AdaptiveLimiter tests that the limit of the host shrinks on overload responses and grows on success
+func TestClient_AdaptiveLimiter(t *testing.T) {
+	overloaded := &atomic.Bool{}
+	overloaded.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if overloaded.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	c := NewClient().UseAdaptiveLimiter(clients.AdaptiveOptions{InitialLimit: 10, BackoffRatio: 0.5})
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.True(t, c.AdaptiveLimiter(host) == nil)
+
+	for i := 0; i < 3; i++ {
+		res, err := c.Execute(c.NewRequest(server.URL, http.MethodGet))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode())
+	}
+	limiter := c.AdaptiveLimiter(host)
+	assert.NotNil(t, limiter)
+	assert.Equal(t, 1, limiter.Limit())
+	assert.Equal(t, uint64(3), limiter.Metrics().Overloads)
+
+	overloaded.Store(false)
+	for i := 0; i < 10; i++ {
+		_, err := c.Execute(c.NewRequest(server.URL, http.MethodGet))
+		assert.NoError(t, err)
+	}
+	assert.True(t, limiter.Limit() > 1)
+	assert.Equal(t, 0, limiter.InFlight())
+}
+
+func TestClient_UseResilience(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		assert.Equal(t, `{"a":1}`, strings.TrimSpace(string(b)))
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	cb := clients.NewCB(&clients.BreakerInfo{FailureThreshold: 5})
+	bulkhead := clients.NewBulkhead(2, 0, 0)
+	c := NewClient().UseResilience(clients.NewResilience(cb, bulkhead,
+		&clients.RetryPolicy{RetryInfo: clients.RetryInfo{MaxRetries: 3, Wait: 1}}))
+	req := c.NewRequest(server.URL, http.MethodPost).SetContentType(rest.JSONContentType).
+		SetBody(map[string]int{"a": 1})
+	res, err := c.Execute(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.Equal(t, int32(3), attempts.Load())
+	assert.Equal(t, uint64(2), cb.Counts().Failures)
+	assert.Equal(t, 0, bulkhead.InFlight())
+}
+
+func TestClient_UseAuth(t *testing.T) {
+	var authorization, key string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, key = r.Header.Get("Authorization"), r.URL.Query().Get("api_key")
+	}))
+	defer server.Close()
+	c := NewClient().UseAuth(clients.NewAPIKeyAuth("api_key", "k1", clients.APIKeyQuery))
+	_, err := c.Execute(c.NewRequest(server.URL+"/items?page=1", http.MethodGet))
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", key)
+
+	c = NewClient().UseAuth(clients.NewSigV4Auth("AKID", "secret", "us-east-1", "s3"))
+	_, err = c.Execute(c.NewRequest(server.URL+"/bucket/key", http.MethodGet))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKID/"))
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oss.nandlabs.io/golly/rest"
+)
+
+// SigningOptions configures the HMAC signing of the requests of the client. See the scheme in the rest package.
+type SigningOptions struct {
+	// KeyId is the id of the key sent to the server to resolve the key
+	KeyId string
+	// Key is the shared secret of the HMAC
+	Key []byte
+	// Headers are the names of the request headers signed along with the request, e.g. Content-Type
+	Headers []string
+}
+
+// signingNow returns the time of the signatures, replaced by the tests
+var signingNow = time.Now
+
+// signingNonce returns a random nonce, replaced by the tests
+var signingNonce = func() (nonce string, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err == nil {
+		nonce = hex.EncodeToString(b)
+	}
+	return
+}
+
+// SignRequests signs each request of the client with an HMAC-SHA256 of its method, path, query, selected headers,
+// body hash, timestamp and nonce. The body is buffered to hash it unless the request sets the X-Content-Sha256
+// header, which is then trusted as the hash of a streamed body. The retries are signed again with a new nonce.
+func (c *Client) SignRequests(opts SigningOptions) *Client {
+	c.signing = &opts
+	return c
+}
+
+// sign sets the signature headers of the request
+func (s *SigningOptions) sign(httpReq *http.Request) (err error) {
+	bodySha256 := httpReq.Header.Get(rest.ContentSha256Header)
+	if bodySha256 == "" {
+		if bodySha256, err = hashBody(httpReq); err != nil {
+			return
+		}
+	}
+	var nonce string
+	if nonce, err = signingNonce(); err != nil {
+		return
+	}
+	httpReq.Header.Set(rest.SignatureKeyIdHeader, s.KeyId)
+	httpReq.Header.Set(rest.SignatureTimestampHeader, strconv.FormatInt(signingNow().Unix(), 10))
+	httpReq.Header.Set(rest.SignatureNonceHeader, nonce)
+	httpReq.Header.Set(rest.SignatureHeadersHeader, rest.SignedHeaderNames(s.Headers))
+	canonical := rest.CanonicalString(httpReq.Method, httpReq.URL, httpReq.Header, bodySha256)
+	httpReq.Header.Set(rest.SignatureHeader, rest.SignCanonical(s.Key, canonical))
+	return
+}
+
+// bufferBody reads the body of the request into memory unless it can be read again with GetBody, so that it can be
+// sent again by the retries.
+func bufferBody(httpReq *http.Request) (err error) {
+	if httpReq.Body == nil || httpReq.Body == http.NoBody || httpReq.GetBody != nil {
+		return
+	}
+	var data []byte
+	data, err = io.ReadAll(httpReq.Body)
+	_ = httpReq.Body.Close()
+	if err != nil {
+		return
+	}
+	httpReq.ContentLength = int64(len(data))
+	httpReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	httpReq.Body, err = httpReq.GetBody()
+	return
+}
+
+// hashBody returns the hash of the body of the request. The body is buffered so that it can be read again by the
+// retries.
+func hashBody(httpReq *http.Request) (bodySha256 string, err error) {
+	if httpReq.Body == nil || httpReq.Body == http.NoBody {
+		return rest.EmptyBodySha256, nil
+	}
+	if err = bufferBody(httpReq); err != nil {
+		return
+	}
+	var body io.ReadCloser
+	if body, err = httpReq.GetBody(); err != nil {
+		return
+	}
+	data, err := io.ReadAll(body)
+	_ = body.Close()
+	if err == nil {
+		bodySha256 = rest.BodySha256(data)
+		httpReq.Body, err = httpReq.GetBody()
+	}
+	return
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/rest"
+)
+
+// signingVector is a signed request of rest/testdata/signing_vectors.json, shared with the rest and server tests
+type signingVector struct {
+	Name      string      `json:"name"`
+	Method    string      `json:"method"`
+	Url       string      `json:"url"`
+	Headers   http.Header `json:"headers"`
+	Body      string      `json:"body"`
+	Key       string      `json:"key"`
+	Signature string      `json:"signature"`
+}
+
+func TestSignRequests_Vectors(t *testing.T) {
+	data, err := os.ReadFile("../testdata/signing_vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []signingVector
+	if err = json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	defer func(now func() time.Time, nonce func() (string, error)) {
+		signingNow, signingNonce = now, nonce
+	}(signingNow, signingNonce)
+
+	for _, v := range vectors {
+		var received http.Header
+		var body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header
+			b, _ := io.ReadAll(r.Body)
+			body = string(b)
+		}))
+		timestamp, _ := strconv.ParseInt(v.Headers.Get(rest.SignatureTimestampHeader), 10, 64)
+		signingNow = func() time.Time { return time.Unix(timestamp, 0) }
+		signingNonce = func() (string, error) { return v.Headers.Get(rest.SignatureNonceHeader), nil }
+
+		signed := v.Headers.Get(rest.SignatureHeadersHeader)
+		var headers []string
+		if signed != "" {
+			headers = strings.Split(signed, ";")
+		}
+		c := NewClient().SignRequests(SigningOptions{
+			KeyId:   v.Headers.Get(rest.SignatureKeyIdHeader),
+			Key:     []byte(v.Key),
+			Headers: headers,
+		})
+		u, _ := url.Parse(v.Url)
+		req := c.NewRequest(server.URL+u.RequestURI(), v.Method)
+		for _, name := range headers {
+			req.AddHeader(name, v.Headers.Values(name)...)
+		}
+		if hash := v.Headers.Get(rest.ContentSha256Header); hash != "" {
+			req.AddHeader(rest.ContentSha256Header, hash)
+		}
+		if v.Body != "" {
+			// a reader without a length as a streamed body
+			req.SeBodyReader(io.MultiReader(strings.NewReader(v.Body)))
+		}
+		if _, err = c.Execute(req); err != nil {
+			t.Fatalf("%s: Execute() error = %v", v.Name, err)
+		}
+		server.Close()
+		if got := received.Get(rest.SignatureHeader); got != v.Signature {
+			t.Errorf("%s: signature = %s, want %s", v.Name, got, v.Signature)
+		}
+		if received.Get(rest.SignatureKeyIdHeader) != v.Headers.Get(rest.SignatureKeyIdHeader) || body != v.Body {
+			t.Errorf("%s: got the headers %v and the body %q", v.Name, received, body)
+		}
+	}
+}
+
+func TestSignRequests_Retry(t *testing.T) {
+	var nonces []string
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, r.Header.Get(rest.SignatureNonceHeader))
+		b, _ := io.ReadAll(r.Body)
+		if strings.TrimSpace(string(b)) != `{"a":1}` || r.Header.Get(rest.SignatureHeader) == "" {
+			t.Errorf("attempt %d: got the body %q and the headers %v", attempts.Load(), b, r.Header)
+		}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	c := NewClient().SignRequests(SigningOptions{KeyId: "k", Key: []byte("s")}).Retry(1, 0).
+		ErrorOnHttpStatus(http.StatusServiceUnavailable)
+	req := c.NewRequest(server.URL, http.MethodPost).SetContentType(rest.JSONContentType).
+		SetBody(map[string]int{"a": 1})
+	res, err := c.Execute(req)
+	if err != nil || res.StatusCode() != http.StatusOK {
+		t.Fatalf("Execute() = %v, %v", res, err)
+	}
+	if len(nonces) != 2 || nonces[0] == nonces[1] {
+		t.Errorf("nonces = %q, want a new nonce for the retry", nonces)
+	}
+}
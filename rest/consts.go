@@ -22,6 +22,8 @@ const (
 	AcceptEncodingHeader = "Accept-Encoding"
 	// AcceptLanguageHeader
 	AcceptLanguageHeader = "Accept-Language"
+	// IdempotencyKeyHeader is the header of the key identifying the retries of a request
+	IdempotencyKeyHeader = "Idempotency-Key"
 
 	// PathSeparator
 	PathSeparator = "/"
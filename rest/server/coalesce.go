@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/rest"
+)
+
+const (
+	defaultCoalesceMaxWaiters  = 100
+	defaultCoalesceMaxPark     = 10 * time.Second
+	defaultCoalesceMaxBodySize = 1024 * 1024
+)
+
+// defaultReplayExcludedHeaders are the headers of the shared response that are never replayed to the waiters
+var defaultReplayExcludedHeaders = []string{"Date"}
+
+// CoalesceOptions configures CoalesceMiddleware
+type CoalesceOptions struct {
+	// Paths are the path.Match patterns of the GET and HEAD requests coalesced, all of them are coalesced if empty
+	Paths []string
+	// KeyHeaders are the request headers that are part of the key along with the method, the path and the query,
+	// such as Accept or Accept-Language
+	KeyHeaders []string
+	// ReplayExcludedHeaders are the response headers not replayed to the waiters in addition to the Date
+	ReplayExcludedHeaders []string
+	// MaxWaiters is the number of requests parked on a request in flight, the others are served on their own.
+	// Defaults to 100.
+	MaxWaiters int
+	// MaxPark is the longest time a request is parked, after which it is served on its own. Defaults to 10 seconds.
+	MaxPark time.Duration
+	// MaxBodySize is the largest response replayed, the waiters of a larger response are served on their own.
+	// Defaults to 1MiB.
+	MaxBodySize int64
+}
+
+// CoalesceStats are the counters of a Coalescer
+type CoalesceStats struct {
+	// Executed is the number of requests served by the handler while others could wait for them
+	Executed uint64 `json:"executed"`
+	// Coalesced is the number of requests served with the response of a request in flight
+	Coalesced uint64 `json:"coalesced"`
+	// Bypassed is the number of matching requests not coalesced as they carry credentials
+	Bypassed uint64 `json:"bypassed"`
+	// Unshared is the number of parked requests served on their own as the response could not be shared
+	Unshared uint64 `json:"unshared"`
+	// Overflowed is the number of requests served on their own as MaxWaiters requests were already parked
+	Overflowed uint64 `json:"overflowed"`
+	// TimedOut is the number of parked requests served on their own after MaxPark
+	TimedOut uint64 `json:"timedOut"`
+	// WaitTime is the total time the requests were parked
+	WaitTime time.Duration `json:"waitTime"`
+	// MaxWait is the longest time a request was parked
+	MaxWait time.Duration `json:"maxWait"`
+}
+
+// Coalescer serves the identical GET and HEAD requests arriving while one of them is in flight with its response,
+// so that an expensive endpoint computes the response once. Create it with CoalesceMiddleware.
+type Coalescer struct {
+	opts       CoalesceOptions
+	keyHeaders []string
+	exclude    map[string]bool
+	mutex      sync.Mutex
+	calls      map[string]*coalescedCall
+
+	executed, coalesced, bypassed, unshared, overflowed, timedOut atomic.Uint64
+	waitTime, maxWait                                             atomic.Int64
+}
+
+// coalescedCall is a request in flight and the response shared with its waiters
+type coalescedCall struct {
+	done    chan struct{}
+	waiters int
+	// the response, set before done is closed
+	shared bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+// CoalesceMiddleware creates a Coalescer. Add its Filter to the router, after the authentication filters.
+//
+// The key of a request is its method, path, sorted query and KeyHeaders. A request whose key is in flight is parked
+// until the first one completes, then gets its status, headers and body. The requests with an Authorization or a
+// Cookie header are never coalesced as their responses may vary per caller. The responses setting a cookie, marked
+// private or no-store, or larger than MaxBodySize are never shared; their waiters are served on their own.
+func CoalesceMiddleware(opts CoalesceOptions) *Coalescer {
+	if opts.MaxWaiters <= 0 {
+		opts.MaxWaiters = defaultCoalesceMaxWaiters
+	}
+	if opts.MaxPark <= 0 {
+		opts.MaxPark = defaultCoalesceMaxPark
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaultCoalesceMaxBodySize
+	}
+	c := &Coalescer{opts: opts, exclude: make(map[string]bool), calls: make(map[string]*coalescedCall)}
+	for _, h := range opts.KeyHeaders {
+		c.keyHeaders = append(c.keyHeaders, http.CanonicalHeaderKey(h))
+	}
+	sort.Strings(c.keyHeaders)
+	for _, h := range append(append([]string{}, defaultReplayExcludedHeaders...), opts.ReplayExcludedHeaders...) {
+		c.exclude[http.CanonicalHeaderKey(h)] = true
+	}
+	return c
+}
+
+// Filter coalesces the matching requests
+func (c *Coalescer) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.matches(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+			c.bypassed.Add(1)
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := c.key(r)
+		c.mutex.Lock()
+		call, inFlight := c.calls[key]
+		if !inFlight {
+			call = &coalescedCall{done: make(chan struct{})}
+			c.calls[key] = call
+			c.mutex.Unlock()
+			c.execute(key, call, next, w, r)
+			return
+		}
+		if call.waiters >= c.opts.MaxWaiters {
+			c.mutex.Unlock()
+			c.overflowed.Add(1)
+			next.ServeHTTP(w, r)
+			return
+		}
+		call.waiters++
+		c.mutex.Unlock()
+		c.wait(call, next, w, r)
+	})
+}
+
+// Stats returns the counters of the coalescer
+func (c *Coalescer) Stats() CoalesceStats {
+	return CoalesceStats{
+		Executed:   c.executed.Load(),
+		Coalesced:  c.coalesced.Load(),
+		Bypassed:   c.bypassed.Load(),
+		Unshared:   c.unshared.Load(),
+		Overflowed: c.overflowed.Load(),
+		TimedOut:   c.timedOut.Load(),
+		WaitTime:   time.Duration(c.waitTime.Load()),
+		MaxWait:    time.Duration(c.maxWait.Load()),
+	}
+}
+
+// StatsHandler returns a handler serving the Stats as JSON, to be added to the metrics routes of the server
+func (c *Coalescer) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(rest.ContentTypeHeader, ioutils.MimeApplicationJSON)
+		_ = json.NewEncoder(w).Encode(c.Stats())
+	})
+}
+
+// execute serves the request and shares its response with the requests parked meanwhile
+func (c *Coalescer) execute(key string, call *coalescedCall, next http.Handler, w http.ResponseWriter,
+	r *http.Request) {
+	c.executed.Add(1)
+	cw := &coalesceWriter{ResponseWriter: w, status: http.StatusOK, limit: c.opts.MaxBodySize}
+	defer func() {
+		// a panicking handler shares nothing and the panic goes on to the server
+		c.mutex.Lock()
+		delete(c.calls, key)
+		c.mutex.Unlock()
+		close(call.done)
+	}()
+	next.ServeHTTP(cw, r)
+	if cw.header == nil {
+		cw.header = w.Header().Clone()
+	}
+	if !cw.truncated && shareable(cw.header) {
+		call.shared, call.status, call.header, call.body = true, cw.status, cw.header, cw.body.Bytes()
+	}
+}
+
+// wait parks the request until the call completes and replays its response, or serves the request on its own if
+// the response is not shared or the call takes longer than MaxPark
+func (c *Coalescer) wait(call *coalescedCall, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	timer := time.NewTimer(c.opts.MaxPark)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+		c.waited(time.Since(start))
+		if !call.shared {
+			c.unshared.Add(1)
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.coalesced.Add(1)
+		c.replay(call, w, r)
+	case <-timer.C:
+		c.waited(time.Since(start))
+		c.timedOut.Add(1)
+		logger.DebugF("serving the request %s %s parked for %v on its own", r.Method, r.URL.Path, c.opts.MaxPark)
+		next.ServeHTTP(w, r)
+	}
+}
+
+// waited records the time a request was parked
+func (c *Coalescer) waited(d time.Duration) {
+	c.waitTime.Add(int64(d))
+	for {
+		current := c.maxWait.Load()
+		if int64(d) <= current || c.maxWait.CompareAndSwap(current, int64(d)) {
+			return
+		}
+	}
+}
+
+// replay writes the shared response
+func (c *Coalescer) replay(call *coalescedCall, w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	for k, v := range call.header {
+		if !c.exclude[k] {
+			header[k] = append([]string(nil), v...)
+		}
+	}
+	w.WriteHeader(call.status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(call.body)
+	}
+}
+
+// matches checks the method and the path of the request
+func (c *Coalescer) matches(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if len(c.opts.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range c.opts.Paths {
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the method, the path, the query sorted by name and the key headers of the request
+func (c *Coalescer) key(r *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString(r.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(r.URL.EscapedPath())
+	sb.WriteByte('?')
+	sb.WriteString(r.URL.Query().Encode())
+	for _, h := range c.keyHeaders {
+		sb.WriteByte('\n')
+		sb.WriteString(h)
+		sb.WriteByte(':')
+		sb.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return sb.String()
+}
+
+// shareable checks that the response does not set a cookie and may be served to other callers
+func shareable(header http.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "private" || directive == "no-store" || strings.HasPrefix(directive, "private=") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// coalesceWriter writes the response while keeping its status, headers and body up to the limit
+type coalesceWriter struct {
+	http.ResponseWriter
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+// WriteHeader records the status and the headers and writes them
+func (cw *coalesceWriter) WriteHeader(status int) {
+	if cw.header == nil {
+		cw.status, cw.header = status, cw.ResponseWriter.Header().Clone()
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the data up to the limit and writes it
+func (cw *coalesceWriter) Write(data []byte) (int, error) {
+	if cw.header == nil {
+		cw.header = cw.ResponseWriter.Header().Clone()
+	}
+	if int64(cw.body.Len()+len(data)) > cw.limit {
+		cw.truncated = true
+	} else {
+		cw.body.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush flushes the underlying writer if it supports it
+func (cw *coalesceWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for the http.ResponseController
+func (cw *coalesceWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
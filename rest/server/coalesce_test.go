@@ -0,0 +1,199 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowHandler is a handler counting its executions, the first of which blocks until released
+type slowHandler struct {
+	executions atomic.Int32
+	release    chan struct{}
+	cookie     bool
+}
+
+func (h *slowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.executions.Add(1)
+	if n == 1 {
+		<-h.release
+	}
+	w.Header().Set("Date", time.Now().Format(http.TimeFormat))
+	w.Header().Set("X-Report", "daily")
+	if h.cookie {
+		w.Header().Set("Set-Cookie", "session="+strconv.Itoa(int(n)))
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("report for " + r.URL.RawQuery))
+}
+
+// waitFor polls the condition until it holds or a second elapses
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("the condition is not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waiters returns the number of requests parked on the request in flight
+func (c *Coalescer) waiters() (n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, call := range c.calls {
+		n += call.waiters
+	}
+	return
+}
+
+// fire sends the requests concurrently and returns their responses once all completed
+func fire(h http.Handler, requests ...*http.Request) []*httptest.ResponseRecorder {
+	recorders := make([]*httptest.ResponseRecorder, len(requests))
+	var wg sync.WaitGroup
+	for i, r := range requests {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(recorders[i], r)
+		}()
+	}
+	wg.Wait()
+	return recorders
+}
+
+func identicalRequests(n int) (requests []*http.Request) {
+	for i := 0; i < n; i++ {
+		// the same query in a different order
+		target := "/reports?b=2&a=1"
+		if i%2 == 1 {
+			target = "/reports?a=1&b=2"
+		}
+		requests = append(requests, httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	return
+}
+
+func TestCoalesceMiddleware(t *testing.T) {
+	handler := &slowHandler{release: make(chan struct{})}
+	c := CoalesceMiddleware(CoalesceOptions{Paths: []string{"/reports"}})
+	h := c.Filter(handler)
+	done := make(chan []*httptest.ResponseRecorder)
+	go func() { done <- fire(h, identicalRequests(10)...) }()
+	waitFor(t, func() bool { return c.waiters() == 9 })
+	close(handler.release)
+	recorders := <-done
+
+	if n := handler.executions.Load(); n != 1 {
+		t.Errorf("executions = %d, want 1", n)
+	}
+	dates := 0
+	for _, w := range recorders {
+		if w.Code != http.StatusAccepted || w.Header().Get("X-Report") != "daily" ||
+			w.Body.String() != "report for b=2&a=1" && w.Body.String() != "report for a=1&b=2" {
+			t.Errorf("response = %d %v %q", w.Code, w.Header(), w.Body.String())
+		}
+		if w.Header().Get("Date") != "" {
+			dates++
+		}
+	}
+	// only the executed request has its Date
+	if dates != 1 {
+		t.Errorf("%d responses with a Date", dates)
+	}
+	stats := c.Stats()
+	if stats.Executed != 1 || stats.Coalesced != 9 || stats.WaitTime <= 0 || stats.MaxWait <= 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestCoalesceMiddleware_Bypass(t *testing.T) {
+	t.Run("Credentials", func(t *testing.T) {
+		handler := &slowHandler{release: make(chan struct{})}
+		c := CoalesceMiddleware(CoalesceOptions{})
+		h := c.Filter(handler)
+		done := make(chan []*httptest.ResponseRecorder)
+		go func() { done <- fire(h, identicalRequests(1)...) }()
+		waitFor(t, func() bool { return handler.executions.Load() == 1 })
+		requests := identicalRequests(2)
+		requests[0].Header.Set("Authorization", "Bearer token")
+		requests[1].Header.Set("Cookie", "session=1")
+		fire(h, requests...)
+		// a POST is never coalesced
+		fire(h, httptest.NewRequest(http.MethodPost, "/reports?b=2&a=1", nil))
+		close(handler.release)
+		<-done
+		if n := handler.executions.Load(); n != 4 {
+			t.Errorf("executions = %d, want 4", n)
+		}
+		if stats := c.Stats(); stats.Bypassed != 2 || stats.Coalesced != 0 {
+			t.Errorf("stats = %+v", stats)
+		}
+	})
+	t.Run("SetCookie", func(t *testing.T) {
+		handler := &slowHandler{release: make(chan struct{}), cookie: true}
+		c := CoalesceMiddleware(CoalesceOptions{})
+		h := c.Filter(handler)
+		done := make(chan []*httptest.ResponseRecorder)
+		go func() { done <- fire(h, identicalRequests(3)...) }()
+		waitFor(t, func() bool { return c.waiters() == 2 })
+		close(handler.release)
+		cookies := map[string]bool{}
+		for _, w := range <-done {
+			cookies[w.Header().Get("Set-Cookie")] = true
+		}
+		// the waiters are served on their own, each with its cookie
+		if n := handler.executions.Load(); n != 3 || len(cookies) != 3 {
+			t.Errorf("executions = %d, cookies = %v", n, cookies)
+		}
+		if stats := c.Stats(); stats.Unshared != 2 {
+			t.Errorf("stats = %+v", stats)
+		}
+	})
+}
+
+func TestCoalesceMiddleware_MaxWaiters(t *testing.T) {
+	handler := &slowHandler{release: make(chan struct{})}
+	c := CoalesceMiddleware(CoalesceOptions{MaxWaiters: 2})
+	h := c.Filter(handler)
+	done := make(chan []*httptest.ResponseRecorder)
+	go func() { done <- fire(h, identicalRequests(5)...) }()
+	waitFor(t, func() bool { return c.waiters() == 2 && c.Stats().Overflowed == 2 })
+	close(handler.release)
+	for _, w := range <-done {
+		if w.Code != http.StatusAccepted {
+			t.Errorf("status = %d", w.Code)
+		}
+	}
+	if n := handler.executions.Load(); n != 3 {
+		t.Errorf("executions = %d, want 3", n)
+	}
+	if stats := c.Stats(); stats.Coalesced != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestCoalesceMiddleware_MaxPark(t *testing.T) {
+	handler := &slowHandler{release: make(chan struct{})}
+	c := CoalesceMiddleware(CoalesceOptions{MaxPark: 20 * time.Millisecond})
+	h := c.Filter(handler)
+	done := make(chan []*httptest.ResponseRecorder)
+	go func() { done <- fire(h, identicalRequests(1)...) }()
+	waitFor(t, func() bool { return handler.executions.Load() == 1 })
+	// the parked request falls through to its own execution while the first one is still blocked
+	parked := fire(h, identicalRequests(1)...)[0]
+	if parked.Code != http.StatusAccepted || handler.executions.Load() != 2 {
+		t.Errorf("status = %d, executions = %d", parked.Code, handler.executions.Load())
+	}
+	close(handler.release)
+	<-done
+	if stats := c.Stats(); stats.TimedOut != 1 || stats.MaxWait < 20*time.Millisecond {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -150,6 +150,12 @@ func (c *Context) WriteFrom(data io.Reader) {
 	io.Copy(c.response, data)
 }
 
+// Mark records a named point in time of the request handling. The marks are added to the access records captured
+// by the turbo.AccessLog filter.
+func (c *Context) Mark(name string) {
+	turbo.Mark(c.request, name)
+}
+
 // HttpResWriter returns the http.ResponseWriter
 func (c *Context) HttpResWriter() http.ResponseWriter {
 	return c.response
@@ -6,8 +6,10 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/turbo"
 )
 
 // TestContext_GetParam tests the GetParam function
@@ -227,3 +229,21 @@ func TestContext_HttpResWriter(t *testing.T) {
 		t.Errorf("HttpResWriter() = %v, want %v", writer, rec)
 	}
 }
+
+// TestContext_Mark tests the Mark function
+func TestContext_Mark(t *testing.T) {
+	var records []*turbo.AccessRecord
+	filter := turbo.AccessLog(turbo.AccessLogOptions{
+		SlowThreshold: time.Nanosecond,
+		Sink:          func(record *turbo.AccessRecord) { records = append(records, record) },
+	})
+	handler := filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := &Context{request: r, response: w}
+		ctx.Mark("db-done")
+		time.Sleep(time.Millisecond)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+	if len(records) != 1 || len(records[0].Marks) != 1 || records[0].Marks[0].Name != "db-done" {
+		t.Errorf("Mark() records = %v", records)
+	}
+}
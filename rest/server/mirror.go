@@ -0,0 +1,405 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/rest/client"
+)
+
+const (
+	// MirrorHeader is set on the mirrored requests so that the mirror can tell them from the real traffic
+	MirrorHeader = "X-Mirrored-Request"
+
+	defaultMirrorConcurrency = 4
+	defaultMirrorQueueSize   = 100
+	defaultMirrorMaxBodySize = 1024 * 1024
+	defaultMirrorTimeout     = 10
+)
+
+// hopByHopHeaders are the headers of a connection that are never mirrored
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te",
+	"Trailer", "Transfer-Encoding", "Upgrade", "Content-Length"}
+
+// MirrorOptions configures MirrorMiddleware
+type MirrorOptions struct {
+	// Target is the base url of the mirror, such as http://shadow.internal:8080. The path and the query of the
+	// requests are appended to it.
+	Target string
+	// Client sends the mirrored requests. Defaults to a client with a timeout of 10 seconds and without retries.
+	Client *client.Client
+	// Paths are the path.Match patterns of the paths mirrored, all the paths are mirrored if empty
+	Paths []string
+	// SamplePercent is the percentage of the matching requests mirrored, from 0 to 100
+	SamplePercent float64
+	// IncludeMutating mirrors the POST, PUT, PATCH and DELETE requests as well. Only the safe methods are mirrored
+	// by default since the mirror would apply the writes a second time.
+	IncludeMutating bool
+	// ExcludeHeaders are the headers not sent to the mirror, such as Authorization, along with the hop-by-hop ones
+	ExcludeHeaders []string
+	// MaxBodySize is the largest body mirrored, the requests with a larger body are not mirrored. It is also the
+	// size of the response bodies compared. Defaults to 1MiB.
+	MaxBodySize int64
+	// Concurrency is the number of requests sent to the mirror at once. Defaults to 4.
+	Concurrency int
+	// QueueSize is the number of requests waiting for the mirror. Once the queue is full the requests are dropped,
+	// the primary requests never wait for the mirror. Defaults to 100.
+	QueueSize int
+	// Comparator compares the responses of the mirror with the primary ones if set
+	Comparator *MirrorComparator
+}
+
+// MirrorComparator compares the response of the mirror with the one of the primary backend. The statuses must match;
+// the JSON bodies must be equal once the volatile paths are removed, the other bodies must be identical.
+type MirrorComparator struct {
+	// IgnorePaths are the dotted paths of the JSON values that differ between the backends, such as timestamps or
+	// generated ids. A * matches any key or index: "items.*.updatedAt".
+	IgnorePaths []string
+}
+
+// MirrorStats are the counters of a Mirror
+type MirrorStats struct {
+	// Sampled is the number of requests selected for mirroring
+	Sampled uint64 `json:"sampled"`
+	// Sent is the number of requests the mirror responded to
+	Sent uint64 `json:"sent"`
+	// Failed is the number of requests that could not be sent to the mirror
+	Failed uint64 `json:"failed"`
+	// Dropped is the number of requests dropped with the queue full or the mirror closed
+	Dropped uint64 `json:"dropped"`
+	// Skipped is the number of sampled requests not mirrored for a body larger than MaxBodySize
+	Skipped uint64 `json:"skipped"`
+	// Compared is the number of responses compared
+	Compared uint64 `json:"compared"`
+	// StatusMismatches is the number of compared responses with different statuses
+	StatusMismatches uint64 `json:"statusMismatches"`
+	// BodyMismatches is the number of compared responses with the same status and different bodies
+	BodyMismatches uint64 `json:"bodyMismatches"`
+}
+
+// Mirror duplicates a sample of the requests to a secondary backend, such as a rewritten service shadowing the
+// production traffic before the cut over. Create it with MirrorMiddleware.
+type Mirror struct {
+	opts    MirrorOptions
+	exclude map[string]bool
+	ignore  [][]string
+	queue   chan *mirrorJob
+	mutex   sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
+
+	sampled, sent, failed, dropped, skipped    atomic.Uint64
+	compared, statusMismatches, bodyMismatches atomic.Uint64
+}
+
+// mirrorJob is a request waiting for the mirror along with the primary response to compare
+type mirrorJob struct {
+	method string
+	uri    string
+	header http.Header
+	body   []byte
+	status int
+	result []byte
+}
+
+// MirrorMiddleware creates a Mirror sending the requests to its target from a pool of workers. Add its Filter to
+// the router, the response of the primary backend is always the one sent to the client. Close the Mirror to stop its
+// workers.
+func MirrorMiddleware(opts MirrorOptions) *Mirror {
+	if opts.Client == nil {
+		opts.Client = client.NewClient().ReqTimeout(defaultMirrorTimeout)
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaultMirrorMaxBodySize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultMirrorConcurrency
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultMirrorQueueSize
+	}
+	opts.Target = strings.TrimSuffix(opts.Target, "/")
+	m := &Mirror{
+		opts:    opts,
+		exclude: make(map[string]bool),
+		queue:   make(chan *mirrorJob, opts.QueueSize),
+	}
+	for _, h := range append(hopByHopHeaders, opts.ExcludeHeaders...) {
+		m.exclude[http.CanonicalHeaderKey(h)] = true
+	}
+	if opts.Comparator != nil {
+		for _, p := range opts.Comparator.IgnorePaths {
+			m.ignore = append(m.ignore, strings.Split(p, "."))
+		}
+	}
+	m.wg.Add(opts.Concurrency)
+	for i := 0; i < opts.Concurrency; i++ {
+		go m.work()
+	}
+	return m
+}
+
+// Filter mirrors the sampled requests once the primary backend has responded
+func (m *Mirror) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.matches(r) || rand.Float64()*100 >= m.opts.SamplePercent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.sampled.Add(1)
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, m.opts.MaxBodySize+1))
+			// the primary gets the body read followed by the rest of it
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if err != nil || int64(len(body)) > m.opts.MaxBodySize {
+				m.skipped.Add(1)
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		job := &mirrorJob{method: r.Method, uri: r.URL.RequestURI(), header: m.headers(r), body: body}
+		if m.opts.Comparator != nil {
+			rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK, limit: m.opts.MaxBodySize}
+			next.ServeHTTP(rw, r)
+			job.status, job.result = rw.status, rw.body.Bytes()
+		} else {
+			next.ServeHTTP(w, r)
+		}
+		m.enqueue(job)
+	})
+}
+
+// Stats returns the counters of the mirror
+func (m *Mirror) Stats() MirrorStats {
+	return MirrorStats{
+		Sampled:          m.sampled.Load(),
+		Sent:             m.sent.Load(),
+		Failed:           m.failed.Load(),
+		Dropped:          m.dropped.Load(),
+		Skipped:          m.skipped.Load(),
+		Compared:         m.compared.Load(),
+		StatusMismatches: m.statusMismatches.Load(),
+		BodyMismatches:   m.bodyMismatches.Load(),
+	}
+}
+
+// StatsHandler returns a handler serving the Stats as JSON, to be added to the metrics routes of the server
+func (m *Mirror) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(rest.ContentTypeHeader, ioutils.MimeApplicationJSON)
+		_ = json.NewEncoder(w).Encode(m.Stats())
+	})
+}
+
+// Close stops accepting requests and waits for the queued ones to be sent
+func (m *Mirror) Close() (err error) {
+	m.mutex.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mutex.Unlock()
+	m.wg.Wait()
+	return
+}
+
+// matches checks the method and the path of the request
+func (m *Mirror) matches(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if !m.opts.IncludeMutating {
+			return false
+		}
+	}
+	if len(m.opts.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range m.opts.Paths {
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// headers returns the headers of the request sent to the mirror
+func (m *Mirror) headers(r *http.Request) http.Header {
+	exclude := m.exclude
+	if connection := r.Header.Values("Connection"); len(connection) > 0 {
+		exclude = make(map[string]bool, len(m.exclude))
+		for k := range m.exclude {
+			exclude[k] = true
+		}
+		for _, v := range connection {
+			for _, h := range strings.Split(v, ",") {
+				exclude[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+			}
+		}
+	}
+	header := make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		if !exclude[http.CanonicalHeaderKey(k)] {
+			header[k] = append([]string(nil), v...)
+		}
+	}
+	header.Set(MirrorHeader, "true")
+	return header
+}
+
+// enqueue queues the job unless the queue is full or the mirror is closed
+func (m *Mirror) enqueue(job *mirrorJob) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if !m.closed {
+		select {
+		case m.queue <- job:
+			return
+		default:
+		}
+	}
+	m.dropped.Add(1)
+}
+
+// work sends the queued jobs to the mirror
+func (m *Mirror) work() {
+	defer m.wg.Done()
+	for job := range m.queue {
+		m.send(job)
+	}
+}
+
+// send sends the job to the mirror and compares the responses
+func (m *Mirror) send(job *mirrorJob) {
+	req := m.opts.Client.NewRequest(m.opts.Target+job.uri, job.method)
+	for k, v := range job.header {
+		req.AddHeader(k, v...)
+	}
+	if len(job.body) > 0 {
+		req.SeBodyReader(bytes.NewReader(job.body))
+	}
+	res, err := m.opts.Client.Execute(req)
+	if err != nil {
+		m.failed.Add(1)
+		logger.DebugF("mirroring %s %s failed: %v", job.method, job.uri, err)
+		return
+	}
+	defer res.Raw().Body.Close()
+	m.sent.Add(1)
+	if m.opts.Comparator == nil {
+		_, _ = io.Copy(io.Discard, res.Raw().Body)
+		return
+	}
+	m.compared.Add(1)
+	if res.StatusCode() != job.status {
+		m.statusMismatches.Add(1)
+		logger.DebugF("mirror of %s %s responded %d instead of %d", job.method, job.uri, res.StatusCode(),
+			job.status)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Raw().Body, m.opts.MaxBodySize))
+	if err != nil || !m.sameBody(job.result, body) {
+		m.bodyMismatches.Add(1)
+		logger.DebugF("mirror of %s %s responded a different body", job.method, job.uri)
+	}
+}
+
+// sameBody compares the bodies as JSON without the ignored paths if both are JSON, byte by byte otherwise
+func (m *Mirror) sameBody(primary, mirrored []byte) bool {
+	var p, s any
+	if json.Unmarshal(primary, &p) != nil || json.Unmarshal(mirrored, &s) != nil {
+		return bytes.Equal(primary, mirrored)
+	}
+	for _, parts := range m.ignore {
+		p = removeJSONPath(p, parts)
+		s = removeJSONPath(s, parts)
+	}
+	return reflect.DeepEqual(p, s)
+}
+
+// removeJSONPath removes the values at the dotted path from a decoded JSON value
+func removeJSONPath(v any, parts []string) any {
+	if len(parts) == 0 {
+		return nil
+	}
+	key, last := parts[0], len(parts) == 1
+	switch node := v.(type) {
+	case map[string]any:
+		for k, child := range node {
+			if key != "*" && k != key {
+				continue
+			}
+			if last {
+				delete(node, k)
+			} else {
+				node[k] = removeJSONPath(child, parts[1:])
+			}
+		}
+	case []any:
+		for i, child := range node {
+			if key != "*" && key != strconv.Itoa(i) {
+				continue
+			}
+			if last {
+				node[i] = nil
+			} else {
+				node[i] = removeJSONPath(child, parts[1:])
+			}
+		}
+	}
+	return v
+}
+
+// recordingWriter is a http.ResponseWriter keeping the status and the beginning of the body
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int64
+}
+
+// WriteHeader records the status and writes it
+func (rw *recordingWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status, rw.wroteHeader = status, true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the data up to the limit and writes it
+func (rw *recordingWriter) Write(data []byte) (n int, err error) {
+	rw.wroteHeader = true
+	if remaining := rw.limit - int64(rw.body.Len()); remaining > 0 {
+		rw.body.Write(data[:min(int64(len(data)), remaining)])
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+// Flush flushes the underlying writer if it supports it
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for the http.ResponseController
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mirrorBackend is a httptest backend recording the requests it receives
+type mirrorBackend struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newMirrorBackend(t *testing.T, handler http.HandlerFunc) *mirrorBackend {
+	b := &mirrorBackend{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		b.mutex.Lock()
+		b.requests = append(b.requests, r)
+		b.bodies = append(b.bodies, string(body))
+		b.mutex.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *mirrorBackend) count() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.requests)
+}
+
+// proxyTo returns a handler serving the requests with the backend, like the primary service behind the server
+func proxyTo(backend *mirrorBackend) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest(r.Method, backend.URL+r.URL.RequestURI(), r.Body)
+		req.Header = r.Header.Clone()
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		w.WriteHeader(res.StatusCode)
+		_, _ = io.Copy(w, res.Body)
+	})
+}
+
+func TestMirror_Sampling(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	primary, shadow := newMirrorBackend(t, ok), newMirrorBackend(t, ok)
+	mirror := MirrorMiddleware(MirrorOptions{Target: shadow.URL, SamplePercent: 25, QueueSize: 2000,
+		Paths: []string{"/api/*"}})
+	h := mirror.Filter(proxyTo(primary))
+	for i := 0; i < 2000; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/items", nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	_ = mirror.Close()
+
+	stats := mirror.Stats()
+	if stats.Sampled < 400 || stats.Sampled > 600 {
+		t.Errorf("sampled %d of 2000 requests, want about 500", stats.Sampled)
+	}
+	if stats.Sent+stats.Dropped != stats.Sampled || uint64(shadow.count()) != stats.Sent {
+		t.Errorf("mirror received %d requests with %+v", shadow.count(), stats)
+	}
+	if primary.count() != 2001 {
+		t.Errorf("primary received %d requests, want all of them", primary.count())
+	}
+}
+
+func TestMirror_SlowMirror(t *testing.T) {
+	release := make(chan struct{})
+	primary := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	shadow := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) { <-release })
+	mirror := MirrorMiddleware(MirrorOptions{Target: shadow.URL, SamplePercent: 100, Concurrency: 1, QueueSize: 2})
+	h := mirror.Filter(proxyTo(primary))
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d from the primary", w.Code)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the primary requests took %v with a stuck mirror", elapsed)
+	}
+	close(release)
+	_ = mirror.Close()
+	// one request is sent, two are queued and the others are dropped
+	if stats := mirror.Stats(); stats.Dropped < 17 || stats.Sent+stats.Dropped != 20 {
+		t.Errorf("got %+v, want the requests over the queue dropped", stats)
+	}
+}
+
+func TestMirror_Fidelity(t *testing.T) {
+	primary := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	shadow := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	mirror := MirrorMiddleware(MirrorOptions{Target: shadow.URL + "/", SamplePercent: 100, IncludeMutating: true,
+		ExcludeHeaders: []string{"Authorization"}})
+	h := mirror.Filter(proxyTo(primary))
+	body := `{"name":"widget","tags":["a","b"]}`
+	r := httptest.NewRequest(http.MethodPost, "/api/items?dry=true&x=1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Connection", "X-Hop")
+	r.Header.Set("X-Hop", "1")
+	r.Header.Set("X-Trace", "t1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	_ = mirror.Close()
+
+	if shadow.count() != 1 || primary.count() != 1 {
+		t.Fatalf("got %d mirrored and %d primary requests", shadow.count(), primary.count())
+	}
+	if primary.bodies[0] != body || shadow.bodies[0] != body {
+		t.Errorf("got the bodies %q and %q, want %q", primary.bodies[0], shadow.bodies[0], body)
+	}
+	got := shadow.requests[0]
+	if got.Method != http.MethodPost || got.URL.RequestURI() != "/api/items?dry=true&x=1" {
+		t.Errorf("got %s %s", got.Method, got.URL.RequestURI())
+	}
+	if got.Header.Get("Content-Type") != "application/json" || got.Header.Get("X-Trace") != "t1" ||
+		got.Header.Get(MirrorHeader) != "true" {
+		t.Errorf("got the headers %v", got.Header)
+	}
+	if got.Header.Get("Authorization") != "" || got.Header.Get("X-Hop") != "" {
+		t.Errorf("got the excluded headers %v", got.Header)
+	}
+}
+
+func TestMirror_Divergence(t *testing.T) {
+	primary := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id":1,"at":"10:00","items":[{"n":1,"etag":"a"}]}`)
+	})
+	shadow := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			_, _ = io.WriteString(w, `{"items":[{"etag":"b","n":1}],"id":1,"at":"10:01"}`)
+		case "/different":
+			_, _ = io.WriteString(w, `{"id":2,"at":"10:01","items":[{"n":1,"etag":"a"}]}`)
+		default:
+			_, _ = io.WriteString(w, `{}`)
+		}
+	})
+	mirror := MirrorMiddleware(MirrorOptions{Target: shadow.URL, SamplePercent: 100,
+		Comparator: &MirrorComparator{IgnorePaths: []string{"at", "items.*.etag"}}})
+	h := mirror.Filter(proxyTo(primary))
+	for _, p := range []string{"/same", "/different", "/missing", "/same"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if p == "/missing" && w.Code != http.StatusNotFound {
+			t.Errorf("got %d, want the primary response", w.Code)
+		}
+	}
+	_ = mirror.Close()
+
+	stats := mirror.Stats()
+	if stats.Compared != 4 || stats.StatusMismatches != 1 || stats.BodyMismatches != 1 {
+		t.Errorf("got %+v, want one status and one body mismatch", stats)
+	}
+	w := httptest.NewRecorder()
+	mirror.StatsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics/mirror", nil))
+	if !strings.Contains(w.Body.String(), `"bodyMismatches":1`) {
+		t.Errorf("got the stats %s", w.Body.String())
+	}
+}
+
+func TestMirror_MutatingExcluded(t *testing.T) {
+	primary := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	shadow := newMirrorBackend(t, func(w http.ResponseWriter, r *http.Request) {})
+	mirror := MirrorMiddleware(MirrorOptions{Target: shadow.URL, SamplePercent: 100})
+	h := mirror.Filter(proxyTo(primary))
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodGet} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/api/items", strings.NewReader("{}")))
+	}
+	_ = mirror.Close()
+	if shadow.count() != 1 || shadow.requests[0].Method != http.MethodGet || primary.count() != 5 {
+		t.Errorf("got %d mirrored requests, want the GET only", shadow.count())
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/turbo"
+)
+
+const (
+	// QuotaLimitHeader is the header of the number of requests allowed in the period of the quota
+	QuotaLimitHeader = "X-Quota-Limit"
+	// QuotaRemainingHeader is the header of the number of requests left in the period of the quota
+	QuotaRemainingHeader = "X-Quota-Remaining"
+	// QuotaResetHeader is the header of the time the period of the quota ends, in seconds since the epoch
+	QuotaResetHeader = "X-Quota-Reset"
+)
+
+// QuotaPeriod is the period after which the counters of a quota roll over
+type QuotaPeriod int
+
+const (
+	// QuotaDaily quotas roll over at midnight
+	QuotaDaily QuotaPeriod = iota
+	// QuotaMonthly quotas roll over at midnight on the first day of the month
+	QuotaMonthly
+)
+
+// quotaNow returns the current time, replaced by the tests
+var quotaNow = time.Now
+
+// QuotaPlan defines the limits of a quota
+type QuotaPlan struct {
+	// Period is the period of the limits
+	Period QuotaPeriod `json:"period" yaml:"period"`
+	// Requests is the number of requests allowed in a period
+	Requests int64 `json:"requests" yaml:"requests"`
+	// Bytes is the number of response bytes allowed in a period. Zero does not limit the bytes.
+	Bytes int64 `json:"bytes" yaml:"bytes"`
+	// Grace is the fraction of the limits served over them before rejecting, 0.1 for a 10% overage.
+	// The headers report the limits without the grace.
+	Grace float64 `json:"grace" yaml:"grace"`
+	// Location is the time zone of the period boundaries. Defaults to UTC.
+	Location *time.Location `json:"-" yaml:"-"`
+}
+
+// bounds returns the identifier of the period containing t and the time it ends
+func (p *QuotaPlan) bounds(t time.Time) (period string, reset time.Time) {
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	if p.Period == QuotaMonthly {
+		period = t.Format("2006-01")
+		reset = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+	} else {
+		period = t.Format("2006-01-02")
+		reset = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+	}
+	return
+}
+
+// exceeded returns true if the used amount reached the limit along with the grace
+func (p *QuotaPlan) exceeded(used, limit int64) bool {
+	return limit > 0 && float64(used) > float64(limit)*(1+p.Grace)
+}
+
+// QuotaExceeded is the body of the responses rejected by QuotaMiddleware
+type QuotaExceeded struct {
+	Error     string    `json:"error"`
+	Key       string    `json:"key"`
+	Plan      string    `json:"plan"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	BytesUsed int64     `json:"bytesUsed,omitempty"`
+	Reset     time.Time `json:"reset"`
+}
+
+// QuotaMiddleware returns a filter counting the requests and the response bytes of each client against the quota
+// of its plan. keyFn identifies the client and its plan; the requests without a key or with an unknown plan are
+// not counted. Each counted response carries the X-Quota-* headers. Once the requests, or the bytes, of the period
+// exceed the limit along with the grace the requests are rejected with a 429 and a QuotaExceeded body. The rejected
+// requests are not counted.
+func QuotaMiddleware(store QuotaStore, plans map[string]QuotaPlan,
+	keyFn func(r *http.Request) (key, plan string)) turbo.FilterFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, name := keyFn(r)
+			plan, ok := plans[name]
+			if key == "" || !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			period, reset := plan.bounds(quotaNow())
+			counter, err := store.Add(QuotaCounter{Key: key, Plan: name, Period: period, Requests: 1})
+			if err != nil {
+				logger.ErrorF("quota of %s not counted: %v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			remaining := plan.Requests - counter.Requests
+			if remaining < 0 {
+				remaining = 0
+			}
+			w.Header().Set(QuotaLimitHeader, strconv.FormatInt(plan.Requests, 10))
+			w.Header().Set(QuotaRemainingHeader, strconv.FormatInt(remaining, 10))
+			w.Header().Set(QuotaResetHeader, strconv.FormatInt(reset.Unix(), 10))
+			// the request counted is served up to the limit, the bytes already sent must be below it
+			if plan.exceeded(counter.Requests, plan.Requests) || plan.exceeded(counter.Bytes+1, plan.Bytes) {
+				if _, err = store.Add(QuotaCounter{Key: key, Plan: name, Period: period, Requests: -1}); err != nil {
+					logger.ErrorF("quota of %s not restored: %v", key, err)
+				}
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(reset.Sub(quotaNow()).Seconds())+1, 10))
+				w.Header().Set(rest.ContentTypeHeader, ioutils.MimeApplicationJSON)
+				w.WriteHeader(http.StatusTooManyRequests)
+				_ = json.NewEncoder(w).Encode(&QuotaExceeded{
+					Error:     "quota exceeded",
+					Key:       key,
+					Plan:      name,
+					Limit:     plan.Requests,
+					Used:      counter.Requests - 1,
+					BytesUsed: counter.Bytes,
+					Reset:     reset,
+				})
+				return
+			}
+			cw := &countingWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			if plan.Bytes > 0 && cw.written > 0 {
+				if _, err = store.Add(QuotaCounter{Key: key, Plan: name, Period: period, Bytes: cw.written}); err != nil {
+					logger.ErrorF("quota bytes of %s not counted: %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+// CurrentQuotaUsage returns the counters of the store in the current period of their plan, for the support tools
+func CurrentQuotaUsage(store QuotaStore, plans map[string]QuotaPlan) (usage []QuotaCounter, err error) {
+	var counters []QuotaCounter
+	if counters, err = store.List(); err == nil {
+		now := quotaNow()
+		for _, counter := range counters {
+			if plan, ok := plans[counter.Plan]; ok {
+				if period, _ := plan.bounds(now); period == counter.Period {
+					usage = append(usage, counter)
+				}
+			}
+		}
+	}
+	return
+}
+
+// countingWriter is a http.ResponseWriter counting the bytes of the body
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+// Write writes the data and counts its bytes
+func (cw *countingWriter) Write(data []byte) (n int, err error) {
+	n, err = cw.ResponseWriter.Write(data)
+	cw.written += int64(n)
+	return
+}
+
+// Flush flushes the underlying writer if it supports it
+func (cw *countingWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for the http.ResponseController
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
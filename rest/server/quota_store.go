@@ -0,0 +1,282 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+)
+
+const (
+	defaultQuotaFlushInterval = 5 * time.Second
+	defaultQuotaRetention     = 62 * 24 * time.Hour
+	quotaLockRetry            = 10 * time.Millisecond
+	quotaLockTimeout          = 10 * time.Second
+	// quotaLockStale is the age from which the lock file of a crashed process is removed
+	quotaLockStale = 30 * time.Second
+)
+
+// ErrQuotaLocked is returned when the lock of a file quota store cannot be acquired in time
+var ErrQuotaLocked = errors.New("the quota store is locked")
+
+// QuotaCounter is the usage of a client in a period of its quota
+type QuotaCounter struct {
+	Key      string    `json:"key"`
+	Plan     string    `json:"plan"`
+	Period   string    `json:"period"`
+	Requests int64     `json:"requests"`
+	Bytes    int64     `json:"bytes"`
+	Updated  time.Time `json:"updated"`
+}
+
+// id returns the identifier of the counter in the store
+func (c *QuotaCounter) id() string {
+	return c.Key + "|" + c.Period
+}
+
+// QuotaStore persists the quota counters. Implementations must be safe for concurrent use.
+type QuotaStore interface {
+	// Add adds the Requests and the Bytes of the delta to the counter of its Key and Period and returns the totals
+	Add(delta QuotaCounter) (QuotaCounter, error)
+	// List returns all the counters of the store
+	List() ([]QuotaCounter, error)
+}
+
+// FileQuotaStore is a QuotaStore keeping the counters in memory and flushing them to a JSON file in batches.
+// The counts of the requests since the last flush, at most the flush interval, are lost if the process crashes.
+// Several processes can share the file: each flush adds the counts of the process to the file under a lock file,
+// and reads back the counts of the others, so the totals seen by a process lag the others by a flush interval.
+// The counters not updated for the retention are dropped from the file.
+type FileQuotaStore struct {
+	path      string
+	retention time.Duration
+	mutex     sync.Mutex
+	// flushMutex serializes the flushes
+	flushMutex sync.Mutex
+	// totals are the counters read from the file at the last flush
+	totals map[string]*QuotaCounter
+	// deltas are the counts not flushed yet
+	deltas map[string]*QuotaCounter
+	// flushing are the counts of the flush in progress
+	flushing map[string]*QuotaCounter
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewFileQuotaStore opens the quota store of the file and starts flushing it at the interval. An interval of 0
+// selects 5 seconds. The store must be closed to flush the last counts.
+func NewFileQuotaStore(path string, flushInterval time.Duration) (store *FileQuotaStore, err error) {
+	if flushInterval <= 0 {
+		flushInterval = defaultQuotaFlushInterval
+	}
+	store = &FileQuotaStore{
+		path:      path,
+		retention: defaultQuotaRetention,
+		deltas:    map[string]*QuotaCounter{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if store.totals, err = store.read(); err != nil {
+		return nil, err
+	}
+	go store.run(flushInterval)
+	return
+}
+
+// Add adds the counts of the delta and returns the totals
+func (s *FileQuotaStore) Add(delta QuotaCounter) (counter QuotaCounter, err error) {
+	id := delta.id()
+	now := quotaNow()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pending, ok := s.deltas[id]
+	if !ok {
+		pending = &QuotaCounter{Key: delta.Key, Plan: delta.Plan, Period: delta.Period}
+		s.deltas[id] = pending
+	}
+	pending.Requests += delta.Requests
+	pending.Bytes += delta.Bytes
+	pending.Updated = now
+	counter = *pending
+	for _, counters := range []map[string]*QuotaCounter{s.totals, s.flushing} {
+		if total, ok := counters[id]; ok {
+			counter.Requests += total.Requests
+			counter.Bytes += total.Bytes
+		}
+	}
+	return
+}
+
+// List returns the counters of the store including the counts not flushed yet
+func (s *FileQuotaStore) List() (counters []QuotaCounter, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	merged := map[string]*QuotaCounter{}
+	mergeQuotaCounters(merged, s.totals)
+	mergeQuotaCounters(merged, s.flushing)
+	mergeQuotaCounters(merged, s.deltas)
+	for _, counter := range merged {
+		counters = append(counters, *counter)
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		return counters[i].id() < counters[j].id()
+	})
+	return
+}
+
+// Flush adds the pending counts to the file and reads back the totals. The requests are not blocked by the file
+// operations: the counts added meanwhile are kept for the next flush.
+func (s *FileQuotaStore) Flush() (err error) {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+	s.mutex.Lock()
+	deltas := s.deltas
+	s.flushing, s.deltas = deltas, map[string]*QuotaCounter{}
+	s.mutex.Unlock()
+	var totals map[string]*QuotaCounter
+	var unlock func()
+	if unlock, err = s.lock(); err == nil {
+		if totals, err = s.read(); err == nil {
+			mergeQuotaCounters(totals, deltas)
+			expired := quotaNow().Add(-s.retention)
+			for id, counter := range totals {
+				if counter.Updated.Before(expired) {
+					delete(totals, id)
+				}
+			}
+			err = s.write(totals)
+		}
+		unlock()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flushing = nil
+	if err == nil {
+		s.totals = totals
+	} else {
+		// keep the counts for the next flush
+		mergeQuotaCounters(s.deltas, deltas)
+	}
+	return
+}
+
+// Close stops the flushes and flushes the pending counts
+func (s *FileQuotaStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
+
+// run flushes the store at the interval until it is closed
+func (s *FileQuotaStore) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				logger.ErrorF("flushing the quota store %s failed: %v", s.path, err)
+			}
+		}
+	}
+}
+
+// read reads the counters of the file, none if it does not exist
+func (s *FileQuotaStore) read() (totals map[string]*QuotaCounter, err error) {
+	totals = map[string]*QuotaCounter{}
+	var data []byte
+	if data, err = os.ReadFile(s.path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	var counters []*QuotaCounter
+	if err = json.Unmarshal(data, &counters); err == nil {
+		for _, counter := range counters {
+			totals[counter.id()] = counter
+		}
+	}
+	return
+}
+
+// write replaces the file with the counters through a temporary file
+func (s *FileQuotaStore) write(totals map[string]*QuotaCounter) (err error) {
+	counters := make([]*QuotaCounter, 0, len(totals))
+	for _, counter := range totals {
+		counters = append(counters, counter)
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		return counters[i].id() < counters[j].id()
+	})
+	var data []byte
+	if data, err = json.MarshalIndent(counters, "", "  "); err != nil {
+		return
+	}
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp"); err != nil {
+		return
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return
+}
+
+// lock creates the lock file of the store, waiting for the other processes to remove theirs
+func (s *FileQuotaStore) lock() (unlock func(), err error) {
+	name := s.path + ".lock"
+	deadline := time.Now().Add(quotaLockTimeout)
+	for {
+		var f *os.File
+		if f, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600); err == nil {
+			ioutils.CloserFunc(f)
+			return func() { _ = os.Remove(name) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return
+		}
+		if info, statErr := os.Stat(name); statErr == nil && time.Since(info.ModTime()) > quotaLockStale {
+			logger.WarnF("removing the stale lock of the quota store %s", s.path)
+			_ = os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrQuotaLocked
+		}
+		time.Sleep(quotaLockRetry)
+	}
+}
+
+// mergeQuotaCounters adds the counters of src to dst
+func mergeQuotaCounters(dst, src map[string]*QuotaCounter) {
+	for id, counter := range src {
+		if total, ok := dst[id]; ok {
+			total.Requests += counter.Requests
+			total.Bytes += counter.Bytes
+			if counter.Updated.After(total.Updated) {
+				total.Updated = counter.Updated
+			}
+		} else {
+			c := *counter
+			dst[id] = &c
+		}
+	}
+}
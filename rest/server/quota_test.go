@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setQuotaNow sets the time of the quotas until the end of the test
+func setQuotaNow(t *testing.T, now *time.Time) {
+	quotaNow = func() time.Time { return *now }
+	t.Cleanup(func() { quotaNow = time.Now })
+}
+
+func quotaHandler(store QuotaStore, plans map[string]QuotaPlan) http.Handler {
+	return QuotaMiddleware(store, plans, func(r *http.Request) (string, string) {
+		return r.Header.Get("X-Api-Key"), r.Header.Get("X-Plan")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+}
+
+func quotaRequest(h http.Handler, key, plan string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Api-Key", key)
+	r.Header.Set("X-Plan", plan)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func newTestQuotaStore(t *testing.T) *FileQuotaStore {
+	store, err := NewFileQuotaStore(filepath.Join(t.TempDir(), "quota.json"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileQuotaStore() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestQuotaMiddleware_Headers(t *testing.T) {
+	now := time.Date(2026, 3, 31, 22, 30, 0, 0, time.UTC)
+	setQuotaNow(t, &now)
+	plans := map[string]QuotaPlan{
+		"free": {Period: QuotaDaily, Requests: 3},
+	}
+	h := quotaHandler(newTestQuotaStore(t), plans)
+	for i := 1; i <= 3; i++ {
+		w := quotaRequest(h, "k1", "free")
+		if w.Code != http.StatusOK || w.Header().Get(QuotaLimitHeader) != "3" ||
+			w.Header().Get(QuotaRemainingHeader) != strconv.Itoa(3-i) {
+			t.Errorf("request %d: got %d with %v", i, w.Code, w.Header())
+		}
+		if reset := w.Header().Get(QuotaResetHeader); reset != "1775001600" {
+			t.Errorf("got reset %s, want midnight of April 1st", reset)
+		}
+	}
+	w := quotaRequest(h, "k1", "free")
+	var body QuotaExceeded
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusTooManyRequests || body.Used != 3 || body.Limit != 3 || body.Plan != "free" ||
+		w.Header().Get(QuotaRemainingHeader) != "0" || w.Header().Get("Retry-After") != "5401" {
+		t.Errorf("got %d with %+v and %v", w.Code, body, w.Header())
+	}
+	// other keys and the requests without a plan are not affected
+	if w = quotaRequest(h, "k2", "free"); w.Code != http.StatusOK {
+		t.Errorf("got %d for another key", w.Code)
+	}
+	if w = quotaRequest(h, "k1", "unknown"); w.Code != http.StatusOK || w.Header().Get(QuotaLimitHeader) != "" {
+		t.Errorf("got %d with %v for an unknown plan", w.Code, w.Header())
+	}
+
+	// the daily quota rolls over at midnight in the time zone of the plan
+	now = now.Add(2 * time.Hour)
+	if w = quotaRequest(h, "k1", "free"); w.Code != http.StatusOK || w.Header().Get(QuotaRemainingHeader) != "2" {
+		t.Errorf("got %d with %v after the rollover", w.Code, w.Header())
+	}
+	tokyo := time.FixedZone("JST", 9*3600)
+	plans["tokyo"] = QuotaPlan{Period: QuotaMonthly, Requests: 1, Location: tokyo}
+	h = quotaHandler(newTestQuotaStore(t), plans)
+	now = time.Date(2026, 4, 30, 14, 59, 0, 0, time.UTC)
+	quotaRequest(h, "k3", "tokyo")
+	if w = quotaRequest(h, "k3", "tokyo"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("got %d, want the quota exhausted before midnight in Tokyo", w.Code)
+	}
+	now = now.Add(time.Minute)
+	if w = quotaRequest(h, "k3", "tokyo"); w.Code != http.StatusOK {
+		t.Errorf("got %d, want the quota rolled over at midnight in Tokyo", w.Code)
+	}
+}
+
+func TestQuotaMiddleware_Grace(t *testing.T) {
+	plans := map[string]QuotaPlan{
+		"pro":   {Period: QuotaMonthly, Requests: 10, Grace: 0.2},
+		"bytes": {Period: QuotaDaily, Requests: 100, Bytes: 25},
+	}
+	h := quotaHandler(newTestQuotaStore(t), plans)
+	for i := 1; i <= 13; i++ {
+		w := quotaRequest(h, "k", "pro")
+		if want := i <= 12; (w.Code == http.StatusOK) != want {
+			t.Errorf("request %d: got %d", i, w.Code)
+		}
+		if i > 10 && w.Header().Get(QuotaRemainingHeader) != "0" {
+			t.Errorf("request %d: got %s remaining in the grace", i, w.Header().Get(QuotaRemainingHeader))
+		}
+	}
+	// each response is 10 bytes: served while below 25 bytes
+	for i := 1; i <= 4; i++ {
+		w := quotaRequest(h, "k", "bytes")
+		if want := i <= 3; (w.Code == http.StatusOK) != want {
+			t.Errorf("request %d: got %d", i, w.Code)
+		}
+	}
+}
+
+func TestFileQuotaStore_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	// two stores share the file as two processes would
+	stores := make([]*FileQuotaStore, 2)
+	for i := range stores {
+		var err error
+		if stores[i], err = NewFileQuotaStore(path, 5*time.Millisecond); err != nil {
+			t.Fatalf("NewFileQuotaStore() error = %v", err)
+		}
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(store *FileQuotaStore) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := store.Add(QuotaCounter{Key: "k", Plan: "p", Period: "2026-10", Requests: 1, Bytes: 2}); err != nil {
+					t.Errorf("Add() error = %v", err)
+				}
+				if i%10 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(stores[g%2])
+	}
+	wg.Wait()
+	for _, store := range stores {
+		if err := store.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}
+	// the counts survive a restart
+	store, err := NewFileQuotaStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFileQuotaStore() error = %v", err)
+	}
+	defer store.Close()
+	counters, _ := store.List()
+	if len(counters) != 1 || counters[0].Requests != 2000 || counters[0].Bytes != 4000 {
+		t.Errorf("got %+v, want 2000 requests", counters)
+	}
+	counter, _ := store.Add(QuotaCounter{Key: "k", Period: "2026-10", Requests: 1})
+	if counter.Requests != 2001 {
+		t.Errorf("got %d requests", counter.Requests)
+	}
+}
+
+func TestCurrentQuotaUsage(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	setQuotaNow(t, &now)
+	plans := map[string]QuotaPlan{"free": {Period: QuotaDaily, Requests: 3}}
+	store := newTestQuotaStore(t)
+	h := quotaHandler(store, plans)
+	quotaRequest(h, "a", "free")
+	now = now.Add(24 * time.Hour)
+	quotaRequest(h, "b", "free")
+	quotaRequest(h, "b", "free")
+	usage, err := CurrentQuotaUsage(store, plans)
+	if err != nil || len(usage) != 1 || usage[0].Key != "b" || usage[0].Requests != 2 {
+		t.Errorf("got %+v, %v", usage, err)
+	}
+}
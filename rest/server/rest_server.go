@@ -189,7 +189,7 @@ func New(opts *Options) (rServer Server, err error) {
 		return
 	}
 	router := turbo.NewRouter()
-	router.AddCorsFilter(opts.Cors)
+	router.UseCORS(opts.Cors)
 
 	httpServer := &http.Server{
 		Handler:      router,
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"oss.nandlabs.io/golly/secrets"
+	"oss.nandlabs.io/golly/turbo"
+)
+
+// DefaultSecondFactorHeader is the default request header of the one-time password of SecondFactorMiddleware
+const DefaultSecondFactorHeader = "X-OTP-Code"
+
+var (
+	// ErrPrincipalMissing is the error of the requests reaching SecondFactorMiddleware without a verified principal
+	ErrPrincipalMissing = errors.New("the request is not authenticated")
+	// ErrSecondFactorMissing is the error of the requests without a one-time password
+	ErrSecondFactorMissing = errors.New("a one-time password is required")
+	// ErrSecondFactorInvalid is the error of the requests whose one-time password does not match or was already used
+	ErrSecondFactorInvalid = errors.New("the one-time password is invalid")
+)
+
+// secondFactorNow returns the time of the verifications, replaced by the tests
+var secondFactorNow = time.Now
+
+// secondFactorKey is the context key of the principal of a request verified by SecondFactorMiddleware
+type secondFactorKey struct{}
+
+// SecondFactorOptions configures SecondFactorMiddleware
+type SecondFactorOptions struct {
+	// Principal returns the principal verified by the first factor, such as the password authentication filter
+	// preceding SecondFactorMiddleware, or an empty string if the request is not authenticated.
+	Principal func(r *http.Request) string
+	// TOTP returns the TOTP of the principal. Configure it with a replay store so that a code is accepted once.
+	TOTP func(principal string) (*secrets.TOTP, error)
+	// Header is the request header carrying the one-time password. Defaults to X-OTP-Code.
+	Header string
+}
+
+// SecondFactorPrincipal returns the principal of a request verified by SecondFactorMiddleware, empty if none
+func SecondFactorPrincipal(r *http.Request) string {
+	principal, _ := r.Context().Value(secondFactorKey{}).(string)
+	return principal
+}
+
+// SecondFactorMiddleware returns a filter requiring the principal verified by the first factor to present a TOTP
+// code, to be added to the privileged routes after the authentication filter. The requests without a principal, a
+// code, or with a code that does not match are rejected with a 401. The principal of the verified requests is
+// available with SecondFactorPrincipal.
+func SecondFactorMiddleware(opts SecondFactorOptions) turbo.FilterFunc {
+	if opts.Header == "" {
+		opts.Header = DefaultSecondFactorHeader
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := verifySecondFactor(r, &opts)
+			if err != nil {
+				logger.DebugF("rejecting the request %s %s: %v", r.Method, r.URL.Path, err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), secondFactorKey{}, principal)))
+		})
+	}
+}
+
+// verifySecondFactor verifies the one-time password of the principal of the request
+func verifySecondFactor(r *http.Request, opts *SecondFactorOptions) (principal string, err error) {
+	if opts.Principal != nil {
+		principal = opts.Principal(r)
+	}
+	if principal == "" {
+		err = ErrPrincipalMissing
+		return
+	}
+	code := r.Header.Get(opts.Header)
+	if code == "" {
+		err = ErrSecondFactorMissing
+		return
+	}
+	var totp *secrets.TOTP
+	if totp, err = opts.TOTP(principal); err != nil || totp == nil || !totp.Verify(code, secondFactorNow()) {
+		err = ErrSecondFactorInvalid
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/secrets"
+)
+
+// secondFactorHandler returns a handler behind a stand-in password filter setting the X-User header as the
+// principal, and SecondFactorMiddleware with a TOTP for alice
+func secondFactorHandler(t *testing.T) (http.Handler, *secrets.TOTP) {
+	totp, err := secrets.NewTOTP([]byte("12345678901234567890"),
+		secrets.TOTPOptions{Skew: 1, Replay: secrets.NewMemOTPReplayStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := SecondFactorMiddleware(SecondFactorOptions{
+		Principal: func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+		TOTP: func(principal string) (*secrets.TOTP, error) {
+			if principal == "alice" {
+				return totp, nil
+			}
+			return nil, errors.New("not enrolled")
+		},
+	})
+	return filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(SecondFactorPrincipal(r)))
+	})), totp
+}
+
+func TestSecondFactorMiddleware(t *testing.T) {
+	now := time.Now()
+	secondFactorNow = func() time.Time { return now }
+	t.Cleanup(func() { secondFactorNow = time.Now })
+	h, totp := secondFactorHandler(t)
+	code := totp.Generate(now)
+	tests := []struct {
+		name   string
+		user   string
+		code   string
+		status int
+		body   string
+	}{
+		{name: "Unauthenticated", code: code, status: http.StatusUnauthorized, body: ErrPrincipalMissing.Error()},
+		{name: "MissingCode", user: "alice", status: http.StatusUnauthorized, body: ErrSecondFactorMissing.Error()},
+		{name: "NotEnrolled", user: "bob", code: code, status: http.StatusUnauthorized,
+			body: ErrSecondFactorInvalid.Error()},
+		{name: "WrongCode", user: "alice", code: "000000", status: http.StatusUnauthorized,
+			body: ErrSecondFactorInvalid.Error()},
+		{name: "Verified", user: "alice", code: code, status: http.StatusOK, body: "alice"},
+		{name: "Replayed", user: "alice", code: code, status: http.StatusUnauthorized,
+			body: ErrSecondFactorInvalid.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin", nil)
+			if tt.user != "" {
+				r.Header.Set("X-User", tt.user)
+			}
+			if tt.code != "" {
+				r.Header.Set(DefaultSecondFactorHeader, tt.code)
+			}
+			w := serve(h, r)
+			if w.Code != tt.status || w.Body.String() != tt.body && w.Body.String() != tt.body+"\n" {
+				t.Errorf("status = %d, body = %q, want %d %q", w.Code, w.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
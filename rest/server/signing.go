@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/turbo"
+)
+
+const (
+	defaultSignatureSkew        = 5 * time.Minute
+	defaultSignatureNonces      = 100000
+	defaultSignatureMaxBodySize = 10 * 1024 * 1024
+)
+
+var (
+	// ErrSignatureMissing is the error of the requests without the signature headers
+	ErrSignatureMissing = errors.New("the request is not signed")
+	// ErrSignatureStale is the error of the requests signed outside the allowed clock skew
+	ErrSignatureStale = errors.New("the signature timestamp is outside the allowed skew")
+	// ErrSignatureInvalid is the error of the requests whose signature does not match
+	ErrSignatureInvalid = errors.New("the signature is invalid")
+	// ErrSignatureReplayed is the error of the requests with a nonce already used
+	ErrSignatureReplayed = errors.New("the signature nonce was already used")
+)
+
+// signingNow returns the time of the verifications, replaced by the tests
+var signingNow = time.Now
+
+// signatureKeyIdKey is the context key of the key id of a verified request
+type signatureKeyIdKey struct{}
+
+// SignatureOptions configures SignatureVerificationMiddleware
+type SignatureOptions struct {
+	// MaxSkew is the largest difference allowed between the signature timestamp and the time of the server.
+	// Defaults to 5 minutes.
+	MaxSkew time.Duration
+	// NonceCacheSize is the number of nonces remembered to reject the replays. The nonces are remembered for twice
+	// the MaxSkew, after which the requests are rejected as stale; once the cache is full the oldest nonces are
+	// forgotten early. Defaults to 100000.
+	NonceCacheSize int
+	// MaxBodySize is the largest body verified, the larger requests are rejected with a 413. Defaults to 10MiB.
+	MaxBodySize int64
+}
+
+// SignatureKeyId returns the key id of the signature of a request verified by SignatureVerificationMiddleware
+func SignatureKeyId(r *http.Request) string {
+	keyId, _ := r.Context().Value(signatureKeyIdKey{}).(string)
+	return keyId
+}
+
+// SignatureVerificationMiddleware returns a filter verifying the HMAC signatures of the requests made by the rest
+// client with SignRequests. keyResolver returns the key of a key id; to rotate the keys of a service resolve both the
+// old and the new key ids until the clients have moved. The requests that are not signed, signed with an unknown key,
+// outside the clock skew, replayed or with a signature that does not match are rejected with a 401. The key id of
+// the verified requests is available with SignatureKeyId.
+func SignatureVerificationMiddleware(keyResolver func(keyId string) ([]byte, error),
+	opts *SignatureOptions) turbo.FilterFunc {
+	o := SignatureOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxSkew <= 0 {
+		o.MaxSkew = defaultSignatureSkew
+	}
+	if o.NonceCacheSize <= 0 {
+		o.NonceCacheSize = defaultSignatureNonces
+	}
+	if o.MaxBodySize <= 0 {
+		o.MaxBodySize = defaultSignatureMaxBodySize
+	}
+	nonces := newNonceCache(o.NonceCacheSize, 2*o.MaxSkew)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyId, status, err := verifySignature(r, keyResolver, &o, nonces)
+			if err != nil {
+				logger.DebugF("rejecting the request %s %s: %v", r.Method, r.URL.Path, err)
+				http.Error(w, err.Error(), status)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signatureKeyIdKey{}, keyId)))
+		})
+	}
+}
+
+// verifySignature verifies the signature of the request and replaces its body with the verified one
+func verifySignature(r *http.Request, keyResolver func(keyId string) ([]byte, error), o *SignatureOptions,
+	nonces *nonceCache) (keyId string, status int, err error) {
+	status = http.StatusUnauthorized
+	keyId = r.Header.Get(rest.SignatureKeyIdHeader)
+	signature := r.Header.Get(rest.SignatureHeader)
+	nonce := r.Header.Get(rest.SignatureNonceHeader)
+	if keyId == "" || signature == "" || nonce == "" {
+		err = ErrSignatureMissing
+		return
+	}
+	var timestamp int64
+	if timestamp, err = strconv.ParseInt(r.Header.Get(rest.SignatureTimestampHeader), 10, 64); err != nil {
+		err = ErrSignatureMissing
+		return
+	}
+	now := signingNow()
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > o.MaxSkew || skew < -o.MaxSkew {
+		err = ErrSignatureStale
+		return
+	}
+	var key []byte
+	if key, err = keyResolver(keyId); err != nil || len(key) == 0 {
+		err = ErrSignatureInvalid
+		return
+	}
+	bodySha256 := rest.EmptyBodySha256
+	if r.Body != nil && r.Body != http.NoBody {
+		var data []byte
+		data, err = io.ReadAll(io.LimitReader(r.Body, o.MaxBodySize+1))
+		_ = r.Body.Close()
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+		if int64(len(data)) > o.MaxBodySize {
+			status, err = http.StatusRequestEntityTooLarge, errors.New("the body is too large to verify")
+			return
+		}
+		bodySha256 = rest.BodySha256(data)
+		r.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	if declared := r.Header.Get(rest.ContentSha256Header); declared != "" && declared != bodySha256 {
+		err = ErrSignatureInvalid
+		return
+	}
+	expected, _ := base64.StdEncoding.DecodeString(
+		rest.SignCanonical(key, rest.CanonicalString(r.Method, r.URL, r.Header, bodySha256)))
+	actual, decodeErr := base64.StdEncoding.DecodeString(signature)
+	if decodeErr != nil || !hmac.Equal(expected, actual) {
+		err = ErrSignatureInvalid
+		return
+	}
+	// the nonces are remembered only for the valid signatures so that the forged requests cannot fill the cache
+	if !nonces.add(keyId+":"+nonce, now) {
+		err = ErrSignatureReplayed
+	}
+	return
+}
+
+// nonceCache remembers the nonces for a time, forgetting the oldest first once full
+type nonceCache struct {
+	mutex  sync.Mutex
+	size   int
+	ttl    time.Duration
+	seen   map[string]time.Time
+	order  []string
+	oldest int
+}
+
+// newNonceCache creates a new nonceCache
+func newNonceCache(size int, ttl time.Duration) *nonceCache {
+	return &nonceCache{size: size, ttl: ttl, seen: make(map[string]time.Time, size), order: make([]string, 0, size)}
+}
+
+// add remembers the nonce and returns false if it was already seen within the ttl
+func (nc *nonceCache) add(nonce string, now time.Time) bool {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	if at, ok := nc.seen[nonce]; ok && now.Sub(at) < nc.ttl {
+		return false
+	}
+	// forget the expired nonces, and the oldest one when full
+	for len(nc.seen) > 0 {
+		first := nc.order[nc.oldest]
+		if now.Sub(nc.seen[first]) < nc.ttl && len(nc.seen) < nc.size {
+			break
+		}
+		delete(nc.seen, first)
+		nc.oldest++
+	}
+	if nc.oldest > len(nc.order)/2 {
+		nc.order = append(nc.order[:0], nc.order[nc.oldest:]...)
+		nc.oldest = 0
+	}
+	nc.seen[nonce] = now
+	nc.order = append(nc.order, nonce)
+	return true
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/rest/client"
+)
+
+// signingVector is a signed request of rest/testdata/signing_vectors.json, shared with the rest and client tests
+type signingVector struct {
+	Name    string      `json:"name"`
+	Method  string      `json:"method"`
+	Url     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	Key     string      `json:"key"`
+}
+
+func loadSigningVectors(t *testing.T) (vectors []signingVector) {
+	data, err := os.ReadFile("../testdata/signing_vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// request returns the request of the vector with the changes applied to a copy of its headers
+func (v *signingVector) request(change func(r *http.Request)) *http.Request {
+	r := httptest.NewRequest(v.Method, v.Url, strings.NewReader(v.Body))
+	for name, values := range v.Headers {
+		r.Header[name] = append([]string{}, values...)
+	}
+	if change != nil {
+		change(r)
+	}
+	return r
+}
+
+// setSigningNow sets the time of the verifications until the end of the test
+func setSigningNow(t *testing.T, now *time.Time) {
+	signingNow = func() time.Time { return *now }
+	t.Cleanup(func() { signingNow = time.Now })
+}
+
+// signedHandler returns a handler verifying the signatures with the keys of the vectors
+func signedHandler(vectors []signingVector, opts *SignatureOptions) http.Handler {
+	keys := map[string]string{}
+	for _, v := range vectors {
+		keys[v.Headers.Get(rest.SignatureKeyIdHeader)] = v.Key
+	}
+	return SignatureVerificationMiddleware(func(keyId string) ([]byte, error) {
+		if key, ok := keys[keyId]; ok {
+			return []byte(key), nil
+		}
+		return nil, errors.New("unknown key")
+	}, opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(SignatureKeyId(r) + ":" + string(b)))
+	}))
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestSignatureVerification_Vectors(t *testing.T) {
+	vectors := loadSigningVectors(t)
+	var now time.Time
+	setSigningNow(t, &now)
+	for _, v := range vectors {
+		timestamp, _ := strconv.ParseInt(v.Headers.Get(rest.SignatureTimestampHeader), 10, 64)
+		now = time.Unix(timestamp, 0)
+		h := signedHandler(vectors, nil)
+		w := serve(h, v.request(nil))
+		if want := v.Headers.Get(rest.SignatureKeyIdHeader) + ":" + v.Body; w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got %d %q, want %q", v.Name, w.Code, w.Body.String(), want)
+		}
+
+		// every element of the canonical string is signed
+		tampers := map[string]func(r *http.Request){
+			"method": func(r *http.Request) { r.Method = "DELETE" },
+			"path":   func(r *http.Request) { r.URL.Path += "x"; r.URL.RawPath = "" },
+			"query":  func(r *http.Request) { r.URL.RawQuery += "&z=1" },
+			"body": func(r *http.Request) {
+				r.Body = io.NopCloser(strings.NewReader(v.Body + "x"))
+			},
+			"timestamp": func(r *http.Request) {
+				r.Header.Set(rest.SignatureTimestampHeader, strconv.FormatInt(timestamp+1, 10))
+			},
+			"nonce":          func(r *http.Request) { r.Header.Set(rest.SignatureNonceHeader, "other") },
+			"signed headers": func(r *http.Request) { r.Header.Set(rest.SignatureHeadersHeader, "x-other") },
+			"key id":         func(r *http.Request) { r.Header.Set(rest.SignatureKeyIdHeader, "unknown") },
+			"signature":      func(r *http.Request) { r.Header.Set(rest.SignatureHeader, "AAAA") },
+		}
+		if v.Headers.Get(rest.SignatureHeadersHeader) != "" {
+			tampers["header"] = func(r *http.Request) { r.Header.Set("X-Tenant", "other") }
+		}
+		for name, tamper := range tampers {
+			if w = serve(signedHandler(vectors, nil), v.request(tamper)); w.Code != http.StatusUnauthorized {
+				t.Errorf("%s: got %d with a tampered %s", v.Name, w.Code, name)
+			}
+		}
+	}
+}
+
+func TestSignatureVerification_SkewAndReplay(t *testing.T) {
+	vectors := loadSigningVectors(t)
+	v := vectors[0]
+	timestamp, _ := strconv.ParseInt(v.Headers.Get(rest.SignatureTimestampHeader), 10, 64)
+	signed := time.Unix(timestamp, 0)
+	var now time.Time
+	setSigningNow(t, &now)
+	opts := &SignatureOptions{MaxSkew: time.Minute}
+	for _, tt := range []struct {
+		offset time.Duration
+		status int
+	}{
+		{-time.Minute - time.Second, http.StatusUnauthorized},
+		{-time.Minute, http.StatusOK},
+		{time.Minute, http.StatusOK},
+		{time.Minute + time.Second, http.StatusUnauthorized},
+	} {
+		now = signed.Add(tt.offset)
+		if w := serve(signedHandler(vectors, opts), v.request(nil)); w.Code != tt.status {
+			t.Errorf("got %d with a skew of %v, want %d", w.Code, tt.offset, tt.status)
+		}
+	}
+
+	now = signed
+	h := signedHandler(vectors, opts)
+	if w := serve(h, v.request(nil)); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	w := serve(h, v.request(nil))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), ErrSignatureReplayed.Error()) {
+		t.Errorf("got %d %q, want the replay rejected", w.Code, w.Body.String())
+	}
+
+	// the bounded cache forgets the oldest nonces first
+	cache := newNonceCache(2, time.Minute)
+	for _, nonce := range []string{"a", "b", "c"} {
+		cache.add(nonce, now)
+	}
+	if !cache.add("a", now) || cache.add("c", now) {
+		t.Error("the oldest nonce is not forgotten first")
+	}
+	if !cache.add("c", now.Add(time.Minute)) {
+		t.Error("the expired nonce is not forgotten")
+	}
+
+	// the declared hash of a streamed body must match the body
+	stream := vectors[2]
+	timestamp, _ = strconv.ParseInt(stream.Headers.Get(rest.SignatureTimestampHeader), 10, 64)
+	now = time.Unix(timestamp, 0)
+	if w = serve(signedHandler(vectors, nil), stream.request(func(r *http.Request) {
+		r.Body = io.NopCloser(strings.NewReader("other"))
+	})); w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d with a body not matching its declared hash", w.Code)
+	}
+	if w = serve(signedHandler(vectors, &SignatureOptions{MaxBodySize: 4}), stream.request(nil)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d with a body over the limit", w.Code)
+	}
+	if w = serve(signedHandler(vectors, nil), httptest.NewRequest(http.MethodGet, "/", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d without a signature", w.Code)
+	}
+}
+
+func TestSignatureVerification_RoundTrip(t *testing.T) {
+	// two key ids of the same service are accepted during a rotation
+	keys := map[string][]byte{"orders-2025": []byte("old secret"), "orders-2026": []byte("new secret")}
+	server := httptest.NewServer(SignatureVerificationMiddleware(func(keyId string) ([]byte, error) {
+		if key, ok := keys[keyId]; ok {
+			return key, nil
+		}
+		return nil, errors.New("unknown key")
+	}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(SignatureKeyId(r) + " " + r.URL.Query().Get("q") + " " + strings.TrimSpace(string(b))))
+	})))
+	defer server.Close()
+
+	for keyId, key := range keys {
+		c := client.NewClient().SignRequests(client.SigningOptions{KeyId: keyId, Key: key,
+			Headers: []string{rest.ContentTypeHeader}})
+		req := c.NewRequest(server.URL+"/orders/42?q=a+b&q=c", http.MethodPost).
+			SetContentType(rest.JSONContentType).
+			SetBody(map[string]string{"item": "widget"})
+		res, err := c.Execute(req)
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		b, _ := io.ReadAll(res.Raw().Body)
+		if res.StatusCode() != http.StatusOK || string(b) != keyId+` a b {"item":"widget"}` {
+			t.Errorf("got %d %q", res.StatusCode(), b)
+		}
+	}
+
+	c := client.NewClient().SignRequests(client.SigningOptions{KeyId: "orders-2026", Key: []byte("wrong")})
+	res, err := c.Execute(c.NewRequest(server.URL, http.MethodGet))
+	if err != nil || res.StatusCode() != http.StatusUnauthorized {
+		t.Errorf("got %v, %v with a wrong key", res, err)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/messaging"
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/uuid"
+)
+
+// WebhookManagementRoutes adds the routes managing the subscribers of the dispatcher under the prefix:
+//
+//	GET    {prefix}/subscribers                   lists the subscribers
+//	POST   {prefix}/subscribers                   adds a subscriber, its id is generated if empty
+//	GET    {prefix}/subscribers/:id               returns a subscriber
+//	PUT    {prefix}/subscribers/:id               replaces a subscriber
+//	DELETE {prefix}/subscribers/:id               removes a subscriber
+//	GET    {prefix}/subscribers/:id/deliveries    lists the delivery logs of a subscriber
+//	GET    {prefix}/subscribers/:id/dead-letters  lists the dead lettered deliveries of a subscriber
+//	POST   {prefix}/deliveries/:id/redeliver      retries a dead lettered delivery
+//
+// The secrets of the subscribers are never returned. The routes should be protected by the filters of the server.
+func WebhookManagementRoutes(server Server, prefix string, dispatcher *messaging.WebhookDispatcher) (err error) {
+	routes := &webhookRoutes{dispatcher: dispatcher}
+	for _, r := range []struct {
+		method  string
+		path    string
+		handler HandlerFunc
+	}{
+		{http.MethodGet, "/subscribers", routes.list},
+		{http.MethodPost, "/subscribers", routes.create},
+		{http.MethodGet, "/subscribers/:id", routes.get},
+		{http.MethodPut, "/subscribers/:id", routes.put},
+		{http.MethodDelete, "/subscribers/:id", routes.delete},
+		{http.MethodGet, "/subscribers/:id/deliveries", routes.deliveries},
+		{http.MethodGet, "/subscribers/:id/dead-letters", routes.deadLetters},
+		{http.MethodPost, "/deliveries/:id/redeliver", routes.redeliver},
+	} {
+		if _, err = server.AddRoute(prefix+r.path, r.handler, r.method); err != nil {
+			return
+		}
+	}
+	return
+}
+
+type webhookRoutes struct {
+	dispatcher *messaging.WebhookDispatcher
+}
+
+func (wr *webhookRoutes) list(ctx Context) {
+	subscribers, err := wr.dispatcher.Store().List()
+	if err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	for _, subscriber := range subscribers {
+		subscriber.Secret = ""
+	}
+	_ = ctx.WriteJSON(subscribers)
+}
+
+func (wr *webhookRoutes) create(ctx Context) {
+	subscriber := &messaging.Subscriber{}
+	if err := ctx.Read(subscriber); err != nil {
+		http.Error(ctx.HttpResWriter(), err.Error(), http.StatusBadRequest)
+		return
+	}
+	if subscriber.Id == "" {
+		id, err := uuid.V4()
+		if err != nil {
+			writeWebhookError(ctx, err)
+			return
+		}
+		subscriber.Id = id.String()
+	} else if _, err := wr.dispatcher.Store().Get(subscriber.Id); err == nil {
+		http.Error(ctx.HttpResWriter(), "the subscriber "+subscriber.Id+" exists", http.StatusConflict)
+		return
+	}
+	wr.save(ctx, subscriber, http.StatusCreated)
+}
+
+func (wr *webhookRoutes) get(ctx Context) {
+	id, _ := ctx.GetParam("id", PathParam)
+	subscriber, err := wr.dispatcher.Store().Get(id)
+	if err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	subscriber.Secret = ""
+	_ = ctx.WriteJSON(subscriber)
+}
+
+// put replaces the subscriber. The secret is kept if the request does not set one.
+func (wr *webhookRoutes) put(ctx Context) {
+	id, _ := ctx.GetParam("id", PathParam)
+	current, err := wr.dispatcher.Store().Get(id)
+	if err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	subscriber := &messaging.Subscriber{}
+	if err = ctx.Read(subscriber); err != nil {
+		http.Error(ctx.HttpResWriter(), err.Error(), http.StatusBadRequest)
+		return
+	}
+	subscriber.Id = id
+	if subscriber.Secret == "" {
+		subscriber.Secret = current.Secret
+	}
+	wr.save(ctx, subscriber, http.StatusOK)
+}
+
+func (wr *webhookRoutes) save(ctx Context, subscriber *messaging.Subscriber, status int) {
+	if err := wr.dispatcher.Store().Put(subscriber); err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	subscriber.Secret = ""
+	ctx.SetHeader(rest.ContentTypeHeader, ioutils.MimeApplicationJSON)
+	ctx.SetStatusCode(status)
+	_ = jsonCodec.Write(subscriber, ctx.HttpResWriter())
+}
+
+func (wr *webhookRoutes) delete(ctx Context) {
+	id, _ := ctx.GetParam("id", PathParam)
+	if err := wr.dispatcher.Store().Delete(id); err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	ctx.SetStatusCode(http.StatusNoContent)
+}
+
+func (wr *webhookRoutes) deliveries(ctx Context) {
+	id, _ := ctx.GetParam("id", PathParam)
+	if _, err := wr.dispatcher.Store().Get(id); err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	logs := wr.dispatcher.Deliveries(id)
+	if logs == nil {
+		logs = []messaging.DeliveryLog{}
+	}
+	_ = ctx.WriteJSON(logs)
+}
+
+func (wr *webhookRoutes) deadLetters(ctx Context) {
+	id, _ := ctx.GetParam("id", PathParam)
+	if _, err := wr.dispatcher.Store().Get(id); err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	records := wr.dispatcher.DeadLetters(id)
+	if records == nil {
+		records = []messaging.WebhookDeadLetter{}
+	}
+	_ = ctx.WriteJSON(records)
+}
+
+func (wr *webhookRoutes) redeliver(ctx Context) {
+	id, _ := ctx.GetParam("id", PathParam)
+	if err := wr.dispatcher.Redeliver(id); err != nil {
+		writeWebhookError(ctx, err)
+		return
+	}
+	ctx.SetStatusCode(http.StatusAccepted)
+}
+
+// writeWebhookError writes the status of the error of the webhook management
+func writeWebhookError(ctx Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, messaging.ErrSubscriberNotFound), errors.Is(err, messaging.ErrDeliveryNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, messaging.ErrInvalidSubscriber):
+		status = http.StatusBadRequest
+	}
+	http.Error(ctx.HttpResWriter(), err.Error(), status)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/messaging"
+)
+
+// TestWebhookManagementRoutes manages a subscriber through the routes, lets its deliveries fail until they are dead
+// lettered and redelivers one once the receiver verifying the signatures is fixed.
+func TestWebhookManagementRoutes(t *testing.T) {
+	var healthy atomic.Bool
+	var received atomic.Int32
+	receiver := httptest.NewServer(SignatureVerificationMiddleware(func(keyId string) ([]byte, error) {
+		if keyId != "billing" {
+			return nil, errors.New("unknown key")
+		}
+		return []byte("s3cret"), nil
+	}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		event := &messaging.WebhookEvent{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil || event.Type != "invoice.paid" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received.Add(1)
+	})))
+	defer receiver.Close()
+
+	source, _ := url.Parse("chan://webhook-routes")
+	dispatcher, err := messaging.NewWebhookDispatcher(messaging.GetManager(), messaging.NewMemSubscriberStore(),
+		messaging.DispatcherOptions{Source: source, RetryTiers: []time.Duration{time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dispatcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer dispatcher.Stop()
+	srv, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WebhookManagementRoutes(srv, "/webhooks", dispatcher); err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(srv.Router())
+	defer api.Close()
+
+	call := func(method, path, body string, wantStatus int) string {
+		t.Helper()
+		req, _ := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		if res.StatusCode != wantStatus {
+			t.Fatalf("%s %s = %d %s, want %d", method, path, res.StatusCode, data, wantStatus)
+		}
+		return string(data)
+	}
+
+	call(http.MethodPost, "/webhooks/subscribers", `{"id":"billing","url":"ftp://x","secret":"s3cret"}`,
+		http.StatusBadRequest)
+	created := call(http.MethodPost, "/webhooks/subscribers",
+		`{"id":"billing","url":"`+receiver.URL+`","secret":"s3cret","event_types":["invoice.*"],"active":true}`,
+		http.StatusCreated)
+	if strings.Contains(created, "s3cret") {
+		t.Errorf("the secret is returned: %s", created)
+	}
+	call(http.MethodPost, "/webhooks/subscribers", `{"id":"billing","url":"http://x","secret":"s"}`,
+		http.StatusConflict)
+	// the secret is kept when the subscriber is replaced without one
+	call(http.MethodPut, "/webhooks/subscribers/billing",
+		`{"url":"`+receiver.URL+`","event_types":["invoice.paid"],"active":true}`, http.StatusOK)
+	if got := call(http.MethodGet, "/webhooks/subscribers", "", http.StatusOK); !strings.Contains(got,
+		`"event_types":["invoice.paid"]`) || strings.Contains(got, "s3cret") {
+		t.Errorf("subscribers = %s", got)
+	}
+	call(http.MethodGet, "/webhooks/subscribers/unknown", "", http.StatusNotFound)
+
+	event, _ := messaging.NewWebhookEvent("invoice.paid", map[string]int{"amount": 10})
+	if err = messaging.PublishWebhookEvent(messaging.GetManager(), source, event); err != nil {
+		t.Fatal(err)
+	}
+	var dead []messaging.WebhookDeadLetter
+	for deadline := time.Now().Add(5 * time.Second); len(dead) == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the delivery was not dead lettered")
+		}
+		_ = json.Unmarshal([]byte(call(http.MethodGet, "/webhooks/subscribers/billing/dead-letters", "",
+			http.StatusOK)), &dead)
+	}
+	if dead[0].Attempts != 2 || dead[0].Event.Id != event.Id {
+		t.Errorf("dead letter = %+v", dead[0])
+	}
+
+	healthy.Store(true)
+	call(http.MethodPost, "/webhooks/deliveries/unknown/redeliver", "", http.StatusNotFound)
+	call(http.MethodPost, "/webhooks/deliveries/"+dead[0].DeliveryId+"/redeliver", "", http.StatusAccepted)
+	var logs []messaging.DeliveryLog
+	for deadline := time.Now().Add(5 * time.Second); len(logs) < 3; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the delivery was not redelivered")
+		}
+		_ = json.Unmarshal([]byte(call(http.MethodGet, "/webhooks/subscribers/billing/deliveries", "",
+			http.StatusOK)), &logs)
+	}
+	if logs[2].Status != messaging.DeliverySucceeded || logs[2].Attempt != 1 || received.Load() != 1 {
+		t.Errorf("deliveries = %+v, received %d", logs, received.Load())
+	}
+	if got := call(http.MethodGet, "/webhooks/subscribers/billing/dead-letters", "", http.StatusOK); got != "[]\n" {
+		t.Errorf("dead letters = %s", got)
+	}
+
+	call(http.MethodDelete, "/webhooks/subscribers/billing", "", http.StatusNoContent)
+	call(http.MethodDelete, "/webhooks/subscribers/billing", "", http.StatusNotFound)
+}
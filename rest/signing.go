@@ -0,0 +1,139 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// The request signing scheme shared by the client and the server.
+//
+// The signer sends the headers
+//
+//	X-Signature-Key-Id:    the id of the key
+//	X-Signature-Timestamp: the time of the signature, in seconds since the epoch
+//	X-Signature-Nonce:     a random value unique to the request
+//	X-Signature-Headers:   the lower case names of the signed headers, sorted and separated by ';', may be empty
+//	X-Content-Sha256:      the hex SHA-256 of the body, optional
+//	X-Signature:           the base64 HMAC-SHA256 of the canonical string with the key
+//
+// The canonical string is made of the following lines separated by '\n', the last line not followed by one:
+//
+//	the upper case method
+//	the escaped path of the url, '/' if empty
+//	the canonical query
+//	one 'name:value' line for each signed header, in the order of X-Signature-Headers, the values of a repeated
+//	header trimmed and joined with ','
+//	the value of X-Signature-Headers
+//	the timestamp
+//	the nonce
+//	the hex SHA-256 of the body, of the empty string for an empty body
+//
+// The canonical query is the list of the 'key=value' query parameters sorted by key and then by value, each key and
+// value decoded and then escaped as in url.QueryEscape with the spaces as '%20', joined with '&'. The repeated
+// parameters are all kept; a parameter without a value is 'key='.
+//
+// The body hash is the X-Content-Sha256 header when present, so that a streamed body can be signed with a hash
+// computed beforehand; the server then checks the hash against the body it reads.
+const (
+	// SignatureHeader is the header of the signature of the request
+	SignatureHeader = "X-Signature"
+	// SignatureKeyIdHeader is the header of the id of the signing key
+	SignatureKeyIdHeader = "X-Signature-Key-Id"
+	// SignatureTimestampHeader is the header of the time of the signature in seconds since the epoch
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	// SignatureNonceHeader is the header of the nonce of the signature
+	SignatureNonceHeader = "X-Signature-Nonce"
+	// SignatureHeadersHeader is the header of the names of the signed headers
+	SignatureHeadersHeader = "X-Signature-Headers"
+	// ContentSha256Header is the header of the hex SHA-256 of the body
+	ContentSha256Header = "X-Content-Sha256"
+	// EmptyBodySha256 is the hex SHA-256 of an empty body
+	EmptyBodySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// SignedHeaderNames returns the value of the X-Signature-Headers header for the names
+func SignedHeaderNames(names []string) string {
+	lower := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			lower = append(lower, name)
+		}
+	}
+	sort.Strings(lower)
+	return strings.Join(lower, ";")
+}
+
+// CanonicalQuery returns the canonical form of the raw query
+func CanonicalQuery(rawQuery string) string {
+	values, _ := url.ParseQuery(rawQuery)
+	params := make([]string, 0, len(values))
+	for key, vals := range values {
+		for _, v := range vals {
+			params = append(params, escapeCanonical(key)+"="+escapeCanonical(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// escapeCanonical escapes the query component with the spaces as %20
+func escapeCanonical(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// CanonicalString returns the string signed for the request, see the signing scheme. The signature headers must be
+// set, the body hash is the hex SHA-256 of the body.
+func CanonicalString(method string, u *url.URL, header http.Header, bodySha256 string) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToUpper(method))
+	sb.WriteByte('\n')
+	path := u.EscapedPath()
+	if path == "" {
+		path = PathSeparator
+	}
+	sb.WriteString(path)
+	sb.WriteByte('\n')
+	sb.WriteString(CanonicalQuery(u.RawQuery))
+	sb.WriteByte('\n')
+	signed := header.Get(SignatureHeadersHeader)
+	if signed != "" {
+		for _, name := range strings.Split(signed, ";") {
+			values := header.Values(name)
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.TrimSpace(v)
+			}
+			sb.WriteString(name)
+			sb.WriteByte(':')
+			sb.WriteString(strings.Join(trimmed, ","))
+			sb.WriteByte('\n')
+		}
+	}
+	sb.WriteString(signed)
+	sb.WriteByte('\n')
+	sb.WriteString(header.Get(SignatureTimestampHeader))
+	sb.WriteByte('\n')
+	sb.WriteString(header.Get(SignatureNonceHeader))
+	sb.WriteByte('\n')
+	sb.WriteString(bodySha256)
+	return sb.String()
+}
+
+// SignCanonical returns the base64 HMAC-SHA256 of the canonical string with the key
+func SignCanonical(key []byte, canonical string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(canonical))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// BodySha256 returns the hex SHA-256 of the body
+func BodySha256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
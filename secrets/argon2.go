@@ -0,0 +1,227 @@
+package secrets
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sync"
+)
+
+const (
+	// argon2Version is the version 1.3 of Argon2
+	argon2Version = 0x13
+	// argon2idType is the type of Argon2id in the hashes
+	argon2idType = 2
+	// argon2SyncPoints is the number of slices of the lanes
+	argon2SyncPoints = 4
+	// argon2BlockWords is the number of 64 bits words of the blocks of 1 KiB
+	argon2BlockWords = 128
+)
+
+// argon2Block is a block of the memory of Argon2
+type argon2Block [argon2BlockWords]uint64
+
+// argon2idKey derives a key of keyLen bytes from the password with Argon2id as specified by RFC 9106, with the time
+// passes over the memory of KiB, filled by threads lanes. The secret and the data are the optional key and
+// associated data of the specification.
+func argon2idKey(password, salt, secret, data []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	lanes := uint32(threads)
+	h0 := argon2InitHash(password, salt, secret, data, time, memory, lanes, keyLen)
+	// the number of blocks is a multiple of 4 blocks per lane, of at least 8 blocks per lane
+	memory = memory / (argon2SyncPoints * lanes) * (argon2SyncPoints * lanes)
+	if memory < 2*argon2SyncPoints*lanes {
+		memory = 2 * argon2SyncPoints * lanes
+	}
+	blocks := make([]argon2Block, memory)
+	laneLength := memory / lanes
+	var input [72]byte
+	copy(input[:], h0)
+	var buf [1024]byte
+	for lane := uint32(0); lane < lanes; lane++ {
+		binary.LittleEndian.PutUint32(input[68:], lane)
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(input[64:], i)
+			argon2Hash(buf[:], input[:])
+			for w := range blocks[lane*laneLength+i] {
+				blocks[lane*laneLength+i][w] = binary.LittleEndian.Uint64(buf[w*8:])
+			}
+		}
+	}
+	argon2Fill(blocks, time, memory, lanes)
+
+	// the final block is the xor of the last blocks of the lanes
+	final := blocks[laneLength-1]
+	for lane := uint32(1); lane < lanes; lane++ {
+		for w, v := range blocks[lane*laneLength+laneLength-1] {
+			final[w] ^= v
+		}
+	}
+	for w, v := range final {
+		binary.LittleEndian.PutUint64(buf[w*8:], v)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, buf[:])
+	return key
+}
+
+// argon2InitHash returns the hash H0 of the parameters and the inputs
+func argon2InitHash(password, salt, secret, data []byte, time, memory, lanes, keyLen uint32) []byte {
+	var params [24]byte
+	binary.LittleEndian.PutUint32(params[0:], lanes)
+	binary.LittleEndian.PutUint32(params[4:], keyLen)
+	binary.LittleEndian.PutUint32(params[8:], memory)
+	binary.LittleEndian.PutUint32(params[12:], time)
+	binary.LittleEndian.PutUint32(params[16:], argon2Version)
+	binary.LittleEndian.PutUint32(params[20:], argon2idType)
+	input := [][]byte{params[:]}
+	for _, b := range [][]byte{password, salt, secret, data} {
+		input = append(input, binary.LittleEndian.AppendUint32(nil, uint32(len(b))), b)
+	}
+	return blake2bSum(64, input...)
+}
+
+// argon2Hash fills the out with the variable length hash H' of the input
+func argon2Hash(out, input []byte) {
+	size := binary.LittleEndian.AppendUint32(nil, uint32(len(out)))
+	if len(out) <= 64 {
+		copy(out, blake2bSum(len(out), size, input))
+		return
+	}
+	v := blake2bSum(64, size, input)
+	n := copy(out, v[:32])
+	for len(out)-n > 64 {
+		v = blake2bSum(64, v)
+		n += copy(out[n:], v[:32])
+	}
+	copy(out[n:], blake2bSum(len(out)-n, v))
+}
+
+// argon2Fill makes the passes over the blocks, the lanes of each slice being filled concurrently
+func argon2Fill(blocks []argon2Block, time, memory, lanes uint32) {
+	laneLength := memory / lanes
+	segmentLength := laneLength / argon2SyncPoints
+	fillSegment := func(pass, slice, lane uint32, wg *sync.WaitGroup) {
+		defer wg.Done()
+		var addresses, input, zero argon2Block
+		// Argon2id addresses the blocks independently of the password in the first half of the first pass
+		independent := pass == 0 && slice < argon2SyncPoints/2
+		if independent {
+			input[0], input[1], input[2] = uint64(pass), uint64(lane), uint64(slice)
+			input[3], input[4], input[5] = uint64(memory), uint64(time), argon2idType
+		}
+		index := uint32(0)
+		if pass == 0 && slice == 0 {
+			// the first two blocks are set from H0
+			index = 2
+			input[6]++
+			argon2Compress(&addresses, &input, &zero, false)
+			argon2Compress(&addresses, &addresses, &zero, false)
+		}
+		offset := lane*laneLength + slice*segmentLength + index
+		for ; index < segmentLength; index, offset = index+1, offset+1 {
+			prev := offset - 1
+			if index == 0 && slice == 0 {
+				prev += laneLength
+			}
+			var random uint64
+			if independent {
+				if index%argon2BlockWords == 0 {
+					input[6]++
+					argon2Compress(&addresses, &input, &zero, false)
+					argon2Compress(&addresses, &addresses, &zero, false)
+				}
+				random = addresses[index%argon2BlockWords]
+			} else {
+				random = blocks[prev][0]
+			}
+			ref := argon2RefIndex(random, laneLength, segmentLength, lanes, pass, slice, lane, index)
+			argon2Compress(&blocks[offset], &blocks[prev], &blocks[ref], true)
+		}
+	}
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			var wg sync.WaitGroup
+			for lane := uint32(0); lane < lanes; lane++ {
+				wg.Add(1)
+				go fillSegment(pass, slice, lane, &wg)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// argon2RefIndex returns the index of the block referenced by the block of the index in the segment
+func argon2RefIndex(random uint64, laneLength, segmentLength, lanes, pass, slice, lane, index uint32) uint32 {
+	refLane := uint32(random>>32) % lanes
+	if pass == 0 && slice == 0 {
+		refLane = lane
+	}
+	// the area of the blocks that can be referenced, starting after the current segment in the next passes
+	area, start := 3*segmentLength, ((slice+1)%argon2SyncPoints)*segmentLength
+	if lane == refLane {
+		area += index
+	}
+	if pass == 0 {
+		area, start = slice*segmentLength, 0
+		if slice == 0 || lane == refLane {
+			area += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		area--
+	}
+	x := random & 0xffffffff
+	x = (x * x) >> 32
+	x = (uint64(area) * x) >> 32
+	return refLane*laneLength + uint32((uint64(start)+uint64(area)-(x+1))%uint64(laneLength))
+}
+
+// argon2Compress sets, or xors in the next passes, the out with the compression G of the blocks x and y
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r, q argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	q = r
+	// the rows of 16 words, then the columns of 2 words of each row
+	for i := 0; i < argon2BlockWords; i += 16 {
+		argon2Permute(&q, i, i+1, i+2, i+3, i+4, i+5, i+6, i+7, i+8, i+9, i+10, i+11, i+12, i+13, i+14, i+15)
+	}
+	for i := 0; i < 16; i += 2 {
+		argon2Permute(&q, i, i+1, i+16, i+17, i+32, i+33, i+48, i+49, i+64, i+65, i+80, i+81, i+96, i+97, i+112,
+			i+113)
+	}
+	for i := range q {
+		if xor {
+			out[i] ^= r[i] ^ q[i]
+		} else {
+			out[i] = r[i] ^ q[i]
+		}
+	}
+}
+
+// argon2Permute applies the BlaMka round of BLAKE2b to the 16 words of the block at the indexes
+func argon2Permute(b *argon2Block, i ...int) {
+	g := func(a, c, d, e int) {
+		b[a] = argon2Mix(b[a], b[c])
+		b[e] = bits.RotateLeft64(b[e]^b[a], -32)
+		b[d] = argon2Mix(b[d], b[e])
+		b[c] = bits.RotateLeft64(b[c]^b[d], -24)
+		b[a] = argon2Mix(b[a], b[c])
+		b[e] = bits.RotateLeft64(b[e]^b[a], -16)
+		b[d] = argon2Mix(b[d], b[e])
+		b[c] = bits.RotateLeft64(b[c]^b[d], -63)
+	}
+	g(i[0], i[4], i[8], i[12])
+	g(i[1], i[5], i[9], i[13])
+	g(i[2], i[6], i[10], i[14])
+	g(i[3], i[7], i[11], i[15])
+	g(i[0], i[5], i[10], i[15])
+	g(i[1], i[6], i[11], i[12])
+	g(i[2], i[7], i[8], i[13])
+	g(i[3], i[4], i[9], i[14])
+}
+
+// argon2Mix is the addition of BlaMka, with the product of the low 32 bits of the words
+func argon2Mix(x, y uint64) uint64 {
+	return x + y + 2*uint64(uint32(x))*uint64(uint32(y))
+}
@@ -14,6 +14,8 @@ const (
 //
 // Value is an array of bytes to hold decrypted value of a secret.
 
+// Created specifies the time the credential was first written to its store.
+//
 // LastUpdated specifies the last updated time of the credential.
 //
 // Version string specifies the current version of the credential. If the credential type/Store implementation
@@ -24,6 +26,7 @@ const (
 
 type Credential struct {
 	Value       []byte
+	Created     time.Time
 	LastUpdated time.Time
 	Version     string
 	MetaData    map[string]interface{}
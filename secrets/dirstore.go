@@ -0,0 +1,96 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	DirStoreProvider = "dirStore"
+)
+
+// dirStore keeps each credential in a plain file of a directory named by its key, such as the secrets of
+// Kubernetes mounted as a volume.
+type dirStore struct {
+	dir string
+}
+
+// NewDirStore creates a Store of the files of the directory. The files starting with a dot, such as the ..data
+// links of Kubernetes, are ignored. The LastUpdated of the credentials is the modification time of their file, and
+// the files are written readable by the owner only.
+func NewDirStore(dir string) (s Store, err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(dir); err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s is not a directory", dir)
+	}
+	if err == nil {
+		s = &dirStore{dir: dir}
+	}
+	return
+}
+
+func (ds *dirStore) Get(key string, ctx context.Context) (cred *Credential, err error) {
+	if err = validateKey(key); err != nil {
+		return
+	}
+	name := filepath.Join(ds.dir, key)
+	var info os.FileInfo
+	var value []byte
+	if info, err = os.Stat(name); err == nil && info.IsDir() {
+		err = fs.ErrNotExist
+	}
+	if err == nil {
+		value, err = os.ReadFile(name)
+	}
+	if err == nil {
+		cred = &Credential{Value: value, LastUpdated: info.ModTime()}
+	} else if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	return
+}
+
+func (ds *dirStore) Write(key string, credential *Credential, ctx context.Context) (err error) {
+	if err = validateKey(key); err == nil {
+		err = writeFileAtomic(filepath.Join(ds.dir, key), credential.Value)
+	}
+	return
+}
+
+func (ds *dirStore) Delete(key string, ctx context.Context) (err error) {
+	if err = validateKey(key); err != nil {
+		return
+	}
+	if err = os.Remove(filepath.Join(ds.dir, key)); errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	return
+}
+
+func (ds *dirStore) List(ctx context.Context) (keys []string, err error) {
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(ds.dir); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		// the links of Kubernetes are followed
+		if info, statErr := os.Stat(filepath.Join(ds.dir, entry.Name())); statErr == nil && !info.IsDir() {
+			keys = append(keys, entry.Name())
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (ds *dirStore) Provider() string {
+	return DirStoreProvider
+}
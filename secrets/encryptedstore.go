@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EncryptedFileStoreProvider = "encryptedFileStore"
+)
+
+// encryptedStoreData is the additional data authenticated with the content of the encrypted store files
+var encryptedStoreData = []byte("golly.secrets.v1")
+
+// ErrStoreDecryption is the error of an encrypted store file that cannot be decrypted with the master key
+var ErrStoreDecryption = errors.New("unable to decrypt the store")
+
+// encryptedFileStore keeps all the credentials in a single file encrypted with AES-GCM
+type encryptedFileStore struct {
+	credentials map[string]*Credential
+	storeFile   string
+	aead        cipher.AEAD
+	mutex       sync.RWMutex
+}
+
+// NewEncryptedFileStore creates a Store of the credentials of the file, encrypted with AES-GCM with the master key
+// of 16, 24 or 32 bytes, see MasterKeyFromEnv and MasterKeyFromFile. The file is created on the first write. The
+// store keeps the Created time of the credentials, sets their LastUpdated time and numbers their Version from 1 on
+// each write, and rewrites the whole file atomically on each change.
+func NewEncryptedFileStore(storeFile string, masterKey []byte) (s Store, err error) {
+	store := &encryptedFileStore{
+		credentials: make(map[string]*Credential),
+		storeFile:   storeFile,
+	}
+	if _, err = gcmAlgorithm(masterKey); err == nil {
+		store.aead, err = newGCM(masterKey)
+	}
+	if err != nil {
+		return
+	}
+	var content []byte
+	if content, err = os.ReadFile(storeFile); errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err == nil {
+		err = store.decrypt(content)
+	}
+	if err == nil {
+		s = store
+	}
+	return
+}
+
+// MasterKeyFromEnv returns the master key encoded with base64 in the environment variable
+func MasterKeyFromEnv(name string) (key []byte, err error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("the environment variable %s of the master key is not set", name)
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+}
+
+// MasterKeyFromFile returns the master key encoded with base64 in the file
+func MasterKeyFromFile(name string) (key []byte, err error) {
+	var content []byte
+	if content, err = os.ReadFile(name); err == nil {
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	}
+	return
+}
+
+func (es *encryptedFileStore) Get(key string, ctx context.Context) (cred *Credential, err error) {
+	es.mutex.RLock()
+	defer es.mutex.RUnlock()
+	if v, ok := es.credentials[key]; ok {
+		cred = copyCredential(v)
+	} else {
+		err = fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	return
+}
+
+func (es *encryptedFileStore) Write(key string, credential *Credential, ctx context.Context) (err error) {
+	if key == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidSecretName, key)
+	}
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	now := time.Now()
+	stored := copyCredential(credential)
+	stored.Created, stored.LastUpdated, stored.Version = now, now, "1"
+	previous, exists := es.credentials[key]
+	if exists {
+		version, _ := strconv.Atoi(previous.Version)
+		stored.Created, stored.Version = previous.Created, strconv.Itoa(version+1)
+	}
+	es.credentials[key] = stored
+	if err = es.save(); err != nil {
+		if exists {
+			es.credentials[key] = previous
+		} else {
+			delete(es.credentials, key)
+		}
+	}
+	return
+}
+
+func (es *encryptedFileStore) Delete(key string, ctx context.Context) (err error) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	previous, ok := es.credentials[key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	delete(es.credentials, key)
+	if err = es.save(); err != nil {
+		es.credentials[key] = previous
+	}
+	return
+}
+
+func (es *encryptedFileStore) List(ctx context.Context) (keys []string, err error) {
+	es.mutex.RLock()
+	defer es.mutex.RUnlock()
+	for key := range es.credentials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (es *encryptedFileStore) Provider() string {
+	return EncryptedFileStoreProvider
+}
+
+// decrypt loads the credentials of the content of the store file, the nonce followed by the encrypted gob
+func (es *encryptedFileStore) decrypt(content []byte) (err error) {
+	nonceSize := es.aead.NonceSize()
+	if len(content) < nonceSize {
+		return fmt.Errorf("%w: %s is too short", ErrStoreDecryption, es.storeFile)
+	}
+	var plaintext []byte
+	if plaintext, err = es.aead.Open(nil, content[:nonceSize], content[nonceSize:], encryptedStoreData); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrStoreDecryption, es.storeFile, err)
+	}
+	return gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&es.credentials)
+}
+
+// save writes the credentials to the store file. The caller must hold the lock.
+func (es *encryptedFileStore) save() (err error) {
+	var b bytes.Buffer
+	if err = gob.NewEncoder(&b).Encode(es.credentials); err != nil {
+		return
+	}
+	nonce := make([]byte, es.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err == nil {
+		err = writeFileAtomic(es.storeFile, es.aead.Seal(nonce, nonce, b.Bytes(), encryptedStoreData))
+	}
+	return
+}
+
+// copyCredential returns a copy of the credential with a shallow copy of its metadata
+func copyCredential(c *Credential) *Credential {
+	copied := *c
+	copied.Value = bytes.Clone(c.Value)
+	copied.MetaData = maps.Clone(c.MetaData)
+	return &copied
+}
@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	EnvStoreProvider = "envStore"
+)
+
+// envStore reads the credentials from the environment variables. The key is mapped to the variable of the prefix
+// followed by the key in upper case, with the characters other than letters, digits and _ replaced by _, e.g.
+// db.password is APP_DB_PASSWORD for the prefix APP_.
+type envStore struct {
+	prefix string
+}
+
+// NewEnvStore creates a Store of the environment variables of the prefix. The credentials have no metadata, and
+// List returns the names of the variables without the prefix.
+func NewEnvStore(prefix string) Store {
+	return &envStore{prefix: prefix}
+}
+
+// envName returns the name of the environment variable of the key
+func (es *envStore) envName(key string) string {
+	return es.prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func (es *envStore) Get(key string, ctx context.Context) (cred *Credential, err error) {
+	if key == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSecretName, key)
+	}
+	if v, ok := os.LookupEnv(es.envName(key)); ok {
+		cred = &Credential{Value: []byte(v)}
+	} else {
+		err = fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	return
+}
+
+func (es *envStore) Write(key string, credential *Credential, ctx context.Context) (err error) {
+	if key == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidSecretName, key)
+	}
+	return os.Setenv(es.envName(key), credential.Str())
+}
+
+func (es *envStore) Delete(key string, ctx context.Context) (err error) {
+	name := es.envName(key)
+	if _, ok := os.LookupEnv(name); !ok || key == "" {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	return os.Unsetenv(name)
+}
+
+func (es *envStore) List(ctx context.Context) (keys []string, err error) {
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if key, ok := strings.CutPrefix(name, es.prefix); ok && key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (es *envStore) Provider() string {
+	return EnvStoreProvider
+}
@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"oss.nandlabs.io/golly/codec"
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// FieldKeyring is a codec.FieldEncryptor using AES-GCM. Values are encrypted with the active key and decrypted
+// with the key named by the key id of the envelope, so documents encrypted before a rotation stay readable
+// as long as the old key is kept in the keyring.
+type FieldKeyring struct {
+	mutex  sync.RWMutex
+	active string
+	keys   map[string][]byte
+}
+
+// NewFieldKeyring creates a FieldKeyring with the key as the active key.
+// The key must be of 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+func NewFieldKeyring(keyId string, key []byte) (keyring *FieldKeyring, err error) {
+	keyring = &FieldKeyring{keys: make(map[string][]byte)}
+	if err = keyring.Rotate(keyId, key); err != nil {
+		keyring = nil
+	}
+	return
+}
+
+// AddKey adds a key for decrypting values without making it the active key
+func (k *FieldKeyring) AddKey(keyId string, key []byte) (err error) {
+	if _, err = gcmAlgorithm(key); err == nil {
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+		k.keys[keyId] = append([]byte{}, key...)
+	}
+	return
+}
+
+// Rotate adds the key and makes it the active key. The previous keys are kept for decrypting.
+func (k *FieldKeyring) Rotate(keyId string, key []byte) (err error) {
+	if err = k.AddKey(keyId, key); err == nil {
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+		k.active = keyId
+	}
+	return
+}
+
+// RemoveKey removes the key. Values encrypted with the key can no longer be decrypted.
+func (k *FieldKeyring) RemoveKey(keyId string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	delete(k.keys, keyId)
+	if k.active == keyId {
+		k.active = ""
+	}
+}
+
+// ActiveKeyId returns the id of the key used to encrypt
+func (k *FieldKeyring) ActiveKeyId() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.active
+}
+
+func (k *FieldKeyring) Encrypt(plaintext []byte) (envelope *codec.FieldEnvelope, err error) {
+	var aead cipher.AEAD
+	k.mutex.RLock()
+	keyId, key := k.active, k.keys[k.active]
+	k.mutex.RUnlock()
+	if key == nil {
+		err = fmt.Errorf("%w: no active key", ErrKeyNotFound)
+		return
+	}
+	envelope = &codec.FieldEnvelope{Version: codec.FieldEnvelopeVersion, KeyId: keyId}
+	envelope.Algorithm, err = gcmAlgorithm(key)
+	if err == nil {
+		aead, err = newGCM(key)
+		if err == nil {
+			envelope.Nonce = make([]byte, aead.NonceSize())
+			if _, err = io.ReadFull(rand.Reader, envelope.Nonce); err == nil {
+				envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, plaintext, envelope.AdditionalData())
+			}
+		}
+	}
+	if err != nil {
+		envelope = nil
+	}
+	return
+}
+
+func (k *FieldKeyring) Decrypt(envelope *codec.FieldEnvelope) (plaintext []byte, err error) {
+	var aead cipher.AEAD
+	var algorithm string
+	k.mutex.RLock()
+	key := k.keys[envelope.KeyId]
+	k.mutex.RUnlock()
+	if key == nil {
+		err = fmt.Errorf("%w: %s", ErrKeyNotFound, envelope.KeyId)
+		return
+	}
+	algorithm, err = gcmAlgorithm(key)
+	if err == nil && algorithm != envelope.Algorithm {
+		err = fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, envelope.Algorithm)
+	}
+	if err == nil {
+		aead, err = newGCM(key)
+		if err == nil {
+			if len(envelope.Nonce) != aead.NonceSize() {
+				err = errors.New("invalid nonce size")
+				return
+			}
+			plaintext, err = aead.Open(nil, envelope.Nonce, envelope.Ciphertext, envelope.AdditionalData())
+		}
+	}
+	return
+}
+
+// gcmAlgorithm returns the name of the algorithm for the size of the key
+func gcmAlgorithm(key []byte) (algorithm string, err error) {
+	switch len(key) {
+	case 16, 24, 32:
+		algorithm = fmt.Sprintf("A%dGCM", len(key)*8)
+	default:
+		err = aes.KeySizeError(len(key))
+	}
+	return
+}
+
+func newGCM(key []byte) (aead cipher.AEAD, err error) {
+	var block cipher.Block
+	block, err = aes.NewCipher(key)
+	if err == nil {
+		aead, err = cipher.NewGCM(block)
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/codec"
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+type jobParams struct {
+	Name  string `json:"name" yaml:"name"`
+	Token string `json:"token" yaml:"token" encrypt:"true"`
+}
+
+func keyringCodec(t *testing.T, contentType string, keyring *FieldKeyring) codec.Codec {
+	c, err := codec.Get(contentType, map[string]interface{}{codec.FieldEncryptorOpt: keyring})
+	assert.NoError(t, err)
+	return c
+}
+
+func TestFieldKeyring_Rotation(t *testing.T) {
+	for _, contentType := range []string{ioutils.MimeApplicationJSON, ioutils.MimeTextYAML} {
+		t.Run(contentType, func(t *testing.T) {
+			keyring, err := NewFieldKeyring("v1", []byte("0123456789abcdef0123456789abcdef"))
+			assert.NoError(t, err)
+			c := keyringCodec(t, contentType, keyring)
+			old, err := c.EncodeToString(jobParams{Name: "job", Token: "s3cr3t"})
+			assert.NoError(t, err)
+			assert.True(t, strings.Contains(old, "$enc$v1$A256GCM$v1$"))
+			assert.False(t, strings.Contains(old, "s3cr3t"))
+
+			assert.NoError(t, keyring.Rotate("v2", []byte("fedcba9876543210")))
+			assert.Equal(t, "v2", keyring.ActiveKeyId())
+			current, err := c.EncodeToString(jobParams{Name: "job", Token: "n3w"})
+			assert.NoError(t, err)
+			assert.True(t, strings.Contains(current, "$enc$v1$A128GCM$v2$"))
+
+			// documents encrypted with the old key stay readable
+			var params jobParams
+			assert.NoError(t, c.DecodeString(old, &params))
+			assert.Equal(t, "s3cr3t", params.Token)
+			assert.NoError(t, c.DecodeString(current, &params))
+			assert.Equal(t, "n3w", params.Token)
+
+			keyring.RemoveKey("v1")
+			err = c.DecodeString(old, &params)
+			assert.True(t, errors.Is(err, codec.ErrFieldDecryption))
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+		})
+	}
+}
+
+func TestFieldKeyring_Tamper(t *testing.T) {
+	keyring, err := NewFieldKeyring("v1", []byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	assert.NoError(t, keyring.AddKey("v2", []byte("fedcba9876543210")))
+	envelope, err := keyring.Encrypt([]byte("payload"))
+	assert.NoError(t, err)
+	plaintext, err := keyring.Decrypt(envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", string(plaintext))
+
+	tests := []struct {
+		name   string
+		tamper func(e *codec.FieldEnvelope)
+	}{
+		{name: "ciphertext", tamper: func(e *codec.FieldEnvelope) { e.Ciphertext[0] ^= 0xff }},
+		{name: "nonce", tamper: func(e *codec.FieldEnvelope) { e.Nonce[0] ^= 0xff }},
+		{name: "truncated", tamper: func(e *codec.FieldEnvelope) { e.Ciphertext = e.Ciphertext[:len(e.Ciphertext)-1] }},
+		{name: "key id", tamper: func(e *codec.FieldEnvelope) { e.KeyId = "v2" }},
+		{name: "algorithm", tamper: func(e *codec.FieldEnvelope) { e.Algorithm = "A256GCM" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered, err := codec.ParseFieldEnvelope(envelope.String())
+			assert.NoError(t, err)
+			tt.tamper(tampered)
+			_, err = keyring.Decrypt(tampered)
+			assert.Error(t, err)
+		})
+	}
+
+	// a tampered document fails to decode
+	c := keyringCodec(t, ioutils.MimeApplicationJSON, keyring)
+	encoded, err := c.EncodeToString(jobParams{Name: "job", Token: "s3cr3t"})
+	assert.NoError(t, err)
+	i := strings.LastIndex(encoded, "$") + 2
+	flipped := "A"
+	if encoded[i] == 'A' {
+		flipped = "B"
+	}
+	var params jobParams
+	err = c.DecodeString(encoded[:i]+flipped+encoded[i+1:], &params)
+	assert.True(t, errors.Is(err, codec.ErrFieldDecryption))
+}
+
+func TestFieldKeyring_InvalidKey(t *testing.T) {
+	_, err := NewFieldKeyring("v1", []byte("short"))
+	assert.Error(t, err)
+	keyring := &FieldKeyring{keys: map[string][]byte{}}
+	_, err = keyring.Encrypt([]byte("payload"))
+	assert.True(t, errors.Is(err, ErrKeyNotFound))
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
 )
 
@@ -54,7 +55,7 @@ func (ls *localStore) Get(key string, ctx context.Context) (cred *Credential, er
 	if v, ok := ls.credentials[key]; ok {
 		cred = v
 	} else {
-		err = fmt.Errorf("Unable to find a credential with key %s", key)
+		err = fmt.Errorf("%w: unable to find a credential with key %s", ErrSecretNotFound, key)
 	}
 
 	return
@@ -64,6 +65,33 @@ func (ls *localStore) Write(key string, credential *Credential, ctx context.Cont
 	ls.mutex.Lock()
 	defer ls.mutex.Unlock()
 	ls.credentials[key] = credential
+	err = ls.save()
+	return
+}
+
+func (ls *localStore) Delete(key string, ctx context.Context) (err error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	if _, ok := ls.credentials[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+	}
+	delete(ls.credentials, key)
+	err = ls.save()
+	return
+}
+
+func (ls *localStore) List(ctx context.Context) (keys []string, err error) {
+	ls.mutex.RLock()
+	defer ls.mutex.RUnlock()
+	for key := range ls.credentials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// save writes the credentials to the store file. The caller must hold the lock.
+func (ls *localStore) save() (err error) {
 	var b = &bytes.Buffer{}
 	var encodedData []byte
 	encoder := gob.NewEncoder(b)
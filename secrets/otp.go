@@ -0,0 +1,402 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTPAlgorithm is the HMAC algorithm of the one-time passwords
+type OTPAlgorithm string
+
+const (
+	// OTPSha1 is the HMAC-SHA1 algorithm, the default of the authenticator apps
+	OTPSha1 OTPAlgorithm = "SHA1"
+	// OTPSha256 is the HMAC-SHA256 algorithm
+	OTPSha256 OTPAlgorithm = "SHA256"
+	// OTPSha512 is the HMAC-SHA512 algorithm
+	OTPSha512 OTPAlgorithm = "SHA512"
+)
+
+const (
+	// DefaultOTPDigits is the default number of digits of the one-time passwords
+	DefaultOTPDigits = 6
+	// DefaultTOTPPeriod is the default time step of the time based one-time passwords
+	DefaultTOTPPeriod = 30 * time.Second
+	// DefaultHOTPLookAhead is the default number of counters checked after the counter of a HOTP
+	DefaultHOTPLookAhead = 10
+	// DefaultOTPSecretSize is the default number of bytes of the secrets generated by NewOTPSecret
+	DefaultOTPSecretSize = 20
+)
+
+var ErrInvalidOTPDigits = errors.New("the one-time passwords have 6 to 8 digits")
+var ErrInvalidOTPURI = errors.New("invalid otpauth uri")
+
+// otpBase32 is the unpadded base32 encoding of the secrets of the otpauth uris
+var otpBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// OTPReplayStore remembers the one-time passwords already used so that they are not accepted twice
+type OTPReplayStore interface {
+	// Use marks the key as used until the expiry. It returns false if the key is already used.
+	Use(key string, expiry time.Time) bool
+}
+
+// MemOTPReplayStore is an OTPReplayStore keeping the used keys in memory until they expire
+type MemOTPReplayStore struct {
+	mutex sync.Mutex
+	used  map[string]time.Time
+	now   func() time.Time
+}
+
+// NewMemOTPReplayStore creates an empty MemOTPReplayStore
+func NewMemOTPReplayStore() *MemOTPReplayStore {
+	return &MemOTPReplayStore{used: make(map[string]time.Time), now: time.Now}
+}
+
+// Use marks the key as used until the expiry. The expired keys are removed.
+func (s *MemOTPReplayStore) Use(key string, expiry time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	for k, e := range s.used {
+		if !e.After(now) {
+			delete(s.used, k)
+		}
+	}
+	if _, used := s.used[key]; used {
+		return false
+	}
+	s.used[key] = expiry
+	return true
+}
+
+// TOTPOptions configures a TOTP
+type TOTPOptions struct {
+	// Period is the time step of the passwords, in whole seconds. Defaults to 30 seconds.
+	Period time.Duration
+	// Digits is the number of digits of the passwords, 6 to 8. Defaults to 6.
+	Digits int
+	// Algorithm is the HMAC algorithm. Defaults to OTPSha1.
+	Algorithm OTPAlgorithm
+	// Skew is the number of time steps accepted before and after the current one to allow for the clock drift.
+	// 0 accepts only the current step.
+	Skew int
+	// Replay rejects the passwords already used when set
+	Replay OTPReplayStore
+}
+
+// TOTP generates and verifies the time based one-time passwords of RFC 6238
+type TOTP struct {
+	secret []byte
+	opts   TOTPOptions
+	newMac func() hash.Hash
+	// replayKey identifies the secret in the replay store without revealing it
+	replayKey string
+}
+
+// NewTOTP creates a TOTP of the secret
+func NewTOTP(secret []byte, opts TOTPOptions) (totp *TOTP, err error) {
+	if opts.Period < time.Second {
+		opts.Period = DefaultTOTPPeriod
+	}
+	var newMac func() hash.Hash
+	if newMac, opts.Algorithm, opts.Digits, err = otpParams(opts.Algorithm, opts.Digits); err == nil {
+		sum := sha256.Sum256(secret)
+		totp = &TOTP{
+			secret:    append([]byte{}, secret...),
+			opts:      opts,
+			newMac:    newMac,
+			replayKey: hex.EncodeToString(sum[:8]),
+		}
+	}
+	return
+}
+
+// Generate returns the password of the time step of the time
+func (t *TOTP) Generate(at time.Time) string {
+	return otpCode(t.newMac, t.secret, t.counter(at), t.opts.Digits)
+}
+
+// Verify checks the code against the passwords of the time steps within the skew of the time. All the steps are
+// compared in constant time. With a replay store a code is accepted only once.
+func (t *TOTP) Verify(code string, at time.Time) bool {
+	current := t.counter(at)
+	matched := int64(-1)
+	for i := -t.opts.Skew; i <= t.opts.Skew; i++ {
+		counter := int64(current) + int64(i)
+		if counter < 0 {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(otpCode(t.newMac, t.secret, uint64(counter), t.opts.Digits)),
+			[]byte(code)) == 1 {
+			matched = counter
+		}
+	}
+	if matched < 0 {
+		return false
+	}
+	if t.opts.Replay != nil {
+		// the step is used until it is out of the skew window
+		expiry := time.Unix(0, 0).Add(time.Duration(matched+int64(t.opts.Skew)+1) * t.opts.Period)
+		return t.opts.Replay.Use(t.replayKey+":"+strconv.FormatInt(matched, 10), expiry)
+	}
+	return true
+}
+
+// counter returns the time step of the time
+func (t *TOTP) counter(at time.Time) uint64 {
+	if at.Unix() < 0 {
+		return 0
+	}
+	return uint64(at.Unix()) / uint64(t.opts.Period/time.Second)
+}
+
+// HOTP generates and verifies the counter based one-time passwords of RFC 4226
+type HOTP struct {
+	// Digits is the number of digits of the passwords, 6 to 8. Defaults to 6.
+	Digits int
+	// Algorithm is the HMAC algorithm. Defaults to OTPSha1.
+	Algorithm OTPAlgorithm
+	// LookAhead is the number of counters checked after the counter to resynchronize with a token whose counter
+	// moved ahead. Defaults to 10.
+	LookAhead int
+	mutex     sync.Mutex
+	secret    []byte
+	counter   uint64
+}
+
+// NewHOTP creates a HOTP of the secret expecting the password of the counter next
+func NewHOTP(secret []byte, counter uint64) *HOTP {
+	return &HOTP{
+		Digits:    DefaultOTPDigits,
+		Algorithm: OTPSha1,
+		LookAhead: DefaultHOTPLookAhead,
+		secret:    append([]byte{}, secret...),
+		counter:   counter,
+	}
+}
+
+// Counter returns the counter of the next expected password
+func (h *HOTP) Counter() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.counter
+}
+
+// Generate returns the password of the counter
+func (h *HOTP) Generate(counter uint64) (code string, err error) {
+	var newMac func() hash.Hash
+	var digits int
+	if newMac, _, digits, err = otpParams(h.Algorithm, h.Digits); err == nil {
+		code = otpCode(newMac, h.secret, counter, digits)
+	}
+	return
+}
+
+// Verify checks the code against the passwords of the counter and the LookAhead next counters. On a match the
+// counter moves past the matched one so that the code and the skipped ones are not accepted again.
+func (h *HOTP) Verify(code string) bool {
+	newMac, _, digits, err := otpParams(h.Algorithm, h.Digits)
+	if err != nil {
+		return false
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	matched, found := uint64(0), false
+	for i := 0; i <= max(h.LookAhead, 0); i++ {
+		counter := h.counter + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(otpCode(newMac, h.secret, counter, digits)), []byte(code)) == 1 &&
+			!found {
+			matched, found = counter, true
+		}
+	}
+	if found {
+		h.counter = matched + 1
+	}
+	return found
+}
+
+// otpParams returns the HMAC of the algorithm and the digits, with their defaults
+func otpParams(algorithm OTPAlgorithm, digits int) (newMac func() hash.Hash, alg OTPAlgorithm, d int, err error) {
+	alg, d = algorithm, digits
+	if alg == "" {
+		alg = OTPSha1
+	}
+	if d == 0 {
+		d = DefaultOTPDigits
+	}
+	switch OTPAlgorithm(strings.ToUpper(string(alg))) {
+	case OTPSha1:
+		newMac = sha1.New
+	case OTPSha256:
+		newMac = sha256.New
+	case OTPSha512:
+		newMac = sha512.New
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+		return
+	}
+	if d < 6 || d > 8 {
+		err = ErrInvalidOTPDigits
+	}
+	return
+}
+
+// otpCode returns the password of the counter using the dynamic truncation of RFC 4226
+func otpCode(newMac func() hash.Hash, secret []byte, counter uint64, digits int) string {
+	mac := hmac.New(newMac, secret)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// NewOTPSecret generates a random secret of the number of bytes, DefaultOTPSecretSize if not positive
+func NewOTPSecret(bytes int) (secret []byte, err error) {
+	if bytes <= 0 {
+		bytes = DefaultOTPSecretSize
+	}
+	secret = make([]byte, bytes)
+	if _, err = rand.Read(secret); err != nil {
+		secret = nil
+	}
+	return
+}
+
+// EncodeOTPSecret encodes the secret in the unpadded base32 of the authenticator apps
+func EncodeOTPSecret(secret []byte) string {
+	return otpBase32.EncodeToString(secret)
+}
+
+// DecodeOTPSecret decodes a base32 secret. The case, the spaces, the hyphens and the padding, whether missing or
+// complete, are ignored as the secrets are often typed in or copied from various sources.
+func DecodeOTPSecret(encoded string) ([]byte, error) {
+	encoded = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '=' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(encoded))
+	return otpBase32.DecodeString(encoded)
+}
+
+// OTPKey holds the parameters of the otpauth uri of a one-time password, used to enroll an authenticator app with a
+// QR code
+type OTPKey struct {
+	// Type is totp or hotp
+	Type string
+	// Issuer is the service the account belongs to
+	Issuer string
+	// Account is the name of the account, such as an email
+	Account string
+	// Secret is the shared secret
+	Secret []byte
+	// Algorithm is the HMAC algorithm. Omitted from the uri if empty.
+	Algorithm OTPAlgorithm
+	// Digits is the number of digits. Omitted from the uri if 0.
+	Digits int
+	// Period is the time step of a totp. Omitted from the uri if 0.
+	Period time.Duration
+	// Counter is the initial counter of a hotp
+	Counter uint64
+}
+
+// URI returns the otpauth uri of the key
+func (k *OTPKey) URI() string {
+	label := url.PathEscape(k.Account)
+	if k.Issuer != "" {
+		label = url.PathEscape(k.Issuer) + ":" + label
+	}
+	params := url.Values{}
+	params.Set("secret", EncodeOTPSecret(k.Secret))
+	if k.Issuer != "" {
+		params.Set("issuer", k.Issuer)
+	}
+	if k.Algorithm != "" {
+		params.Set("algorithm", string(k.Algorithm))
+	}
+	if k.Digits != 0 {
+		params.Set("digits", strconv.Itoa(k.Digits))
+	}
+	if k.Type == "hotp" {
+		params.Set("counter", strconv.FormatUint(k.Counter, 10))
+	} else if k.Period != 0 {
+		params.Set("period", strconv.Itoa(int(k.Period/time.Second)))
+	}
+	return "otpauth://" + k.Type + "/" + label + "?" + strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+// ParseOTPURI parses an otpauth uri. The issuer of the parameters takes precedence over the one of the label.
+func ParseOTPURI(uri string) (key *OTPKey, err error) {
+	var u *url.URL
+	if u, err = url.Parse(uri); err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidOTPURI, err)
+		return
+	}
+	if u.Scheme != "otpauth" || (u.Host != "totp" && u.Host != "hotp") {
+		err = fmt.Errorf("%w: %s", ErrInvalidOTPURI, uri)
+		return
+	}
+	k := &OTPKey{Type: u.Host}
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		k.Issuer, k.Account = issuer, strings.TrimLeft(account, " ")
+	} else {
+		k.Account = label
+	}
+	params := u.Query()
+	if issuer := params.Get("issuer"); issuer != "" {
+		k.Issuer = issuer
+	}
+	if k.Secret, err = DecodeOTPSecret(params.Get("secret")); err != nil || len(k.Secret) == 0 {
+		err = fmt.Errorf("%w: invalid secret", ErrInvalidOTPURI)
+		return
+	}
+	k.Algorithm = OTPAlgorithm(strings.ToUpper(params.Get("algorithm")))
+	if v := params.Get("digits"); v != "" {
+		if k.Digits, err = strconv.Atoi(v); err != nil {
+			err = fmt.Errorf("%w: invalid digits %s", ErrInvalidOTPURI, v)
+			return
+		}
+	}
+	if v := params.Get("period"); v != "" {
+		var seconds int
+		if seconds, err = strconv.Atoi(v); err != nil || seconds <= 0 {
+			err = fmt.Errorf("%w: invalid period %s", ErrInvalidOTPURI, v)
+			return
+		}
+		k.Period = time.Duration(seconds) * time.Second
+	}
+	if v := params.Get("counter"); v != "" {
+		if k.Counter, err = strconv.ParseUint(v, 10, 64); err != nil {
+			err = fmt.Errorf("%w: invalid counter %s", ErrInvalidOTPURI, v)
+			return
+		}
+	} else if k.Type == "hotp" {
+		err = fmt.Errorf("%w: the counter of the hotp is missing", ErrInvalidOTPURI)
+		return
+	}
+	key = k
+	return
+}
@@ -0,0 +1,185 @@
+package secrets
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+var (
+	rfcSecretSha1   = []byte("12345678901234567890")
+	rfcSecretSha256 = []byte("12345678901234567890123456789012")
+	rfcSecretSha512 = []byte("1234567890123456789012345678901234567890123456789012345678901234")
+)
+
+// TestHOTP_RFC4226 tests the values of the appendix D of RFC 4226
+func TestHOTP_RFC4226(t *testing.T) {
+	hotp := NewHOTP(rfcSecretSha1, 0)
+	for counter, want := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922",
+		"162583", "399871", "520489"} {
+		got, err := hotp.Generate(uint64(counter))
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+// TestTOTP_RFC6238 tests the values of the appendix B of RFC 6238
+func TestTOTP_RFC6238(t *testing.T) {
+	tests := []struct {
+		unix                 int64
+		sha1, sha256, sha512 string
+	}{
+		{59, "94287082", "46119246", "90693936"},
+		{1111111109, "07081804", "68084774", "25091201"},
+		{1111111111, "14050471", "67062674", "99943326"},
+		{1234567890, "89005924", "91819424", "93441116"},
+		{2000000000, "69279037", "90698825", "38618901"},
+		{20000000000, "65353130", "77737706", "47863826"},
+	}
+	totps := map[OTPAlgorithm][]byte{OTPSha1: rfcSecretSha1, OTPSha256: rfcSecretSha256, OTPSha512: rfcSecretSha512}
+	for _, tt := range tests {
+		for algorithm, want := range map[OTPAlgorithm]string{OTPSha1: tt.sha1, OTPSha256: tt.sha256,
+			OTPSha512: tt.sha512} {
+			totp, err := NewTOTP(totps[algorithm], TOTPOptions{Digits: 8, Algorithm: algorithm})
+			assert.NoError(t, err)
+			at := time.Unix(tt.unix, 0)
+			if got := totp.Generate(at); got != want {
+				t.Errorf("Generate(%d) with %s = %s, want %s", tt.unix, algorithm, got, want)
+			}
+			assert.True(t, totp.Verify(want, at))
+		}
+	}
+}
+
+func TestNewTOTP_Invalid(t *testing.T) {
+	_, err := NewTOTP(rfcSecretSha1, TOTPOptions{Algorithm: "MD5"})
+	assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm))
+	_, err = NewTOTP(rfcSecretSha1, TOTPOptions{Digits: 10})
+	assert.Equal(t, ErrInvalidOTPDigits, err)
+}
+
+func TestTOTP_Skew(t *testing.T) {
+	totp, err := NewTOTP(rfcSecretSha1, TOTPOptions{Skew: 1})
+	assert.NoError(t, err)
+	// the step of [1200, 1230)
+	code := totp.Generate(time.Unix(1200, 0))
+	tests := []struct {
+		unix int64
+		want bool
+	}{
+		{1169, false},
+		{1170, true},
+		{1229, true},
+		{1259, true},
+		{1260, false},
+	}
+	for _, tt := range tests {
+		if got := totp.Verify(code, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("Verify() at %d = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+
+	strict, err := NewTOTP(rfcSecretSha1, TOTPOptions{})
+	assert.NoError(t, err)
+	assert.True(t, strict.Verify(code, time.Unix(1229, 0)))
+	assert.False(t, strict.Verify(code, time.Unix(1230, 0)))
+	assert.False(t, strict.Verify(code[:5], time.Unix(1200, 0)))
+}
+
+func TestTOTP_Replay(t *testing.T) {
+	store := NewMemOTPReplayStore()
+	now := time.Unix(1200, 0)
+	store.now = func() time.Time { return now }
+	totp, err := NewTOTP(rfcSecretSha1, TOTPOptions{Skew: 1, Replay: store})
+	assert.NoError(t, err)
+	other, err := NewTOTP(rfcSecretSha256, TOTPOptions{Skew: 1, Replay: store})
+	assert.NoError(t, err)
+
+	code := totp.Generate(now)
+	assert.True(t, totp.Verify(code, now))
+	// neither in the same step nor in the next one
+	assert.False(t, totp.Verify(code, now))
+	assert.False(t, totp.Verify(code, now.Add(30*time.Second)))
+	// the other secrets are tracked apart
+	assert.True(t, other.Verify(other.Generate(now), now))
+	// the used steps are forgotten once out of the skew window
+	now = now.Add(time.Minute)
+	assert.True(t, totp.Verify(totp.Generate(now), now))
+	assert.Equal(t, 1, len(store.used))
+}
+
+func TestHOTP_Resync(t *testing.T) {
+	hotp := NewHOTP(rfcSecretSha1, 0)
+	hotp.LookAhead = 3
+	assert.True(t, hotp.Verify("755224"))
+	assert.Equal(t, uint64(1), hotp.Counter())
+	assert.False(t, hotp.Verify("755224"))
+	// the token moved ahead to the counter 4
+	assert.True(t, hotp.Verify("338314"))
+	assert.Equal(t, uint64(5), hotp.Counter())
+	// beyond the look-ahead
+	assert.False(t, hotp.Verify("520489"))
+	assert.Equal(t, uint64(5), hotp.Counter())
+}
+
+func TestOTPSecret(t *testing.T) {
+	secret, err := NewOTPSecret(0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultOTPSecretSize, len(secret))
+	encoded := EncodeOTPSecret(secret)
+	assert.False(t, strings.Contains(encoded, "="))
+
+	for _, s := range []string{"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq",
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ", "GEZD-GNBV-GY3T-QOJQ-GEZD-GNBV-GY3T-QOJQ"} {
+		decoded, err := DecodeOTPSecret(s)
+		assert.NoError(t, err)
+		assert.Equal(t, string(rfcSecretSha1), string(decoded))
+	}
+	// missing and complete padding
+	for _, s := range []string{"MFRGG", "MFRGG===", "MFRGGZA", "MFRGGZA="} {
+		_, err := DecodeOTPSecret(s)
+		assert.NoError(t, err)
+	}
+}
+
+func TestOTPKey_URI(t *testing.T) {
+	key := &OTPKey{
+		Type:      "totp",
+		Issuer:    "Nand Labs",
+		Account:   "alice@example.com",
+		Secret:    rfcSecretSha1,
+		Algorithm: OTPSha256,
+		Digits:    8,
+		Period:    60 * time.Second,
+	}
+	uri := key.URI()
+	assert.Equal(t, "otpauth://totp/Nand%20Labs:alice@example.com?algorithm=SHA256&digits=8"+
+		"&issuer=Nand%20Labs&period=60&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", uri)
+	parsed, err := ParseOTPURI(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, key.URI(), parsed.URI())
+	assert.Equal(t, "Nand Labs", parsed.Issuer)
+	assert.Equal(t, "alice@example.com", parsed.Account)
+	assert.Equal(t, time.Minute, parsed.Period)
+
+	hotp := &OTPKey{Type: "hotp", Account: "bob", Secret: rfcSecretSha1, Counter: 7}
+	parsed, err = ParseOTPURI(hotp.URI())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), parsed.Counter)
+	assert.Equal(t, "", parsed.Issuer)
+
+	// the issuer of the parameters takes precedence
+	parsed, err = ParseOTPURI("otpauth://totp/Old:carol?secret=GEZDGNBV&issuer=New")
+	assert.NoError(t, err)
+	assert.Equal(t, "New", parsed.Issuer)
+	assert.Equal(t, "carol", parsed.Account)
+
+	for _, uri := range []string{"https://totp/a?secret=GEZDGNBV", "otpauth://motp/a?secret=GEZDGNBV",
+		"otpauth://totp/a", "otpauth://totp/a?secret=GEZDGNBV&period=x", "otpauth://hotp/a?secret=GEZDGNBV"} {
+		_, err = ParseOTPURI(uri)
+		assert.True(t, errors.Is(err, ErrInvalidOTPURI))
+	}
+}
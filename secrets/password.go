@@ -0,0 +1,195 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultHashMemory is the default memory in KiB of the password hashes
+	DefaultHashMemory = 64 * 1024
+	// DefaultHashIterations is the default number of passes over the memory of the password hashes
+	DefaultHashIterations = 3
+	// DefaultHashParallelism is the default number of lanes of the memory filled concurrently
+	DefaultHashParallelism = 4
+	// DefaultHashSaltLength is the default number of bytes of the salts of the password hashes
+	DefaultHashSaltLength = 16
+	// DefaultHashKeyLength is the default number of bytes of the password hashes
+	DefaultHashKeyLength = 32
+)
+
+// argon2idPrefix is the prefix of the PHC strings of the Argon2id hashes
+const argon2idPrefix = "$argon2id$"
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// hashBase64 is the unpadded base64 of the salts and the hashes of the PHC strings
+var hashBase64 = base64.RawStdEncoding
+
+// HashOption configures HashPassword and NeedsRehash
+type HashOption func(cfg *hashConfig)
+
+// hashConfig holds the parameters of the password hashes
+type hashConfig struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	saltLength  uint32
+	keyLength   uint32
+}
+
+// WithHashMemory sets the memory in KiB used to hash the passwords. Defaults to DefaultHashMemory.
+func WithHashMemory(kib uint32) HashOption {
+	return func(cfg *hashConfig) {
+		if kib > 0 {
+			cfg.memory = kib
+		}
+	}
+}
+
+// WithHashIterations sets the number of passes over the memory. Defaults to DefaultHashIterations.
+func WithHashIterations(n uint32) HashOption {
+	return func(cfg *hashConfig) {
+		if n > 0 {
+			cfg.iterations = n
+		}
+	}
+}
+
+// WithHashParallelism sets the number of lanes of the memory filled concurrently. Defaults to
+// DefaultHashParallelism.
+func WithHashParallelism(n uint8) HashOption {
+	return func(cfg *hashConfig) {
+		if n > 0 {
+			cfg.parallelism = n
+		}
+	}
+}
+
+// WithHashSaltLength sets the number of random bytes of the salts, at least 8. Defaults to DefaultHashSaltLength.
+func WithHashSaltLength(n uint32) HashOption {
+	return func(cfg *hashConfig) {
+		if n >= 8 {
+			cfg.saltLength = n
+		}
+	}
+}
+
+// WithHashKeyLength sets the number of bytes of the hashes, at least 16. Defaults to DefaultHashKeyLength.
+func WithHashKeyLength(n uint32) HashOption {
+	return func(cfg *hashConfig) {
+		if n >= 16 {
+			cfg.keyLength = n
+		}
+	}
+}
+
+func newHashConfig(opts []HashOption) *hashConfig {
+	cfg := &hashConfig{
+		memory:      DefaultHashMemory,
+		iterations:  DefaultHashIterations,
+		parallelism: DefaultHashParallelism,
+		saltLength:  DefaultHashSaltLength,
+		keyLength:   DefaultHashKeyLength,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// passwordHash is a parsed PHC string of an Argon2id hash
+type passwordHash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// HashPassword hashes the password with Argon2id and a random salt. The hash is the PHC string holding the
+// parameters, e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>, so that it keeps verifying when the options change.
+func HashPassword(password string, opts ...HashOption) (hash string, err error) {
+	cfg := newHashConfig(opts)
+	salt := make([]byte, cfg.saltLength)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	key := argon2idKey([]byte(password), salt, nil, nil, cfg.iterations, cfg.memory, cfg.parallelism, cfg.keyLength)
+	hash = fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2Version, cfg.memory, cfg.iterations,
+		cfg.parallelism, hashBase64.EncodeToString(salt), hashBase64.EncodeToString(key))
+	return
+}
+
+// VerifyPassword checks the password against the hash of HashPassword, hashing it with the parameters of the hash.
+// The hashes are compared in constant time. An error is returned for a hash that cannot be parsed, ErrInvalidHash,
+// or that is not of Argon2id, ErrUnsupportedAlgorithm.
+func VerifyPassword(hash, password string) (ok bool, err error) {
+	var h *passwordHash
+	if h, err = parsePasswordHash(hash); err != nil {
+		return
+	}
+	key := argon2idKey([]byte(password), h.salt, nil, nil, h.iterations, h.memory, h.parallelism, uint32(len(h.key)))
+	ok = subtle.ConstantTimeCompare(key, h.key) == 1
+	return
+}
+
+// NeedsRehash checks if the hash was not made by HashPassword with the options, e.g. after the defaults are raised,
+// so that the password is hashed again once verified. A hash that cannot be parsed needs a rehash.
+func NeedsRehash(hash string, opts ...HashOption) bool {
+	h, err := parsePasswordHash(hash)
+	if err != nil {
+		return true
+	}
+	cfg := newHashConfig(opts)
+	return h.memory != cfg.memory || h.iterations != cfg.iterations || h.parallelism != cfg.parallelism ||
+		uint32(len(h.salt)) != cfg.saltLength || uint32(len(h.key)) != cfg.keyLength
+}
+
+// parsePasswordHash parses the PHC string of an Argon2id hash of the version 19
+func parsePasswordHash(hash string) (h *passwordHash, err error) {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		if algorithm, _, found := strings.Cut(strings.TrimPrefix(hash, "$"), "$"); found {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+		}
+		return nil, ErrInvalidHash
+	}
+	fields := strings.Split(strings.TrimPrefix(hash, argon2idPrefix), "$")
+	if len(fields) != 4 || fields[0] != "v="+strconv.Itoa(argon2Version) {
+		return nil, ErrInvalidHash
+	}
+	h = &passwordHash{}
+	var m, t, p uint64
+	for _, param := range strings.Split(fields[1], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		switch name {
+		case "m":
+			m, err = strconv.ParseUint(value, 10, 32)
+		case "t":
+			t, err = strconv.ParseUint(value, 10, 32)
+		case "p":
+			p, err = strconv.ParseUint(value, 10, 8)
+		default:
+			err = ErrInvalidHash
+		}
+		if err != nil {
+			return nil, ErrInvalidHash
+		}
+	}
+	if t == 0 || p == 0 || m < 8*p {
+		return nil, ErrInvalidHash
+	}
+	h.memory, h.iterations, h.parallelism = uint32(m), uint32(t), uint8(p)
+	if h.salt, err = hashBase64.DecodeString(fields[2]); err == nil {
+		h.key, err = hashBase64.DecodeString(fields[3])
+	}
+	if err != nil || len(h.salt) < 8 || len(h.key) < 4 {
+		return nil, ErrInvalidHash
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// cheapHash are the options of the hashes of the tests
+var cheapHash = []HashOption{WithHashMemory(64), WithHashIterations(1), WithHashParallelism(1)}
+
+func TestBlake2b_RFC7693(t *testing.T) {
+	assert.Equal(t, "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8"+
+		"dbf1925ab92386edd4009923", hex.EncodeToString(blake2bSum(64, []byte("abc"))))
+	assert.Equal(t, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b"+
+		"1448b755d56f701afe9be2ce", hex.EncodeToString(blake2bSum(64)))
+}
+
+func TestArgon2id_RFC9106(t *testing.T) {
+	key := argon2idKey(bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16), bytes.Repeat([]byte{3}, 8),
+		bytes.Repeat([]byte{4}, 12), 3, 32, 4, 32)
+	assert.Equal(t, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659", hex.EncodeToString(key))
+}
+
+func TestVerifyPassword_Reference(t *testing.T) {
+	// the hash of the reference implementation of Argon2
+	hash := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	ok, err := VerifyPassword(hash, "password")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyPassword(hash, "Password")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple", cheapHash...)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"))
+	other, err := HashPassword("correct horse battery staple", cheapHash...)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	ok, err := VerifyPassword(hash, "correct horse battery staple")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = VerifyPassword(hash, "correct horse battery stapl")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// the hashes keep verifying with their own parameters when the options change
+	assert.False(t, NeedsRehash(hash, cheapHash...))
+	assert.True(t, NeedsRehash(hash))
+	assert.True(t, NeedsRehash(hash, append(cheapHash, WithHashIterations(2))...))
+	assert.True(t, NeedsRehash("$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"))
+}
+
+func TestVerifyPassword_InvalidHash(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"password",
+		"$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=0,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=4,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=1,p=1,x=2$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+	} {
+		ok, err := VerifyPassword(hash, "password")
+		assert.True(t, errors.Is(err, ErrInvalidHash))
+		assert.False(t, ok)
+	}
+	_, err := VerifyPassword("$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "password")
+	assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm))
+}
+
+func TestVerifyPassword_TimingSafe(t *testing.T) {
+	hash, err := HashPassword("password", cheapHash...)
+	assert.NoError(t, err)
+	// the median durations of the verifications of the password and of wrong passwords are alike
+	median := func(password string) time.Duration {
+		durations := make([]time.Duration, 101)
+		for i := range durations {
+			start := time.Now()
+			_, _ = VerifyPassword(hash, password)
+			durations[i] = time.Since(start)
+		}
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		return durations[len(durations)/2]
+	}
+	median("warmup")
+	right, wrong, empty := median("password"), median("passwore"), median("")
+	for _, d := range []time.Duration{wrong, empty} {
+		ratio := float64(d) / float64(right)
+		if ratio < 0.5 || ratio > 2 {
+			t.Errorf("the verification took %v instead of %v", d, right)
+		}
+	}
+}
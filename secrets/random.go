@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Charset is the set of characters of the strings of GenerateRandomString
+type Charset string
+
+const (
+	// CharsetNumeric is the set of the decimal digits
+	CharsetNumeric Charset = "0123456789"
+	// CharsetAlpha is the set of the ASCII letters
+	CharsetAlpha Charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// CharsetAlphaNumeric is the set of the ASCII letters and digits
+	CharsetAlphaNumeric = CharsetAlpha + CharsetNumeric
+	// CharsetHex is the set of the lower case hexadecimal digits
+	CharsetHex Charset = "0123456789abcdef"
+	// CharsetURLSafe is the set of the characters of the unpadded base64 url encoding
+	CharsetURLSafe = CharsetAlphaNumeric + "-_"
+	// CharsetUnambiguous is the set of the ASCII upper case letters and digits that are not mistaken for one another,
+	// without 0, 1, I and O
+	CharsetUnambiguous Charset = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+// DefaultTokenSize is the default number of random bytes of the tokens of GenerateToken
+const DefaultTokenSize = 32
+
+var ErrInvalidCharset = errors.New("the charset has less than two characters")
+
+// GenerateRandomString returns a string of n characters drawn uniformly from the charset with crypto/rand
+func GenerateRandomString(n int, charset Charset) (s string, err error) {
+	chars := []rune(string(charset))
+	if len(chars) < 2 {
+		return "", ErrInvalidCharset
+	}
+	size := big.NewInt(int64(len(chars)))
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		var index *big.Int
+		if index, err = rand.Int(rand.Reader, size); err != nil {
+			return "", err
+		}
+		sb.WriteRune(chars[index.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// GenerateToken returns the unpadded base64 url encoding of the number of random bytes, e.g. for API keys. Defaults
+// to DefaultTokenSize bytes when the bytes are not positive.
+func GenerateToken(bytes int) (token string, err error) {
+	if bytes <= 0 {
+		bytes = DefaultTokenSize
+	}
+	b := make([]byte, bytes)
+	if _, err = rand.Read(b); err == nil {
+		token = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestGenerateRandomString(t *testing.T) {
+	for _, charset := range []Charset{CharsetNumeric, CharsetAlphaNumeric, CharsetURLSafe, CharsetUnambiguous, "αβγ"} {
+		s, err := GenerateRandomString(64, charset)
+		assert.NoError(t, err)
+		assert.Equal(t, 64, len([]rune(s)))
+		for _, r := range s {
+			assert.True(t, strings.ContainsRune(string(charset), r))
+		}
+	}
+
+	// every character is drawn
+	counts := map[rune]int{}
+	s, err := GenerateRandomString(10000, CharsetHex)
+	assert.NoError(t, err)
+	for _, r := range s {
+		counts[r]++
+	}
+	assert.Len(t, counts, 16)
+	for _, count := range counts {
+		assert.True(t, count > 400 && count < 850)
+	}
+
+	s, err = GenerateRandomString(0, CharsetHex)
+	assert.NoError(t, err)
+	assert.Equal(t, "", s)
+	_, err = GenerateRandomString(8, "a")
+	assert.True(t, errors.Is(err, ErrInvalidCharset))
+}
+
+func TestGenerateToken(t *testing.T) {
+	token, err := GenerateToken(0)
+	assert.NoError(t, err)
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	assert.NoError(t, err)
+	assert.Len(t, b, DefaultTokenSize)
+	other, err := GenerateToken(16)
+	assert.NoError(t, err)
+	assert.Equal(t, 22, len(other))
+	assert.NotEqual(t, token, other)
+}
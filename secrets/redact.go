@@ -0,0 +1,282 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"oss.nandlabs.io/golly/l3"
+)
+
+// RedactedMask replaces the masked values
+const RedactedMask = "***"
+
+// redactMinLength is the number of characters below which Redact masks the whole string
+const redactMinLength = 8
+
+// SensitiveTag is the struct tag marking the fields masked by ScrubStruct, e.g. `sensitive:"true"`
+const SensitiveTag = "sensitive"
+
+// defaultSensitivePatterns are the patterns of the names of the fields masked by ScrubStruct
+var defaultSensitivePatterns = []string{"password", "token", "secret", "key"}
+
+// sensitivePatterns holds the lower case patterns of the names of the fields masked by ScrubStruct
+var sensitivePatterns atomic.Pointer[[]string]
+
+func init() {
+	SetSensitivePatterns()
+}
+
+// Redact returns the string with all but its first and last 2 characters masked, e.g. sk***yz. Strings of less than
+// 8 characters are masked entirely. The mask does not reveal the length of the string.
+func Redact(s string) string {
+	chars := []rune(s)
+	if len(chars) == 0 {
+		return s
+	}
+	if len(chars) < redactMinLength {
+		return RedactedMask
+	}
+	return string(chars[:2]) + RedactedMask + string(chars[len(chars)-2:])
+}
+
+// Sensitive holds a value that is masked when it is formatted, marshalled to JSON or text, or logged with slog.
+// The value is only returned by Reveal.
+type Sensitive[T any] struct {
+	value T
+}
+
+// NewSensitive wraps the value in a Sensitive
+func NewSensitive[T any](value T) Sensitive[T] {
+	return Sensitive[T]{value: value}
+}
+
+// Reveal returns the value
+func (s Sensitive[T]) Reveal() T {
+	return s.value
+}
+
+// String returns RedactedMask
+func (s Sensitive[T]) String() string {
+	return RedactedMask
+}
+
+// GoString returns RedactedMask
+func (s Sensitive[T]) GoString() string {
+	return RedactedMask
+}
+
+// Format writes RedactedMask whatever the verb, so that the value is not printed by the verbs ignoring String
+func (s Sensitive[T]) Format(f fmt.State, _ rune) {
+	_, _ = io.WriteString(f, RedactedMask)
+}
+
+// MarshalJSON returns RedactedMask as a JSON string
+func (s Sensitive[T]) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(RedactedMask)), nil
+}
+
+// UnmarshalJSON decodes the value, so that the configurations can hold Sensitive fields
+func (s *Sensitive[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &s.value)
+}
+
+// MarshalText returns RedactedMask
+func (s Sensitive[T]) MarshalText() ([]byte, error) {
+	return []byte(RedactedMask), nil
+}
+
+// LogValue returns RedactedMask as the slog value
+func (s Sensitive[T]) LogValue() slog.Value {
+	return slog.StringValue(RedactedMask)
+}
+
+// SetSensitivePatterns replaces the patterns of the names of the fields and of the map keys masked by ScrubStruct.
+// A name matches the patterns it contains, ignoring the case. Without patterns, the defaults password, token, secret
+// and key are restored.
+func SetSensitivePatterns(patterns ...string) {
+	if len(patterns) == 0 {
+		patterns = defaultSensitivePatterns
+	}
+	lower := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p != "" {
+			lower = append(lower, strings.ToLower(p))
+		}
+	}
+	sensitivePatterns.Store(&lower)
+}
+
+// ScrubStruct returns a deep copy of the value, safe for logging, with the sensitive fields masked. A field is
+// sensitive if it is tagged `sensitive:"true"`, or if its name matches a pattern of SetSensitivePatterns and it is
+// not tagged `sensitive:"false"`. The values of the maps with string keys matching a pattern are masked as well.
+// The strings, byte slices and empty interfaces are replaced by RedactedMask and the other types by their zero value.
+// The structs, pointers, maps, slices, arrays and interfaces are copied recursively, the unexported fields are copied
+// as they are.
+func ScrubStruct(v any) any {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if !scrubbable(rv.Kind()) {
+		return v
+	}
+	s := &scrubber{patterns: *sensitivePatterns.Load(), visited: make(map[scrubVisit]reflect.Value)}
+	return s.scrub(rv).Interface()
+}
+
+// EnableLogScrubbing makes l3 log the copies of ScrubStruct of the arguments of the entries, so that the sensitive
+// fields of the structs and maps logged are masked.
+func EnableLogScrubbing() {
+	l3.SetArgScrubber(ScrubStruct)
+}
+
+// scrubbable checks if the values of the kind may hold sensitive fields
+func scrubbable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Struct, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// scrubVisit identifies a pointer, map or slice already copied, so that the cycles are copied once
+type scrubVisit struct {
+	ptr    uintptr
+	length int
+	typ    reflect.Type
+}
+
+// scrubber makes the copies of ScrubStruct
+type scrubber struct {
+	patterns []string
+	visited  map[scrubVisit]reflect.Value
+}
+
+// scrub returns a copy of the value with the sensitive fields masked
+func (s *scrubber) scrub(v reflect.Value) reflect.Value {
+	if !scrubbable(v.Kind()) {
+		return v
+	}
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		visit := scrubVisit{ptr: v.Pointer(), typ: t}
+		if c, ok := s.visited[visit]; ok {
+			return c
+		}
+		c := reflect.New(t.Elem())
+		s.visited[visit] = c
+		c.Elem().Set(s.scrub(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(t).Elem()
+		c.Set(s.scrub(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(t).Elem()
+		c.Set(v)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if s.sensitiveField(field) {
+				c.Field(i).Set(maskValue(field.Type))
+			} else {
+				c.Field(i).Set(s.scrub(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		visit := scrubVisit{ptr: v.Pointer(), typ: t}
+		if c, ok := s.visited[visit]; ok {
+			return c
+		}
+		c := reflect.MakeMapWithSize(t, v.Len())
+		s.visited[visit] = c
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key()
+			if key.Kind() == reflect.String && s.sensitiveName(key.String()) {
+				c.SetMapIndex(key, maskValue(t.Elem()))
+			} else {
+				c.SetMapIndex(key, s.scrub(iter.Value()))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		visit := scrubVisit{ptr: v.Pointer(), length: v.Len(), typ: t}
+		if c, ok := s.visited[visit]; ok {
+			return c
+		}
+		c := reflect.MakeSlice(t, v.Len(), v.Len())
+		s.visited[visit] = c
+		if t.Elem().Kind() == reflect.Uint8 {
+			reflect.Copy(c, v)
+			return c
+		}
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(s.scrub(v.Index(i)))
+		}
+		return c
+	default: // reflect.Array
+		c := reflect.New(t).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(s.scrub(v.Index(i)))
+		}
+		return c
+	}
+}
+
+// sensitiveField checks if the field is tagged sensitive, or has a name matching a pattern and is not tagged
+func (s *scrubber) sensitiveField(field reflect.StructField) bool {
+	if tag, ok := field.Tag.Lookup(SensitiveTag); ok {
+		if sensitive, err := strconv.ParseBool(tag); err == nil {
+			return sensitive
+		}
+	}
+	return s.sensitiveName(field.Name)
+}
+
+// sensitiveName checks if the name contains a pattern ignoring the case
+func (s *scrubber) sensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range s.patterns {
+		if strings.Contains(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskValue returns RedactedMask for the strings, the byte slices and the empty interfaces, and the zero value for
+// the other types
+func maskValue(t reflect.Type) reflect.Value {
+	switch {
+	case t.Kind() == reflect.String:
+		return reflect.ValueOf(RedactedMask).Convert(t)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return reflect.ValueOf([]byte(RedactedMask)).Convert(t)
+	case t.Kind() == reflect.Interface && t.NumMethod() == 0:
+		return reflect.ValueOf(RedactedMask)
+	}
+	return reflect.Zero(t)
+}
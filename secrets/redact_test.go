@@ -0,0 +1,144 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/l3"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "", Redact(""))
+	assert.Equal(t, RedactedMask, Redact("abc"))
+	assert.Equal(t, RedactedMask, Redact("abcdefg"))
+	assert.Equal(t, "sk***yz", Redact("sk-live-0123456789xyz"))
+	assert.Equal(t, "αβ***ψω", Redact("αβγδεζηψω"))
+}
+
+type scrubAuth struct {
+	User     string
+	Password string
+	APIKey   []byte
+	Pin      int `sensitive:"true"`
+	KeyCount int `sensitive:"false"`
+	Token    Sensitive[string]
+}
+
+type scrubConfig struct {
+	Name    string
+	Auth    scrubAuth
+	Backup  *scrubAuth
+	Users   []scrubAuth
+	Headers map[string]string
+	Extra   map[string]any
+	Any     any
+	Next    *scrubConfig
+	secret  string
+}
+
+func TestSensitive(t *testing.T) {
+	s := NewSensitive("hunter2")
+	assert.Equal(t, "hunter2", s.Reveal())
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%d"} {
+		assert.Equal(t, RedactedMask, fmt.Sprintf(format, s))
+	}
+	auth := scrubAuth{Token: s}
+	assert.False(t, strings.Contains(fmt.Sprintf("%+v %#v", auth, &auth), "hunter2"))
+
+	b, err := json.Marshal(auth)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(b), "hunter2"))
+	assert.True(t, strings.Contains(string(b), `"Token":"***"`))
+
+	var decoded scrubAuth
+	assert.NoError(t, json.Unmarshal([]byte(`{"Token":"s3cret"}`), &decoded))
+	assert.Equal(t, "s3cret", decoded.Token.Reveal())
+	text, err := s.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, RedactedMask, string(text))
+	assert.Equal(t, RedactedMask, s.LogValue().String())
+}
+
+func TestScrubStruct(t *testing.T) {
+	auth := scrubAuth{User: "alice", Password: "hunter2", APIKey: []byte("k"), Pin: 1234, KeyCount: 2,
+		Token: NewSensitive("t0ken")}
+	cfg := &scrubConfig{
+		Name:    "app",
+		Auth:    auth,
+		Backup:  &auth,
+		Users:   []scrubAuth{auth, {User: "bob", Password: "pa55"}},
+		Headers: map[string]string{"Authorization": "Bearer x", "X-Api-Key": "abc", "Accept": "json"},
+		Extra:   map[string]any{"db": map[string]any{"password": "pw", "host": "db"}, "list": []any{auth}},
+		Any:     &auth,
+		secret:  "internal",
+	}
+	cfg.Next = cfg
+
+	scrubbed, ok := ScrubStruct(cfg).(*scrubConfig)
+	assert.True(t, ok)
+	masked := scrubAuth{User: "alice", Password: RedactedMask, APIKey: []byte(RedactedMask), KeyCount: 2}
+	assert.Equal(t, "app", scrubbed.Name)
+	assert.Equal(t, masked, scrubbed.Auth)
+	assert.Equal(t, masked, *scrubbed.Backup)
+	assert.Equal(t, masked, scrubbed.Users[0])
+	assert.Equal(t, RedactedMask, scrubbed.Users[1].Password)
+	assert.Equal(t, "bob", scrubbed.Users[1].User)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer x", "X-Api-Key": RedactedMask, "Accept": "json"},
+		scrubbed.Headers)
+	assert.Equal(t, map[string]any{"password": RedactedMask, "host": "db"}, scrubbed.Extra["db"])
+	assert.Equal(t, masked, scrubbed.Extra["list"].([]any)[0])
+	assert.Equal(t, masked, *scrubbed.Any.(*scrubAuth))
+	assert.Equal(t, "internal", scrubbed.secret)
+	// the cycle is copied once
+	assert.True(t, scrubbed.Next == scrubbed)
+
+	// the original is unchanged
+	assert.Equal(t, "hunter2", cfg.Auth.Password)
+	assert.Equal(t, "hunter2", cfg.Backup.Password)
+	assert.Equal(t, "hunter2", cfg.Users[0].Password)
+	assert.Equal(t, "abc", cfg.Headers["X-Api-Key"])
+	assert.Equal(t, "pw", cfg.Extra["db"].(map[string]any)["password"])
+	assert.Equal(t, 1234, cfg.Auth.Pin)
+
+	// the values are returned as they are
+	assert.Equal(t, nil, ScrubStruct(nil))
+	assert.Equal(t, "hunter2", ScrubStruct("hunter2"))
+	assert.Equal(t, masked, ScrubStruct(auth))
+	assert.Equal(t, []scrubAuth{masked}, ScrubStruct([]scrubAuth{auth}))
+	assert.Equal(t, [1]scrubAuth{masked}, ScrubStruct([1]scrubAuth{auth}))
+}
+
+func TestSetSensitivePatterns(t *testing.T) {
+	defer SetSensitivePatterns()
+	SetSensitivePatterns("USER")
+	scrubbed := ScrubStruct(scrubAuth{User: "alice", Password: "hunter2", Pin: 1234}).(scrubAuth)
+	assert.Equal(t, RedactedMask, scrubbed.User)
+	assert.Equal(t, "hunter2", scrubbed.Password)
+	assert.Equal(t, 0, scrubbed.Pin)
+
+	SetSensitivePatterns()
+	scrubbed = ScrubStruct(scrubAuth{User: "alice", Password: "hunter2"}).(scrubAuth)
+	assert.Equal(t, "alice", scrubbed.User)
+	assert.Equal(t, RedactedMask, scrubbed.Password)
+}
+
+func TestEnableLogScrubbing(t *testing.T) {
+	EnableLogScrubbing()
+	defer l3.SetArgScrubber(nil)
+	capture := l3.StartCapture(0)
+	logger := l3.Get()
+	auth := scrubAuth{User: "alice", Password: "hunter2", Token: NewSensitive("t0ken")}
+	logger.ErrorF("auth %+v", auth)
+	logger.Error(map[string]string{"token": "t0ken"})
+	logs := capture.Stop()
+	assert.Len(t, logs, 2)
+	for _, log := range logs {
+		assert.False(t, strings.Contains(log.Message, "hunter2"))
+		assert.False(t, strings.Contains(log.Message, "t0ken"))
+	}
+	assert.True(t, strings.Contains(logs[0].Message, "User:alice"))
+	assert.Equal(t, "hunter2", auth.Password)
+}
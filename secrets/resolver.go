@@ -0,0 +1,24 @@
+package secrets
+
+import (
+	"context"
+
+	"oss.nandlabs.io/golly/config"
+)
+
+const (
+	// SecretVarPrefix is the prefix of the variables ${secret:NAME} of the config properties
+	SecretVarPrefix = "secret"
+)
+
+// RegisterConfigResolver resolves the variables ${secret:NAME} of the config properties with the credentials of the
+// store
+func RegisterConfigResolver(store Store) {
+	config.RegisterResolver(SecretVarPrefix, func(name string) (v string, err error) {
+		var cred *Credential
+		if cred, err = store.Get(name, context.Background()); err == nil {
+			v = cred.Str()
+		}
+		return
+	})
+}
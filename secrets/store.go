@@ -2,10 +2,57 @@ package secrets
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+// ErrSecretNotFound is the error of the stores for a missing secret
+var ErrSecretNotFound = errors.New("secret not found")
+
+// ErrInvalidSecretName is the error of the stores for a name they cannot hold
+var ErrInvalidSecretName = errors.New("invalid secret name")
+
+// Store holds named secrets as credentials
 type Store interface {
+	// Get the credential of the key
 	Get(key string, ctx context.Context) (*Credential, error)
+	// Write the credential of the key, replacing any existing one
 	Write(key string, credential *Credential, ctx context.Context) error
+	// Delete the credential of the key
+	Delete(key string, ctx context.Context) error
+	// List the keys of the credentials sorted
+	List(ctx context.Context) ([]string, error)
+	// Provider name of the store
 	Provider() string
 }
+
+// validateKey checks that the key is a single path element without a leading dot, usable as a file name
+func validateKey(key string) (err error) {
+	if key == "" || strings.HasPrefix(key, ".") || strings.ContainsAny(key, "/\\\x00") {
+		err = fmt.Errorf("%w: %q", ErrInvalidSecretName, key)
+	}
+	return
+}
+
+// writeFileAtomic writes the data to a temporary file of the directory of the file, readable by the owner only, and
+// renames it to the file so that a failed write never leaves a partial file
+func writeFileAtomic(name string, data []byte) (err error) {
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*"); err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return
+}
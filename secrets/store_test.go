@@ -0,0 +1,181 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"oss.nandlabs.io/golly/config"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+var testMasterKey = []byte("0123456789abcdef0123456789abcdef")
+
+// testStores creates a store of each backend
+func testStores(t *testing.T) []Store {
+	dirStore, err := NewDirStore(t.TempDir())
+	assert.NoError(t, err)
+	encryptedStore, err := NewEncryptedFileStore(filepath.Join(t.TempDir(), "secrets.enc"), testMasterKey)
+	assert.NoError(t, err)
+	return []Store{NewEnvStore("GOLLY_TEST_SECRET_"), dirStore, encryptedStore}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	for _, store := range testStores(t) {
+		t.Run(store.Provider(), func(t *testing.T) {
+			_, err := store.Get("db_password", ctx)
+			assert.True(t, errors.Is(err, ErrSecretNotFound))
+			assert.True(t, errors.Is(store.Delete("db_password", ctx), ErrSecretNotFound))
+
+			assert.NoError(t, store.Write("db_password", &Credential{Value: []byte("s3cr3t")}, ctx))
+			assert.NoError(t, store.Write("api_key", &Credential{Value: []byte("k3y")}, ctx))
+			cred, err := store.Get("db_password", ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, "s3cr3t", cred.Str())
+			keys, err := store.List(ctx)
+			assert.NoError(t, err)
+			if store.Provider() == EnvStoreProvider {
+				assert.Equal(t, []string{"API_KEY", "DB_PASSWORD"}, keys)
+			} else {
+				assert.Equal(t, []string{"api_key", "db_password"}, keys)
+			}
+
+			assert.NoError(t, store.Delete("db_password", ctx))
+			assert.NoError(t, store.Delete("api_key", ctx))
+			_, err = store.Get("db_password", ctx)
+			assert.True(t, errors.Is(err, ErrSecretNotFound))
+			keys, err = store.List(ctx)
+			assert.NoError(t, err)
+			assert.Len(t, keys, 0)
+		})
+	}
+}
+
+func TestEnvStore_Mapping(t *testing.T) {
+	t.Setenv("APP_DB_PASSWORD", "s3cr3t")
+	cred, err := NewEnvStore("APP_").Get("db.password", context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", cred.Str())
+}
+
+func TestDirStore(t *testing.T) {
+	// the layout of the Kubernetes secrets mounted as a volume
+	dir := t.TempDir()
+	data := filepath.Join(dir, "..2024_01_01_00_00_00.000000000")
+	assert.NoError(t, os.Mkdir(data, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(data, "password"), []byte("s3cr3t"), 0o644))
+	assert.NoError(t, os.Symlink(filepath.Base(data), filepath.Join(dir, "..data")))
+	assert.NoError(t, os.Symlink(filepath.Join("..data", "password"), filepath.Join(dir, "password")))
+
+	store, err := NewDirStore(dir)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	keys, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"password"}, keys)
+	cred, err := store.Get("password", ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", cred.Str())
+	assert.False(t, cred.LastUpdated.IsZero())
+
+	for _, key := range []string{"", "..data", "../password", "a/b"} {
+		_, err = store.Get(key, ctx)
+		assert.True(t, errors.Is(err, ErrInvalidSecretName))
+	}
+	_, err = NewDirStore(filepath.Join(data, "password"))
+	assert.Error(t, err)
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	ctx := context.Background()
+	storeFile := filepath.Join(t.TempDir(), "secrets.enc")
+	store, err := NewEncryptedFileStore(storeFile, testMasterKey)
+	assert.NoError(t, err)
+	meta := map[string]interface{}{CredType: "password"}
+	assert.NoError(t, store.Write("db", &Credential{Value: []byte("v1"), MetaData: meta}, ctx))
+	first, err := store.Get("db", ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", first.Version)
+	assert.Equal(t, "password", first.Type())
+	assert.NoError(t, store.Write("db", &Credential{Value: []byte("v2"), MetaData: meta}, ctx))
+
+	content, err := os.ReadFile(storeFile)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(content, []byte("v2")))
+
+	// the secrets are read back with the master key
+	t.Setenv("GOLLY_TEST_MASTER_KEY", base64.StdEncoding.EncodeToString(testMasterKey))
+	key, err := MasterKeyFromEnv("GOLLY_TEST_MASTER_KEY")
+	assert.NoError(t, err)
+	reopened, err := NewEncryptedFileStore(storeFile, key)
+	assert.NoError(t, err)
+	second, err := reopened.Get("db", ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", second.Str())
+	assert.Equal(t, "2", second.Version)
+	assert.True(t, second.Created.Equal(first.Created))
+	assert.True(t, second.LastUpdated.After(first.LastUpdated))
+
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	assert.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))+"\n"), 0o600))
+	wrongKey, err := MasterKeyFromFile(keyFile)
+	assert.NoError(t, err)
+	_, err = NewEncryptedFileStore(storeFile, wrongKey)
+	assert.True(t, errors.Is(err, ErrStoreDecryption))
+	_, err = NewEncryptedFileStore(storeFile, []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestEncryptedFileStore_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	storeFile := filepath.Join(t.TempDir(), "secrets.enc")
+	store, err := NewEncryptedFileStore(storeFile, testMasterKey)
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i)
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, store.Write(key, &Credential{Value: []byte(fmt.Sprint(j))}, ctx))
+				cred, err := store.Get(key, ctx)
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprint(j), cred.Str())
+				_, err = store.List(ctx)
+				assert.NoError(t, err)
+			}
+			if i%2 == 0 {
+				assert.NoError(t, store.Delete(key, ctx))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	reopened, err := NewEncryptedFileStore(storeFile, testMasterKey)
+	assert.NoError(t, err)
+	keys, err := reopened.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key-1", "key-3", "key-5", "key-7"}, keys)
+	cred, err := reopened.Get("key-3", ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "9", cred.Str())
+	assert.Equal(t, "10", cred.Version)
+}
+
+func TestRegisterConfigResolver(t *testing.T) {
+	t.Setenv("GOLLY_CONFIG_DB_PASSWORD", "s3cr3t")
+	RegisterConfigResolver(NewEnvStore("GOLLY_CONFIG_"))
+	p := config.NewProperties()
+	p.Put("db.url", "postgres://app:${secret:db_password}@db/app")
+	p.Put("db.missing", "${secret:missing}")
+	assert.Equal(t, "postgres://app:s3cr3t@db/app", p.Get("db.url", ""))
+	assert.Equal(t, "${secret:missing}", p.Get("db.missing", ""))
+}
@@ -21,6 +21,16 @@ func (t *TestStore) Write(key string, credential *Credential, ctx context.Contex
 	panic("implement me")
 }
 
+func (t *TestStore) Delete(key string, ctx context.Context) error {
+
+	panic("implement me")
+}
+
+func (t *TestStore) List(ctx context.Context) ([]string, error) {
+
+	panic("implement me")
+}
+
 func (t *TestStore) Provider() string {
 	return t.providerName
 }
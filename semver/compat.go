@@ -0,0 +1,141 @@
+package semver
+
+import (
+	"fmt"
+)
+
+// Reason tells why a version is or is not accepted by a CompatibilityPolicy
+type Reason string
+
+const (
+	// ReasonCompatible is the reason of an accepted version
+	ReasonCompatible Reason = "compatible"
+	// ReasonTooOld is the reason of a version below the constraint
+	ReasonTooOld Reason = "too old"
+	// ReasonTooNew is the reason of a version above the constraint
+	ReasonTooNew Reason = "too new"
+	// ReasonPreRelease is the reason of a pre-release version in the constraint while the pre-releases are not allowed
+	ReasonPreRelease Reason = "pre-release not allowed"
+	// ReasonBuildMismatch is the reason of a version whose build metadata differs from the one required
+	ReasonBuildMismatch Reason = "build metadata mismatch"
+	// ReasonExcluded is the reason of a version excluded with !=
+	ReasonExcluded Reason = "excluded"
+	// ReasonUnsatisfied is the reason of a version that falls between the ranges of the constraint
+	ReasonUnsatisfied Reason = "not satisfied"
+	// ReasonInvalid is the reason of a version or a constraint that cannot be parsed
+	ReasonInvalid Reason = "invalid"
+)
+
+// Explanation describes the decision of a CompatibilityPolicy. Its String is suitable for the users.
+type Explanation struct {
+	// Reason of the decision
+	Reason Reason
+	// Version checked
+	Version string
+	// Constraint the version was checked against
+	Constraint string
+	// Current version of the policy
+	Current string
+	// Err is the parse error of an invalid version or constraint
+	Err error
+}
+
+// String describes the decision
+func (e Explanation) String() string {
+	switch e.Reason {
+	case ReasonCompatible:
+		return fmt.Sprintf("version %s satisfies %s", e.Version, e.Constraint)
+	case ReasonTooOld, ReasonTooNew:
+		return fmt.Sprintf("version %s is %s, %s is required", e.Version, e.Reason, e.Constraint)
+	case ReasonPreRelease:
+		return fmt.Sprintf("version %s is a pre-release, which %s does not allow", e.Version, e.Constraint)
+	case ReasonBuildMismatch:
+		return fmt.Sprintf("version %s does not have the build metadata of %s", e.Version, e.Constraint)
+	case ReasonExcluded:
+		return fmt.Sprintf("version %s is excluded by %s", e.Version, e.Constraint)
+	case ReasonInvalid:
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("version %s does not satisfy %s", e.Version, e.Constraint)
+}
+
+// CompatibilityPolicy decides whether the versions of the artifacts used by a program, such as plugins or
+// configuration files, are compatible with the current version of the program
+type CompatibilityPolicy struct {
+	current *SemVer
+}
+
+// NewCompatibilityPolicy creates a new CompatibilityPolicy for the current version. The version may be prefixed
+// with v and may omit the minor and patch components.
+func NewCompatibilityPolicy(current string) (policy *CompatibilityPolicy, err error) {
+	var v *SemVer
+	if v, err = parseTolerant(current); err == nil {
+		policy = &CompatibilityPolicy{current: v}
+	}
+	return
+}
+
+// Current returns the current version of the policy
+func (p *CompatibilityPolicy) Current() *SemVer {
+	return p.current
+}
+
+// DefaultConstraint returns the constraint applied when none is given. It accepts the versions of the current major
+// up to the current version, the versions of the current minor for a 0 major. The upper bound is the release of a
+// pre-release current version, so that 1.3.0-rc.1 accepts an artifact of 1.3.0.
+func (p *CompatibilityPolicy) DefaultConstraint() string {
+	c := p.current
+	if c.major == 0 {
+		return fmt.Sprintf(">=0.%d.0 <=%d.%d.%d", c.minor, c.major, c.minor, c.patch)
+	}
+	return fmt.Sprintf(">=%d.0.0 <=%d.%d.%d", c.major, c.major, c.minor, c.patch)
+}
+
+// Accepts checks if the version of the artifact satisfies the constraint, or the DefaultConstraint if the
+// constraint is empty. The version may be prefixed with v and may omit the minor and patch components. A
+// pre-release version is accepted when the current version is a pre-release as well.
+func (p *CompatibilityPolicy) Accepts(artifactVersion string, constraint string) (bool, Explanation) {
+	if constraint == "" {
+		constraint = p.DefaultConstraint()
+	}
+	e := Explanation{Version: artifactVersion, Constraint: constraint, Current: p.current.String()}
+	v, err := parseTolerant(artifactVersion)
+	if err != nil {
+		e.Reason, e.Err = ReasonInvalid, err
+		return false, e
+	}
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		e.Reason, e.Err = ReasonInvalid, err
+		return false, e
+	}
+	var ok bool
+	ok, e.Reason = c.check(v, p.current.preRelease != "")
+	return ok, e
+}
+
+// Nearest returns the candidate version accepted for the constraint that is the nearest to the version: the lowest
+// one above it, or the highest one below it if there is none above. It returns false if no candidate is accepted.
+// The candidates that cannot be parsed are ignored.
+func (p *CompatibilityPolicy) Nearest(version, constraint string, candidates []string) (nearest string, ok bool) {
+	v, err := parseTolerant(version)
+	if err != nil {
+		return
+	}
+	var above, below *SemVer
+	for _, candidate := range candidates {
+		if accepted, _ := p.Accepts(candidate, constraint); !accepted {
+			continue
+		}
+		c, _ := parseTolerant(candidate)
+		if precedence(c, v) >= 0 {
+			if above == nil || precedence(c, above) < 0 {
+				above, nearest = c, candidate
+			}
+		} else if above == nil && (below == nil || precedence(c, below) > 0) {
+			below, nearest = c, candidate
+		}
+	}
+	ok = above != nil || below != nil
+	return
+}
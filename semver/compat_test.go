@@ -0,0 +1,94 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestCompatibilityPolicy_Accepts(t *testing.T) {
+	tests := []struct {
+		current    string
+		version    string
+		constraint string
+		want       Reason
+	}{
+		// the default constraint accepts the current major up to the current version
+		{"1.4.2", "1.0.0", "", ReasonCompatible},
+		{"v1.4", "v1.4", "", ReasonCompatible},
+		{"1.4.2", "1.4.3", "", ReasonTooNew},
+		{"1.4.2", "0.9.0", "", ReasonTooOld},
+		{"1.4.2", "2.0", "", ReasonTooNew},
+		{"0.4.2", "0.3.0", "", ReasonTooOld},
+		{"0.4.2", "0.4.1", "", ReasonCompatible},
+		// a pre-release current version accepts the stable artifacts of its release and the pre-releases
+		{"1.3.0-rc.1", "1.3.0", "", ReasonCompatible},
+		{"1.3.0-rc.1", "1.3.0-rc.1", "", ReasonCompatible},
+		{"1.3.0-rc.1", "1.3.1", "", ReasonTooNew},
+		{"1.3.0", "1.3.0-rc.1", "", ReasonPreRelease},
+		{"2.0.0-beta.1", "2.0.0-alpha", ">=1.0 <3.0", ReasonCompatible},
+		// explicit constraints
+		{"1.4.2", "1.2", ">=1.2 <2", ReasonCompatible},
+		{"1.4.2", "1.1.9", ">=1.2 <2", ReasonTooOld},
+		{"1.4.2", "2.0.0", ">=1.2 <2", ReasonTooNew},
+		{"1.4.2", "2.0.0-beta.1", ">=1.2 <2", ReasonPreRelease},
+		{"1.4.2", "1.2.3+def", "=1.2.3+abc", ReasonBuildMismatch},
+		{"1.4.2", "1.2.3", "!=1.2.3", ReasonExcluded},
+		{"1.4.2", "2.5.0", "^1.0 || ^3.0", ReasonUnsatisfied},
+		{"1.4.2", "0.5.0", "^1.0 || ^3.0", ReasonTooOld},
+		{"1.4.2", "1.x", ">=1", ReasonInvalid},
+		{"1.4.2", "1.0.0", ">=banana", ReasonInvalid},
+	}
+	for _, tt := range tests {
+		policy, err := NewCompatibilityPolicy(tt.current)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, e := policy.Accepts(tt.version, tt.constraint)
+		if e.Reason != tt.want || ok != (tt.want == ReasonCompatible) {
+			t.Errorf("%s accepts %s for %q = %v, %s, want %s", tt.current, tt.version, tt.constraint, ok, e.Reason, tt.want)
+		}
+	}
+	if _, err := NewCompatibilityPolicy("one"); err == nil {
+		t.Error("NewCompatibilityPolicy() accepted an invalid version")
+	}
+}
+
+func TestExplanation_String(t *testing.T) {
+	policy, _ := NewCompatibilityPolicy("1.4.2")
+	tests := []struct {
+		version, constraint, want string
+	}{
+		{"1.3.0", "", "version 1.3.0 satisfies >=1.0.0 <=1.4.2"},
+		{"1.1", ">=1.2 <2", "version 1.1 is too old, >=1.2 <2 is required"},
+		{"2.0.0-beta.1", ">=1.2 <2", "version 2.0.0-beta.1 is a pre-release, which >=1.2 <2 does not allow"},
+		{"1.2.3", "!=1.2.3", "version 1.2.3 is excluded by !=1.2.3"},
+		{"nope", "", `invalid version "nope"`},
+	}
+	for _, tt := range tests {
+		if _, e := policy.Accepts(tt.version, tt.constraint); e.String() != tt.want {
+			t.Errorf("String() = %q, want %q", e.String(), tt.want)
+		}
+	}
+}
+
+func TestCompatibilityPolicy_Nearest(t *testing.T) {
+	policy, _ := NewCompatibilityPolicy("2.0.0")
+	candidates := []string{"1.0.0", "1.2.0", "1.4.2", "1.5.0-beta.1", "2.0.0", "bad"}
+	tests := []struct {
+		version, want string
+		ok            bool
+	}{
+		{"1.1.0", "1.2.0", true},
+		{"1.0.0", "1.2.0", true},
+		{"2.1.0", "1.4.2", true},
+		{"1.3.0", "1.4.2", true},
+	}
+	for _, tt := range tests {
+		got, ok := policy.Nearest(tt.version, ">=1.2 <2", candidates)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Nearest(%s) = %s, %v, want %s", tt.version, got, ok, tt.want)
+		}
+	}
+	if _, ok := policy.Nearest("1.0.0", ">=3", candidates); ok {
+		t.Error("Nearest() found a candidate for an unsatisfiable constraint")
+	}
+}
@@ -0,0 +1,372 @@
+package semver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidConstraint is returned when a version constraint cannot be parsed
+var ErrInvalidConstraint = errors.New("invalid version constraint")
+
+// Constraint is a set of version ranges. The ranges are separated by || and a version satisfies the constraint if it
+// satisfies all the comparators of one of them. The comparators of a range are separated by spaces or commas:
+//
+//   - =1.2.3, !=1.2.3, >1.2.3, >=1.2.3, <1.2.3 and <=1.2.3 compare the versions. A bare version is an =.
+//   - ~1.2.3 allows the patch to change: >=1.2.3 <1.3.0.
+//   - ^1.2.3 allows the changes that do not modify the left most non zero component: >=1.2.3 <2.0.0.
+//   - 1.2 - 1.4 is an inclusive range: >=1.2.0 <1.5.0.
+//
+// The versions may be prefixed with v and may omit the minor and patch components or use x or * in their place.
+// A missing component matches any value, so =1.2 is >=1.2.0 <1.3.0 and * matches any version.
+//
+// A pre-release version only satisfies a range that has a comparator with a pre-release of the same major, minor
+// and patch, so that >=1.2.0-beta.1 accepts 1.2.0-beta.2 but not 1.3.0-alpha. Build metadata is ignored unless an =
+// comparator has some, in which case it must be the same.
+type Constraint struct {
+	raw  string
+	sets [][]*comparator
+}
+
+// comparator is an operator of a range and the version it compares to
+type comparator struct {
+	op      string
+	version *SemVer
+}
+
+// ParseConstraint parses the constraint
+func ParseConstraint(constraint string) (c *Constraint, err error) {
+	c = &Constraint{raw: strings.TrimSpace(constraint)}
+	for _, set := range strings.Split(c.raw, "||") {
+		var comparators []*comparator
+		if comparators, err = parseRange(set); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidConstraint, constraint, err)
+		}
+		c.sets = append(c.sets, comparators)
+	}
+	return
+}
+
+// parseRange parses the comparators of a range
+func parseRange(set string) (comparators []*comparator, err error) {
+	var tokens []string
+	for _, field := range strings.Fields(strings.ReplaceAll(set, ",", " ")) {
+		// an operator separated from its version by spaces is joined back
+		if n := len(tokens); n > 0 && strings.Trim(tokens[n-1], "<>=!~^") == "" {
+			tokens[n-1] += field
+		} else {
+			tokens = append(tokens, field)
+		}
+	}
+	for i := 0; i < len(tokens); i++ {
+		var parsed []*comparator
+		if i+2 < len(tokens) && tokens[i+1] == "-" {
+			parsed, err = parseHyphen(tokens[i], tokens[i+2])
+			i += 2
+		} else {
+			parsed, err = parseComparator(tokens[i])
+		}
+		if err != nil {
+			return
+		}
+		comparators = append(comparators, parsed...)
+	}
+	return
+}
+
+// operators in the order they are looked up
+var operators = []string{">=", "<=", "!=", "==", "~>", ">", "<", "=", "~", "^"}
+
+// parseComparator parses a comparator into the equivalent primitive comparators
+func parseComparator(token string) ([]*comparator, error) {
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(token, o) {
+			op = o
+			break
+		}
+	}
+	v, parts, err := parsePartial(token[len(op):])
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "", "=", "==":
+		if parts == 3 {
+			return []*comparator{{"=", v}}, nil
+		}
+		return between(v, parts), nil
+	case "!=":
+		if parts == 3 {
+			return []*comparator{{"!=", v}}, nil
+		}
+		return nil, fmt.Errorf("%s needs a complete version", token)
+	case ">":
+		if parts < 3 {
+			return atLeast(bump(v, parts)), nil
+		}
+		return []*comparator{{">", v}}, nil
+	case ">=":
+		return atLeast(v), nil
+	case "<":
+		return []*comparator{{"<", v}}, nil
+	case "<=":
+		if parts < 3 {
+			return []*comparator{{"<", bump(v, parts)}}, nil
+		}
+		return []*comparator{{"<=", v}}, nil
+	case "~", "~>":
+		if parts == 3 {
+			parts = 2
+		}
+		return []*comparator{{">=", v}, {"<", bump(v, max(parts, 1))}}, nil
+	default: // ^
+		upper := 1
+		if v.major == 0 && parts > 1 {
+			upper = 2
+			if v.minor == 0 && parts > 2 {
+				upper = 3
+			}
+		}
+		return []*comparator{{">=", v}, {"<", bump(v, upper)}}, nil
+	}
+}
+
+// parseHyphen parses an inclusive range
+func parseHyphen(from, to string) ([]*comparator, error) {
+	lower, _, err := parsePartial(from)
+	if err != nil {
+		return nil, err
+	}
+	upper, parts, err := parsePartial(to)
+	if err != nil {
+		return nil, err
+	}
+	if parts < 3 {
+		return append(atLeast(lower), &comparator{"<", bump(upper, parts)}), nil
+	}
+	return append(atLeast(lower), &comparator{"<=", upper}), nil
+}
+
+// atLeast returns the comparator of the versions from v, or none for 0.0.0 which any version satisfies
+func atLeast(v *SemVer) []*comparator {
+	if *v == (SemVer{}) {
+		return nil
+	}
+	return []*comparator{{">=", v}}
+}
+
+// between returns the comparators of the versions matching the given components of v
+func between(v *SemVer, parts int) []*comparator {
+	if parts == 0 {
+		return nil
+	}
+	return append(atLeast(v), &comparator{"<", bump(v, parts)})
+}
+
+// bump returns the lowest version above the versions matching the given components of v
+func bump(v *SemVer, parts int) *SemVer {
+	switch parts {
+	case 0:
+		return &SemVer{}
+	case 1:
+		return &SemVer{major: v.major + 1}
+	case 2:
+		return &SemVer{major: v.major, minor: v.minor + 1}
+	}
+	return &SemVer{major: v.major, minor: v.minor, patch: v.patch + 1}
+}
+
+// parsePartial parses a version that may omit the minor and patch components or use x or * in their place. It
+// returns the version with the missing components set to 0 and the number of components given.
+func parsePartial(input string) (v *SemVer, parts int, err error) {
+	input = strings.TrimPrefix(strings.TrimSpace(input), "v")
+	core, rest := input, ""
+	if i := strings.IndexAny(input, "-+"); i >= 0 {
+		core, rest = input[:i], input[i:]
+	}
+	numbers := strings.Split(core, ".")
+	if len(numbers) > 3 || core == "" {
+		err = fmt.Errorf("invalid version %q", input)
+		return
+	}
+	components := [3]int{}
+	for parts < len(numbers) {
+		n := numbers[parts]
+		if n == "x" || n == "X" || n == "*" {
+			break
+		}
+		if components[parts], err = strconv.Atoi(n); err != nil || components[parts] < 0 {
+			err = fmt.Errorf("invalid version %q", input)
+			return
+		}
+		parts++
+	}
+	for i := parts; i < len(numbers); i++ {
+		if n := numbers[i]; n != "x" && n != "X" && n != "*" {
+			err = fmt.Errorf("invalid version %q", input)
+			return
+		}
+	}
+	if rest != "" {
+		if parts < 3 {
+			err = fmt.Errorf("invalid version %q", input)
+			return
+		}
+		if v, err = parse(input); err == nil {
+			parts = 3
+		}
+		return
+	}
+	v = &SemVer{major: components[0], minor: components[1], patch: components[2]}
+	return
+}
+
+// parseTolerant parses a version that may be prefixed with v and may omit the minor and patch components
+func parseTolerant(input string) (v *SemVer, err error) {
+	if strings.ContainsAny(input, "xX*") {
+		err = fmt.Errorf("invalid version %q", input)
+		return
+	}
+	v, _, err = parsePartial(input)
+	return
+}
+
+// Check returns true if the version satisfies the constraint
+func (c *Constraint) Check(v *SemVer) bool {
+	ok, _ := c.check(v, false)
+	return ok
+}
+
+// String returns the constraint as it was parsed
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+// check returns true if the version satisfies the constraint, or false and the reason it does not. A pre-release
+// version may satisfy any range if allowPreRelease is true.
+func (c *Constraint) check(v *SemVer, allowPreRelease bool) (bool, Reason) {
+	var reason Reason
+	for i, set := range c.sets {
+		setReason := ReasonCompatible
+		for _, cmp := range set {
+			if !cmp.matches(v) {
+				setReason = cmp.reason(v)
+				break
+			}
+		}
+		if setReason == ReasonCompatible && v.preRelease != "" && !allowPreRelease && !allowsPreRelease(set, v) {
+			setReason = ReasonPreRelease
+		}
+		if setReason == ReasonCompatible {
+			return true, setReason
+		}
+		if i == 0 {
+			reason = setReason
+		} else if reason != setReason {
+			reason = ReasonUnsatisfied
+		}
+	}
+	return false, reason
+}
+
+// allowsPreRelease checks if a comparator of the range has a pre-release of the same major, minor and patch
+func allowsPreRelease(set []*comparator, v *SemVer) bool {
+	for _, cmp := range set {
+		c := cmp.version
+		if c.preRelease != "" && c.major == v.major && c.minor == v.minor && c.patch == v.patch {
+			return true
+		}
+	}
+	return false
+}
+
+// matches checks if the version satisfies the comparator
+func (cmp *comparator) matches(v *SemVer) bool {
+	p := precedence(v, cmp.version)
+	switch cmp.op {
+	case "=":
+		return p == 0 && (cmp.version.build == "" || cmp.version.build == v.build)
+	case "!=":
+		return p != 0
+	case ">":
+		return p > 0
+	case ">=":
+		return p >= 0
+	case "<":
+		return p < 0
+	default:
+		return p <= 0
+	}
+}
+
+// reason returns the reason the version does not satisfy the comparator
+func (cmp *comparator) reason(v *SemVer) Reason {
+	switch cmp.op {
+	case "=":
+		p := precedence(v, cmp.version)
+		if p == 0 {
+			return ReasonBuildMismatch
+		} else if p < 0 {
+			return ReasonTooOld
+		}
+		return ReasonTooNew
+	case "!=":
+		return ReasonExcluded
+	case ">", ">=":
+		return ReasonTooOld
+	default:
+		return ReasonTooNew
+	}
+}
+
+// precedence compares the versions as defined by the semver specification. The build metadata is ignored.
+func precedence(v1, v2 *SemVer) int {
+	for _, d := range []int{v1.major - v2.major, v1.minor - v2.minor, v1.patch - v2.patch} {
+		if d != 0 {
+			if d > 0 {
+				return 1
+			}
+			return -1
+		}
+	}
+	switch {
+	case v1.preRelease == v2.preRelease:
+		return 0
+	case v1.preRelease == "":
+		return 1
+	case v2.preRelease == "":
+		return -1
+	}
+	ids1, ids2 := strings.Split(v1.preRelease, "."), strings.Split(v2.preRelease, ".")
+	for i := 0; i < len(ids1) && i < len(ids2); i++ {
+		n1, err1 := strconv.Atoi(ids1[i])
+		n2, err2 := strconv.Atoi(ids2[i])
+		switch {
+		case err1 == nil && err2 == nil:
+			if n1 != n2 {
+				if n1 > n2 {
+					return 1
+				}
+				return -1
+			}
+		case err1 == nil:
+			// numeric identifiers have a lower precedence than the alphanumeric ones
+			return -1
+		case err2 == nil:
+			return 1
+		default:
+			if c := strings.Compare(ids1[i], ids2[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(ids1) > len(ids2):
+		return 1
+	case len(ids1) < len(ids2):
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package semver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConstraint_Check(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"", "3.4.5", true},
+		{"*", "0.0.1", true},
+		{"1.2.3", "1.2.3", true},
+		{"=v1.2.3", "1.2.4", false},
+		{"1.2", "1.2.9", true},
+		{"1.2", "1.3.0", false},
+		{"1.x", "1.9.0", true},
+		{"1.2.x", "1.3.0", false},
+		{"!=1.2.3", "1.2.3", false},
+		{">1.2.3", "1.2.4", true},
+		{">1.2", "1.2.9", false},
+		{">1.2", "1.3.0", true},
+		{">=1.2, <2", "1.9.9", true},
+		{">= 1.2 < 2", "2.0.0", false},
+		{"<=1.2", "1.2.9", true},
+		{"<=1.2.3", "1.2.4", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"1.2 - 1.4", "1.4.7", true},
+		{"1.2 - 1.4.0", "1.4.1", false},
+		{"^1.0 || ^3.0", "3.1.0", true},
+		{"^1.0 || ^3.0", "2.1.0", false},
+		// a pre-release only satisfies a range with a pre-release of the same version
+		{"<2.0.0", "2.0.0-beta.1", false},
+		{">=1.2.0-beta.1", "1.2.0-beta.2", true},
+		{">=1.2.0-beta.1", "1.3.0-alpha", false},
+		{">=1.2.0-beta.2", "1.2.0-beta.11", true},
+		{">=1.2.0-rc.1", "1.2.0-beta.9", false},
+		// build metadata
+		{"=1.2.3+abc", "1.2.3+abc", true},
+		{"=1.2.3+abc", "1.2.3+def", false},
+		{"1.2.3", "1.2.3+def", true},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) error = %v", tt.constraint, err)
+			continue
+		}
+		v, _, err := parsePartial(tt.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Check(v); got != tt.want {
+			t.Errorf("%q.Check(%s) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseConstraint_Invalid(t *testing.T) {
+	for _, constraint := range []string{">=a.b", "1.2.3.4", "^", "!=1.2", ">=1.2-beta", "1.x.3"} {
+		if _, err := ParseConstraint(constraint); !errors.Is(err, ErrInvalidConstraint) {
+			t.Errorf("ParseConstraint(%q) error = %v", constraint, err)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	// the ordering example of the specification
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0"}
+	for i := 0; i+1 < len(ordered); i++ {
+		v1, _ := Parse(ordered[i])
+		v2, _ := Parse(ordered[i+1])
+		if precedence(v1, v2) != -1 || precedence(v2, v1) != 1 || precedence(v1, v1) != 0 {
+			t.Errorf("precedence(%s, %s) is not ordered", ordered[i], ordered[i+1])
+		}
+	}
+}
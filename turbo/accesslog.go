@@ -0,0 +1,308 @@
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRequestIdHeader is the header the request id is read from
+	DefaultRequestIdHeader = "X-Request-Id"
+	// RedactedValue replaces the values of the redacted headers
+	RedactedValue = "[REDACTED]"
+	// CaptureSlow is the reason of the records captured for the requests slower than the threshold
+	CaptureSlow = "slow"
+	// CaptureError is the reason of the records captured for the requests failed with a 5xx status
+	CaptureError = "error"
+)
+
+// defaultRedactedHeaders are always redacted in the captured records
+var defaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// marksKey is the context key of the timing marks of a request
+type marksKey struct{}
+
+// TimingMark is a named point in time recorded by the handlers of a request using Mark
+type TimingMark struct {
+	// Name of the mark
+	Name string `json:"name"`
+	// Elapsed is the time since the start of the request
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// timingMarks holds the marks of a request
+type timingMarks struct {
+	start time.Time
+	mutex sync.Mutex
+	marks []TimingMark
+}
+
+// AccessRecord is an entry of the access log
+type AccessRecord struct {
+	Time       time.Time     `json:"time"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	Status     int           `json:"status"`
+	Duration   time.Duration `json:"duration"`
+	Bytes      int64         `json:"bytes"`
+	RemoteAddr string        `json:"remote_addr"`
+	RequestId  string        `json:"request_id,omitempty"`
+	// Capture is the reason the extended record is captured, CaptureSlow or CaptureError. Empty for sampled records.
+	Capture string `json:"capture,omitempty"`
+	// Headers of the request with the redacted headers replaced. Only set on captured records.
+	Headers      http.Header  `json:"headers,omitempty"`
+	RequestBody  string       `json:"request_body,omitempty"`
+	ResponseBody string       `json:"response_body,omitempty"`
+	Marks        []TimingMark `json:"marks,omitempty"`
+}
+
+// AccessLogOptions configures the AccessLog filter.
+// The route patterns are matched with path.Match against the path of the request, the longest matching pattern wins.
+type AccessLogOptions struct {
+	// SampleRate is the fraction of the healthy fast requests logged, 0 logs none and 1 logs all
+	SampleRate float64
+	// RouteSampleRates overrides the SampleRate for the route patterns
+	RouteSampleRates map[string]float64
+	// AlwaysLog are the route patterns that are always logged
+	AlwaysLog []string
+	// SlowThreshold captures the extended record of the requests slower than the threshold. Disabled if 0.
+	SlowThreshold time.Duration
+	// RouteSlowThresholds overrides the SlowThreshold for the route patterns
+	RouteSlowThresholds map[string]time.Duration
+	// RedactHeaders are the headers redacted in the captured records in addition to the Authorization,
+	// Proxy-Authorization, Cookie and Set-Cookie headers
+	RedactHeaders []string
+	// CaptureBodyBytes is the number of bytes of the request and the response bodies kept for the captured records.
+	// The bodies of all the requests are buffered up to this size. Only the part of the request body read by the
+	// handler is kept. Disabled if 0.
+	CaptureBodyBytes int
+	// RequestIdHeader is the header of the request id. Defaults to DefaultRequestIdHeader.
+	RequestIdHeader string
+	// Sink receives the records. Defaults to logging the records as JSON, the captured ones at the warn level.
+	Sink func(record *AccessRecord)
+	// Random returns a number in [0,1) to sample the requests. Defaults to math/rand.
+	Random func() float64
+}
+
+// accessLog is the state of the AccessLog filter
+type accessLog struct {
+	opts   AccessLogOptions
+	redact map[string]bool
+}
+
+// AccessLog logs a sample of the healthy requests and captures an extended record of the slow and failed requests.
+// The requests are timed and their status and size recorded; the headers and the bodies are copied only for the
+// requests that are captured.
+func AccessLog(opts AccessLogOptions) FilterFunc {
+	if opts.RequestIdHeader == "" {
+		opts.RequestIdHeader = DefaultRequestIdHeader
+	}
+	if opts.Sink == nil {
+		opts.Sink = logAccessRecord
+	}
+	if opts.Random == nil {
+		opts.Random = rand.Float64
+	}
+	al := &accessLog{opts: opts, redact: make(map[string]bool)}
+	for _, h := range append(append([]string{}, defaultRedactedHeaders...), opts.RedactHeaders...) {
+		al.redact[http.CanonicalHeaderKey(h)] = true
+	}
+	return al.filter
+}
+
+// Mark records a named point in time of the request. The marks are added to the captured access records.
+// Mark does nothing if the request is not served through the AccessLog filter.
+func Mark(r *http.Request, name string) {
+	if tm, ok := r.Context().Value(marksKey{}).(*timingMarks); ok {
+		tm.mutex.Lock()
+		tm.marks = append(tm.marks, TimingMark{Name: name, Elapsed: time.Since(tm.start)})
+		tm.mutex.Unlock()
+	}
+}
+
+// Marks returns the marks recorded for the request
+func Marks(r *http.Request) (marks []TimingMark) {
+	if tm, ok := r.Context().Value(marksKey{}).(*timingMarks); ok {
+		tm.mutex.Lock()
+		marks = append(marks, tm.marks...)
+		tm.mutex.Unlock()
+	}
+	return
+}
+
+// filter wraps the handler with the access logging
+func (al *accessLog) filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aw := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+		tm := &aw.marks
+		tm.start = time.Now()
+		r = r.WithContext(context.WithValue(r.Context(), marksKey{}, tm))
+		var reqBody *capturingReader
+		if al.opts.CaptureBodyBytes > 0 {
+			aw.limit = al.opts.CaptureBodyBytes
+			if r.Body != nil && r.Body != http.NoBody {
+				reqBody = &capturingReader{ReadCloser: r.Body, limit: al.opts.CaptureBodyBytes}
+				r.Body = reqBody
+			}
+		}
+		next.ServeHTTP(aw, r)
+		duration := time.Since(tm.start)
+		p := r.URL.Path
+		capture := ""
+		if aw.status >= http.StatusInternalServerError {
+			capture = CaptureError
+		} else if threshold := al.slowThreshold(p); threshold > 0 && duration > threshold {
+			capture = CaptureSlow
+		}
+		if capture == "" && !matchesAny(al.opts.AlwaysLog, p) && al.opts.Random() >= al.sampleRate(p) {
+			return
+		}
+		record := &AccessRecord{
+			Time:       tm.start,
+			Method:     r.Method,
+			Path:       p,
+			Status:     aw.status,
+			Duration:   duration,
+			Bytes:      aw.bytes,
+			RemoteAddr: r.RemoteAddr,
+			RequestId:  r.Header.Get(al.opts.RequestIdHeader),
+			Capture:    capture,
+		}
+		if capture != "" {
+			record.Headers = al.redactHeaders(r.Header)
+			record.ResponseBody = capturedString(&aw.captured)
+			if reqBody != nil {
+				record.RequestBody = capturedString(&reqBody.captured)
+			}
+			record.Marks = Marks(r)
+		}
+		al.opts.Sink(record)
+	})
+}
+
+// sampleRate returns the sample rate of the path
+func (al *accessLog) sampleRate(p string) float64 {
+	return routeValue(al.opts.RouteSampleRates, p, al.opts.SampleRate)
+}
+
+// slowThreshold returns the slow threshold of the path
+func (al *accessLog) slowThreshold(p string) time.Duration {
+	return routeValue(al.opts.RouteSlowThresholds, p, al.opts.SlowThreshold)
+}
+
+// routeValue returns the value of the longest route pattern matching the path or the fallback
+func routeValue[T any](values map[string]T, p string, fallback T) (value T) {
+	value = fallback
+	matched := -1
+	for pattern, v := range values {
+		if ok, _ := path.Match(pattern, p); ok && len(pattern) > matched {
+			value, matched = v, len(pattern)
+		}
+	}
+	return
+}
+
+// redactHeaders copies the headers replacing the values of the redacted ones
+func (al *accessLog) redactHeaders(header http.Header) http.Header {
+	copied := header.Clone()
+	for name := range copied {
+		if al.redact[name] {
+			copied[name] = []string{RedactedValue}
+		}
+	}
+	return copied
+}
+
+// matchesAny checks if the path matches any of the patterns
+func matchesAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// logAccessRecord logs the record as JSON
+func logAccessRecord(record *AccessRecord) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		logger.ErrorF("unable to encode the access record: %v", err)
+		return
+	}
+	if record.Capture != "" {
+		logger.Warn(string(b))
+	} else {
+		logger.Info(string(b))
+	}
+}
+
+// accessWriter records the status and the size of the response. It holds the marks of the request to save an
+// allocation.
+type accessWriter struct {
+	http.ResponseWriter
+	marks       timingMarks
+	status      int
+	wroteHeader bool
+	bytes       int64
+	limit       int
+	captured    bytes.Buffer
+}
+
+func (aw *accessWriter) WriteHeader(status int) {
+	if !aw.wroteHeader {
+		aw.status = status
+		aw.wroteHeader = true
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessWriter) Write(b []byte) (n int, err error) {
+	aw.wroteHeader = true
+	n, err = aw.ResponseWriter.Write(b)
+	aw.bytes += int64(n)
+	if remaining := aw.limit - aw.captured.Len(); remaining > 0 {
+		aw.captured.Write(b[:min(n, remaining)])
+	}
+	return
+}
+
+// Flush flushes the response if the underlying writer supports it
+func (aw *accessWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (aw *accessWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// capturingReader keeps the first bytes read from the body
+type capturingReader struct {
+	io.ReadCloser
+	limit    int
+	captured bytes.Buffer
+}
+
+func (cr *capturingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.ReadCloser.Read(p)
+	if remaining := cr.limit - cr.captured.Len(); remaining > 0 && n > 0 {
+		cr.captured.Write(p[:min(n, remaining)])
+	}
+	return
+}
+
+// capturedString returns the captured bytes as a valid UTF-8 string
+func capturedString(b *bytes.Buffer) string {
+	return strings.ToValidUTF8(b.String(), "�")
+}
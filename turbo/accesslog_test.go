@@ -0,0 +1,141 @@
+package turbo
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// accessLogRouter returns a router with the AccessLog filter recording the records
+func accessLogRouter(t *testing.T, opts AccessLogOptions) (*Router, *[]*AccessRecord) {
+	records := &[]*AccessRecord{}
+	opts.Sink = func(record *AccessRecord) {
+		*records = append(*records, record)
+	}
+	router := NewRouter()
+	router.AddGlobalFilter(AccessLog(opts))
+	handlers := map[string]func(w http.ResponseWriter, r *http.Request){
+		"/fast": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		},
+		"/slow": func(w http.ResponseWriter, r *http.Request) {
+			Mark(r, "db-done")
+			time.Sleep(20 * time.Millisecond)
+			Mark(r, "render-done")
+			_, _ = w.Write([]byte("slow response"))
+		},
+		"/reports/daily": func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+		},
+		"/fail": func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write(append([]byte("failed "), body...))
+		},
+		"/admin/users": func(w http.ResponseWriter, r *http.Request) {},
+	}
+	for p, h := range handlers {
+		if _, err := router.Add(p, h, http.MethodGet, http.MethodPost); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return router, records
+}
+
+func TestAccessLog_Sampling(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	router, records := accessLogRouter(t, AccessLogOptions{
+		SampleRate:       0.1,
+		RouteSampleRates: map[string]float64{"/admin/*": 0.5},
+		AlwaysLog:        []string{"/admin/users"},
+		Random:           rnd.Float64,
+	})
+	const n = 10000
+	for i := 0; i < n; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	}
+	// within 4 standard deviations of the sample rate
+	if got := len(*records); got < 880 || got > 1120 {
+		t.Errorf("AccessLog() logged %d of %d requests, want about %d", got, n, n/10)
+	}
+	for _, record := range *records {
+		if record.Capture != "" || record.Headers != nil || record.Marks != nil {
+			t.Fatalf("AccessLog() sampled record has the extended fields %+v", record)
+		}
+	}
+	*records = nil
+	for i := 0; i < 100; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/users", nil))
+	}
+	if len(*records) != 100 {
+		t.Errorf("AccessLog() logged %d of the always logged requests, want 100", len(*records))
+	}
+}
+
+func TestAccessLog_CaptureSlow(t *testing.T) {
+	router, records := accessLogRouter(t, AccessLogOptions{
+		SlowThreshold:       10 * time.Millisecond,
+		RouteSlowThresholds: map[string]time.Duration{"/reports/*": time.Second},
+		RedactHeaders:       []string{"x-api-key"},
+		CaptureBodyBytes:    4,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set("Accept", "text/plain")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	// legitimately slow
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reports/daily", nil))
+	if len(*records) != 1 {
+		t.Fatalf("AccessLog() logged %d records, want 1", len(*records))
+	}
+	record := (*records)[0]
+	if record.Capture != CaptureSlow || record.RequestId != "req-1" || record.Path != "/slow" {
+		t.Errorf("AccessLog() record = %+v", record)
+	}
+	if record.Headers.Get("Authorization") != RedactedValue || record.Headers.Get("X-Api-Key") != RedactedValue {
+		t.Errorf("AccessLog() headers are not redacted %v", record.Headers)
+	}
+	if record.Headers.Get("Accept") != "text/plain" || req.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("AccessLog() headers = %v", record.Headers)
+	}
+	if record.ResponseBody != "slow" || record.Bytes != int64(len("slow response")) {
+		t.Errorf("AccessLog() response body = %q, bytes = %d", record.ResponseBody, record.Bytes)
+	}
+	if len(record.Marks) != 2 || record.Marks[0].Name != "db-done" || record.Marks[1].Name != "render-done" ||
+		record.Marks[1].Elapsed < record.Marks[0].Elapsed+20*time.Millisecond {
+		t.Errorf("AccessLog() marks = %v", record.Marks)
+	}
+}
+
+func TestAccessLog_CaptureError(t *testing.T) {
+	router, records := accessLogRouter(t, AccessLogOptions{CaptureBodyBytes: 1024})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/fail", strings.NewReader("payload")))
+	if len(*records) != 1 {
+		t.Fatalf("AccessLog() logged %d records, want 1", len(*records))
+	}
+	record := (*records)[0]
+	if record.Capture != CaptureError || record.Status != http.StatusBadGateway {
+		t.Errorf("AccessLog() record = %+v", record)
+	}
+	if record.RequestBody != "payload" || record.ResponseBody != "failed payload" {
+		t.Errorf("AccessLog() bodies = %q, %q", record.RequestBody, record.ResponseBody)
+	}
+	if record.Marks != nil {
+		t.Errorf("AccessLog() marks = %v, want none", record.Marks)
+	}
+}
+
+func TestMark_WithoutAccessLog(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	Mark(req, "ignored")
+	if marks := Marks(req); marks != nil {
+		t.Errorf("Marks() = %v, want nil", marks)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 )
 
 // BenchmarkFindRouteStatic: Static Path Test
@@ -107,3 +108,24 @@ func BenchmarkRouter_ServeHTTPParams(b *testing.B) {
 		router.ServeHTTP(w, r)
 	}
 }
+
+// BenchmarkAccessLogSampledOut: overhead of the AccessLog filter for the requests that are not logged
+func BenchmarkAccessLogSampledOut(b *testing.B) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	filtered := AccessLog(AccessLogOptions{SampleRate: 0, SlowThreshold: time.Second})(handler)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	b.Run("baseline", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.ServeHTTP(w, req)
+		}
+	})
+	b.Run("access-log", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			filtered.ServeHTTP(w, req)
+		}
+	})
+}
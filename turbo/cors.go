@@ -0,0 +1,142 @@
+package turbo
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"oss.nandlabs.io/golly/turbo/filters"
+)
+
+// AllowHeader lists the methods served by a path in the response to an OPTIONS request
+const AllowHeader = "Allow"
+
+// corsPolicy is the CORS configuration of a router, a group or a route. A policy without a filter disables CORS.
+type corsPolicy struct {
+	filter *filters.CorsFilter
+}
+
+// newCorsPolicy returns the policy of the options, or nil to inherit the policy of the enclosing level
+func newCorsPolicy(opts *filters.CorsOptions) *corsPolicy {
+	if opts == nil {
+		return nil
+	}
+	return &corsPolicy{filter: opts.NewFilter()}
+}
+
+// UseCORS sets the default CORS policy of the routes. The policy of a group or a route overrides it, the most
+// specific one applies. Passing nil removes the default.
+//
+// The preflight requests of a path are answered by the router before any route filter or authenticator, with
+// Access-Control-Allow-Methods listing the methods registered for the path that the policy allows. The CORS headers
+// of the actual requests are set before the authenticator runs, unless the policy allows credentials in which case
+// they are only set once the request is authenticated.
+func (router *Router) UseCORS(opts *filters.CorsOptions) *Router {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	router.cors = newCorsPolicy(opts)
+	return router
+}
+
+// CORS sets the CORS policy of the route overriding the one of its group and router. Passing nil removes the
+// override.
+func (route *Route) CORS(opts *filters.CorsOptions) *Route {
+	route.cors = newCorsPolicy(opts)
+	return route
+}
+
+// NoCORS disables CORS for the route. No CORS header is set on its responses, whatever the policy of its group and
+// router.
+func (route *Route) NoCORS() *Route {
+	route.cors = &corsPolicy{}
+	return route
+}
+
+// corsFor returns the filter of the most specific CORS policy of the route, or nil if CORS is disabled
+func (router *Router) corsFor(route *Route) *filters.CorsFilter {
+	if route.cors != nil {
+		return route.cors.filter
+	}
+	for group := route.group; group != nil; group = group.parent {
+		if group.cors != nil {
+			return group.cors.filter
+		}
+	}
+	if router.cors != nil {
+		return router.cors.filter
+	}
+	return nil
+}
+
+// answersOptions checks if the router answers the OPTIONS request of the route itself. That is the case of the
+// preflight requests of a route with a CORS policy and of the routes without an OPTIONS handler.
+func (router *Router) answersOptions(route *Route, r *http.Request) bool {
+	if filters.IsPreflight(r) && router.corsFor(route) != nil {
+		return true
+	}
+	return len(route.handlers) > 0 && route.handlers[OPTIONS] == nil
+}
+
+// allowedMethods returns the sorted methods registered for the route including OPTIONS
+func (route *Route) allowedMethods() []string {
+	methods := make([]string, 0, len(route.handlers)+1)
+	for method := range route.handlers {
+		methods = append(methods, method)
+	}
+	if route.handlers[OPTIONS] == nil {
+		methods = append(methods, OPTIONS)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// optionsHandler answers the OPTIONS requests of the route with the Allow header and the preflight headers of its
+// CORS policy
+func (router *Router) optionsHandler(route *Route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods := route.allowedMethods()
+		w.Header().Set(AllowHeader, strings.Join(methods, ", "))
+		cf := router.corsFor(route)
+		if cf == nil || !filters.IsPreflight(r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		cf.HandlePreflightFor(w, r, methods)
+		if handler := route.handlers[OPTIONS]; handler != nil && cf.PreFlightPassThrough {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		status := cf.ResponseStatus
+		if status == 0 {
+			status = http.StatusNoContent
+		}
+		w.WriteHeader(status)
+	})
+}
+
+// applyCors wraps the handler of the route with its authenticator and the CORS headers of its policy. The headers
+// are set inside the authenticator when the policy allows credentials and outside of it otherwise.
+func (router *Router) applyCors(route *Route, handler http.Handler) http.Handler {
+	cf := router.corsFor(route)
+	withCors := func(next http.Handler) http.Handler {
+		if cf == nil || next == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cf.HandleActualRequest(w, r)
+			next.ServeHTTP(w, r)
+		})
+	}
+	if cf != nil && cf.AllowCredentials {
+		handler = withCors(handler)
+	}
+	// check for authenticated filter explicitly at the top
+	// we add all the filters added by the user in its order and if the user has added an Authenticator Filter then it will always be executed first
+	if route.authFilter != nil {
+		handler = route.authFilter.Apply(handler)
+	}
+	if cf != nil && !cf.AllowCredentials {
+		handler = withCors(handler)
+	}
+	return handler
+}
@@ -0,0 +1,222 @@
+package turbo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oss.nandlabs.io/golly/turbo/filters"
+)
+
+const (
+	consoleOrigin = "https://console.internal"
+	publicOrigin  = "https://app.example.com"
+)
+
+// headerAuth rejects the requests without an Authorization header and records the CORS origin set before it ran
+type headerAuth struct {
+	calls        int
+	originBefore string
+}
+
+func (a *headerAuth) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.calls++
+		a.originBefore = w.Header().Get(filters.AllowOriginHeader)
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func corsRouter(t *testing.T) *Router {
+	router := NewRouter().UseCORS(&filters.CorsOptions{AllowedOrigins: []string{"https://*.example.com"}})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	public := router.Group("/v1/public")
+	public.UseCORS(&filters.CorsOptions{AllowedOrigins: []string{"*"}, MaxAge: 600})
+	admin := router.Group("/v1/").Group("admin")
+	admin.UseCORS(&filters.CorsOptions{AllowedOrigins: []string{consoleOrigin}, AllowCredentials: true})
+
+	for _, err := range []error{
+		second(public.Get("/items", ok)),
+		second(public.Add("/items", ok, POST, DELETE)),
+		second(admin.Get("/users/{id}", ok)),
+		second(router.Get("/v1/status", ok)),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	webhook, err := public.Post("/webhook", ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook.NoCORS()
+	special, err := admin.Put("/special", ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	special.CORS(&filters.CorsOptions{AllowedOrigins: []string{publicOrigin}})
+	return router
+}
+
+func second(_ *Route, err error) error {
+	return err
+}
+
+func serve(router *Router, method, path, origin string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		r.Header.Set(filters.OriginHeader, origin)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func preflight(router *Router, path, origin, method string) *httptest.ResponseRecorder {
+	return serve(router, OPTIONS, path, origin, filters.AccessControlReqMethodHdr, method)
+}
+
+func TestCors_Precedence(t *testing.T) {
+	router := corsRouter(t)
+	tests := []struct {
+		name, path, origin, want string
+	}{
+		{"router default", "/v1/status", publicOrigin, publicOrigin},
+		{"router default other origin", "/v1/status", consoleOrigin, ""},
+		{"group", "/v1/public/items", "https://anyone.org", "*"},
+		{"nested group", "/v1/admin/users/1", consoleOrigin, consoleOrigin},
+		{"nested group other origin", "/v1/admin/users/1", publicOrigin, ""},
+		{"route", "/v1/admin/special", publicOrigin, publicOrigin},
+		{"route other origin", "/v1/admin/special", consoleOrigin, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := GET
+			if tt.path == "/v1/admin/special" {
+				method = PUT
+			}
+			w := serve(router, method, tt.path, tt.origin, "Authorization", "token")
+			if got := w.Header().Get(filters.AllowOriginHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", filters.AllowOriginHeader, got, tt.want)
+			}
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d", w.Code)
+			}
+			w = preflight(router, tt.path, tt.origin, method)
+			if got := w.Header().Get(filters.AllowOriginHeader); got != tt.want {
+				t.Errorf("preflight %s = %q, want %q", filters.AllowOriginHeader, got, tt.want)
+			}
+		})
+	}
+
+	// removing the group policy falls back to the router default
+	group := router.Group("/v2")
+	group.UseCORS(&filters.CorsOptions{AllowedOrigins: []string{consoleOrigin}})
+	if _, err := group.Get("/items", func(w http.ResponseWriter, r *http.Request) {}); err != nil {
+		t.Fatal(err)
+	}
+	group.UseCORS(nil)
+	if got := serve(router, GET, "/v2/items", publicOrigin).Header().Get(filters.AllowOriginHeader); got != publicOrigin {
+		t.Errorf("%s = %q after removing the group policy", filters.AllowOriginHeader, got)
+	}
+}
+
+func TestCors_Preflight(t *testing.T) {
+	router := corsRouter(t)
+	w := preflight(router, "/v1/public/items", publicOrigin, "delete")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d", w.Code)
+	}
+	// Allow and Access-Control-Allow-Methods list the registered methods
+	if got := w.Header().Get(AllowHeader); got != "DELETE, GET, OPTIONS, POST" {
+		t.Errorf("%s = %q", AllowHeader, got)
+	}
+	if got := w.Header().Get(filters.AllowMethodsHeader); got != "DELETE, GET, OPTIONS, POST" {
+		t.Errorf("%s = %q", filters.AllowMethodsHeader, got)
+	}
+	if got := w.Header().Get(filters.MaxAgeHeader); got != "600" {
+		t.Errorf("%s = %q", filters.MaxAgeHeader, got)
+	}
+
+	// a method not registered for the path is not allowed
+	w = preflight(router, "/v1/public/items", publicOrigin, PATCH)
+	if got := w.Header().Get(filters.AllowOriginHeader); got != "" {
+		t.Errorf("%s = %q for an unregistered method", filters.AllowOriginHeader, got)
+	}
+
+	// a plain OPTIONS request gets the Allow header only
+	w = serve(router, OPTIONS, "/v1/status", "")
+	if w.Code != http.StatusNoContent || w.Header().Get(AllowHeader) != "GET, OPTIONS" {
+		t.Errorf("OPTIONS = %d %q", w.Code, w.Header().Get(AllowHeader))
+	}
+	if got := w.Header().Get(filters.AllowMethodsHeader); got != "" {
+		t.Errorf("%s = %q without a preflight", filters.AllowMethodsHeader, got)
+	}
+}
+
+func TestCors_NoCORS(t *testing.T) {
+	router := corsRouter(t)
+	for _, w := range []*httptest.ResponseRecorder{
+		serve(router, POST, "/v1/public/webhook", publicOrigin),
+		preflight(router, "/v1/public/webhook", publicOrigin, POST),
+	} {
+		for name := range w.Header() {
+			if len(name) > 15 && name[:15] == "Access-Control-" {
+				t.Errorf("header %s set on a route without CORS", name)
+			}
+		}
+	}
+	if w := preflight(router, "/v1/public/webhook", publicOrigin, POST); w.Header().Get(AllowHeader) != "OPTIONS, POST" {
+		t.Errorf("%s = %q", AllowHeader, w.Header().Get(AllowHeader))
+	}
+}
+
+func TestCors_AuthOrdering(t *testing.T) {
+	router := corsRouter(t)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	adminAuth, publicAuth := &headerAuth{}, &headerAuth{}
+	adminRoute, _ := router.Group("/v1/admin").UseCORS(&filters.CorsOptions{AllowedOrigins: []string{consoleOrigin},
+		AllowCredentials: true}).Get("/reports", ok)
+	adminRoute.AddAuthenticator(adminAuth)
+	publicRoute, _ := router.Get("/v1/feed", ok)
+	publicRoute.AddAuthenticator(publicAuth)
+
+	// the preflights carry no credentials and are answered without the authenticator
+	for _, path := range []string{"/v1/admin/reports", "/v1/feed"} {
+		origin := publicOrigin
+		if path == "/v1/admin/reports" {
+			origin = consoleOrigin
+		}
+		w := preflight(router, path, origin, GET)
+		if w.Code != http.StatusNoContent || w.Header().Get(filters.AllowOriginHeader) != origin {
+			t.Errorf("preflight %s = %d %q", path, w.Code, w.Header().Get(filters.AllowOriginHeader))
+		}
+	}
+	if adminAuth.calls != 0 || publicAuth.calls != 0 {
+		t.Errorf("the authenticator ran for a preflight")
+	}
+
+	// with credentials the CORS headers are set once the request is authenticated
+	w := serve(router, GET, "/v1/admin/reports", consoleOrigin)
+	if w.Code != http.StatusUnauthorized || w.Header().Get(filters.AllowOriginHeader) != "" {
+		t.Errorf("unauthenticated = %d %q", w.Code, w.Header().Get(filters.AllowOriginHeader))
+	}
+	w = serve(router, GET, "/v1/admin/reports", consoleOrigin, "Authorization", "token")
+	if w.Code != http.StatusOK || w.Header().Get(filters.AllowCredentials) != "true" || adminAuth.originBefore != "" {
+		t.Errorf("authenticated = %d %v, origin before auth %q", w.Code, w.Header(), adminAuth.originBefore)
+	}
+
+	// without credentials they are set before the authenticator
+	w = serve(router, GET, "/v1/feed", publicOrigin)
+	if w.Code != http.StatusUnauthorized || publicAuth.originBefore != publicOrigin {
+		t.Errorf("unauthenticated = %d, origin before auth %q", w.Code, publicAuth.originBefore)
+	}
+}
@@ -2,6 +2,7 @@ package filters
 
 import (
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 
@@ -147,17 +148,35 @@ func (cf *CorsFilter) SetAllowPvtNetwork(allow bool) {
 	}
 }
 
-// isOriginAllowed checks if the origin is allowed
+// isOriginAllowed checks if the origin is allowed. An allowed origin may be a pattern with * matching any part of
+// a host name such as https://*.example.com
 func (cf *CorsFilter) isOriginAllowed(origin string) (bool, string) {
 	if cf.AllowAllOrigins {
 		return true, AccessControlAllowAllOrigins
 	}
-	return assertion.ListHas(strings.ToLower(origin), cf.AllowedOrigins), origin
+	lower := strings.ToLower(origin)
+	for _, allowed := range cf.AllowedOrigins {
+		if allowed == lower {
+			return true, origin
+		}
+		if strings.Contains(allowed, "*") {
+			if ok, _ := path.Match(allowed, lower); ok {
+				return true, origin
+			}
+		}
+	}
+	return false, origin
 }
 
-// isMethodAllowed checks if the method is allowed
+// isMethodAllowed checks if the method is allowed. The methods are not restricted when AllowedMethods is empty.
 func (cf *CorsFilter) isMethodAllowed(method string) bool {
-	return cf.AllowAllMethods || assertion.ListHas(method, cf.AllowedMethods)
+	return cf.AllowAllMethods || len(cf.AllowedMethods) == 0 || assertion.ListHas(method, cf.AllowedMethods)
+}
+
+// IsPreflight checks if the request is a CORS preflight request
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get(OriginHeader) != textutils.EmptyStr &&
+		r.Header.Get(AccessControlReqMethodHdr) != textutils.EmptyStr
 }
 
 // handlePreflight handles the preflight request
@@ -165,6 +184,19 @@ func (cf *CorsFilter) isMethodAllowed(method string) bool {
 // and sets the appropriate headers
 
 func (cf *CorsFilter) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	cf.preflight(w, r, nil)
+}
+
+// HandlePreflightFor handles the preflight request of a path serving the methods. The requested method must be one
+// of them, and Access-Control-Allow-Methods lists all of them that are allowed so that it agrees with the Allow
+// header of the path.
+func (cf *CorsFilter) HandlePreflightFor(w http.ResponseWriter, r *http.Request, methods []string) {
+	cf.preflight(w, r, methods)
+}
+
+// preflight sets the headers of the preflight response. The allowed methods are the requested one when methods is
+// nil.
+func (cf *CorsFilter) preflight(w http.ResponseWriter, r *http.Request, methods []string) {
 	reqOrigin := r.Header.Get(OriginHeader)
 
 	// Add Vary Headers
@@ -179,12 +211,25 @@ func (cf *CorsFilter) handlePreflight(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if the method is allowed.
-	if methodAllowed := cf.isMethodAllowed(r.Header.Get(AccessControlReqMethodHdr)); !methodAllowed {
+	reqMethod := strings.ToUpper(r.Header.Get(AccessControlReqMethodHdr))
+	allowMethods := reqMethod
+	if methods != nil {
+		var allowed []string
+		for _, m := range methods {
+			if cf.isMethodAllowed(m) {
+				allowed = append(allowed, m)
+			}
+		}
+		if !assertion.ListHas(reqMethod, allowed) {
+			return
+		}
+		allowMethods = strings.Join(allowed, ", ")
+	} else if methodAllowed := cf.isMethodAllowed(reqMethod); !methodAllowed {
 
 		return
 	}
 	w.Header().Set(AllowOriginHeader, origin)
-	w.Header().Set(AllowMethodsHeader, r.Header.Get(AccessControlReqMethodHdr))
+	w.Header().Set(AllowMethodsHeader, allowMethods)
 
 	if len(cf.AllowedHeaders) > 0 {
 		// Set the allowed headers
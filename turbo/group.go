@@ -0,0 +1,93 @@
+package turbo
+
+import (
+	"net/http"
+	"strings"
+
+	"oss.nandlabs.io/golly/turbo/filters"
+)
+
+// Group registers routes under a common path prefix and holds the configuration shared by them
+type Group struct {
+	//router the routes are registered with
+	router *Router
+	//prefix of the paths of the routes
+	prefix string
+	//parent group whose configuration applies unless overridden
+	parent *Group
+	//cors policy of the routes of the group
+	cors *corsPolicy
+}
+
+// Group returns a new group of routes under the prefix
+func (router *Router) Group(prefix string) *Group {
+	return &Group{router: router, prefix: strings.TrimSuffix(prefix, PathSeparator)}
+}
+
+// Group returns a new group nested in this one. The prefix is appended to the prefix of this group and the
+// configuration of this group applies to the nested one unless overridden.
+func (group *Group) Group(prefix string) *Group {
+	nested := group.router.Group(group.path(prefix))
+	nested.parent = group
+	return nested
+}
+
+// Prefix returns the path prefix of the routes of the group
+func (group *Group) Prefix() string {
+	return group.prefix
+}
+
+// UseCORS sets the CORS policy of the routes of the group overriding the one of the router and of the parent
+// group. Passing nil removes the override.
+func (group *Group) UseCORS(opts *filters.CorsOptions) *Group {
+	group.cors = newCorsPolicy(opts)
+	return group
+}
+
+// NoCORS disables CORS for the routes of the group unless a route sets its own policy
+func (group *Group) NoCORS() *Group {
+	group.cors = &corsPolicy{}
+	return group
+}
+
+// path returns the path of the group followed by the path
+func (group *Group) path(path string) string {
+	if !strings.HasPrefix(path, PathSeparator) {
+		path = PathSeparator + path
+	}
+	return group.prefix + path
+}
+
+// AddHandler registers the handler for one or more HTTP methods on the path relative to the group
+func (group *Group) AddHandler(path string, h http.Handler, methods ...string) (route *Route, err error) {
+	route, err = group.router.AddHandler(group.path(path), h, methods...)
+	if err == nil {
+		route.group = group
+	}
+	return
+}
+
+// Add a turbo handler for one or more HTTP methods on the path relative to the group
+func (group *Group) Add(path string, f func(w http.ResponseWriter, r *http.Request), methods ...string) (*Route, error) {
+	return group.AddHandler(path, http.HandlerFunc(f), methods...)
+}
+
+// Get to Add a turbo handler for GET method
+func (group *Group) Get(path string, f func(w http.ResponseWriter, r *http.Request)) (*Route, error) {
+	return group.Add(path, f, GET)
+}
+
+// Post to Add a turbo handler for POST method
+func (group *Group) Post(path string, f func(w http.ResponseWriter, r *http.Request)) (*Route, error) {
+	return group.Add(path, f, POST)
+}
+
+// Put to Add a turbo handler for PUT method
+func (group *Group) Put(path string, f func(w http.ResponseWriter, r *http.Request)) (*Route, error) {
+	return group.Add(path, f, PUT)
+}
+
+// Delete to Add a turbo handler for DELETE method
+func (group *Group) Delete(path string, f func(w http.ResponseWriter, r *http.Request)) (*Route, error) {
+	return group.Add(path, f, DELETE)
+}
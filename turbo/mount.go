@@ -0,0 +1,215 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"oss.nandlabs.io/golly/textutils"
+)
+
+// ErrMountConflict is returned when a mount prefix overlaps an existing route or mount.
+var ErrMountConflict = errors.New("mount conflict")
+
+// MountPoint holds a handler mounted under a path prefix of the router.
+type MountPoint struct {
+	//prefix as provided by the user (sanitized)
+	prefix string
+	//segments of the prefix. Path variables are stored as :<name>
+	segments []string
+	//handler to delegate to
+	handler http.Handler
+	//child is set when the mounted handler is a turbo router
+	child *Router
+	//stripPrefix removes the prefix from the request path before delegating
+	stripPrefix bool
+}
+
+// Prefix returns the prefix under which the handler is mounted.
+func (mp *MountPoint) Prefix() string {
+	return mp.prefix
+}
+
+// StripPrefix configures if the mount prefix is removed from the request path before delegating.
+// The prefix is stripped by default.
+func (mp *MountPoint) StripPrefix(strip bool) *MountPoint {
+	mp.stripPrefix = strip
+	return mp
+}
+
+// PreservePrefix keeps the mount prefix on the request path. This is required by handlers that
+// do their own routing on the full path such as net/http/pprof.
+func (mp *MountPoint) PreservePrefix() *MountPoint {
+	return mp.StripPrefix(false)
+}
+
+// Mount delegates every request under the prefix to the handler.
+// Any miss inside the mounted handler is handled by the mounted handler itself.
+// If the handler is a *Router its routes are listed as part of this router's Routes with the prefix applied.
+// Path variables defined in the prefix are available to the mounted handler using GetPathParam.
+func (router *Router) Mount(prefix string, handler http.Handler) (mp *MountPoint, err error) {
+	var pathValue string
+	if handler == nil {
+		err = ErrInvalidHandler
+		return
+	}
+	pathValue, err = sanitizePath(prefix)
+	if err != nil {
+		return
+	}
+	segments := splitPath(pathValue)
+	if len(segments) == 0 {
+		err = ErrInvalidPath
+		return
+	}
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	location := "mount " + displayPath(segments)
+	for _, m := range router.mounts {
+		if segmentsOverlap(segments, m.segments) {
+			err = fmt.Errorf("%w: %s overlaps with mount %s", ErrMountConflict, location, displayPath(m.segments))
+			return
+		}
+	}
+	for _, info := range router.routeInfos() {
+		if len(info.segments) >= len(segments) && segmentsOverlap(segments, info.segments) {
+			err = fmt.Errorf("%w: %s overlaps with route %s %s", ErrMountConflict, location, info.Method, info.Path)
+			return
+		}
+	}
+	mp = &MountPoint{
+		prefix:      pathValue,
+		segments:    segments,
+		handler:     handler,
+		stripPrefix: true,
+	}
+	if child, ok := handler.(*Router); ok {
+		mp.child = child
+	}
+	logger.InfoF("Mounting handler at: %s", pathValue)
+	router.mounts = append(router.mounts, mp)
+	return
+}
+
+// MountFunc delegates every request under the prefix to the handler function.
+func (router *Router) MountFunc(prefix string, f func(w http.ResponseWriter, r *http.Request)) (*MountPoint, error) {
+	if f == nil {
+		return nil, ErrInvalidHandler
+	}
+	return router.Mount(prefix, http.HandlerFunc(f))
+}
+
+// checkMountConflict checks if a route with the given segments overlaps any of the mounts.
+// The caller is expected to hold the router lock.
+func (router *Router) checkMountConflict(segments []string, method, path string) error {
+	for _, m := range router.mounts {
+		if len(segments) >= len(m.segments) && segmentsOverlap(m.segments, segments) {
+			return fmt.Errorf("%w: route %s %s overlaps with mount %s", ErrMountConflict, method, path, displayPath(m.segments))
+		}
+	}
+	return nil
+}
+
+// findMount returns the mount point matching the path along with the path variables of the prefix
+// and the remaining path after the prefix.
+func (router *Router) findMount(path string) (mp *MountPoint, params []Param, rest string) {
+	router.lock.RLock()
+	defer router.lock.RUnlock()
+	for _, m := range router.mounts {
+		if params, rest, ok := m.match(path); ok {
+			return m, params, rest
+		}
+	}
+	return nil, nil, textutils.EmptyStr
+}
+
+// match checks if the path is under the mount prefix.
+func (mp *MountPoint) match(path string) (params []Param, rest string, ok bool) {
+	rest = path
+	for _, segment := range mp.segments {
+		if !strings.HasPrefix(rest, PathSeparator) {
+			return nil, textutils.EmptyStr, false
+		}
+		rest = rest[1:]
+		val := rest
+		if idx := strings.Index(rest, PathSeparator); idx >= 0 {
+			val = rest[:idx]
+		}
+		if segment[0] == textutils.ColonChar {
+			if val == textutils.EmptyStr {
+				return nil, textutils.EmptyStr, false
+			}
+			params = append(params, Param{
+				key:   segment[1:],
+				value: val,
+			})
+		} else if val != segment {
+			return nil, textutils.EmptyStr, false
+		}
+		rest = rest[len(val):]
+	}
+	ok = true
+	return
+}
+
+// handlerFor returns the handler to be invoked for the request matched to the mount point.
+func (mp *MountPoint) handlerFor(r *http.Request, rest string) (http.Handler, *http.Request) {
+	if !mp.stripPrefix {
+		return mp.handler, r
+	}
+	if rest == textutils.EmptyStr {
+		rest = PathSeparator
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = rest
+	r2.URL.RawPath = textutils.EmptyStr
+	return mp.handler, r2
+}
+
+// splitPath splits the sanitized path into its segments ignoring the leading and trailing separators.
+func splitPath(path string) (segments []string) {
+	for _, s := range strings.Split(path, PathSeparator) {
+		if s != textutils.EmptyStr {
+			segments = append(segments, s)
+		}
+	}
+	return
+}
+
+// segmentsOverlap checks if the segments of the prefix match the leading segments of other.
+// A path variable matches any value.
+func segmentsOverlap(prefix, other []string) bool {
+	for i := 0; i < len(prefix) && i < len(other); i++ {
+		if prefix[i][0] == textutils.ColonChar || other[i][0] == textutils.ColonChar {
+			continue
+		}
+		if prefix[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// displayPath renders the segments as a path with path variables in the {<name>} syntax.
+func displayPath(segments []string) string {
+	var sb strings.Builder
+	for _, s := range segments {
+		sb.WriteString(PathSeparator)
+		if s[0] == textutils.ColonChar {
+			sb.WriteRune(textutils.OpenBraceChar)
+			sb.WriteString(s[1:])
+			sb.WriteRune(textutils.CloseBraceChar)
+		} else {
+			sb.WriteString(s)
+		}
+	}
+	if sb.Len() == 0 {
+		return PathSeparator
+	}
+	return sb.String()
+}
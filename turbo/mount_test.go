@@ -0,0 +1,244 @@
+package turbo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/pprof"
+	"strings"
+	"testing"
+)
+
+func TestRouter_MountPprof(t *testing.T) {
+	router := NewRouter()
+	mp, err := router.MountFunc("/debug/pprof", pprof.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp.PreservePrefix()
+	tests := []struct {
+		name     string
+		path     string
+		want     int
+		contains string
+	}{
+		{
+			name:     "Index",
+			path:     "/debug/pprof/",
+			want:     http.StatusOK,
+			contains: "goroutine",
+		},
+		{
+			name:     "Profile",
+			path:     "/debug/pprof/goroutine?debug=1",
+			want:     http.StatusOK,
+			contains: "goroutine profile",
+		},
+		{
+			name: "UnknownProfile",
+			path: "/debug/pprof/unknown",
+			want: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(GET, tt.path, nil)
+			router.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("ServeHTTP() got = %v, want = %v", w.Code, tt.want)
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("ServeHTTP() body does not contain %q", tt.contains)
+			}
+		})
+	}
+}
+
+func TestRouter_MountStripPrefix(t *testing.T) {
+	router := NewRouter()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+	if _, err := router.Mount("/strip", handler); err != nil {
+		t.Fatal(err)
+	}
+	mp, err := router.Mount("/preserve", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp.PreservePrefix()
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/strip/a/b", want: "/a/b"},
+		{path: "/strip", want: "/"},
+		{path: "/preserve/a/b", want: "/preserve/a/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(GET, tt.path, nil))
+			if w.Body.String() != tt.want {
+				t.Errorf("ServeHTTP() got = %v, want = %v", w.Body.String(), tt.want)
+			}
+		})
+	}
+	// a prefix only matches complete segments
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(GET, "/stripped", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP() got = %v, want = %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRouter_MountMissHandledByMount(t *testing.T) {
+	router := NewRouter()
+	router.SetUnmanaged(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	router.MountFunc("/legacy", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(GET, "/legacy/missing", nil))
+	if w.Code != http.StatusGone {
+		t.Errorf("ServeHTTP() got = %v, want = %v", w.Code, http.StatusGone)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(GET, "/other/missing", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("ServeHTTP() got = %v, want = %v", w.Code, http.StatusTeapot)
+	}
+}
+
+func TestRouter_MountRouter(t *testing.T) {
+	parent := NewRouter()
+	parent.AddGlobalFilter(filterFunction("parent/"))
+	parent.Get("/health", testHandler)
+
+	child := NewRouter()
+	child.AddGlobalFilter(filterFunction("child1/"), filterFunction("child2/"))
+	route, err := child.Get("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ := GetPathParam("tenant", r)
+		id, _ := GetPathParam("id", r)
+		_, _ = w.Write([]byte(tenant + ":" + id))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.AddFilter(filterFunction("route/"))
+	child.Post("/users", testHandler)
+
+	if _, err = parent.Mount("/tenants/{tenant}", child); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	parent.ServeHTTP(w, httptest.NewRequest(GET, "/tenants/acme/users/42", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() got = %v, want = %v", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != "parent/route/child1/child2/acme:42" {
+		t.Errorf("ServeHTTP() got = %v", got)
+	}
+
+	// miss inside the child router is handled by the child with the stripped path
+	w = httptest.NewRecorder()
+	parent.ServeHTTP(w, httptest.NewRequest(GET, "/tenants/acme/unknown/path", nil))
+	if got := w.Body.String(); !strings.Contains(got, `Endpoint not found :"/unknown/path"`) {
+		t.Errorf("ServeHTTP() got = %v", got)
+	}
+
+	want := []RouteInfo{
+		{Method: GET, Path: "/health"},
+		{Method: POST, Path: "/tenants/{tenant}/users"},
+		{Method: GET, Path: "/tenants/{tenant}/users/{id}"},
+	}
+	got := parent.Routes()
+	if len(got) != len(want) {
+		t.Fatalf("Routes() got = %v, want = %v", got, want)
+	}
+	for i := range want {
+		if got[i].Method != want[i].Method || got[i].Path != want[i].Path {
+			t.Errorf("Routes()[%d] got = %v, want = %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRouter_MountConflict(t *testing.T) {
+	router := NewRouter()
+	router.Get("/api/v1/users", testHandler)
+	if _, err := router.Mount("/debug", testHandler); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		mount func() error
+		want  []string
+	}{
+		{
+			name: "MountOverRoute",
+			mount: func() error {
+				_, err := router.Mount("/api", testHandler)
+				return err
+			},
+			want: []string{"mount /api", "route GET /api/v1/users"},
+		},
+		{
+			name: "MountOverRouteWithVar",
+			mount: func() error {
+				_, err := router.Mount("/api/{version}", testHandler)
+				return err
+			},
+			want: []string{"mount /api/{version}", "route GET /api/v1/users"},
+		},
+		{
+			name: "MountOverMount",
+			mount: func() error {
+				_, err := router.Mount("/debug/pprof", testHandler)
+				return err
+			},
+			want: []string{"mount /debug/pprof", "mount /debug"},
+		},
+		{
+			name: "RouteUnderMount",
+			mount: func() error {
+				_, err := router.Get("/debug/vars", testHandler)
+				return err
+			},
+			want: []string{"route GET /debug/vars", "mount /debug"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.mount()
+			if !errors.Is(err, ErrMountConflict) {
+				t.Fatalf("got err = %v, want = %v", err, ErrMountConflict)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("error %q does not contain %q", err.Error(), s)
+				}
+			}
+		})
+	}
+	// sibling paths do not conflict
+	if _, err := router.Mount("/api/v2", testHandler); err != nil {
+		t.Errorf("Mount() got err = %v", err)
+	}
+	if _, err := router.Get("/debugger", testHandler); err != nil {
+		t.Errorf("Get() got err = %v", err)
+	}
+}
+
+func TestRouter_MountInvalid(t *testing.T) {
+	router := NewRouter()
+	if _, err := router.Mount("/", testHandler); err != ErrInvalidPath {
+		t.Errorf("Mount() got err = %v, want = %v", err, ErrInvalidPath)
+	}
+	if _, err := router.Mount("/debug", nil); err != ErrInvalidHandler {
+		t.Errorf("Mount() got err = %v, want = %v", err, ErrInvalidHandler)
+	}
+}
@@ -0,0 +1,316 @@
+package turbo
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+)
+
+const (
+	// ServerTimingHeader is the response header of the timing metrics
+	ServerTimingHeader = "Server-Timing"
+	// TotalTiming is the name of the metric of the whole request added to the Server-Timing header
+	TotalTiming = "total"
+)
+
+// serverTimingKey is the context key of the timing metrics of a request
+type serverTimingKey struct{}
+
+// TimingMetric is a named duration recorded by the handlers of a request using Timing
+type TimingMetric struct {
+	// Name of the metric
+	Name string `json:"name"`
+	// Desc is the description of the metric, empty if none
+	Desc string `json:"desc,omitempty"`
+	// Duration of the metric
+	Duration time.Duration `json:"duration"`
+}
+
+// serverTimings holds the metrics of a request
+type serverTimings struct {
+	mutex   sync.Mutex
+	metrics []TimingMetric
+	// flushed is true once the header is written, the metrics stopped later are not sent
+	flushed bool
+}
+
+// ServerTimingOptions configures the ServerTiming filter.
+type ServerTimingOptions struct {
+	// Allow are the names of the metrics sent in the header, all the metrics are sent if empty. The total is always
+	// sent.
+	Allow []string
+	// SampleRate is the fraction of the requests the header is sent for, 0 sends it for none and 1 for all
+	SampleRate float64
+	// DebugHeader is a request header sending the Server-Timing header when present, regardless of the SampleRate
+	DebugHeader string
+	// DebugParam is a query parameter sending the Server-Timing header when present, regardless of the SampleRate
+	DebugParam string
+	// Stats aggregates the metrics of all the requests by route when set, whether the header is sent or not
+	Stats *TimingStats
+	// RouteKey returns the route the metrics of the request are aggregated under. Defaults to the method and the path
+	// of the request; set it when the paths hold variables.
+	RouteKey func(r *http.Request) string
+	// Random returns a number in [0,1) to sample the requests. Defaults to math/rand.
+	Random func() float64
+}
+
+// serverTiming is the state of the ServerTiming filter
+type serverTiming struct {
+	opts  ServerTimingOptions
+	allow map[string]bool
+}
+
+// ServerTiming sends the durations recorded by the handlers with Timing in the Server-Timing response header, along
+// with the total duration of the request up to the header. The header is sent for a sample of the requests and for
+// the requests carrying the debug header or query parameter, with only the allowed metrics.
+func ServerTiming(opts ServerTimingOptions) FilterFunc {
+	if opts.Random == nil {
+		opts.Random = rand.Float64
+	}
+	if opts.RouteKey == nil {
+		opts.RouteKey = func(r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}
+	}
+	st := &serverTiming{opts: opts}
+	if len(opts.Allow) > 0 {
+		st.allow = make(map[string]bool, len(opts.Allow))
+		for _, name := range opts.Allow {
+			st.allow[name] = true
+		}
+	}
+	return st.filter
+}
+
+// Timing starts timing the named metric of the request and returns the function stopping it. The metric is sent in
+// the Server-Timing header if it is stopped before the header is written. Timing does nothing if the request is not
+// served through the ServerTiming filter.
+func Timing(r *http.Request, name string) (stop func()) {
+	return TimingDesc(r, name, "")
+}
+
+// TimingDesc starts timing the named metric of the request with a description and returns the function stopping it
+func TimingDesc(r *http.Request, name, desc string) (stop func()) {
+	timings, ok := r.Context().Value(serverTimingKey{}).(*serverTimings)
+	if !ok {
+		return func() {}
+	}
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			timings.add(TimingMetric{Name: name, Desc: desc, Duration: time.Since(start)})
+		})
+	}
+}
+
+// add records the metric, noting it if the header is already written
+func (st *serverTimings) add(metric TimingMetric) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.flushed {
+		logger.DebugF("the timing %s is stopped after the Server-Timing header is written", metric.Name)
+	}
+	st.metrics = append(st.metrics, metric)
+}
+
+// filter wraps the handler with the timing of the request
+func (s *serverTiming) filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timings := &serverTimings{}
+		tw := &timingWriter{ResponseWriter: w, timings: timings, start: time.Now()}
+		if s.emits(r) {
+			tw.render = s.render
+		}
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), serverTimingKey{}, timings)))
+		tw.writeTimings()
+		if s.opts.Stats != nil {
+			timings.mutex.Lock()
+			metrics := append([]TimingMetric{{Name: TotalTiming, Duration: time.Since(tw.start)}}, timings.metrics...)
+			timings.mutex.Unlock()
+			s.opts.Stats.add(s.opts.RouteKey(r), metrics)
+		}
+	})
+}
+
+// emits checks if the header is sent for the request
+func (s *serverTiming) emits(r *http.Request) bool {
+	if s.opts.DebugHeader != "" && r.Header.Get(s.opts.DebugHeader) != "" {
+		return true
+	}
+	if s.opts.DebugParam != "" && r.URL.Query().Has(s.opts.DebugParam) {
+		return true
+	}
+	return s.opts.Random() < s.opts.SampleRate
+}
+
+// render returns the value of the Server-Timing header with the allowed metrics and the total
+func (s *serverTiming) render(metrics []TimingMetric, total time.Duration) string {
+	var sb strings.Builder
+	for _, metric := range metrics {
+		if s.allow != nil && !s.allow[metric.Name] {
+			continue
+		}
+		writeTimingMetric(&sb, metric)
+		sb.WriteString(", ")
+	}
+	writeTimingMetric(&sb, TimingMetric{Name: TotalTiming, Duration: total})
+	return sb.String()
+}
+
+// writeTimingMetric writes the metric as name;desc="...";dur=milliseconds. The characters of the name which are not
+// allowed in a token are replaced with an underscore.
+func writeTimingMetric(sb *strings.Builder, metric TimingMetric) {
+	for _, c := range metric.Name {
+		if isTokenChar(c) {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	if metric.Desc != "" {
+		sb.WriteString(`;desc="`)
+		for _, c := range metric.Desc {
+			if c == '"' || c == '\\' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(c)
+		}
+		sb.WriteByte('"')
+	}
+	sb.WriteString(";dur=")
+	sb.WriteString(strconv.FormatFloat(float64(metric.Duration.Microseconds())/1000, 'f', -1, 64))
+}
+
+// isTokenChar checks if the character is allowed in a token of RFC 9110
+func isTokenChar(c rune) bool {
+	return c < 0x7f && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
+
+// timingWriter writes the Server-Timing header before the response header
+type timingWriter struct {
+	http.ResponseWriter
+	timings *serverTimings
+	start   time.Time
+	// render returns the value of the header, nil if the header is not sent for the request
+	render  func(metrics []TimingMetric, total time.Duration) string
+	written bool
+}
+
+// writeTimings sets the header with the metrics stopped so far, once
+func (tw *timingWriter) writeTimings() {
+	if tw.written {
+		return
+	}
+	tw.written = true
+	tw.timings.mutex.Lock()
+	defer tw.timings.mutex.Unlock()
+	tw.timings.flushed = true
+	if tw.render != nil {
+		tw.Header().Add(ServerTimingHeader, tw.render(tw.timings.metrics, time.Since(tw.start)))
+	}
+}
+
+func (tw *timingWriter) WriteHeader(status int) {
+	tw.writeTimings()
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	tw.writeTimings()
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush flushes the response if the underlying writer supports it
+func (tw *timingWriter) Flush() {
+	tw.writeTimings()
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// TimingStat is the aggregate of a timing metric of a route
+type TimingStat struct {
+	// Count is the number of times the metric was recorded
+	Count int64 `json:"count"`
+	// Total is the sum of the durations
+	Total time.Duration `json:"total"`
+	// Max is the longest duration
+	Max time.Duration `json:"max"`
+}
+
+// Mean returns the mean duration of the metric
+func (ts TimingStat) Mean() time.Duration {
+	if ts.Count == 0 {
+		return 0
+	}
+	return ts.Total / time.Duration(ts.Count)
+}
+
+// TimingStats aggregates the timing metrics of the requests served through the ServerTiming filter by route, so
+// the time spent in the handlers, the databases and the external calls can be compared across routes.
+type TimingStats struct {
+	mutex  sync.Mutex
+	routes map[string]map[string]TimingStat
+}
+
+// NewTimingStats creates empty TimingStats
+func NewTimingStats() *TimingStats {
+	return &TimingStats{routes: map[string]map[string]TimingStat{}}
+}
+
+// add aggregates the metrics of a request of the route
+func (ts *TimingStats) add(route string, metrics []TimingMetric) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	stats, ok := ts.routes[route]
+	if !ok {
+		stats = map[string]TimingStat{}
+		ts.routes[route] = stats
+	}
+	for _, metric := range metrics {
+		stat := stats[metric.Name]
+		stat.Count++
+		stat.Total += metric.Duration
+		stat.Max = max(stat.Max, metric.Duration)
+		stats[metric.Name] = stat
+	}
+}
+
+// Snapshot returns a copy of the stats of each metric by route
+func (ts *TimingStats) Snapshot() map[string]map[string]TimingStat {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	snapshot := make(map[string]map[string]TimingStat, len(ts.routes))
+	for route, stats := range ts.routes {
+		copied := make(map[string]TimingStat, len(stats))
+		for name, stat := range stats {
+			copied[name] = stat
+		}
+		snapshot[route] = copied
+	}
+	return snapshot
+}
+
+// Handler returns a handler serving the Snapshot as JSON, to be added to the stats routes of the server
+func (ts *TimingStats) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ioutils.MimeApplicationJSON)
+		if err := json.NewEncoder(w).Encode(ts.Snapshot()); err != nil {
+			logger.ErrorF("unable to encode the timing stats: %v", err)
+		}
+	})
+}
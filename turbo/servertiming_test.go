@@ -0,0 +1,152 @@
+package turbo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serverTimingPattern matches a Server-Timing header of metrics with a duration and an optional description
+var serverTimingPattern = regexp.MustCompile(
+	`^[!#$%&'*+\-.^_` + "`" + `|~0-9A-Za-z]+(;desc="(\\.|[^"\\])*")?;dur=\d+(\.\d+)?` +
+		`(, [!#$%&'*+\-.^_` + "`" + `|~0-9A-Za-z]+(;desc="(\\.|[^"\\])*")?;dur=\d+(\.\d+)?)*$`)
+
+// serverTimingRouter returns a router with the ServerTiming filter and handlers recording timings
+func serverTimingRouter(t *testing.T, opts ServerTimingOptions) *Router {
+	router := NewRouter()
+	router.AddGlobalFilter(ServerTiming(opts))
+	handlers := map[string]func(w http.ResponseWriter, r *http.Request){
+		"/orders": func(w http.ResponseWriter, r *http.Request) {
+			stop := Timing(r, "db")
+			time.Sleep(5 * time.Millisecond)
+			stop()
+			stop()
+			TimingDesc(r, "cache lookup", `hit "warm"`)()
+			_, _ = w.Write([]byte("ok"))
+		},
+		"/late": func(w http.ResponseWriter, r *http.Request) {
+			stop := Timing(r, "render")
+			w.WriteHeader(http.StatusAccepted)
+			// stopped after the header is written
+			stop()
+			Timing(r, "external")()
+		},
+		"/empty": func(w http.ResponseWriter, r *http.Request) {
+			Timing(r, "db")()
+		},
+	}
+	for p, h := range handlers {
+		if _, err := router.Get(p, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return router
+}
+
+func serveTiming(router *Router, target string, header ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestServerTiming_Header(t *testing.T) {
+	router := serverTimingRouter(t, ServerTimingOptions{SampleRate: 1})
+	w := serveTiming(router, "/orders")
+	value := w.Header().Get(ServerTimingHeader)
+	if !serverTimingPattern.MatchString(value) {
+		t.Fatalf("Server-Timing = %q is not well formed", value)
+	}
+	metrics := strings.Split(value, ", ")
+	if len(metrics) != 3 || !strings.HasPrefix(metrics[0], "db;dur=") ||
+		!strings.HasPrefix(metrics[1], `cache_lookup;desc="hit \"warm\"";dur=`) ||
+		!strings.HasPrefix(metrics[2], "total;dur=") {
+		t.Errorf("Server-Timing = %q", value)
+	}
+	if db, err := strconv.ParseFloat(strings.TrimPrefix(metrics[0], "db;dur="), 64); err != nil || db < 5 {
+		t.Errorf("db duration = %v ms, %v", db, err)
+	}
+	// the handlers that do not write still get the header
+	if value = serveTiming(router, "/empty").Header().Get(ServerTimingHeader); !strings.HasPrefix(value, "db;dur=") {
+		t.Errorf("Server-Timing = %q", value)
+	}
+}
+
+func TestServerTiming_AllowList(t *testing.T) {
+	router := serverTimingRouter(t, ServerTimingOptions{SampleRate: 1, Allow: []string{"db"}})
+	value := serveTiming(router, "/orders").Header().Get(ServerTimingHeader)
+	if strings.Contains(value, "cache") || !strings.HasPrefix(value, "db;dur=") ||
+		!strings.Contains(value, ", total;dur=") {
+		t.Errorf("Server-Timing = %q", value)
+	}
+}
+
+func TestServerTiming_DebugTrigger(t *testing.T) {
+	router := serverTimingRouter(t, ServerTimingOptions{DebugHeader: "X-Debug-Timing", DebugParam: "timing"})
+	if value := serveTiming(router, "/orders").Header().Values(ServerTimingHeader); len(value) != 0 {
+		t.Errorf("Server-Timing = %q without a trigger", value)
+	}
+	if value := serveTiming(router, "/orders", "X-Debug-Timing", "1").Header().Get(ServerTimingHeader); value == "" {
+		t.Errorf("Server-Timing is not sent with the debug header")
+	}
+	if value := serveTiming(router, "/orders?timing").Header().Get(ServerTimingHeader); value == "" {
+		t.Errorf("Server-Timing is not sent with the debug parameter")
+	}
+
+	sampled := serverTimingRouter(t, ServerTimingOptions{SampleRate: 0.5, Random: func() float64 { return 0.7 }})
+	if value := serveTiming(sampled, "/orders").Header().Values(ServerTimingHeader); len(value) != 0 {
+		t.Errorf("Server-Timing = %q for a request out of the sample", value)
+	}
+}
+
+func TestServerTiming_AfterFlush(t *testing.T) {
+	stats := NewTimingStats()
+	router := serverTimingRouter(t, ServerTimingOptions{SampleRate: 1, Stats: stats})
+	w := serveTiming(router, "/late")
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status = %d", w.Code)
+	}
+	// only the total is sent, the metrics stopped later are dropped from the header
+	value := w.Header().Get(ServerTimingHeader)
+	if !strings.HasPrefix(value, "total;dur=") || strings.Contains(value, ",") {
+		t.Errorf("Server-Timing = %q", value)
+	}
+	// but they are aggregated
+	if got := stats.Snapshot()["GET /late"]; got["render"].Count != 1 || got["external"].Count != 1 {
+		t.Errorf("stats = %v", got)
+	}
+	// outside of the filter the timings do nothing
+	Timing(httptest.NewRequest(http.MethodGet, "/", nil), "db")()
+}
+
+func TestServerTiming_Stats(t *testing.T) {
+	stats := NewTimingStats()
+	router := serverTimingRouter(t, ServerTimingOptions{Stats: stats, RouteKey: func(r *http.Request) string {
+		return "orders"
+	}})
+	for i := 0; i < 3; i++ {
+		serveTiming(router, "/orders")
+	}
+	got := stats.Snapshot()["orders"]
+	if len(got) != 3 || got["db"].Count != 3 || got["cache lookup"].Count != 3 || got[TotalTiming].Count != 3 {
+		t.Fatalf("stats = %v", got)
+	}
+	if db := got["db"]; db.Mean() < 5*time.Millisecond || db.Max < db.Mean() || got[TotalTiming].Total < db.Total {
+		t.Errorf("db = %+v, total = %+v", db, got[TotalTiming])
+	}
+
+	w := httptest.NewRecorder()
+	stats.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	served := map[string]map[string]TimingStat{}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil || served["orders"]["db"].Count != 3 {
+		t.Errorf("stats handler = %s, %v", w.Body.String(), err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,6 +27,20 @@ type Router struct {
 	topLevelRoutes map[string]*Route
 	//global filters
 	globalFilters []FilterFunc
+	//handlers mounted under a prefix
+	mounts []*MountPoint
+	//default CORS policy of the routes
+	cors *corsPolicy
+}
+
+// RouteInfo describes a route registered with the router
+type RouteInfo struct {
+	//Method of the route. Handlers mounted using Mount are listed with the * method
+	Method string
+	//Path of the route. Path variables are listed using the {<name>} syntax
+	Path string
+	//segments of the path
+	segments []string
 }
 
 // Param to hold key value
@@ -56,6 +71,10 @@ type Route struct {
 	queryParams map[string]*QueryParam
 	//logger to set the external logger if required using SetLogger()
 	logger l3.Logger
+	//cors policy of the route overriding the one of its group and router
+	cors *corsPolicy
+	//group the route was registered with
+	group *Group
 }
 
 // QueryParam for the Route configuration
@@ -163,6 +182,10 @@ func (router *Router) AddHandler(path string, h http.Handler, methods ...string)
 	if err != nil {
 		return
 	}
+	err = router.checkMountConflict(splitPath(pathValue), strings.Join(methods, textutils.CommaStr), pathValue)
+	if err != nil {
+		return
+	}
 	pathValues = strings.Split(pathValue, PathSeparator)
 	// check for the leading empty path value and remove it
 	if len(pathValues) > 1 && pathValues[0] == textutils.EmptyStr {
@@ -295,39 +318,124 @@ func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "Path Moved : %q \n", html.EscapeString(p))
 		return
 	}
+	// handlers mounted under a prefix take care of the request including any miss
+	if mp, params, rest := router.findMount(path); mp != nil {
+		r = withParams(r, params)
+		handler, r = mp.handlerFor(r, rest)
+		router.applyGlobalFilters(handler).ServeHTTP(w, r)
+		return
+	}
 	// start by checking where the method of the Request is same as that of the registered method
 	match, params := router.findRoute(r)
-	if match != nil {
+	if match != nil && r.Method == OPTIONS && router.answersOptions(match, r) {
+		// preflights carry no credentials, they are answered before the route filters and the authenticator
+		handler = router.applyGlobalFilters(router.optionsHandler(match))
+	} else if match != nil {
 		handler = match.handlers[r.Method]
 		//Global Middlewares added
-		if router.globalFilters != nil {
-			for i := range router.globalFilters {
-				handler = router.globalFilters[len(router.globalFilters)-1-i](handler)
-			}
-		}
+		handler = router.applyGlobalFilters(handler)
 		//Route specific Middlewares added
 		if len(match.filters) > 0 {
 			for i := range match.filters {
 				handler = match.filters[len(match.filters)-1-i](handler)
 			}
 		}
-		// check for authenticated filter explicitly at the top
-		// we add all the filters added by the user in its order and if the user has added an Authenticator Filter then it will always be executed first
-		if match.authFilter != nil {
-			handler = match.authFilter.Apply(handler)
-		}
+		handler = router.applyCors(match, handler)
 	} else {
 		handler = router.unManagedRouteHandler
 	}
 	if handler == nil {
 		handler = router.unsupportedMethodHandler
 	}
-	if params != nil {
-		r = r.WithContext(context.WithValue(r.Context(), "params", params))
-	}
+	r = withParams(r, params)
 	handler.ServeHTTP(w, r)
 }
 
+// applyGlobalFilters wraps the handler with the global filters of the router
+func (router *Router) applyGlobalFilters(handler http.Handler) http.Handler {
+	if router.globalFilters != nil {
+		for i := range router.globalFilters {
+			handler = router.globalFilters[len(router.globalFilters)-1-i](handler)
+		}
+	}
+	return handler
+}
+
+// withParams adds the path params to the request context.
+// Params already present in the context (set by a parent router) are retained.
+func withParams(r *http.Request, params []Param) *http.Request {
+	if params == nil {
+		return r
+	}
+	if parentParams, ok := r.Context().Value("params").([]Param); ok {
+		params = append(append([]Param{}, parentParams...), params...)
+	}
+	return r.WithContext(context.WithValue(r.Context(), "params", params))
+}
+
+// Routes returns all the routes registered with the router sorted by path and method.
+// Routes of a mounted router are included with the mount prefix applied.
+func (router *Router) Routes() (routes []RouteInfo) {
+	router.lock.RLock()
+	defer router.lock.RUnlock()
+	routes = router.routeInfos()
+	for _, mp := range router.mounts {
+		if mp.child != nil {
+			for _, childRoute := range mp.child.Routes() {
+				segments := append(append([]string{}, mp.segments...), childRoute.segments...)
+				routes = append(routes, RouteInfo{
+					Method:   childRoute.Method,
+					Path:     displayPath(segments),
+					segments: segments,
+				})
+			}
+		} else {
+			routes = append(routes, RouteInfo{
+				Method:   textutils.AsteriskStr,
+				Path:     displayPath(mp.segments),
+				segments: mp.segments,
+			})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path == routes[j].Path {
+			return routes[i].Method < routes[j].Method
+		}
+		return routes[i].Path < routes[j].Path
+	})
+	return
+}
+
+// routeInfos lists the routes registered directly with the router.
+// The caller is expected to hold the router lock.
+func (router *Router) routeInfos() (routes []RouteInfo) {
+	var walk func(route *Route, parent []string)
+	walk = func(route *Route, parent []string) {
+		segments := parent
+		if route.path != textutils.EmptyStr {
+			segment := route.path
+			if route.isPathVar {
+				segment = textutils.ColonStr + segment
+			}
+			segments = append(append([]string{}, parent...), segment)
+		}
+		for method := range route.handlers {
+			routes = append(routes, RouteInfo{
+				Method:   method,
+				Path:     displayPath(segments),
+				segments: segments,
+			})
+		}
+		for _, subRoute := range route.subRoutes {
+			walk(subRoute, segments)
+		}
+	}
+	for _, route := range router.topLevelRoutes {
+		walk(route, nil)
+	}
+	return
+}
+
 func (r *Router) SetUnmanaged(handler http.Handler) *Router {
 	r.unManagedRouteHandler = handler
 	return r
@@ -0,0 +1,353 @@
+package turbo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"oss.nandlabs.io/golly/codec"
+	"oss.nandlabs.io/golly/codec/validator"
+	"oss.nandlabs.io/golly/ioutils"
+)
+
+const (
+	// PathTag is the struct tag of the request fields set from the path params
+	PathTag = "path"
+	// QueryTag is the struct tag of the request fields set from the query params
+	QueryTag = "query"
+	// MimeProblemJSON is the content type of the problem details
+	MimeProblemJSON = "application/problem+json"
+	// constraintsTag is the struct tag of the codec validator
+	constraintsTag = "constraints"
+)
+
+// ErrorRenderer writes the error returned by a typed handler to the response
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, err error)
+
+// DefaultErrorRenderer renders the errors of the typed handlers created with JSON and JSONNoBody.
+// It can be replaced at startup to customize the error responses.
+var DefaultErrorRenderer ErrorRenderer = RenderProblem
+
+// Error is an error with the HTTP status it is rendered with by the typed handlers
+type Error struct {
+	// Status is the HTTP status of the error
+	Status int
+	// Err is the underlying error
+	Err error
+}
+
+// NewError creates a new Error with the status and the underlying error
+func NewError(status int, err error) *Error {
+	return &Error{Status: status, Err: err}
+}
+
+// Errorf creates a new Error with the status and a formatted message
+func Errorf(status int, format string, args ...any) *Error {
+	return &Error{Status: status, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ProblemDetails is the error response of RFC 9457 (formerly RFC 7807)
+type ProblemDetails struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// StatusOf returns the HTTP status of the error.
+// It is the status of the Error in the chain, 504 for a deadline exceeded and 500 otherwise.
+func StatusOf(err error) int {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Status
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// RenderProblem writes the error as ProblemDetails with the status of StatusOf.
+// The detail of the server errors is logged and not sent to the client.
+func RenderProblem(w http.ResponseWriter, r *http.Request, err error) {
+	status := StatusOf(err)
+	problem := &ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.Path,
+	}
+	if status >= http.StatusInternalServerError {
+		logger.ErrorF("request %s %s failed: %v", r.Method, r.URL.Path, err)
+	} else {
+		problem.Detail = err.Error()
+	}
+	w.Header().Set("Content-Type", MimeProblemJSON)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+// JSON adapts a typed function to an http.HandlerFunc.
+// The request body is decoded into Req with the codec of its Content-Type (JSON if absent) and the fields of Req
+// tagged with path or query are set from the path and the query params of the request. Req is then validated if it
+// has fields tagged with constraints. The response is encoded with the codec negotiated from the Accept header with
+// the status 200, or 204 if the response is nil or an empty struct.
+// The errors are written with the DefaultErrorRenderer; the panics of fn are not recovered.
+func JSON[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.HandlerFunc {
+	return typedHandler(fn, true)
+}
+
+// JSONNoBody is JSON for the requests without a body such as GET and DELETE.
+// Req is only populated from the path and the query params.
+func JSONNoBody[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.HandlerFunc {
+	return typedHandler(fn, false)
+}
+
+// typedHandler creates the handler of the typed function
+func typedHandler[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error), readBody bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resp Resp
+		var respCodec codec.Codec
+		var contentType string
+		req, err := decodeRequest[Req](r, readBody)
+		if err == nil {
+			contentType, respCodec, err = negotiate(r.Header.Get("Accept"))
+		}
+		if err == nil {
+			resp, err = fn(r.Context(), req)
+		}
+		if err != nil {
+			DefaultErrorRenderer(w, r, err)
+			return
+		}
+		if isEmpty(reflect.ValueOf(resp)) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		if err = respCodec.Write(resp, w); err != nil {
+			logger.ErrorF("unable to encode the response of %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+}
+
+// decodeRequest creates the request from the body and the params
+func decodeRequest[Req any](r *http.Request, readBody bool) (req Req, err error) {
+	v := reflect.ValueOf(&req).Elem()
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	if readBody && r.Body != nil && r.Body != http.NoBody {
+		var c codec.Codec
+		if c, err = requestCodec(r.Header.Get("Content-Type")); err == nil {
+			if err = c.Read(r.Body, v.Addr().Interface()); err == io.EOF {
+				err = nil
+			} else if err != nil {
+				err = Errorf(http.StatusBadRequest, "invalid request body: %v", err)
+			}
+		}
+	}
+	if err == nil && v.Kind() == reflect.Struct {
+		err = injectParams(r, v)
+		if err == nil && hasConstraints(v.Type()) {
+			if verr := validator.NewStructValidator().Validate(v.Interface()); verr != nil {
+				err = NewError(http.StatusUnprocessableEntity, verr)
+			}
+		}
+	}
+	return
+}
+
+// requestCodec returns the codec of the content type of the request
+func requestCodec(contentType string) (c codec.Codec, err error) {
+	if contentType == "" {
+		contentType = ioutils.MimeApplicationJSON
+	}
+	if c, err = codec.GetDefault(contentType); err != nil {
+		err = NewError(http.StatusUnsupportedMediaType, err)
+	}
+	return
+}
+
+// negotiate returns the content type and the codec of the response from the Accept header.
+// The media types are tried in the order of their quality, JSON is used for */* or if the header is absent.
+func negotiate(accept string) (contentType string, c codec.Codec, err error) {
+	type mediaRange struct {
+		typ string
+		q   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mr := mediaRange{typ: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, p := range params[1:] {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				mr.q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		if mr.typ != "" && mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	if len(ranges) == 0 {
+		ranges = append(ranges, mediaRange{typ: "*/*", q: 1})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, mr := range ranges {
+		switch mr.typ {
+		case "*/*", "application/*":
+			contentType = ioutils.MimeApplicationJSON
+		case "text/*":
+			contentType = ioutils.MimeTextYAML
+		default:
+			contentType = mr.typ
+		}
+		if c, err = codec.GetDefault(contentType); err == nil {
+			return
+		}
+	}
+	err = Errorf(http.StatusNotAcceptable, "none of the media types %q is supported", accept)
+	return
+}
+
+// injectParams sets the fields tagged with path or query from the params of the request
+func injectParams(r *http.Request, v reflect.Value) (err error) {
+	t := v.Type()
+	var query map[string][]string
+	for i := 0; i < t.NumField() && err == nil; i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if name, ok := sf.Tag.Lookup(PathTag); ok {
+			var value string
+			if value, err = GetPathParam(name, r); err == nil {
+				err = setField(v.Field(i), []string{value})
+			}
+			if err != nil {
+				err = Errorf(http.StatusBadRequest, "invalid path param %s: %v", name, err)
+			}
+		} else if name, ok = sf.Tag.Lookup(QueryTag); ok {
+			if query == nil {
+				query = r.URL.Query()
+			}
+			if values := query[name]; len(values) > 0 {
+				if err = setField(v.Field(i), values); err != nil {
+					err = Errorf(http.StatusBadRequest, "invalid query param %s: %v", name, err)
+				}
+			}
+		}
+	}
+	return
+}
+
+// setField converts the values to the type of the field. Slices take all the values, the other types the first one.
+func setField(field reflect.Value, values []string) (err error) {
+	if field.Kind() == reflect.Ptr {
+		elem := reflect.New(field.Type().Elem())
+		if err = setField(elem.Elem(), values); err == nil {
+			field.Set(elem)
+		}
+		return
+	}
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err = setValue(slice.Index(i), value); err != nil {
+				return
+			}
+		}
+		field.Set(slice)
+		return
+	}
+	return setValue(field, values[0])
+}
+
+// setValue converts the value to the type of the field
+func setValue(field reflect.Value, value string) (err error) {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(value); err == nil {
+			field.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(value, 10, field.Type().Bits()); err == nil {
+			field.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(value, 10, field.Type().Bits()); err == nil {
+			field.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(value, field.Type().Bits()); err == nil {
+			field.SetFloat(f)
+		}
+	default:
+		err = fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		err = fmt.Errorf("%q is not a valid %s", value, field.Type())
+	}
+	return
+}
+
+// constrainedTypes caches whether the struct types have fields tagged with constraints
+var constrainedTypes sync.Map
+
+// hasConstraints checks if any field of the struct type is tagged with constraints
+func hasConstraints(t reflect.Type) bool {
+	if v, ok := constrainedTypes.Load(t); ok {
+		return v.(bool)
+	}
+	found := false
+	for i := 0; i < t.NumField() && !found; i++ {
+		tag, ok := t.Field(i).Tag.Lookup(constraintsTag)
+		found = ok && tag != "-"
+	}
+	constrainedTypes.Store(t, found)
+	return found
+}
+
+// isEmpty checks if the response is nil or an empty struct
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil() || isEmpty(v.Elem())
+	case reflect.Struct:
+		return v.NumField() == 0
+	}
+	return false
+}
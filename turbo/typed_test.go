@@ -0,0 +1,190 @@
+package turbo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type typedItem struct {
+	Id    int      `json:"id" path:"id"`
+	Name  string   `json:"name" constraints:"min-length=3"`
+	Page  *uint    `json:"page,omitempty" query:"page"`
+	Tags  []string `json:"tags,omitempty" query:"tag"`
+	Ratio float64  `json:"ratio,omitempty" query:"ratio"`
+}
+
+type typedKey struct {
+	Id    int     `path:"id"`
+	Page  *uint   `query:"page"`
+	Ratio float64 `query:"ratio"`
+}
+
+var errItemNotFound = errors.New("item not found")
+
+// typedRouter returns a router with the typed handlers of the items
+func typedRouter(t *testing.T) *Router {
+	router := NewRouter()
+	router.AddGlobalFilter(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if p := recover(); p != nil {
+					w.WriteHeader(http.StatusTeapot)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	})
+	routes := []struct {
+		path    string
+		method  string
+		handler http.HandlerFunc
+	}{
+		{"/items/{id}", http.MethodPut, JSON(func(ctx context.Context, item *typedItem) (*typedItem, error) {
+			return item, nil
+		})},
+		{"/items/{id}", http.MethodGet, JSONNoBody(func(ctx context.Context, key typedKey) (*typedItem, error) {
+			if key.Id == 404 {
+				return nil, NewError(http.StatusNotFound, errItemNotFound)
+			}
+			if key.Id == 500 {
+				return nil, errors.New("database password rejected")
+			}
+			return &typedItem{Id: key.Id, Name: "fetched"}, nil
+		})},
+		{"/items/{id}", http.MethodDelete, JSONNoBody(func(ctx context.Context, key typedKey) (struct{}, error) {
+			return struct{}{}, nil
+		})},
+		{"/panic", http.MethodGet, JSONNoBody(func(ctx context.Context, req struct{}) (*typedItem, error) {
+			panic("boom")
+		})},
+	}
+	for _, route := range routes {
+		if _, err := router.Add(route.path, route.handler, route.method); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return router
+}
+
+func serveTyped(router *Router, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestJSON_Params(t *testing.T) {
+	router := typedRouter(t)
+	w := serveTyped(router, http.MethodPut, "/items/7?page=2&tag=a&tag=b&ratio=0.5", `{"name":"golly","id":1}`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	var item typedItem
+	if err := json.Unmarshal(w.Body.Bytes(), &item); err != nil {
+		t.Fatal(err)
+	}
+	// the path params take precedence over the body
+	if item.Id != 7 || item.Name != "golly" || item.Page == nil || *item.Page != 2 || item.Ratio != 0.5 ||
+		strings.Join(item.Tags, ",") != "a,b" {
+		t.Errorf("got %+v", item)
+	}
+
+	tests := []struct {
+		target string
+		detail string
+	}{
+		{"/items/abc", `invalid path param id: \"abc\" is not a valid int`},
+		{"/items/1?page=-1", `invalid query param page: \"-1\" is not a valid uint`},
+		{"/items/1?ratio=x", `invalid query param ratio`},
+	}
+	for _, tt := range tests {
+		w = serveTyped(router, http.MethodGet, tt.target, "")
+		if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != MimeProblemJSON ||
+			!strings.Contains(w.Body.String(), tt.detail) {
+			t.Errorf("%s: got %d %s", tt.target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestJSON_Negotiation(t *testing.T) {
+	router := typedRouter(t)
+	w := serveTyped(router, http.MethodPut, "/items/1", "name: yaml item\n",
+		"Content-Type", "text/yaml", "Accept", "text/html;q=0.9, text/yaml")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/yaml" ||
+		!strings.Contains(w.Body.String(), "yaml item") {
+		t.Errorf("got %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	w = serveTyped(router, http.MethodGet, "/items/1", "", "Accept", "text/html, */*;q=0.8")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	w = serveTyped(router, http.MethodGet, "/items/1", "", "Accept", "text/html")
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("got %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+	w = serveTyped(router, http.MethodPut, "/items/1", "name=x", "Content-Type", "application/x-www-form-urlencoded")
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("got %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+	w = serveTyped(router, http.MethodPut, "/items/1", `{"name":`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid request body") {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJSON_Errors(t *testing.T) {
+	router := typedRouter(t)
+	w := serveTyped(router, http.MethodPut, "/items/1", `{"name":"ab"}`)
+	var problem ProblemDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnprocessableEntity || problem.Status != w.Code || problem.Instance != "/items/1" ||
+		problem.Detail == "" {
+		t.Errorf("got %d %+v", w.Code, problem)
+	}
+
+	w = serveTyped(router, http.MethodGet, "/items/404", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), errItemNotFound.Error()) {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+	// the detail of the server errors is not sent
+	w = serveTyped(router, http.MethodGet, "/items/500", "")
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "password") {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+
+	defer func(renderer ErrorRenderer) { DefaultErrorRenderer = renderer }(DefaultErrorRenderer)
+	DefaultErrorRenderer = func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, "custom: "+err.Error(), StatusOf(err))
+	}
+	w = serveTyped(router, http.MethodGet, "/items/404", "")
+	if w.Code != http.StatusNotFound || w.Body.String() != "custom: item not found\n" {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJSON_NoContentAndPanic(t *testing.T) {
+	router := typedRouter(t)
+	w := serveTyped(router, http.MethodDelete, "/items/1", "")
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+	w = serveTyped(router, http.MethodGet, "/panic", "")
+	if w.Code != http.StatusTeapot {
+		t.Errorf("got %d, want the status of the recovery filter", w.Code)
+	}
+}
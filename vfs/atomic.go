@@ -0,0 +1,151 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+
+	"oss.nandlabs.io/golly/uuid"
+)
+
+const atomicTmpPrefix = ".tmp-"
+
+// ErrConflict is returned by Update with WithConflictCheck when the file is modified by another writer during the
+// update
+var ErrConflict = errors.New("concurrent modification")
+
+// ErrNotAtomic is returned by the MoveAtomic of a file system that cannot replace the file atomically, WriteAtomic
+// then falls back to Move
+var ErrNotAtomic = errors.New("atomic move not supported")
+
+// AtomicMover is implemented by the file systems able to replace a file by another of the same file system
+// atomically, so that the readers see either the previous or the new content. The manager implements it for its
+// file systems.
+type AtomicMover interface {
+	// MoveAtomic renames the src file to dst replacing any existing file, or returns ErrNotAtomic
+	MoveAtomic(src, dst *url.URL) error
+}
+
+// UpdateOption configures Update
+type UpdateOption func(cfg *updateConfig)
+
+// updateConfig holds the configuration of an update
+type updateConfig struct {
+	conflictCheck bool
+}
+
+// WithConflictCheck fails the Update with ErrConflict if the content of the file changed since it was read. The
+// content is checked again just before the new content replaces it.
+func WithConflictCheck() UpdateOption {
+	return func(cfg *updateConfig) {
+		cfg.conflictCheck = true
+	}
+}
+
+// WriteAtomic writes the content of the reader to the file of the url on any file system registered with the
+// manager, without leaving a partially written file behind. The content is written to a temporary sibling of the
+// file, then moved in place with the MoveAtomic of the manager. The temporary file is deleted on failure, leaving
+// the existing file untouched. The file systems not supporting MoveAtomic fall back to Move, which may not be
+// atomic.
+func WriteAtomic(manager Manager, u *url.URL, r io.Reader) error {
+	return writeAtomic(manager, u, r, nil)
+}
+
+// WriteAtomicRaw is same as WriteAtomic except it accepts the url as a string
+func WriteAtomicRaw(manager Manager, raw string, r io.Reader) (err error) {
+	var u *url.URL
+	if u, err = url.Parse(raw); err == nil {
+		err = WriteAtomic(manager, u, r)
+	}
+	return
+}
+
+// Update reads the content of the file of the url, applies the fn and writes its result back with WriteAtomic.
+// The fn receives nil for a missing file, which is then created. An error of the fn aborts the update.
+func Update(manager Manager, u *url.URL, fn func(current []byte) ([]byte, error), opts ...UpdateOption) (err error) {
+	cfg := &updateConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	var current, updated []byte
+	if current, err = readAll(manager, u); err != nil {
+		return
+	}
+	if updated, err = fn(current); err != nil {
+		return
+	}
+	var check func() error
+	if cfg.conflictCheck {
+		sum := sha256.Sum256(current)
+		check = func() (err error) {
+			var latest []byte
+			if latest, err = readAll(manager, u); err == nil && sha256.Sum256(latest) != sum {
+				err = ErrConflict
+			}
+			return
+		}
+	}
+	return writeAtomic(manager, u, bytes.NewReader(updated), check)
+}
+
+// UpdateRaw is same as Update except it accepts the url as a string
+func UpdateRaw(manager Manager, raw string, fn func(current []byte) ([]byte, error), opts ...UpdateOption) (err error) {
+	var u *url.URL
+	if u, err = url.Parse(raw); err == nil {
+		err = Update(manager, u, fn, opts...)
+	}
+	return
+}
+
+// writeAtomic writes the content to a temporary sibling of the file and moves it in place once the check passes
+func writeAtomic(manager Manager, u *url.URL, r io.Reader, check func() error) (err error) {
+	var uid *uuid.UUID
+	if uid, err = uuid.V4(); err != nil {
+		return
+	}
+	tmpUrl := *u
+	tmpUrl.Path = path.Join(path.Dir(u.Path), "."+path.Base(u.Path)+atomicTmpPrefix+uid.String())
+	var tmp VFile
+	if tmp, err = manager.Create(&tmpUrl); err != nil {
+		return
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && check != nil {
+		err = check()
+	}
+	if err == nil {
+		if mover, ok := manager.(AtomicMover); ok {
+			err = mover.MoveAtomic(&tmpUrl, u)
+		} else {
+			err = ErrNotAtomic
+		}
+		if errors.Is(err, ErrNotAtomic) {
+			err = manager.Move(&tmpUrl, u)
+		}
+	}
+	if err != nil {
+		_ = manager.Delete(&tmpUrl)
+	}
+	return
+}
+
+// readAll returns the content of the file, nil if it does not exist
+func readAll(manager Manager, u *url.URL) (content []byte, err error) {
+	var file VFile
+	if file, err = manager.Open(u); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer file.Close()
+	return file.AsBytes()
+}
+
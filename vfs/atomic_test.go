@@ -0,0 +1,80 @@
+package vfs
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+var errCrash = errors.New("crash")
+
+// crashingManager fails between the write of the temporary file and its move in place
+type crashingManager struct {
+	Manager
+}
+
+func (c *crashingManager) MoveAtomic(src, dst *url.URL) error {
+	return errCrash
+}
+
+func TestWriteAtomic(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://atomic/root", "file://" + t.TempDir() + "/root"} {
+		_, err := m.MkdirAllRaw(root)
+		assert.NoError(t, err)
+		assert.NoError(t, WriteAtomicRaw(m, root+"/a.json", strings.NewReader(`{"v":1}`)))
+		assert.Equal(t, map[string]string{"a.json": `{"v":1}`}, readTree(t, m, root))
+		assert.NoError(t, WriteAtomicRaw(m, root+"/a.json", strings.NewReader(`{"v":2}`)))
+		assert.Equal(t, map[string]string{"a.json": `{"v":2}`}, readTree(t, m, root))
+
+		// a crash between the write and the rename, the temporary file is deleted
+		err = WriteAtomicRaw(&crashingManager{Manager: m}, root+"/a.json", strings.NewReader(`{"v":3}`))
+		assert.Equal(t, errCrash, err)
+		assert.Equal(t, map[string]string{"a.json": `{"v":2}`}, readTree(t, m, root))
+
+		// a crash while writing
+		err = WriteAtomicRaw(m, root+"/a.json", iotest.TimeoutReader(strings.NewReader(`{"v":4}`)))
+		assert.True(t, errors.Is(err, iotest.ErrTimeout))
+		assert.Equal(t, map[string]string{"a.json": `{"v":2}`}, readTree(t, m, root))
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://update/root", "file://" + t.TempDir() + "/root"} {
+		_, err := m.MkdirAllRaw(root)
+		assert.NoError(t, err)
+		appendFn := func(s string) func(current []byte) ([]byte, error) {
+			return func(current []byte) ([]byte, error) {
+				return append(current, s...), nil
+			}
+		}
+		// a missing file is created
+		assert.NoError(t, UpdateRaw(m, root+"/counter", appendFn("a")))
+		assert.NoError(t, UpdateRaw(m, root+"/counter", appendFn("b"), WithConflictCheck()))
+		assert.Equal(t, map[string]string{"counter": "ab"}, readTree(t, m, root))
+
+		failure := errors.New("failure")
+		err = UpdateRaw(m, root+"/counter", func(current []byte) ([]byte, error) { return nil, failure })
+		assert.Equal(t, failure, err)
+
+		// another writer updates the file while the fn runs
+		err = UpdateRaw(m, root+"/counter", func(current []byte) ([]byte, error) {
+			assert.NoError(t, UpdateRaw(m, root+"/counter", appendFn("c")))
+			return append(current, 'd'), nil
+		}, WithConflictCheck())
+		assert.True(t, errors.Is(err, ErrConflict))
+		assert.Equal(t, map[string]string{"counter": "abc"}, readTree(t, m, root))
+
+		// without the check the last writer wins
+		assert.NoError(t, UpdateRaw(m, root+"/counter", func(current []byte) ([]byte, error) {
+			assert.NoError(t, UpdateRaw(m, root+"/counter", appendFn("e")))
+			return append(current, 'f'), nil
+		}))
+		assert.Equal(t, map[string]string{"counter": "abcf"}, readTree(t, m, root))
+	}
+}
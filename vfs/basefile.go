@@ -22,5 +22,26 @@ func (b *BaseFile) AsBytes() ([]byte, error) {
 }
 
 func (b *BaseFile) WriteString(s string) (int, error) {
-	return io.WriteString(b, s)
+	return b.Write([]byte(s))
+}
+
+// List returns the children of the file that pass the filter, listed with ListAll. The file systems able to filter
+// while listing override it.
+func (b *BaseFile) List(filter FileFilter) (files []VFile, err error) {
+	var children []VFile
+	if children, err = b.ListAll(); err != nil {
+		return
+	}
+	for _, child := range children {
+		var ok bool
+		if ok, err = filter(child); err != nil {
+			return nil, err
+		}
+		if ok {
+			files = append(files, child)
+		} else {
+			_ = child.Close()
+		}
+	}
+	return
 }
@@ -193,8 +193,9 @@ func (b *BaseVFS) Walk(u *url.URL, fn WalkFn) (err error) {
 	var srcFi VFileInfo
 	var childInfo VFileInfo
 	var children []VFile
-	src, err = manager.Open(u)
+	src, err = b.Open(u)
 	if err == nil {
+		defer ioutils.CloserFunc(src)
 		srcFi, err = src.Info()
 		if err == nil {
 			if srcFi.IsDir() {
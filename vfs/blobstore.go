@@ -0,0 +1,452 @@
+package vfs
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/uuid"
+)
+
+const (
+	blobMagic            = "gbs"
+	blobVersion          = 1
+	blobHeaderSize       = 16
+	blobFlagCompressed   = 1
+	blobTmpDir           = "tmp"
+	blobDigestLength     = 64
+	blobShardPrefixCount = 2
+)
+
+// ErrBlobNotFound is returned when no blob exists for the digest
+var ErrBlobNotFound = errors.New("blob not found")
+
+// ErrBlobCorrupt is returned when the stored content of a blob does not match its digest
+var ErrBlobCorrupt = errors.New("blob corrupt")
+
+// ErrInvalidDigest is returned when the digest is not a hex encoded SHA-256 checksum
+var ErrInvalidDigest = errors.New("invalid digest")
+
+// ErrBlobReadOnly is returned when writing to a blob
+var ErrBlobReadOnly = errors.New("blob is read only")
+
+// BlobStoreOptions configures a BlobStore
+type BlobStoreOptions struct {
+	// Compress stores the new blobs gzip compressed. Blobs are always identified by the digest of the
+	// uncompressed content, so compressed and uncompressed blobs of the same content are deduplicated.
+	Compress bool
+}
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	// Digest is the hex encoded SHA-256 checksum of the content
+	Digest string
+	// Size is the size of the content
+	Size int64
+	// StoredSize is the size of the blob in the store including the header
+	StoredSize int64
+	// Compressed is true if the blob is stored compressed
+	Compressed bool
+	// ModTime is the time the blob was stored
+	ModTime time.Time
+}
+
+// BlobStore is a content addressable store on top of any file system of the Manager.
+// Blobs are stored at <base>/aa/bb/<rest of the digest> and the content is written only once for a digest.
+// Blobs are written to a temporary file which is moved once complete, so partially written blobs are never
+// visible. The move is atomic for the file systems that rename, like the local and mem file systems.
+type BlobStore struct {
+	manager Manager
+	base    *url.URL
+	options BlobStoreOptions
+	chksum  ioutils.ChkSumCalc
+	// mutex is held exclusively by GC so that blobs stored during the sweep are never removed
+	mutex sync.RWMutex
+}
+
+// NewBlobStore creates a new BlobStore at the baseURL using the manager.
+func NewBlobStore(manager Manager, baseURL string, opts BlobStoreOptions) (store *BlobStore, err error) {
+	var base *url.URL
+	base, err = url.Parse(baseURL)
+	if err != nil {
+		return
+	}
+	if !manager.IsSupported(base.Scheme) {
+		err = fmt.Errorf("unsupported scheme %s for in the url %s", base.Scheme, baseURL)
+		return
+	}
+	base.Path = path.Clean("/" + base.Path)
+	var dir VFile
+	dir, err = manager.MkdirAll(base.JoinPath(blobTmpDir))
+	if err == nil {
+		ioutils.CloserFunc(dir)
+		store = &BlobStore{
+			manager: manager,
+			base:    base,
+			options: opts,
+			chksum:  ioutils.NewChkSumCalc(ioutils.SHA256),
+		}
+	}
+	return
+}
+
+// Put stores the content of the reader and returns its digest and size. If a blob with the same digest
+// exists the content is not stored again.
+func (s *BlobStore) Put(r io.Reader) (digest string, size int64, err error) {
+	var uid *uuid.UUID
+	uid, err = uuid.V4()
+	if err != nil {
+		return
+	}
+	tmpUrl := s.base.JoinPath(blobTmpDir, uid.String())
+	var tmp VFile
+	tmp, err = s.manager.Create(tmpUrl)
+	if err != nil {
+		return
+	}
+	digest, size, err = s.write(tmp, r)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.commit(tmpUrl, digest)
+	}
+	if err != nil {
+		_ = s.manager.Delete(tmpUrl)
+	}
+	return
+}
+
+// write writes the header and the content to the file computing the digest while streaming the content.
+func (s *BlobStore) write(file VFile, r io.Reader) (digest string, size int64, err error) {
+	var flags byte
+	if s.options.Compress {
+		flags |= blobFlagCompressed
+	}
+	_, err = file.Write(encodeBlobHeader(flags, 0))
+	if err != nil {
+		return
+	}
+	var w io.Writer = file
+	var zw *gzip.Writer
+	if s.options.Compress {
+		zw = gzip.NewWriter(file)
+		w = zw
+	}
+	counter := &countingWriter{}
+	digest, err = s.chksum.CalculateFor(io.TeeReader(r, io.MultiWriter(w, counter)))
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		size = counter.n
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = file.Write(encodeBlobHeader(flags, size))
+	}
+	return
+}
+
+// commit moves the temporary file to the location of the digest unless the blob already exists.
+func (s *BlobStore) commit(tmpUrl *url.URL, digest string) (err error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var exists bool
+	exists, err = s.exists(digest)
+	if err != nil {
+		return
+	}
+	if exists {
+		err = s.manager.Delete(tmpUrl)
+		return
+	}
+	blobUrl := s.blobUrl(digest)
+	var dir VFile
+	dir, err = s.manager.MkdirAll(blobUrl.JoinPath(".."))
+	if err == nil {
+		ioutils.CloserFunc(dir)
+		err = s.manager.Move(tmpUrl, blobUrl)
+	}
+	return
+}
+
+// Get returns the content of the blob. The returned file is read only and must be closed by the caller.
+func (s *BlobStore) Get(digest string) (file VFile, err error) {
+	var f VFile
+	var flags byte
+	var size int64
+	f, flags, size, err = s.open(digest)
+	if err != nil {
+		return
+	}
+	bf := &blobFile{VFile: f, reader: io.LimitReader(f, size)}
+	if flags&blobFlagCompressed != 0 {
+		bf.zr, err = gzip.NewReader(f)
+		if err != nil {
+			ioutils.CloserFunc(f)
+			err = fmt.Errorf("%w: %s: %v", ErrBlobCorrupt, digest, err)
+			return
+		}
+		bf.reader = bf.zr
+	}
+	file = bf
+	return
+}
+
+// Exists checks if the blob exists
+func (s *BlobStore) Exists(digest string) (exists bool, err error) {
+	if err = validateDigest(digest); err == nil {
+		exists, err = s.exists(digest)
+	}
+	return
+}
+
+func (s *BlobStore) exists(digest string) (exists bool, err error) {
+	var f VFile
+	f, err = s.manager.Open(s.blobUrl(digest))
+	if err == nil {
+		ioutils.CloserFunc(f)
+		exists = true
+	} else if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+// Delete removes the blob
+func (s *BlobStore) Delete(digest string) (err error) {
+	var exists bool
+	exists, err = s.Exists(digest)
+	if err == nil {
+		if !exists {
+			err = fmt.Errorf("%w: %s", ErrBlobNotFound, digest)
+			return
+		}
+		err = s.manager.Delete(s.blobUrl(digest))
+	}
+	return
+}
+
+// Stat returns the BlobInfo of the blob
+func (s *BlobStore) Stat(digest string) (info BlobInfo, err error) {
+	var f VFile
+	var flags byte
+	var size int64
+	f, flags, size, err = s.open(digest)
+	if err != nil {
+		return
+	}
+	defer ioutils.CloserFunc(f)
+	var fi VFileInfo
+	fi, err = f.Info()
+	if err == nil {
+		info = BlobInfo{
+			Digest:     digest,
+			Size:       size,
+			StoredSize: fi.Size(),
+			Compressed: flags&blobFlagCompressed != 0,
+			ModTime:    fi.ModTime(),
+		}
+	}
+	return
+}
+
+// Verify re-hashes the stored content of the blob. ErrBlobCorrupt is returned if the content does not
+// match the digest.
+func (s *BlobStore) Verify(digest string) (err error) {
+	var f VFile
+	f, err = s.Get(digest)
+	if err != nil {
+		return
+	}
+	defer ioutils.CloserFunc(f)
+	var info BlobInfo
+	info, err = s.Stat(digest)
+	if err != nil {
+		return
+	}
+	counter := &countingWriter{}
+	var sum string
+	sum, err = s.chksum.CalculateFor(io.TeeReader(f, counter))
+	if err != nil {
+		err = fmt.Errorf("%w: %s: %v", ErrBlobCorrupt, digest, err)
+	} else if sum != digest || counter.n != info.Size {
+		err = fmt.Errorf("%w: %s", ErrBlobCorrupt, digest)
+	}
+	return
+}
+
+// GC removes the blobs that are not live. The liveDigests function is invoked once and yields the
+// digests of all the blobs that are referenced by the caller. Puts wait for GC to complete, so a blob must
+// be part of the live set once its Put returns.
+// The digests of the removed blobs are returned.
+func (s *BlobStore) GC(ctx context.Context, liveDigests func(yield func(string))) (removed []string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	live := make(map[string]bool)
+	liveDigests(func(digest string) {
+		live[digest] = true
+	})
+	var garbage []string
+	err = s.manager.Walk(s.base, func(file VFile) (err error) {
+		defer ioutils.CloserFunc(file)
+		if err = ctx.Err(); err == nil {
+			if digest, ok := s.digestOf(file.Url()); ok && !live[digest] {
+				garbage = append(garbage, digest)
+			}
+		}
+		return
+	})
+	for _, digest := range garbage {
+		if err != nil {
+			return
+		}
+		if err = ctx.Err(); err == nil {
+			err = s.manager.Delete(s.blobUrl(digest))
+			if err == nil {
+				removed = append(removed, digest)
+			}
+		}
+	}
+	return
+}
+
+// open opens the blob and reads its header
+func (s *BlobStore) open(digest string) (file VFile, flags byte, size int64, err error) {
+	if err = validateDigest(digest); err != nil {
+		return
+	}
+	file, err = s.manager.Open(s.blobUrl(digest))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%w: %s", ErrBlobNotFound, digest)
+		}
+		return
+	}
+	header := make([]byte, blobHeaderSize)
+	if _, err = io.ReadFull(file, header); err == nil {
+		flags, size, err = decodeBlobHeader(header)
+	}
+	if err != nil {
+		ioutils.CloserFunc(file)
+		file = nil
+		err = fmt.Errorf("%w: %s: %v", ErrBlobCorrupt, digest, err)
+	}
+	return
+}
+
+// blobUrl returns the url of the blob sharded by the first two pairs of characters of the digest
+func (s *BlobStore) blobUrl(digest string) *url.URL {
+	return s.base.JoinPath(digest[:blobShardPrefixCount], digest[blobShardPrefixCount:2*blobShardPrefixCount],
+		digest[2*blobShardPrefixCount:])
+}
+
+// digestOf returns the digest of the blob at the url. False is returned if the url is not of a blob.
+func (s *BlobStore) digestOf(u *url.URL) (digest string, ok bool) {
+	rel := strings.TrimPrefix(path.Clean(u.Path), s.base.Path+"/")
+	parts := strings.Split(rel, "/")
+	if len(parts) == 3 && len(parts[0]) == blobShardPrefixCount && len(parts[1]) == blobShardPrefixCount {
+		digest = strings.Join(parts, "")
+		ok = validateDigest(digest) == nil
+	}
+	return
+}
+
+func validateDigest(digest string) (err error) {
+	if len(digest) != blobDigestLength || strings.ToLower(digest) != digest {
+		err = fmt.Errorf("%w: %s", ErrInvalidDigest, digest)
+	} else if _, decodeErr := hex.DecodeString(digest); decodeErr != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidDigest, digest)
+	}
+	return
+}
+
+// encodeBlobHeader encodes the header stored before the content of every blob.
+// The header is the magic, the version, the flags, 3 reserved bytes and the size of the content.
+func encodeBlobHeader(flags byte, size int64) []byte {
+	header := make([]byte, blobHeaderSize)
+	copy(header, blobMagic)
+	header[3] = blobVersion
+	header[4] = flags
+	binary.BigEndian.PutUint64(header[8:], uint64(size))
+	return header
+}
+
+func decodeBlobHeader(header []byte) (flags byte, size int64, err error) {
+	if string(header[:3]) != blobMagic || header[3] != blobVersion {
+		err = errors.New("invalid header")
+		return
+	}
+	flags = header[4]
+	size = int64(binary.BigEndian.Uint64(header[8:]))
+	return
+}
+
+// blobFile is the read only VFile of the content of a blob
+type blobFile struct {
+	VFile
+	reader io.Reader
+	zr     *gzip.Reader
+}
+
+func (b *blobFile) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+func (b *blobFile) Write(p []byte) (int, error) {
+	return 0, ErrBlobReadOnly
+}
+
+func (b *blobFile) WriteString(s string) (int, error) {
+	return 0, ErrBlobReadOnly
+}
+
+func (b *blobFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("unsupported operation Seek for blob")
+}
+
+func (b *blobFile) AsString() (s string, err error) {
+	var bytes []byte
+	bytes, err = io.ReadAll(b)
+	if err == nil {
+		s = string(bytes)
+	}
+	return
+}
+
+func (b *blobFile) AsBytes() ([]byte, error) {
+	return io.ReadAll(b)
+}
+
+func (b *blobFile) Close() (err error) {
+	if b.zr != nil {
+		err = b.zr.Close()
+	}
+	if closeErr := b.VFile.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,230 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// blobStoreBackends returns the base urls of the file systems the blob store tests run against
+func blobStoreBackends(t *testing.T) (m Manager, backends map[string]string) {
+	m = &fileSystems{}
+	m.Register(newOsFs())
+	m.Register(NewMemFs())
+	backends = map[string]string{
+		"file": "file://" + t.TempDir() + "/blobs",
+		"mem":  "mem://store/blobs",
+	}
+	return
+}
+
+// blobCount counts the blobs and the temporary files of the store
+func blobCount(t *testing.T, s *BlobStore) (blobs, tmp int) {
+	err := s.manager.Walk(s.base, func(file VFile) error {
+		defer file.Close()
+		if _, ok := s.digestOf(file.Url()); ok {
+			blobs++
+		} else {
+			tmp++
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	return
+}
+
+func TestBlobStore_PutGet(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewBlobStore(m, base, BlobStoreOptions{})
+			assert.NoError(t, err)
+			digest, size, err := s.Put(strings.NewReader("hello blob"))
+			assert.NoError(t, err)
+			want, _ := ioutils.NewChkSumCalc(ioutils.SHA256).Calculate("hello blob")
+			assert.Equal(t, want, digest)
+			assert.Equal(t, int64(10), size)
+			assert.Equal(t, "/"+digest[:2]+"/"+digest[2:4]+"/"+digest[4:],
+				strings.TrimPrefix(s.blobUrl(digest).Path, s.base.Path))
+
+			exists, err := s.Exists(digest)
+			assert.NoError(t, err)
+			assert.True(t, exists)
+			f, err := s.Get(digest)
+			assert.NoError(t, err)
+			content, err := f.AsString()
+			assert.NoError(t, err)
+			assert.Equal(t, "hello blob", content)
+			_, err = f.Write([]byte("x"))
+			assert.True(t, errors.Is(err, ErrBlobReadOnly))
+			assert.NoError(t, f.Close())
+
+			info, err := s.Stat(digest)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(10), info.Size)
+			assert.Equal(t, int64(10+blobHeaderSize), info.StoredSize)
+			assert.False(t, info.Compressed)
+			assert.NoError(t, s.Verify(digest))
+
+			assert.NoError(t, s.Delete(digest))
+			exists, err = s.Exists(digest)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+			_, err = s.Get(digest)
+			assert.True(t, errors.Is(err, ErrBlobNotFound))
+			assert.True(t, errors.Is(s.Delete(digest), ErrBlobNotFound))
+			_, err = s.Get("not-a-digest")
+			assert.True(t, errors.Is(err, ErrInvalidDigest))
+		})
+	}
+}
+
+func TestBlobStore_ConcurrentPut(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewBlobStore(m, base, BlobStoreOptions{})
+			assert.NoError(t, err)
+			content := strings.Repeat("same content ", 1000)
+			digests := make([]string, 20)
+			wg := sync.WaitGroup{}
+			for i := range digests {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var err error
+					digests[i], _, err = s.Put(strings.NewReader(content))
+					assert.NoError(t, err)
+				}(i)
+			}
+			wg.Wait()
+			for _, digest := range digests {
+				assert.Equal(t, digests[0], digest)
+			}
+			blobs, tmp := blobCount(t, s)
+			assert.Equal(t, 1, blobs)
+			assert.Equal(t, 0, tmp)
+			f, err := s.Get(digests[0])
+			assert.NoError(t, err)
+			defer f.Close()
+			got, err := f.AsString()
+			assert.NoError(t, err)
+			assert.Equal(t, content, got)
+		})
+	}
+}
+
+func TestBlobStore_GC(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewBlobStore(m, base, BlobStoreOptions{})
+			assert.NoError(t, err)
+			var digests []string
+			for _, content := range []string{"a", "b", "c", "d"} {
+				digest, _, err := s.Put(strings.NewReader(content))
+				assert.NoError(t, err)
+				digests = append(digests, digest)
+			}
+			live := digests[:2]
+			removed, err := s.GC(context.Background(), func(yield func(string)) {
+				for _, digest := range live {
+					yield(digest)
+				}
+			})
+			assert.NoError(t, err)
+			sort.Strings(removed)
+			garbage := append([]string{}, digests[2:]...)
+			sort.Strings(garbage)
+			assert.Equal(t, garbage, removed)
+			for i, digest := range digests {
+				exists, err := s.Exists(digest)
+				assert.NoError(t, err)
+				assert.Equal(t, i < 2, exists)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err = s.GC(ctx, func(yield func(string)) {})
+			assert.True(t, errors.Is(err, context.Canceled))
+			blobs, _ := blobCount(t, s)
+			assert.Equal(t, 2, blobs)
+		})
+	}
+}
+
+func TestBlobStore_Corruption(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		for _, compress := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/compress=%v", name, compress), func(t *testing.T) {
+				s, err := NewBlobStore(m, base, BlobStoreOptions{Compress: compress})
+				assert.NoError(t, err)
+				content := strings.Repeat("corrupt me ", 100)
+				digest, _, err := s.Put(strings.NewReader(content))
+				assert.NoError(t, err)
+				assert.NoError(t, s.Verify(digest))
+
+				f, err := m.Open(s.blobUrl(digest))
+				assert.NoError(t, err)
+				stored, err := f.AsBytes()
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+				stored[len(stored)-5] ^= 0xff
+				f, err = m.Create(s.blobUrl(digest))
+				assert.NoError(t, err)
+				_, err = io.Copy(f, bytes.NewReader(stored))
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+
+				err = s.Verify(digest)
+				assert.True(t, errors.Is(err, ErrBlobCorrupt))
+				assert.NoError(t, s.Delete(digest))
+			})
+		}
+	}
+}
+
+func TestBlobStore_Compression(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			compressed, err := NewBlobStore(m, base, BlobStoreOptions{Compress: true})
+			assert.NoError(t, err)
+			plain, err := NewBlobStore(m, base, BlobStoreOptions{})
+			assert.NoError(t, err)
+			content := strings.Repeat("compressible ", 1000)
+			digest, size, err := compressed.Put(strings.NewReader(content))
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(content)), size)
+
+			// the digest is of the uncompressed content and is deduplicated with uncompressed puts
+			plainDigest, _, err := plain.Put(strings.NewReader(content))
+			assert.NoError(t, err)
+			assert.Equal(t, digest, plainDigest)
+
+			info, err := plain.Stat(digest)
+			assert.NoError(t, err)
+			assert.True(t, info.Compressed)
+			assert.Equal(t, int64(len(content)), info.Size)
+			assert.True(t, info.StoredSize < info.Size)
+
+			f, err := plain.Get(digest)
+			assert.NoError(t, err)
+			got, err := f.AsString()
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+			assert.Equal(t, content, got)
+			assert.NoError(t, plain.Verify(digest))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+)
+
+// ByExtension matches the files with one of the extensions, with or without the leading dot, in any case.
+// Directories never match.
+func ByExtension(exts ...string) FileFilter {
+	wanted := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		wanted["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return func(file VFile) (ok bool, err error) {
+		var info VFileInfo
+		if info, err = file.Info(); err == nil && !info.IsDir() {
+			ok = wanted[strings.ToLower(path.Ext(info.Name()))]
+		}
+		return
+	}
+}
+
+// ByMimeType matches the files whose mime type, looked up from the extension with ioutils.GetMimeFromExt, is one of
+// the types. A type ending with /* such as image/* matches all its subtypes. Directories never match.
+func ByMimeType(mimes ...string) FileFilter {
+	return func(file VFile) (ok bool, err error) {
+		var info VFileInfo
+		if info, err = file.Info(); err != nil || info.IsDir() {
+			return
+		}
+		mime := ioutils.GetMimeFromExt(strings.ToLower(path.Ext(info.Name())))
+		if mime == "" {
+			return
+		}
+		for _, m := range mimes {
+			if m == mime || (strings.HasSuffix(m, "/*") && strings.HasPrefix(mime, strings.TrimSuffix(m, "*"))) {
+				return true, nil
+			}
+		}
+		return
+	}
+}
+
+// ModifiedAfter matches the files and directories modified after the time
+func ModifiedAfter(t time.Time) FileFilter {
+	return func(file VFile) (ok bool, err error) {
+		var info VFileInfo
+		if info, err = file.Info(); err == nil {
+			ok = info.ModTime().After(t)
+		}
+		return
+	}
+}
+
+// MinSize matches the files of at least size bytes. Directories never match.
+func MinSize(size int64) FileFilter {
+	return func(file VFile) (ok bool, err error) {
+		var info VFileInfo
+		if info, err = file.Info(); err == nil {
+			ok = !info.IsDir() && info.Size() >= size
+		}
+		return
+	}
+}
+
+// AllOf matches the files passing all the filters, evaluated in order until one does not match
+func AllOf(filters ...FileFilter) FileFilter {
+	return func(file VFile) (ok bool, err error) {
+		for _, filter := range filters {
+			if ok, err = filter(file); err != nil || !ok {
+				return
+			}
+		}
+		return true, nil
+	}
+}
+
+// AnyOf matches the files passing one of the filters, evaluated in order until one matches
+func AnyOf(filters ...FileFilter) FileFilter {
+	return func(file VFile) (ok bool, err error) {
+		for _, filter := range filters {
+			if ok, err = filter(file); err != nil || ok {
+				return
+			}
+		}
+		return
+	}
+}
+
+// Not matches the files not passing the filter
+func Not(filter FileFilter) FileFilter {
+	return func(file VFile) (ok bool, err error) {
+		if ok, err = filter(file); err == nil {
+			ok = !ok
+		}
+		return
+	}
+}
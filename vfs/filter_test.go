@@ -0,0 +1,42 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestFileFilters(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://filter/root", "file://" + t.TempDir() + "/root"} {
+		writeGlobFiles(t, m, root)
+		dir, err := m.OpenRaw(root)
+		assert.NoError(t, err)
+		list := func(filter FileFilter) (names []string) {
+			files, err := dir.List(filter)
+			assert.NoError(t, err)
+			for _, file := range files {
+				info, err := file.Info()
+				assert.NoError(t, err)
+				names = append(names, info.Name())
+				assert.NoError(t, file.Close())
+			}
+			return
+		}
+		assert.Equal(t, []string{".hidden.log", "app.log"}, list(ByExtension("LOG")))
+		assert.Equal(t, []string{".hidden.log", "app.log", "notes.txt"}, list(ByExtension(".log", "txt")))
+		assert.Equal(t, []string{"notes.txt"}, list(ByMimeType("text/plain")))
+		assert.Equal(t, []string{"notes.txt"}, list(ByMimeType("text/*")))
+		assert.Equal(t, []string{".hidden.log", "app.log.1", "notes.txt"}, list(MinSize(9)))
+		assert.Equal(t, []string{"2024", "deep", "v1.2.3"}, list(AllOf(ModifiedAfter(time.Now().Add(-time.Hour)),
+			Not(AnyOf(ByExtension("log", "txt", "1"), MinSize(0))))))
+		assert.Equal(t, []string(nil), list(ModifiedAfter(time.Now().Add(time.Hour))))
+
+		failure := errors.New("failure")
+		_, err = dir.List(func(file VFile) (bool, error) { return false, failure })
+		assert.Equal(t, failure, err)
+		assert.NoError(t, dir.Close())
+	}
+}
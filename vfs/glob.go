@@ -0,0 +1,151 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// globPattern is a compiled glob of Glob
+type globPattern struct {
+	root     *url.URL
+	segments []string
+}
+
+// Glob returns the files and directories matching the pattern on any file system registered with the manager, in the
+// order of WalkTree. The pattern is a url whose path is a slash separated glob, e.g. file:///var/log/**/*.log:
+//
+//   - * matches any sequence of characters within a path segment, including a leading dot
+//   - ? matches any character within a path segment
+//   - [a-z] and [^a-z] match a character of the class, and \ escapes a character, as with path.Match
+//   - ** as a whole segment matches zero or more directories
+//
+// The path of the pattern is not url escaped and ? is always a wildcard. The tree is walked from the longest leading
+// directory without wildcards, with ListAll, skipping the directories that cannot match. A syntax error of the
+// pattern is returned before anything is listed, wrapping path.ErrBadPattern. A missing leading directory matches
+// nothing.
+func Glob(manager Manager, pattern string) (files []VFile, err error) {
+	var g *globPattern
+	if g, err = compileGlob(pattern); err != nil {
+		return
+	}
+	if len(g.segments) == 0 {
+		// no wildcard, the file itself if it exists
+		var file VFile
+		if file, err = manager.Open(g.root); err == nil {
+			files = append(files, file)
+		} else if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	rootPath := strings.TrimSuffix(g.root.Path, "/")
+	var matched []*url.URL
+	err = WalkTree(manager, g.root, func(file VFile, info VFileInfo) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(file.Url().Path, rootPath), "/")
+		if rel == "" {
+			return nil
+		}
+		segments := strings.Split(rel, "/")
+		if matchGlob(g.segments, segments) {
+			u := *file.Url()
+			matched = append(matched, &u)
+		}
+		if info.IsDir() && !matchGlobPrefix(g.segments, segments) {
+			return SkipDir
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) && len(matched) == 0 {
+		err = nil
+	}
+	// the files of the walk are closed once walked
+	for _, u := range matched {
+		if err != nil {
+			break
+		}
+		var file VFile
+		if file, err = manager.Open(u); err == nil {
+			files = append(files, file)
+		}
+	}
+	return
+}
+
+// compileGlob splits the pattern into the url of its leading directory without wildcards and the segments of the
+// glob under it
+func compileGlob(pattern string) (g *globPattern, err error) {
+	root := &url.URL{}
+	p := pattern
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		root.Scheme = scheme
+		root.Host, p, _ = strings.Cut(rest, "/")
+		p = "/" + p
+	}
+	segments := strings.Split(p, "/")
+	for _, segment := range segments {
+		if segment != "**" && strings.Contains(segment, "**") {
+			return nil, fmt.Errorf("%w: ** must be a whole segment in %s", path.ErrBadPattern, pattern)
+		}
+		if _, err = path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, pattern)
+		}
+	}
+	static := 0
+	for static < len(segments) && segments[static] != "**" && !strings.ContainsAny(segments[static], `*?[\`) {
+		static++
+	}
+	root.Path = strings.Join(segments[:static], "/")
+	if root.Path == "" && strings.HasPrefix(p, "/") {
+		root.Path = "/"
+	}
+	g = &globPattern{root: root}
+	for _, segment := range segments[static:] {
+		if segment != "" {
+			g.segments = append(g.segments, segment)
+		}
+	}
+	return
+}
+
+// matchGlob checks if the path segments match the glob segments
+func matchGlob(glob, segments []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchGlob(glob[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], segments[0]); !ok {
+			return false
+		}
+		glob, segments = glob[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// matchGlobPrefix checks if a path below the directory of the segments may match the glob segments
+func matchGlobPrefix(glob, segments []string) bool {
+	for len(segments) > 0 {
+		if len(glob) == 0 {
+			return false
+		}
+		if glob[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(glob[0], segments[0]); !ok {
+			return false
+		}
+		glob, segments = glob[1:], segments[1:]
+	}
+	return len(glob) > 0
+}
@@ -0,0 +1,83 @@
+package vfs
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// globFiles are the files of the glob fixtures, with dots, spaces and unicode in their names
+var globFiles = []string{
+	"app.log",
+	"app.log.1",
+	".hidden.log",
+	"notes.txt",
+	"2024/01/app.log",
+	"2024/01/db.log",
+	"2024/02/app.log",
+	"2024/02/ünïcødé.log",
+	"2024/my logs/app 1.log",
+	"2024/my logs/app 2.txt",
+	"v1.2.3/release.notes.md",
+	"deep/a/b/c/d/app.log",
+}
+
+// writeGlobFiles creates the globFiles under the root url and returns the function listing the paths relative to the
+// root of the files matched by a pattern under the root
+func writeGlobFiles(t *testing.T, m Manager, root string) func(pattern string) []string {
+	for _, name := range globFiles {
+		_, err := m.MkdirAllRaw(root + "/" + path.Dir(name))
+		assert.NoError(t, err)
+		f, err := m.CreateRaw(root + "/" + name)
+		assert.NoError(t, err)
+		_, err = f.WriteString(name)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+	prefix := mustParse(root).Path + "/"
+	return func(pattern string) (names []string) {
+		files, err := Glob(m, root+"/"+pattern)
+		assert.NoError(t, err)
+		for _, file := range files {
+			names = append(names, strings.TrimPrefix(file.Url().Path, prefix))
+			assert.NoError(t, file.Close())
+		}
+		return
+	}
+}
+
+func TestGlob(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://glob/root", "file://" + t.TempDir() + "/root"} {
+		glob := writeGlobFiles(t, m, root)
+		assert.Equal(t, []string{".hidden.log", "app.log"}, glob("*.log"))
+		assert.Equal(t, []string{".hidden.log", "2024/01/app.log", "2024/01/db.log", "2024/02/app.log",
+			"2024/02/ünïcødé.log", "2024/my logs/app 1.log", "app.log", "deep/a/b/c/d/app.log"}, glob("**/*.log"))
+		assert.Equal(t, []string{"2024/01/app.log", "2024/02/app.log"}, glob("2024/*/app.log"))
+		assert.Equal(t, []string{"2024/01/app.log", "2024/01/db.log"}, glob("2024/0[^2]/*"))
+		assert.Equal(t, []string{"2024/02/ünïcødé.log"}, glob("2024/*/ü*"))
+		assert.Equal(t, []string{"2024/my logs/app 1.log", "2024/my logs/app 2.txt"}, glob("2024/my logs/app ?.*"))
+		assert.Equal(t, []string{"app.log.1"}, glob("app.log.?"))
+		assert.Equal(t, []string{"v1.2.3/release.notes.md"}, glob("v1.*/*.md"))
+		assert.Equal(t, []string{"deep/a/b/c/d/app.log"}, glob("deep/**/d/*.log"))
+		assert.Equal(t, []string{"2024/01", "2024/02"}, glob("2024/0?"))
+		// the \ escapes a wildcard
+		assert.Equal(t, []string(nil), glob(`app\*`))
+		// without wildcards the file itself, if it exists
+		assert.Equal(t, []string{"notes.txt"}, glob("notes.txt"))
+		assert.Equal(t, []string(nil), glob("missing.txt"))
+		assert.Equal(t, []string(nil), glob("missing/**/*.log"))
+	}
+}
+
+func TestGlob_BadPattern(t *testing.T) {
+	// the pattern is checked before the file system is resolved
+	for _, pattern := range []string{"unknown://bucket/[a-/*.log", "mem://bucket/a**/*.log", "mem://bucket/**/\\"} {
+		files, err := Glob(newTreeManager(), pattern)
+		assert.True(t, errors.Is(err, path.ErrBadPattern))
+		assert.Len(t, files, 0)
+	}
+}
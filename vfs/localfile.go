@@ -1,6 +1,7 @@
 package vfs
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"net/url"
@@ -17,6 +18,16 @@ type OsFile struct {
 	fs       VFileSystem
 }
 
+func newOsFile(f *os.File, u *url.URL, fs VFileSystem) *OsFile {
+	file := &OsFile{
+		file:     f,
+		Location: u,
+		fs:       fs,
+	}
+	file.BaseFile = &BaseFile{VFile: file}
+	return file
+}
+
 func (o *OsFile) Close() error {
 	return o.file.Close()
 }
@@ -37,28 +48,41 @@ func (o *OsFile) ContentType() string {
 	return fsutils.LookupContentType(o.Location.Path)
 }
 
+// ListAll returns the files and directories directly under the directory sorted by name, nil for a file.
 func (o *OsFile) ListAll() (files []VFile, err error) {
-	manager := GetManager()
-	var children []VFile
-	err = filepath.WalkDir(o.Location.Path, visit(manager, &children))
-	if err == nil {
-		files = children
+	var info os.FileInfo
+	if info, err = o.file.Stat(); err != nil || !info.IsDir() {
+		return
+	}
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(o.Location.Path); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		u := *o.Location
+		u.Path = filepath.Join(o.Location.Path, entry.Name())
+		var f *os.File
+		if f, err = os.Open(u.Path); err != nil {
+			return
+		}
+		files = append(files, newOsFile(f, &u, o.fs))
 	}
 	return
 }
 
-func visit(manager Manager, paths *[]VFile) func(string, os.DirEntry, error) (err error) {
-	return func(path string, info os.DirEntry, err2 error) (err error) {
-		if err2 != nil {
-			return
-		}
-		if !info.IsDir() {
-			var child VFile
-			child, err = manager.OpenRaw(path)
-			*paths = append(*paths, child)
+// Watch watches the file by polling, without any native notification. The file is reopened on each poll to detect
+// its deletion, the modified files are found with their modification time and size or WithContentHash.
+func (o *OsFile) Watch(ctx context.Context, opts ...WatchOption) (<-chan Event, error) {
+	cfg := newWatchConfig(opts)
+	return pollWatch(ctx, cfg, func() (states map[string]*watchState, err error) {
+		var f *os.File
+		if f, err = os.Open(o.Location.Path); err == nil {
+			file := newOsFile(f, o.Location, o.fs)
+			defer file.Close()
+			states, err = snapshotFile(file, cfg)
 		}
 		return
-	}
+	})
 }
 
 func (o *OsFile) Delete() error {
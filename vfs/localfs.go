@@ -20,11 +20,7 @@ func (o OsFs) Create(u *url.URL) (file VFile, err error) {
 	var f *os.File
 	f, err = os.Create(u.Path)
 	if err == nil {
-		file = &OsFile{
-			file:     f,
-			Location: u,
-			fs:       o,
-		}
+		file = newOsFile(f, u, o)
 	}
 	return
 }
@@ -45,15 +41,43 @@ func (o OsFs) MkdirAll(u *url.URL) (file VFile, err error) {
 	return
 }
 
+// Move renames the src to dst. If the rename fails, e.g. when the urls are on different devices, the src is
+// copied to dst and then deleted.
+func (o OsFs) Move(src, dst *url.URL) (err error) {
+	if dst.Scheme == fileScheme || dst.Scheme == emptyScheme {
+		if err = os.Rename(src.Path, dst.Path); err == nil {
+			return
+		}
+	}
+	err = o.BaseVFS.Move(src, dst)
+	return
+}
+
+// MoveAtomic renames the src file to dst with os.Rename, which replaces dst atomically within a file system.
+func (o OsFs) MoveAtomic(src, dst *url.URL) (err error) {
+	if dst.Scheme != fileScheme && dst.Scheme != emptyScheme {
+		return ErrNotAtomic
+	}
+	return os.Rename(src.Path, dst.Path)
+}
+
+func (o OsFs) MoveRaw(src, dst string) (err error) {
+	var srcUrl, dstUrl *url.URL
+	srcUrl, err = url.Parse(src)
+	if err == nil {
+		dstUrl, err = url.Parse(dst)
+		if err == nil {
+			err = o.Move(srcUrl, dstUrl)
+		}
+	}
+	return
+}
+
 func (o OsFs) Open(u *url.URL) (file VFile, err error) {
 	var f *os.File
 	f, err = os.Open(u.Path)
 	if err == nil {
-		file = &OsFile{
-			file:     f,
-			Location: u,
-			fs:       o,
-		}
+		file = newOsFile(f, u, o)
 	}
 	return
 }
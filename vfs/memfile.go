@@ -0,0 +1,300 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"oss.nandlabs.io/golly/fsutils"
+)
+
+// MemFile is a file of the MemFs. Every MemFile has its own offset for reading and writing.
+type MemFile struct {
+	*BaseFile
+	fs       *MemFs
+	key      string
+	offset   int64
+	Location *url.URL
+}
+
+func (m *MemFile) Close() error {
+	return nil
+}
+
+// node returns the node of the file. The caller must hold the mutex of the MemFs.
+func (m *MemFile) node(op string) (node *memNode, err error) {
+	var ok bool
+	node, ok = m.fs.nodes[m.key]
+	if !ok {
+		err = &fs.PathError{Op: op, Path: m.key, Err: fs.ErrNotExist}
+	} else if node.dir && (op == "read" || op == "write") {
+		err = &fs.PathError{Op: op, Path: m.key, Err: errMemIsDir}
+	}
+	return
+}
+
+func (m *MemFile) Read(b []byte) (n int, err error) {
+	m.fs.mutex.RLock()
+	defer m.fs.mutex.RUnlock()
+	var node *memNode
+	node, err = m.node("read")
+	if err == nil {
+		if m.offset >= int64(len(node.data)) {
+			err = io.EOF
+			return
+		}
+		n = copy(b, node.data[m.offset:])
+		m.offset += int64(n)
+	}
+	return
+}
+
+func (m *MemFile) Write(b []byte) (n int, err error) {
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+	var node *memNode
+	node, err = m.node("write")
+	if err == nil {
+		end := m.offset + int64(len(b))
+		if end > int64(len(node.data)) {
+			data := make([]byte, end)
+			copy(data, node.data)
+			node.data = data
+		}
+		n = copy(node.data[m.offset:], b)
+		m.offset += int64(n)
+		node.modTime = time.Now()
+		if n > 0 {
+			m.fs.notify(EventModify, m.key, "")
+		}
+	}
+	return
+}
+
+func (m *MemFile) Seek(offset int64, whence int) (abs int64, err error) {
+	m.fs.mutex.RLock()
+	defer m.fs.mutex.RUnlock()
+	var node *memNode
+	node, err = m.node("seek")
+	if err != nil {
+		return
+	}
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = m.offset + offset
+	case io.SeekEnd:
+		abs = int64(len(node.data)) + offset
+	default:
+		err = errors.New("invalid whence")
+		return
+	}
+	if abs < 0 {
+		err = errors.New("negative position")
+		return
+	}
+	m.offset = abs
+	return
+}
+
+func (m *MemFile) ContentType() string {
+	return fsutils.LookupContentType(m.key)
+}
+
+// ListAll returns the files and directories directly under the directory sorted by name.
+func (m *MemFile) ListAll() (files []VFile, err error) {
+	m.fs.mutex.RLock()
+	defer m.fs.mutex.RUnlock()
+	var node *memNode
+	node, err = m.node("list")
+	if err != nil || !node.dir {
+		return
+	}
+	var keys []string
+	for key := range m.fs.nodes {
+		if key != m.key && path.Dir(key) == m.key {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		files = append(files, m.fs.newFile(key, memUrl(key)))
+	}
+	return
+}
+
+// Watch sends the changes of the file as they are made, the options of the polling are ignored. The events of a
+// file moved within the MemFs are reported with EventRename to the watchers of either location.
+func (m *MemFile) Watch(ctx context.Context, opts ...WatchOption) (<-chan Event, error) {
+	cfg := newWatchConfig(opts)
+	w := &memWatcher{key: m.key, recursive: cfg.recursive, signal: make(chan struct{}, 1)}
+	m.fs.mutex.Lock()
+	if _, err := m.node("watch"); err != nil {
+		m.fs.mutex.Unlock()
+		return nil, err
+	}
+	if m.fs.watchers == nil {
+		m.fs.watchers = make(map[*memWatcher]struct{})
+	}
+	m.fs.watchers[w] = struct{}{}
+	m.fs.mutex.Unlock()
+
+	events := make(chan Event, cfg.buffer)
+	go func() {
+		defer close(events)
+		defer func() {
+			m.fs.mutex.Lock()
+			delete(m.fs.watchers, w)
+			m.fs.mutex.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.signal:
+			}
+			for _, event := range w.take() {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Delete removes the file or the empty directory.
+func (m *MemFile) Delete() (err error) {
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+	var node *memNode
+	node, err = m.node("remove")
+	if err == nil && node.dir {
+		for key := range m.fs.nodes {
+			if strings.HasPrefix(key, m.key+"/") || (m.key == "/" && key != "/") {
+				err = &fs.PathError{Op: "remove", Path: m.key, Err: errMemNotEmpty}
+				return
+			}
+		}
+	}
+	if err == nil && m.key != "/" {
+		delete(m.fs.nodes, m.key)
+		m.fs.notify(EventDelete, m.key, "")
+	}
+	return
+}
+
+// DeleteAll removes the file or the directory along with all its children.
+func (m *MemFile) DeleteAll() (err error) {
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+	prefix := strings.TrimSuffix(m.key, "/") + "/"
+	var deleted []string
+	for key := range m.fs.nodes {
+		if key != "/" && (key == m.key || strings.HasPrefix(key, prefix)) {
+			delete(m.fs.nodes, key)
+			deleted = append(deleted, key)
+		}
+	}
+	// the children before their directory
+	sort.Sort(sort.Reverse(sort.StringSlice(deleted)))
+	for _, key := range deleted {
+		m.fs.notify(EventDelete, key, "")
+	}
+	return
+}
+
+func (m *MemFile) Info() (info VFileInfo, err error) {
+	m.fs.mutex.RLock()
+	defer m.fs.mutex.RUnlock()
+	var node *memNode
+	node, err = m.node("stat")
+	if err == nil {
+		info = &memFileInfo{
+			name:    path.Base(m.key),
+			size:    int64(len(node.data)),
+			dir:     node.dir,
+			modTime: node.modTime,
+		}
+	}
+	return
+}
+
+func (m *MemFile) Parent() (file VFile, err error) {
+	key := path.Dir(m.key)
+	file, err = m.fs.Open(memUrl(key))
+	return
+}
+
+func (m *MemFile) Url() *url.URL {
+	return m.Location
+}
+
+func (m *MemFile) AddProperty(name string, value string) (err error) {
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+	var node *memNode
+	node, err = m.node("property")
+	if err == nil {
+		if node.properties == nil {
+			node.properties = make(map[string]string)
+		}
+		node.properties[name] = value
+	}
+	return
+}
+
+func (m *MemFile) GetProperty(name string) (v string, err error) {
+	m.fs.mutex.RLock()
+	defer m.fs.mutex.RUnlock()
+	var node *memNode
+	node, err = m.node("property")
+	if err == nil {
+		v = node.properties[name]
+	}
+	return
+}
+
+// memFileInfo is the fs.FileInfo of a MemFile
+type memFileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string {
+	return i.name
+}
+
+func (i *memFileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i *memFileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *memFileInfo) IsDir() bool {
+	return i.dir
+}
+
+func (i *memFileInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,288 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MemScheme = "mem"
+)
+
+var memFsSchemes = []string{MemScheme}
+
+var errMemIsDir = errors.New("is a directory")
+var errMemNotDir = errors.New("not a directory")
+var errMemNotEmpty = errors.New("directory not empty")
+
+// MemFs is an in memory file system for the mem scheme. The host and the path of the url together locate
+// the file, i.e. mem://bucket/a/b.txt is the file b.txt in the directory /bucket/a.
+// MemFs is not registered with the default manager, use Manager.Register to add it.
+type MemFs struct {
+	*BaseVFS
+	mutex    sync.RWMutex
+	nodes    map[string]*memNode
+	watchers map[*memWatcher]struct{}
+}
+
+// memNode is a file or a directory of the MemFs
+type memNode struct {
+	dir        bool
+	data       []byte
+	modTime    time.Time
+	properties map[string]string
+}
+
+// NewMemFs creates a new empty MemFs
+func NewMemFs() *MemFs {
+	m := &MemFs{
+		nodes: map[string]*memNode{
+			"/": {dir: true, modTime: time.Now()},
+		},
+	}
+	m.BaseVFS = &BaseVFS{VFileSystem: m}
+	return m
+}
+
+// memKey returns the key of the url within the MemFs
+func memKey(u *url.URL) string {
+	return path.Join("/", u.Host, u.Path)
+}
+
+// memUrl returns the url for the key of the MemFs
+func memUrl(key string) *url.URL {
+	host, p, _ := strings.Cut(strings.TrimPrefix(key, "/"), "/")
+	return &url.URL{
+		Scheme: MemScheme,
+		Host:   host,
+		Path:   "/" + p,
+	}
+}
+
+func (m *MemFs) Create(u *url.URL) (file VFile, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := memKey(u)
+	if err = m.checkParent("create", key); err != nil {
+		return
+	}
+	node, ok := m.nodes[key]
+	if ok && node.dir {
+		err = &fs.PathError{Op: "create", Path: key, Err: errMemIsDir}
+		return
+	}
+	if ok {
+		node.data = nil
+		node.modTime = time.Now()
+		m.notify(EventModify, key, "")
+	} else {
+		m.nodes[key] = &memNode{modTime: time.Now()}
+		m.notify(EventCreate, key, "")
+	}
+	file = m.newFile(key, u)
+	return
+}
+
+func (m *MemFs) Mkdir(u *url.URL) (file VFile, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := memKey(u)
+	if err = m.checkParent("mkdir", key); err != nil {
+		return
+	}
+	if _, ok := m.nodes[key]; ok {
+		err = &fs.PathError{Op: "mkdir", Path: key, Err: fs.ErrExist}
+		return
+	}
+	m.nodes[key] = &memNode{dir: true, modTime: time.Now()}
+	m.notify(EventCreate, key, "")
+	file = m.newFile(key, u)
+	return
+}
+
+func (m *MemFs) MkdirAll(u *url.URL) (file VFile, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := memKey(u)
+	current := "/"
+	for _, name := range strings.Split(strings.TrimPrefix(key, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name)
+		node, ok := m.nodes[current]
+		if !ok {
+			m.nodes[current] = &memNode{dir: true, modTime: time.Now()}
+			m.notify(EventCreate, current, "")
+		} else if !node.dir {
+			err = &fs.PathError{Op: "mkdir", Path: current, Err: errMemNotDir}
+			return
+		}
+	}
+	file = m.newFile(key, u)
+	return
+}
+
+// Move renames the src to dst. The rename is atomic when both the urls are of the MemFs, an existing
+// file at dst is replaced.
+func (m *MemFs) Move(src, dst *url.URL) (err error) {
+	if dst.Scheme != MemScheme {
+		err = m.BaseVFS.Move(src, dst)
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	srcKey, dstKey := memKey(src), memKey(dst)
+	srcNode, ok := m.nodes[srcKey]
+	if !ok {
+		err = &fs.PathError{Op: "move", Path: srcKey, Err: fs.ErrNotExist}
+		return
+	}
+	if err = m.checkParent("move", dstKey); err != nil {
+		return
+	}
+	if srcKey == dstKey {
+		return
+	}
+	if srcKey == "/" || strings.HasPrefix(dstKey, srcKey+"/") {
+		err = &fs.PathError{Op: "move", Path: dstKey, Err: fs.ErrInvalid}
+		return
+	}
+	if dstNode, exists := m.nodes[dstKey]; exists && (dstNode.dir || srcNode.dir) {
+		err = &fs.PathError{Op: "move", Path: dstKey, Err: fs.ErrExist}
+		return
+	}
+	moved := []string{srcKey}
+	for key := range m.nodes {
+		if strings.HasPrefix(key, srcKey+"/") {
+			moved = append(moved, key)
+		}
+	}
+	sort.Strings(moved)
+	for _, key := range moved {
+		node := m.nodes[key]
+		delete(m.nodes, key)
+		m.nodes[dstKey+strings.TrimPrefix(key, srcKey)] = node
+	}
+	for _, key := range moved {
+		m.notify(EventRename, dstKey+strings.TrimPrefix(key, srcKey), key)
+	}
+	return
+}
+
+// MoveAtomic renames the src file to dst within the MemFs, which is atomic.
+func (m *MemFs) MoveAtomic(src, dst *url.URL) (err error) {
+	if dst.Scheme != MemScheme {
+		return ErrNotAtomic
+	}
+	return m.Move(src, dst)
+}
+
+func (m *MemFs) MoveRaw(src, dst string) (err error) {
+	var srcUrl, dstUrl *url.URL
+	srcUrl, err = url.Parse(src)
+	if err == nil {
+		dstUrl, err = url.Parse(dst)
+		if err == nil {
+			err = m.Move(srcUrl, dstUrl)
+		}
+	}
+	return
+}
+
+func (m *MemFs) Open(u *url.URL) (file VFile, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	key := memKey(u)
+	if _, ok := m.nodes[key]; !ok {
+		err = &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+		return
+	}
+	file = m.newFile(key, u)
+	return
+}
+
+func (m *MemFs) Schemes() []string {
+	return memFsSchemes
+}
+
+// checkParent checks that the parent of the key is an existing directory. The caller must hold the mutex.
+func (m *MemFs) checkParent(op, key string) (err error) {
+	parent, ok := m.nodes[path.Dir(key)]
+	if !ok {
+		err = &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+	} else if !parent.dir {
+		err = &fs.PathError{Op: op, Path: key, Err: errMemNotDir}
+	}
+	return
+}
+
+func (m *MemFs) newFile(key string, u *url.URL) *MemFile {
+	f := &MemFile{
+		fs:       m,
+		key:      key,
+		Location: u,
+	}
+	f.BaseFile = &BaseFile{VFile: f}
+	return f
+}
+
+// memWatcher is a watch of a MemFile. The events are queued by the MemFs and sent on the channel of the watch by
+// its own goroutine, so that a slow receiver never blocks the MemFs.
+type memWatcher struct {
+	key       string
+	recursive bool
+	mutex     sync.Mutex
+	pending   []Event
+	signal    chan struct{}
+}
+
+// matches checks if the key is the watched file, one of its children or, when recursive, any file under it
+func (w *memWatcher) matches(key string) bool {
+	if key == w.key || path.Dir(key) == w.key {
+		return true
+	}
+	return w.recursive && strings.HasPrefix(key, strings.TrimSuffix(w.key, "/")+"/")
+}
+
+// push queues the event and wakes up the goroutine of the watch
+func (w *memWatcher) push(event Event) {
+	w.mutex.Lock()
+	w.pending = append(w.pending, event)
+	w.mutex.Unlock()
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the queued events and empties the queue
+func (w *memWatcher) take() (events []Event) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	events, w.pending = w.pending, nil
+	return
+}
+
+// notify queues the event of the key, moved from the from key with EventRename, to the watchers of either key.
+// The caller must hold the mutex.
+func (m *MemFs) notify(t EventType, key, from string) {
+	if len(m.watchers) == 0 {
+		return
+	}
+	event := Event{Url: memUrl(key), Type: t, Time: time.Now()}
+	if from != "" {
+		event.From = memUrl(from)
+	}
+	for w := range m.watchers {
+		if w.matches(key) || (from != "" && w.matches(from)) {
+			w.push(event)
+		}
+	}
+}
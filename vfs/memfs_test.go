@@ -0,0 +1,89 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestMemFs_CreateReadWrite(t *testing.T) {
+	m := NewMemFs()
+	_, err := m.CreateRaw("mem://bucket/missing/file.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	_, err = m.MkdirAllRaw("mem://bucket/dir")
+	assert.NoError(t, err)
+	f, err := m.CreateRaw("mem://bucket/dir/file.txt")
+	assert.NoError(t, err)
+	_, err = f.WriteString("hello world")
+	assert.NoError(t, err)
+	_, err = f.Seek(6, io.SeekStart)
+	assert.NoError(t, err)
+	_, err = f.WriteString("golly")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	f, err = m.OpenRaw("mem://bucket/dir/file.txt")
+	assert.NoError(t, err)
+	content, err := f.AsString()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello golly", content)
+	info, err := f.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, "file.txt", info.Name())
+	assert.Equal(t, int64(11), info.Size())
+	assert.False(t, info.IsDir())
+	assert.Equal(t, "text/plain", f.ContentType())
+	assert.NoError(t, f.AddProperty("owner", "golly"))
+	owner, err := f.GetProperty("owner")
+	assert.NoError(t, err)
+	assert.Equal(t, "golly", owner)
+
+	_, err = m.OpenRaw("mem://bucket/dir/missing.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestMemFs_ListMoveDelete(t *testing.T) {
+	m := NewMemFs()
+	_, err := m.MkdirAllRaw("mem://bucket/a/b")
+	assert.NoError(t, err)
+	for _, name := range []string{"mem://bucket/a/1.txt", "mem://bucket/a/b/2.txt"} {
+		f, err := m.CreateRaw(name)
+		assert.NoError(t, err)
+		_, err = f.WriteString(name)
+		assert.NoError(t, err)
+	}
+	dir, err := m.OpenRaw("mem://bucket/a")
+	assert.NoError(t, err)
+	children, err := dir.ListAll()
+	assert.NoError(t, err)
+	assert.Len(t, children, 2)
+	assert.Equal(t, "mem://bucket/a/1.txt", children[0].Url().String())
+	assert.Equal(t, "mem://bucket/a/b", children[1].Url().String())
+
+	var walked []string
+	assert.NoError(t, m.WalkRaw("mem://bucket/a", func(file VFile) error {
+		walked = append(walked, file.Url().String())
+		return nil
+	}))
+	assert.Equal(t, []string{"mem://bucket/a/1.txt", "mem://bucket/a/b/2.txt"}, walked)
+
+	assert.NoError(t, m.MoveRaw("mem://bucket/a", "mem://bucket/c"))
+	f, err := m.OpenRaw("mem://bucket/c/b/2.txt")
+	assert.NoError(t, err)
+	content, err := f.AsString()
+	assert.NoError(t, err)
+	assert.Equal(t, "mem://bucket/a/b/2.txt", content)
+	_, err = m.OpenRaw("mem://bucket/a")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	dir, err = m.OpenRaw("mem://bucket/c")
+	assert.NoError(t, err)
+	assert.Error(t, dir.Delete())
+	assert.NoError(t, m.DeleteRaw("mem://bucket/c"))
+	_, err = m.OpenRaw("mem://bucket/c/b/2.txt")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
@@ -0,0 +1,86 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"oss.nandlabs.io/golly/fsutils"
+)
+
+// errSearchStopped stops the walk once the search is cancelled
+var errSearchStopped = errors.New("search stopped")
+
+// Search searches the content of the files under the url of any file system registered with the manager.
+// The url can also resolve to a file. See fsutils.SearchContext for the results and the cancellation.
+// Symbolic links are not followed as the files are walked using the Walk of the file system.
+func Search(ctx context.Context, manager Manager, u *url.URL, opts fsutils.SearchOptions) (results <-chan fsutils.SearchResult, err error) {
+	var s *fsutils.Searcher
+	if s, err = fsutils.NewSearcher(opts); err != nil {
+		return
+	}
+	var root VFile
+	var rootInfo VFileInfo
+	if root, err = manager.Open(u); err != nil {
+		return
+	}
+	rootInfo, err = root.Info()
+	_ = root.Close()
+	if err != nil {
+		return
+	}
+	rootPath := strings.TrimSuffix(u.Path, "/")
+	results = s.Search(ctx, func(ctx context.Context, visit func(src fsutils.SearchSource) bool) error {
+		if !rootInfo.IsDir() {
+			visit(fsutils.SearchSource{
+				Path: u.String(),
+				Size: rootInfo.Size(),
+				Open: func() (io.ReadCloser, error) {
+					return manager.Open(u)
+				},
+			})
+			return nil
+		}
+		walkErr := manager.Walk(u, func(file VFile) (err error) {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			var info VFileInfo
+			if info, err = file.Info(); err != nil {
+				return
+			}
+			fileUrl := file.Url()
+			rel := strings.TrimPrefix(strings.TrimPrefix(fileUrl.Path, rootPath), "/")
+			if s.Excluded(rel) || s.Deep(rel) || !s.Included(rel) {
+				return
+			}
+			src := fsutils.SearchSource{
+				Path: fileUrl.String(),
+				Size: info.Size(),
+				Open: func() (io.ReadCloser, error) {
+					return manager.Open(fileUrl)
+				},
+			}
+			if !visit(src) {
+				err = errSearchStopped
+			}
+			return
+		})
+		if errors.Is(walkErr, errSearchStopped) {
+			walkErr = nil
+		}
+		return walkErr
+	})
+	return
+}
+
+// SearchRaw is same as Search except that it accepts the url as a string
+func SearchRaw(ctx context.Context, manager Manager, raw string, opts fsutils.SearchOptions) (results <-chan fsutils.SearchResult, err error) {
+	var u *url.URL
+	if u, err = url.Parse(raw); err == nil {
+		results, err = Search(ctx, manager, u, opts)
+	}
+	return
+}
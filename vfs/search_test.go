@@ -0,0 +1,59 @@
+package vfs
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"oss.nandlabs.io/golly/fsutils"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+func TestSearch(t *testing.T) {
+	m := NewMemFs()
+	manager := &fileSystems{}
+	manager.Register(m)
+	files := map[string]string{
+		"mem://bucket/src/a.go":      "package a\n// golly\n",
+		"mem://bucket/src/a_test.go": "golly\n",
+		"mem://bucket/docs/b.md":     "line\ngolly docs\n",
+		"mem://bucket/bin/c.bin":     "golly\x00",
+	}
+	for _, dir := range []string{"mem://bucket/src", "mem://bucket/docs", "mem://bucket/bin"} {
+		_, err := m.MkdirAllRaw(dir)
+		assert.NoError(t, err)
+	}
+	for raw, content := range files {
+		f, err := m.CreateRaw(raw)
+		assert.NoError(t, err)
+		_, err = f.WriteString(content)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	results, err := SearchRaw(context.Background(), manager, "mem://bucket", fsutils.SearchOptions{
+		Pattern: "golly",
+		Exclude: []string{"*_test.go"},
+		Before:  1,
+	})
+	assert.NoError(t, err)
+	var matches []string
+	for result := range results {
+		assert.NoError(t, result.Err)
+		matches = append(matches, result.Path)
+		assert.Len(t, result.Before, 1)
+	}
+	sort.Strings(matches)
+	assert.ElementsMatch(t, matches, "mem://bucket/docs/b.md", "mem://bucket/src/a.go")
+
+	results, err = SearchRaw(context.Background(), manager, "mem://bucket/src/a_test.go", fsutils.SearchOptions{Pattern: "golly"})
+	assert.NoError(t, err)
+	count := 0
+	for range results {
+		count++
+	}
+	assert.Equal(t, 1, count)
+
+	_, err = SearchRaw(context.Background(), manager, "mem://bucket/missing", fsutils.SearchOptions{Pattern: "golly"})
+	assert.Error(t, err)
+}
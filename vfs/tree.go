@@ -0,0 +1,414 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"oss.nandlabs.io/golly/ioutils"
+)
+
+const defaultCopyConcurrency = 1
+
+// SkipDir is returned by a TreeWalkFn to skip the children of a directory, or the remaining files of the directory
+// of a file. It is the fs.SkipDir of the standard library.
+var SkipDir = fs.SkipDir
+
+// ErrDestinationExists is the error of the files not copied as they exist at the destination with OverwriteFail
+var ErrDestinationExists = errors.New("the destination exists")
+
+// TreeWalkFn is called by WalkTree for each file and directory with its info
+type TreeWalkFn func(file VFile, info VFileInfo) error
+
+// OverwritePolicy selects what CopyTree does with the files existing at the destination
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces the existing files
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteSkip keeps the existing files and skips their copy
+	OverwriteSkip
+	// OverwriteFail fails the copy of the existing files with ErrDestinationExists
+	OverwriteFail
+)
+
+// CopyProgress is the progress of a CopyTree reported after each file
+type CopyProgress struct {
+	// Source is the url of the file
+	Source *url.URL
+	// Destination is the url the file is copied to
+	Destination *url.URL
+	// Size is the number of bytes copied for the file
+	Size int64
+	// Skipped is true if the file existed at the destination with OverwriteSkip
+	Skipped bool
+	// Err is the error of the copy of the file
+	Err error
+	// Files is the number of files processed so far, including this one
+	Files int
+	// Bytes is the number of bytes copied so far
+	Bytes int64
+}
+
+// CopyFailure is a file that could not be copied
+type CopyFailure struct {
+	// Source is the url of the file
+	Source *url.URL
+	// Err is the error of the copy
+	Err error
+}
+
+// CopyError reports the files that could not be copied by a CopyTree with WithContinueOnError
+type CopyError struct {
+	Failures []CopyFailure
+}
+
+func (e *CopyError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", f.Source, f.Err))
+	}
+	return fmt.Sprintf("%d files not copied: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+func (e *CopyError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+// CopyOption configures CopyTree and MoveTree
+type CopyOption func(cfg *copyConfig)
+
+// copyConfig holds the configuration of a copy
+type copyConfig struct {
+	overwrite       OverwritePolicy
+	concurrency     int
+	continueOnError bool
+	onProgress      func(progress CopyProgress)
+}
+
+// WithOverwrite sets the policy of the files existing at the destination. Defaults to OverwriteAlways.
+func WithOverwrite(policy OverwritePolicy) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.overwrite = policy
+	}
+}
+
+// WithCopyConcurrency sets the number of files copied at a time. Defaults to 1.
+func WithCopyConcurrency(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		if n > 0 {
+			cfg.concurrency = n
+		}
+	}
+}
+
+// WithContinueOnError copies the remaining files when a file fails instead of aborting. The failures are returned
+// together in a CopyError.
+func WithContinueOnError() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.continueOnError = true
+	}
+}
+
+// WithCopyProgress sets a callback invoked each time a file is processed, copied, skipped or failed. It is called
+// from the goroutines of the copy, one call at a time.
+func WithCopyProgress(fn func(progress CopyProgress)) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.onProgress = fn
+	}
+}
+
+// WalkTree walks the tree rooted at the url on any file system registered with the manager. Unlike Walk, fn is
+// called for the root and for every directory as well as for the files, each directory before its children and the
+// children of a directory in the order of their names, so the walk is deterministic.
+//
+// If fn returns SkipDir for a directory its children are skipped, and for a file the remaining files of its
+// directory are skipped. Any other error stops the walk and is returned.
+func WalkTree(manager Manager, u *url.URL, fn TreeWalkFn) (err error) {
+	var root VFile
+	if root, err = manager.Open(u); err != nil {
+		return
+	}
+	defer ioutils.CloserFunc(root)
+	if err = walkTree(root, fn); errors.Is(err, SkipDir) {
+		err = nil
+	}
+	return
+}
+
+// WalkTreeRaw is same as WalkTree except that it accepts the url as a string
+func WalkTreeRaw(manager Manager, raw string, fn TreeWalkFn) (err error) {
+	var u *url.URL
+	if u, err = url.Parse(raw); err == nil {
+		err = WalkTree(manager, u, fn)
+	}
+	return
+}
+
+// walkTree calls fn for the file and walks its children if it is a directory
+func walkTree(file VFile, fn TreeWalkFn) (err error) {
+	var info VFileInfo
+	if info, err = file.Info(); err != nil {
+		return
+	}
+	if err = fn(file, info); err != nil || !info.IsDir() {
+		if errors.Is(err, SkipDir) && info.IsDir() {
+			err = nil
+		}
+		return
+	}
+	var children []VFile
+	if children, err = file.ListAll(); err != nil {
+		return
+	}
+	sortFiles(children)
+	defer func() {
+		for _, child := range children {
+			ioutils.CloserFunc(child)
+		}
+	}()
+	for _, child := range children {
+		if err = walkTree(child, fn); err != nil {
+			if errors.Is(err, SkipDir) {
+				err = nil
+				break
+			}
+			return
+		}
+	}
+	return
+}
+
+// sortFiles sorts the files by the path of their url
+func sortFiles(files []VFile) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Url().Path < files[j].Url().Path })
+}
+
+// CopyTree copies the file or the directory tree at src to dst, on any file systems registered with the manager, by
+// streaming the content of each file. The directories are created at the destination as they are walked and the
+// files are copied by WithCopyConcurrency goroutines.
+//
+// By default the copy aborts on the first error: no other file is started, the files in flight complete and the
+// error is returned. The files already copied are left at the destination. With WithContinueOnError every file is
+// attempted and the failures are returned in a CopyError. Copying a directory into itself fails with fs.ErrInvalid.
+func CopyTree(manager Manager, src, dst *url.URL, opts ...CopyOption) (err error) {
+	cfg := &copyConfig{concurrency: defaultCopyConcurrency}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if within(dst, src) {
+		return &fs.PathError{Op: "copy", Path: dst.String(), Err: fs.ErrInvalid}
+	}
+	c := &treeCopy{manager: manager, cfg: cfg, jobs: make(chan [2]*url.URL)}
+	for i := 0; i < cfg.concurrency; i++ {
+		c.wg.Add(1)
+		go c.worker()
+	}
+	srcPath := strings.TrimSuffix(src.Path, "/")
+	walkErr := WalkTree(manager, src, func(file VFile, info VFileInfo) (err error) {
+		if c.aborted() {
+			return errCopyAborted
+		}
+		target := *dst
+		target.Path = strings.TrimSuffix(dst.Path, "/") + strings.TrimPrefix(file.Url().Path, srcPath)
+		if info.IsDir() {
+			var dir VFile
+			if dir, err = manager.MkdirAll(&target); err == nil {
+				ioutils.CloserFunc(dir)
+			} else if c.fail(file.Url(), err) {
+				// the children of a directory that could not be created are not attempted
+				err = SkipDir
+			} else {
+				err = errCopyAborted
+			}
+			return
+		}
+		if file.Url().Path == srcPath {
+			// a single file is copied to dst, whose directory is created if missing
+			parent := target
+			parent.Path = path.Dir(target.Path)
+			var dir VFile
+			if dir, err = manager.MkdirAll(&parent); err != nil {
+				c.fail(file.Url(), err)
+				return errCopyAborted
+			}
+			ioutils.CloserFunc(dir)
+		}
+		source := *file.Url()
+		c.jobs <- [2]*url.URL{&source, &target}
+		return
+	})
+	close(c.jobs)
+	c.wg.Wait()
+	if walkErr != nil && !errors.Is(walkErr, errCopyAborted) && !errors.Is(walkErr, SkipDir) {
+		c.fail(src, walkErr)
+	}
+	return c.err()
+}
+
+// CopyTreeRaw is same as CopyTree except that it accepts the urls as strings
+func CopyTreeRaw(manager Manager, src, dst string, opts ...CopyOption) (err error) {
+	var srcUrl, dstUrl *url.URL
+	if srcUrl, err = url.Parse(src); err == nil {
+		if dstUrl, err = url.Parse(dst); err == nil {
+			err = CopyTree(manager, srcUrl, dstUrl, opts...)
+		}
+	}
+	return
+}
+
+// MoveTree moves the file or the directory tree at src to dst. When both the urls have the same scheme the move is a
+// rename by the Move of the file system. Otherwise the tree is copied with CopyTree and src is deleted only if every
+// file was copied: after a partial failure src is left untouched and the files copied remain at the destination.
+//
+// The OverwriteSkip and OverwriteFail policies apply to the rename as a whole: an existing dst is kept, respectively
+// fails the move with ErrDestinationExists.
+func MoveTree(manager Manager, src, dst *url.URL, opts ...CopyOption) (err error) {
+	if src.Scheme != dst.Scheme {
+		if err = CopyTree(manager, src, dst, opts...); err == nil {
+			err = manager.Delete(src)
+		}
+		return
+	}
+	cfg := &copyConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.overwrite != OverwriteAlways {
+		var existing VFile
+		if existing, err = manager.Open(dst); err == nil {
+			ioutils.CloserFunc(existing)
+			if cfg.overwrite == OverwriteFail {
+				err = &fs.PathError{Op: "move", Path: dst.String(), Err: ErrDestinationExists}
+			}
+			return
+		}
+	}
+	err = manager.Move(src, dst)
+	return
+}
+
+// MoveTreeRaw is same as MoveTree except that it accepts the urls as strings
+func MoveTreeRaw(manager Manager, src, dst string, opts ...CopyOption) (err error) {
+	var srcUrl, dstUrl *url.URL
+	if srcUrl, err = url.Parse(src); err == nil {
+		if dstUrl, err = url.Parse(dst); err == nil {
+			err = MoveTree(manager, srcUrl, dstUrl, opts...)
+		}
+	}
+	return
+}
+
+// errCopyAborted stops the walk of a copy after a failure
+var errCopyAborted = errors.New("copy aborted")
+
+// treeCopy is a CopyTree in progress
+type treeCopy struct {
+	manager  Manager
+	cfg      *copyConfig
+	jobs     chan [2]*url.URL
+	wg       sync.WaitGroup
+	mutex    sync.Mutex
+	failures []CopyFailure
+	files    int
+	bytes    int64
+}
+
+// worker copies the files of the jobs
+func (c *treeCopy) worker() {
+	defer c.wg.Done()
+	for job := range c.jobs {
+		if c.aborted() {
+			continue
+		}
+		size, skipped, err := c.copyFile(job[0], job[1])
+		c.mutex.Lock()
+		c.files++
+		c.bytes += size
+		if err != nil {
+			c.failures = append(c.failures, CopyFailure{Source: job[0], Err: err})
+		}
+		if c.cfg.onProgress != nil {
+			c.cfg.onProgress(CopyProgress{Source: job[0], Destination: job[1], Size: size, Skipped: skipped,
+				Err: err, Files: c.files, Bytes: c.bytes})
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// copyFile streams the content of the src file to dst according to the overwrite policy
+func (c *treeCopy) copyFile(src, dst *url.URL) (size int64, skipped bool, err error) {
+	if c.cfg.overwrite != OverwriteAlways {
+		var existing VFile
+		if existing, err = c.manager.Open(dst); err == nil {
+			ioutils.CloserFunc(existing)
+			if c.cfg.overwrite == OverwriteFail {
+				err = &fs.PathError{Op: "copy", Path: dst.String(), Err: ErrDestinationExists}
+			}
+			skipped = err == nil
+			return
+		}
+		err = nil
+	}
+	var srcFile, dstFile VFile
+	if srcFile, err = c.manager.Open(src); err != nil {
+		return
+	}
+	defer ioutils.CloserFunc(srcFile)
+	if dstFile, err = c.manager.Create(dst); err != nil {
+		return
+	}
+	size, err = io.Copy(dstFile, srcFile)
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// fail records the failure and returns true if the copy continues
+func (c *treeCopy) fail(u *url.URL, err error) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures = append(c.failures, CopyFailure{Source: u, Err: err})
+	return c.cfg.continueOnError
+}
+
+// aborted checks if the copy stops on a failure
+func (c *treeCopy) aborted() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return !c.cfg.continueOnError && len(c.failures) > 0
+}
+
+// err returns the first failure when the copy aborts and all of them otherwise
+func (c *treeCopy) err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch {
+	case len(c.failures) == 0:
+		return nil
+	case !c.cfg.continueOnError:
+		return c.failures[0].Err
+	default:
+		return &CopyError{Failures: c.failures}
+	}
+}
+
+// within checks if the url u is the url parent or below it on the same file system
+func within(u, parent *url.URL) bool {
+	if u.Scheme != parent.Scheme || u.Host != parent.Host {
+		return false
+	}
+	p, base := path.Clean("/"+u.Path), path.Clean("/"+parent.Path)
+	return p == base || strings.HasPrefix(p, strings.TrimSuffix(base, "/")+"/")
+}
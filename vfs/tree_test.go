@@ -0,0 +1,222 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// treeFiles are the files of the trees of the tests, by path relative to the root
+var treeFiles = map[string]string{
+	"a.txt":         "a",
+	"b/c.txt":       "bc",
+	"b/d/e.txt":     "bde",
+	"b/d/f.txt":     "bdf",
+	"g/h/i/j.txt":   "ghij",
+	"k/empty/.keep": "",
+}
+
+// newTreeManager creates a manager with the local and an in memory file system
+func newTreeManager() Manager {
+	m := &fileSystems{}
+	m.Register(newOsFs())
+	m.Register(NewMemFs())
+	return m
+}
+
+// writeTree creates the treeFiles under the root url
+func writeTree(t *testing.T, m Manager, root string) {
+	for name, content := range treeFiles {
+		_, err := m.MkdirAllRaw(root + "/" + filepath.Dir(name))
+		assert.NoError(t, err)
+		f, err := m.CreateRaw(root + "/" + name)
+		assert.NoError(t, err)
+		_, err = f.WriteString(content)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+}
+
+// readTree returns the content of the files under the root url by path relative to the root
+func readTree(t *testing.T, m Manager, root string) map[string]string {
+	files := map[string]string{}
+	rootPath := strings.TrimSuffix(mustParse(root).Path, "/")
+	assert.NoError(t, WalkTreeRaw(m, root, func(file VFile, info VFileInfo) (err error) {
+		if !info.IsDir() {
+			files[strings.TrimPrefix(file.Url().Path, rootPath+"/")], err = file.AsString()
+		}
+		return
+	}))
+	return files
+}
+
+func mustParse(raw string) *url.URL {
+	u, _ := url.Parse(raw)
+	return u
+}
+
+func TestWalkTree(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://walk/root", "file://" + t.TempDir() + "/root"} {
+		writeTree(t, m, root)
+		prefix := mustParse(root).Path
+		visit := func(skip map[string]error) (visited []string, err error) {
+			err = WalkTreeRaw(m, root, func(file VFile, info VFileInfo) error {
+				rel := strings.TrimPrefix(file.Url().Path, prefix)
+				if info.IsDir() {
+					rel += "/"
+				}
+				visited = append(visited, rel)
+				return skip[rel]
+			})
+			return
+		}
+
+		visited, err := visit(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a.txt", "/b/", "/b/c.txt", "/b/d/", "/b/d/e.txt", "/b/d/f.txt", "/g/",
+			"/g/h/", "/g/h/i/", "/g/h/i/j.txt", "/k/", "/k/empty/", "/k/empty/.keep"}, visited)
+
+		// SkipDir skips the children of a directory and the remaining files of the directory of a file
+		visited, err = visit(map[string]error{"/b/d/": SkipDir, "/g/h/i/j.txt": SkipDir, "/k/empty/.keep": SkipDir})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a.txt", "/b/", "/b/c.txt", "/b/d/", "/g/", "/g/h/", "/g/h/i/",
+			"/g/h/i/j.txt", "/k/", "/k/empty/", "/k/empty/.keep"}, visited)
+
+		stop := errors.New("stop")
+		visited, err = visit(map[string]error{"/b/c.txt": stop})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, []string{"/", "/a.txt", "/b/", "/b/c.txt"}, visited)
+	}
+	err := WalkTreeRaw(m, "mem://walk/missing", func(file VFile, info VFileInfo) error { return nil })
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestCopyTree(t *testing.T) {
+	m := newTreeManager()
+	local := "file://" + t.TempDir()
+	writeTree(t, m, local+"/src")
+
+	// from file to mem and back, concurrently
+	var mutex sync.Mutex
+	var progress []CopyProgress
+	err := CopyTreeRaw(m, local+"/src", "mem://copy/dst", WithCopyConcurrency(4),
+		WithCopyProgress(func(p CopyProgress) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			progress = append(progress, p)
+		}))
+	assert.NoError(t, err)
+	assert.Equal(t, treeFiles, readTree(t, m, "mem://copy/dst"))
+	assert.Len(t, progress, len(treeFiles))
+	last := progress[len(progress)-1]
+	assert.Equal(t, len(treeFiles), last.Files)
+	assert.Equal(t, int64(13), last.Bytes)
+
+	assert.NoError(t, CopyTreeRaw(m, "mem://copy/dst", local+"/back"))
+	assert.Equal(t, treeFiles, readTree(t, m, local+"/back"))
+	// the empty directories are copied as well
+	info, err := os.Stat(filepath.Join(mustParse(local).Path, "back", "k", "empty"))
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	// a single file, into a missing directory
+	assert.NoError(t, CopyTreeRaw(m, local+"/src/b/d/e.txt", "mem://copy/single/e.txt"))
+	assert.Equal(t, map[string]string{"e.txt": "bde"}, readTree(t, m, "mem://copy/single"))
+
+	err = CopyTreeRaw(m, "mem://copy/dst", "mem://copy/dst/b/nested")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+}
+
+func TestCopyTree_Overwrite(t *testing.T) {
+	m := newTreeManager()
+	writeTree(t, m, "mem://overwrite/src")
+	existing := func(dst string) {
+		_, err := m.MkdirAllRaw(dst + "/b")
+		assert.NoError(t, err)
+		f, err := m.CreateRaw(dst + "/b/c.txt")
+		assert.NoError(t, err)
+		_, _ = f.WriteString("existing")
+		assert.NoError(t, f.Close())
+	}
+
+	existing("mem://overwrite/always")
+	assert.NoError(t, CopyTreeRaw(m, "mem://overwrite/src", "mem://overwrite/always"))
+	assert.Equal(t, treeFiles, readTree(t, m, "mem://overwrite/always"))
+
+	existing("mem://overwrite/skip")
+	skipped := 0
+	assert.NoError(t, CopyTreeRaw(m, "mem://overwrite/src", "mem://overwrite/skip", WithOverwrite(OverwriteSkip),
+		WithCopyProgress(func(p CopyProgress) {
+			if p.Skipped {
+				skipped++
+			}
+		})))
+	files := readTree(t, m, "mem://overwrite/skip")
+	assert.Equal(t, "existing", files["b/c.txt"])
+	assert.Equal(t, "bde", files["b/d/e.txt"])
+	assert.Equal(t, 1, skipped)
+}
+
+func TestCopyTree_PartialFailure(t *testing.T) {
+	m := newTreeManager()
+	writeTree(t, m, "mem://partial/src")
+	for _, dst := range []string{"mem://partial/abort/b", "mem://partial/continue/b"} {
+		_, err := m.MkdirAllRaw(dst)
+		assert.NoError(t, err)
+		f, err := m.CreateRaw(dst + "/c.txt")
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	// the copy stops at the first failure, the files copied before it remain
+	err := CopyTreeRaw(m, "mem://partial/src", "mem://partial/abort", WithOverwrite(OverwriteFail))
+	assert.True(t, errors.Is(err, ErrDestinationExists))
+	assert.Equal(t, map[string]string{"a.txt": "a", "b/c.txt": ""}, readTree(t, m, "mem://partial/abort"))
+
+	// every other file is copied and the failures are reported together
+	err = CopyTreeRaw(m, "mem://partial/src", "mem://partial/continue", WithOverwrite(OverwriteFail),
+		WithContinueOnError(), WithCopyConcurrency(2))
+	var copyErr *CopyError
+	assert.True(t, errors.As(err, &copyErr))
+	assert.Len(t, copyErr.Failures, 1)
+	assert.Equal(t, "/src/b/c.txt", copyErr.Failures[0].Source.Path)
+	assert.True(t, errors.Is(err, ErrDestinationExists))
+	files := readTree(t, m, "mem://partial/continue")
+	assert.Equal(t, len(treeFiles), len(files))
+	assert.Equal(t, "", files["b/c.txt"])
+	assert.Equal(t, "ghij", files["g/h/i/j.txt"])
+}
+
+func TestMoveTree(t *testing.T) {
+	m := newTreeManager()
+	local := "file://" + t.TempDir()
+
+	// across file systems the tree is copied then deleted
+	writeTree(t, m, local+"/src")
+	assert.NoError(t, MoveTreeRaw(m, local+"/src", "mem://move/dst"))
+	assert.Equal(t, treeFiles, readTree(t, m, "mem://move/dst"))
+	_, err := os.Stat(filepath.Join(mustParse(local).Path, "src"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// on the same file system it is renamed
+	assert.NoError(t, MoveTreeRaw(m, "mem://move/dst", "mem://move/renamed"))
+	assert.Equal(t, treeFiles, readTree(t, m, "mem://move/renamed"))
+	_, err = m.OpenRaw("mem://move/dst")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	err = MoveTreeRaw(m, "mem://move/renamed/a.txt", "mem://move/renamed/b/c.txt", WithOverwrite(OverwriteFail))
+	assert.True(t, errors.Is(err, ErrDestinationExists))
+
+	// a partial failure keeps the source
+	writeTree(t, m, local+"/kept")
+	err = MoveTreeRaw(m, local+"/kept", "mem://move/renamed", WithOverwrite(OverwriteFail), WithContinueOnError())
+	assert.Error(t, err)
+	assert.Equal(t, treeFiles, readTree(t, m, local+"/kept"))
+}
@@ -0,0 +1,687 @@
+package vfs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oss.nandlabs.io/golly/ioutils"
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/uuid"
+)
+
+const (
+	// TusResumableHeader is the header of the version of the resumable upload protocol
+	TusResumableHeader = "Tus-Resumable"
+	// UploadOffsetHeader is the header of the number of bytes of an upload received
+	UploadOffsetHeader = "Upload-Offset"
+	// UploadLengthHeader is the header of the total size of an upload
+	UploadLengthHeader = "Upload-Length"
+	// UploadMetadataHeader is the header of the metadata of an upload, comma separated keys and base64 values
+	UploadMetadataHeader = "Upload-Metadata"
+	// UploadChecksumHeader is the header of the checksum of an upload, the algorithm and the base64 digest
+	UploadChecksumHeader = "Upload-Checksum"
+	// UploadExpiresHeader is the header of the time an upload expires
+	UploadExpiresHeader = "Upload-Expires"
+	// UploadContentType is the content type of the chunks of an upload
+	UploadContentType = "application/offset+octet-stream"
+	// StatusChecksumMismatch is the status of an upload whose content does not match its checksum
+	StatusChecksumMismatch = 460
+
+	tusVersion            = "1.0.0"
+	uploadInfoFile        = "info.json"
+	uploadDataFile        = "data"
+	uploadChunkPrefix     = "chunk-"
+	uploadChecksumSha256  = "sha256"
+	defaultUploadExpiry   = 24 * time.Hour
+	defaultUploadJanitor  = 10 * time.Minute
+	uploadIdLength        = 36
+	uploadTmpSuffix       = ".tmp"
+	uploadExtensionHeader = "Tus-Extension"
+	uploadVersionHeader   = "Tus-Version"
+	uploadMaxSizeHeader   = "Tus-Max-Size"
+	uploadExtensions      = "creation,expiration,checksum,termination"
+)
+
+// ErrUploadNotFound is returned when no upload exists for the id
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrUploadExpired is returned when the upload expired
+var ErrUploadExpired = errors.New("upload expired")
+
+// ErrUploadOffset is returned when a chunk is not sent at the offset of the upload
+var ErrUploadOffset = errors.New("upload offset mismatch")
+
+// ErrUploadTooLarge is returned when an upload exceeds the max size or a chunk exceeds the length of the upload
+var ErrUploadTooLarge = errors.New("upload too large")
+
+// ErrUploadLocked is returned when a chunk is sent while another one of the upload is being written
+var ErrUploadLocked = errors.New("upload locked")
+
+// ErrUploadChecksum is returned when the content of a completed upload does not match its checksum
+var ErrUploadChecksum = errors.New("upload checksum mismatch")
+
+// UploadOptions configures an UploadHandler
+type UploadOptions struct {
+	// MaxSize is the max length of an upload. 0 does not limit the length.
+	MaxSize int64
+	// Expiry is the time after its last chunk an upload is removed. 0 selects 24 hours.
+	Expiry time.Duration
+	// JanitorInterval is the interval the expired uploads are removed at. 0 selects 10 minutes, a negative
+	// interval disables the janitor, Cleanup can be invoked instead.
+	JanitorInterval time.Duration
+	// PromoteTo is the url of the directory the completed uploads are moved to as <PromoteTo>/<id>. The upload is
+	// copied when the directory is on another file system, and the upload is then removed. The completed
+	// uploads are left at <base>/<id>/data if empty.
+	PromoteTo string
+	// OnComplete is invoked with the url of the file of every completed upload. A file left at the location of
+	// the upload is removed once the upload expires. An error fails the last chunk with 500.
+	OnComplete func(upload *Upload, file *url.URL) error
+}
+
+// Upload is the state of a resumable upload
+type Upload struct {
+	// Id identifies the upload
+	Id string `json:"id"`
+	// Length is the total size of the upload
+	Length int64 `json:"length"`
+	// Offset is the number of bytes received
+	Offset int64 `json:"offset"`
+	// Metadata of the upload declared on its creation
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Checksum is the hex encoded SHA-256 checksum declared on the creation of the upload
+	Checksum string `json:"checksum,omitempty"`
+	// Digest is the hex encoded SHA-256 checksum of the content of a completed upload
+	Digest string `json:"digest,omitempty"`
+	// Created is the time the upload was created
+	Created time.Time `json:"created"`
+	// Expires is the time the upload expires
+	Expires time.Time `json:"expires"`
+	// Completed is true once all the bytes of the upload are received
+	Completed bool `json:"completed"`
+	// Chunks are the offsets of the chunks stored
+	Chunks []int64 `json:"chunks,omitempty"`
+}
+
+// UploadHandler is a http.Handler of resumable uploads implementing the core of the tus protocol along with its
+// creation, expiration, checksum and termination extensions. It is mounted at a path, e.g. /files, and serves
+//   - POST /files creating an upload of the Upload-Length and Upload-Metadata headers, the Location of the response
+//     is the url of the upload, /files/<id>
+//   - PATCH /files/<id> appending the body at the Upload-Offset header, 409 is returned if it is not the offset of
+//     the upload and 423 if another chunk of the upload is being written
+//   - HEAD /files/<id> returning the Upload-Offset of the upload
+//   - DELETE /files/<id> removing the upload
+//
+// Every chunk is stored as a file at <base>/<id>/ along with the state of the upload, so the uploads survive a
+// restart and can be stored on any file system of the Manager. The chunks are joined once the upload completes.
+type UploadHandler struct {
+	manager Manager
+	base    *url.URL
+	promote *url.URL
+	options UploadOptions
+	chksum  ioutils.ChkSumCalc
+	mutex   sync.Mutex
+	// writing holds the ids of the uploads being written
+	writing   map[string]bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewResumableUploadHandler creates a new UploadHandler storing the uploads at the baseURL using the manager.
+// The janitor removing the expired uploads runs until the handler is closed.
+func NewResumableUploadHandler(manager Manager, baseURL string, opts UploadOptions) (handler *UploadHandler,
+	err error) {
+	var base *url.URL
+	if base, err = url.Parse(baseURL); err != nil {
+		return
+	}
+	if !manager.IsSupported(base.Scheme) {
+		err = fmt.Errorf("unsupported scheme %s for in the url %s", base.Scheme, baseURL)
+		return
+	}
+	base.Path = path.Clean("/" + base.Path)
+	var promote *url.URL
+	if opts.PromoteTo != "" {
+		if promote, err = url.Parse(opts.PromoteTo); err != nil {
+			return
+		}
+		if !manager.IsSupported(promote.Scheme) {
+			err = fmt.Errorf("unsupported scheme %s for in the url %s", promote.Scheme, opts.PromoteTo)
+			return
+		}
+		promote.Path = path.Clean("/" + promote.Path)
+	}
+	if opts.Expiry <= 0 {
+		opts.Expiry = defaultUploadExpiry
+	}
+	if opts.JanitorInterval == 0 {
+		opts.JanitorInterval = defaultUploadJanitor
+	}
+	var dir VFile
+	if dir, err = manager.MkdirAll(base); err == nil && promote != nil {
+		ioutils.CloserFunc(dir)
+		dir, err = manager.MkdirAll(promote)
+	}
+	if err != nil {
+		return
+	}
+	ioutils.CloserFunc(dir)
+	handler = &UploadHandler{
+		manager: manager,
+		base:    base,
+		promote: promote,
+		options: opts,
+		chksum:  ioutils.NewChkSumCalc(ioutils.SHA256),
+		writing: make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	if opts.JanitorInterval > 0 {
+		handler.wg.Add(1)
+		go handler.janitor()
+	}
+	return
+}
+
+// ServeHTTP serves the requests of the protocol
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(TusResumableHeader, tusVersion)
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set(uploadVersionHeader, tusVersion)
+		w.Header().Set(uploadExtensionHeader, uploadExtensions)
+		if h.options.MaxSize > 0 {
+			w.Header().Set(uploadMaxSizeHeader, strconv.FormatInt(h.options.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		h.create(w, r)
+	case http.MethodHead:
+		h.head(w, r)
+	case http.MethodPatch:
+		h.patch(w, r)
+	case http.MethodDelete:
+		h.delete(w, r)
+	default:
+		w.Header().Set("Allow", "OPTIONS, POST, HEAD, PATCH, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Get returns the state of the upload
+func (h *UploadHandler) Get(id string) (upload *Upload, err error) {
+	if !validUploadId(id) {
+		err = fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+		return
+	}
+	if upload, err = h.load(id); err == nil && time.Now().After(upload.Expires) {
+		err = fmt.Errorf("%w: %s", ErrUploadExpired, id)
+	}
+	return
+}
+
+// Remove removes the upload and its chunks. ErrUploadLocked is returned if a chunk of the upload is being written.
+func (h *UploadHandler) Remove(id string) (err error) {
+	if !validUploadId(id) {
+		return fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	if !h.lock(id) {
+		return fmt.Errorf("%w: %s", ErrUploadLocked, id)
+	}
+	defer h.unlock(id)
+	if _, err = h.load(id); err == nil {
+		err = h.manager.Delete(h.base.JoinPath(id))
+	}
+	return
+}
+
+// Cleanup removes the expired uploads and returns their ids. The uploads whose chunks are being written are
+// skipped.
+func (h *UploadHandler) Cleanup(ctx context.Context) (removed []string, err error) {
+	var files []VFile
+	if files, err = h.manager.List(h.base); err != nil {
+		return
+	}
+	// the files listed are either the directories of the uploads or the files in them
+	var ids []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		rel := strings.TrimPrefix(path.Clean(file.Url().Path), h.base.Path+"/")
+		if id, _, _ := strings.Cut(rel, "/"); !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+		ioutils.CloserFunc(file)
+	}
+	now := time.Now()
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if !validUploadId(id) || !h.lock(id) {
+			continue
+		}
+		if h.expired(id, now) {
+			if err = h.manager.Delete(h.base.JoinPath(id)); err == nil {
+				removed = append(removed, id)
+			}
+		}
+		h.unlock(id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Close stops the janitor
+func (h *UploadHandler) Close() error {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+	h.wg.Wait()
+	return nil
+}
+
+// create creates an upload
+func (h *UploadHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get(UploadLengthHeader), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid "+UploadLengthHeader, http.StatusBadRequest)
+		return
+	}
+	if h.options.MaxSize > 0 && length > h.options.MaxSize {
+		http.Error(w, ErrUploadTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	upload := &Upload{Length: length}
+	if upload.Metadata, err = parseUploadMetadata(r.Header.Get(UploadMetadataHeader)); err == nil {
+		upload.Checksum, err = parseUploadChecksum(r.Header.Get(UploadChecksumHeader))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var uid *uuid.UUID
+	if uid, err = uuid.V4(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	upload.Id = uid.String()
+	upload.Created = time.Now()
+	upload.Expires = upload.Created.Add(h.options.Expiry)
+	var dir VFile
+	if dir, err = h.manager.MkdirAll(h.base.JoinPath(upload.Id)); err == nil {
+		ioutils.CloserFunc(dir)
+		err = h.save(upload)
+	}
+	// an empty upload is complete once created
+	status := http.StatusInternalServerError
+	if err == nil && length == 0 {
+		h.lock(upload.Id)
+		status, err = h.complete(upload)
+		h.unlock(upload.Id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.Id)
+	h.writeState(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head writes the offset of the upload
+func (h *UploadHandler) head(w http.ResponseWriter, r *http.Request) {
+	upload, err := h.Get(path.Base(r.URL.Path))
+	if err != nil {
+		w.WriteHeader(uploadErrorStatus(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(UploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	h.writeState(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch writes a chunk of the upload
+func (h *UploadHandler) patch(w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+	if r.Header.Get(rest.ContentTypeHeader) != UploadContentType {
+		http.Error(w, "the content type must be "+UploadContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid "+UploadOffsetHeader, http.StatusBadRequest)
+		return
+	}
+	if !validUploadId(id) {
+		http.Error(w, ErrUploadNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !h.lock(id) {
+		http.Error(w, ErrUploadLocked.Error(), http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+	var upload *Upload
+	if upload, err = h.Get(id); err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	if upload.Completed || offset != upload.Offset {
+		w.Header().Set(UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+		http.Error(w, fmt.Sprintf("%s: the offset is %d", ErrUploadOffset, upload.Offset), http.StatusConflict)
+		return
+	}
+	remaining := upload.Length - upload.Offset
+	if r.ContentLength > remaining {
+		http.Error(w, ErrUploadTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var written int64
+	status := http.StatusInternalServerError
+	written, err = h.writeChunk(upload, r.Body, remaining)
+	// the bytes received are kept when the client disconnects so that it can resume from them
+	if written > 0 {
+		upload.Chunks = append(upload.Chunks, upload.Offset)
+		upload.Offset += written
+		upload.Expires = time.Now().Add(h.options.Expiry)
+		if saveErr := h.save(upload); err == nil {
+			err = saveErr
+		}
+	}
+	if errors.Is(err, ErrUploadTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	if err == nil && upload.Offset == upload.Length {
+		status, err = h.complete(upload)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	h.writeState(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// delete removes the upload
+func (h *UploadHandler) delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.Remove(path.Base(r.URL.Path)); err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeChunk writes at most remaining bytes of the reader to a new chunk of the upload. ErrUploadTooLarge is
+// returned if the reader has more bytes, the chunk is then discarded.
+func (h *UploadHandler) writeChunk(upload *Upload, r io.Reader, remaining int64) (written int64, err error) {
+	chunkUrl := h.chunkUrl(upload.Id, upload.Offset)
+	var chunk VFile
+	if chunk, err = h.manager.Create(chunkUrl); err != nil {
+		return
+	}
+	written, err = io.Copy(chunk, io.LimitReader(r, remaining+1))
+	if closeErr := chunk.Close(); err == nil {
+		err = closeErr
+	}
+	if written > remaining {
+		written, err = 0, ErrUploadTooLarge
+	}
+	if written == 0 {
+		_ = h.manager.Delete(chunkUrl)
+	}
+	return
+}
+
+// complete joins the chunks of the upload computing its digest, verifies its checksum and promotes it. The upload
+// is removed if its checksum does not match.
+func (h *UploadHandler) complete(upload *Upload) (status int, err error) {
+	status = http.StatusInternalServerError
+	dataUrl := h.base.JoinPath(upload.Id, uploadDataFile)
+	if upload.Digest, err = h.join(upload, dataUrl); err != nil {
+		return
+	}
+	if upload.Checksum != "" && upload.Checksum != upload.Digest {
+		_ = h.manager.Delete(h.base.JoinPath(upload.Id))
+		status, err = StatusChecksumMismatch, fmt.Errorf("%w: %s", ErrUploadChecksum, upload.Id)
+		return
+	}
+	for _, offset := range upload.Chunks {
+		if err = h.manager.Delete(h.chunkUrl(upload.Id, offset)); err != nil {
+			return
+		}
+	}
+	upload.Chunks = nil
+	upload.Completed = true
+	if err = h.save(upload); err != nil {
+		return
+	}
+	fileUrl := dataUrl
+	if h.promote != nil {
+		fileUrl = h.promote.JoinPath(upload.Id)
+		if err = h.move(dataUrl, fileUrl); err != nil {
+			return
+		}
+		if err = h.manager.Delete(h.base.JoinPath(upload.Id)); err != nil {
+			return
+		}
+	}
+	if h.options.OnComplete != nil {
+		err = h.options.OnComplete(upload, fileUrl)
+	}
+	return
+}
+
+// join writes the chunks of the upload in order to the file and returns the digest of the content
+func (h *UploadHandler) join(upload *Upload, dataUrl *url.URL) (digest string, err error) {
+	var data VFile
+	if data, err = h.manager.Create(dataUrl); err != nil {
+		return
+	}
+	defer ioutils.CloserFunc(data)
+	readers := make([]io.Reader, 0, len(upload.Chunks))
+	for _, offset := range upload.Chunks {
+		var chunk VFile
+		if chunk, err = h.manager.Open(h.chunkUrl(upload.Id, offset)); err != nil {
+			return
+		}
+		defer ioutils.CloserFunc(chunk)
+		readers = append(readers, chunk)
+	}
+	digest, err = h.chksum.CalculateFor(io.TeeReader(io.MultiReader(readers...), data))
+	return
+}
+
+// move moves the file to the dst, it is copied through the manager when the dst is on another file system
+func (h *UploadHandler) move(src, dst *url.URL) (err error) {
+	if src.Scheme == dst.Scheme {
+		return h.manager.Move(src, dst)
+	}
+	var srcFile, dstFile VFile
+	if srcFile, err = h.manager.Open(src); err != nil {
+		return
+	}
+	defer ioutils.CloserFunc(srcFile)
+	if dstFile, err = h.manager.Create(dst); err != nil {
+		return
+	}
+	_, err = io.Copy(dstFile, srcFile)
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = h.manager.Delete(src)
+	}
+	return
+}
+
+// load reads the state of the upload
+func (h *UploadHandler) load(id string) (upload *Upload, err error) {
+	var f VFile
+	if f, err = h.manager.Open(h.base.JoinPath(id, uploadInfoFile)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+		}
+		return
+	}
+	defer ioutils.CloserFunc(f)
+	upload = &Upload{}
+	if err = json.NewDecoder(f).Decode(upload); err != nil {
+		upload = nil
+	}
+	return
+}
+
+// save writes the state of the upload to a temporary file which is moved once complete, so that the state read
+// along a write is never partial
+func (h *UploadHandler) save(upload *Upload) (err error) {
+	infoUrl := h.base.JoinPath(upload.Id, uploadInfoFile)
+	tmpUrl := h.base.JoinPath(upload.Id, uploadInfoFile+uploadTmpSuffix)
+	var f VFile
+	if f, err = h.manager.Create(tmpUrl); err != nil {
+		return
+	}
+	err = json.NewEncoder(f).Encode(upload)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = h.manager.Move(tmpUrl, infoUrl)
+	}
+	return
+}
+
+// expired checks if the upload expired. An upload without a readable state expires after the expiry from the
+// modification time of its directory.
+func (h *UploadHandler) expired(id string, now time.Time) bool {
+	if upload, err := h.load(id); err == nil {
+		return now.After(upload.Expires)
+	}
+	dir, err := h.manager.Open(h.base.JoinPath(id))
+	if err != nil {
+		return false
+	}
+	defer ioutils.CloserFunc(dir)
+	info, err := dir.Info()
+	return err == nil && now.After(info.ModTime().Add(h.options.Expiry))
+}
+
+// janitor removes the expired uploads at the interval until the handler is closed
+func (h *UploadHandler) janitor() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.options.JanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			_, _ = h.Cleanup(context.Background())
+		}
+	}
+}
+
+// lock marks the upload as being written, false is returned if it already is
+func (h *UploadHandler) lock(id string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.writing[id] {
+		return false
+	}
+	h.writing[id] = true
+	return true
+}
+
+func (h *UploadHandler) unlock(id string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.writing, id)
+}
+
+// writeState writes the offset and the expiry of the upload to the header
+func (h *UploadHandler) writeState(w http.ResponseWriter, upload *Upload) {
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(UploadExpiresHeader, upload.Expires.UTC().Format(http.TimeFormat))
+}
+
+// chunkUrl returns the url of the chunk at the offset, named so that the chunks are listed in order
+func (h *UploadHandler) chunkUrl(id string, offset int64) *url.URL {
+	return h.base.JoinPath(id, fmt.Sprintf("%s%020d", uploadChunkPrefix, offset))
+}
+
+func validUploadId(id string) bool {
+	if len(id) != uploadIdLength {
+		return false
+	}
+	_, err := uuid.ParseUUID(id)
+	return err == nil
+}
+
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUploadExpired):
+		return http.StatusGone
+	case errors.Is(err, ErrUploadLocked):
+		return http.StatusLocked
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseUploadMetadata parses the Upload-Metadata header, comma separated keys followed by a space and the base64
+// encoded value, the value may be omitted
+func parseUploadMetadata(header string) (metadata map[string]string, err error) {
+	if strings.TrimSpace(header) == "" {
+		return
+	}
+	metadata = make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("invalid %s %q", UploadMetadataHeader, header)
+		}
+		var decoded []byte
+		if decoded, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("invalid %s value of %s: %v", UploadMetadataHeader, key, err)
+		}
+		metadata[key] = string(decoded)
+	}
+	return
+}
+
+// parseUploadChecksum parses the Upload-Checksum header, the algorithm followed by a space and the base64 encoded
+// digest, and returns the hex encoded digest. Only sha256 is supported.
+func parseUploadChecksum(header string) (checksum string, err error) {
+	if header == "" {
+		return
+	}
+	algorithm, value, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(algorithm, uploadChecksumSha256) {
+		err = fmt.Errorf("unsupported checksum algorithm %s", algorithm)
+		return
+	}
+	var digest []byte
+	if digest, err = base64.StdEncoding.DecodeString(value); err != nil || len(digest) != blobDigestLength/2 {
+		err = fmt.Errorf("invalid %s %q", UploadChecksumHeader, header)
+		return
+	}
+	checksum = hex.EncodeToString(digest)
+	return
+}
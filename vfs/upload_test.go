@@ -0,0 +1,326 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/rest"
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// uploadClient drives the protocol against a httptest server of the handler mounted at /files
+type uploadClient struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func newUploadServer(t *testing.T, m Manager, base string, opts UploadOptions) (*UploadHandler, *uploadClient) {
+	if opts.JanitorInterval == 0 {
+		opts.JanitorInterval = -1
+	}
+	h, err := NewResumableUploadHandler(m, base, opts)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+	mux := http.NewServeMux()
+	mux.Handle("/files", h)
+	mux.Handle("/files/", h)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return h, &uploadClient{t: t, server: server}
+}
+
+func (c *uploadClient) do(method, location string, header map[string]string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(method, c.server.URL+location, body)
+	assert.NoError(c.t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := c.server.Client().Do(req)
+	assert.NoError(c.t, err)
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+	return res
+}
+
+// create creates an upload and returns its location
+func (c *uploadClient) create(length int, header map[string]string) string {
+	if header == nil {
+		header = map[string]string{}
+	}
+	header[UploadLengthHeader] = strconv.Itoa(length)
+	res := c.do(http.MethodPost, "/files", header, nil)
+	assert.Equal(c.t, http.StatusCreated, res.StatusCode)
+	return res.Header.Get("Location")
+}
+
+func (c *uploadClient) patch(location string, offset int, chunk string) *http.Response {
+	return c.do(http.MethodPatch, location, map[string]string{
+		rest.ContentTypeHeader: UploadContentType,
+		UploadOffsetHeader:     strconv.Itoa(offset),
+	}, strings.NewReader(chunk))
+}
+
+func (c *uploadClient) offset(location string) (status int, offset string) {
+	res := c.do(http.MethodHead, location, nil, nil)
+	return res.StatusCode, res.Header.Get(UploadOffsetHeader)
+}
+
+func uploadChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func readUpload(t *testing.T, m Manager, u *url.URL) string {
+	f, err := m.Open(u)
+	assert.NoError(t, err)
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func TestUploadHandler_Protocol(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			var completed *Upload
+			var file *url.URL
+			_, c := newUploadServer(t, m, base+"/uploads", UploadOptions{
+				OnComplete: func(upload *Upload, u *url.URL) error {
+					completed, file = upload, u
+					return nil
+				},
+			})
+			content := "hello resumable world"
+			location := c.create(len(content), map[string]string{
+				UploadMetadataHeader: "filename " + base64.StdEncoding.EncodeToString([]byte("hello.txt")) + ",draft",
+				UploadChecksumHeader: uploadChecksum(content),
+			})
+			assert.True(t, strings.HasPrefix(location, "/files/"))
+			status, offset := c.offset(location)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, "0", offset)
+
+			res := c.patch(location, 0, content[:6])
+			assert.Equal(t, http.StatusNoContent, res.StatusCode)
+			assert.Equal(t, "6", res.Header.Get(UploadOffsetHeader))
+			// a chunk sent again or ahead of the offset is rejected with the offset to resume from
+			for _, at := range []int{0, 10} {
+				res = c.patch(location, at, content[at:])
+				assert.Equal(t, http.StatusConflict, res.StatusCode)
+				assert.Equal(t, "6", res.Header.Get(UploadOffsetHeader))
+			}
+			res = c.patch(location, 6, content[6:12])
+			assert.Equal(t, http.StatusNoContent, res.StatusCode)
+			assert.True(t, completed == nil)
+			res = c.patch(location, 12, content[12:])
+			assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+			assert.NotNil(t, completed)
+			assert.Equal(t, map[string]string{"filename": "hello.txt", "draft": ""}, completed.Metadata)
+			assert.Equal(t, completed.Checksum, completed.Digest)
+			assert.Equal(t, content, readUpload(t, m, file))
+			// the chunks are removed once joined
+			files, err := m.List(file.JoinPath(".."))
+			assert.NoError(t, err)
+			assert.Equal(t, 2, len(files))
+			status, offset = c.offset(location)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, strconv.Itoa(len(content)), offset)
+			assert.Equal(t, http.StatusConflict, c.patch(location, len(content), "more").StatusCode)
+
+			assert.Equal(t, http.StatusNoContent, c.do(http.MethodDelete, location, nil, nil).StatusCode)
+			status, _ = c.offset(location)
+			assert.Equal(t, http.StatusNotFound, status)
+		})
+	}
+}
+
+func TestUploadHandler_Resume(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	h, c := newUploadServer(t, m, backends["mem"], UploadOptions{})
+	content := strings.Repeat("0123456789", 10)
+	location := c.create(len(content), map[string]string{UploadChecksumHeader: uploadChecksum(content)})
+
+	// the client disconnects after sending 42 bytes of the chunk
+	body := io.MultiReader(strings.NewReader(content[:42]), iotestErrReader{})
+	req := httptest.NewRequest(http.MethodPatch, location, body)
+	req.Header.Set(rest.ContentTypeHeader, UploadContentType)
+	req.Header.Set(UploadOffsetHeader, "0")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	status, offset := c.offset(location)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "42", offset)
+	assert.Equal(t, http.StatusNoContent, c.patch(location, 42, content[42:]).StatusCode)
+	upload, err := h.Get(location[len("/files/"):])
+	assert.NoError(t, err)
+	assert.True(t, upload.Completed)
+	assert.Equal(t, content, readUpload(t, m, h.base.JoinPath(upload.Id, uploadDataFile)))
+}
+
+// iotestErrReader fails every read as a connection reset
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestUploadHandler_Expiry(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			h, c := newUploadServer(t, m, base+"/expiry", UploadOptions{Expiry: 100 * time.Millisecond})
+			abandoned := c.create(10, nil)
+			assert.Equal(t, http.StatusNoContent, c.patch(abandoned, 0, "01234").StatusCode)
+			time.Sleep(60 * time.Millisecond)
+			active := c.create(10, nil)
+			time.Sleep(60 * time.Millisecond)
+
+			status, _ := c.offset(abandoned)
+			assert.Equal(t, http.StatusGone, status)
+			assert.Equal(t, http.StatusGone, c.patch(abandoned, 5, "56789").StatusCode)
+			removed, err := h.Cleanup(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, []string{abandoned[len("/files/"):]}, removed)
+			status, _ = c.offset(abandoned)
+			assert.Equal(t, http.StatusNotFound, status)
+			// the chunks extend the expiry
+			assert.Equal(t, http.StatusNoContent, c.patch(active, 0, "01234").StatusCode)
+			status, offset := c.offset(active)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, "5", offset)
+		})
+	}
+}
+
+func TestUploadHandler_Janitor(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	_, c := newUploadServer(t, m, backends["mem"], UploadOptions{
+		Expiry:          20 * time.Millisecond,
+		JanitorInterval: 10 * time.Millisecond,
+	})
+	location := c.create(10, nil)
+	time.Sleep(100 * time.Millisecond)
+	status, _ := c.offset(location)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestUploadHandler_ChecksumMismatch(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			_, c := newUploadServer(t, m, base+"/checksum", UploadOptions{
+				OnComplete: func(*Upload, *url.URL) error {
+					called = true
+					return nil
+				},
+			})
+			location := c.create(8, map[string]string{UploadChecksumHeader: uploadChecksum("expected")})
+			assert.Equal(t, http.StatusNoContent, c.patch(location, 0, "corr").StatusCode)
+			assert.Equal(t, StatusChecksumMismatch, c.patch(location, 4, "upt!").StatusCode)
+			assert.False(t, called)
+			status, _ := c.offset(location)
+			assert.Equal(t, http.StatusNotFound, status)
+
+			res := c.do(http.MethodPost, "/files", map[string]string{
+				UploadLengthHeader:   "8",
+				UploadChecksumHeader: "md5 AAAA",
+			}, nil)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		})
+	}
+}
+
+func TestUploadHandler_ConcurrentPatch(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	h, c := newUploadServer(t, m, backends["mem"], UploadOptions{})
+	location := c.create(10, nil)
+	id := location[len("/files/"):]
+
+	pr, pw := io.Pipe()
+	first := make(chan int)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPatch, c.server.URL+location, pr)
+		req.Header.Set(rest.ContentTypeHeader, UploadContentType)
+		req.Header.Set(UploadOffsetHeader, "0")
+		res, err := c.server.Client().Do(req)
+		if err != nil {
+			first <- 0
+			return
+		}
+		_ = res.Body.Close()
+		first <- res.StatusCode
+	}()
+	_, err := pw.Write([]byte("01234"))
+	assert.NoError(t, err)
+	// the first chunk holds the upload until its body is closed
+	deadline := time.Now().Add(5 * time.Second)
+	for !h.writingUpload(id) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	res := c.patch(location, 0, "abcde")
+	assert.Equal(t, http.StatusLocked, res.StatusCode)
+	assert.Equal(t, http.StatusLocked, c.do(http.MethodDelete, location, nil, nil).StatusCode)
+	_ = pw.Close()
+	assert.Equal(t, http.StatusNoContent, <-first)
+	status, offset := c.offset(location)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "5", offset)
+}
+
+// writingUpload checks if a chunk of the upload is being written
+func (h *UploadHandler) writingUpload(id string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.writing[id]
+}
+
+func TestUploadHandler_Limits(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	_, c := newUploadServer(t, m, backends["mem"], UploadOptions{MaxSize: 8})
+	res := c.do(http.MethodPost, "/files", map[string]string{UploadLengthHeader: "9"}, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	location := c.create(4, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, c.patch(location, 0, "01234").StatusCode)
+	res = c.do(http.MethodPatch, location, map[string]string{UploadOffsetHeader: "0"}, strings.NewReader("0123"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+	status, _ := c.offset("/files/not-an-upload")
+	assert.Equal(t, http.StatusNotFound, status)
+	res = c.do(http.MethodOptions, "/files", nil, nil)
+	assert.Equal(t, "8", res.Header.Get(uploadMaxSizeHeader))
+}
+
+func TestUploadHandler_Promote(t *testing.T) {
+	m, backends := blobStoreBackends(t)
+	var file *url.URL
+	// the uploads are stored on the mem file system and promoted to the local one
+	h, c := newUploadServer(t, m, backends["mem"]+"/staging", UploadOptions{
+		PromoteTo: backends["file"] + "/permanent",
+		OnComplete: func(upload *Upload, u *url.URL) error {
+			file = u
+			return nil
+		},
+	})
+	location := c.create(5, nil)
+	assert.Equal(t, http.StatusNoContent, c.patch(location, 0, "hello").StatusCode)
+	assert.Equal(t, backends["file"]+"/permanent"+location[len("/files"):], file.String())
+	assert.Equal(t, "hello", readUpload(t, m, file))
+	_, err := h.Get(location[len("/files/"):])
+	assert.True(t, errors.Is(err, ErrUploadNotFound))
+
+	// an empty upload completes once created
+	location = c.create(0, nil)
+	assert.Equal(t, "", readUpload(t, m, file.JoinPath("..", location[len("/files/"):])))
+}
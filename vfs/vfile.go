@@ -1,6 +1,7 @@
 package vfs
 
 import (
+	"context"
 	"io"
 	"io/fs"
 	"net/url"
@@ -16,6 +17,11 @@ type VFile interface {
 	VFileContent
 	//ListAll children of this file instance. can be nil in case of file object instead of directory
 	ListAll() ([]VFile, error)
+	//List the children of this file instance that pass the filter, see the filters such as ByExtension
+	List(filter FileFilter) ([]VFile, error)
+	//Watch the changes of the file, and of its children for a directory, until the context is done. The channel is
+	//closed when the context is done, see the WatchOption for the configuration.
+	Watch(ctx context.Context, opts ...WatchOption) (<-chan Event, error)
 	//Delete the file object. If the file type is directory all files and subdirectories will be deleted
 	Delete() error
 	//DeleteAll deletes all the files and it's subdirectories
@@ -164,6 +164,19 @@ func (fs *fileSystems) Move(src, dst *url.URL) (err error) {
 	return
 }
 
+// MoveAtomic delegates to the MoveAtomic of the file system of the src, ErrNotAtomic if it does not support it.
+func (fs *fileSystems) MoveAtomic(src, dst *url.URL) (err error) {
+	var vfs VFileSystem
+	if vfs, err = fs.getFsFor(src); err == nil {
+		if mover, ok := vfs.(AtomicMover); ok {
+			err = mover.MoveAtomic(src, dst)
+		} else {
+			err = ErrNotAtomic
+		}
+	}
+	return
+}
+
 func (fs *fileSystems) MoveRaw(src, dst string) (err error) {
 	var srcUrl, dstUrl *url.URL
 	srcUrl, err = url.Parse(src)
@@ -0,0 +1,263 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const (
+	defaultWatchInterval = time.Second
+	defaultWatchBuffer   = 64
+)
+
+// EventType is the type of change of an Event
+type EventType int
+
+const (
+	// EventCreate is a file or directory created
+	EventCreate EventType = iota
+	// EventModify is a file whose content changed
+	EventModify
+	// EventDelete is a file or directory deleted
+	EventDelete
+	// EventRename is a file or directory moved to the Url of the event from its From url
+	EventRename
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreate:
+		return "create"
+	case EventModify:
+		return "modify"
+	case EventDelete:
+		return "delete"
+	case EventRename:
+		return "rename"
+	}
+	return "unknown"
+}
+
+// Event is a change of a watched file
+type Event struct {
+	// Url of the file changed
+	Url *url.URL
+	// From is the url the file was moved from with EventRename
+	From *url.URL
+	// Type of the change
+	Type EventType
+	// Time the change was detected
+	Time time.Time
+}
+
+// WatchOption configures VFile.Watch
+type WatchOption func(cfg *watchConfig)
+
+// watchConfig holds the configuration of a watch
+type watchConfig struct {
+	interval  time.Duration
+	recursive bool
+	hash      bool
+	buffer    int
+}
+
+// WithWatchInterval sets the interval between two polls of the file systems watched by polling. Defaults to 1s.
+func WithWatchInterval(interval time.Duration) WatchOption {
+	return func(cfg *watchConfig) {
+		if interval > 0 {
+			cfg.interval = interval
+		}
+	}
+}
+
+// WithRecursive watches all the files under a directory instead of its immediate children
+func WithRecursive() WatchOption {
+	return func(cfg *watchConfig) {
+		cfg.recursive = true
+	}
+}
+
+// WithContentHash detects the modified files by hashing their content instead of comparing their modification time
+// and size, when watching by polling. It catches the changes keeping both, at the cost of reading every file on
+// each poll.
+func WithContentHash() WatchOption {
+	return func(cfg *watchConfig) {
+		cfg.hash = true
+	}
+}
+
+// WithWatchBuffer sets the size of the buffer of the channel of the events. Defaults to 64.
+func WithWatchBuffer(size int) WatchOption {
+	return func(cfg *watchConfig) {
+		if size >= 0 {
+			cfg.buffer = size
+		}
+	}
+}
+
+func newWatchConfig(opts []WatchOption) *watchConfig {
+	cfg := &watchConfig{interval: defaultWatchInterval, buffer: defaultWatchBuffer}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// watchState is the state of a file compared between two polls
+type watchState struct {
+	u       *url.URL
+	dir     bool
+	size    int64
+	modTime time.Time
+	hash    uint64
+}
+
+// watchSnapshot returns the state of the watched files by url
+type watchSnapshot func() (map[string]*watchState, error)
+
+// Watch watches the file by polling with ListAll and Info. The file systems able to report the changes override it.
+func (b *BaseFile) Watch(ctx context.Context, opts ...WatchOption) (<-chan Event, error) {
+	cfg := newWatchConfig(opts)
+	return pollWatch(ctx, cfg, func() (map[string]*watchState, error) {
+		return snapshotFile(b.VFile, cfg)
+	})
+}
+
+// pollWatch takes a snapshot every interval of the config and sends the differences with the previous one on the
+// channel, until the context is done. The first snapshot is taken before returning, its error is returned.
+func pollWatch(ctx context.Context, cfg *watchConfig, snapshot watchSnapshot) (<-chan Event, error) {
+	prev, err := snapshot()
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event, cfg.buffer)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(cfg.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := snapshot()
+			if errors.Is(err, fs.ErrNotExist) {
+				current, err = map[string]*watchState{}, nil
+			}
+			if err != nil {
+				// the next poll reports the changes in between
+				continue
+			}
+			for _, event := range diffSnapshots(prev, current) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = current
+		}
+	}()
+	return events, nil
+}
+
+// diffSnapshots returns the events of the changes between two snapshots, the deletions first with the children
+// before their directory, then the creations and modifications sorted by url
+func diffSnapshots(prev, current map[string]*watchState) (events []Event) {
+	now := time.Now()
+	var deleted, keys []string
+	for key := range prev {
+		if _, ok := current[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	for key := range current {
+		keys = append(keys, key)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(deleted)))
+	sort.Strings(keys)
+	for _, key := range deleted {
+		events = append(events, Event{Url: prev[key].u, Type: EventDelete, Time: now})
+	}
+	for _, key := range keys {
+		before, after := prev[key], current[key]
+		switch {
+		case before == nil || before.dir != after.dir:
+			events = append(events, Event{Url: after.u, Type: EventCreate, Time: now})
+		case !after.dir && (before.size != after.size || !before.modTime.Equal(after.modTime) ||
+			before.hash != after.hash):
+			events = append(events, Event{Url: after.u, Type: EventModify, Time: now})
+		}
+	}
+	return
+}
+
+// snapshotFile returns the state of the file and of its children, of all the files under it when recursive
+func snapshotFile(file VFile, cfg *watchConfig) (states map[string]*watchState, err error) {
+	states = map[string]*watchState{}
+	var info VFileInfo
+	if info, err = file.Info(); err == nil {
+		err = snapshotTree(file, info, cfg, states, true)
+	}
+	return
+}
+
+// snapshotTree adds the state of the file, and of its children when listed, to the states
+func snapshotTree(file VFile, info VFileInfo, cfg *watchConfig, states map[string]*watchState, list bool) (err error) {
+	u := *file.Url()
+	state := &watchState{u: &u, dir: info.IsDir()}
+	if !state.dir {
+		state.size, state.modTime = info.Size(), info.ModTime()
+		if cfg.hash {
+			if state.hash, err = hashContent(file); err != nil {
+				return
+			}
+		}
+	}
+	states[u.String()] = state
+	if !state.dir || !list {
+		return
+	}
+	var children []VFile
+	if children, err = file.ListAll(); err != nil {
+		return
+	}
+	defer func() {
+		for _, child := range children {
+			_ = child.Close()
+		}
+	}()
+	for _, child := range children {
+		var childInfo VFileInfo
+		if childInfo, err = child.Info(); err == nil {
+			err = snapshotTree(child, childInfo, cfg, states, cfg.recursive)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted while listed
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// hashContent returns the hash of the content of the file read from its start
+func hashContent(file VFile) (sum uint64, err error) {
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	h := fnv.New64a()
+	if _, err = io.Copy(h, file); err == nil {
+		sum = h.Sum64()
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"oss.nandlabs.io/golly/testing/assert"
+)
+
+// nextEvent returns the type and the path relative to the root of the next event, failing after a second
+func nextEvent(t *testing.T, events <-chan Event, root string) string {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events closed")
+		}
+		rel := event.Type.String() + " " + strings.TrimPrefix(event.Url.Path, mustParse(root).Path)
+		if event.From != nil {
+			rel += " from " + strings.TrimPrefix(event.From.Path, mustParse(root).Path)
+		}
+		return rel
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	return ""
+}
+
+// collectEvents returns the events sent until none is sent within the wait, as returned by nextEvent. The
+// consecutive events of a file are reported once, as the polling may merge them.
+func collectEvents(t *testing.T, events <-chan Event, root string, wait time.Duration) (collected []string) {
+	t.Helper()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			rel := event.Type.String() + " " + strings.TrimPrefix(event.Url.Path, mustParse(root).Path)
+			if len(collected) == 0 || collected[len(collected)-1] != rel {
+				collected = append(collected, rel)
+			}
+		case <-time.After(wait):
+			return
+		}
+	}
+}
+
+// writeFile creates or replaces the file at the url with the content
+func writeFile(t *testing.T, m Manager, raw, content string) {
+	f, err := m.CreateRaw(raw)
+	assert.NoError(t, err)
+	_, err = f.WriteString(content)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func TestWatch(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://watch/root", "file://" + t.TempDir() + "/root"} {
+		_, err := m.MkdirAllRaw(root + "/sub")
+		assert.NoError(t, err)
+		dir, err := m.OpenRaw(root)
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := dir.Watch(ctx, WithWatchInterval(10*time.Millisecond))
+		assert.NoError(t, err)
+
+		writeFile(t, m, root+"/a.txt", "")
+		assert.Equal(t, "create /a.txt", nextEvent(t, events, root))
+		writeFile(t, m, root+"/a.txt", "changed")
+		assert.Equal(t, "modify /a.txt", nextEvent(t, events, root))
+		writeFile(t, m, root+"/a.txt", "changed again")
+		assert.Equal(t, []string{"modify /a.txt"}, collectEvents(t, events, root, 50*time.Millisecond))
+		// the files under the children are not watched
+		writeFile(t, m, root+"/sub/b.txt", "b")
+		assert.Equal(t, []string(nil), collectEvents(t, events, root, 50*time.Millisecond))
+		assert.NoError(t, m.DeleteRaw(root+"/a.txt"))
+		assert.Equal(t, "delete /a.txt", nextEvent(t, events, root))
+
+		cancel()
+		for range events {
+		}
+		assert.NoError(t, dir.Close())
+	}
+}
+
+func TestWatch_Recursive(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://recursive/root", "file://" + t.TempDir() + "/root"} {
+		_, err := m.MkdirAllRaw(root)
+		assert.NoError(t, err)
+		dir, err := m.OpenRaw(root)
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := dir.Watch(ctx, WithRecursive(), WithWatchInterval(10*time.Millisecond))
+		assert.NoError(t, err)
+
+		_, err = m.MkdirAllRaw(root + "/a/b")
+		assert.NoError(t, err)
+		assert.Equal(t, "create /a", nextEvent(t, events, root))
+		assert.Equal(t, "create /a/b", nextEvent(t, events, root))
+		writeFile(t, m, root+"/a/b/c.txt", "")
+		assert.Equal(t, "create /a/b/c.txt", nextEvent(t, events, root))
+		assert.NoError(t, m.DeleteRaw(root+"/a/b"))
+		assert.Equal(t, "delete /a/b/c.txt", nextEvent(t, events, root))
+		assert.Equal(t, "delete /a/b", nextEvent(t, events, root))
+		cancel()
+		assert.NoError(t, dir.Close())
+	}
+}
+
+func TestWatch_MemRename(t *testing.T) {
+	m := newTreeManager()
+	writeTree(t, m, "mem://rename/root")
+	dir, err := m.OpenRaw("mem://rename/root/b")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := dir.Watch(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.MoveRaw("mem://rename/root/b/c.txt", "mem://rename/root/c.txt"))
+	assert.Equal(t, "rename /c.txt from /b/c.txt", nextEvent(t, events, "mem://rename/root"))
+	assert.NoError(t, m.MoveRaw("mem://rename/root/a.txt", "mem://rename/root/b/a.txt"))
+	assert.Equal(t, "rename /b/a.txt from /a.txt", nextEvent(t, events, "mem://rename/root"))
+}
+
+func TestWatch_ContentHash(t *testing.T) {
+	m := newTreeManager()
+	root := "file://" + t.TempDir()
+	name := filepath.Join(mustParse(root).Path, "a.txt")
+	writeFile(t, m, root+"/a.txt", "before")
+	modTime := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(name, modTime, modTime))
+	file, err := m.OpenRaw(root + "/a.txt")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bySize, err := file.Watch(ctx, WithWatchInterval(10*time.Millisecond))
+	assert.NoError(t, err)
+	byHash, err := file.Watch(ctx, WithWatchInterval(10*time.Millisecond), WithContentHash())
+	assert.NoError(t, err)
+
+	// same size and modification time
+	assert.NoError(t, os.WriteFile(name, []byte("after!"), 0o644))
+	assert.NoError(t, os.Chtimes(name, modTime, modTime))
+	assert.Equal(t, "modify /a.txt", nextEvent(t, byHash, root))
+	assert.Equal(t, []string(nil), collectEvents(t, bySize, root, 50*time.Millisecond))
+
+	// the deletion of the watched file itself
+	assert.NoError(t, os.Remove(name))
+	assert.Equal(t, "delete /a.txt", nextEvent(t, bySize, root))
+}
+
+func TestWatch_Closed(t *testing.T) {
+	m := newTreeManager()
+	for _, root := range []string{"mem://closed/root", "file://" + t.TempDir() + "/root"} {
+		dir, err := m.MkdirAllRaw(root)
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := dir.Watch(ctx, WithWatchInterval(10*time.Millisecond))
+		assert.NoError(t, err)
+		cancel()
+		select {
+		case _, ok := <-events:
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("events not closed")
+		}
+		assert.NoError(t, dir.DeleteAll())
+		_, err = dir.Watch(context.Background())
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+		assert.NoError(t, dir.Close())
+	}
+}